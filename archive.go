@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// openArchiveDB connects to the database at DATABASE_URL and brings its
+// schema up to date, so an archive can be imported into a new database
+// service. It returns the cipher of the database's encrypted columns too.
+func openArchiveDB() (*sql.DB, *db.ColumnCipher, error) {
+	url := os.Getenv(helpers.DatabaseURLEnvVar)
+	if url == "" {
+		return nil, nil, errors.New(helpers.DatabaseURLEnvVar + " is not set")
+	}
+	cipher, err := db.ParseColumnCipher(os.Getenv(helpers.DBEncryptionKeyEnvVar),
+		os.Getenv(helpers.DBEncryptionPreviousKeysEnvVar))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid database encryption keys: %v", err)
+	}
+	conn, err := db.Open(url, db.DefaultPoolOptions())
+	return conn, cipher, err
+}
+
+// runExport implements the export subcommand. It writes all the dashboard's
+// own data in the database at DATABASE_URL as an archive.
+func runExport(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := flags.String("out", "", "file to write the archive to, instead of the standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	conn, cipher, err := openArchiveDB()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	archive, err := db.Export(conn, cipher)
+	if err != nil {
+		return err
+	}
+
+	w := stdout
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(archive); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %v\n", archive.Summary())
+	return nil
+}
+
+// runImport implements the import subcommand. It replaces all the
+// dashboard's own data in the database at DATABASE_URL with an archive.
+func runImport(args []string, stdin io.Reader) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	in := flags.String("in", "", "file to read the archive from, instead of the standard input")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	r := stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var archive db.Archive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return fmt.Errorf("could not read the archive: %v", err)
+	}
+
+	conn, cipher, err := openArchiveDB()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := db.Import(conn, archive, cipher); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %v\n", archive.Summary())
+	return nil
+}
+
+// archiveMain runs the export or import subcommand and exits.
+func archiveMain(subcommand string, args []string) {
+	var err error
+	if subcommand == "export" {
+		err = runExport(args, os.Stdout)
+	} else {
+		err = runImport(args, os.Stdin)
+	}
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
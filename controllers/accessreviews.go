@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+// AccessReviewContext stores the session info and access token per user.
+// All routes within AccessReviewContext let org managers review who has
+// access to their orgs.
+type AccessReviewContext struct {
+	*SecureContext // Required.
+}
+
+// accessReviewDecisionsBody is the body of the decisions on members of an
+// org under review.
+type accessReviewDecisionsBody struct {
+	Decisions []db.AccessReviewDecision `json:"decisions"`
+}
+
+// writeAccessReview responds with the access review.
+func writeAccessReview(rw http.ResponseWriter, status int, review interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(review)
+}
+
+// reviewForManager gets the review of the path and checks the user manages
+// its org. It responds with an error and returns false otherwise.
+func (c *AccessReviewContext) reviewForManager(rw web.ResponseWriter, req *web.Request) (db.AccessReview, bool) {
+	review, err := c.Settings.AccessReviewStore.AccessReview(req.PathParams["id"])
+	if err == db.ErrAccessReviewNotFound {
+		newUaaError(http.StatusNotFound, err.Error()+".").writeTo(rw)
+		return review, false
+	}
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return review, false
+	}
+	if ok, uaaErr := c.managesOrg(review.OrgGUID); uaaErr != nil {
+		uaaErr.writeTo(rw)
+		return review, false
+	} else if !ok {
+		newUaaError(http.StatusForbidden, "only org managers can review the org's access.").writeTo(rw)
+		return review, false
+	}
+	return review, true
+}
+
+// List returns the access reviews of the org of ?org_guid=, which the user
+// manages, newest first. ?status= filters them.
+func (c *AccessReviewContext) List(rw web.ResponseWriter, req *web.Request) {
+	query := req.URL.Query()
+	filter := db.AccessReviewFilter{OrgGUID: query.Get("org_guid"), Status: query.Get("status")}
+	if filter.OrgGUID == "" {
+		newUaaError(http.StatusBadRequest, "org_guid is required.").writeTo(rw)
+		return
+	}
+	if ok, uaaErr := c.managesOrg(filter.OrgGUID); uaaErr != nil {
+		uaaErr.writeTo(rw)
+		return
+	} else if !ok {
+		newUaaError(http.StatusForbidden, "only org managers can review the org's access.").writeTo(rw)
+		return
+	}
+	reviews, err := c.Settings.AccessReviewStore.AccessReviews(filter)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	writeAccessReview(rw, http.StatusOK, reviews)
+}
+
+// Get returns a review of an org the user manages.
+func (c *AccessReviewContext) Get(rw web.ResponseWriter, req *web.Request) {
+	review, ok := c.reviewForManager(rw, req)
+	if !ok {
+		return
+	}
+	writeAccessReview(rw, http.StatusOK, review)
+}
+
+// Report returns the summary of a review of an org the user manages.
+func (c *AccessReviewContext) Report(rw web.ResponseWriter, req *web.Request) {
+	review, ok := c.reviewForManager(rw, req)
+	if !ok {
+		return
+	}
+	writeAccessReview(rw, http.StatusOK, helpers.NewAccessReviewReport(review.Campaign, []db.AccessReview{review}, time.Now()))
+}
+
+// Decide records the manager's decisions on members of the org. The org
+// roles of the members being revoked are revoked first, with the manager's
+// own credentials, and only the decisions that could be carried out are
+// recorded. The review is completed once every member is decided on.
+func (c *AccessReviewContext) Decide(rw web.ResponseWriter, req *web.Request) {
+	var body accessReviewDecisionsBody
+	if err := readBodyToStruct(req.Body, &body); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	review, ok := c.reviewForManager(rw, req)
+	if !ok {
+		return
+	}
+	if review.Status != db.AccessReviewOpen {
+		newUaaError(http.StatusConflict, db.ErrAccessReviewCompleted.Error()+".").writeTo(rw)
+		return
+	}
+	if err := review.Validate(body.Decisions); err != nil {
+		newUaaError(http.StatusBadRequest, err.Error()).writeTo(rw)
+		return
+	}
+	var (
+		done     []db.AccessReviewDecision
+		failures []string
+	)
+	for _, d := range body.Decisions {
+		if d.Decision == db.AccessRevoke {
+			if err := c.revokeOrgRoles(review, d.UserID); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", d.UserID, err))
+				continue
+			}
+		}
+		done = append(done, d)
+	}
+	if len(done) > 0 {
+		var err error
+		review, err = c.Settings.AccessReviewStore.DecideAccessReview(review.ID, done, c.userID())
+		if err == db.ErrAccessReviewCompleted {
+			newUaaError(http.StatusConflict, err.Error()+".").writeTo(rw)
+			return
+		}
+		if err != nil {
+			newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+			return
+		}
+		c.Settings.RecordAuditEvent(req.Request, c.userID(), "decide_access_review", struct {
+			ReviewID  string                    `json:"review_id"`
+			OrgGUID   string                    `json:"org_guid"`
+			Decisions []db.AccessReviewDecision `json:"decisions"`
+		}{review.ID, review.OrgGUID, done})
+		if review.Status == db.AccessReviewCompleted {
+			c.Settings.RecordAuditEvent(req.Request, c.userID(), "complete_access_review",
+				helpers.NewAccessReviewReport(review.Campaign, []db.AccessReview{review}, time.Now()))
+		}
+	}
+	if len(failures) > 0 {
+		newUaaError(http.StatusBadGateway, "unable to revoke the roles of "+strings.Join(failures, "; ")).writeTo(rw)
+		return
+	}
+	writeAccessReview(rw, http.StatusOK, review)
+}
+
+// revokeOrgRoles removes the user from the org: their other org roles
+// first, since the CF API keeps org users who have them, then the user
+// along with their space roles.
+func (c *AccessReviewContext) revokeOrgRoles(review db.AccessReview, userID string) error {
+	org := "/v2/organizations/" + url.PathEscape(review.OrgGUID)
+	user := "/" + url.PathEscape(userID)
+	for _, role := range []string{"managers", "billing_managers", "auditors"} {
+		if err := c.ccRequest("DELETE", org+"/"+role+user, nil, nil); err != nil && !isCCNotFound(err) {
+			return err
+		}
+	}
+	if err := c.ccRequest("DELETE", org+"/users"+user+"?recursive=true", nil, nil); err != nil && !isCCNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// StartAccessReviewCampaign starts a campaign reviewing every org now, as a
+// job, without waiting for the scheduled one.
+func (c *AdminContext) StartAccessReviewCampaign(rw web.ResponseWriter, req *web.Request) {
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "start_access_review_campaign", nil)
+	c.submitJob(rw, "access-review-campaign", []jobs.Task{{
+		Name: "review the orgs",
+		Run: func() error {
+			_, err := c.Settings.AccessReviews.StartCampaign(time.Now())
+			return err
+		},
+	}})
+}
+
+// AccessReviewReport returns the completion report of the campaign of
+// ?campaign=, or of the latest campaign.
+func (c *AdminContext) AccessReviewReport(rw web.ResponseWriter, req *web.Request) {
+	campaign := req.URL.Query().Get("campaign")
+	if campaign == "" {
+		latest, err := c.Settings.AccessReviewStore.AccessReviews(db.AccessReviewFilter{Limit: 1})
+		if err != nil {
+			newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+			return
+		}
+		if len(latest) == 0 {
+			newUaaError(http.StatusNotFound, "no access review campaign was started.").writeTo(rw)
+			return
+		}
+		campaign = latest[0].Campaign
+	}
+	reviews, err := c.Settings.AccessReviewStore.AccessReviews(db.AccessReviewFilter{Campaign: campaign})
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	writeAccessReview(rw, http.StatusOK, helpers.NewAccessReviewReport(campaign, reviews, time.Now()))
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+// maxActivityEvents is the most events the activity timeline returns.
+const maxActivityEvents = 500
+
+// activityTimeline is the response of Activity.
+type activityTimeline struct {
+	// Since is the start of the timeline, AuditRetention ago.
+	Since  time.Time       `json:"since"`
+	Events []db.AuditEvent `json:"events"`
+}
+
+// activityTimelineFields are the fields of Activity that can be selected.
+var activityTimelineFields = fieldsOf(activityTimeline{})
+
+// Activity returns the current user's own actions in the dashboard, such as
+// logins and changes, over the retention period, newest first. It lets users
+// review their recent account activity and spot misuse of their account.
+func (c *MeContext) Activity(rw web.ResponseWriter, req *web.Request) {
+	userID := c.userID()
+	if userID == "" {
+		newUaaError(http.StatusNotFound, "activity is not available for this account.").writeTo(rw)
+		return
+	}
+	since := time.Now().UTC().Add(-db.AuditRetention)
+	events, err := c.Settings.Audit.Events(userID, since, maxActivityEvents)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, "unable to load the activity.").writeTo(rw)
+		return
+	}
+	c.writeAggregate(rw, req, activityTimelineFields, activityTimeline{Since: since, Events: events})
+}
+
+// OrgContext stores the session info and access token per user.
+// All routes within OrgContext are about an org the user can see.
+type OrgContext struct {
+	*SecureContext // Required.
+}
+
+// orgActivity is the response of OrgContext.Activity.
+type orgActivity struct {
+	// Since is the start of the activity, AuditRetention ago.
+	Since     time.Time     `json:"since"`
+	Incidents []db.Incident `json:"incidents"`
+}
+
+// orgActivityFields are the fields of OrgContext.Activity that can be
+// selected.
+var orgActivityFields = fieldsOf(orgActivity{})
+
+// Activity returns the incidents of the org's apps, such as crash loops,
+// over the retention period, newest first, to anyone who can see the org.
+func (c *OrgContext) Activity(rw web.ResponseWriter, req *web.Request) {
+	orgGUID := req.PathParams["org_guid"]
+	if err := c.ccRequest("GET", "/v2/organizations/"+url.PathEscape(orgGUID), nil, nil); err != nil {
+		newUaaError(http.StatusNotFound, "unknown org.").writeTo(rw)
+		return
+	}
+	since := time.Now().UTC().Add(-db.AuditRetention)
+	incidents, err := c.Settings.Incidents.Incidents(orgGUID, since, maxActivityEvents)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, "unable to load the activity.").writeTo(rw)
+		return
+	}
+	c.writeAggregate(rw, req, orgActivityFields, orgActivity{Since: since, Incidents: incidents})
+}
@@ -0,0 +1,95 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestActivity(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(adminTokenData, envVars)
+
+	response, request := NewTestRequest("DELETE", "/v2/apps/app-1", nil)
+	router.ServeHTTP(response, request)
+	response, request = NewTestRequest("PUT", "/admin/content", []byte(`{"quick_links": []}`))
+	router.ServeHTTP(response, request)
+	// Reads are not activity.
+	response, request = NewTestRequest("GET", "/v2/apps/app-1", nil)
+	router.ServeHTTP(response, request)
+
+	response, request = NewTestRequest("GET", "/api/me/activity", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected code %d. Found %d", http.StatusOK, response.Code)
+	}
+	var timeline struct {
+		Events []struct {
+			Actor   string          `json:"actor"`
+			Action  string          `json:"action"`
+			Details json.RawMessage `json:"details"`
+		} `json:"events"`
+	}
+	json.NewDecoder(response.Body).Decode(&timeline)
+	if len(timeline.Events) != 2 {
+		t.Fatalf("Expected 2 events. Found %+v", timeline.Events)
+	}
+	// Newest first.
+	if event := timeline.Events[0]; event.Action != "update_content" || event.Actor != "admin-guid" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	expected := NewJSONResponseContentTester(`{"method": "DELETE", "path": "/v2/apps/app-1"}`)
+	if event := timeline.Events[1]; event.Action != "cf_api_request" || !expected.Check(t, string(event.Details)) {
+		t.Errorf("Unexpected event %s %s", event.Action, event.Details)
+	}
+
+	// Users only see their own activity.
+	router, _ = CreateRouterWithMockSession(userTokenData, envVars)
+	response, request = NewTestRequest("GET", "/api/me/activity", nil)
+	router.ServeHTTP(response, request)
+	json.NewDecoder(response.Body).Decode(&timeline)
+	if len(timeline.Events) != 0 {
+		t.Errorf("Expected no events. Found %+v", timeline.Events)
+	}
+}
+
+func TestOrgActivity(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/organizations/org-1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"metadata": {"guid": "org-1"}}`))
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	response, request := NewTestRequest("GET", "/api/orgs/org-2/activity", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected the activity of orgs the user can't see to be hidden. Found %d", response.Code)
+	}
+
+	response, request = NewTestRequest("GET", "/api/orgs/org-1/activity", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected code %d. Found %d", http.StatusOK, response.Code)
+	}
+	var activity struct {
+		Incidents []json.RawMessage `json:"incidents"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&activity); err != nil || activity.Incidents == nil || len(activity.Incidents) != 0 {
+		t.Errorf("Expected no incidents. Found %+v, %v", activity, err)
+	}
+}
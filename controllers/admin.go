@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/gocraft/web"
+)
+
+// adminScope is the scope needed to see all the apps on the platform.
+const adminScope = "cloud_controller.admin"
+
+// AdminContext stores the session info and access token per user.
+// All routes within AdminContext are for platform admins only.
+type AdminContext struct {
+	*SecureContext // Required.
+}
+
+// ccV3App is a partial representation of a v3 CF API app.
+type ccV3App struct {
+	GUID          string `json:"guid"`
+	Name          string `json:"name"`
+	State         string `json:"state"`
+	Relationships struct {
+		Space struct {
+			Data struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		} `json:"space"`
+	} `json:"relationships"`
+}
+
+// ccV3Droplet is a partial representation of a v3 CF API droplet.
+type ccV3Droplet struct {
+	GUID       string `json:"guid"`
+	CreatedAt  string `json:"created_at"`
+	Stack      string `json:"stack"`
+	Buildpacks []struct {
+		Name          string `json:"name"`
+		BuildpackName string `json:"buildpack_name"`
+		Version       string `json:"version"`
+		DetectOutput  string `json:"detect_output"`
+	} `json:"buildpacks"`
+}
+
+// buildpackImpactApp is an app whose current droplet was built with the
+// buildpack.
+type buildpackImpactApp struct {
+	GUID             string `json:"guid"`
+	Name             string `json:"name"`
+	SpaceGUID        string `json:"space_guid"`
+	State            string `json:"state"`
+	BuildpackVersion string `json:"buildpack_version"`
+	// LastStaged is when the current droplet was created.
+	LastStaged string `json:"last_staged"`
+}
+
+// buildpackImpactReport is the response of BuildpackImpact.
+type buildpackImpactReport struct {
+	Buildpack string               `json:"buildpack"`
+	Version   string               `json:"version,omitempty"`
+	Apps      []buildpackImpactApp `json:"apps"`
+}
+
+// buildpackImpactFields are the fields of BuildpackImpact that can be
+// selected.
+var buildpackImpactFields = fieldsOf(buildpackImpactReport{})
+
+// BuildpackImpact lists the apps whose current droplet was built with the
+// given buildpack (and optionally the given version of it), least recently
+// staged first. Operators use it to find who to notify before removing an
+// old buildpack.
+func (c *AdminContext) BuildpackImpact(rw web.ResponseWriter, req *web.Request) {
+	buildpack := req.URL.Query().Get("buildpack")
+	version := req.URL.Query().Get("version")
+	if buildpack == "" {
+		newUaaError(http.StatusBadRequest, "buildpack is required.").writeTo(rw)
+		return
+	}
+	resources, err := c.ccGetAllV3("/v3/apps?" + url.Values{"per_page": {"5000"}}.Encode())
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+
+	ccApps := make([]ccV3App, len(resources))
+	droplets := make([]*ccV3Droplet, len(resources))
+	tasks := make([]func() error, len(resources))
+	for i, resource := range resources {
+		if err := json.Unmarshal(resource, &ccApps[i]); err != nil {
+			newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+			return
+		}
+		i := i
+		tasks[i] = func() error {
+			var droplet ccV3Droplet
+			err := c.ccRequest("GET", "/v3/apps/"+ccApps[i].GUID+"/droplets/current", nil, &droplet)
+			if isCCNotFound(err) {
+				// The app was never staged.
+				return nil
+			}
+			droplets[i] = &droplet
+			return err
+		}
+	}
+	if err := c.fanOut(tasks); err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+
+	apps := []buildpackImpactApp{}
+	for i, app := range ccApps {
+		droplet := droplets[i]
+		if droplet == nil {
+			continue
+		}
+		for _, bp := range droplet.Buildpacks {
+			if bp.Name != buildpack && bp.BuildpackName != buildpack {
+				continue
+			}
+			if version != "" && bp.Version != version {
+				continue
+			}
+			apps = append(apps, buildpackImpactApp{
+				GUID:             app.GUID,
+				Name:             app.Name,
+				SpaceGUID:        app.Relationships.Space.Data.GUID,
+				State:            app.State,
+				BuildpackVersion: bp.Version,
+				LastStaged:       droplet.CreatedAt,
+			})
+			break
+		}
+	}
+	// Timestamps are RFC 3339 in UTC, so they sort as strings.
+	sort.SliceStable(apps, func(i, j int) bool {
+		return apps[i].LastStaged < apps[j].LastStaged
+	})
+
+	c.writeAggregate(rw, req, buildpackImpactFields, buildpackImpactReport{
+		Buildpack: buildpack,
+		Version:   version,
+		Apps:      apps,
+	})
+}
+
+// LoginReport shows how many logins reached each step of the login flow
+// since the dashboard started.
+func (c *AdminContext) LoginReport(rw web.ResponseWriter, req *web.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(c.Settings.Logins.Report())
+}
+
+// StartupReport shows how the dashboard started: its endpoints, the features
+// turned on, how long each startup step took and the warnings.
+func (c *AdminContext) StartupReport(rw web.ResponseWriter, req *web.Request) {
+	if c.Settings.Startup == nil {
+		newUaaError(http.StatusNotFound, "the startup report is not available.").writeTo(rw)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(c.Settings.Startup.Snapshot())
+}
+
+// ProxyQuotaUsage shows the CF API requests made through the dashboard by
+// each user and org in the current hour.
+func (c *AdminContext) ProxyQuotaUsage(rw web.ResponseWriter, req *web.Request) {
+	if c.Settings.ProxyQuota == nil {
+		newUaaError(http.StatusNotFound, "proxy quotas are not enabled.").writeTo(rw)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(c.Settings.ProxyQuota.Usage())
+}
@@ -0,0 +1,185 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/logging"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+var adminTokenData = NewTokenData(map[string]interface{}{
+	"user_id": "admin-guid",
+	"scope":   []string{"openid", "cloud_controller.admin"},
+})
+
+func TestBuildpackImpact(t *testing.T) {
+	var cc *httptest.Server
+	cc = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/apps":
+			if r.URL.Query().Get("page") == "2" {
+				w.Write([]byte(`{"pagination": {"next": null}, "resources": [
+					{"guid": "app-3", "name": "never-staged", "state": "STOPPED"}
+				]}`))
+				return
+			}
+			w.Write([]byte(`{"pagination": {"next": {"href": "` + cc.URL + `/v3/apps?page=2"}}, "resources": [
+				{"guid": "app-1", "name": "web", "state": "STARTED", "relationships": {"space": {"data": {"guid": "space-1"}}}},
+				{"guid": "app-2", "name": "api", "state": "STARTED", "relationships": {"space": {"data": {"guid": "space-2"}}}},
+				{"guid": "app-4", "name": "static", "state": "STARTED", "relationships": {"space": {"data": {"guid": "space-2"}}}}
+			]}`))
+		case "/v3/apps/app-1/droplets/current":
+			w.Write([]byte(`{"guid": "droplet-1", "created_at": "2018-03-01T00:00:00Z", "buildpacks": [{"name": "ruby_buildpack", "buildpack_name": "ruby", "version": "1.7.1"}]}`))
+		case "/v3/apps/app-2/droplets/current":
+			w.Write([]byte(`{"guid": "droplet-2", "created_at": "2017-11-01T00:00:00Z", "buildpacks": [{"name": "ruby_buildpack", "buildpack_name": "ruby", "version": "1.7.1"}]}`))
+		case "/v3/apps/app-4/droplets/current":
+			w.Write([]byte(`{"guid": "droplet-4", "created_at": "2017-01-01T00:00:00Z", "buildpacks": [{"name": "ruby_buildpack", "buildpack_name": "ruby", "version": "1.7.2"}]}`))
+		case "/v3/apps/app-3/droplets/current":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		default:
+			t.Errorf("Unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+
+	tests := []BasicSecureTest{
+		{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "Buildpack Impact Without Admin Scope",
+				SessionData: userTokenData,
+				Location:    "/admin/buildpacks/impact?buildpack=ruby_buildpack",
+			},
+			ExpectedCode:     http.StatusForbidden,
+			ExpectedResponse: NewJSONResponseContentTester(`{"status": "forbidden", "error_description": "This needs the cloud_controller.admin scope.", "required_scope": "cloud_controller.admin", "required_scopes": ["cloud_controller.admin"]}`),
+		},
+		{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "Buildpack Impact For Version",
+				SessionData: adminTokenData,
+				Location:    "/admin/buildpacks/impact?buildpack=ruby&version=1.7.1",
+			},
+			ExpectedCode: http.StatusOK,
+			ExpectedResponse: NewJSONResponseContentTester(`{"buildpack": "ruby", "version": "1.7.1", "apps": [
+				{"guid": "app-2", "name": "api", "space_guid": "space-2", "state": "STARTED", "buildpack_version": "1.7.1", "last_staged": "2017-11-01T00:00:00Z"},
+				{"guid": "app-1", "name": "web", "space_guid": "space-1", "state": "STARTED", "buildpack_version": "1.7.1", "last_staged": "2018-03-01T00:00:00Z"}
+			]}`),
+		},
+		{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "Buildpack Impact For All Versions",
+				SessionData: adminTokenData,
+				Location:    "/admin/buildpacks/impact?buildpack=ruby_buildpack",
+			},
+			ExpectedCode: http.StatusOK,
+			ExpectedResponse: NewJSONResponseContentTester(`{"buildpack": "ruby_buildpack", "apps": [
+				{"guid": "app-4", "name": "static", "space_guid": "space-2", "state": "STARTED", "buildpack_version": "1.7.2", "last_staged": "2017-01-01T00:00:00Z"},
+				{"guid": "app-2", "name": "api", "space_guid": "space-2", "state": "STARTED", "buildpack_version": "1.7.1", "last_staged": "2017-11-01T00:00:00Z"},
+				{"guid": "app-1", "name": "web", "space_guid": "space-1", "state": "STARTED", "buildpack_version": "1.7.1", "last_staged": "2018-03-01T00:00:00Z"}
+			]}`),
+		},
+		{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "Buildpack Impact Without Buildpack",
+				SessionData: adminTokenData,
+				Location:    "/admin/buildpacks/impact",
+			},
+			ExpectedCode:     http.StatusBadRequest,
+			ExpectedResponse: NewJSONResponseContentTester(`{"status": "failure", "data": "buildpack is required."}`),
+		},
+	}
+	for _, test := range tests {
+		response, request := NewTestRequest("GET", test.Location, nil)
+		router, _ := CreateRouterWithMockSession(test.SessionData, envVars)
+		router.ServeHTTP(response, request)
+		if response.Code != test.ExpectedCode {
+			t.Errorf("Test %s did not meet expected code.\nExpected %d.\nFound %d.\n", test.TestName, test.ExpectedCode, response.Code)
+		}
+		if !test.ExpectedResponse.Check(t, response.Body.String()) {
+			t.Errorf("Test %s did not contain expected value.\nExpected %s.\n Found (%s)\n.", test.TestName, test.ExpectedResponse.Display(), response.Body.String())
+		}
+	}
+}
+
+func TestLoginReport(t *testing.T) {
+	router, _ := CreateRouterWithMockSession(adminTokenData, GetMockCompleteEnvVars())
+
+	// A callback without the state from the handshake.
+	response, request := NewTestRequest("GET", "/oauth2callback?code=code&state=other", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected code %d. Found %d", http.StatusUnauthorized, response.Code)
+	}
+
+	response, request = NewTestRequest("GET", "/admin/logins", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected code %d. Found %d", http.StatusOK, response.Code)
+	}
+	var report helpers.LoginFunnelReport
+	json.NewDecoder(response.Body).Decode(&report)
+	if report.Counts[helpers.LoginStateMismatch] != 1 || report.Counts[helpers.LoginCompleted] != 0 {
+		t.Errorf("Unexpected login report %+v", report)
+	}
+}
+
+func TestStartupReport(t *testing.T) {
+	router, _ := CreateRouterWithMockSession(userTokenData, GetMockCompleteEnvVars())
+	response, request := NewTestRequest("GET", "/api/startup-report", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected code %d. Found %d", http.StatusForbidden, response.Code)
+	}
+
+	// The report is only kept by the server.
+	router, _ = CreateRouterWithMockSession(adminTokenData, GetMockCompleteEnvVars())
+	response, request = NewTestRequest("GET", "/api/startup-report", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected code %d. Found %d", http.StatusNotFound, response.Code)
+	}
+}
+
+func TestUpdateLogLevels(t *testing.T) {
+	defer logging.Levels.Set(logging.InfoLevel, nil)
+	router, _ := CreateRouterWithMockSession(adminTokenData, GetMockCompleteEnvVars())
+
+	response, request := NewTestRequest("PUT", "/admin/log_levels", []byte(`{"modules": {"login": "debug"}}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+	if !logging.Levels.Enabled("login", logging.DebugLevel) || logging.Levels.Enabled("proxy", logging.DebugLevel) {
+		t.Error("Expected only the login module to log at the debug level")
+	}
+
+	response, request = NewTestRequest("GET", "/admin/log_levels", nil)
+	router.ServeHTTP(response, request)
+	var levels logging.Snapshot
+	json.NewDecoder(response.Body).Decode(&levels)
+	if levels.Default != "info" || levels.Modules["login"] != "debug" {
+		t.Errorf("Unexpected levels %+v", levels)
+	}
+
+	for _, body := range []string{`{"default": "loud"}`, `{"modules": {"nope": "debug"}}`} {
+		response, request = NewTestRequest("PUT", "/admin/log_levels", []byte(body))
+		router.ServeHTTP(response, request)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected code %d for %s. Found %d", http.StatusBadRequest, body, response.Code)
+		}
+	}
+
+	router, _ = CreateRouterWithMockSession(userTokenData, GetMockCompleteEnvVars())
+	response, request = NewTestRequest("PUT", "/admin/log_levels", []byte(`{"default": "debug"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected code %d. Found %d", http.StatusForbidden, response.Code)
+	}
+}
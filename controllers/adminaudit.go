@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+const (
+	// defaultAdminAuditEntries is how many entries of the admin audit log
+	// are returned unless ?limit= says otherwise.
+	defaultAdminAuditEntries = 100
+	// maxAdminAuditEntries is the most entries of the admin audit log
+	// returned at once.
+	maxAdminAuditEntries = 1000
+)
+
+// adminAuditLog is the response of AdminAuditLog.
+type adminAuditLog struct {
+	Since   time.Time            `json:"since"`
+	Entries []db.AdminAuditEntry `json:"entries"`
+}
+
+// adminAuditLogFields are the fields of AdminAuditLog that can be selected.
+var adminAuditLogFields = fieldsOf(adminAuditLog{})
+
+// AdminAuditLog returns the recent privileged actions the dashboard took on
+// behalf of users, newest first. ?actor=, ?action= and ?target= filter them,
+// ?since= is an RFC 3339 time, AuditRetention ago by default, and ?limit= is
+// how many to return, at most maxAdminAuditEntries.
+func (c *AdminContext) AdminAuditLog(rw web.ResponseWriter, req *web.Request) {
+	query := req.URL.Query()
+	filter := db.AdminAuditFilter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+		Since:  time.Now().UTC().Add(-db.AuditRetention),
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			newUaaError(http.StatusBadRequest, "since must be an RFC 3339 time.").writeTo(rw)
+			return
+		}
+		filter.Since = t
+	}
+	limit := defaultAdminAuditEntries
+	if l := query.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > maxAdminAuditEntries {
+			newUaaError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxAdminAuditEntries)+".").writeTo(rw)
+			return
+		}
+	}
+	entries, err := c.Settings.AdminAudit.Store.AdminActions(filter, limit)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, "unable to load the admin audit log.").writeTo(rw)
+		return
+	}
+	c.writeAggregate(rw, req, adminAuditLogFields, adminAuditLog{Since: filter.Since, Entries: entries})
+}
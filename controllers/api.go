@@ -1,9 +1,15 @@
 package controllers
 
 import (
+	"encoding/json"
 	"fmt"
-	"github.com/gocraft/web"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocraft/web"
 )
 
 // APIContext stores the session info and access token per user.
@@ -15,8 +21,85 @@ type APIContext struct {
 // APIProxy is a handler that serves as a proxy for all the CF API. Any route that comes in the /v2/* route
 // that has not been specified, will just come here.
 func (c *APIContext) APIProxy(rw web.ResponseWriter, req *web.Request) {
+	if quota := c.Settings.ProxyQuota; quota != nil {
+		if exceeded, resetsAt := quota.Allow(c.userID(), requestOrgGUID(req)); exceeded != "" {
+			quotaExceeded(rw, exceeded, resetsAt)
+			return
+		}
+	}
+	if c.parkForApproval(rw, req) {
+		return
+	}
+	if c.Settings.PlatformCache != nil && isPlatformRequest(req.Request) {
+		c.platformProxy(rw, req.Request)
+		return
+	}
+	if req.Method != "GET" && req.Method != "HEAD" {
+		// Record the changes users make, so they can review them.
+		c.Settings.RecordAuditEvent(req.Request, c.userID(), "cf_api_request", struct {
+			Method string `json:"method"`
+			Path   string `json:"path"`
+		}{
+			Method: req.Method,
+			Path:   req.URL.Path,
+		})
+	}
 	reqURL := fmt.Sprintf("%s%s", c.Settings.ConsoleAPI, req.URL)
-	c.Proxy(rw, req.Request, reqURL, c.GenericResponseHandler)
+	if c.Settings.APICache != nil {
+		if req.Method == "GET" {
+			if c.cachedAPIProxy(rw, req.Request, reqURL) {
+				return
+			}
+		} else if req.Method != "HEAD" {
+			c.invalidateAPICache()
+		}
+	}
+	responseHandler := c.GenericResponseHandler
+	if m := orgUserPathPattern.FindStringSubmatch(req.URL.Path); m != nil && req.Method == "PUT" {
+		responseHandler = func(rw http.ResponseWriter, response *http.Response) {
+			c.GenericResponseHandler(rw, response)
+			if response.StatusCode >= 200 && response.StatusCode < 300 {
+				c.publishUserAdded(m[1], m[2], "")
+			}
+		}
+	}
+	c.Proxy(rw, req.Request, reqURL, responseHandler)
+}
+
+// orgPathPattern matches CF API paths of an org and its sub-resources.
+var orgPathPattern = regexp.MustCompile(`^/v2/organizations/([^/]+)`)
+
+// requestOrgGUID returns the GUID of the org a CF API request is for, when
+// it's in the path or an organization_guid filter, or an empty string.
+func requestOrgGUID(req *web.Request) string {
+	if m := orgPathPattern.FindStringSubmatch(req.URL.Path); m != nil {
+		return m[1]
+	}
+	for _, q := range req.URL.Query()["q"] {
+		if strings.HasPrefix(q, "organization_guid:") {
+			return strings.TrimPrefix(q, "organization_guid:")
+		}
+	}
+	return ""
+}
+
+// quotaExceeded responds to a request over a proxy quota.
+func quotaExceeded(rw http.ResponseWriter, scope string, resetsAt time.Time) {
+	retryAfter := int(time.Until(resetsAt).Seconds()) + 1
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	rw.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(rw).Encode(struct {
+		Status      string    `json:"status"`
+		Description string    `json:"error_description"`
+		Scope       string    `json:"scope"`
+		ResetsAt    time.Time `json:"resets_at"`
+	}{
+		Status:      "quota_exceeded",
+		Description: "Too many requests for this " + scope + " in the last hour. Try again later.",
+		Scope:       scope,
+		ResetsAt:    resetsAt,
+	})
 }
 
 // UserProfile redirects users to the `/profile` page
@@ -1,9 +1,20 @@
 package controllers_test
 
 import (
-	. "github.com/18F/cg-dashboard/helpers/testhelpers"
-
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/cloudfoundry-community/go-cfenv"
+	"github.com/govau/cf-common/env"
+
+	"github.com/18F/cg-dashboard/controllers"
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
 )
 
 var authStatusTests = []BasicSecureTest{
@@ -53,3 +64,159 @@ func TestProfile(t *testing.T) {
 		}
 	}
 }
+
+func TestAPIProxyQuota(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.ProxyQuotaPerUserEnvVar] = "3"
+	envVars[helpers.ProxyQuotaPerOrgEnvVar] = "2"
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	for i, test := range []struct {
+		location     string
+		expectedCode int
+	}{
+		{"/v2/organizations/org-1/spaces", http.StatusOK},
+		{"/v2/spaces?q=organization_guid:org-1", http.StatusOK},
+		{"/v2/organizations/org-1", http.StatusTooManyRequests},
+		{"/v2/info", http.StatusOK},
+		{"/v2/info", http.StatusTooManyRequests},
+	} {
+		response, request := NewTestRequest("GET", test.location, nil)
+		router.ServeHTTP(response, request)
+		if response.Code != test.expectedCode {
+			t.Errorf("Request %d to %s: expected code %d. Found %d", i+1, test.location, test.expectedCode, response.Code)
+		}
+		if response.Code == http.StatusTooManyRequests && response.Header().Get("Retry-After") == "" {
+			t.Errorf("Request %d to %s: expected a Retry-After header", i+1, test.location)
+		}
+	}
+
+	// The quota is per user.
+	adminRouter, _ := CreateRouterWithMockSession(adminTokenData, envVars)
+	response, request := NewTestRequest("GET", "/v2/info", nil)
+	adminRouter.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected code %d. Found %d", http.StatusOK, response.Code)
+	}
+
+	response, request = NewTestRequest("GET", "/admin/proxy_quotas", nil)
+	adminRouter.ServeHTTP(response, request)
+	var usage helpers.ProxyQuotaUsage
+	json.NewDecoder(response.Body).Decode(&usage)
+	if usage.PerUser != 3 || usage.PerOrg != 2 || usage.Users["admin-guid"] != 1 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+}
+
+func TestAPIProxyResponseLimit(t *testing.T) {
+	large := strings.Repeat("x", 200)
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/small":
+			w.Write([]byte(`{}`))
+		case "/v2/sized":
+			w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+			w.Write([]byte(large))
+		case "/v2/streamed":
+			// Flushing sends the response without a Content-Length.
+			w.Write([]byte(large[:50]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(large[50:]))
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.MaxProxyResponseBytesEnvVar] = "100"
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	for _, test := range []struct {
+		location     string
+		expectedCode int
+		expectedSize int
+	}{
+		{"/v2/small", http.StatusOK, 2},
+		{"/v2/streamed", http.StatusOK, 100},
+	} {
+		response, request := NewTestRequest("GET", test.location, nil)
+		router.ServeHTTP(response, request)
+		if response.Code != test.expectedCode || response.Body.Len() != test.expectedSize {
+			t.Errorf("%s: expected code %d with %d bytes. Found %d with %d bytes", test.location, test.expectedCode, test.expectedSize, response.Code, response.Body.Len())
+		}
+	}
+
+	response, request := NewTestRequest("GET", "/v2/sized", nil)
+	router.ServeHTTP(response, request)
+	expected := NewJSONResponseContentTester(`{"status": "response_too_large", "error_description": "The response is larger than the 100 bytes the dashboard can handle."}`)
+	if response.Code != http.StatusBadGateway {
+		t.Errorf("Expected code %d. Found %d", http.StatusBadGateway, response.Code)
+	}
+	if !expected.Check(t, response.Body.String()) {
+		t.Errorf("Expected %s. Found %s", expected.Display(), response.Body.String())
+	}
+}
+
+func TestAPIProxyPlatformCache(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.URL.RequestURI()]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/oauth/token":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token": "client-token", "token_type": "bearer", "expires_in": 3600}`))
+		case "/v2/stacks":
+			if r.URL.Query().Get("page") == "" {
+				w.Write([]byte(`{"total_pages": 2, "next_url": "/v2/stacks?page=2", "resources": [{"metadata": {"guid": "stack-1"}}]}`))
+				return
+			}
+			w.Write([]byte(`{"total_pages": 2, "next_url": null, "resources": [{"metadata": {"guid": "stack-2"}}]}`))
+		default:
+			w.Write([]byte(`{"resources": []}`))
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.UAAURLEnvVar] = cc.URL
+
+	// Every user gets the stacks cached by the first request.
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+	adminRouter, _ := CreateRouterWithMockSession(adminTokenData, envVars)
+	for _, r := range []http.Handler{router, router, adminRouter} {
+		response, request := NewTestRequest("GET", "/v2/stacks", nil)
+		r.ServeHTTP(response, request)
+		if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), "stack-1") {
+			t.Errorf("Expected the stacks. Found %d %s", response.Code, response.Body.String())
+		}
+	}
+	// Other requests aren't cached.
+	for i := 0; i < 2; i++ {
+		response, request := NewTestRequest("GET", "/v2/apps", nil)
+		router.ServeHTTP(response, request)
+	}
+	if calls["/v2/stacks"] != 2 || calls["/v2/apps"] != 2 {
+		t.Errorf("Expected the stacks to be requested once per router and apps every time. Found %v", calls)
+	}
+
+	// Warming the cache loads all the pages.
+	settings := helpers.Settings{}
+	app, _ := cfenv.Current()
+	if err := settings.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	controllers.WarmPlatformCache(&settings)
+	if calls["/v2/stacks?page=2"] != 1 || calls["/v2/buildpacks"] != 1 {
+		t.Errorf("Expected all the pages to be warmed. Found %v", calls)
+	}
+	if settings.PlatformCache.Len() != 5 {
+		t.Errorf("Expected 5 cached pages. Found %d", settings.PlatformCache.Len())
+	}
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	// cacheBustHeader makes the proxy get a fresh response from the CF API,
+	// e.g. when the user reloads a list, and cache it in place of the old one.
+	cacheBustHeader = "X-Cache-Bust"
+	// cacheStatusHeader tells whether the response came from the API cache:
+	// HIT, MISS or BUST.
+	cacheStatusHeader = "X-Cache"
+	// maxCachedAPIResponseBytes is the largest response kept in the API
+	// cache. Larger responses are streamed to the user rather than held in
+	// memory.
+	maxCachedAPIResponseBytes = 1 << 20
+)
+
+// apiCacheWriter buffers a proxied response to cache it. Once the response is
+// over maxCachedAPIResponseBytes, or its Content-Length says it will be, it's
+// streamed to rw instead and isn't cached.
+type apiCacheWriter struct {
+	rw        http.ResponseWriter
+	header    http.Header
+	code      int
+	body      bytes.Buffer
+	streaming bool
+}
+
+func (w *apiCacheWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *apiCacheWriter) WriteHeader(code int) {
+	if w.code != 0 {
+		return
+	}
+	w.code = code
+	if w.streaming {
+		w.stream()
+	}
+}
+
+func (w *apiCacheWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.streaming && w.body.Len()+len(p) > maxCachedAPIResponseBytes {
+		w.streaming = true
+		if err := w.stream(); err != nil {
+			return 0, err
+		}
+	}
+	if w.streaming {
+		return w.rw.Write(p)
+	}
+	return w.body.Write(p)
+}
+
+// stream sends the headers and what was buffered to rw.
+func (w *apiCacheWriter) stream() error {
+	for name, values := range w.header {
+		w.rw.Header()[name] = values
+	}
+	w.rw.WriteHeader(w.code)
+	_, err := w.rw.Write(w.body.Bytes())
+	w.body.Reset()
+	return err
+}
+
+// cachedAPIProxy proxies a CF API GET through the API cache, keyed on the
+// user and the URL. It returns false if the response of the path isn't
+// cached.
+func (c *APIContext) cachedAPIProxy(rw http.ResponseWriter, req *http.Request, reqURL string) bool {
+	cache := c.Settings.APICache
+	ttl := cache.TTL(req.URL.Path)
+	user := c.userID()
+	if ttl <= 0 || user == "" {
+		return false
+	}
+	key, err := cache.Key(user, req.URL.RequestURI())
+	if err != nil {
+		c.logger(cacheLog).Warnf("unable to look up the API cache: %v", err)
+		return false
+	}
+	status := "BUST"
+	if req.Header.Get(cacheBustHeader) == "" {
+		status = "MISS"
+		if data, err := cache.Backend.Get(key); err != nil {
+			c.logger(cacheLog).Warnf("unable to read the API cache: %v", err)
+		} else if data != nil {
+			var response cachedResponse
+			if err := json.Unmarshal(data, &response); err == nil {
+				rw.Header().Set(cacheStatusHeader, "HIT")
+				response.writeTo(rw)
+				return true
+			}
+		}
+	}
+
+	rw.Header().Set(cacheStatusHeader, status)
+	w := &apiCacheWriter{rw: rw, header: make(http.Header)}
+	c.Proxy(w, req, reqURL, func(rw http.ResponseWriter, res *http.Response) {
+		rw.Header().Set("Content-Type", res.Header.Get("Content-Type"))
+		w.streaming = res.ContentLength > maxCachedAPIResponseBytes
+		c.GenericResponseHandler(rw, res)
+	})
+	if w.streaming {
+		return true
+	}
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	response := &cachedResponse{Code: w.code, ContentType: w.header.Get("Content-Type"), Body: w.body.Bytes()}
+	if w.code == http.StatusOK {
+		data, _ := json.Marshal(response)
+		if err := cache.Backend.Set(key, data, ttl); err != nil {
+			c.logger(cacheLog).Warnf("unable to write the API cache: %v", err)
+		}
+	}
+	for name, values := range w.header {
+		rw.Header()[name] = values
+	}
+	response.writeTo(rw)
+	return true
+}
+
+// invalidateAPICache drops the user's cached responses after a change they
+// made, so they see it right away.
+func (c *APIContext) invalidateAPICache() {
+	if user := c.userID(); user != "" {
+		if err := c.Settings.APICache.Invalidate(user); err != nil {
+			c.logger(cacheLog).Warnf("unable to invalidate the API cache: %v", err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestAPICache(t *testing.T) {
+	var calls int32
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"call": ` + strconv.Itoa(int(n)) + `}`))
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.APICacheEnvVar] = helpers.MemoryAPICacheBackend
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	for i, test := range []struct {
+		method string
+		path   string
+		bust   bool
+		cache  string
+		body   string
+	}{
+		{"GET", "/v2/organizations", false, "MISS", `{"call": 1}`},
+		{"GET", "/v2/organizations", false, "HIT", `{"call": 1}`},
+		{"GET", "/v2/organizations", true, "BUST", `{"call": 2}`},
+		{"GET", "/v2/organizations", false, "HIT", `{"call": 2}`},
+		// Uncached types of resources aren't cached.
+		{"GET", "/v2/apps/app-1/stats", false, "", `{"call": 3}`},
+		// A change invalidates the user's cached responses.
+		{"PUT", "/v2/spaces/space-1", false, "", `{"call": 4}`},
+		{"GET", "/v2/organizations", false, "MISS", `{"call": 5}`},
+	} {
+		response, request := NewTestRequest(test.method, test.path, nil)
+		if test.bust {
+			request.Header.Set("X-Cache-Bust", "1")
+		}
+		router.ServeHTTP(response, request)
+		if got := response.Header().Get("X-Cache"); got != test.cache {
+			t.Errorf("%d: expected X-Cache %q. Found %q", i, test.cache, got)
+		}
+		if response.Body.String() != test.body {
+			t.Errorf("%d: expected %s. Found %s", i, test.body, response.Body.String())
+		}
+	}
+}
+
+func TestAPICacheStreamsLargeResponses(t *testing.T) {
+	var calls int32
+	large := `{"resources": ["` + strings.Repeat("x", 2<<20) + `"]}`
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		// Responses without a Content-Length are streamed once they're
+		// over the limit.
+		if r.URL.Query().Get("chunked") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+		}
+		w.Write([]byte(large))
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.APICacheEnvVar] = helpers.MemoryAPICacheBackend
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	for _, path := range []string{"/v2/organizations", "/v2/organizations?chunked=1"} {
+		for i := 0; i < 2; i++ {
+			response, request := NewTestRequest("GET", path, nil)
+			router.ServeHTTP(response, request)
+			if response.Code != http.StatusOK || response.Body.String() != large {
+				t.Errorf("%s: expected the whole response. Found %d with %d bytes", path, response.Code, response.Body.Len())
+			}
+			if got := response.Header().Get("X-Cache"); got != "MISS" {
+				t.Errorf("%s: expected the large response not to be cached. Found X-Cache %q", path, got)
+			}
+		}
+	}
+	if calls != 4 {
+		t.Errorf("Expected every request to reach the CF API. Found %d calls", calls)
+	}
+}
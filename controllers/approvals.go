@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+// maxParkedBodyBytes is the largest CF API request body that can be parked
+// for approval.
+const maxParkedBodyBytes = 1 << 20
+
+// parkForApproval parks the CF API request of a platform admin as a pending
+// change when the approval policy needs a second admin to approve it. It
+// returns true if the request was handled.
+func (c *APIContext) parkForApproval(rw web.ResponseWriter, req *web.Request) bool {
+	policy := c.Settings.ApprovalPolicy
+	if policy == nil || req.Method == "GET" || req.Method == "HEAD" || !c.hasScope(adminScope) {
+		return false
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(io.LimitReader(req.Body, maxParkedBodyBytes+1)); err != nil {
+			newUaaError(http.StatusBadRequest, "unable to read the request body.").writeTo(rw)
+			return true
+		}
+		// Let the request through with its body if it doesn't need approval.
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	class := policy.Classify(req.Method, req.URL.Path, body)
+	if class == "" {
+		return false
+	}
+	if len(body) > maxParkedBodyBytes || (len(body) > 0 && !json.Valid(body)) {
+		newUaaError(http.StatusBadRequest, "changes that need approval must have a JSON body of at most 1 MiB.").writeTo(rw)
+		return true
+	}
+	change, err := c.parkChange(req.Request, class, req.Method, req.URL.RequestURI(), body)
+	if err == errParkNeedsJWT {
+		newUaaError(http.StatusForbidden, err.Error()+".").writeTo(rw)
+		return true
+	}
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return true
+	}
+	rw.Header().Set("Location", "/admin/pending_changes/"+change.ID)
+	writePendingChange(rw, http.StatusAccepted, change)
+	return true
+}
+
+// errParkNeedsJWT is the error of a change that needs approval from a user
+// whose ID is unknown.
+var errParkNeedsJWT = errors.New("changes that need approval need a JWT access token")
+
+// parkChange keeps the CF API change of the user as a pending change until
+// another admin approves it. The request is only used for the audit event.
+func (c *SecureContext) parkChange(req *http.Request, class, method, path string, body []byte) (db.PendingChange, error) {
+	change := db.PendingChange{
+		Class:       class,
+		Method:      method,
+		Path:        path,
+		RequestedBy: c.userID(),
+	}
+	if len(body) > 0 {
+		change.Body = body
+	}
+	if change.RequestedBy == "" {
+		return change, errParkNeedsJWT
+	}
+	change, err := c.Settings.PendingChanges.CreatePendingChange(change)
+	if err != nil {
+		return change, err
+	}
+	c.Settings.RecordAuditEvent(req, change.RequestedBy, "park_change", struct {
+		ID     string `json:"id"`
+		Class  string `json:"class"`
+		Method string `json:"method"`
+		Path   string `json:"path"`
+	}{
+		ID:     change.ID,
+		Class:  change.Class,
+		Method: change.Method,
+		Path:   change.Path,
+	})
+	return change, nil
+}
+
+// writePendingChange responds with the pending change.
+func writePendingChange(rw http.ResponseWriter, status int, change interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(change)
+}
+
+// PendingChanges lists the changes waiting for approval, or those with the
+// given ?status=.
+func (c *AdminContext) PendingChanges(rw web.ResponseWriter, req *web.Request) {
+	status := req.URL.Query().Get("status")
+	if status == "" {
+		status = db.PendingChangePending
+	} else if status == "all" {
+		status = ""
+	}
+	changes, err := c.Settings.PendingChanges.PendingChanges(status)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	writePendingChange(rw, http.StatusOK, changes)
+}
+
+// pendingChange gets the pending change of the path. It responds with an
+// error and returns false if it can't be decided on anymore.
+func (c *AdminContext) pendingChange(rw web.ResponseWriter, req *web.Request) (db.PendingChange, bool) {
+	change, err := c.Settings.PendingChanges.PendingChange(req.PathParams["id"])
+	if err == db.ErrPendingChangeNotFound {
+		newUaaError(http.StatusNotFound, err.Error()+".").writeTo(rw)
+		return change, false
+	}
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return change, false
+	}
+	if change.Status != db.PendingChangePending {
+		newUaaError(http.StatusConflict, db.ErrPendingChangeDecided.Error()+".").writeTo(rw)
+		return change, false
+	}
+	return change, true
+}
+
+// decidePendingChange records the decision on the change. It responds with
+// an error and returns false if it was decided already.
+func (c *AdminContext) decidePendingChange(rw web.ResponseWriter, change db.PendingChange, status string) (db.PendingChange, bool) {
+	change, err := c.Settings.PendingChanges.DecidePendingChange(change.ID, status, c.userID())
+	if err == db.ErrPendingChangeDecided {
+		newUaaError(http.StatusConflict, err.Error()+".").writeTo(rw)
+		return change, false
+	}
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return change, false
+	}
+	return change, true
+}
+
+// ApprovePendingChange lets a second admin approve a change, which is then
+// made in a job with the approver's credentials.
+func (c *AdminContext) ApprovePendingChange(rw web.ResponseWriter, req *web.Request) {
+	change, ok := c.pendingChange(rw, req)
+	if !ok {
+		return
+	}
+	if approver := c.userID(); approver == "" || approver == change.RequestedBy {
+		newUaaError(http.StatusForbidden, "changes need to be approved by another admin.").writeTo(rw)
+		return
+	}
+	if change.Expired(time.Now()) {
+		newUaaError(http.StatusConflict, "the change has expired, make it again.").writeTo(rw)
+		return
+	}
+	change, ok = c.decidePendingChange(rw, change, db.PendingChangeApproved)
+	if !ok {
+		return
+	}
+	var body interface{}
+	if change.Body != nil {
+		body = change.Body
+	}
+	job, ok := c.submitJob(rw, "approved-change", []jobs.Task{{
+		Name: change.Method + " " + change.Path,
+		Run: func() error {
+			return c.approvedCCRequest(change.Method, change.Path, body, nil)
+		},
+	}})
+	if !ok {
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "approve_change", struct {
+		ID          string `json:"id"`
+		Class       string `json:"class"`
+		RequestedBy string `json:"requested_by"`
+		JobID       string `json:"job_id"`
+	}{
+		ID:          change.ID,
+		Class:       change.Class,
+		RequestedBy: change.RequestedBy,
+		JobID:       job.ID,
+	})
+}
+
+// RejectPendingChange rejects a change, or withdraws it when done by the
+// admin who made it.
+func (c *AdminContext) RejectPendingChange(rw web.ResponseWriter, req *web.Request) {
+	change, ok := c.pendingChange(rw, req)
+	if !ok {
+		return
+	}
+	change, ok = c.decidePendingChange(rw, change, db.PendingChangeRejected)
+	if !ok {
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "reject_change", struct {
+		ID          string `json:"id"`
+		Class       string `json:"class"`
+		RequestedBy string `json:"requested_by"`
+	}{
+		ID:          change.ID,
+		Class:       change.Class,
+		RequestedBy: change.RequestedBy,
+	})
+	writePendingChange(rw, http.StatusOK, change)
+}
@@ -0,0 +1,222 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+var otherAdminTokenData = NewTokenData(map[string]interface{}{
+	"user_id": "other-admin-guid",
+	"scope":   []string{"openid", "cloud_controller.admin"},
+})
+
+func TestPendingChanges(t *testing.T) {
+	made := make(chan string, 4)
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		made <- r.Method + " " + r.URL.RequestURI()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.ApprovalRequiredForEnvVar] = "org_deletion,quota_increase"
+	envVars[helpers.ApprovalQuotaThresholdEnvVar] = "10240"
+	router, store := CreateRouterWithMockSession(adminTokenData, envVars)
+
+	response, request := NewTestRequest("DELETE", "/v2/organizations/org-1?recursive=true", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected the org deletion to be parked. Found %d: %s", response.Code, response.Body.String())
+	}
+	var parked struct {
+		ID     string `json:"id"`
+		Class  string `json:"class"`
+		Status string `json:"status"`
+	}
+	json.NewDecoder(response.Body).Decode(&parked)
+	if parked.Class != "org_deletion" || parked.Status != "pending" {
+		t.Errorf("Unexpected pending change %+v", parked)
+	}
+
+	// Changes that don't need approval go through.
+	response, request = NewTestRequest("PUT", "/v2/quota_definitions/quota-1", []byte(`{"memory_limit": 1024}`))
+	router.ServeHTTP(response, request)
+	if made := <-made; made != "PUT /v2/quota_definitions/quota-1" {
+		t.Errorf("Expected the quota change to be made. Found %s", made)
+	}
+
+	response, request = NewTestRequest("PUT", "/v2/quota_definitions/quota-1", []byte(`{"memory_limit": 20480}`))
+	router.ServeHTTP(response, request)
+	var rejected struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(response.Body).Decode(&rejected)
+	if response.Code != http.StatusAccepted {
+		t.Errorf("Expected the quota increase to be parked. Found %d", response.Code)
+	}
+
+	response, request = NewTestRequest("POST", "/admin/pending_changes/"+parked.ID+"/approve", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected admins not to approve their own changes. Found %d", response.Code)
+	}
+
+	switchSession(store, otherAdminTokenData)
+	response, request = NewTestRequest("GET", "/admin/pending_changes", nil)
+	router.ServeHTTP(response, request)
+	if !strings.Contains(response.Body.String(), parked.ID) || !strings.Contains(response.Body.String(), rejected.ID) {
+		t.Errorf("Expected both pending changes. Found %s", response.Body.String())
+	}
+
+	response, request = NewTestRequest("POST", "/admin/pending_changes/"+parked.ID+"/approve", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected the change to be approved. Found %d: %s", response.Code, response.Body.String())
+	}
+	select {
+	case made := <-made:
+		if made != "DELETE /v2/organizations/org-1?recursive=true" {
+			t.Errorf("Expected the org to be deleted. Found %s", made)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the approved change to be made")
+	}
+
+	response, request = NewTestRequest("POST", "/admin/pending_changes/"+parked.ID+"/reject", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected a decided change to conflict. Found %d", response.Code)
+	}
+
+	response, request = NewTestRequest("POST", "/admin/pending_changes/"+rejected.ID+"/reject", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), `"status":"rejected"`) {
+		t.Errorf("Expected the change to be rejected. Found %d: %s", response.Code, response.Body.String())
+	}
+
+	// Only platform admins' changes need approval.
+	switchSession(store, userTokenData)
+	response, request = NewTestRequest("DELETE", "/v2/organizations/org-2", nil)
+	router.ServeHTTP(response, request)
+	if made := <-made; made != "DELETE /v2/organizations/org-2" {
+		t.Errorf("Expected the request to be proxied. Found %s", made)
+	}
+	select {
+	case made := <-made:
+		t.Errorf("Unexpected request %s", made)
+	default:
+	}
+}
+
+func TestDashboardChangesNeedApproval(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v2/organizations/org-1/user_roles":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "user-1"}, "entity": {"organization_roles": ["org_user"]}}]}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/organizations":
+			w.Write([]byte(`{"next_url": null, "resources": []}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/organizations/org-1":
+			w.Write([]byte(`{"metadata": {"guid": "org-1"}, "entity": {"name": "org"}}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/organizations/org-1/managers":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "admin-guid"}}]}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/users/admin-guid/managed_organizations":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "org-1"}}]}`))
+		case r.Method == "POST" || r.Method == "PUT":
+			mu.Lock()
+			calls = append(calls, r.Method+" "+r.URL.Path)
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"metadata": {"guid": "org-2"}}`))
+		default:
+			t.Errorf("Unexpected CC request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cc.Close()
+	uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/token" {
+			w.Write([]byte(`{"access_token": "privileged-token", "token_type": "bearer", "expires_in": 3600}`))
+			return
+		}
+		w.Write([]byte(`{"resources": []}`))
+	}))
+	defer uaa.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.UAAURLEnvVar] = uaa.URL
+	envVars[helpers.ApprovalRequiredForEnvVar] = "admin_role_grant"
+	router, store := CreateRouterWithMockSession(adminTokenData, envVars)
+
+	// A roles change set.
+	response, request := NewTestRequest("POST", "/changesets/roles/plan", []byte(`{"changes": [
+		{"user_guid": "user-1", "org_guid": "org-1", "role": "managers", "action": "add"}
+	]}`))
+	router.ServeHTTP(response, request)
+	plan := response.Body.Bytes()
+	response, request = NewTestRequest("POST", "/changesets/apply", plan)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected the change set to be applied. Found %d: %s", response.Code, response.Body.String())
+	}
+	job := waitForJob(t, router, response.Header().Get("Location"))
+	if !strings.Contains(fmt.Sprint(job["results"]), "pending change") {
+		t.Errorf("Expected the manager role to be parked. Found %v", job["results"])
+	}
+
+	// Org onboarding with a manager.
+	response, request = NewTestRequest("POST", "/changesets/org-onboarding/plan", []byte(`{"name": "new-org", "manager_guids": ["user-2"]}`))
+	router.ServeHTTP(response, request)
+	plan = response.Body.Bytes()
+	response, request = NewTestRequest("POST", "/changesets/apply", plan)
+	router.ServeHTTP(response, request)
+	job = waitForJob(t, router, response.Header().Get("Location"))
+	if !strings.Contains(fmt.Sprint(job["results"]), "pending change") {
+		t.Errorf("Expected the org manager to be parked. Found %v", job["results"])
+	}
+
+	// An approved role request for an org manager role.
+	switchSession(store, userTokenData)
+	response, request = NewTestRequest("POST", "/api/role_requests", []byte(`{"org_guid": "org-1", "role": "managers"}`))
+	router.ServeHTTP(response, request)
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(response.Body).Decode(&created)
+	switchSession(store, adminTokenData)
+	response, request = NewTestRequest("POST", "/api/role_requests/"+created.ID+"/approve", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusAccepted || response.Header().Get("Location") == "" {
+		t.Errorf("Expected the manager role to be parked. Found %d: %s", response.Code, response.Body.String())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, call := range calls {
+		if strings.Contains(call, "/managers/") {
+			t.Errorf("Expected no manager role to be given. Found %s", call)
+		}
+	}
+	response, request = NewTestRequest("GET", "/admin/pending_changes", nil)
+	router.ServeHTTP(response, request)
+	var pending []struct {
+		Path string `json:"path"`
+	}
+	json.NewDecoder(response.Body).Decode(&pending)
+	if len(pending) != 3 {
+		t.Errorf("Expected 3 pending changes. Found %+v", pending)
+	}
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+// noDatabaseMessage is the error of the endpoints that need the database.
+const noDatabaseMessage = "the dashboard has no database, its data is only kept in memory."
+
+// ExportData downloads all the dashboard's own data, such as preferences,
+// audit events and role requests, as a versioned archive. It's for backups and
+// for moving the data to another database service with ImportData or the
+// import subcommand.
+func (c *AdminContext) ExportData(rw web.ResponseWriter, req *web.Request) {
+	if c.Settings.DB == nil {
+		newUaaError(http.StatusConflict, noDatabaseMessage).writeTo(rw)
+		return
+	}
+	archive, err := db.Export(c.Settings.DB, c.Settings.DBCipher)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "export_data", archive.Summary())
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="dashboard-data-%s.json"`,
+		archive.CreatedAt.Format("20060102-150405")))
+	rw.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(rw).Encode(archive)
+}
+
+// ImportData replaces all the dashboard's own data with the archive in the
+// body, as downloaded from ExportData.
+func (c *AdminContext) ImportData(rw web.ResponseWriter, req *web.Request) {
+	if c.Settings.DB == nil {
+		newUaaError(http.StatusConflict, noDatabaseMessage).writeTo(rw)
+		return
+	}
+	var archive db.Archive
+	if err := readBodyToStruct(req.Body, &archive); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	if archive.Format != db.ArchiveFormat {
+		newUaaError(http.StatusBadRequest, fmt.Sprintf("unsupported archive format %d.", archive.Format)).writeTo(rw)
+		return
+	}
+	if err := db.Import(c.Settings.DB, archive, c.Settings.DBCipher); err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	summary := archive.Summary()
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "import_data", summary)
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(summary)
+}
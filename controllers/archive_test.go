@@ -0,0 +1,32 @@
+package controllers_test
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestDataArchiveWithoutDatabase(t *testing.T) {
+	router, _ := CreateRouterWithMockSession(adminTokenData, GetMockCompleteEnvVars())
+	for _, test := range []struct {
+		method, path string
+		body         []byte
+	}{
+		{"GET", "/admin/export", nil},
+		{"POST", "/admin/import", []byte(`{"format": 1}`)},
+	} {
+		response, request := NewTestRequest(test.method, test.path, test.body)
+		router.ServeHTTP(response, request)
+		if response.Code != http.StatusConflict {
+			t.Errorf("%s %s: expected code %d. Found %d", test.method, test.path, http.StatusConflict, response.Code)
+		}
+	}
+
+	router, _ = CreateRouterWithMockSession(userTokenData, GetMockCompleteEnvVars())
+	response, request := NewTestRequest("GET", "/admin/export", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected code %d for a non-admin. Found %d", http.StatusForbidden, response.Code)
+	}
+}
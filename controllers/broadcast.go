@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+const (
+	// broadcastBatchSize is the number of emails sent by a single task of a
+	// broadcast job.
+	broadcastBatchSize = 50
+	// scimFilterSize is the number of users looked up in a single SCIM query.
+	scimFilterSize = 50
+)
+
+// broadcastRequest is the body to preview or send a broadcast email.
+type broadcastRequest struct {
+	OrgGUIDs   []string `json:"org_guids"`
+	SpaceGUIDs []string `json:"space_guids"`
+	Subject    string   `json:"subject"`
+	Message    string   `json:"message"`
+}
+
+// scimUser is a partial representation of a UAA SCIM user.
+type scimUser struct {
+	ID     string `json:"id"`
+	Emails []struct {
+		Value   string `json:"value"`
+		Primary bool   `json:"primary"`
+	} `json:"emails"`
+}
+
+// readBroadcast reads and validates the broadcast request.
+func readBroadcast(req *web.Request) (*broadcastRequest, *UaaError) {
+	var broadcast broadcastRequest
+	if err := readBodyToStruct(req.Body, &broadcast); err != nil {
+		return nil, err
+	}
+	if len(broadcast.OrgGUIDs) == 0 && len(broadcast.SpaceGUIDs) == 0 {
+		return nil, newUaaError(http.StatusBadRequest, "org_guids or space_guids are required.")
+	}
+	if strings.TrimSpace(broadcast.Subject) == "" || strings.TrimSpace(broadcast.Message) == "" {
+		return nil, newUaaError(http.StatusBadRequest, "subject and message are required.")
+	}
+	return &broadcast, nil
+}
+
+// broadcastRecipients returns the sorted, unique email addresses of all the
+// users with a role in the orgs or spaces.
+func (c *AdminContext) broadcastRecipients(broadcast *broadcastRequest) ([]string, error) {
+	var paths []string
+	for _, guid := range broadcast.OrgGUIDs {
+		paths = append(paths, "/v2/organizations/"+url.PathEscape(guid)+"/user_roles")
+	}
+	for _, guid := range broadcast.SpaceGUIDs {
+		paths = append(paths, "/v2/spaces/"+url.PathEscape(guid)+"/user_roles")
+	}
+	userIDs := make(map[string]bool)
+	for _, path := range paths {
+		users, err := c.ccGetAll(path + "?results-per-page=100")
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			userIDs[user.Metadata.GUID] = true
+		}
+	}
+	ids := make([]string, 0, len(userIDs))
+	for id := range userIDs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	emails := make(map[string]bool)
+	for start := 0; start < len(ids); start += scimFilterSize {
+		end := start + scimFilterSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		users, err := c.scimUsers(ids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			if email := primaryEmail(user); email != "" {
+				emails[strings.ToLower(email)] = true
+			}
+		}
+	}
+	recipients := make([]string, 0, len(emails))
+	for email := range emails {
+		recipients = append(recipients, email)
+	}
+	sort.Strings(recipients)
+	return recipients, nil
+}
+
+// scimUsers looks up the users by ID in UAA with the dashboard's credentials.
+func (c *SecureContext) scimUsers(ids []string) ([]scimUser, error) {
+	filters := make([]string, len(ids))
+	for i, id := range ids {
+		// Per https://tools.ietf.org/html/rfc7644#section-3.4.2.2, the value
+		// format in a SCIM query is JSON format.
+		idJSON, err := json.Marshal(id)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = fmt.Sprintf("id eq %s", idJSON)
+	}
+	reqURL := fmt.Sprintf("%s/Users?%s", c.Settings.UaaURL, url.Values{
+		"filter":     {strings.Join(filters, " or ")},
+		"attributes": {"id,emails"},
+		"count":      {fmt.Sprint(len(ids))},
+	}.Encode())
+	req, _ := http.NewRequest("GET", reqURL, nil)
+	w := httptest.NewRecorder()
+	c.PrivilegedProxy(w, req, reqURL, c.GenericResponseHandler)
+	if w.Code != http.StatusOK {
+		return nil, fmt.Errorf("unable to look up users: status %d", w.Code)
+	}
+	var resp struct {
+		Resources []scimUser `json:"resources"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return resp.Resources, nil
+}
+
+// primaryEmail returns the primary email of the user, or the first one if
+// none is marked as primary.
+func primaryEmail(user scimUser) string {
+	for _, email := range user.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(user.Emails) > 0 {
+		return user.Emails[0].Value
+	}
+	return ""
+}
+
+// PreviewBroadcast renders the broadcast email and lists who would receive it
+// without sending anything.
+func (c *AdminContext) PreviewBroadcast(rw web.ResponseWriter, req *web.Request) {
+	broadcast, uaaErr := readBroadcast(req)
+	if uaaErr != nil {
+		uaaErr.writeTo(rw)
+		return
+	}
+	recipients, err := c.broadcastRecipients(broadcast)
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	body := new(bytes.Buffer)
+	if err := c.templates.GetBroadcastEmail(body, broadcast.Subject, broadcast.Message); err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(struct {
+		Subject    string   `json:"subject"`
+		HTML       string   `json:"html"`
+		Recipients []string `json:"recipients"`
+	}{
+		Subject:    broadcast.Subject,
+		HTML:       body.String(),
+		Recipients: recipients,
+	})
+}
+
+// SendBroadcast queues the broadcast email to everyone with a role in the
+// orgs or spaces. Emails are sent in throttled batches by a job and the
+// broadcast is recorded in the audit log.
+func (c *AdminContext) SendBroadcast(rw web.ResponseWriter, req *web.Request) {
+	broadcast, uaaErr := readBroadcast(req)
+	if uaaErr != nil {
+		uaaErr.writeTo(rw)
+		return
+	}
+	recipients, err := c.broadcastRecipients(broadcast)
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	body := new(bytes.Buffer)
+	if err := c.templates.GetBroadcastEmail(body, broadcast.Subject, broadcast.Message); err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+
+	var tasks []jobs.Task
+	for start := 0; start < len(recipients); start += broadcastBatchSize {
+		end := start + broadcastBatchSize
+		if end > len(recipients) {
+			end = len(recipients)
+		}
+		batch := recipients[start:end]
+		tasks = append(tasks, jobs.Task{
+			Name: fmt.Sprintf("recipients %d-%d", start+1, end),
+			Run: func() error {
+				failed := 0
+				for _, email := range batch {
+					if err := c.mailer.SendEmail(email, broadcast.Subject, body.Bytes()); err != nil {
+						failed++
+					}
+				}
+				if failed > 0 {
+					return fmt.Errorf("failed to send %d of %d emails", failed, len(batch))
+				}
+				return nil
+			},
+		})
+	}
+
+	job, ok := c.submitJob(rw, "broadcast-email", tasks)
+	if !ok {
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "broadcast_email", struct {
+		JobID      string   `json:"job_id"`
+		OrgGUIDs   []string `json:"org_guids"`
+		SpaceGUIDs []string `json:"space_guids"`
+		Subject    string   `json:"subject"`
+		Recipients int      `json:"recipients"`
+	}{
+		JobID:      job.ID,
+		OrgGUIDs:   broadcast.OrgGUIDs,
+		SpaceGUIDs: broadcast.SpaceGUIDs,
+		Subject:    broadcast.Subject,
+		Recipients: len(recipients),
+	})
+}
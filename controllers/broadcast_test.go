@@ -0,0 +1,135 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func newBroadcastServers(t *testing.T) (cc, uaa *httptest.Server) {
+	cc = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/organizations/org-guid/user_roles":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "user-1"}}, {"metadata": {"guid": "user-2"}}]}`))
+		case "/v2/spaces/space-guid/user_roles":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "user-2"}}, {"metadata": {"guid": "user-3"}}]}`))
+		default:
+			t.Errorf("Unexpected CC request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	uaa = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/token":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token": "privileged-token", "token_type": "bearer", "expires_in": 3600}`))
+		case "/Users":
+			if r.Header.Get("Authorization") != "Bearer privileged-token" {
+				t.Errorf("Expected the users to be looked up with the dashboard's credentials")
+			}
+			expected := `id eq "user-1" or id eq "user-2" or id eq "user-3"`
+			if filter := r.URL.Query().Get("filter"); filter != expected {
+				t.Errorf("Expected filter %s. Found %s", expected, filter)
+			}
+			w.Write([]byte(`{"resources": [
+				{"id": "user-1", "emails": [{"value": "one@example.com", "primary": true}]},
+				{"id": "user-2", "emails": [{"value": "Two@example.com"}]},
+				{"id": "user-3", "emails": [{"value": "old@example.com"}, {"value": "three@example.com", "primary": true}]}
+			]}`))
+		default:
+			t.Errorf("Unexpected UAA request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return cc, uaa
+}
+
+func TestPreviewBroadcast(t *testing.T) {
+	cc, uaa := newBroadcastServers(t)
+	defer cc.Close()
+	defer uaa.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.UAAURLEnvVar] = uaa.URL
+	router, _ := CreateRouterWithMockSession(adminTokenData, envVars)
+
+	response, request := NewTestRequest("POST", "/admin/broadcast/preview",
+		[]byte(`{"org_guids": ["org-guid"], "space_guids": ["space-guid"], "subject": "Maintenance", "message": "Upgrade on Saturday."}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+	var preview struct {
+		Subject    string   `json:"subject"`
+		HTML       string   `json:"html"`
+		Recipients []string `json:"recipients"`
+	}
+	json.NewDecoder(response.Body).Decode(&preview)
+	expected := []string{"one@example.com", "three@example.com", "two@example.com"}
+	if strings.Join(preview.Recipients, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected recipients %v. Found %v", expected, preview.Recipients)
+	}
+	if !strings.Contains(preview.HTML, "Upgrade on Saturday.") {
+		t.Errorf("Expected the rendered message in the preview. Found %s", preview.HTML)
+	}
+
+	response, request = NewTestRequest("POST", "/admin/broadcast/preview",
+		[]byte(`{"org_guids": ["org-guid"], "subject": "Maintenance"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected code %d without a message. Found %d", http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestSendBroadcast(t *testing.T) {
+	cc, uaa := newBroadcastServers(t)
+	defer cc.Close()
+	defer uaa.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.UAAURLEnvVar] = uaa.URL
+
+	// Only admins can send broadcasts.
+	response, request := NewTestRequest("POST", "/admin/broadcast",
+		[]byte(`{"org_guids": ["org-guid"], "subject": "Maintenance", "message": "Upgrade on Saturday."}`))
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected code %d. Found %d", http.StatusForbidden, response.Code)
+	}
+
+	response, request = NewTestRequest("POST", "/admin/broadcast",
+		[]byte(`{"org_guids": ["org-guid"], "space_guids": ["space-guid"], "subject": "Maintenance", "message": "Upgrade on Saturday."}`))
+	router, _ = CreateRouterWithMockSession(adminTokenData, envVars)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusAccepted, response.Code, response.Body.String())
+	}
+	var job struct {
+		ID      string `json:"id"`
+		Kind    string `json:"kind"`
+		Status  string `json:"status"`
+		Results []struct {
+			Name string `json:"name"`
+		} `json:"results"`
+	}
+	json.NewDecoder(response.Body).Decode(&job)
+	if job.Kind != "broadcast-email" || len(job.Results) != 1 || job.Results[0].Name != "recipients 1-3" {
+		t.Fatalf("Unexpected job %+v", job)
+	}
+	for i := 0; i < 100 && job.Status != "succeeded" && job.Status != "failed"; i++ {
+		time.Sleep(10 * time.Millisecond)
+		response, request = NewTestRequest("GET", "/jobs/"+job.ID, nil)
+		router.ServeHTTP(response, request)
+		json.NewDecoder(response.Body).Decode(&job)
+	}
+	if job.Status != "succeeded" {
+		t.Errorf("Expected the broadcast to be sent. Found %+v", job)
+	}
+}
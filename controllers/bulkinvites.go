@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// maxBulkInviteBody is the largest body of a bulk invite.
+const maxBulkInviteBody = 1 << 20
+
+// The statuses of the addresses of a bulk invite.
+const (
+	bulkInviteInvited  = "invited"
+	bulkInviteExisting = "existing"
+	bulkInviteRejected = "rejected"
+	bulkInviteFailed   = "failed"
+)
+
+// bulkInviteResult is the outcome of inviting one address of a bulk invite.
+type bulkInviteResult struct {
+	Email    string `json:"email"`
+	Status   string `json:"status"`
+	UserGUID string `json:"userGuid,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// bulkInviteResponse is the response to a bulk invite, with the results in
+// the order of the addresses.
+type bulkInviteResponse struct {
+	Status   string             `json:"status"`
+	Invited  int                `json:"invited"`
+	Existing int                `json:"existing"`
+	Failed   int                `json:"failed"`
+	Results  []bulkInviteResult `json:"results"`
+}
+
+// BulkInviteUsers invites many users at once, like InviteUserToOrg does one.
+// The body is a JSON array of e-mails, or a CSV of them when its content type
+// is text/csv. The addresses are checked against the allowed domains first,
+// then invited concurrently at the pace of the invite policy. It responds
+// with the outcome of each address; the status is "success" only when none
+// was rejected or failed.
+func (c *UAAContext) BulkInviteUsers(rw web.ResponseWriter, req *web.Request) {
+	if req.Body == nil {
+		newUaaError(http.StatusBadRequest, "no body in request.").writeTo(rw)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBulkInviteBody+1))
+	if err != nil {
+		newUaaError(http.StatusBadRequest, err.Error()).writeTo(rw)
+		return
+	}
+	if len(body) > maxBulkInviteBody {
+		newUaaError(http.StatusRequestEntityTooLarge, "the list of e-mails is too large.").writeTo(rw)
+		return
+	}
+	policy := c.Settings.Invites
+	addresses, err := helpers.ParseInviteAddresses(req.Header.Get("Content-Type"), body)
+	if err != nil {
+		newUaaError(http.StatusBadRequest, err.Error()+".").writeTo(rw)
+		return
+	}
+	if len(addresses) == 0 {
+		newUaaError(http.StatusBadRequest, "no e-mails to invite.").writeTo(rw)
+		return
+	}
+	if len(addresses) > policy.MaxBulk {
+		newUaaError(http.StatusBadRequest, "at most "+strconv.Itoa(policy.MaxBulk)+" e-mails can be invited at once.").writeTo(rw)
+		return
+	}
+
+	results := make([]bulkInviteResult, len(addresses))
+	var tasks []func() error
+	for i, address := range addresses {
+		email, checkErr := policy.CheckAddress(address)
+		if checkErr != nil {
+			helpers.RecordInvite(helpers.InviteRejected)
+			results[i] = bulkInviteResult{Email: address, Status: bulkInviteRejected, Error: checkErr.Error()}
+			continue
+		}
+		results[i].Email = email
+		result := &results[i]
+		tasks = append(tasks, func() error {
+			policy.Wait()
+			user, uaaErr := c.inviteUser(req.Request, result.Email)
+			switch {
+			case uaaErr != nil:
+				result.Status, result.Error = bulkInviteFailed, uaaErr.data
+			case user.Verified:
+				result.Status, result.UserGUID = bulkInviteExisting, user.ID
+			default:
+				result.Status, result.UserGUID = bulkInviteInvited, user.ID
+			}
+			return nil
+		})
+	}
+	c.Settings.Workers.Run(c.userID(), tasks)
+
+	response := bulkInviteResponse{Status: "success", Results: results}
+	for _, result := range results {
+		switch result.Status {
+		case bulkInviteInvited:
+			response.Invited++
+		case bulkInviteExisting:
+			response.Existing++
+		default:
+			response.Failed++
+			response.Status = "partial"
+		}
+	}
+	if response.Invited+response.Existing == 0 {
+		response.Status = "failure"
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "bulk_invite", struct {
+		Invited  int `json:"invited"`
+		Existing int `json:"existing"`
+		Failed   int `json:"failed"`
+	}{response.Invited, response.Existing, response.Failed})
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(response)
+}
@@ -0,0 +1,367 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// ccResource is the envelope of a single resource from the v2 CF API.
+type ccResource struct {
+	Metadata struct {
+		GUID      string `json:"guid"`
+		CreatedAt string `json:"created_at"`
+		UpdatedAt string `json:"updated_at"`
+	} `json:"metadata"`
+	Entity json.RawMessage `json:"entity"`
+}
+
+// ccPage is a single page of v2 CF API resources.
+type ccPage struct {
+	TotalPages int          `json:"total_pages"`
+	NextURL    *string      `json:"next_url"`
+	Resources  []ccResource `json:"resources"`
+}
+
+// ccV3Page is a single page of v3 CF API resources.
+type ccV3Page struct {
+	Pagination struct {
+		Next *struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"pagination"`
+	Resources []json.RawMessage `json:"resources"`
+}
+
+// ccError is returned when the CF API responds with a non 2xx status.
+type ccError struct {
+	Method string
+	Path   string
+	Code   int
+	// RequestID is the CF API's ID of the request, if it sent one.
+	RequestID string
+}
+
+func (e *ccError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("%s %s failed with status %d (CF request ID %s)", e.Method, e.Path, e.Code, e.RequestID)
+	}
+	return fmt.Sprintf("%s %s failed with status %d", e.Method, e.Path, e.Code)
+}
+
+// isCCNotFound returns true if the error is a CF API 404.
+func isCCNotFound(err error) bool {
+	e, ok := err.(*ccError)
+	return ok && e.Code == http.StatusNotFound
+}
+
+// ccErrorStatus returns the status of a CF API client error, such as 403 or
+// 422, so it's passed on to the user, and 502 for any other error.
+func ccErrorStatus(err error) int {
+	if e, ok := err.(*ccError); ok && e.Code >= 400 && e.Code < 500 {
+		return e.Code
+	}
+	return http.StatusBadGateway
+}
+
+// parkedChangeError is returned for a CF API change that was parked for the
+// approval of another admin instead of being made.
+type parkedChangeError struct {
+	Change db.PendingChange
+}
+
+func (e *parkedChangeError) Error() string {
+	return fmt.Sprintf("%s %s needs the approval of another admin, it is pending change %s",
+		e.Change.Method, e.Change.Path, e.Change.ID)
+}
+
+// ccRequest sends a request with the user's credentials to the CF API path
+// and decodes the JSON response into v (if not nil). Changes of platform
+// admins that the approval policy holds are parked instead, with a
+// *parkedChangeError, so the dashboard's own endpoints can't bypass it.
+func (c *SecureContext) ccRequest(method, path string, body, v interface{}) error {
+	if err := c.parkCCRequest(method, path, body); err != nil {
+		return err
+	}
+	return c.approvedCCRequest(method, path, body, v)
+}
+
+// parkCCRequest parks the change if it needs the approval of another admin,
+// returning a *parkedChangeError.
+func (c *SecureContext) parkCCRequest(method, path string, body interface{}) error {
+	policy := c.Settings.ApprovalPolicy
+	if policy == nil || method == "GET" || method == "HEAD" || !c.hasScope(adminScope) {
+		return nil
+	}
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	class := policy.Classify(method, strings.SplitN(path, "?", 2)[0], b)
+	if class == "" {
+		return nil
+	}
+	change, err := c.parkChange(nil, class, method, path, b)
+	if err != nil {
+		return err
+	}
+	return &parkedChangeError{Change: change}
+}
+
+// approvedCCRequest is ccRequest without the approval policy, for changes
+// that were approved.
+func (c *SecureContext) approvedCCRequest(method, path string, body, v interface{}) error {
+	return c.sendCCRequest(c.Proxy, method, path, body, v)
+}
+
+// privilegedCCRequest is like approvedCCRequest with the dashboard's own
+// credentials, for requests made after the user's token may have expired,
+// e.g. in scheduled jobs. The dashboard's own endpoints check the user may
+// make the request before calling it.
+func (c *SecureContext) privilegedCCRequest(method, path string, body, v interface{}) error {
+	return c.sendCCRequest(c.PrivilegedProxy, method, path, body, v)
+}
+
+// sendCCRequest sends the CF API request through the proxy and decodes the
+// JSON response into v (if not nil).
+func (c *SecureContext) sendCCRequest(proxy func(http.ResponseWriter, *http.Request, string, ResponseHandler),
+	method, path string, body, v interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	reqURL := c.Settings.ConsoleAPI + path
+	req, _ := http.NewRequest(method, reqURL, reqBody)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	var copyErr error
+	proxy(w, req, reqURL, func(rw http.ResponseWriter, res *http.Response) {
+		copyErr = c.copyResponse(rw, res)
+	})
+	size := float64(w.Body.Len())
+	ccBufferedBytes.Add(size)
+	defer ccBufferedBytes.Sub(size)
+	if copyErr == errResponseTooLarge {
+		return fmt.Errorf("%s %s failed: %v", method, path, copyErr)
+	}
+	if w.Code < 200 || w.Code > 299 {
+		return &ccError{Method: method, Path: path, Code: w.Code, RequestID: w.Header().Get(cfRequestIDHeader)}
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(w.Body).Decode(v)
+}
+
+// ccGetAll lists all the resources at the CF API path, following the
+// pagination. Once the number of pages is known, the other pages are fetched
+// concurrently.
+func (c *SecureContext) ccGetAll(path string) ([]ccResource, error) {
+	var first ccPage
+	if err := c.ccRequest("GET", path, nil, &first); err != nil {
+		return nil, err
+	}
+	resources := first.Resources
+	if first.NextURL == nil {
+		return resources, nil
+	}
+	next, err := url.Parse(*first.NextURL)
+	if err != nil || first.TotalPages < 2 {
+		// Without the page numbers, walk the pages one after the other.
+		for next := first.NextURL; next != nil; {
+			var page ccPage
+			if err := c.ccRequest("GET", *next, nil, &page); err != nil {
+				return nil, err
+			}
+			resources = append(resources, page.Resources...)
+			next = page.NextURL
+		}
+		return resources, nil
+	}
+
+	pages := make([]ccPage, first.TotalPages-1)
+	tasks := make([]func() error, len(pages))
+	for i := range pages {
+		query := next.Query()
+		query.Set("page", strconv.Itoa(i+2))
+		pageURL := *next
+		pageURL.RawQuery = query.Encode()
+		page := &pages[i]
+		tasks[i] = func() error {
+			return c.ccRequest("GET", pageURL.RequestURI(), nil, page)
+		}
+	}
+	if err := c.fanOut(tasks); err != nil {
+		return nil, err
+	}
+	for _, page := range pages {
+		resources = append(resources, page.Resources...)
+	}
+	return resources, nil
+}
+
+// ccEach calls fn for each resource at the v2 CF API path, following the
+// pagination. Pages are streamed: fn decodes each resource from the decoder
+// into a struct with just the fields it needs, so large responses (e.g. apps
+// with big environments) are never held in memory as a whole.
+func (c *SecureContext) ccEach(path string, fn func(dec *json.Decoder) error) error {
+	for next := &path; next != nil; {
+		var err error
+		next, err = c.ccStreamPage(*next, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ccStreamPage gets a v2 page of resources, calling fn for each resource as
+// it's read, and returns the URL of the next page.
+func (c *SecureContext) ccStreamPage(path string, fn func(dec *json.Decoder) error) (next *string, err error) {
+	reqURL := c.Settings.ConsoleAPI + path
+	req, _ := http.NewRequest("GET", reqURL, nil)
+	w := httptest.NewRecorder()
+	streamed := false
+	c.Proxy(w, req, reqURL, func(rw http.ResponseWriter, res *http.Response) {
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			rw.WriteHeader(res.StatusCode)
+			return
+		}
+		streamed = true
+		next, err = walkCCPage(json.NewDecoder(res.Body), fn)
+	})
+	if !streamed {
+		return nil, &ccError{Method: "GET", Path: path, Code: w.Code, RequestID: w.Header().Get(cfRequestIDHeader)}
+	}
+	return next, err
+}
+
+// walkCCPage reads a v2 page token by token, handing each resource to fn.
+func walkCCPage(dec *json.Decoder, fn func(dec *json.Decoder) error) (next *string, err error) {
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "next_url":
+			err = dec.Decode(&next)
+		case "resources":
+			if err = expectDelim(dec, '['); err != nil {
+				return nil, err
+			}
+			for dec.More() {
+				if err = fn(dec); err != nil {
+					return nil, err
+				}
+			}
+			err = expectDelim(dec, ']')
+		default:
+			var skipped json.RawMessage
+			err = dec.Decode(&skipped)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return next, expectDelim(dec, '}')
+}
+
+// expectDelim reads the next token, which must be the delimiter.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("unexpected %v in CF API response, expected %v", tok, delim)
+	}
+	return nil
+}
+
+// fanOut runs the tasks with the shared workers, within the user's share of
+// them, and returns the first error.
+func (c *SecureContext) fanOut(tasks []func() error) error {
+	return c.Settings.Workers.Run(c.userID(), tasks)
+}
+
+// ccGetAllV3 lists all the resources at the v3 CF API path, following the
+// pagination.
+func (c *SecureContext) ccGetAllV3(path string) ([]json.RawMessage, error) {
+	var resources []json.RawMessage
+	for next := path; next != ""; {
+		var page ccV3Page
+		if err := c.ccRequest("GET", next, nil, &page); err != nil {
+			return nil, err
+		}
+		resources = append(resources, page.Resources...)
+		next = ""
+		if page.Pagination.Next != nil {
+			// Unlike v2, v3 links are absolute.
+			next = strings.TrimPrefix(page.Pagination.Next.Href, c.Settings.ConsoleAPI)
+		}
+	}
+	return resources, nil
+}
+
+// ccGetOne returns the only resource at the CF API path, e.g. a lookup by name.
+func (c *SecureContext) ccGetOne(path string) (*ccResource, error) {
+	resources, err := c.ccGetAll(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(resources) != 1 {
+		return nil, errors.New("resource not found")
+	}
+	return &resources[0], nil
+}
+
+// userID returns the UAA user ID from the user's access token, or what UAA's
+// token introspection says for an opaque token. It's an empty string if
+// neither says.
+func (c *SecureContext) userID() string {
+	if claims, err := helpers.ParseTokenClaims(c.Token.AccessToken); err == nil {
+		return claims.UserID
+	}
+	if c.Settings.TokenIntrospector == nil {
+		return ""
+	}
+	userID, _ := c.Settings.TokenIntrospector.UserID(c.Token.AccessToken)
+	return userID
+}
+
+// managesOrg returns true if the user is a manager of the org.
+func (c *SecureContext) managesOrg(orgGUID string) (bool, *UaaError) {
+	orgs, err := c.ccGetAll("/v2/users/" + url.PathEscape(c.userID()) + "/managed_organizations?results-per-page=100")
+	if err != nil {
+		return false, newUaaError(http.StatusBadGateway, err.Error())
+	}
+	for _, org := range orgs {
+		if org.Metadata.GUID == orgGUID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
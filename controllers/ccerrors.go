@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// The stable dashboard codes of the CF API errors.
+const (
+	errorQuotaExceeded = "quota_exceeded"
+	errorNameTaken     = "name_taken"
+	errorNotAuthorized = "not_authorized"
+	// errorCF is any other CF API error.
+	errorCF = "cf_error"
+)
+
+// maxCCErrorBytes is the largest CF API error body that is normalized.
+// Larger ones are passed on as they are.
+const maxCCErrorBytes = 64 << 10
+
+// ccErrorCodes maps the CF API error codes, e.g. CF-AppNameTaken, to the
+// dashboard codes.
+var ccErrorCodes = map[string]string{
+	"CF-AppMemoryQuotaExceeded":                 errorQuotaExceeded,
+	"CF-OrgQuotaTotalMemoryExceeded":            errorQuotaExceeded,
+	"CF-SpaceQuotaTotalMemoryExceeded":          errorQuotaExceeded,
+	"CF-QuotaInstanceMemoryLimitExceeded":       errorQuotaExceeded,
+	"CF-SpaceQuotaInstanceMemoryLimitExceeded":  errorQuotaExceeded,
+	"CF-OrgQuotaTotalRoutesExceeded":            errorQuotaExceeded,
+	"CF-SpaceQuotaTotalRoutesExceeded":          errorQuotaExceeded,
+	"CF-ServiceInstanceQuotaExceeded":           errorQuotaExceeded,
+	"CF-ServiceInstanceSpaceQuotaExceeded":      errorQuotaExceeded,
+	"CF-AppNameTaken":                           errorNameTaken,
+	"CF-SpaceNameTaken":                         errorNameTaken,
+	"CF-OrganizationNameTaken":                  errorNameTaken,
+	"CF-ServiceInstanceNameTaken":               errorNameTaken,
+	"CF-RouteHostTaken":                         errorNameTaken,
+	"CF-DomainNameTaken":                        errorNameTaken,
+	"CF-NotAuthorized":                          errorNotAuthorized,
+	"CF-InsufficientScope":                      errorNotAuthorized,
+	"CF-ServiceInstanceNotAuthorizedToAccessIt": errorNotAuthorized,
+}
+
+// ccErrorMessages are the messages shown for the dashboard codes.
+var ccErrorMessages = map[string]string{
+	errorQuotaExceeded: "This would go over your org or space quota. Free up resources, or ask an org manager to raise the quota.",
+	errorNameTaken:     "That name is already taken. Choose another name.",
+	errorNotAuthorized: "You don't have permission to do this. Ask an org or space manager for the role you need.",
+}
+
+// dashboardError is a CF API error translated for users. It keeps the fields
+// of v2 errors that the frontend uses.
+type dashboardError struct {
+	Code      int    `json:"code"`
+	ErrorCode string `json:"error_code"`
+	Title     string `json:"title"`
+	// Description is the message shown to users.
+	Description string `json:"description"`
+	// DashboardCode is the stable code of the error, e.g. quota_exceeded.
+	DashboardCode string `json:"dashboard_code"`
+	// Raw is the CF API's own error, for users who want the detail.
+	Raw json.RawMessage `json:"raw"`
+}
+
+// normalizeCCError translates a v2 or v3 CF API error body. It returns false
+// if the body isn't a CF API error.
+func normalizeCCError(status int, body []byte) (dashboardError, bool) {
+	var parsed struct {
+		// v2 errors.
+		Code        int    `json:"code"`
+		ErrorCode   string `json:"error_code"`
+		Description string `json:"description"`
+		// v3 errors.
+		Errors []struct {
+			Code   int    `json:"code"`
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &parsed) != nil {
+		return dashboardError{}, false
+	}
+	if len(parsed.Errors) > 0 {
+		parsed.Code = parsed.Errors[0].Code
+		parsed.ErrorCode = parsed.Errors[0].Title
+		parsed.Description = parsed.Errors[0].Detail
+	}
+	if !strings.HasPrefix(parsed.ErrorCode, "CF-") {
+		return dashboardError{}, false
+	}
+	e := dashboardError{
+		Code:          parsed.Code,
+		ErrorCode:     parsed.ErrorCode,
+		Title:         parsed.ErrorCode,
+		Description:   parsed.Description,
+		DashboardCode: ccErrorCodes[parsed.ErrorCode],
+		Raw:           json.RawMessage(body),
+	}
+	// v3 reports most validation errors as unprocessable, with the reason in
+	// the detail.
+	detail := strings.ToLower(parsed.Description)
+	switch {
+	case e.DashboardCode != "":
+	case strings.Contains(detail, "quota"):
+		e.DashboardCode = errorQuotaExceeded
+	case strings.Contains(detail, "must be unique") || strings.Contains(detail, "is taken"):
+		e.DashboardCode = errorNameTaken
+	case status == http.StatusForbidden:
+		e.DashboardCode = errorNotAuthorized
+	default:
+		e.DashboardCode = errorCF
+	}
+	if message, ok := ccErrorMessages[e.DashboardCode]; ok {
+		e.Description = message
+	} else if status >= 500 {
+		// Server errors only have internal detail.
+		e.Description = "Cloud Foundry couldn't complete the request. Try again later."
+	}
+	return e, true
+}
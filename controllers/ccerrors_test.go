@@ -0,0 +1,71 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestCCErrorNormalization(t *testing.T) {
+	errors := map[string]struct {
+		status int
+		body   string
+	}{
+		"/v2/quota":     {http.StatusBadRequest, `{"code": 100005, "description": "You have exceeded your organization's memory limit: app requested more memory than available", "error_code": "CF-OrgQuotaTotalMemoryExceeded"}`},
+		"/v2/taken":     {http.StatusBadRequest, `{"code": 100002, "description": "The app name is taken: web", "error_code": "CF-AppNameTaken"}`},
+		"/v2/forbidden": {http.StatusForbidden, `{"code": 10003, "description": "You are not authorized to perform the requested action", "error_code": "CF-NotAuthorized"}`},
+		"/v2/v3taken":   {http.StatusUnprocessableEntity, `{"errors": [{"code": 10008, "title": "CF-UnprocessableEntity", "detail": "name must be unique in space"}]}`},
+		"/v2/invalid":   {http.StatusBadRequest, `{"code": 1001, "description": "Request invalid due to parse error: invalid request", "error_code": "CF-MessageParseError"}`},
+		"/v2/broken":    {http.StatusInternalServerError, `{"code": 10001, "description": "An unknown error occurred: Sequel::DatabaseError", "error_code": "UnknownError"}`},
+		"/v2/crashed":   {http.StatusInternalServerError, `{"code": 10001, "description": "Sequel::DatabaseError at ccdb-1", "error_code": "CF-ServerError"}`},
+	}
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := errors[r.URL.Path]
+		w.WriteHeader(e.status)
+		w.Write([]byte(e.body))
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	for _, test := range []struct {
+		path          string
+		dashboardCode string
+		description   string
+	}{
+		{"/v2/quota", "quota_exceeded", "This would go over your org or space quota. Free up resources, or ask an org manager to raise the quota."},
+		{"/v2/taken", "name_taken", "That name is already taken. Choose another name."},
+		{"/v2/forbidden", "not_authorized", "You don't have permission to do this. Ask an org or space manager for the role you need."},
+		{"/v2/v3taken", "name_taken", "That name is already taken. Choose another name."},
+		{"/v2/invalid", "cf_error", "Request invalid due to parse error: invalid request"},
+		{"/v2/crashed", "cf_error", "Cloud Foundry couldn't complete the request. Try again later."},
+	} {
+		response, request := NewTestRequest("GET", test.path, nil)
+		router.ServeHTTP(response, request)
+		var body struct {
+			ErrorCode     string          `json:"error_code"`
+			Description   string          `json:"description"`
+			DashboardCode string          `json:"dashboard_code"`
+			Raw           json.RawMessage `json:"raw"`
+		}
+		json.NewDecoder(response.Body).Decode(&body)
+		if response.Code != errors[test.path].status || body.DashboardCode != test.dashboardCode || body.Description != test.description {
+			t.Errorf("%s: unexpected error %d %+v", test.path, response.Code, body)
+		}
+		if !NewJSONResponseContentTester(errors[test.path].body).Check(t, string(body.Raw)) {
+			t.Errorf("%s: expected the raw error. Found %s", test.path, body.Raw)
+		}
+	}
+
+	// Errors that aren't from the CF API are passed on as they are.
+	response, request := NewTestRequest("GET", "/v2/broken", nil)
+	router.ServeHTTP(response, request)
+	if !NewJSONResponseContentTester(errors["/v2/broken"].body).Check(t, response.Body.String()) {
+		t.Errorf("Expected the error as it is. Found %s", response.Body.String())
+	}
+}
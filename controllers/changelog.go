@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// ChangelogContext stores the session info and access token per user.
+// All routes within ChangelogContext show the release notes to users after
+// deploys.
+type ChangelogContext struct {
+	*SecureContext // Required.
+}
+
+// changelogResponse is the changelog, and what's new to the user.
+type changelogResponse struct {
+	Entries         []db.ChangelogEntry `json:"entries"`
+	LatestVersion   string              `json:"latest_version"`
+	LastSeenVersion string              `json:"last_seen_version"`
+	// Unseen is how many entries are newer than the last seen version.
+	Unseen int `json:"unseen"`
+}
+
+// changelogSeenBody is the body to mark a version as seen.
+type changelogSeenBody struct {
+	Version string `json:"version"`
+}
+
+// writeChangelogJSON responds with the changelog or an entry.
+func writeChangelogJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(v)
+}
+
+// unseenChangelogEntries returns how many of the entries, newest first, are
+// newer than the version.
+func unseenChangelogEntries(entries []db.ChangelogEntry, lastSeen string) int {
+	if lastSeen == "" {
+		return len(entries)
+	}
+	for i, e := range entries {
+		if db.CompareChangelogVersions(e.Version, lastSeen) <= 0 {
+			return i
+		}
+	}
+	return len(entries)
+}
+
+// List shows the changelog, newest version first, with the latest version the
+// user has seen. With ?unseen=true only the entries newer than it are shown,
+// which is what the frontend shows after a deploy.
+func (c *ChangelogContext) List(rw web.ResponseWriter, req *web.Request) {
+	entries, err := c.Settings.Changelog.ChangelogEntries()
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	lastSeen, err := c.Settings.Changelog.LastSeenVersion(c.userID())
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	res := changelogResponse{
+		Entries:         entries,
+		LastSeenVersion: lastSeen,
+		Unseen:          unseenChangelogEntries(entries, lastSeen),
+	}
+	if len(entries) > 0 {
+		res.LatestVersion = entries[0].Version
+	}
+	if req.URL.Query().Get("unseen") == "true" {
+		res.Entries = entries[:res.Unseen]
+	}
+	writeChangelogJSON(rw, res)
+}
+
+// MarkSeen records the user has seen the release notes up to the version in
+// the body, or up to the latest version without one.
+func (c *ChangelogContext) MarkSeen(rw web.ResponseWriter, req *web.Request) {
+	var body changelogSeenBody
+	if req.ContentLength != 0 {
+		if err := readBodyToStruct(req.Body, &body); err != nil {
+			err.writeTo(rw)
+			return
+		}
+	}
+	if body.Version == "" {
+		entries, err := c.Settings.Changelog.ChangelogEntries()
+		if err != nil {
+			newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+			return
+		}
+		if len(entries) == 0 {
+			newUaaError(http.StatusBadRequest, "the changelog is empty.").writeTo(rw)
+			return
+		}
+		body.Version = entries[0].Version
+	}
+	if !db.ValidChangelogVersion(body.Version) {
+		newUaaError(http.StatusBadRequest, "the version is not a version like 1.4.0.").writeTo(rw)
+		return
+	}
+	lastSeen, err := c.Settings.Changelog.LastSeenVersion(c.userID())
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	// A tab left open on an older version doesn't bring back newer notes.
+	if lastSeen == "" || db.CompareChangelogVersions(body.Version, lastSeen) > 0 {
+		if err := c.Settings.Changelog.SetLastSeenVersion(c.userID(), body.Version); err != nil {
+			newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+			return
+		}
+		lastSeen = body.Version
+	}
+	writeChangelogJSON(rw, changelogSeenBody{Version: lastSeen})
+}
+
+// SaveChangelogEntry creates or replaces the changelog entry of the version in
+// the path.
+func (c *AdminContext) SaveChangelogEntry(rw web.ResponseWriter, req *web.Request) {
+	var entry db.ChangelogEntry
+	if err := readBodyToStruct(req.Body, &entry); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	entry.Version = req.PathParams["version"]
+	entry.Title = strings.TrimSpace(entry.Title)
+	entry.UpdatedBy = c.userID()
+	if err := entry.Validate(); err != nil {
+		newUaaError(http.StatusBadRequest, err.Error()+".").writeTo(rw)
+		return
+	}
+	entry, err := c.Settings.Changelog.SaveChangelogEntry(entry)
+	if err == helpers.ErrChangelogReadOnly {
+		newUaaError(http.StatusConflict, err.Error()+".").writeTo(rw)
+		return
+	}
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, entry.UpdatedBy, "save_changelog_entry", struct {
+		Version string `json:"version"`
+	}{entry.Version})
+	writeChangelogJSON(rw, entry)
+}
+
+// DeleteChangelogEntry deletes the changelog entry of the version in the path.
+func (c *AdminContext) DeleteChangelogEntry(rw web.ResponseWriter, req *web.Request) {
+	version := req.PathParams["version"]
+	err := c.Settings.Changelog.DeleteChangelogEntry(version)
+	switch err {
+	case nil:
+	case db.ErrChangelogEntryNotFound:
+		newUaaError(http.StatusNotFound, err.Error()+".").writeTo(rw)
+		return
+	case helpers.ErrChangelogReadOnly:
+		newUaaError(http.StatusConflict, err.Error()+".").writeTo(rw)
+		return
+	default:
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "delete_changelog_entry", struct {
+		Version string `json:"version"`
+	}{version})
+	rw.WriteHeader(http.StatusNoContent)
+}
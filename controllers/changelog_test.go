@@ -0,0 +1,72 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestChangelog(t *testing.T) {
+	router, _ := CreateRouterWithMockSession(adminTokenData, GetMockCompleteEnvVars())
+
+	response, request := NewTestRequest("PUT", "/admin/changelog/latest", []byte(`{"body": "Notes"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected code %d. Found %d", http.StatusBadRequest, response.Code)
+	}
+	for _, version := range []string{"1.9.0", "1.10.0"} {
+		response, request = NewTestRequest("PUT", "/admin/changelog/"+version, []byte(`{"title": "Release `+version+`", "body": "- Notes"}`))
+		router.ServeHTTP(response, request)
+		if response.Code != http.StatusOK {
+			t.Errorf("Expected code %d. Found %d", http.StatusOK, response.Code)
+		}
+	}
+
+	var changelog struct {
+		Entries []struct {
+			Version string `json:"version"`
+		} `json:"entries"`
+		LatestVersion   string `json:"latest_version"`
+		LastSeenVersion string `json:"last_seen_version"`
+		Unseen          int    `json:"unseen"`
+	}
+	response, request = NewTestRequest("GET", "/api/changelog", nil)
+	router.ServeHTTP(response, request)
+	json.NewDecoder(response.Body).Decode(&changelog)
+	if len(changelog.Entries) != 2 || changelog.Entries[0].Version != "1.10.0" || changelog.LatestVersion != "1.10.0" || changelog.LastSeenVersion != "" || changelog.Unseen != 2 {
+		t.Errorf("Unexpected changelog %+v", changelog)
+	}
+
+	response, request = NewTestRequest("PUT", "/api/changelog/seen", []byte(`{"version": "1.9.0"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected code %d. Found %d", http.StatusOK, response.Code)
+	}
+	response, request = NewTestRequest("GET", "/api/changelog?unseen=true", nil)
+	router.ServeHTTP(response, request)
+	json.NewDecoder(response.Body).Decode(&changelog)
+	if len(changelog.Entries) != 1 || changelog.Entries[0].Version != "1.10.0" || changelog.LastSeenVersion != "1.9.0" || changelog.Unseen != 1 {
+		t.Errorf("Unexpected unseen changelog %+v", changelog)
+	}
+
+	// Without a version, the latest is seen.
+	response, request = NewTestRequest("PUT", "/api/changelog/seen", nil)
+	router.ServeHTTP(response, request)
+	expected := NewJSONResponseContentTester(`{"version": "1.10.0"}`)
+	if !expected.Check(t, response.Body.String()) {
+		t.Errorf("Unexpected response %s", response.Body.String())
+	}
+
+	response, request = NewTestRequest("DELETE", "/admin/changelog/2.0.0", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected code %d. Found %d", http.StatusNotFound, response.Code)
+	}
+	response, request = NewTestRequest("DELETE", "/admin/changelog/1.9.0", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNoContent {
+		t.Errorf("Expected code %d. Found %d", http.StatusNoContent, response.Code)
+	}
+}
@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocraft/web"
+)
+
+// maxChangesWait is the longest a request for changes can wait for one.
+const maxChangesWait = 30 * time.Second
+
+// changesPollInterval is how often the CF API is asked for new events while
+// a request waits for changes.
+var changesPollInterval = 5 * time.Second
+
+// ChangesContext stores the session info and access token per user.
+// All routes within ChangesContext let the frontend follow changes to the
+// resources it shows instead of refetching them.
+type ChangesContext struct {
+	*SecureContext // Required.
+}
+
+// changesPerPage is how many events are read at once.
+const changesPerPage = 100
+
+// changeCursor is the position in the CF API audit events up to which the
+// client has seen the changes. Events only have a timestamp to the second,
+// so the number of events of that second already seen is kept too. It can
+// be more than a page when many events share a second, e.g. bulk changes.
+type changeCursor struct {
+	Timestamp string `json:"t"`
+	Seen      int    `json:"n,omitempty"`
+}
+
+func (cursor changeCursor) encode() string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseChangeCursor(s string) (changeCursor, bool) {
+	var cursor changeCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &cursor) != nil {
+		return cursor, false
+	}
+	if _, err := time.Parse(time.RFC3339, cursor.Timestamp); err != nil || cursor.Seen < 0 {
+		return cursor, false
+	}
+	return cursor, true
+}
+
+// resourceChange is the latest change to a resource since the cursor.
+type resourceChange struct {
+	Type      string `json:"type"`
+	GUID      string `json:"guid"`
+	Name      string `json:"name"`
+	Event     string `json:"event"`
+	SpaceGUID string `json:"space_guid,omitempty"`
+	OrgGUID   string `json:"org_guid,omitempty"`
+	Timestamp string `json:"timestamp"`
+}
+
+// changeFeed is the response of Changes.
+type changeFeed struct {
+	// Cursor is passed as ?since= to get the next changes.
+	Cursor  string           `json:"cursor"`
+	Changes []resourceChange `json:"changes"`
+	// More is true when there are more changes than returned at once. They
+	// can be requested right away with the cursor.
+	More bool `json:"more"`
+}
+
+// changeFeedFields are the fields of Changes that can be selected.
+var changeFeedFields = fieldsOf(changeFeed{})
+
+// ccEvent is a partial representation of a v2 CF API audit event.
+type ccEvent struct {
+	Type             string `json:"type"`
+	Actee            string `json:"actee"`
+	ActeeType        string `json:"actee_type"`
+	ActeeName        string `json:"actee_name"`
+	Timestamp        string `json:"timestamp"`
+	SpaceGUID        string `json:"space_guid"`
+	OrganizationGUID string `json:"organization_guid"`
+	ActorName        string `json:"actor_name"`
+	Metadata         struct {
+		// ExitDescription is why the app crashed, for app.crash events.
+		ExitDescription string `json:"exit_description"`
+	} `json:"metadata"`
+}
+
+// Changes returns the resources changed since the ?since= cursor, according
+// to the CF API audit events the user can see. Without a cursor, it only
+// returns the cursor to start from. With ?wait=<seconds> (at most 30), the
+// request is held until there are changes or the time is up, so the frontend
+// can long poll.
+func (c *ChangesContext) Changes(rw web.ResponseWriter, req *web.Request) {
+	query := req.URL.Query()
+	since := query.Get("since")
+	if since == "" {
+		c.writeAggregate(rw, req, changeFeedFields, changeFeed{
+			Cursor:  changeCursor{Timestamp: time.Now().UTC().Format(time.RFC3339)}.encode(),
+			Changes: []resourceChange{},
+		})
+		return
+	}
+	cursor, ok := parseChangeCursor(since)
+	if !ok {
+		newUaaError(http.StatusBadRequest, "invalid cursor.").writeTo(rw)
+		return
+	}
+	var wait time.Duration
+	if w := query.Get("wait"); w != "" {
+		seconds, err := strconv.Atoi(w)
+		if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > maxChangesWait {
+			newUaaError(http.StatusBadRequest, "wait must be a number of seconds up to "+strconv.Itoa(int(maxChangesWait/time.Second))+".").writeTo(rw)
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+
+	// The wait ends before the request times out, in case the route's
+	// timeout was configured shorter than the wait.
+	deadline := time.Now().Add(wait)
+	if timeout, ok := req.Context().Deadline(); ok && timeout.Before(deadline) {
+		deadline = timeout
+	}
+	for {
+		feed, err := c.changesSince(cursor)
+		if err != nil {
+			newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+			return
+		}
+		if len(feed.Changes) > 0 || !time.Now().Add(changesPollInterval).Before(deadline) {
+			c.writeAggregate(rw, req, changeFeedFields, feed)
+			return
+		}
+		select {
+		case <-time.After(changesPollInterval):
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// changesSince gets the next page of events after the cursor and keeps the
+// latest change to each resource.
+func (c *ChangesContext) changesSince(cursor changeCursor) (changeFeed, error) {
+	// The events of the cursor's second that were already seen are skipped,
+	// the whole pages of them by asking for a later page.
+	var page ccPage
+	err := c.ccRequest("GET", "/v2/events?"+url.Values{
+		"q":                {"timestamp>=" + cursor.Timestamp},
+		"order-direction":  {"asc"},
+		"results-per-page": {strconv.Itoa(changesPerPage)},
+		"page":             {strconv.Itoa(cursor.Seen/changesPerPage + 1)},
+	}.Encode(), nil, &page)
+	if err != nil {
+		return changeFeed{}, err
+	}
+	resources := page.Resources
+	if skip := cursor.Seen % changesPerPage; skip < len(resources) {
+		resources = resources[skip:]
+	} else {
+		resources = nil
+	}
+
+	feed := changeFeed{
+		Cursor:  cursor.encode(),
+		Changes: []resourceChange{},
+		More:    page.NextURL != nil,
+	}
+	latest := make(map[string]int)
+	for _, resource := range resources {
+		var event ccEvent
+		if err := json.Unmarshal(resource.Entity, &event); err != nil {
+			return changeFeed{}, err
+		}
+		if event.Timestamp == cursor.Timestamp {
+			cursor.Seen++
+		} else {
+			cursor = changeCursor{Timestamp: event.Timestamp, Seen: 1}
+		}
+		feed.Cursor = cursor.encode()
+		if event.Actee == "" {
+			continue
+		}
+		change := resourceChange{
+			Type:      strings.TrimPrefix(event.ActeeType, "v3-"),
+			GUID:      event.Actee,
+			Name:      event.ActeeName,
+			Event:     event.Type,
+			SpaceGUID: event.SpaceGUID,
+			OrgGUID:   event.OrganizationGUID,
+			Timestamp: event.Timestamp,
+		}
+		key := change.Type + "/" + change.GUID
+		if i, ok := latest[key]; ok {
+			feed.Changes[i] = change
+			continue
+		}
+		latest[key] = len(feed.Changes)
+		feed.Changes = append(feed.Changes, change)
+	}
+	return feed, nil
+}
@@ -0,0 +1,142 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestChanges(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/events" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.URL.Query().Get("q") + " page " + r.URL.Query().Get("page") {
+		case "timestamp>=2019-05-01T10:00:00Z page 1":
+			w.Write([]byte(`{"next_url": "/v2/events?page=2", "resources": [
+				{"metadata": {"guid": "event-1"}, "entity": {"type": "audit.app.create", "actee": "app-1", "actee_type": "app", "actee_name": "web", "space_guid": "space-1", "organization_guid": "org-1", "timestamp": "2019-05-01T10:00:00Z"}},
+				{"metadata": {"guid": "event-2"}, "entity": {"type": "audit.app.update", "actee": "app-1", "actee_type": "app", "actee_name": "web", "space_guid": "space-1", "organization_guid": "org-1", "timestamp": "2019-05-01T10:00:00Z"}},
+				{"metadata": {"guid": "event-3"}, "entity": {"type": "audit.route.delete-request", "actee": "route-1", "actee_type": "route", "actee_name": "www", "space_guid": "space-1", "organization_guid": "org-1", "timestamp": "2019-05-01T10:00:05Z"}}
+			]}`))
+		case "timestamp>=2019-05-01T10:00:05Z page 1":
+			w.Write([]byte(`{"next_url": null, "resources": [
+				{"metadata": {"guid": "event-3"}, "entity": {"type": "audit.route.delete-request", "actee": "route-1", "actee_type": "route", "actee_name": "www", "space_guid": "space-1", "organization_guid": "org-1", "timestamp": "2019-05-01T10:00:05Z"}}
+			]}`))
+		case "timestamp>=2019-05-01T10:00:10Z page 2":
+			// Events 101 to 103 of a second with more than a page of them.
+			w.Write([]byte(`{"next_url": null, "resources": [
+				{"metadata": {"guid": "event-101"}, "entity": {"type": "audit.app.update", "actee": "app-1", "actee_type": "app", "actee_name": "web", "space_guid": "space-1", "organization_guid": "org-1", "timestamp": "2019-05-01T10:00:10Z"}},
+				{"metadata": {"guid": "event-102"}, "entity": {"type": "audit.app.update", "actee": "app-2", "actee_type": "app", "actee_name": "worker", "space_guid": "space-1", "organization_guid": "org-1", "timestamp": "2019-05-01T10:00:10Z"}},
+				{"metadata": {"guid": "event-103"}, "entity": {"type": "audit.app.update", "actee": "app-3", "actee_type": "app", "actee_name": "cron", "space_guid": "space-1", "organization_guid": "org-1", "timestamp": "2019-05-01T10:00:10Z"}}
+			]}`))
+		default:
+			t.Errorf("Unexpected events query %s", r.URL.RawQuery)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	cursor := func(timestamp string, seen int) string {
+		b, _ := json.Marshal(struct {
+			Timestamp string `json:"t"`
+			Seen      int    `json:"n,omitempty"`
+		}{timestamp, seen})
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	tests := []struct {
+		name             string
+		location         string
+		expectedCode     int
+		expectedResponse string
+	}{
+		{
+			name:         "Latest Change Per Resource",
+			location:     "/api/changes?since=" + cursor("2019-05-01T10:00:00Z", 0),
+			expectedCode: http.StatusOK,
+			expectedResponse: `{
+				"cursor": "` + cursor("2019-05-01T10:00:05Z", 1) + `",
+				"changes": [
+					{"type": "app", "guid": "app-1", "name": "web", "event": "audit.app.update", "space_guid": "space-1", "org_guid": "org-1", "timestamp": "2019-05-01T10:00:00Z"},
+					{"type": "route", "guid": "route-1", "name": "www", "event": "audit.route.delete-request", "space_guid": "space-1", "org_guid": "org-1", "timestamp": "2019-05-01T10:00:05Z"}
+				],
+				"more": true
+			}`,
+		},
+		{
+			name:             "No Changes Since Cursor",
+			location:         "/api/changes?since=" + cursor("2019-05-01T10:00:05Z", 1),
+			expectedCode:     http.StatusOK,
+			expectedResponse: `{"cursor": "` + cursor("2019-05-01T10:00:05Z", 1) + `", "changes": [], "more": false}`,
+		},
+		{
+			name:         "More Events In A Second Than A Page",
+			location:     "/api/changes?since=" + cursor("2019-05-01T10:00:10Z", 101),
+			expectedCode: http.StatusOK,
+			expectedResponse: `{
+				"cursor": "` + cursor("2019-05-01T10:00:10Z", 103) + `",
+				"changes": [
+					{"type": "app", "guid": "app-2", "name": "worker", "event": "audit.app.update", "space_guid": "space-1", "org_guid": "org-1", "timestamp": "2019-05-01T10:00:10Z"},
+					{"type": "app", "guid": "app-3", "name": "cron", "event": "audit.app.update", "space_guid": "space-1", "org_guid": "org-1", "timestamp": "2019-05-01T10:00:10Z"}
+				],
+				"more": false
+			}`,
+		},
+		{
+			name:             "Invalid Cursor",
+			location:         "/api/changes?since=nope",
+			expectedCode:     http.StatusBadRequest,
+			expectedResponse: `{"status": "failure", "data": "invalid cursor."}`,
+		},
+		{
+			name:             "Wait Too Long",
+			location:         "/api/changes?since=" + cursor("2019-05-01T10:00:05Z", 0) + "&wait=60",
+			expectedCode:     http.StatusBadRequest,
+			expectedResponse: `{"status": "failure", "data": "wait must be a number of seconds up to 30."}`,
+		},
+	}
+	for _, test := range tests {
+		response, request := NewTestRequest("GET", test.location, nil)
+		router.ServeHTTP(response, request)
+		if response.Code != test.expectedCode {
+			t.Errorf("Test %s: expected code %d. Found %d", test.name, test.expectedCode, response.Code)
+		}
+		expected := NewJSONResponseContentTester(test.expectedResponse)
+		if !expected.Check(t, response.Body.String()) {
+			t.Errorf("Test %s: expected %s. Found %s", test.name, expected.Display(), response.Body.String())
+		}
+	}
+
+	// The wait ends before the request times out, with the changes so far.
+	response, request := NewTestRequest("GET", "/api/changes?since="+cursor("2019-05-01T10:00:05Z", 1)+"&wait=25", nil)
+	ctx, cancel := context.WithTimeout(request.Context(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	router.ServeHTTP(response, request.WithContext(ctx))
+	if response.Code != http.StatusOK || time.Since(start) > time.Second {
+		t.Errorf("Expected the wait to end before the timeout. Found %d after %v: %s",
+			response.Code, time.Since(start), response.Body.String())
+	}
+
+	// Without a cursor, only the cursor to start from is returned.
+	response, request = NewTestRequest("GET", "/api/changes", nil)
+	router.ServeHTTP(response, request)
+	var feed struct {
+		Cursor  string        `json:"cursor"`
+		Changes []interface{} `json:"changes"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &feed); err != nil || feed.Cursor == "" || len(feed.Changes) != 0 {
+		t.Errorf("Expected a cursor without changes. Found %s", response.Body.String())
+	}
+}
@@ -0,0 +1,443 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+// errChangedSincePlanned is the error of a change whose target changed after
+// the change set was planned.
+var errChangedSincePlanned = errors.New("the target changed since the change set was planned, plan it again")
+
+// ChangeSetContext stores the session info and access token per user.
+// All routes within ChangeSetContext make bulk changes in two steps: a plan
+// lists the changes for the user to review, and applying the plan makes
+// exactly those changes.
+type ChangeSetContext struct {
+	*SecureContext // Required.
+}
+
+// changeSetKind is a bulk operation that can be planned and applied.
+type changeSetKind struct {
+	// scope, if set, is required to plan and apply the operation.
+	scope string
+	// plan lists the changes asked for in the request.
+	plan func(c *ChangeSetContext, req *web.Request) ([]helpers.Change, *UaaError)
+	// apply makes a single planned change, unless its target changed since.
+	apply func(c *ChangeSetContext, change helpers.Change) error
+}
+
+// changeSetKinds are the bulk operations, by name.
+var changeSetKinds = map[string]changeSetKind{
+	"restage":        {plan: planRestage, apply: applyRestage},
+	"roles":          {plan: planRoles, apply: applyRole},
+	"org-onboarding": {scope: adminScope, plan: planOrgOnboarding, apply: applyOrgOnboarding},
+}
+
+// kind looks up the bulk operation and checks the user may run it.
+func (c *ChangeSetContext) kind(rw web.ResponseWriter, name string) (changeSetKind, bool) {
+	kind, ok := changeSetKinds[name]
+	if !ok {
+		newUaaError(http.StatusNotFound, "unknown change set kind "+name+".").writeTo(rw)
+		return kind, false
+	}
+	if kind.scope != "" && !c.hasScope(kind.scope) {
+		c.forbidden(rw, kind.scope)
+		return kind, false
+	}
+	return kind, true
+}
+
+// Plan lists the changes a bulk operation would make as a signed change set,
+// without making them.
+func (c *ChangeSetContext) Plan(rw web.ResponseWriter, req *web.Request) {
+	name := req.PathParams["kind"]
+	kind, ok := c.kind(rw, name)
+	if !ok {
+		return
+	}
+	owner := c.userID()
+	if owner == "" {
+		newUaaError(http.StatusBadRequest, "change sets need a JWT access token.").writeTo(rw)
+		return
+	}
+	changes, uaaErr := kind.plan(c, req)
+	if uaaErr != nil {
+		uaaErr.writeTo(rw)
+		return
+	}
+	if changes == nil {
+		changes = []helpers.Change{}
+	}
+	cs, err := c.Settings.ChangeSets.Plan(name, owner, changes)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(cs)
+}
+
+// Apply queues the changes of a change set returned by Plan. A change set
+// can only be applied once, by the user who planned it, before it expires.
+func (c *ChangeSetContext) Apply(rw web.ResponseWriter, req *web.Request) {
+	var cs helpers.ChangeSet
+	if err := readBodyToStruct(req.Body, &cs); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	kind, ok := c.kind(rw, cs.Kind)
+	if !ok {
+		return
+	}
+	if err := c.Settings.ChangeSets.Claim(cs, c.userID()); err != nil {
+		code := http.StatusBadRequest
+		if err == helpers.ErrChangeSetApplied {
+			code = http.StatusConflict
+		}
+		newUaaError(code, err.Error()).writeTo(rw)
+		return
+	}
+
+	tasks := make([]jobs.Task, 0, len(cs.Changes))
+	for _, change := range cs.Changes {
+		change := change
+		tasks = append(tasks, jobs.Task{
+			Name: change.Action + " " + change.Target,
+			Run: func() error {
+				return kind.apply(c, change)
+			},
+		})
+	}
+	job, ok := c.submitJob(rw, "change-set-"+cs.Kind, tasks)
+	if !ok {
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "apply_change_set", struct {
+		ChangeSetID string `json:"change_set_id"`
+		Kind        string `json:"kind"`
+		Changes     int    `json:"changes"`
+		JobID       string `json:"job_id"`
+	}{
+		ChangeSetID: cs.ID,
+		Kind:        cs.Kind,
+		Changes:     len(cs.Changes),
+		JobID:       job.ID,
+	})
+}
+
+// restagePlanRequest is the body to plan restaging apps.
+type restagePlanRequest struct {
+	AppGUIDs []string `json:"app_guids"`
+}
+
+// planRestage plans restaging each app. An app that is updated after the
+// plan, e.g. pushed or scaled, is not restaged.
+func planRestage(c *ChangeSetContext, req *web.Request) ([]helpers.Change, *UaaError) {
+	var plan restagePlanRequest
+	if err := readBodyToStruct(req.Body, &plan); err != nil {
+		return nil, err
+	}
+	if len(plan.AppGUIDs) == 0 {
+		return nil, newUaaError(http.StatusBadRequest, "app_guids are required.")
+	}
+	var changes []helpers.Change
+	for _, guid := range plan.AppGUIDs {
+		path := "/v2/apps/" + url.PathEscape(guid)
+		var resource ccResource
+		if err := c.ccRequest("GET", path, nil, &resource); err != nil {
+			return nil, newUaaError(http.StatusBadGateway, err.Error())
+		}
+		var app ccApp
+		if err := json.Unmarshal(resource.Entity, &app); err != nil {
+			return nil, newUaaError(http.StatusBadGateway, err.Error())
+		}
+		description := "Restage " + app.Name
+		if app.State == "STARTED" {
+			description += ", restarting its instances"
+		}
+		changes = append(changes, helpers.Change{
+			Action:      "restage",
+			Target:      path,
+			Description: description,
+			Current:     resource.Metadata.UpdatedAt,
+		})
+	}
+	return changes, nil
+}
+
+func applyRestage(c *ChangeSetContext, change helpers.Change) error {
+	var resource ccResource
+	if err := c.ccRequest("GET", change.Target, nil, &resource); err != nil {
+		return err
+	}
+	if resource.Metadata.UpdatedAt != change.Current {
+		return errChangedSincePlanned
+	}
+	return c.ccRequest("POST", change.Target+"/restage", nil, nil)
+}
+
+// rolePlanRequest is the body to plan adding and removing org and space
+// roles.
+type rolePlanRequest struct {
+	Changes []struct {
+		UserGUID  string `json:"user_guid"`
+		OrgGUID   string `json:"org_guid"`
+		SpaceGUID string `json:"space_guid"`
+		// Role is as named in the CF API paths, e.g. "managers".
+		Role string `json:"role"`
+		// Action is "add" or "remove".
+		Action string `json:"action"`
+	} `json:"changes"`
+}
+
+// orgRoles and spaceRoles map the roles in the CF API paths to their names
+// in user_roles.
+var (
+	orgRoles = map[string]string{
+		"users":            "org_user",
+		"managers":         "org_manager",
+		"billing_managers": "billing_manager",
+		"auditors":         "org_auditor",
+	}
+	spaceRoles = map[string]string{
+		"developers": "space_developer",
+		"managers":   "space_manager",
+		"auditors":   "space_auditor",
+	}
+)
+
+// States of a planned target, e.g. whether a user has a role.
+const (
+	statePresent = "present"
+	stateAbsent  = "absent"
+)
+
+// userRoles returns the role names of each user of the org or space at the
+// CF API path.
+func (c *ChangeSetContext) userRoles(path string) (map[string][]string, error) {
+	resources, err := c.ccGetAll(path + "/user_roles?results-per-page=100")
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[string][]string)
+	for _, resource := range resources {
+		var entity struct {
+			OrganizationRoles []string `json:"organization_roles"`
+			SpaceRoles        []string `json:"space_roles"`
+		}
+		if err := json.Unmarshal(resource.Entity, &entity); err != nil {
+			return nil, err
+		}
+		roles[resource.Metadata.GUID] = append(entity.OrganizationRoles, entity.SpaceRoles...)
+	}
+	return roles, nil
+}
+
+// roleState returns whether the user has the role in the user roles.
+func roleState(roles map[string][]string, userGUID, role string) string {
+	for _, r := range roles[userGUID] {
+		if r == role {
+			return statePresent
+		}
+	}
+	return stateAbsent
+}
+
+// splitRoleTarget splits a role target, e.g.
+// /v2/organizations/:guid/managers/:user_guid, into the org or space path,
+// the role name in user_roles and the user GUID.
+func splitRoleTarget(target string) (path, role, userGUID string, err error) {
+	parts := strings.Split(strings.TrimPrefix(target, "/v2/"), "/")
+	if len(parts) != 4 {
+		return "", "", "", errors.New("invalid role target " + target)
+	}
+	roles := orgRoles
+	if parts[0] == "spaces" {
+		roles = spaceRoles
+	}
+	return "/v2/" + parts[0] + "/" + parts[1], roles[parts[2]], parts[3], nil
+}
+
+// planRoles plans the role changes, leaving out the ones already made. A
+// change is not made if someone else made or undid it after the plan.
+func planRoles(c *ChangeSetContext, req *web.Request) ([]helpers.Change, *UaaError) {
+	var plan rolePlanRequest
+	if err := readBodyToStruct(req.Body, &plan); err != nil {
+		return nil, err
+	}
+	if len(plan.Changes) == 0 {
+		return nil, newUaaError(http.StatusBadRequest, "changes are required.")
+	}
+	// Org and space user roles are only read once.
+	rolesByPath := make(map[string]map[string][]string)
+	var changes []helpers.Change
+	for i, rc := range plan.Changes {
+		var path, role, noun string
+		var ok bool
+		switch {
+		case rc.OrgGUID != "" && rc.SpaceGUID == "":
+			path, noun = "/v2/organizations/"+url.PathEscape(rc.OrgGUID), "organization "+rc.OrgGUID
+			role, ok = orgRoles[rc.Role]
+		case rc.SpaceGUID != "" && rc.OrgGUID == "":
+			path, noun = "/v2/spaces/"+url.PathEscape(rc.SpaceGUID), "space "+rc.SpaceGUID
+			role, ok = spaceRoles[rc.Role]
+		default:
+			return nil, newUaaError(http.StatusBadRequest, fmt.Sprintf("change %d needs either an org_guid or a space_guid.", i+1))
+		}
+		if !ok {
+			return nil, newUaaError(http.StatusBadRequest, fmt.Sprintf("change %d has an unknown role %q.", i+1, rc.Role))
+		}
+		if rc.UserGUID == "" || (rc.Action != "add" && rc.Action != "remove") {
+			return nil, newUaaError(http.StatusBadRequest, fmt.Sprintf("change %d needs a user_guid and an action of add or remove.", i+1))
+		}
+		roles, ok := rolesByPath[path]
+		if !ok {
+			var err error
+			if roles, err = c.userRoles(path); err != nil {
+				return nil, newUaaError(http.StatusBadGateway, err.Error())
+			}
+			rolesByPath[path] = roles
+		}
+		current, desired := roleState(roles, rc.UserGUID, role), statePresent
+		description := fmt.Sprintf("Add user %s as %s of %s", rc.UserGUID, role, noun)
+		if rc.Action == "remove" {
+			desired = stateAbsent
+			description = fmt.Sprintf("Remove %s of %s from user %s", role, noun, rc.UserGUID)
+		}
+		if current == desired {
+			continue
+		}
+		changes = append(changes, helpers.Change{
+			Action:      rc.Action + "_role",
+			Target:      path + "/" + rc.Role + "/" + url.PathEscape(rc.UserGUID),
+			Description: description,
+			Current:     current,
+			Desired:     desired,
+		})
+	}
+	return changes, nil
+}
+
+func applyRole(c *ChangeSetContext, change helpers.Change) error {
+	path, role, userGUID, err := splitRoleTarget(change.Target)
+	if err != nil {
+		return err
+	}
+	roles, err := c.userRoles(path)
+	if err != nil {
+		return err
+	}
+	if roleState(roles, userGUID, role) != change.Current {
+		return errChangedSincePlanned
+	}
+	method := "PUT"
+	if change.Desired == stateAbsent {
+		method = "DELETE"
+	}
+	return c.ccRequest(method, change.Target, nil, nil)
+}
+
+// orgOnboarding is the body to plan creating an org for a new team.
+type orgOnboarding struct {
+	Name                string   `json:"name"`
+	QuotaDefinitionGUID string   `json:"quota_definition_guid,omitempty"`
+	ManagerGUIDs        []string `json:"manager_guids"`
+}
+
+// orgExists returns true if an org with the name exists.
+func (c *ChangeSetContext) orgExists(name string) (bool, error) {
+	orgs, err := c.ccGetAll("/v2/organizations?" + url.Values{"q": {"name:" + name}}.Encode())
+	return len(orgs) > 0, err
+}
+
+// planOrgOnboarding plans creating the org with its managers. The org is not
+// created if an org with the same name was created after the plan.
+func planOrgOnboarding(c *ChangeSetContext, req *web.Request) ([]helpers.Change, *UaaError) {
+	var onboarding orgOnboarding
+	if err := readBodyToStruct(req.Body, &onboarding); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(onboarding.Name) == "" {
+		return nil, newUaaError(http.StatusBadRequest, "name is required.")
+	}
+	exists, err := c.orgExists(onboarding.Name)
+	if err != nil {
+		return nil, newUaaError(http.StatusBadGateway, err.Error())
+	}
+	if exists {
+		return nil, newUaaError(http.StatusConflict, "organization "+onboarding.Name+" already exists.")
+	}
+	description := "Create organization " + onboarding.Name
+	if onboarding.QuotaDefinitionGUID != "" {
+		var quota struct {
+			Entity struct {
+				Name string `json:"name"`
+			} `json:"entity"`
+		}
+		err := c.ccRequest("GET", "/v2/quota_definitions/"+url.PathEscape(onboarding.QuotaDefinitionGUID), nil, &quota)
+		if err != nil {
+			return nil, newUaaError(http.StatusBadRequest, "unknown quota definition "+onboarding.QuotaDefinitionGUID+".")
+		}
+		description += " with the " + quota.Entity.Name + " quota"
+	}
+	if len(onboarding.ManagerGUIDs) > 0 {
+		description += " and managers " + strings.Join(onboarding.ManagerGUIDs, ", ")
+	}
+	desired, _ := json.Marshal(onboarding)
+	return []helpers.Change{{
+		Action:      "create_org",
+		Target:      "/v2/organizations",
+		Description: description,
+		Current:     stateAbsent,
+		Desired:     string(desired),
+	}}, nil
+}
+
+func applyOrgOnboarding(c *ChangeSetContext, change helpers.Change) error {
+	var onboarding orgOnboarding
+	if err := json.Unmarshal([]byte(change.Desired), &onboarding); err != nil {
+		return err
+	}
+	exists, err := c.orgExists(onboarding.Name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errChangedSincePlanned
+	}
+	body := map[string]string{"name": onboarding.Name}
+	if onboarding.QuotaDefinitionGUID != "" {
+		body["quota_definition_guid"] = onboarding.QuotaDefinitionGUID
+	}
+	var org ccResource
+	if err := c.ccRequest("POST", "/v2/organizations", body, &org); err != nil {
+		return err
+	}
+	// Managers must be users of the org first. Managers held for approval
+	// don't hold up the others.
+	var parked error
+	for _, guid := range onboarding.ManagerGUIDs {
+		for _, role := range []string{"users", "managers"} {
+			path := "/v2/organizations/" + org.Metadata.GUID + "/" + role + "/" + url.PathEscape(guid)
+			err := c.ccRequest("PUT", path, nil, nil)
+			if _, ok := err.(*parkedChangeError); ok {
+				parked = err
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return parked
+}
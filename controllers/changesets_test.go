@@ -0,0 +1,154 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+// newChangeSetCC returns a fake CF API with two apps and an org where
+// user-1 is a user. It records the changes it receives.
+func newChangeSetCC(t *testing.T, mu *sync.Mutex, appUpdatedAt *string, calls *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v2/apps/app-1":
+			w.Write([]byte(`{"metadata": {"guid": "app-1", "updated_at": "` + *appUpdatedAt + `"}, "entity": {"name": "web", "state": "STARTED"}}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/apps/app-2":
+			w.Write([]byte(`{"metadata": {"guid": "app-2", "updated_at": "2018-01-01T00:00:00Z"}, "entity": {"name": "worker", "state": "STOPPED"}}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/organizations/org-1/user_roles":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "user-1"}, "entity": {"organization_roles": ["org_user"]}}]}`))
+		case r.Method == "POST" || r.Method == "PUT" || r.Method == "DELETE":
+			*calls = append(*calls, r.Method+" "+r.URL.Path)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+// waitForJob polls the job until it's finished.
+func waitForJob(t *testing.T, router http.Handler, location string) map[string]interface{} {
+	for i := 0; i < 100; i++ {
+		response, request := NewTestRequest("GET", location, nil)
+		router.ServeHTTP(response, request)
+		var job map[string]interface{}
+		json.NewDecoder(response.Body).Decode(&job)
+		if job["finished_at"] != nil {
+			return job
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("The job did not finish")
+	return nil
+}
+
+func TestChangeSetRestage(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	appUpdatedAt := "2018-01-01T00:00:00Z"
+	cc := newChangeSetCC(t, &mu, &appUpdatedAt, &calls)
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	response, request := NewTestRequest("POST", "/changesets/restage/plan", []byte(`{"app_guids": ["app-1", "app-2"]}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+	plan := response.Body.Bytes()
+	var cs helpers.ChangeSet
+	json.Unmarshal(plan, &cs)
+	if len(cs.Changes) != 2 || cs.Changes[0].Description != "Restage web, restarting its instances" || cs.Signature == "" {
+		t.Errorf("Unexpected change set %+v", cs)
+	}
+
+	// app-1 is pushed again after the plan.
+	mu.Lock()
+	appUpdatedAt = "2018-02-01T00:00:00Z"
+	mu.Unlock()
+
+	response, request = NewTestRequest("POST", "/changesets/apply", plan)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusAccepted, response.Code, response.Body.String())
+	}
+	job := waitForJob(t, router, response.Header().Get("Location"))
+	results := job["results"].([]interface{})
+	if results[0].(map[string]interface{})["status"] != "failed" || results[1].(map[string]interface{})["status"] != "succeeded" {
+		t.Errorf("Unexpected results %v", results)
+	}
+	mu.Lock()
+	if len(calls) != 1 || calls[0] != "POST /v2/apps/app-2/restage" {
+		t.Errorf("Unexpected calls %v", calls)
+	}
+	mu.Unlock()
+
+	// A change set can only be applied once.
+	response, request = NewTestRequest("POST", "/changesets/apply", plan)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected code %d. Found %d", http.StatusConflict, response.Code)
+	}
+}
+
+func TestChangeSetRoles(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	appUpdatedAt := "2018-01-01T00:00:00Z"
+	cc := newChangeSetCC(t, &mu, &appUpdatedAt, &calls)
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	response, request := NewTestRequest("POST", "/changesets/roles/plan", []byte(`{"changes": [
+		{"user_guid": "user-1", "org_guid": "org-1", "role": "users", "action": "add"},
+		{"user_guid": "user-1", "org_guid": "org-1", "role": "managers", "action": "add"},
+		{"user_guid": "user-2", "org_guid": "org-1", "role": "auditors", "action": "remove"}
+	]}`))
+	router.ServeHTTP(response, request)
+	var cs helpers.ChangeSet
+	json.NewDecoder(response.Body).Decode(&cs)
+	// The other changes are already made.
+	if len(cs.Changes) != 1 || cs.Changes[0].Target != "/v2/organizations/org-1/managers/user-1" {
+		t.Fatalf("Unexpected change set %+v", cs)
+	}
+
+	// Change sets can't be modified.
+	cs.Changes[0].Target = "/v2/organizations/org-1/managers/user-3"
+	tampered, _ := json.Marshal(cs)
+	response, request = NewTestRequest("POST", "/changesets/apply", tampered)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected code %d. Found %d", http.StatusBadRequest, response.Code)
+	}
+
+	response, request = NewTestRequest("POST", "/changesets/roles/plan", []byte(`{"changes": [
+		{"user_guid": "user-1", "org_guid": "org-1", "role": "developers", "action": "add"}
+	]}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected code %d. Found %d", http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestChangeSetOrgOnboardingWithoutAdminScope(t *testing.T) {
+	router, _ := CreateRouterWithMockSession(userTokenData, GetMockCompleteEnvVars())
+	response, request := NewTestRequest("POST", "/changesets/org-onboarding/plan", []byte(`{"name": "new-org"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected code %d. Found %d", http.StatusForbidden, response.Code)
+	}
+}
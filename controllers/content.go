@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+// frontendConfig is the per-deployment configuration of the frontend.
+type frontendConfig struct {
+	db.DeploymentContent
+	// Theme is the content's theme with the deployment's defaults filled in.
+	Theme db.Theme `json:"theme"`
+}
+
+// theme is the branding of the deployment: the theme saved by platform admins
+// over the one from the settings. The settings alone are used when the saved
+// content can't be loaded, so the page is still branded.
+func (c *Context) theme() db.Theme {
+	content, err := c.Settings.Content.Content()
+	if err != nil {
+		return c.Settings.Theme
+	}
+	return content.Theme.WithDefaults(c.Settings.Theme)
+}
+
+// Config serves the per-deployment configuration of the frontend, such as
+// quick links, support contact info and theme. It needs no session so it can be
+// shown on the login and error pages too.
+func (c *Context) Config(rw web.ResponseWriter, req *web.Request) {
+	content, err := c.Settings.Content.Content()
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, "unable to load the configuration.").writeTo(rw)
+		return
+	}
+	// Who changed the content last and how often is for admins only.
+	content.UpdatedBy, content.UpdatedAt, content.Version = "", nil, 0
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(frontendConfig{
+		DeploymentContent: content,
+		Theme:             content.Theme.WithDefaults(c.Settings.Theme),
+	})
+}
+
+// Content shows the operator-managed content and who changed it last. Its ETag
+// is the content's version, for updates to send back in If-Match.
+func (c *AdminContext) Content(rw web.ResponseWriter, req *web.Request) {
+	content, err := c.Settings.Content.Content()
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	rw.Header().Set("ETag", versionETag(content.Version))
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(content)
+}
+
+// UpdateContent replaces the operator-managed content, unless it was changed
+// since the version in If-Match, or in the body, was read.
+func (c *AdminContext) UpdateContent(rw web.ResponseWriter, req *web.Request) {
+	var content db.DeploymentContent
+	if err := readBodyToStruct(req.Body, &content); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	if content.QuickLinks == nil {
+		content.QuickLinks = []db.QuickLink{}
+	}
+	if err := content.Validate(); err != nil {
+		newUaaError(http.StatusBadRequest, err.Error()).writeTo(rw)
+		return
+	}
+	version, uaaErr := expectedVersion(req, content.Version)
+	if uaaErr != nil {
+		uaaErr.writeTo(rw)
+		return
+	}
+	now := time.Now().UTC()
+	content.UpdatedBy, content.UpdatedAt, content.Version = c.userID(), &now, version
+	content, err := c.Settings.Content.SaveContent(content)
+	if err == db.ErrVersionConflict {
+		newVersionConflictError().writeTo(rw)
+		return
+	}
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, content.UpdatedBy, "update_content", struct {
+		QuickLinks int    `json:"quick_links"`
+		DocsURL    string `json:"docs_url"`
+	}{
+		QuickLinks: len(content.QuickLinks),
+		DocsURL:    content.DocsURL,
+	})
+
+	rw.Header().Set("ETag", versionETag(content.Version))
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(content)
+}
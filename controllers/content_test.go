@@ -0,0 +1,157 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestContent(t *testing.T) {
+	router, _ := CreateRouterWithMockSession(adminTokenData, GetMockCompleteEnvVars())
+
+	// Nothing configured yet.
+	response, request := NewTestRequest("GET", "/api/config", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected code %d. Found %d", http.StatusOK, response.Code)
+	}
+	expected := NewJSONResponseContentTester(`{"quick_links": [], "support": {}, "theme": {}}`)
+	if !expected.Check(t, response.Body.String()) {
+		t.Errorf("Unexpected config %s", response.Body.String())
+	}
+
+	response, request = NewTestRequest("PUT", "/admin/content", []byte(`{"quick_links": [{"title": "Status", "url": "javascript:alert(1)"}]}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected code %d. Found %d", http.StatusBadRequest, response.Code)
+	}
+
+	response, request = NewTestRequest("PUT", "/admin/content", []byte(`{
+		"quick_links": [{"title": "Status", "url": "https://status.example.com"}],
+		"docs_url": "https://docs.example.com",
+		"support": {"email": "support@example.com"}
+	}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected code %d. Found %d", http.StatusOK, response.Code)
+	}
+
+	response, request = NewTestRequest("GET", "/admin/content", nil)
+	router.ServeHTTP(response, request)
+	var content db.DeploymentContent
+	json.NewDecoder(response.Body).Decode(&content)
+	if content.UpdatedBy != "admin-guid" || content.UpdatedAt == nil || len(content.QuickLinks) != 1 {
+		t.Errorf("Unexpected content %+v", content)
+	}
+
+	// Only admins see who changed the content.
+	response, request = NewTestRequest("GET", "/api/config", nil)
+	router.ServeHTTP(response, request)
+	expected = NewJSONResponseContentTester(`{
+		"quick_links": [{"title": "Status", "url": "https://status.example.com"}],
+		"docs_url": "https://docs.example.com",
+		"support": {"email": "support@example.com"},
+		"theme": {}
+	}`)
+	if !expected.Check(t, response.Body.String()) {
+		t.Errorf("Unexpected config %s", response.Body.String())
+	}
+}
+
+func TestUpdateContentConflict(t *testing.T) {
+	router, _ := CreateRouterWithMockSession(adminTokenData, GetMockCompleteEnvVars())
+
+	response, request := NewTestRequest("PUT", "/admin/content", []byte(`{"docs_url": "https://docs.example.com"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK || response.Header().Get("ETag") != `"1"` {
+		t.Fatalf("Expected code %d with ETag %q. Found %d with %q", http.StatusOK, `"1"`, response.Code, response.Header().Get("ETag"))
+	}
+
+	testCases := []struct {
+		name         string
+		ifMatch      string
+		body         string
+		expectedCode int
+	}{
+		{"stale body version", "", `{"version": 0}`, http.StatusPreconditionFailed},
+		{"stale If-Match", `"0"`, `{}`, http.StatusPreconditionFailed},
+		{"unknown If-Match", `"abc"`, `{}`, http.StatusPreconditionFailed},
+		{"negative body version", "", `{"version": -1}`, http.StatusBadRequest},
+		{"current If-Match", `W/"1"`, `{"version": 0}`, http.StatusOK},
+		{"current body version", "", `{"version": 2}`, http.StatusOK},
+		{"any version", "*", `{}`, http.StatusOK},
+	}
+	for _, test := range testCases {
+		response, request := NewTestRequest("PUT", "/admin/content", []byte(test.body))
+		if test.ifMatch != "" {
+			request.Header.Set("If-Match", test.ifMatch)
+		}
+		router.ServeHTTP(response, request)
+		if response.Code != test.expectedCode {
+			t.Errorf("%s: expected code %d. Found %d", test.name, test.expectedCode, response.Code)
+		}
+	}
+
+	response, request = NewTestRequest("GET", "/admin/content", nil)
+	router.ServeHTTP(response, request)
+	if etag := response.Header().Get("ETag"); etag != `"4"` {
+		t.Errorf("Expected ETag %q. Found %q", `"4"`, etag)
+	}
+}
+
+func TestUpdateContentWithoutAdminScope(t *testing.T) {
+	router, _ := CreateRouterWithMockSession(userTokenData, GetMockCompleteEnvVars())
+	response, request := NewTestRequest("PUT", "/admin/content", []byte(`{"quick_links": []}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected code %d. Found %d", http.StatusForbidden, response.Code)
+	}
+}
+
+func TestTheme(t *testing.T) {
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.ThemeProductNameEnvVar] = "Agency Cloud"
+	envVars[helpers.ThemePrimaryColorEnvVar] = "#205493"
+	router, _ := CreateRouterWithMockSession(adminTokenData, envVars)
+
+	response, request := NewTestRequest("PUT", "/admin/content", []byte(`{"theme": {"primary_color": "red; x: y"}}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected code %d. Found %d", http.StatusBadRequest, response.Code)
+	}
+
+	// The saved theme overrides the settings field by field.
+	response, request = NewTestRequest("PUT", "/admin/content", []byte(`{"theme": {"primary_color": "#112e51", "footer": "Operated by the Agency"}}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected code %d. Found %d", http.StatusOK, response.Code)
+	}
+	response, request = NewTestRequest("GET", "/api/config", nil)
+	router.ServeHTTP(response, request)
+	expected := NewJSONResponseContentTester(`{
+		"quick_links": [],
+		"support": {},
+		"theme": {"product_name": "Agency Cloud", "primary_color": "#112e51", "footer": "Operated by the Agency"}
+	}`)
+	if !expected.Check(t, response.Body.String()) {
+		t.Errorf("Unexpected config %s", response.Body.String())
+	}
+
+	response, request = NewTestRequest("GET", "/", nil)
+	router.ServeHTTP(response, request)
+	body := response.Body.String()
+	for _, s := range []string{
+		"<title>Agency Cloud</title>",
+		"--theme-primary-color: #112e51;",
+		`"footer":"Operated by the Agency"`,
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("Expected the index to contain %s", s)
+		}
+	}
+}
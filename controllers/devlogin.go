@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// DevLogin sets the cookie of a session minted by `cg-dashboard dev-login`,
+// given in the session parameter, and sends the user to the dashboard. It is
+// only routed with LOCAL_CF.
+func (c *Context) DevLogin(rw web.ResponseWriter, req *web.Request) {
+	value := req.URL.Query().Get("session")
+	token := helpers.DevSessionToken(c.Settings, value)
+	if !c.Settings.LocalCF || token == nil {
+		newUaaError(http.StatusBadRequest, "invalid or expired dev session.").writeTo(rw)
+		return
+	}
+	if claims, err := helpers.ParseTokenClaims(token.AccessToken); err == nil {
+		c.logger(loginLog).Warnf("logged in with a dev session as %s", claims.UserName)
+	}
+	http.SetCookie(rw, &http.Cookie{
+		Name:     helpers.DevSessionName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(c.Settings.SessionTimeouts.Absolute / time.Second),
+		Secure:   c.Settings.SecureCookies,
+		HttpOnly: true,
+	})
+	http.Redirect(rw, req.Request, "/", http.StatusFound)
+}
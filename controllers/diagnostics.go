@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/loggregatorlib/logmessage"
+	"github.com/gocraft/web"
+)
+
+// Limits of the app diagnostics.
+const (
+	// maxDiagnosticEvents is how many of the app's last events are included.
+	maxDiagnosticEvents = 10
+	// maxDiagnosticLogLines is how many staging and error log lines are
+	// included, the most recent ones.
+	maxDiagnosticLogLines = 100
+)
+
+// stagingLogSource is the source name of the staging logs.
+const stagingLogSource = "STG"
+
+// AppContext stores the session info and access token per user.
+// All routes within AppContext are about an app the user can see.
+type AppContext struct {
+	*SecureContext // Required.
+}
+
+// diagnosticLogLine is a line of the app's recent logs.
+type diagnosticLogLine struct {
+	Timestamp time.Time `json:"timestamp"`
+	// Source is where the line comes from, e.g. STG for staging or APP/PROC/WEB
+	// for the app.
+	Source  string `json:"source"`
+	Message string `json:"message"`
+}
+
+// diagnosticEvent is one of the app's last events, like a crash or an
+// update.
+type diagnosticEvent struct {
+	Type      string `json:"type"`
+	Timestamp string `json:"timestamp"`
+	Actor     string `json:"actor"`
+	// Description is why the app crashed, for crash events.
+	Description string `json:"description,omitempty"`
+}
+
+// appDiagnostics is the response of Diagnostics.
+type appDiagnostics struct {
+	AppGUID      string `json:"app_guid"`
+	AppName      string `json:"app_name"`
+	State        string `json:"state"`
+	PackageState string `json:"package_state"`
+	// Summary explains in a sentence why the app failed.
+	Summary string `json:"summary"`
+	// StagingError is the CF API's error when the last staging failed.
+	StagingError string              `json:"staging_error,omitempty"`
+	StagingLogs  []diagnosticLogLine `json:"staging_logs"`
+	// ErrorLogs are the lines the app wrote to stderr.
+	ErrorLogs []diagnosticLogLine `json:"error_logs"`
+	// Events are the app's last events, newest first.
+	Events []diagnosticEvent `json:"events"`
+	// Warnings are the parts of the diagnostics that couldn't be loaded.
+	Warnings []string `json:"warnings"`
+}
+
+// appDiagnosticsFields are the fields of Diagnostics that can be selected.
+var appDiagnosticsFields = fieldsOf(appDiagnostics{})
+
+// ccAppStaging is the state of a v2 CF API app and of its last staging.
+type ccAppStaging struct {
+	Name                     string `json:"name"`
+	State                    string `json:"state"`
+	PackageState             string `json:"package_state"`
+	StagingFailedReason      string `json:"staging_failed_reason"`
+	StagingFailedDescription string `json:"staging_failed_description"`
+}
+
+// Diagnostics collects why the app failed to stage or start in one place: the
+// CF API's staging error, the staging logs, the lines the app wrote to stderr
+// and its last events, with a one sentence summary. Everything is looked up
+// with the user's token. The logs or events are left out, with a warning,
+// when they can't be read.
+func (c *AppContext) Diagnostics(rw web.ResponseWriter, req *web.Request) {
+	guid := req.PathParams["guid"]
+	var app struct {
+		Entity ccAppStaging `json:"entity"`
+	}
+	if err := c.ccRequest("GET", "/v2/apps/"+url.PathEscape(guid), nil, &app); err != nil {
+		if isCCNotFound(err) {
+			newUaaError(http.StatusNotFound, "unknown app.").writeTo(rw)
+			return
+		}
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	diagnostics := appDiagnostics{
+		AppGUID:      guid,
+		AppName:      app.Entity.Name,
+		State:        app.Entity.State,
+		PackageState: app.Entity.PackageState,
+		StagingLogs:  []diagnosticLogLine{},
+		ErrorLogs:    []diagnosticLogLine{},
+		Events:       []diagnosticEvent{},
+		Warnings:     []string{},
+	}
+	if app.Entity.PackageState == "FAILED" {
+		diagnostics.StagingError = app.Entity.StagingFailedDescription
+		if diagnostics.StagingError == "" {
+			diagnostics.StagingError = app.Entity.StagingFailedReason
+		}
+	}
+
+	var events []ccEvent
+	var messages []*logmessage.LogMessage
+	var eventsErr, logsErr error
+	c.fanOut([]func() error{
+		func() error {
+			var page struct {
+				Resources []struct {
+					Entity ccEvent `json:"entity"`
+				} `json:"resources"`
+			}
+			eventsErr = c.ccRequest("GET", "/v2/events?"+url.Values{
+				"q":                {"actee:" + guid},
+				"order-direction":  {"desc"},
+				"results-per-page": {"10"},
+			}.Encode(), nil, &page)
+			for _, resource := range page.Resources {
+				events = append(events, resource.Entity)
+			}
+			return nil
+		},
+		func() error {
+			messages, logsErr = c.recentLogMessages(guid)
+			return nil
+		},
+	})
+
+	if eventsErr != nil {
+		diagnostics.Warnings = append(diagnostics.Warnings, "events could not be loaded: "+eventsErr.Error())
+	}
+	for i, event := range events {
+		if i == maxDiagnosticEvents {
+			break
+		}
+		diagnostics.Events = append(diagnostics.Events, diagnosticEvent{
+			Type:        event.Type,
+			Timestamp:   event.Timestamp,
+			Actor:       event.ActorName,
+			Description: event.Metadata.ExitDescription,
+		})
+	}
+	if logsErr != nil {
+		diagnostics.Warnings = append(diagnostics.Warnings, "logs could not be loaded: "+logsErr.Error())
+	}
+	for _, msg := range messages {
+		line := diagnosticLogLine{
+			Timestamp: time.Unix(0, msg.GetTimestamp()).UTC(),
+			Source:    msg.GetSourceName(),
+			Message:   strings.TrimRight(string(msg.GetMessage()), "\n"),
+		}
+		switch {
+		case line.Source == stagingLogSource:
+			diagnostics.StagingLogs = append(diagnostics.StagingLogs, line)
+		case msg.GetMessageType() == logmessage.LogMessage_ERR:
+			diagnostics.ErrorLogs = append(diagnostics.ErrorLogs, line)
+		}
+	}
+	diagnostics.StagingLogs = lastLogLines(diagnostics.StagingLogs)
+	diagnostics.ErrorLogs = lastLogLines(diagnostics.ErrorLogs)
+	diagnostics.Summary = diagnosticSummary(diagnostics)
+
+	c.writeAggregate(rw, req, appDiagnosticsFields, diagnostics)
+}
+
+// lastLogLines returns the most recent maxDiagnosticLogLines lines.
+func lastLogLines(lines []diagnosticLogLine) []diagnosticLogLine {
+	if len(lines) > maxDiagnosticLogLines {
+		return lines[len(lines)-maxDiagnosticLogLines:]
+	}
+	return lines
+}
+
+// diagnosticSummary explains in a sentence why the app failed: its staging
+// failed, or it crashed, or no failure was found.
+func diagnosticSummary(d appDiagnostics) string {
+	if d.PackageState == "FAILED" {
+		if d.StagingError == "" {
+			return "Staging failed. The staging logs may say why."
+		}
+		return "Staging failed: " + d.StagingError
+	}
+	if len(d.Events) > 0 && d.Events[0].Type == "app.crash" {
+		if d.Events[0].Description == "" {
+			return "The app crashed. Its error logs may say why."
+		}
+		return "The app crashed: " + d.Events[0].Description
+	}
+	return "No recent staging failure or crash was found."
+}
@@ -0,0 +1,90 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestAppDiagnostics(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/apps/app-1":
+			w.Write([]byte(`{"metadata": {"guid": "app-1"}, "entity": {"name": "web", "state": "STARTED", "package_state": "FAILED",
+				"staging_failed_reason": "BuildpackCompileFailed", "staging_failed_description": "App staging failed in the buildpack compile phase"}}`))
+		case "/v2/apps/app-2":
+			w.Write([]byte(`{"metadata": {"guid": "app-2"}, "entity": {"name": "worker", "state": "STARTED", "package_state": "STAGED"}}`))
+		case "/v2/events":
+			if r.URL.Query().Get("q") != "actee:app-2" {
+				w.Write([]byte(`{"resources": []}`))
+				return
+			}
+			w.Write([]byte(`{"resources": [
+				{"entity": {"type": "app.crash", "timestamp": "2018-03-01T10:00:00Z", "actor_name": "worker", "metadata": {"exit_description": "APP/PROC/WEB: Exited with status 1"}}},
+				{"entity": {"type": "audit.app.update", "timestamp": "2018-03-01T09:00:00Z", "actor_name": "user@example.com"}}
+			]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code": 100004, "error_code": "CF-AppNotFound"}`))
+		}
+	}))
+	defer cc.Close()
+	logs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer logs.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.LogURLEnvVar] = logs.URL
+
+	tests := []BasicSecureTest{
+		{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "Diagnostics Of A Staging Failure",
+				SessionData: userTokenData,
+				Location:    "/api/apps/app-1/diagnostics",
+			},
+			ExpectedCode: http.StatusOK,
+			ExpectedResponse: NewJSONResponseContentTester(`{"app_guid": "app-1", "app_name": "web", "state": "STARTED", "package_state": "FAILED",
+				"summary": "Staging failed: App staging failed in the buildpack compile phase",
+				"staging_error": "App staging failed in the buildpack compile phase",
+				"staging_logs": [], "error_logs": [], "events": [],
+				"warnings": ["logs could not be loaded: unexpected status from the recent logs: 502"]}`),
+		},
+		{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "Diagnostics Of A Crash",
+				SessionData: userTokenData,
+				Location:    "/api/apps/app-2/diagnostics?fields=summary,events",
+			},
+			ExpectedCode: http.StatusOK,
+			ExpectedResponse: NewJSONResponseContentTester(`{"summary": "The app crashed: APP/PROC/WEB: Exited with status 1", "events": [
+				{"type": "app.crash", "timestamp": "2018-03-01T10:00:00Z", "actor": "worker", "description": "APP/PROC/WEB: Exited with status 1"},
+				{"type": "audit.app.update", "timestamp": "2018-03-01T09:00:00Z", "actor": "user@example.com"}
+			]}`),
+		},
+		{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "Diagnostics Of An Unknown App",
+				SessionData: userTokenData,
+				Location:    "/api/apps/app-3/diagnostics",
+			},
+			ExpectedCode:     http.StatusNotFound,
+			ExpectedResponse: NewJSONResponseContentTester(`{"status": "failure", "data": "unknown app."}`),
+		},
+	}
+	for _, test := range tests {
+		response, request := NewTestRequest("GET", test.Location, nil)
+		router, _ := CreateRouterWithMockSession(test.SessionData, envVars)
+		router.ServeHTTP(response, request)
+		if response.Code != test.ExpectedCode {
+			t.Errorf("Test %s did not meet expected code.\nExpected %d.\nFound %d.\n", test.TestName, test.ExpectedCode, response.Code)
+		}
+		if !test.ExpectedResponse.Check(t, response.Body.String()) {
+			t.Errorf("Test %s did not contain expected value.\nExpected %s.\n Found (%s)\n.", test.TestName, test.ExpectedResponse.Display(), response.Body.String())
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gocraft/web"
+)
+
+// sharedDomain is a shared domain as shown to platform admins.
+type sharedDomain struct {
+	GUID     string `json:"guid"`
+	Name     string `json:"name"`
+	Internal bool   `json:"internal"`
+	// RouterGroupGUID is set for TCP domains.
+	RouterGroupGUID string `json:"router_group_guid,omitempty"`
+}
+
+// sharedDomains is the response of SharedDomains.
+type sharedDomains []sharedDomain
+
+// sharedDomainFields are the fields of SharedDomains that can be selected.
+var sharedDomainFields = fieldsOf(sharedDomain{})
+
+// domainRoute is a route on a domain.
+type domainRoute struct {
+	GUID      string `json:"guid"`
+	Host      string `json:"host"`
+	Path      string `json:"path,omitempty"`
+	Port      *int   `json:"port,omitempty"`
+	SpaceGUID string `json:"space_guid"`
+}
+
+// toSharedDomain converts the v2 CF API shared domain.
+func toSharedDomain(resource ccResource) (sharedDomain, error) {
+	var entity struct {
+		Name            string `json:"name"`
+		Internal        bool   `json:"internal"`
+		RouterGroupGUID string `json:"router_group_guid"`
+	}
+	err := json.Unmarshal(resource.Entity, &entity)
+	return sharedDomain{
+		GUID:            resource.Metadata.GUID,
+		Name:            entity.Name,
+		Internal:        entity.Internal,
+		RouterGroupGUID: entity.RouterGroupGUID,
+	}, err
+}
+
+// SharedDomains lists the shared domains, including internal ones.
+func (c *AdminContext) SharedDomains(rw web.ResponseWriter, req *web.Request) {
+	resources, err := c.ccGetAll("/v2/shared_domains?results-per-page=100")
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	domains := sharedDomains{}
+	for _, resource := range resources {
+		domain, err := toSharedDomain(resource)
+		if err != nil {
+			newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+			return
+		}
+		domains = append(domains, domain)
+	}
+	c.writeAggregate(rw, req, sharedDomainFields, domains)
+}
+
+// CreateSharedDomain creates a shared domain. Internal domains are only
+// reachable over container to container networking.
+func (c *AdminContext) CreateSharedDomain(rw web.ResponseWriter, req *web.Request) {
+	var body struct {
+		Name            string `json:"name"`
+		Internal        bool   `json:"internal"`
+		RouterGroupGUID string `json:"router_group_guid,omitempty"`
+	}
+	if err := readBodyToStruct(req.Body, &body); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	body.Name = strings.ToLower(strings.TrimSpace(body.Name))
+	if body.Name == "" {
+		newUaaError(http.StatusBadRequest, "name is required.").writeTo(rw)
+		return
+	}
+	if body.Internal && body.RouterGroupGUID != "" {
+		newUaaError(http.StatusBadRequest, "internal domains can't have a router group.").writeTo(rw)
+		return
+	}
+	var resource ccResource
+	if err := c.ccRequest("POST", "/v2/shared_domains", body, &resource); err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	domain, err := toSharedDomain(resource)
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "create_shared_domain", domain)
+	c.Settings.PlatformCache.DeletePrefix("/v2/shared_domains")
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(domain)
+}
+
+// DeleteSharedDomain deletes a shared domain. Since that breaks all the
+// routes on the domain, the first request only lists those routes, and the
+// domain is deleted when the request is repeated with ?confirm=<domain name>.
+func (c *AdminContext) DeleteSharedDomain(rw web.ResponseWriter, req *web.Request) {
+	path := "/v2/shared_domains/" + url.PathEscape(req.PathParams["guid"])
+	var resource ccResource
+	err := c.ccRequest("GET", path, nil, &resource)
+	if isCCNotFound(err) {
+		newUaaError(http.StatusNotFound, "unknown shared domain.").writeTo(rw)
+		return
+	}
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	domain, err := toSharedDomain(resource)
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+
+	if req.URL.Query().Get("confirm") != domain.Name {
+		routes, err := c.ccGetAll("/v2/routes?" + url.Values{
+			"q":                {"domain_guid:" + domain.GUID},
+			"results-per-page": {"100"},
+		}.Encode())
+		if err != nil {
+			newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+			return
+		}
+		affected := []domainRoute{}
+		for _, r := range routes {
+			var route domainRoute
+			if err := json.Unmarshal(r.Entity, &route); err != nil {
+				newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+				return
+			}
+			route.GUID = r.Metadata.GUID
+			affected = append(affected, route)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusConflict)
+		json.NewEncoder(rw).Encode(struct {
+			Status      string        `json:"status"`
+			Description string        `json:"error_description"`
+			Domain      sharedDomain  `json:"domain"`
+			Routes      []domainRoute `json:"routes"`
+		}{
+			Status:      "confirmation_required",
+			Description: "Deleting the domain breaks the routes on it. Repeat the request with ?confirm=" + domain.Name + " to delete it.",
+			Domain:      domain,
+			Routes:      affected,
+		})
+		return
+	}
+
+	if err := c.ccRequest("DELETE", path+"?async=false", nil, nil); err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "delete_shared_domain", domain)
+	c.Settings.PlatformCache.DeletePrefix("/v2/shared_domains")
+	rw.WriteHeader(http.StatusNoContent)
+}
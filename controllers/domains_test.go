@@ -0,0 +1,191 @@
+package controllers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestSharedDomains(t *testing.T) {
+	var calls []string
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v2/shared_domains":
+			w.Write([]byte(`{"next_url": null, "resources": [
+				{"metadata": {"guid": "domain-1"}, "entity": {"name": "apps.example.com", "internal": false}},
+				{"metadata": {"guid": "domain-2"}, "entity": {"name": "apps.internal", "internal": true}}
+			]}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/shared_domains/domain-1":
+			w.Write([]byte(`{"metadata": {"guid": "domain-1"}, "entity": {"name": "apps.example.com", "internal": false}}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/shared_domains/unknown":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/routes":
+			if r.URL.Query().Get("q") != "domain_guid:domain-1" {
+				t.Errorf("Unexpected routes query %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"next_url": null, "resources": [
+				{"metadata": {"guid": "route-1"}, "entity": {"host": "www", "path": "", "space_guid": "space-1"}}
+			]}`))
+		case r.Method == "POST" && r.URL.Path == "/v2/shared_domains":
+			body, _ := ioutil.ReadAll(r.Body)
+			calls = append(calls, "POST "+string(body))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"metadata": {"guid": "domain-3"}, "entity": {"name": "new.internal", "internal": true}}`))
+		case r.Method == "DELETE":
+			calls = append(calls, "DELETE "+r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+
+	tests := []struct {
+		name             string
+		sessionData      map[string]interface{}
+		method, location string
+		body             string
+		expectedCode     int
+		expectedResponse string
+	}{
+		{
+			name:             "List Without Admin Scope",
+			sessionData:      userTokenData,
+			method:           "GET",
+			location:         "/admin/shared_domains",
+			expectedCode:     http.StatusForbidden,
+			expectedResponse: `{"status": "forbidden", "error_description": "This needs the cloud_controller.admin scope.", "required_scope": "cloud_controller.admin", "required_scopes": ["cloud_controller.admin"]}`,
+		},
+		{
+			name:         "List",
+			sessionData:  adminTokenData,
+			method:       "GET",
+			location:     "/admin/shared_domains",
+			expectedCode: http.StatusOK,
+			expectedResponse: `[
+				{"guid": "domain-1", "name": "apps.example.com", "internal": false},
+				{"guid": "domain-2", "name": "apps.internal", "internal": true}
+			]`,
+		},
+		{
+			name:             "Create Internal",
+			sessionData:      adminTokenData,
+			method:           "POST",
+			location:         "/admin/shared_domains",
+			body:             `{"name": " New.Internal ", "internal": true}`,
+			expectedCode:     http.StatusCreated,
+			expectedResponse: `{"guid": "domain-3", "name": "new.internal", "internal": true}`,
+		},
+		{
+			name:             "Create Without Name",
+			sessionData:      adminTokenData,
+			method:           "POST",
+			location:         "/admin/shared_domains",
+			body:             `{"internal": true}`,
+			expectedCode:     http.StatusBadRequest,
+			expectedResponse: `{"status": "failure", "data": "name is required."}`,
+		},
+		{
+			name:         "Delete Without Confirmation",
+			sessionData:  adminTokenData,
+			method:       "DELETE",
+			location:     "/admin/shared_domains/domain-1",
+			expectedCode: http.StatusConflict,
+			expectedResponse: `{
+				"status": "confirmation_required",
+				"error_description": "Deleting the domain breaks the routes on it. Repeat the request with ?confirm=apps.example.com to delete it.",
+				"domain": {"guid": "domain-1", "name": "apps.example.com", "internal": false},
+				"routes": [{"guid": "route-1", "host": "www", "space_guid": "space-1"}]
+			}`,
+		},
+		{
+			name:         "Delete With Wrong Confirmation",
+			sessionData:  adminTokenData,
+			method:       "DELETE",
+			location:     "/admin/shared_domains/domain-1?confirm=apps.internal",
+			expectedCode: http.StatusConflict,
+			expectedResponse: `{
+				"status": "confirmation_required",
+				"error_description": "Deleting the domain breaks the routes on it. Repeat the request with ?confirm=apps.example.com to delete it.",
+				"domain": {"guid": "domain-1", "name": "apps.example.com", "internal": false},
+				"routes": [{"guid": "route-1", "host": "www", "space_guid": "space-1"}]
+			}`,
+		},
+		{
+			name:             "Delete Unknown",
+			sessionData:      adminTokenData,
+			method:           "DELETE",
+			location:         "/admin/shared_domains/unknown",
+			expectedCode:     http.StatusNotFound,
+			expectedResponse: `{"status": "failure", "data": "unknown shared domain."}`,
+		},
+		{
+			name:         "Delete",
+			sessionData:  adminTokenData,
+			method:       "DELETE",
+			location:     "/admin/shared_domains/domain-1?confirm=apps.example.com",
+			expectedCode: http.StatusNoContent,
+		},
+	}
+	for _, test := range tests {
+		var body []byte
+		if test.body != "" {
+			body = []byte(test.body)
+		}
+		response, request := NewTestRequest(test.method, test.location, body)
+		router, _ := CreateRouterWithMockSession(test.sessionData, envVars)
+		router.ServeHTTP(response, request)
+		if response.Code != test.expectedCode {
+			t.Errorf("Test %s: expected code %d. Found %d", test.name, test.expectedCode, response.Code)
+		}
+		if test.expectedResponse != "" {
+			expected := NewJSONResponseContentTester(test.expectedResponse)
+			if !expected.Check(t, response.Body.String()) {
+				t.Errorf("Test %s: expected %s. Found %s", test.name, expected.Display(), response.Body.String())
+			}
+		}
+	}
+
+	expectedCalls := []string{
+		`POST {"name":"new.internal","internal":true}`,
+		"DELETE /v2/shared_domains/domain-1",
+	}
+	if !reflect.DeepEqual(calls, expectedCalls) {
+		t.Errorf("Expected calls %v. Found %v", expectedCalls, calls)
+	}
+}
+
+func TestSharedDomainsPages(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		if page == "" {
+			page = "1"
+		}
+		if r.URL.Query().Get("results-per-page") != "100" {
+			t.Errorf("Expected the page size to be kept. Found %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"total_pages": 3, "next_url": "/v2/shared_domains?page=2&results-per-page=100", "resources": [
+			{"metadata": {"guid": "domain-` + page + `"}, "entity": {"name": "` + page + `.example.com"}}
+		]}`))
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(adminTokenData, envVars)
+
+	response, request := NewTestRequest("GET", "/admin/shared_domains?fields=guid", nil)
+	router.ServeHTTP(response, request)
+	expected := NewJSONResponseContentTester(`[{"guid": "domain-1"}, {"guid": "domain-2"}, {"guid": "domain-3"}]`)
+	if !expected.Check(t, response.Body.String()) {
+		t.Errorf("Expected %s. Found %s", expected.Display(), response.Body.String())
+	}
+}
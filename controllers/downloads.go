@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// downloadURLRequest is the body of a request for a signed download URL.
+type downloadURLRequest struct {
+	// Path is the path and query of the download, e.g.
+	// /stacks/migration?format=csv.
+	Path string `json:"path"`
+}
+
+// downloadURLResponse is a signed download URL.
+type downloadURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateDownloadURL returns a short-lived signed URL for a log, droplet or
+// report download, which can be fetched without the user's session, e.g. by
+// a download manager.
+func (c *MeContext) CreateDownloadURL(rw web.ResponseWriter, req *web.Request) {
+	var body downloadURLRequest
+	if err := readBodyToStruct(req.Body, &body); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	signed, expiresAt, err := c.Settings.Downloads.Sign(body.Path, c.Token)
+	if err == helpers.ErrNotDownloadable {
+		newUaaError(http.StatusBadRequest, err.Error()).writeTo(rw)
+		return
+	}
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	c.logger(securityLog).Infof("signed a download URL for %s", body.Path)
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(downloadURLResponse{
+		URL:       c.Settings.AppURL + signed,
+		ExpiresAt: expiresAt,
+	})
+}
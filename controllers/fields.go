@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gocraft/web"
+)
+
+// fieldSet lists the fields of an aggregate endpoint's response that clients
+// can select with ?fields=, e.g. ?fields=buildpack,apps.name. Fields of
+// objects in arrays are selected for each object.
+type fieldSet []string
+
+// fieldTree is the selected fields. A nil subtree selects the whole field.
+type fieldTree map[string]fieldTree
+
+// fieldsOf lists the fields of the JSON encoding of v, including the fields
+// of nested objects.
+func fieldsOf(v interface{}) fieldSet {
+	return appendFields(nil, "", reflect.TypeOf(v))
+}
+
+func appendFields(fields fieldSet, prefix string, t reflect.Type) fieldSet {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+		return fields
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Anonymous && name == "" {
+			fields = appendFields(fields, prefix, f.Type)
+			continue
+		}
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, prefix+name)
+		fields = appendFields(fields, prefix+name+".", f.Type)
+	}
+	return fields
+}
+
+// parse parses the comma separated fields, which must be in the set.
+func (set fieldSet) parse(param string) (fieldTree, *UaaError) {
+	allowed := make(map[string]bool, len(set))
+	for _, field := range set {
+		allowed[field] = true
+	}
+	tree := fieldTree{}
+	for _, field := range strings.Split(param, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !allowed[field] {
+			return nil, newUaaError(http.StatusBadRequest, "unknown field "+field+". Allowed fields: "+strings.Join(set, ", ")+".")
+		}
+		node := tree
+		parts := strings.Split(field, ".")
+		for i, part := range parts {
+			sub, ok := node[part]
+			if ok && sub == nil {
+				// The whole field is already selected.
+				break
+			}
+			if i == len(parts)-1 {
+				node[part] = nil
+				break
+			}
+			if !ok {
+				sub = fieldTree{}
+				node[part] = sub
+			}
+			node = sub
+		}
+	}
+	return tree, nil
+}
+
+// prune removes the fields that weren't selected from the decoded JSON.
+func (tree fieldTree) prune(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		pruned := make(map[string]interface{}, len(tree))
+		for field, sub := range tree {
+			value, ok := v[field]
+			if !ok {
+				continue
+			}
+			if sub != nil {
+				value = sub.prune(value)
+			}
+			pruned[field] = value
+		}
+		return pruned
+	case []interface{}:
+		for i := range v {
+			v[i] = tree.prune(v[i])
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// writeAggregate writes the response of an aggregate endpoint as JSON, with
+// only the fields selected with ?fields= if any. Reports that are tables can
+// be downloaded as CSV or XLSX instead, with ?format= or Accept. Responses have an ETag, and
+// a request with a matching If-None-Match gets an empty 304 instead, so
+// polling clients don't download unchanged payloads again. The locale and
+// time zone to format the response for are sent as headers.
+func (c *SecureContext) writeAggregate(rw web.ResponseWriter, req *web.Request, fields fieldSet, v interface{}) {
+	format, uaaErr := reportFormat(req)
+	if uaaErr != nil {
+		uaaErr.writeTo(rw)
+		return
+	}
+	if format != reportJSON {
+		table, ok := v.(reportTable)
+		if !ok {
+			newUaaError(http.StatusNotAcceptable, "this response is only available as json.").writeTo(rw)
+			return
+		}
+		c.writeTable(rw, format, table)
+		return
+	}
+	c.writeFormatHints(rw, req)
+	if param := req.URL.Query().Get("fields"); param != "" {
+		tree, uaaErr := fields.parse(param)
+		if uaaErr != nil {
+			uaaErr.writeTo(rw)
+			return
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+			return
+		}
+		// Keep numbers as they are rather than converting them to floats.
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.UseNumber()
+		var decoded interface{}
+		if err := decoder.Decode(&decoded); err != nil {
+			newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+			return
+		}
+		v = tree.prune(decoded)
+	}
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	sum := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	// Let browsers keep the response, but only use it after revalidating.
+	rw.Header().Set("Cache-Control", "private, no-cache")
+	rw.Header().Del("Expires")
+	rw.Header().Del("Pragma")
+	rw.Header().Set("ETag", etag)
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(body.Bytes())
+}
+
+// etagMatches returns true if the If-None-Match header matches the ETag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
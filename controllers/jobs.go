@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+// JobsContext stores the session info and access token per user.
+// All routes within JobsContext are for background jobs started by the user.
+type JobsContext struct {
+	*SecureContext // Required.
+}
+
+// GetJob returns the status and per task results of a job started by the
+// user.
+func (c *JobsContext) GetJob(rw web.ResponseWriter, req *web.Request) {
+	job, ok := c.Settings.Jobs.Get(req.PathParams["id"])
+	// Don't let users see each others jobs. Jobs started with an opaque token
+	// have no owner and can't be looked up.
+	if !ok || job.Owner == "" || job.Owner != c.userID() {
+		rw.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(rw).Encode(struct {
+			Status      string `json:"status"`
+			Description string `json:"error_description"`
+		}{
+			Status:      "Not found",
+			Description: "Unknown job.",
+		})
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(job)
+}
+
+// submitJob starts the tasks as a job owned by the user and responds with
+// the job. It returns false if the job could not be started.
+func (c *SecureContext) submitJob(rw http.ResponseWriter, kind string, tasks []jobs.Task) (jobs.Job, bool) {
+	return c.submitJobWithOptions(rw, kind, tasks, jobs.Options{})
+}
+
+// submitJobWithOptions is like submitJob, with options for running the
+// tasks.
+func (c *SecureContext) submitJobWithOptions(rw http.ResponseWriter, kind string, tasks []jobs.Task, opts jobs.Options) (jobs.Job, bool) {
+	job, err := c.Settings.Jobs.SubmitWithOptions(kind, c.userID(), tasks, opts)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return job, false
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Location", "/jobs/"+job.ID)
+	rw.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(rw).Encode(job)
+	return job, true
+}
@@ -12,6 +12,8 @@ import (
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"sort"
 )
 
 // LogContext stores the session info and access token per user.
@@ -93,3 +95,46 @@ func (c *LogContext) ParseLogMessages(body *io.ReadCloser, contentType string) (
 	buffer.Reset()
 	return &messages, nil
 }
+
+// recentLogMessages reads the recent log messages of the app from the
+// loggregator with the user's token, oldest first.
+func (c *SecureContext) recentLogMessages(appGUID string) ([]*logmessage.LogMessage, error) {
+	client := c.Settings.OAuthConfig.Client(c.Settings.CreateContext(), &c.Token)
+	res, err := client.Get(c.Settings.LogURL + "/recent?" + url.Values{"app": {appGUID}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from the recent logs: %d", res.StatusCode)
+	}
+	_, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	var messages []*logmessage.LogMessage
+	reader := multipart.NewReader(res.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var buffer bytes.Buffer
+		if _, err := buffer.ReadFrom(part); err != nil {
+			return nil, err
+		}
+		part.Close()
+		msg := &logmessage.LogMessage{}
+		if err := proto.Unmarshal(buffer.Bytes(), msg); err != nil {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].GetTimestamp() < messages[j].GetTimestamp()
+	})
+	return messages, nil
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+// logLevelsUpdate is the body of a change of the log levels.
+type logLevelsUpdate struct {
+	// Default is the new default level. Empty keeps the current one.
+	Default string `json:"default"`
+	// Modules replace all the overrides of the default level.
+	Modules map[string]string `json:"modules"`
+}
+
+// LogLevels shows the default log level, the modules' overrides and the
+// modules that log.
+func (c *AdminContext) LogLevels(rw web.ResponseWriter, req *web.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(logging.Levels.Snapshot())
+}
+
+// UpdateLogLevels changes the log levels of this instance until it restarts,
+// e.g. to debug the login flow in production. Other instances keep theirs.
+func (c *AdminContext) UpdateLogLevels(rw web.ResponseWriter, req *web.Request) {
+	var update logLevelsUpdate
+	if err := readBodyToStruct(req.Body, &update); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	level, err := logging.ParseLevel(logging.Levels.Snapshot().Default)
+	if update.Default != "" {
+		level, err = logging.ParseLevel(update.Default)
+	}
+	if err != nil {
+		newUaaError(http.StatusBadRequest, err.Error()).writeTo(rw)
+		return
+	}
+	overrides := map[string]logging.Level{}
+	for module, name := range update.Modules {
+		if overrides[module], err = logging.ParseLevel(name); err != nil {
+			newUaaError(http.StatusBadRequest, "module "+module+": "+err.Error()).writeTo(rw)
+			return
+		}
+	}
+	if err := logging.Levels.Set(level, overrides); err != nil {
+		newUaaError(http.StatusBadRequest, err.Error()).writeTo(rw)
+		return
+	}
+	levels := logging.Levels.Snapshot()
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "update_log_levels", struct {
+		Default string            `json:"default"`
+		Modules map[string]string `json:"modules"`
+	}{
+		Default: levels.Default,
+		Modules: levels.Modules,
+	})
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(levels)
+}
@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/loggregatorlib/logmessage"
+	"github.com/gocraft/web"
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"golang.org/x/oauth2"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+const (
+	// logStreamBuffer is how many log messages are buffered for a browser
+	// that reads them slower than the app logs. More are dropped, and the
+	// browser is told how many.
+	logStreamBuffer = 256
+	// logStreamWriteTimeout closes the streams of browsers that stopped
+	// reading altogether.
+	logStreamWriteTimeout = 10 * time.Second
+
+	// closeSessionExpired is the close code sent when the user's session
+	// expires while streaming, so the frontend sends them to log in.
+	closeSessionExpired = 4001
+	// closeTokenExpiring is the close code sent before the user's token
+	// expires, so the frontend reconnects, which renews it.
+	closeTokenExpiring = 4002
+)
+
+// logStreamMessage is a log message of the app, or how many were dropped
+// because the browser fell behind.
+type logStreamMessage struct {
+	Message     string `json:"message,omitempty"`
+	MessageType string `json:"message_type,omitempty"`
+	SourceType  string `json:"source_type,omitempty"`
+	Timestamp   int64  `json:"timestamp,omitempty"`
+	Dropped     int64  `json:"dropped,omitempty"`
+}
+
+// logStream proxies the loggregator's stream of an app's logs to a browser's
+// websocket.
+type logStream struct {
+	c       *APIContext
+	req     *http.Request
+	appGUID string
+	conn    *websocket.Conn
+
+	messages chan logStreamMessage
+	dropped  int64
+	// closed receives the error the loggregator connection closed with.
+	closed chan error
+	done   chan struct{}
+}
+
+// LogStream streams the app's logs over a websocket, as an alternative to
+// polling the recent logs. The user's session is checked while the stream is
+// open, and the stream is closed when it ends or before the token expires.
+func (c *APIContext) LogStream(rw web.ResponseWriter, req *web.Request) {
+	guard := c.Settings.StreamGuard
+	if !guard.CheckOrigin(req.Request) {
+		newUaaError(http.StatusForbidden, "origin not allowed").writeTo(rw)
+		return
+	}
+	// The streams are only limited for users whose ID is known, so users
+	// of opaque tokens UAA doesn't say the ID of don't share a limit.
+	if userID := c.userID(); userID != "" {
+		release, ok := guard.Acquire(userID)
+		if !ok {
+			newUaaError(http.StatusTooManyRequests, "too many open log streams").writeTo(rw)
+			return
+		}
+		defer release()
+	}
+	appGUID := req.PathParams["guid"]
+	upstream, err := c.dialLogStream(appGUID, c.Token)
+	if err != nil {
+		c.logger(proxyLog).Errorf("unable to open the log stream of app %s: %v", appGUID, err)
+		newUaaError(http.StatusBadGateway, "unable to open the log stream").writeTo(rw)
+		return
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: guard.CheckOrigin}
+	conn, err := upgrader.Upgrade(rw, req.Request, nil)
+	if err != nil {
+		// The upgrader already responded.
+		upstream.Close()
+		return
+	}
+	// The server's read timeout still applies to the hijacked connection.
+	// The stream has its own idle timeout instead.
+	conn.UnderlyingConn().SetReadDeadline(time.Time{})
+	stream := &logStream{
+		c:        c,
+		req:      req.Request,
+		appGUID:  appGUID,
+		conn:     conn,
+		messages: make(chan logStreamMessage, logStreamBuffer),
+		closed:   make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	stream.run(upstream)
+}
+
+// dialLogStream connects to the loggregator's stream of the app's logs with
+// the token.
+func (c *SecureContext) dialLogStream(appGUID string, token oauth2.Token) (*websocket.Conn, error) {
+	u, err := url.Parse(c.Settings.LogURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/tail/"
+	u.RawQuery = url.Values{"app": {appGUID}}.Encode()
+	dialer := websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: helpers.TimeoutConstant}
+	if transport, ok := c.Settings.HTTPClient.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = transport.TLSClientConfig
+	}
+	header := http.Header{"Authorization": {token.Type() + " " + token.AccessToken}}
+	c.forwardRequestID(header)
+	conn, res, err := dialer.Dial(u.String(), header)
+	if err != nil && res != nil {
+		err = fmt.Errorf("%v (status %d)", err, res.StatusCode)
+	}
+	return conn, err
+}
+
+// run proxies the logs until the browser or the loggregator closes its
+// connection, the stream is idle for too long, the user's session ends or
+// their token is about to expire. The hijacked connection can't save a
+// renewed token in a cookie session, so the browser reconnects to renew it.
+func (s *logStream) run(upstream *websocket.Conn) {
+	guard := s.c.Settings.StreamGuard
+	defer func() {
+		close(s.done)
+		upstream.Close()
+		s.conn.Close()
+	}()
+	go s.readUpstream(upstream)
+
+	// The browser only sends control frames, which are read to notice it
+	// closing the stream.
+	activity := make(chan struct{}, 1)
+	browserClosed := make(chan struct{})
+	go func() {
+		defer close(browserClosed)
+		for {
+			if _, _, err := s.conn.ReadMessage(); err != nil {
+				return
+			}
+			select {
+			case activity <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	idle := time.NewTimer(guard.IdleTimeout)
+	defer idle.Stop()
+	resetIdle := func() {
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(guard.IdleTimeout)
+	}
+	tokenCheck := time.NewTicker(guard.TokenCheckInterval)
+	defer tokenCheck.Stop()
+
+	for {
+		select {
+		case msg := <-s.messages:
+			if dropped := atomic.SwapInt64(&s.dropped, 0); dropped > 0 {
+				if s.write(logStreamMessage{Dropped: dropped}) != nil {
+					return
+				}
+			}
+			if s.write(msg) != nil {
+				return
+			}
+			resetIdle()
+		case <-activity:
+			resetIdle()
+		case <-browserClosed:
+			return
+		case <-idle.C:
+			s.close(websocket.CloseNormalClosure, "idle")
+			return
+		case err := <-s.closed:
+			s.c.logger(proxyLog).Warnf("the log stream of app %s closed: %v", s.appGUID, err)
+			s.close(websocket.CloseInternalServerErr, "log stream closed")
+			return
+		case <-tokenCheck.C:
+			token := helpers.SessionToken(s.req, s.c.Settings)
+			if token == nil {
+				s.close(closeSessionExpired, "session expired")
+				return
+			}
+			// The token must last until the next check.
+			if !token.Expiry.IsZero() && token.Expiry.Before(time.Now().Add(guard.TokenCheckInterval)) {
+				s.close(closeTokenExpiring, "token expiring")
+				return
+			}
+		}
+	}
+}
+
+// readUpstream reads the log messages from a loggregator connection until it
+// closes. Messages the browser has no room for are dropped rather than
+// holding up the loggregator.
+func (s *logStream) readUpstream(upstream *websocket.Conn) {
+	for {
+		_, data, err := upstream.ReadMessage()
+		if err != nil {
+			select {
+			case s.closed <- err:
+			case <-s.done:
+			}
+			return
+		}
+		var msg logmessage.LogMessage
+		if err := proto.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		select {
+		case s.messages <- logStreamMessage{
+			Message:     string(msg.GetMessage()),
+			MessageType: msg.GetMessageType().String(),
+			SourceType:  msg.GetSourceName(),
+			Timestamp:   msg.GetTimestamp(),
+		}:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+}
+
+func (s *logStream) write(msg logStreamMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(logStreamWriteTimeout))
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (s *logStream) close(code int, reason string) {
+	s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(logStreamWriteTimeout))
+}
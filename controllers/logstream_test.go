@@ -0,0 +1,73 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry/loggregatorlib/logmessage"
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestLogStream(t *testing.T) {
+	loggregator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tail/" || r.URL.Query().Get("app") != "app-1" {
+			t.Errorf("Expected the stream of app-1. Found %s", r.URL)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			t.Errorf("Expected the user's token. Found %q", r.Header.Get("Authorization"))
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := proto.Marshal(&logmessage.LogMessage{
+			Message:     []byte("hello"),
+			MessageType: logmessage.LogMessage_OUT.Enum(),
+			Timestamp:   proto.Int64(42),
+			AppId:       proto.String("app-1"),
+			SourceName:  proto.String("APP/PROC/WEB"),
+		})
+		conn.WriteMessage(websocket.BinaryMessage, data)
+		conn.ReadMessage()
+	}))
+	defer loggregator.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.LogURLEnvVar] = loggregator.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	streamURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/v2/apps/app-1/logstream"
+
+	_, res, err := websocket.DefaultDialer.Dial(streamURL, http.Header{"Origin": {"https://evil.example.com"}})
+	if err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected another origin to be forbidden. Found %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(streamURL, http.Header{"Origin": {envVars[helpers.HostnameEnvVar]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var msg struct {
+		Message     string `json:"message"`
+		MessageType string `json:"message_type"`
+		SourceType  string `json:"source_type"`
+		Timestamp   int64  `json:"timestamp"`
+	}
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(data, &msg)
+	if msg.Message != "hello" || msg.MessageType != "OUT" || msg.SourceType != "APP/PROC/WEB" || msg.Timestamp != 42 {
+		t.Errorf("Expected the log message. Found %s", data)
+	}
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var pageLog = logging.New("pages")
+
+// renderPage writes a server-rendered page with the deployment's theme. These
+// pages don't need the frontend bundle, so they work when it doesn't load.
+func (c *Context) renderPage(rw web.ResponseWriter, status int, page helpers.Page) {
+	page.Theme = c.theme()
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	if err := c.templates.GetPage(rw, page); err != nil {
+		c.logger(pageLog).Errorf("unable to render the %q page: %v", page.Title, err)
+	}
+}
+
+// wantsHTML returns true if the request comes from a browser navigating to a
+// page rather than from the frontend or a script calling the API.
+func wantsHTML(req *web.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+// Login is the page that starts the login, for when the frontend can't.
+func (c *Context) Login(rw web.ResponseWriter, req *web.Request) {
+	c.renderPage(rw, http.StatusOK, helpers.Page{
+		Title:      "Log in",
+		Paragraphs: []string{"Log in with your cloud.gov account to manage your organizations, spaces and applications."},
+		Link:       &helpers.PageLink{Text: "Log in", URL: "/handshake"},
+	})
+}
+
+// LoggedOut is the page shown after logging out. UAA's logout can be
+// configured to redirect to it.
+func (c *Context) LoggedOut(rw web.ResponseWriter, req *web.Request) {
+	c.renderPage(rw, http.StatusOK, helpers.Page{
+		Title:      "You're logged out",
+		Paragraphs: []string{"You have been logged out of the dashboard."},
+		Link:       &helpers.PageLink{Text: "Log in again", URL: "/handshake"},
+	})
+}
+
+// NotFound is the page for the paths that don't exist. Requests that aren't
+// for a page get a JSON error, like the rest of the API.
+func (c *Context) NotFound(rw web.ResponseWriter, req *web.Request) {
+	if !wantsHTML(req) {
+		newUaaError(http.StatusNotFound, "not found.").writeTo(rw)
+		return
+	}
+	c.renderPage(rw, http.StatusNotFound, helpers.Page{
+		Title:      "Page not found",
+		Paragraphs: []string{"The page you're looking for doesn't exist. Check the address, or go back to the dashboard."},
+		Link:       &helpers.PageLink{Text: "Go to the dashboard", URL: "/"},
+	})
+}
+
+// loginFailed is the page shown when the login can't be completed. The
+// failure is logged with the request ID, which is shown on the page too, so
+// operators can find the cause from a user's report.
+func (c *Context) loginFailed(rw web.ResponseWriter, status int, reason string, cause error) {
+	if status >= http.StatusInternalServerError {
+		c.logger(loginLog).Errorf("login failed with status %d: %v", status, cause)
+	} else {
+		c.logger(loginLog).Warnf("login failed with status %d: %v", status, cause)
+	}
+	c.renderPage(rw, status, helpers.Page{
+		Title: "Your login could not be completed",
+		Paragraphs: []string{reason, "Try logging in again. If the problem continues, contact support " +
+			"with the reference " + c.requestID + "."},
+		Link: &helpers.PageLink{Text: "Log in again", URL: "/handshake"},
+	})
+}
+
+// sessionStoreBusy fails the login because the session store is at capacity
+// and refuses new sessions, asking users to come back later.
+func (c *Context) sessionStoreBusy(rw web.ResponseWriter, cause error) {
+	rw.Header().Set("Retry-After", "300")
+	c.loginFailed(rw, http.StatusServiceUnavailable, c.Settings.SessionBusyMessage, cause)
+}
+
+// MaintenanceMiddleware answers all requests, except health checks and
+// assets, with the maintenance message while the dashboard is in maintenance.
+func (c *Context) MaintenanceMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	message := c.Settings.MaintenanceMessage
+	if message == "" || IsSessionlessPath(req.URL.Path) {
+		next(rw, req)
+		return
+	}
+	rw.Header().Set("Retry-After", "300")
+	if !wantsHTML(req) {
+		newUaaError(http.StatusServiceUnavailable, message).writeTo(rw)
+		return
+	}
+	c.renderPage(rw, http.StatusServiceUnavailable, helpers.Page{
+		Title:      "The dashboard is down for maintenance",
+		Paragraphs: []string{message, "Your applications keep running while the dashboard is unavailable."},
+	})
+}
@@ -0,0 +1,121 @@
+package controllers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+var pageTests = []struct {
+	name         string
+	path         string
+	accept       string
+	envVars      map[string]string
+	expectedCode int
+	expectedBody string
+}{
+	{
+		name:         "login",
+		path:         "/login",
+		accept:       "text/html",
+		expectedCode: http.StatusOK,
+		expectedBody: `<a class="button" href="/handshake">Log in</a>`,
+	},
+	{
+		name:         "logged out",
+		path:         "/logged-out",
+		accept:       "text/html",
+		expectedCode: http.StatusOK,
+		expectedBody: "<h1>You&#39;re logged out</h1>",
+	},
+	{
+		name:         "not found page",
+		path:         "/no-such-page",
+		accept:       "text/html,application/xhtml+xml",
+		expectedCode: http.StatusNotFound,
+		expectedBody: "<h1>Page not found</h1>",
+	},
+	{
+		name:         "not found API",
+		path:         "/no-such-page",
+		accept:       "application/json",
+		expectedCode: http.StatusNotFound,
+		expectedBody: `"status":"failure"`,
+	},
+	{
+		name:         "login with mismatched state",
+		path:         "/oauth2callback?code=code&state=other",
+		accept:       "text/html",
+		expectedCode: http.StatusUnauthorized,
+		expectedBody: "<h1>Your login could not be completed</h1>",
+	},
+	{
+		name:         "maintenance page",
+		path:         "/",
+		accept:       "text/html",
+		envVars:      map[string]string{helpers.MaintenanceMessageEnvVar: "Back at 5pm."},
+		expectedCode: http.StatusServiceUnavailable,
+		expectedBody: "<p>Back at 5pm.</p>",
+	},
+	{
+		name:         "maintenance API",
+		path:         "/v2/info",
+		envVars:      map[string]string{helpers.MaintenanceMessageEnvVar: "Back at 5pm."},
+		expectedCode: http.StatusServiceUnavailable,
+		expectedBody: "Back at 5pm.",
+	},
+	{
+		name:         "health check during maintenance",
+		path:         "/ping",
+		envVars:      map[string]string{helpers.MaintenanceMessageEnvVar: "Back at 5pm."},
+		expectedCode: http.StatusOK,
+		expectedBody: `"status":"alive"`,
+	},
+}
+
+func TestPages(t *testing.T) {
+	for _, test := range pageTests {
+		envVars := GetMockCompleteEnvVars()
+		for k, v := range test.envVars {
+			envVars[k] = v
+		}
+		router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+		response, request := NewTestRequest("GET", test.path, nil)
+		request.Header.Set("Accept", test.accept)
+		router.ServeHTTP(response, request)
+		if response.Code != test.expectedCode {
+			t.Errorf("%s: expected code %d. Found %d", test.name, test.expectedCode, response.Code)
+		}
+		if !strings.Contains(response.Body.String(), test.expectedBody) {
+			t.Errorf("%s: expected the body to contain %s. Found %s", test.name, test.expectedBody, response.Body.String())
+		}
+	}
+}
+
+func TestLoginFailureReference(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+	}{
+		{"mismatched state", "/oauth2callback?code=code&state=other", http.StatusUnauthorized},
+		{"denied", "/oauth2callback?state=state&error=access_denied", http.StatusBadRequest},
+		{"unreachable UAA", "/oauth2callback?code=code&state=state", http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		router, _ := CreateRouterWithMockSession(map[string]interface{}{"state": "state"}, GetMockCompleteEnvVars())
+		response, request := NewTestRequest("GET", test.path, nil)
+		request.Header.Set("Accept", "text/html")
+		request.Header.Set("X-Vcap-Request-Id", "login-request-1")
+		router.ServeHTTP(response, request)
+		if response.Code != test.expectedCode {
+			t.Errorf("%s: expected code %d. Found %d", test.name, test.expectedCode, response.Code)
+		}
+		if !strings.Contains(response.Body.String(), "with the reference login-request-1.") {
+			t.Errorf("%s: expected the request ID in the page. Found %s", test.name, response.Body.String())
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/gocraft/web"
+)
+
+// PlatformContext stores the session info and access token per user.
+// All routes within PlatformContext are for platform operators only.
+type PlatformContext struct {
+	*SecureContext // Required.
+}
+
+// platformMetrics maps the metric names exposed to operators to the log
+// cache PromQL queries backing them. Only these metrics can be read, so the
+// endpoint can't be used to read arbitrary application data.
+var platformMetrics = map[string]string{
+	"router_throughput":         `rate(total_requests{source_id="gorouter"}[1m])`,
+	"cell_remaining_memory":     `CapacityRemainingMemory{source_id="rep"}`,
+	"cell_total_memory":         `CapacityTotalMemory{source_id="rep"}`,
+	"cell_remaining_disk":       `CapacityRemainingDisk{source_id="rep"}`,
+	"cell_total_disk":           `CapacityTotalDisk{source_id="rep"}`,
+	"cell_remaining_containers": `CapacityRemainingContainers{source_id="rep"}`,
+	"cell_containers":           `ContainerCount{source_id="rep"}`,
+}
+
+// Health returns the platform's traffic light for the header widget, to any
+// logged in user. Only admins see the capacity headroom.
+func (c *PlatformContext) Health(rw web.ResponseWriter, req *web.Request) {
+	summary := c.Settings.PlatformHealth.Summary(req.Context(), c.hasScope(adminScope))
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(summary)
+}
+
+// ListMetrics returns the names of the platform metrics that can be read.
+func (c *PlatformContext) ListMetrics(rw web.ResponseWriter, req *web.Request) {
+	names := make([]string, 0, len(platformMetrics))
+	for name := range platformMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(struct {
+		Metrics []string `json:"metrics"`
+	}{
+		Metrics: names,
+	})
+}
+
+// Metric returns the current value of a single platform metric from log cache.
+func (c *PlatformContext) Metric(rw web.ResponseWriter, req *web.Request) {
+	query, ok := platformMetrics[req.PathParams["metric"]]
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(rw).Encode(struct {
+			Status      string `json:"status"`
+			Description string `json:"error_description"`
+		}{
+			Status:      "Not found",
+			Description: "Unknown platform metric.",
+		})
+		return
+	}
+	reqURL := fmt.Sprintf("%s/api/v1/query?%s", c.Settings.LogCacheURL, url.Values{
+		"query": {query},
+	}.Encode())
+	c.Proxy(rw, req.Request, reqURL, c.GenericResponseHandler)
+}
+
+// logCacheQueryResponse is the response of a log cache PromQL instant query.
+type logCacheQueryResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		Result []struct {
+			Metric map[string]string `json:"metric"`
+			// Value is a [timestamp, "value"] pair.
+			Value []interface{} `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// queryLogCache runs a PromQL instant query for one of the platform metrics
+// and returns the value for each cell, keyed by the cell index (or IP).
+func (c *PlatformContext) queryLogCache(metric string) (map[string]float64, error) {
+	reqURL := fmt.Sprintf("%s/api/v1/query?%s", c.Settings.LogCacheURL, url.Values{
+		"query": {platformMetrics[metric]},
+	}.Encode())
+	req, _ := http.NewRequest("GET", reqURL, nil)
+	w := httptest.NewRecorder()
+	c.Proxy(w, req, reqURL, c.GenericResponseHandler)
+	if w.Code != http.StatusOK {
+		return nil, fmt.Errorf("unable to query log cache for %s", metric)
+	}
+	var resp logCacheQueryResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		return nil, err
+	}
+	values := make(map[string]float64)
+	for _, sample := range resp.Data.Result {
+		cell := sample.Metric["index"]
+		if cell == "" {
+			cell = sample.Metric["ip"]
+		}
+		if len(sample.Value) != 2 {
+			continue
+		}
+		raw, _ := sample.Value[1].(string)
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		values[cell] = value
+	}
+	return values, nil
+}
+
+// expectedInstances returns the number of app instances CC expects to be
+// running across all started apps.
+func (c *PlatformContext) expectedInstances() (int, error) {
+	total := 0
+	err := c.ccEach("/v2/apps?"+url.Values{
+		"q":                {"state:STARTED"},
+		"results-per-page": {"100"},
+	}.Encode(), func(dec *json.Decoder) error {
+		var app struct {
+			Entity struct {
+				Instances int `json:"instances"`
+			} `json:"entity"`
+		}
+		if err := dec.Decode(&app); err != nil {
+			return err
+		}
+		total += app.Entity.Instances
+		return nil
+	})
+	return total, err
+}
+
+// cellCapacity is the capacity of a single Diego cell. Sizes are in MB.
+type cellCapacity struct {
+	Cell              string  `json:"cell"`
+	TotalMemoryMB     float64 `json:"total_memory_mb"`
+	RemainingMemoryMB float64 `json:"remaining_memory_mb"`
+	TotalDiskMB       float64 `json:"total_disk_mb"`
+	RemainingDiskMB   float64 `json:"remaining_disk_mb"`
+	Instances         int     `json:"instances"`
+}
+
+// capacityReport summarizes the capacity of the platform.
+type capacityReport struct {
+	Cells             []cellCapacity `json:"cells"`
+	TotalMemoryMB     float64        `json:"total_memory_mb"`
+	RemainingMemoryMB float64        `json:"remaining_memory_mb"`
+	TotalDiskMB       float64        `json:"total_disk_mb"`
+	RemainingDiskMB   float64        `json:"remaining_disk_mb"`
+	PlacedInstances   int            `json:"placed_instances"`
+	ExpectedInstances int            `json:"expected_instances"`
+}
+
+// CapacityReport summarizes the memory and disk capacity of each Diego cell
+// and how app instances are distributed across them.
+func (c *PlatformContext) CapacityReport(rw web.ResponseWriter, req *web.Request) {
+	metrics := make(map[string]map[string]float64)
+	for _, metric := range []string{"cell_total_memory", "cell_remaining_memory",
+		"cell_total_disk", "cell_remaining_disk", "cell_containers"} {
+		values, err := c.queryLogCache(metric)
+		if err != nil {
+			newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+			return
+		}
+		metrics[metric] = values
+	}
+	expected, err := c.expectedInstances()
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+
+	report := capacityReport{Cells: []cellCapacity{}, ExpectedInstances: expected}
+	for cell, totalMemory := range metrics["cell_total_memory"] {
+		capacity := cellCapacity{
+			Cell:              cell,
+			TotalMemoryMB:     totalMemory,
+			RemainingMemoryMB: metrics["cell_remaining_memory"][cell],
+			TotalDiskMB:       metrics["cell_total_disk"][cell],
+			RemainingDiskMB:   metrics["cell_remaining_disk"][cell],
+			Instances:         int(metrics["cell_containers"][cell]),
+		}
+		report.Cells = append(report.Cells, capacity)
+		report.TotalMemoryMB += capacity.TotalMemoryMB
+		report.RemainingMemoryMB += capacity.RemainingMemoryMB
+		report.TotalDiskMB += capacity.TotalDiskMB
+		report.RemainingDiskMB += capacity.RemainingDiskMB
+		report.PlacedInstances += capacity.Instances
+	}
+	sort.Slice(report.Cells, func(i, j int) bool {
+		return report.Cells[i].Cell < report.Cells[j].Cell
+	})
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(report)
+}
@@ -0,0 +1,156 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+var operatorTokenData = NewTokenData(map[string]interface{}{
+	"user_id": "operator-guid",
+	"scope":   []string{"openid", helpers.FirehoseScope},
+})
+
+var userTokenData = NewTokenData(map[string]interface{}{
+	"user_id": "user-guid",
+	"scope":   []string{"openid", "cloud_controller.read"},
+})
+
+var platformMetricsTests = []BasicSecureTest{
+	{
+		BasicConsoleUnitTest: BasicConsoleUnitTest{
+			TestName:    "Platform Metrics Without Firehose Scope",
+			SessionData: userTokenData,
+			Location:    "/platform/metrics",
+		},
+		ExpectedCode:     http.StatusForbidden,
+		ExpectedResponse: NewJSONResponseContentTester(`{"status": "forbidden", "error_description": "This needs the doppler.firehose scope.", "required_scope": "doppler.firehose", "required_scopes": ["doppler.firehose"]}`),
+	},
+	{
+		BasicConsoleUnitTest: BasicConsoleUnitTest{
+			TestName:    "Platform Metrics List",
+			SessionData: operatorTokenData,
+			Location:    "/platform/metrics",
+		},
+		ExpectedCode:     http.StatusOK,
+		ExpectedResponse: NewJSONResponseContentTester(`{"metrics": ["cell_containers", "cell_remaining_containers", "cell_remaining_disk", "cell_remaining_memory", "cell_total_disk", "cell_total_memory", "router_throughput"]}`),
+	},
+	{
+		BasicConsoleUnitTest: BasicConsoleUnitTest{
+			TestName:    "Platform Metric From Log Cache",
+			SessionData: operatorTokenData,
+			Location:    "/platform/metrics/cell_total_memory",
+		},
+		ExpectedCode:     http.StatusOK,
+		ExpectedResponse: NewJSONResponseContentTester(`{"status": "success"}`),
+	},
+	{
+		BasicConsoleUnitTest: BasicConsoleUnitTest{
+			TestName:    "Unknown Platform Metric",
+			SessionData: operatorTokenData,
+			Location:    "/platform/metrics/app_logs",
+		},
+		ExpectedCode:     http.StatusNotFound,
+		ExpectedResponse: NewJSONResponseContentTester(`{"status": "Not found", "error_description": "Unknown platform metric."}`),
+	},
+}
+
+func TestPlatformMetrics(t *testing.T) {
+	logCache := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			t.Errorf("Unexpected log cache path %s", r.URL.Path)
+		}
+		if query := r.URL.Query().Get("query"); !strings.HasPrefix(query, "CapacityTotalMemory") {
+			t.Errorf("Unexpected log cache query %s", query)
+		}
+		w.Write([]byte(`{"status": "success"}`))
+	}))
+	defer logCache.Close()
+
+	for _, test := range platformMetricsTests {
+		envVars := GetMockCompleteEnvVars()
+		envVars[helpers.LogCacheURLEnvVar] = logCache.URL
+		response, request := NewTestRequest("GET", test.Location, nil)
+		router, _ := CreateRouterWithMockSession(test.SessionData, envVars)
+		router.ServeHTTP(response, request)
+		if response.Code != test.ExpectedCode {
+			t.Errorf("Test %s did not meet expected code.\nExpected %d.\nFound %d.\n", test.TestName, test.ExpectedCode, response.Code)
+		}
+		if !test.ExpectedResponse.Check(t, response.Body.String()) {
+			t.Errorf("Test %s did not contain expected value.\nExpected %s.\n Found (%s)\n.", test.TestName, test.ExpectedResponse.Display(), response.Body.String())
+		}
+	}
+}
+
+var capacityMetricValues = map[string]string{
+	"CapacityTotalMemory":     "16384",
+	"CapacityRemainingMemory": "4096",
+	"CapacityTotalDisk":       "65536",
+	"CapacityRemainingDisk":   "32768",
+	"ContainerCount":          "3",
+}
+
+func TestCapacityReport(t *testing.T) {
+	logCache := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		metric := query[:strings.Index(query, "{")]
+		value := capacityMetricValues[metric]
+		w.Write([]byte(`{"status": "success", "data": {"resultType": "vector", "result": [
+			{"metric": {"source_id": "rep", "index": "cell-a"}, "value": [1530000000, "` + value + `"]},
+			{"metric": {"source_id": "rep", "index": "cell-b"}, "value": [1530000000, "` + value + `"]}
+		]}}`))
+	}))
+	defer logCache.Close()
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`{"next_url": null, "resources": [{"entity": {"instances": 1}}]}`))
+			return
+		}
+		if r.URL.Query().Get("q") != "state:STARTED" {
+			t.Errorf("Expected to only list started apps. Found %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"next_url": "/v2/apps?page=2", "resources": [{"entity": {"instances": 2}}, {"entity": {"instances": 4}}]}`))
+	}))
+	defer cc.Close()
+
+	adminTokenData := NewTokenData(map[string]interface{}{
+		"scope": []string{helpers.FirehoseScope, "cloud_controller.admin"},
+	})
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.LogCacheURLEnvVar] = logCache.URL
+	envVars[helpers.APIURLEnvVar] = cc.URL
+
+	response, request := NewTestRequest("GET", "/platform/capacity", nil)
+	router, _ := CreateRouterWithMockSession(adminTokenData, envVars)
+	router.ServeHTTP(response, request)
+	expected := NewJSONResponseContentTester(`{
+		"cells": [
+			{"cell": "cell-a", "total_memory_mb": 16384, "remaining_memory_mb": 4096, "total_disk_mb": 65536, "remaining_disk_mb": 32768, "instances": 3},
+			{"cell": "cell-b", "total_memory_mb": 16384, "remaining_memory_mb": 4096, "total_disk_mb": 65536, "remaining_disk_mb": 32768, "instances": 3}
+		],
+		"total_memory_mb": 32768,
+		"remaining_memory_mb": 8192,
+		"total_disk_mb": 131072,
+		"remaining_disk_mb": 65536,
+		"placed_instances": 6,
+		"expected_instances": 7
+	}`)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected code %d. Found %d", http.StatusOK, response.Code)
+	}
+	if !expected.Check(t, response.Body.String()) {
+		t.Errorf("Expected %s. Found %s", expected.Display(), response.Body.String())
+	}
+
+	// Operators without the admin scope can't see all apps.
+	response, request = NewTestRequest("GET", "/platform/capacity", nil)
+	router, _ = CreateRouterWithMockSession(operatorTokenData, envVars)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected code %d. Found %d", http.StatusForbidden, response.Code)
+	}
+}
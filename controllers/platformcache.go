@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var cacheLog = logging.New("cache")
+
+const (
+	// platformCacheTTL is how long platform-level responses are fresh.
+	platformCacheTTL = 5 * time.Minute
+	// platformCacheStale is how long after that they're still served while
+	// they're refreshed.
+	platformCacheStale = 10 * time.Minute
+)
+
+// platformPaths are the CF API paths whose responses are the same for every
+// user, so they're cached once for everyone. The marketplace isn't one of
+// them: which services a user sees depends on the plan visibility of their
+// orgs.
+var platformPaths = map[string]bool{
+	"/v2/stacks":            true,
+	"/v2/buildpacks":        true,
+	"/v2/shared_domains":    true,
+	"/v2/quota_definitions": true,
+}
+
+// cachedResponse is a proxied response kept in the platform cache.
+type cachedResponse struct {
+	Code        int
+	ContentType string
+	Body        []byte
+}
+
+func (r *cachedResponse) writeTo(rw http.ResponseWriter) {
+	if r.ContentType != "" {
+		rw.Header().Set("Content-Type", r.ContentType)
+	}
+	rw.WriteHeader(r.Code)
+	rw.Write(r.Body)
+}
+
+// isPlatformRequest returns true if the proxied request can be answered from
+// the platform cache.
+func isPlatformRequest(req *http.Request) bool {
+	return req.Method == "GET" && platformPaths[req.URL.Path]
+}
+
+// loadPlatformResponse gets the CF API path, with the dashboard's own
+// credentials if privileged or else the user's, and keeps the response in
+// the platform cache if it succeeded.
+func (c *SecureContext) loadPlatformResponse(path string, privileged bool) (helpers.CacheItem, error) {
+	reqURL := c.Settings.ConsoleAPI + path
+	req, _ := http.NewRequest("GET", reqURL, nil)
+	w := httptest.NewRecorder()
+	handler := func(rw http.ResponseWriter, res *http.Response) {
+		rw.Header().Set("Content-Type", res.Header.Get("Content-Type"))
+		c.copyResponse(rw, res)
+	}
+	if privileged {
+		c.PrivilegedProxy(w, req, reqURL, handler)
+	} else {
+		c.Proxy(w, req, reqURL, handler)
+	}
+	response := &cachedResponse{Code: w.Code, ContentType: w.Header().Get("Content-Type"), Body: w.Body.Bytes()}
+	item := helpers.CacheItem{Value: response, Size: int64(len(response.Body))}
+	if w.Code == http.StatusOK {
+		item.TTL = platformCacheTTL
+		item.Stale = platformCacheStale
+	}
+	return item, nil
+}
+
+// platformProxy answers a proxied request for a platform-level resource from
+// the platform cache, getting it with the user's token when it isn't cached.
+func (c *SecureContext) platformProxy(rw http.ResponseWriter, req *http.Request) {
+	path := req.URL.RequestURI()
+	value, _ := c.Settings.PlatformCache.Get(path, func() (helpers.CacheItem, error) {
+		return c.loadPlatformResponse(path, false)
+	})
+	value.(*cachedResponse).writeTo(rw)
+}
+
+// WarmPlatformCache fills the platform cache with the responses the frontend
+// asks for, using the dashboard's own credentials, so the first users after a
+// deploy don't wait for them.
+func WarmPlatformCache(settings *helpers.Settings) {
+	c := &SecureContext{Context: &Context{Settings: settings}}
+	start := time.Now()
+	warmed := 0
+	for path := range platformPaths {
+		pages, err := c.warmPlatformPath(path)
+		if err != nil {
+			cacheLog.Warnf("unable to warm the platform cache for %s: %v", path, err)
+		}
+		warmed += pages
+	}
+	cacheLog.Infof("warmed the platform cache with %d pages in %v", warmed, time.Since(start).Round(time.Millisecond))
+}
+
+// warmPlatformPath caches all the pages of the path, under the URLs the
+// frontend uses for them, and returns how many were loaded.
+func (c *SecureContext) warmPlatformPath(path string) (int, error) {
+	totalPages := 1
+	for page := 1; page <= totalPages; page++ {
+		pagePath := path
+		if page > 1 {
+			pagePath += "?" + url.Values{"page": {strconv.Itoa(page)}}.Encode()
+		}
+		value, _ := c.Settings.PlatformCache.Get(pagePath, func() (helpers.CacheItem, error) {
+			return c.loadPlatformResponse(pagePath, true)
+		})
+		response := value.(*cachedResponse)
+		if response.Code != http.StatusOK {
+			return page - 1, fmt.Errorf("unexpected status %d", response.Code)
+		}
+		if page == 1 {
+			var first ccPage
+			if err := json.Unmarshal(response.Body, &first); err != nil {
+				return 0, err
+			}
+			if first.TotalPages > 1 {
+				totalPages = first.TotalPages
+			}
+		}
+	}
+	return totalPages, nil
+}
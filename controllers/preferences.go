@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+const (
+	// defaultLocale is the locale of users without a preference whose browser
+	// doesn't say.
+	defaultLocale = "en-US"
+	// defaultTimezone is the time zone of users without a preference.
+	defaultTimezone = "UTC"
+
+	// localeHeader and timezoneHeader tell the frontend how to format the
+	// dates and numbers of an aggregate response.
+	localeHeader   = "X-Format-Locale"
+	timezoneHeader = "X-Format-Timezone"
+)
+
+// MeContext stores the session info and access token per user.
+// All routes within MeContext are about the current user's own data.
+type MeContext struct {
+	*SecureContext // Required.
+}
+
+// Preferences returns the current user's preferences, with their version as
+// the ETag.
+func (c *MeContext) Preferences(rw web.ResponseWriter, req *web.Request) {
+	preferences, err := c.Settings.Preferences.Preferences(c.userID())
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	rw.Header().Set("ETag", versionETag(preferences.Version))
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(preferences)
+}
+
+// UpdatePreferences replaces the current user's preferences, unless they were
+// changed, say in another tab, since the version in If-Match or the body.
+func (c *MeContext) UpdatePreferences(rw web.ResponseWriter, req *web.Request) {
+	var preferences db.Preferences
+	if err := readBodyToStruct(req.Body, &preferences); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	if err := preferences.Validate(); err != nil {
+		newUaaError(http.StatusBadRequest, err.Error()).writeTo(rw)
+		return
+	}
+	version, uaaErr := expectedVersion(req, preferences.Version)
+	if uaaErr != nil {
+		uaaErr.writeTo(rw)
+		return
+	}
+	preferences.Version = version
+	preferences, err := c.Settings.Preferences.SavePreferences(c.userID(), preferences)
+	if err == db.ErrVersionConflict {
+		newVersionConflictError().writeTo(rw)
+		return
+	}
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	rw.Header().Set("ETag", versionETag(preferences.Version))
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(preferences)
+}
+
+// writeFormatHints sets the locale and time zone the user wants dates and
+// numbers in: their preferences, else their browser's language, else en-US
+// and UTC. The data itself stays in the API's formats.
+func (c *SecureContext) writeFormatHints(rw web.ResponseWriter, req *web.Request) {
+	// The defaults are good enough if the preferences can't be loaded.
+	preferences, _ := c.Settings.Preferences.Preferences(c.userID())
+	if preferences.Locale == "" {
+		preferences.Locale = acceptedLocale(req.Header.Get("Accept-Language"))
+	}
+	if preferences.Timezone == "" {
+		preferences.Timezone = defaultTimezone
+	}
+	rw.Header().Set(localeHeader, preferences.Locale)
+	rw.Header().Set(timezoneHeader, preferences.Timezone)
+	rw.Header().Add("Vary", "Accept-Language")
+}
+
+// acceptedLocale returns the browser's preferred locale from the
+// Accept-Language header, which lists them in order of preference.
+func acceptedLocale(acceptLanguage string) string {
+	for _, tag := range strings.Split(acceptLanguage, ",") {
+		tag = strings.TrimSpace(strings.SplitN(tag, ";", 2)[0])
+		if tag != "" && (db.Preferences{Locale: tag}).Validate() == nil {
+			return tag
+		}
+	}
+	return defaultLocale
+}
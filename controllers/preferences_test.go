@@ -0,0 +1,57 @@
+package controllers_test
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestPreferences(t *testing.T) {
+	router, _ := CreateRouterWithMockSession(userTokenData, GetMockCompleteEnvVars())
+
+	// Without preferences, the browser's language and UTC are used.
+	response, request := NewTestRequest("GET", "/api/changes", nil)
+	request.Header.Set("Accept-Language", "*;q=0.1, fr-CA;q=0.9, en;q=0.8")
+	router.ServeHTTP(response, request)
+	if locale, tz := response.Header().Get("X-Format-Locale"), response.Header().Get("X-Format-Timezone"); locale != "fr-CA" || tz != "UTC" {
+		t.Errorf("Expected fr-CA and UTC. Found %s and %s", locale, tz)
+	}
+
+	response, request = NewTestRequest("PUT", "/api/me/preferences", []byte(`{"timezone": "Mars/Olympus_Mons"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected code %d. Found %d", http.StatusBadRequest, response.Code)
+	}
+
+	response, request = NewTestRequest("PUT", "/api/me/preferences", []byte(`{"locale": "de-DE", "timezone": "Europe/Berlin"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected code %d. Found %d", http.StatusOK, response.Code)
+	}
+
+	response, request = NewTestRequest("GET", "/api/me/preferences", nil)
+	router.ServeHTTP(response, request)
+	expected := NewJSONResponseContentTester(`{"locale": "de-DE", "timezone": "Europe/Berlin", "version": 1}`)
+	if !expected.Check(t, response.Body.String()) {
+		t.Errorf("Unexpected preferences %s", response.Body.String())
+	}
+	if etag := response.Header().Get("ETag"); etag != `"1"` {
+		t.Errorf("Expected ETag %q. Found %q", `"1"`, etag)
+	}
+
+	// A save from a tab that loaded the preferences before that one conflicts.
+	response, request = NewTestRequest("PUT", "/api/me/preferences", []byte(`{"locale": "fr-FR"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected code %d. Found %d", http.StatusPreconditionFailed, response.Code)
+	}
+
+	// The preferences win over the browser's language.
+	response, request = NewTestRequest("GET", "/api/changes", nil)
+	request.Header.Set("Accept-Language", "fr-CA")
+	router.ServeHTTP(response, request)
+	if locale, tz := response.Header().Get("X-Format-Locale"), response.Header().Get("X-Format-Timezone"); locale != "de-DE" || tz != "Europe/Berlin" {
+		t.Errorf("Expected de-DE and Europe/Berlin. Found %s and %s", locale, tz)
+	}
+}
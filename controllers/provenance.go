@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/gocraft/web"
+)
+
+// provenanceBuildpack is a buildpack an app's current droplet was built with.
+type provenanceBuildpack struct {
+	// Name is the name of the buildpack, or its URL, e.g. nodejs_buildpack.
+	Name string `json:"name"`
+	// ReportedName is the name the buildpack reports, e.g. nodejs.
+	ReportedName string `json:"reported_name"`
+	Version      string `json:"version"`
+	// DetectedLanguage is what the buildpack detected, e.g. "nodejs 6.11.1".
+	DetectedLanguage string `json:"detected_language"`
+}
+
+// provenanceApp is how an app's current droplet was built. Apps that were
+// never staged have no droplet and no buildpacks.
+type provenanceApp struct {
+	GUID             string                `json:"guid"`
+	Name             string                `json:"name"`
+	SpaceGUID        string                `json:"space_guid"`
+	SpaceName        string                `json:"space_name"`
+	State            string                `json:"state"`
+	Stack            string                `json:"stack"`
+	DropletGUID      string                `json:"droplet_guid"`
+	DropletCreatedAt string                `json:"droplet_created_at"`
+	Buildpacks       []provenanceBuildpack `json:"buildpacks"`
+}
+
+// provenanceReport is the response of Provenance.
+type provenanceReport struct {
+	OrgGUID   string          `json:"org_guid"`
+	Buildpack string          `json:"buildpack,omitempty"`
+	Version   string          `json:"version,omitempty"`
+	Apps      []provenanceApp `json:"apps"`
+}
+
+// provenanceFields are the fields of Provenance that can be selected.
+var provenanceFields = fieldsOf(provenanceReport{})
+
+// matches returns true if the app was built with the buildpack, by its name
+// or the name it reports, and with the version when there is one.
+func (a provenanceApp) matches(buildpack, version string) bool {
+	for _, bp := range a.Buildpacks {
+		if (bp.Name == buildpack || bp.ReportedName == buildpack) && (version == "" || bp.Version == version) {
+			return true
+		}
+	}
+	return false
+}
+
+// Provenance reports how the current droplet of each app in the org was
+// built: its buildpacks with their versions and what they detected, its stack
+// and when it was created. It answers which apps were built with a vulnerable
+// buildpack, and can be narrowed to one with ?buildpack= and ?version=. It
+// needs no admin scope: the user sees the apps of the spaces they can see.
+func (c *OrgContext) Provenance(rw web.ResponseWriter, req *web.Request) {
+	orgGUID := req.PathParams["org_guid"]
+	buildpack := req.URL.Query().Get("buildpack")
+	version := req.URL.Query().Get("version")
+	if version != "" && buildpack == "" {
+		newUaaError(http.StatusBadRequest, "buildpack is required with version.").writeTo(rw)
+		return
+	}
+	if err := c.ccRequest("GET", "/v3/organizations/"+url.PathEscape(orgGUID), nil, nil); err != nil {
+		if isCCNotFound(err) {
+			newUaaError(http.StatusNotFound, "unknown org.").writeTo(rw)
+			return
+		}
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	spaces, err := c.ccGetAllV3("/v3/spaces?" + url.Values{"organization_guids": {orgGUID}, "per_page": {"5000"}}.Encode())
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	spaceNames := map[string]string{}
+	for _, resource := range spaces {
+		var space struct {
+			GUID string `json:"guid"`
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(resource, &space); err != nil {
+			newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+			return
+		}
+		spaceNames[space.GUID] = space.Name
+	}
+	resources, err := c.ccGetAllV3("/v3/apps?" + url.Values{"organization_guids": {orgGUID}, "per_page": {"5000"}}.Encode())
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+
+	ccApps := make([]ccV3App, len(resources))
+	droplets := make([]*ccV3Droplet, len(resources))
+	tasks := make([]func() error, len(resources))
+	for i, resource := range resources {
+		if err := json.Unmarshal(resource, &ccApps[i]); err != nil {
+			newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+			return
+		}
+		i := i
+		tasks[i] = func() error {
+			var droplet ccV3Droplet
+			err := c.ccRequest("GET", "/v3/apps/"+ccApps[i].GUID+"/droplets/current", nil, &droplet)
+			if isCCNotFound(err) {
+				// The app was never staged.
+				return nil
+			}
+			droplets[i] = &droplet
+			return err
+		}
+	}
+	if err := c.fanOut(tasks); err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+
+	apps := []provenanceApp{}
+	for i, ccApp := range ccApps {
+		spaceGUID := ccApp.Relationships.Space.Data.GUID
+		app := provenanceApp{
+			GUID:       ccApp.GUID,
+			Name:       ccApp.Name,
+			SpaceGUID:  spaceGUID,
+			SpaceName:  spaceNames[spaceGUID],
+			State:      ccApp.State,
+			Buildpacks: []provenanceBuildpack{},
+		}
+		if droplet := droplets[i]; droplet != nil {
+			app.Stack = droplet.Stack
+			app.DropletGUID = droplet.GUID
+			app.DropletCreatedAt = droplet.CreatedAt
+			for _, bp := range droplet.Buildpacks {
+				app.Buildpacks = append(app.Buildpacks, provenanceBuildpack{
+					Name:             bp.Name,
+					ReportedName:     bp.BuildpackName,
+					Version:          bp.Version,
+					DetectedLanguage: bp.DetectOutput,
+				})
+			}
+		}
+		if buildpack != "" && !app.matches(buildpack, version) {
+			continue
+		}
+		apps = append(apps, app)
+	}
+	sort.SliceStable(apps, func(i, j int) bool {
+		if apps[i].SpaceName != apps[j].SpaceName {
+			return apps[i].SpaceName < apps[j].SpaceName
+		}
+		return apps[i].Name < apps[j].Name
+	})
+
+	c.writeAggregate(rw, req, provenanceFields, provenanceReport{
+		OrgGUID:   orgGUID,
+		Buildpack: buildpack,
+		Version:   version,
+		Apps:      apps,
+	})
+}
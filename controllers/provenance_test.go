@@ -0,0 +1,124 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestProvenance(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/organizations/org-1":
+			w.Write([]byte(`{"guid": "org-1"}`))
+		case "/v3/spaces":
+			if r.URL.Query().Get("organization_guids") != "org-1" {
+				t.Errorf("Unexpected request %s", r.URL)
+			}
+			w.Write([]byte(`{"pagination": {"next": null}, "resources": [
+				{"guid": "space-1", "name": "prod"},
+				{"guid": "space-2", "name": "dev"}
+			]}`))
+		case "/v3/apps":
+			if r.URL.Query().Get("organization_guids") != "org-1" {
+				t.Errorf("Unexpected request %s", r.URL)
+			}
+			w.Write([]byte(`{"pagination": {"next": null}, "resources": [
+				{"guid": "app-1", "name": "web", "state": "STARTED", "relationships": {"space": {"data": {"guid": "space-1"}}}},
+				{"guid": "app-2", "name": "api", "state": "STARTED", "relationships": {"space": {"data": {"guid": "space-2"}}}},
+				{"guid": "app-3", "name": "never-staged", "state": "STOPPED", "relationships": {"space": {"data": {"guid": "space-2"}}}}
+			]}`))
+		case "/v3/apps/app-1/droplets/current":
+			w.Write([]byte(`{"guid": "droplet-1", "stack": "cflinuxfs2", "created_at": "2018-03-01T00:00:00Z", "buildpacks": [
+				{"name": "nodejs_buildpack", "buildpack_name": "nodejs", "version": "1.6.20", "detect_output": "nodejs 8.9.4"}
+			]}`))
+		case "/v3/apps/app-2/droplets/current":
+			w.Write([]byte(`{"guid": "droplet-2", "stack": "cflinuxfs2", "created_at": "2017-11-01T00:00:00Z", "buildpacks": [
+				{"name": "ruby_buildpack", "buildpack_name": "ruby", "version": "1.7.1", "detect_output": "ruby 2.4.2"}
+			]}`))
+		case "/v3/apps/app-3/droplets/current":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+
+	tests := []BasicSecureTest{
+		{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "Provenance Of All Apps",
+				SessionData: userTokenData,
+				Location:    "/api/orgs/org-1/provenance",
+			},
+			ExpectedCode: http.StatusOK,
+			ExpectedResponse: NewJSONResponseContentTester(`{"org_guid": "org-1", "apps": [
+				{"guid": "app-2", "name": "api", "space_guid": "space-2", "space_name": "dev", "state": "STARTED", "stack": "cflinuxfs2",
+					"droplet_guid": "droplet-2", "droplet_created_at": "2017-11-01T00:00:00Z",
+					"buildpacks": [{"name": "ruby_buildpack", "reported_name": "ruby", "version": "1.7.1", "detected_language": "ruby 2.4.2"}]},
+				{"guid": "app-3", "name": "never-staged", "space_guid": "space-2", "space_name": "dev", "state": "STOPPED", "stack": "",
+					"droplet_guid": "", "droplet_created_at": "", "buildpacks": []},
+				{"guid": "app-1", "name": "web", "space_guid": "space-1", "space_name": "prod", "state": "STARTED", "stack": "cflinuxfs2",
+					"droplet_guid": "droplet-1", "droplet_created_at": "2018-03-01T00:00:00Z",
+					"buildpacks": [{"name": "nodejs_buildpack", "reported_name": "nodejs", "version": "1.6.20", "detected_language": "nodejs 8.9.4"}]}
+			]}`),
+		},
+		{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "Provenance Of A Buildpack Version",
+				SessionData: userTokenData,
+				Location:    "/api/orgs/org-1/provenance?buildpack=nodejs&version=1.6.20&fields=apps.guid",
+			},
+			ExpectedCode:     http.StatusOK,
+			ExpectedResponse: NewJSONResponseContentTester(`{"apps": [{"guid": "app-1"}]}`),
+		},
+		{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "Provenance Without Buildpack",
+				SessionData: userTokenData,
+				Location:    "/api/orgs/org-1/provenance?version=1.6.20",
+			},
+			ExpectedCode:     http.StatusBadRequest,
+			ExpectedResponse: NewJSONResponseContentTester(`{"status": "failure", "data": "buildpack is required with version."}`),
+		},
+		{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "Provenance Of Unknown Org",
+				SessionData: userTokenData,
+				Location:    "/api/orgs/org-2/provenance",
+			},
+			ExpectedCode:     http.StatusNotFound,
+			ExpectedResponse: NewJSONResponseContentTester(`{"status": "failure", "data": "unknown org."}`),
+		},
+	}
+	for _, test := range tests {
+		response, request := NewTestRequest("GET", test.Location, nil)
+		router, _ := CreateRouterWithMockSession(test.SessionData, envVars)
+		router.ServeHTTP(response, request)
+		if response.Code != test.ExpectedCode {
+			t.Errorf("Test %s did not meet expected code.\nExpected %d.\nFound %d.\n", test.TestName, test.ExpectedCode, response.Code)
+		}
+		if !test.ExpectedResponse.Check(t, response.Body.String()) {
+			t.Errorf("Test %s did not contain expected value.\nExpected %s.\n Found (%s)\n.", test.TestName, test.ExpectedResponse.Display(), response.Body.String())
+		}
+	}
+
+	response, request := NewTestRequest("GET", "/api/orgs/org-1/provenance?format=csv", nil)
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+	router.ServeHTTP(response, request)
+	expected := "guid,name,space_guid,space_name,state,stack,droplet_created_at,buildpack,buildpack_version,detected_language\n" +
+		"app-2,api,space-2,dev,STARTED,cflinuxfs2,2017-11-01T00:00:00Z,ruby_buildpack,1.7.1,ruby 2.4.2\n" +
+		"app-3,never-staged,space-2,dev,STOPPED,,,,,\n" +
+		"app-1,web,space-1,prod,STARTED,cflinuxfs2,2018-03-01T00:00:00Z,nodejs_buildpack,1.6.20,nodejs 8.9.4\n"
+	if body := strings.Replace(response.Body.String(), "\r\n", "\n", -1); body != expected {
+		t.Errorf("Unexpected CSV %q", body)
+	}
+}
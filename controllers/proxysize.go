@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errResponseTooLarge is returned when a proxied response is over the
+// configured limit.
+var errResponseTooLarge = errors.New("response is too large")
+
+var (
+	proxyResponseBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "dashboard_proxy_response_bytes",
+		Help:    "Size of the responses proxied from the CF API and UAA.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+	})
+	proxyResponsesTooLarge = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_proxy_responses_too_large_total",
+		Help: "Proxied responses over the size limit, by whether they were rejected up front or cut off while streaming.",
+	}, []string{"result"})
+	ccBufferedBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dashboard_cc_buffered_bytes",
+		Help: "Bytes of CF API responses currently held in memory by aggregate endpoints.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(proxyResponseBytes, proxyResponsesTooLarge, ccBufferedBytes)
+}
+
+// cappedWriter fails writes past the limit instead of buffering or sending
+// them.
+type cappedWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (w *cappedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.remaining {
+		n, err := w.w.Write(p[:w.remaining])
+		w.remaining -= int64(n)
+		if err == nil {
+			err = errResponseTooLarge
+		}
+		return n, err
+	}
+	n, err := w.w.Write(p)
+	w.remaining -= int64(n)
+	return n, err
+}
+
+// copyResponse streams the proxied response to rw, up to the configured
+// limit. Responses known to be too large from their Content-Length are
+// rejected with a 502. Others are cut off once they reach the limit, since
+// their status has already been sent.
+func (c *SecureContext) copyResponse(rw http.ResponseWriter, response *http.Response) error {
+	limit := c.Settings.MaxProxyResponseBytes
+	if limit > 0 && response.ContentLength > limit {
+		proxyResponsesTooLarge.WithLabelValues("rejected").Inc()
+		c.logger(proxyLog).Warnf("proxied response of %d bytes from %s is over the %d bytes limit", response.ContentLength, response.Request.URL.Path, limit)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(rw).Encode(struct {
+			Status      string `json:"status"`
+			Description string `json:"error_description"`
+		}{
+			Status:      "response_too_large",
+			Description: fmt.Sprintf("The response is larger than the %d bytes the dashboard can handle.", limit),
+		})
+		return errResponseTooLarge
+	}
+
+	if response.StatusCode >= 400 && response.ContentLength <= maxCCErrorBytes {
+		// Errors are small, so they can be read whole and translated.
+		body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxCCErrorBytes+1))
+		if err != nil {
+			return err
+		}
+		if ccErr, ok := normalizeCCError(response.StatusCode, body); ok && len(body) <= maxCCErrorBytes {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(response.StatusCode)
+			return json.NewEncoder(rw).Encode(ccErr)
+		}
+		response.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), response.Body))
+	}
+
+	// Should return the same status.
+	rw.WriteHeader(response.StatusCode)
+	var w io.Writer = rw
+	if limit > 0 {
+		w = &cappedWriter{w: rw, remaining: limit}
+	}
+	n, err := io.Copy(w, response.Body)
+	proxyResponseBytes.Observe(float64(n))
+	if err == errResponseTooLarge {
+		proxyResponsesTooLarge.WithLabelValues("truncated").Inc()
+		c.logger(proxyLog).Warnf("proxied response from %s was cut off at the %d bytes limit", response.Request.URL.Path, limit)
+	}
+	return err
+}
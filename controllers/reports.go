@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var reportLog = logging.New("reports")
+
+// The formats reports can be written in.
+const (
+	reportJSON = "json"
+	reportCSV  = "csv"
+	reportXLSX = "xlsx"
+)
+
+// reportMediaTypes are the media types of the report formats in Accept.
+var reportMediaTypes = map[string]string{
+	"application/json":      reportJSON,
+	"text/csv":              reportCSV,
+	helpers.XLSXContentType: reportXLSX,
+}
+
+// reportFlushRows is how many rows are written between flushes, so clients
+// get large reports as they're written.
+const reportFlushRows = 500
+
+// reportTable is a report that can also be written as a table, e.g. for
+// spreadsheets.
+type reportTable interface {
+	// reportName names the downloaded file.
+	reportName() string
+	columns() []string
+	// eachRow calls fn with each row, in the order of the columns, and stops
+	// at the first error.
+	eachRow(fn func(row []string) error) error
+}
+
+// reportFormat returns the format the report was asked in: ?format= if set,
+// else the first format of Accept the reports are written in, else JSON.
+func reportFormat(req *web.Request) (string, *UaaError) {
+	if format := req.URL.Query().Get("format"); format != "" {
+		switch format = strings.ToLower(format); format {
+		case reportJSON, reportCSV, reportXLSX:
+			return format, nil
+		}
+		return "", newUaaError(http.StatusBadRequest, "unknown format "+format+". Allowed formats: json, csv, xlsx.")
+	}
+	for _, accepted := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if format, ok := reportMediaTypes[mediaType]; ok {
+			return format, nil
+		}
+	}
+	return reportJSON, nil
+}
+
+// writeTable streams the report as a CSV or XLSX download.
+func (c *SecureContext) writeTable(rw web.ResponseWriter, format string, table reportTable) {
+	filename := table.reportName() + "-" + time.Now().UTC().Format("20060102") + "." + format
+	rw.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	rw.Header().Set("Cache-Control", "private, no-store")
+	var tw helpers.TableWriter
+	if format == reportCSV {
+		rw.Header().Set("Content-Type", helpers.CSVContentType)
+		tw = helpers.NewCSVTableWriter(rw)
+	} else {
+		rw.Header().Set("Content-Type", helpers.XLSXContentType)
+		var err error
+		if tw, err = helpers.NewXLSXTableWriter(rw); err != nil {
+			newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+			return
+		}
+	}
+	rows := 0
+	err := tw.WriteRow(table.columns())
+	if err == nil {
+		err = table.eachRow(func(row []string) error {
+			if rows++; rows%reportFlushRows == 0 {
+				rw.Flush()
+			}
+			return tw.WriteRow(row)
+		})
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		// The status was sent with the first row, so the client can only
+		// tell from the truncated download.
+		c.logger(reportLog).Warnf("could not write %s report %s: %v", format, table.reportName(), err)
+	}
+}
+
+func (r activityTimeline) reportName() string { return "activity" }
+
+func (r activityTimeline) columns() []string {
+	return []string{"time", "actor", "action", "remote_addr", "details"}
+}
+
+func (r activityTimeline) eachRow(fn func([]string) error) error {
+	for _, e := range r.Events {
+		if err := fn([]string{e.Time.UTC().Format(time.RFC3339), e.Actor, e.Action, e.RemoteAddr, string(e.Details)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r buildpackImpactReport) reportName() string { return "buildpack-impact" }
+
+func (r buildpackImpactReport) columns() []string {
+	return []string{"guid", "name", "space_guid", "state", "buildpack", "buildpack_version", "last_staged"}
+}
+
+func (r buildpackImpactReport) eachRow(fn func([]string) error) error {
+	for _, app := range r.Apps {
+		if err := fn([]string{app.GUID, app.Name, app.SpaceGUID, app.State, r.Buildpack, app.BuildpackVersion, app.LastStaged}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r stackMigrationReport) reportName() string { return "stack-migration" }
+
+func (r stackMigrationReport) columns() []string {
+	return []string{"guid", "name", "space_guid", "state", "instances", "memory_mb", "disk_quota_mb", "buildpack", "restage_impact"}
+}
+
+func (r stackMigrationReport) eachRow(fn func([]string) error) error {
+	for _, app := range r.Apps {
+		row := []string{
+			app.GUID, app.Name, app.SpaceGUID, app.State,
+			strconv.Itoa(app.Instances), strconv.Itoa(app.MemoryMB), strconv.Itoa(app.DiskMB),
+			app.Buildpack, app.RestageImpact,
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r sharedDomains) reportName() string { return "shared-domains" }
+
+func (r sharedDomains) columns() []string {
+	return []string{"guid", "name", "internal", "router_group_guid"}
+}
+
+func (r sharedDomains) eachRow(fn func([]string) error) error {
+	for _, d := range r {
+		if err := fn([]string{d.GUID, d.Name, strconv.FormatBool(d.Internal), d.RouterGroupGUID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r provenanceReport) reportName() string { return "provenance" }
+
+func (r provenanceReport) columns() []string {
+	return []string{"guid", "name", "space_guid", "space_name", "state", "stack", "droplet_created_at", "buildpack", "buildpack_version", "detected_language"}
+}
+
+// eachRow writes a row per buildpack of each app, and one without a
+// buildpack for apps that were never staged.
+func (r provenanceReport) eachRow(fn func([]string) error) error {
+	for _, app := range r.Apps {
+		row := []string{app.GUID, app.Name, app.SpaceGUID, app.SpaceName, app.State, app.Stack, app.DropletCreatedAt}
+		if len(app.Buildpacks) == 0 {
+			if err := fn(append(row, "", "", "")); err != nil {
+				return err
+			}
+		}
+		for _, bp := range app.Buildpacks {
+			if err := fn(append(row[:len(row):len(row)], bp.Name, bp.Version, bp.DetectedLanguage)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package controllers_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestReportFormats(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/shared_domains":
+			w.Write([]byte(`{"next_url": null, "resources": [
+				{"metadata": {"guid": "domain-1"}, "entity": {"name": "apps.example.com", "internal": false}},
+				{"metadata": {"guid": "domain-2"}, "entity": {"name": "apps.internal", "internal": true}}
+			]}`))
+		case "/v2/domains":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "domain-1"}, "entity": {"name": "apps.example.com"}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(adminTokenData, envVars)
+
+	tests := []struct {
+		name                string
+		location, accept    string
+		expectedCode        int
+		expectedContentType string
+	}{
+		{"json by default", "/admin/shared_domains", "", http.StatusOK, "application/json"},
+		{"csv by format", "/admin/shared_domains?format=csv", "application/json", http.StatusOK, helpers.CSVContentType},
+		{"csv by accept", "/admin/shared_domains", "text/html, text/csv;q=0.9", http.StatusOK, helpers.CSVContentType},
+		{"xlsx by accept", "/admin/shared_domains", helpers.XLSXContentType, http.StatusOK, helpers.XLSXContentType},
+		{"unknown format", "/admin/shared_domains?format=pdf", "", http.StatusBadRequest, ""},
+		{"not a table", "/routes/ownership?host=free&domain=apps.example.com&format=csv", "", http.StatusNotAcceptable, ""},
+	}
+	for _, test := range tests {
+		response, request := NewTestRequest("GET", test.location, nil)
+		if test.accept != "" {
+			request.Header.Set("Accept", test.accept)
+		}
+		router.ServeHTTP(response, request)
+		if response.Code != test.expectedCode {
+			t.Errorf("Test %s: Expected code %d. Found %d: %s", test.name, test.expectedCode, response.Code, response.Body.String())
+			continue
+		}
+		if contentType := response.Header().Get("Content-Type"); test.expectedContentType != "" && contentType != test.expectedContentType {
+			t.Errorf("Test %s: Expected content type %s. Found %s", test.name, test.expectedContentType, contentType)
+		}
+	}
+
+	response, request := NewTestRequest("GET", "/admin/shared_domains?format=csv", nil)
+	router.ServeHTTP(response, request)
+	expected := "guid,name,internal,router_group_guid\ndomain-1,apps.example.com,false,\ndomain-2,apps.internal,true,\n"
+	if response.Body.String() != expected {
+		t.Errorf("Expected %q. Found %q", expected, response.Body.String())
+	}
+	if disposition := response.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment; filename=shared-domains-") {
+		t.Errorf("Unexpected Content-Disposition %s", disposition)
+	}
+
+	response, request = NewTestRequest("GET", "/admin/shared_domains?format=xlsx", nil)
+	router.ServeHTTP(response, request)
+	if _, err := zip.NewReader(bytes.NewReader(response.Body.Bytes()), int64(response.Body.Len())); err != nil {
+		t.Errorf("Expected a workbook: %v", err)
+	}
+}
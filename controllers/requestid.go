@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var accessLog = logging.New("access")
+
+const (
+	// requestIDHeader is the response header with the dashboard's ID of the
+	// request.
+	requestIDHeader = "X-Request-Id"
+	// cfRequestIDHeader is the response header with the CF API's ID of the
+	// last request made to it, from its X-Vcap-Request-Id header.
+	cfRequestIDHeader = "X-Cf-Request-Id"
+	// vcapRequestIDHeader is the header the Cloud Foundry router sets on the
+	// requests it forwards, and the CF API sets on its responses.
+	vcapRequestIDHeader = "X-Vcap-Request-Id"
+)
+
+// requestIDPattern matches the request IDs we accept from the router.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9:._-]{1,128}$`)
+
+// RequestIDMiddleware gives the request an ID, sent back in X-Request-Id,
+// logged with every line of the request and forwarded to the CF API, UAA and
+// the log API, so support can trace a user's failed action through all their
+// logs. The router's ID is used when there is one, so it also matches the
+// router's access logs, and otherwise the client's X-Request-Id.
+func (c *Context) RequestIDMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	c.requestID = req.Header.Get(vcapRequestIDHeader)
+	if !requestIDPattern.MatchString(c.requestID) {
+		c.requestID = req.Header.Get(requestIDHeader)
+	}
+	if !requestIDPattern.MatchString(c.requestID) {
+		b := make([]byte, 16)
+		rand.Read(b)
+		c.requestID = hex.EncodeToString(b)
+	}
+	rw.Header().Set(requestIDHeader, c.requestID)
+	c.annotations = helpers.Annotations(req.Request)
+	if c.annotations == nil {
+		// Served without a TimeoutHandler, the request still needs its ID
+		// in its context, e.g. for the admin audit log.
+		req.Request, c.annotations = helpers.WithAnnotations(req.Request)
+	}
+	c.annotations.SetRequestID(c.requestID)
+	c.logFields = []interface{}{"request_id", c.requestID}
+	next(rw, req)
+}
+
+// forwardRequestID sets the request's ID on an upstream request, which the CF
+// API and UAA log with their own IDs.
+func (c *Context) forwardRequestID(header http.Header) {
+	if c.requestID != "" {
+		header.Set(requestIDHeader, c.requestID)
+	}
+}
+
+// logger returns the module's logger for the request, which logs its ID and,
+// once logged in, its user with every line.
+func (c *Context) logger(lg *logging.Logger) *logging.Logger {
+	return lg.With(c.logFields...)
+}
+
+// AccessLogMiddleware logs every request, with its status and duration.
+func (c *Context) AccessLogMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	start := time.Now()
+	next(rw, req)
+	c.logger(accessLog).With("status", rw.StatusCode(), "duration_ms", time.Since(start).Seconds()*1000).
+		Infof("%s %s", req.Method, req.URL.Path)
+}
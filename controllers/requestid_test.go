@@ -0,0 +1,112 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/logging"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestRequestIDs(t *testing.T) {
+	var forwarded string
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get("X-Request-Id")
+		w.Header().Set("X-Vcap-Request-Id", "cc-request-id")
+		if strings.HasSuffix(r.URL.Path, "/managed_organizations") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	response, request := NewTestRequest("GET", "/v2/apps", nil)
+	router.ServeHTTP(response, request)
+	if len(response.Header().Get("X-Request-Id")) != 32 {
+		t.Errorf("Expected a request ID. Found %q", response.Header().Get("X-Request-Id"))
+	}
+	if id := response.Header().Get("X-Cf-Request-Id"); id != "cc-request-id" {
+		t.Errorf("Expected the CF API's request ID. Found %q", id)
+	}
+	if forwarded != response.Header().Get("X-Request-Id") {
+		t.Errorf("Expected the request ID to be forwarded to the CF API. Found %q", forwarded)
+	}
+
+	// The client's request ID is kept without one from the router.
+	response, request = NewTestRequest("GET", "/ping", nil)
+	request.Header.Set("X-Request-Id", "client-id")
+	router.ServeHTTP(response, request)
+	if id := response.Header().Get("X-Request-Id"); id != "client-id" {
+		t.Errorf("Expected the client's request ID. Found %q", id)
+	}
+
+	// The router's request ID is kept.
+	response, request = NewTestRequest("GET", "/ping", nil)
+	request.Header.Set("X-Vcap-Request-Id", "e1f1b6a0-router-id")
+	router.ServeHTTP(response, request)
+	if id := response.Header().Get("X-Request-Id"); id != "e1f1b6a0-router-id" {
+		t.Errorf("Expected the router's request ID. Found %q", id)
+	}
+
+	// Server errors include both IDs.
+	response, request = NewTestRequest("GET", "/api/role_requests?org_guid=org-1", nil)
+	router.ServeHTTP(response, request)
+	var body struct {
+		Data      string `json:"data"`
+		RequestID string `json:"request_id"`
+	}
+	json.NewDecoder(response.Body).Decode(&body)
+	if response.Code != http.StatusBadGateway || body.RequestID != response.Header().Get("X-Request-Id") ||
+		!strings.Contains(body.Data, "CF request ID cc-request-id") {
+		t.Errorf("Expected the request IDs in the error. Found %d: %+v", response.Code, body)
+	}
+}
+
+func TestRequestScopedLogging(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Vcap-Request-Id", "cc-request-id")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.LogFormatEnvVar] = "json"
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+	defer logging.SetFormat(logging.TextFormat)
+	var out bytes.Buffer
+	logging.SetOutput(&out)
+	defer logging.SetOutput(os.Stderr)
+
+	response, request := NewTestRequest("GET", "/v2/apps", nil)
+	request.Header.Set("X-Vcap-Request-Id", "router-id")
+	router.ServeHTTP(response, request)
+
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected JSON log lines. Found %q", line)
+		}
+		if entry["module"] != "proxy" {
+			continue
+		}
+		found = true
+		if entry["request_id"] != "router-id" || entry["user_id"] != "user-guid" ||
+			entry["cf_request_id"] != "cc-request-id" || entry["level"] != "warn" || entry["time"] == nil {
+			t.Errorf("Expected the request's fields. Found %v", entry)
+		}
+	}
+	if !found {
+		t.Errorf("Expected the failed request to be logged. Found %q", out.String())
+	}
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+// maxRestartConcurrency is the most apps restarted at the same time.
+const maxRestartConcurrency = 20
+
+// restartRequest is the body to schedule rolling restarts, e.g. after a
+// stemcell or rootfs is patched.
+type restartRequest struct {
+	// Stacks and CellHosts select the apps to restart: the started apps on
+	// any of the stacks or with an instance on any of the cells (by IP).
+	Stacks    []string `json:"stacks"`
+	CellHosts []string `json:"cell_hosts"`
+	// Concurrency is how many apps are restarted at the same time.
+	Concurrency int `json:"concurrency"`
+	// Window is a daily maintenance window in UTC, e.g. 02:00-05:00.
+	// Restarts only start during the window.
+	Window         string   `json:"window"`
+	SkipAppGUIDs   []string `json:"skip_app_guids"`
+	SkipOrgGUIDs   []string `json:"skip_org_guids"`
+	SkipSpaceGUIDs []string `json:"skip_space_guids"`
+}
+
+// ccV3ProcessStats is a partial representation of v3 CF API process stats.
+type ccV3ProcessStats struct {
+	Resources []struct {
+		State string `json:"state"`
+		Host  string `json:"host"`
+	} `json:"resources"`
+}
+
+// stringSet makes a set of the strings.
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// skippedSpaces returns the GUIDs of the spaces to skip, including the
+// spaces of the orgs to skip.
+func (c *AdminContext) skippedSpaces(restart *restartRequest) (map[string]bool, error) {
+	skipped := stringSet(restart.SkipSpaceGUIDs)
+	if len(restart.SkipOrgGUIDs) == 0 {
+		return skipped, nil
+	}
+	spaces, err := c.ccGetAllV3("/v3/spaces?" + url.Values{
+		"organization_guids": {strings.Join(restart.SkipOrgGUIDs, ",")},
+		"per_page":           {"5000"},
+	}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	for _, resource := range spaces {
+		var space struct {
+			GUID string `json:"guid"`
+		}
+		if err := json.Unmarshal(resource, &space); err != nil {
+			return nil, err
+		}
+		skipped[space.GUID] = true
+	}
+	return skipped, nil
+}
+
+// onCells returns true if an instance of the app runs on one of the cells.
+func (c *AdminContext) onCells(appGUID string, cells map[string]bool) (bool, error) {
+	var stats ccV3ProcessStats
+	err := c.ccRequest("GET", "/v3/apps/"+appGUID+"/processes/web/stats", nil, &stats)
+	if isCCNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, instance := range stats.Resources {
+		if cells[instance.Host] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ScheduleRestarts restarts the selected apps with rolling deployments, so
+// they keep serving traffic. Restarts are throttled, only start during the
+// maintenance window (if any) and are followed through the returned job.
+func (c *AdminContext) ScheduleRestarts(rw web.ResponseWriter, req *web.Request) {
+	var restart restartRequest
+	if err := readBodyToStruct(req.Body, &restart); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	if len(restart.Stacks) == 0 && len(restart.CellHosts) == 0 {
+		newUaaError(http.StatusBadRequest, "stacks or cell_hosts are required.").writeTo(rw)
+		return
+	}
+	if restart.Concurrency < 0 || restart.Concurrency > maxRestartConcurrency {
+		newUaaError(http.StatusBadRequest, fmt.Sprintf("concurrency can be at most %d.", maxRestartConcurrency)).writeTo(rw)
+		return
+	}
+	opts := jobs.Options{Concurrency: restart.Concurrency}
+	if restart.Window != "" {
+		window, err := jobs.ParseWindow(restart.Window)
+		if err != nil {
+			newUaaError(http.StatusBadRequest, err.Error()).writeTo(rw)
+			return
+		}
+		opts.Window = window
+	}
+
+	skippedSpaces, err := c.skippedSpaces(&restart)
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	skippedApps := stringSet(restart.SkipAppGUIDs)
+	stacks := stringSet(restart.Stacks)
+	cells := stringSet(restart.CellHosts)
+
+	resources, err := c.ccGetAllV3("/v3/apps?" + url.Values{"states": {"STARTED"}, "per_page": {"5000"}}.Encode())
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	var candidates []string
+	var selected []bool
+	var lookups []func() error
+	for _, resource := range resources {
+		var app struct {
+			ccV3App
+			Lifecycle struct {
+				Data struct {
+					Stack string `json:"stack"`
+				} `json:"data"`
+			} `json:"lifecycle"`
+		}
+		if err := json.Unmarshal(resource, &app); err != nil {
+			newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+			return
+		}
+		if app.State != "STARTED" || skippedApps[app.GUID] || skippedSpaces[app.Relationships.Space.Data.GUID] {
+			continue
+		}
+		candidates = append(candidates, app.GUID)
+		selected = append(selected, stacks[app.Lifecycle.Data.Stack])
+		if i := len(selected) - 1; !selected[i] && len(cells) > 0 {
+			appGUID := app.GUID
+			lookups = append(lookups, func() (err error) {
+				selected[i], err = c.onCells(appGUID, cells)
+				return err
+			})
+		}
+	}
+	if err := c.fanOut(lookups); err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+
+	// Each restart is recorded in the admin audit log as the admin's.
+	actor := c.userID()
+	var tasks []jobs.Task
+	for i, appGUID := range candidates {
+		if !selected[i] {
+			continue
+		}
+		appGUID := appGUID
+		tasks = append(tasks, jobs.Task{
+			Name: appGUID,
+			// Restarts can start hours later, in the maintenance window,
+			// after the admin's token has expired, so they're made with the
+			// dashboard's own credentials.
+			Run: func() error {
+				err := c.privilegedCCRequest("POST", "/v3/deployments", map[string]interface{}{
+					"strategy": "rolling",
+					"relationships": map[string]interface{}{
+						"app": map[string]interface{}{"data": map[string]string{"guid": appGUID}},
+					},
+				}, nil)
+				if err == nil {
+					c.Settings.AdminAudit.Record(nil, actor, "restart_app", appGUID, nil)
+				}
+				return err
+			},
+		})
+	}
+
+	job, ok := c.submitJobWithOptions(rw, "rolling-restart", tasks, opts)
+	if !ok {
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "schedule_restarts", struct {
+		JobID     string   `json:"job_id"`
+		Stacks    []string `json:"stacks"`
+		CellHosts []string `json:"cell_hosts"`
+		Window    string   `json:"window,omitempty"`
+		Apps      int      `json:"apps"`
+	}{
+		JobID:     job.ID,
+		Stacks:    restart.Stacks,
+		CellHosts: restart.CellHosts,
+		Window:    restart.Window,
+		Apps:      len(tasks),
+	})
+}
@@ -0,0 +1,127 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestScheduleRestarts(t *testing.T) {
+	var mu sync.Mutex
+	var restarted []string
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/v3/spaces":
+			if r.URL.Query().Get("organization_guids") != "org-skip" {
+				t.Errorf("Unexpected spaces query %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"pagination": {"next": null}, "resources": [{"guid": "space-skip"}]}`))
+		case r.URL.Path == "/v3/apps":
+			w.Write([]byte(`{"pagination": {"next": null}, "resources": [
+				{"guid": "app-1", "state": "STARTED", "lifecycle": {"data": {"stack": "cflinuxfs3"}}, "relationships": {"space": {"data": {"guid": "space-1"}}}},
+				{"guid": "app-2", "state": "STARTED", "lifecycle": {"data": {"stack": "cflinuxfs3"}}, "relationships": {"space": {"data": {"guid": "space-skip"}}}},
+				{"guid": "app-3", "state": "STARTED", "lifecycle": {"data": {"stack": "cflinuxfs3"}}, "relationships": {"space": {"data": {"guid": "space-1"}}}},
+				{"guid": "app-4", "state": "STARTED", "lifecycle": {"data": {}}, "relationships": {"space": {"data": {"guid": "space-1"}}}},
+				{"guid": "app-5", "state": "STARTED", "lifecycle": {"data": {}}, "relationships": {"space": {"data": {"guid": "space-1"}}}}
+			]}`))
+		case r.URL.Path == "/v3/apps/app-4/processes/web/stats":
+			w.Write([]byte(`{"resources": [{"state": "RUNNING", "host": "10.0.0.2"}, {"state": "RUNNING", "host": "10.0.0.1"}]}`))
+		case r.URL.Path == "/v3/apps/app-5/processes/web/stats":
+			w.Write([]byte(`{"resources": [{"state": "RUNNING", "host": "10.0.0.3"}]}`))
+		case r.URL.Path == "/oauth/token":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token": "client-token", "token_type": "bearer", "expires_in": 3600}`))
+		case r.Method == "POST" && r.URL.Path == "/v3/deployments":
+			// Restarts outlive the admin's token.
+			if auth := r.Header.Get("Authorization"); auth != "Bearer client-token" {
+				t.Errorf("Expected the restart with the dashboard's credentials. Found %q", auth)
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			restarted = append(restarted, string(body))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.UAAURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(adminTokenData, envVars)
+
+	for _, test := range []struct {
+		name, body, expectedResponse string
+	}{
+		{"Without Selection", `{"concurrency": 2}`, `{"status": "failure", "data": "stacks or cell_hosts are required."}`},
+		{"Too Concurrent", `{"stacks": ["cflinuxfs3"], "concurrency": 50}`, `{"status": "failure", "data": "concurrency can be at most 20."}`},
+		{"Invalid Window", `{"stacks": ["cflinuxfs3"], "window": "night"}`, `{"status": "failure", "data": "invalid maintenance window \"night\", expected HH:MM-HH:MM"}`},
+	} {
+		response, request := NewTestRequest("POST", "/admin/restarts", []byte(test.body))
+		router.ServeHTTP(response, request)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected code %d. Found %d", test.name, http.StatusBadRequest, response.Code)
+		}
+		expected := NewJSONResponseContentTester(test.expectedResponse)
+		if !expected.Check(t, response.Body.String()) {
+			t.Errorf("%s: expected %s. Found %s", test.name, expected.Display(), response.Body.String())
+		}
+	}
+
+	response, request := NewTestRequest("POST", "/admin/restarts", []byte(`{
+		"stacks": ["cflinuxfs3"],
+		"cell_hosts": ["10.0.0.1"],
+		"concurrency": 5,
+		"skip_app_guids": ["app-3"],
+		"skip_org_guids": ["org-skip"]
+	}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusAccepted, response.Code, response.Body.String())
+	}
+	job := waitForJob(t, router, response.Header().Get("Location"))
+	if job["status"] != "succeeded" || job["kind"] != "rolling-restart" {
+		t.Errorf("Unexpected job %v", job)
+	}
+	// The restarts are recorded as the admin's.
+	response, request = NewTestRequest("GET", "/admin/audit_log?action=restart_app", nil)
+	router.ServeHTTP(response, request)
+	var auditLog struct {
+		Entries []db.AdminAuditEntry `json:"entries"`
+	}
+	json.NewDecoder(response.Body).Decode(&auditLog)
+	if len(auditLog.Entries) != 2 || auditLog.Entries[0].Actor != "admin-guid" {
+		t.Errorf("Expected the restarts by the admin. Found %+v", auditLog.Entries)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(restarted)
+	expected := []string{
+		`{"relationships":{"app":{"data":{"guid":"app-1"}}},"strategy":"rolling"}`,
+		`{"relationships":{"app":{"data":{"guid":"app-4"}}},"strategy":"rolling"}`,
+	}
+	if len(restarted) != 2 || restarted[0] != expected[0] || restarted[1] != expected[1] {
+		t.Errorf("Expected restarts %v. Found %v", expected, restarted)
+	}
+}
+
+func TestScheduleRestartsWithoutAdminScope(t *testing.T) {
+	router, _ := CreateRouterWithMockSession(userTokenData, GetMockCompleteEnvVars())
+	response, request := NewTestRequest("POST", "/admin/restarts", []byte(`{"stacks": ["cflinuxfs3"]}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected code %d. Found %d", http.StatusForbidden, response.Code)
+	}
+}
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var roleGrantLog = logging.New("role_grants")
+
+// RoleGrantContext stores the session info and access token per user.
+// All routes within RoleGrantContext let org managers give users roles for a
+// limited time, e.g. to respond to an incident.
+type RoleGrantContext struct {
+	*SecureContext // Required.
+}
+
+// roleGrantBody is the body to grant a role temporarily.
+type roleGrantBody struct {
+	UserID    string `json:"user_id"`
+	OrgGUID   string `json:"org_guid"`
+	SpaceGUID string `json:"space_guid"`
+	Role      string `json:"role"`
+	// Duration is how long the role is granted for, e.g. 8h.
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+// writeRoleGrant responds with the role grant.
+func writeRoleGrant(rw http.ResponseWriter, status int, grant interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(grant)
+}
+
+// requireOrgManager responds with an error and returns false unless the user
+// manages the org.
+func (c *RoleGrantContext) requireOrgManager(rw http.ResponseWriter, orgGUID string) bool {
+	ok, uaaErr := c.managesOrg(orgGUID)
+	if uaaErr != nil {
+		uaaErr.writeTo(rw)
+		return false
+	}
+	if !ok {
+		newUaaError(http.StatusForbidden, "only org managers can grant roles in the org.").writeTo(rw)
+		return false
+	}
+	return true
+}
+
+// hasMember returns true if the user is in the CF API's list of users at
+// path, e.g. an org's users or a space's developers.
+func (c *RoleGrantContext) hasMember(path, userID string) (bool, error) {
+	members, err := c.ccGetAll(path + "?results-per-page=100")
+	if err != nil {
+		return false, err
+	}
+	for _, member := range members {
+		if member.Metadata.GUID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Create gives the user a role in an org the user manages, or in one of its
+// spaces, until the grant's duration is up. The role is given with the
+// manager's own credentials, so the CF API checks they're allowed to, and
+// revoked by the dashboard when it expires. Roles the user already has can't
+// be granted, so they aren't taken away when the grant expires.
+func (c *RoleGrantContext) Create(rw web.ResponseWriter, req *web.Request) {
+	var body roleGrantBody
+	if err := readBodyToStruct(req.Body, &body); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	duration, err := time.ParseDuration(body.Duration)
+	if err != nil {
+		newUaaError(http.StatusBadRequest, "the duration is not a duration like 8h.").writeTo(rw)
+		return
+	}
+	now := time.Now().UTC()
+	grant := db.RoleGrant{
+		UserID:    body.UserID,
+		OrgGUID:   body.OrgGUID,
+		SpaceGUID: body.SpaceGUID,
+		Role:      body.Role,
+		Reason:    strings.TrimSpace(body.Reason),
+		GrantedBy: c.userID(),
+		ExpiresAt: now.Add(duration),
+	}
+	if err := grant.Validate(now); err != nil {
+		newUaaError(http.StatusBadRequest, err.Error()).writeTo(rw)
+		return
+	}
+	if !c.requireOrgManager(rw, grant.OrgGUID) {
+		return
+	}
+	if grant.SpaceGUID != "" {
+		var space ccResource
+		if err := c.ccRequest("GET", "/v2/spaces/"+url.PathEscape(grant.SpaceGUID), nil, &space); err != nil {
+			newUaaError(ccErrorStatus(err), err.Error()).writeTo(rw)
+			return
+		}
+		var entity struct {
+			OrganizationGUID string `json:"organization_guid"`
+		}
+		if err := json.Unmarshal(space.Entity, &entity); err != nil || entity.OrganizationGUID != grant.OrgGUID {
+			newUaaError(http.StatusBadRequest, "the space is not in the org.").writeTo(rw)
+			return
+		}
+	}
+	has, err := c.hasMember(helpers.RoleMembersPath(grant), grant.UserID)
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	if has {
+		newUaaError(http.StatusConflict, "the user already has this role.").writeTo(rw)
+		return
+	}
+	member, err := c.hasMember(helpers.OrgUsersPath(grant.OrgGUID), grant.UserID)
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	grant.AddedToOrg = !member
+
+	// The grant is kept first, so a role given is always revoked.
+	grant, err = c.Settings.RoleGrantStore.CreateRoleGrant(grant)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	// Users need to be in the org to have any other role in it.
+	paths := []string{helpers.RoleGrantPath(grant)}
+	if grant.AddedToOrg {
+		paths = []string{helpers.OrgUserPath(grant.OrgGUID, grant.UserID), paths[0]}
+	}
+	for _, path := range paths {
+		if err := c.ccRequest("PUT", path, nil, nil); err != nil {
+			if _, revokeErr := c.Settings.RoleGrants.Revoke(grant, db.RoleGrantRevoked, grant.GrantedBy); revokeErr != nil {
+				c.logger(roleGrantLog).Errorf("unable to undo role grant %s: %v", grant.ID, revokeErr)
+			}
+			if parked, ok := err.(*parkedChangeError); ok {
+				// An approved change would never be revoked, so it's withdrawn.
+				if _, err := c.Settings.PendingChanges.DecidePendingChange(parked.Change.ID, db.PendingChangeRejected, grant.GrantedBy); err != nil {
+					c.logger(roleGrantLog).Errorf("unable to withdraw pending change %s: %v", parked.Change.ID, err)
+				}
+				newUaaError(http.StatusForbidden, "this role can't be granted temporarily, it needs the approval of another admin.").writeTo(rw)
+				return
+			}
+			newUaaError(ccErrorStatus(err), "unable to give the role: "+err.Error()).writeTo(rw)
+			return
+		}
+	}
+	if grant.AddedToOrg {
+		c.publishUserAdded(grant.OrgGUID, grant.UserID, grant.Role)
+	}
+	c.Settings.RecordAuditEvent(req.Request, grant.GrantedBy, "grant_role", grant)
+	c.Settings.AdminAudit.Record(req.Request, grant.GrantedBy, "grant_role", grant.UserID, grant)
+	writeRoleGrant(rw, http.StatusCreated, grant)
+}
+
+// List returns the user's own role grants or, with ?org_guid=, the grants in
+// an org the user manages. ?status= filters them.
+func (c *RoleGrantContext) List(rw web.ResponseWriter, req *web.Request) {
+	query := req.URL.Query()
+	filter := db.RoleGrantFilter{OrgGUID: query.Get("org_guid"), Status: query.Get("status")}
+	if filter.OrgGUID == "" {
+		filter.UserID = c.userID()
+	} else if !c.requireOrgManager(rw, filter.OrgGUID) {
+		return
+	}
+	grants, err := c.Settings.RoleGrantStore.RoleGrants(filter)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	writeRoleGrant(rw, http.StatusOK, grants)
+}
+
+// Revoke takes the granted role away before the grant expires.
+func (c *RoleGrantContext) Revoke(rw web.ResponseWriter, req *web.Request) {
+	grant, err := c.Settings.RoleGrantStore.RoleGrant(req.PathParams["id"])
+	if err == db.ErrRoleGrantNotFound {
+		newUaaError(http.StatusNotFound, err.Error()+".").writeTo(rw)
+		return
+	}
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	if !c.requireOrgManager(rw, grant.OrgGUID) {
+		return
+	}
+	if grant.Status != db.RoleGrantActive {
+		newUaaError(http.StatusConflict, db.ErrRoleGrantEnded.Error()+".").writeTo(rw)
+		return
+	}
+	grant, err = c.Settings.RoleGrants.Revoke(grant, db.RoleGrantRevoked, c.userID())
+	if err == db.ErrRoleGrantEnded {
+		newUaaError(http.StatusConflict, err.Error()+".").writeTo(rw)
+		return
+	}
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "revoke_role_grant", grant)
+	c.Settings.AdminAudit.Record(req.Request, c.userID(), "revoke_role_grant", grant.UserID, grant)
+	writeRoleGrant(rw, http.StatusOK, grant)
+}
@@ -0,0 +1,133 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestRoleGrants(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		changes []string
+	)
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v2/users/admin-guid/managed_organizations":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "org-1"}}]}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/spaces/space-1":
+			w.Write([]byte(`{"metadata": {"guid": "space-1"}, "entity": {"organization_guid": "org-1"}}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/spaces/space-1/developers":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "dev-guid"}}]}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/organizations/org-1/users":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "dev-guid"}}]}`))
+		case r.Method == "PUT" || r.Method == "DELETE":
+			mu.Lock()
+			changes = append(changes, r.Method+" "+r.URL.Path)
+			mu.Unlock()
+			if r.Method == "DELETE" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cc.Close()
+	uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "privileged-token", "token_type": "bearer", "expires_in": 3600}`))
+	}))
+	defer uaa.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.UAAURLEnvVar] = uaa.URL
+	router, _ := CreateRouterWithMockSession(adminTokenData, envVars)
+
+	for body, expected := range map[string]int{
+		`{"user_id": "responder-guid", "org_guid": "org-1", "space_guid": "space-1", "role": "developers", "duration": "30d"}`: http.StatusBadRequest,
+		`{"user_id": "responder-guid", "org_guid": "org-1", "space_guid": "space-1", "role": "developers", "duration": "5m"}`:  http.StatusBadRequest,
+		`{"user_id": "responder-guid", "org_guid": "org-1", "role": "users", "duration": "8h"}`:                                http.StatusBadRequest,
+		`{"user_id": "responder-guid", "org_guid": "org-2", "role": "auditors", "duration": "8h"}`:                             http.StatusForbidden,
+		`{"user_id": "dev-guid", "org_guid": "org-1", "space_guid": "space-1", "role": "developers", "duration": "8h"}`:        http.StatusConflict,
+	} {
+		response, request := NewTestRequest("POST", "/api/role_grants", []byte(body))
+		router.ServeHTTP(response, request)
+		if response.Code != expected {
+			t.Errorf("Expected code %d for %s. Found %d", expected, body, response.Code)
+		}
+	}
+
+	response, request := NewTestRequest("POST", "/api/role_grants",
+		[]byte(`{"user_id": "responder-guid", "org_guid": "org-1", "space_guid": "space-1", "role": "developers", "duration": "8h", "reason": "Incident 42"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusCreated, response.Code, response.Body.String())
+	}
+	var grant db.RoleGrant
+	json.NewDecoder(response.Body).Decode(&grant)
+	if grant.Status != db.RoleGrantActive || !grant.AddedToOrg || grant.GrantedBy != "admin-guid" {
+		t.Errorf("Unexpected grant %+v", grant)
+	}
+
+	response, request = NewTestRequest("POST", "/api/role_grants/"+grant.ID+"/revoke", nil)
+	router.ServeHTTP(response, request)
+	json.NewDecoder(response.Body).Decode(&grant)
+	if response.Code != http.StatusOK || grant.Status != db.RoleGrantRevoked || grant.EndedBy != "admin-guid" {
+		t.Errorf("Expected the grant to be revoked. Found %d with %+v", response.Code, grant)
+	}
+	response, request = NewTestRequest("POST", "/api/role_grants/"+grant.ID+"/revoke", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected code %d. Found %d", http.StatusConflict, response.Code)
+	}
+
+	// The role changes are in the admin audit log.
+	response, request = NewTestRequest("GET", "/admin/audit_log?target=responder-guid", nil)
+	router.ServeHTTP(response, request)
+	var auditLog struct {
+		Entries []db.AdminAuditEntry `json:"entries"`
+	}
+	json.NewDecoder(response.Body).Decode(&auditLog)
+	if len(auditLog.Entries) != 2 || auditLog.Entries[0].Action != "revoke_role_grant" ||
+		auditLog.Entries[1].Action != "grant_role" || auditLog.Entries[1].Actor != "admin-guid" ||
+		auditLog.Entries[1].RequestID == "" {
+		t.Errorf("Expected the grant and revocation newest first. Found %+v", auditLog.Entries)
+	}
+	response, request = NewTestRequest("GET", "/admin/audit_log?limit=0", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected code %d. Found %d", http.StatusBadRequest, response.Code)
+	}
+	userRouter, _ := CreateRouterWithMockSession(userTokenData, envVars)
+	response, request = NewTestRequest("GET", "/admin/audit_log", nil)
+	userRouter.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected code %d. Found %d", http.StatusForbidden, response.Code)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{
+		"PUT /v2/organizations/org-1/users/responder-guid",
+		"PUT /v2/spaces/space-1/developers/responder-guid",
+		"DELETE /v2/spaces/space-1/developers/responder-guid",
+		"DELETE /v2/organizations/org-1/users/responder-guid",
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %v. Found %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Expected %v. Found %v", expected, changes)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers/logging"
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+var notifyLog = logging.New("notifications")
+
+// RoleRequestContext stores the session info and access token per user.
+// All routes within RoleRequestContext let users ask for org and space roles
+// and org managers decide on them.
+type RoleRequestContext struct {
+	*SecureContext // Required.
+}
+
+// roleRequestBody is the body to request a role.
+type roleRequestBody struct {
+	OrgGUID   string `json:"org_guid"`
+	SpaceGUID string `json:"space_guid"`
+	Role      string `json:"role"`
+	Reason    string `json:"reason"`
+}
+
+// writeRoleRequest responds with the role request.
+func writeRoleRequest(rw http.ResponseWriter, status int, request interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(request)
+}
+
+// Create requests a role in an org the user can see, or in one of its
+// spaces. The org's managers are emailed about it.
+func (c *RoleRequestContext) Create(rw web.ResponseWriter, req *web.Request) {
+	var body roleRequestBody
+	if err := readBodyToStruct(req.Body, &body); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	request := db.RoleRequest{
+		UserID:    c.userID(),
+		OrgGUID:   body.OrgGUID,
+		SpaceGUID: body.SpaceGUID,
+		Role:      body.Role,
+		Reason:    strings.TrimSpace(body.Reason),
+	}
+	if err := request.Validate(); err != nil {
+		newUaaError(http.StatusBadRequest, err.Error()).writeTo(rw)
+		return
+	}
+	if request.UserID == "" {
+		newUaaError(http.StatusForbidden, "roles can't be requested with this account.").writeTo(rw)
+		return
+	}
+	if err := c.ccRequest("GET", "/v2/organizations/"+url.PathEscape(request.OrgGUID), nil, nil); err != nil {
+		newUaaError(http.StatusNotFound, "unknown org.").writeTo(rw)
+		return
+	}
+	pending, err := c.Settings.RoleRequests.RoleRequests(db.RoleRequestFilter{
+		UserID:  request.UserID,
+		OrgGUID: request.OrgGUID,
+		Status:  db.RoleRequestPending,
+	})
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	for _, p := range pending {
+		if p.SpaceGUID == request.SpaceGUID && p.Role == request.Role {
+			newUaaError(http.StatusConflict, "this role was already requested.").writeTo(rw)
+			return
+		}
+	}
+	request, err = c.Settings.RoleRequests.CreateRoleRequest(request)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, request.UserID, "request_role", request)
+	c.notifyOrgManagers(request)
+	writeRoleRequest(rw, http.StatusCreated, request)
+}
+
+// notifyOrgManagers emails the org's managers about the new request in the
+// background. The request stands even if they can't be emailed.
+func (c *RoleRequestContext) notifyOrgManagers(request db.RoleRequest) {
+	managers, err := c.ccGetAll("/v2/organizations/" + url.PathEscape(request.OrgGUID) + "/managers?results-per-page=100")
+	if err != nil {
+		c.logger(notifyLog).Errorf("unable to notify the managers of org %s about role request %s: %v", request.OrgGUID, request.ID, err)
+		return
+	}
+	ids := make([]string, 0, len(managers))
+	for _, manager := range managers {
+		ids = append(ids, manager.Metadata.GUID)
+	}
+	if len(ids) > scimFilterSize {
+		ids = ids[:scimFilterSize]
+	}
+	if len(ids) == 0 {
+		return
+	}
+	subject := "Role request"
+	scope := "your org"
+	if request.SpaceGUID != "" {
+		scope = "a space of your org"
+	}
+	message := fmt.Sprintf("Someone has requested the %s role in %s.", strings.Replace(request.Role, "_", " ", -1), scope)
+	if request.Reason != "" {
+		message += "\n\nTheir reason: " + request.Reason
+	}
+	message += "\n\nReview the request in the dashboard at " + c.Settings.AppURL + "."
+	body := new(bytes.Buffer)
+	if err := c.templates.GetBroadcastEmail(body, subject, message); err != nil {
+		c.logger(notifyLog).Errorf("unable to notify about role request %s: %v", request.ID, err)
+		return
+	}
+	_, err = c.Settings.Jobs.Submit("role-request-notification", request.UserID, []jobs.Task{{
+		Name: "notify org managers",
+		Run: func() error {
+			users, err := c.scimUsers(ids)
+			if err != nil {
+				return err
+			}
+			for _, user := range users {
+				if email := primaryEmail(user); email != "" {
+					if err := c.mailer.SendEmail(email, subject, body.Bytes()); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	}})
+	if err != nil {
+		c.logger(notifyLog).Errorf("unable to notify about role request %s: %v", request.ID, err)
+	}
+}
+
+// List returns the user's own role requests or, with ?org_guid=, the
+// requests for an org the user manages. ?status= filters them.
+func (c *RoleRequestContext) List(rw web.ResponseWriter, req *web.Request) {
+	query := req.URL.Query()
+	filter := db.RoleRequestFilter{OrgGUID: query.Get("org_guid"), Status: query.Get("status")}
+	if filter.OrgGUID == "" {
+		filter.UserID = c.userID()
+	} else if ok, uaaErr := c.managesOrg(filter.OrgGUID); uaaErr != nil {
+		uaaErr.writeTo(rw)
+		return
+	} else if !ok {
+		newUaaError(http.StatusForbidden, "only org managers can see the org's role requests.").writeTo(rw)
+		return
+	}
+	requests, err := c.Settings.RoleRequests.RoleRequests(filter)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	writeRoleRequest(rw, http.StatusOK, requests)
+}
+
+// pendingRequestForManager gets the pending request of the path and checks
+// the user manages its org. It responds with an error and returns false
+// otherwise.
+func (c *RoleRequestContext) pendingRequestForManager(rw web.ResponseWriter, req *web.Request) (db.RoleRequest, bool) {
+	request, err := c.Settings.RoleRequests.RoleRequest(req.PathParams["id"])
+	if err == db.ErrRoleRequestNotFound {
+		newUaaError(http.StatusNotFound, err.Error()+".").writeTo(rw)
+		return request, false
+	}
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return request, false
+	}
+	if ok, uaaErr := c.managesOrg(request.OrgGUID); uaaErr != nil {
+		uaaErr.writeTo(rw)
+		return request, false
+	} else if !ok {
+		newUaaError(http.StatusForbidden, "only org managers can decide on role requests.").writeTo(rw)
+		return request, false
+	}
+	if request.Status != db.RoleRequestPending {
+		newUaaError(http.StatusConflict, db.ErrRoleRequestDecided.Error()+".").writeTo(rw)
+		return request, false
+	}
+	return request, true
+}
+
+// decide records the decision on the request and responds with it, with the
+// code.
+func (c *RoleRequestContext) decide(rw web.ResponseWriter, req *web.Request, request db.RoleRequest, status string, code int) {
+	request, err := c.Settings.RoleRequests.DecideRoleRequest(request.ID, status, c.userID())
+	if err == db.ErrRoleRequestDecided {
+		newUaaError(http.StatusConflict, err.Error()+".").writeTo(rw)
+		return
+	}
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	action := "approve_role_request"
+	if status == db.RoleRequestDenied {
+		action = "deny_role_request"
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), action, request)
+	writeRoleRequest(rw, code, request)
+}
+
+// Approve gives the requested role with the manager's own credentials, so
+// the CF API checks they're allowed to, and marks the request approved.
+func (c *RoleRequestContext) Approve(rw web.ResponseWriter, req *web.Request) {
+	request, ok := c.pendingRequestForManager(rw, req)
+	if !ok {
+		return
+	}
+	org := "/v2/organizations/" + url.PathEscape(request.OrgGUID)
+	user := "/" + url.PathEscape(request.UserID)
+	// Users need to be in the org to have any other role in it.
+	paths := []string{org + "/users" + user}
+	if request.SpaceGUID != "" {
+		var space ccResource
+		if err := c.ccRequest("GET", "/v2/spaces/"+url.PathEscape(request.SpaceGUID), nil, &space); err != nil {
+			newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+			return
+		}
+		var entity struct {
+			OrganizationGUID string `json:"organization_guid"`
+		}
+		if err := json.Unmarshal(space.Entity, &entity); err != nil || entity.OrganizationGUID != request.OrgGUID {
+			newUaaError(http.StatusBadRequest, "the space is not in the org.").writeTo(rw)
+			return
+		}
+		paths = append(paths, "/v2/spaces/"+url.PathEscape(request.SpaceGUID)+"/"+request.Role+user)
+	} else if request.Role != "users" {
+		paths = append(paths, org+"/"+request.Role+user)
+	}
+	var parked *parkedChangeError
+	for _, path := range paths {
+		err := c.ccRequest("PUT", path, nil, nil)
+		if p, ok := err.(*parkedChangeError); ok {
+			// The role is given once another admin approves it.
+			parked = p
+			continue
+		}
+		if err != nil {
+			newUaaError(http.StatusBadGateway, "unable to give the role: "+err.Error()).writeTo(rw)
+			return
+		}
+	}
+	if parked != nil {
+		rw.Header().Set("Location", "/admin/pending_changes/"+parked.Change.ID)
+		c.decide(rw, req, request, db.RoleRequestApproved, http.StatusAccepted)
+		return
+	}
+	c.publishUserAdded(request.OrgGUID, request.UserID, request.Role)
+	c.decide(rw, req, request, db.RoleRequestApproved, http.StatusOK)
+}
+
+// Deny marks the request denied.
+func (c *RoleRequestContext) Deny(rw web.ResponseWriter, req *web.Request) {
+	request, ok := c.pendingRequestForManager(rw, req)
+	if !ok {
+		return
+	}
+	c.decide(rw, req, request, db.RoleRequestDenied, http.StatusOK)
+}
@@ -0,0 +1,152 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+// switchSession logs another user in to the router's session. The router
+// keeps a copy of the store, but they share the session.
+func switchSession(store *MockSessionStore, data map[string]interface{}) {
+	for key := range store.Session.Values {
+		delete(store.Session.Values, key)
+	}
+	for key, value := range data {
+		store.Session.Values[key] = value
+	}
+}
+
+func TestRoleRequests(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		assigned []string
+	)
+	notified := make(chan string, 1)
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v2/organizations/org-1":
+			w.Write([]byte(`{"metadata": {"guid": "org-1"}, "entity": {"name": "org"}}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/organizations/org-1/managers":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "admin-guid"}}]}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/users/admin-guid/managed_organizations":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "org-1"}}]}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/users/user-guid/managed_organizations":
+			w.Write([]byte(`{"next_url": null, "resources": []}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/spaces/space-1":
+			w.Write([]byte(`{"metadata": {"guid": "space-1"}, "entity": {"organization_guid": "org-1"}}`))
+		case r.Method == "PUT":
+			mu.Lock()
+			assigned = append(assigned, r.URL.Path)
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("Unexpected CC request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cc.Close()
+	uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/token":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token": "privileged-token", "token_type": "bearer", "expires_in": 3600}`))
+		case "/Users":
+			notified <- r.URL.Query().Get("filter")
+			w.Write([]byte(`{"resources": [{"id": "admin-guid", "emails": [{"value": "manager@example.com"}]}]}`))
+		}
+	}))
+	defer uaa.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	envVars[helpers.UAAURLEnvVar] = uaa.URL
+	router, store := CreateRouterWithMockSession(userTokenData, envVars)
+
+	response, request := NewTestRequest("POST", "/api/role_requests", []byte(`{"org_guid": "org-1", "role": "developers"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected an org role to be required. Found %d", response.Code)
+	}
+
+	response, request = NewTestRequest("POST", "/api/role_requests",
+		[]byte(`{"org_guid": "org-1", "space_guid": "space-1", "role": "developers", "reason": "Deploying the new app"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusCreated, response.Code, response.Body.String())
+	}
+	var created struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	json.NewDecoder(response.Body).Decode(&created)
+	if created.ID == "" || created.Status != "pending" {
+		t.Errorf("Unexpected request %+v", created)
+	}
+	select {
+	case filter := <-notified:
+		if filter != `id eq "admin-guid"` {
+			t.Errorf("Expected the org manager to be notified. Found %s", filter)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the org managers to be notified")
+	}
+
+	response, request = NewTestRequest("POST", "/api/role_requests",
+		[]byte(`{"org_guid": "org-1", "space_guid": "space-1", "role": "developers"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected the same request to conflict. Found %d", response.Code)
+	}
+
+	// Requesters can't decide on their own requests.
+	response, request = NewTestRequest("POST", "/api/role_requests/"+created.ID+"/approve", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected code %d. Found %d", http.StatusForbidden, response.Code)
+	}
+
+	switchSession(store, adminTokenData)
+	response, request = NewTestRequest("GET", "/api/role_requests?org_guid=org-1&status=pending", nil)
+	router.ServeHTTP(response, request)
+	if !strings.Contains(response.Body.String(), created.ID) {
+		t.Errorf("Expected the org manager to see the request. Found %s", response.Body.String())
+	}
+
+	response, request = NewTestRequest("POST", "/api/role_requests/"+created.ID+"/approve", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+	expected := "/v2/organizations/org-1/users/user-guid,/v2/spaces/space-1/developers/user-guid"
+	if strings.Join(assigned, ",") != expected {
+		t.Errorf("Expected the roles %s to be given. Found %s", expected, assigned)
+	}
+
+	response, request = NewTestRequest("POST", "/api/role_requests/"+created.ID+"/deny", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected a decided request to conflict. Found %d", response.Code)
+	}
+
+	switchSession(store, userTokenData)
+	response, request = NewTestRequest("GET", "/api/role_requests", nil)
+	router.ServeHTTP(response, request)
+	expectedResponse := NewJSONResponseContentTester(`[{"status": "approved", "decided_by": "admin-guid"}]`)
+	var requests []struct {
+		Status    string `json:"status"`
+		DecidedBy string `json:"decided_by"`
+	}
+	json.NewDecoder(response.Body).Decode(&requests)
+	b, _ := json.Marshal(requests)
+	if !expectedResponse.Check(t, string(b)) {
+		t.Errorf("Unexpected requests %s", b)
+	}
+}
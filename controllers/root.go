@@ -1,46 +1,90 @@
 package controllers
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/logging"
 	"github.com/18F/cg-dashboard/mailer"
 	"github.com/gocraft/web"
 	"github.com/gorilla/csrf"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/oauth2"
 )
 
+var (
+	loginLog  = logging.New("login")
+	healthLog = logging.New("health")
+)
+
 // Context represents the context for all requests that do not need authentication.
 type Context struct {
 	Settings  *helpers.Settings
 	templates *helpers.Templates
 	mailer    mailer.Mailer
+	// requestID identifies the request in the logs and error responses.
+	requestID string
+	// logFields are the key and value pairs logged with every line about
+	// the request, e.g. its ID.
+	logFields []interface{}
+	// annotations tell the timeout handler about the request, if it's
+	// served through one.
+	annotations *helpers.RequestAnnotations
+	// cspNonce is the nonce the inline scripts of the response need to run.
+	cspNonce string
 }
 
 // StaticMiddleware provides simple caching middleware for static assets.
-func StaticMiddleware(path string) func(web.ResponseWriter, *web.Request, web.NextMiddlewareFunc) {
+// The content-hashed assets of the manifest never change and are cached for
+// good. The index refers to them by their hashed names, so a new deploy is
+// picked up as soon as the index is reloaded.
+func StaticMiddleware(path string, assets helpers.AssetManifest) func(web.ResponseWriter, *web.Request, web.NextMiddlewareFunc) {
 	staticMiddleware := web.StaticMiddleware(path)
 	return func(rw web.ResponseWriter, r *web.Request, next web.NextMiddlewareFunc) {
-		// We want clients to cache these assets but it's important that they are
-		// up to date. If the javascript bundle does not match the server API,
-		// undefined behavior could happen.
-		rw.Header().Set("Cache-Control", "public, must-revalidate")
+		if file := strings.TrimPrefix(r.URL.Path, "/assets/"); file != r.URL.Path && assets.IsHashed(file) {
+			rw.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			// We want clients to cache the other assets but it's important that
+			// they are up to date. If the javascript bundle does not match the
+			// server API, undefined behavior could happen.
+			rw.Header().Set("Cache-Control", "public, must-revalidate")
+		}
 		staticMiddleware(rw, r, next)
 	}
 }
 
+// Assets returns the asset manifest, so that clients and tooling can find the
+// hashed file names of the current build. It is empty when the assets are not
+// hashed.
+func (c *Context) Assets(rw web.ResponseWriter, req *web.Request) {
+	assets := c.Settings.Assets
+	if assets == nil {
+		assets = helpers.AssetManifest{}
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(rw).Encode(assets)
+}
+
 // Index serves index.html
 func (c *Context) Index(w web.ResponseWriter, r *web.Request) {
+	// The index refers to the current build's assets, so it is always checked.
+	w.Header().Set("Cache-Control", "no-cache")
 	c.templates.GetIndex(w,
 		csrf.Token(r.Request),
-		os.Getenv("GA_TRACKING_ID"),
-		os.Getenv("NEW_RELIC_ID"),
-		os.Getenv("NEW_RELIC_BROWSER_LICENSE_KEY"))
+		c.cspNonce,
+		os.Getenv(helpers.GATrackingIDEnvVar),
+		os.Getenv(helpers.NewRelicIDEnvVar),
+		os.Getenv(helpers.NewRelicBrowserLicenseKeyEnvVar),
+		c.theme())
 }
 
 type pingData struct {
@@ -86,23 +130,130 @@ func (c *Context) Ping(rw web.ResponseWriter, req *web.Request) {
 		rw.WriteHeader(http.StatusInternalServerError)
 		// Also, should log out the data in the case of error so we can look at logs
 		// later to see what's wrong.
-		log.Println(string(dataJSON))
+		c.logger(healthLog).Errorf("ping failed: %s", dataJSON)
 	}
 	rw.Write(dataJSON)
 }
 
+// readyTimeout is how long the readiness check waits for the database.
+const readyTimeout = 2 * time.Second
+
+// readyData is the result of the readiness check.
+type readyData struct {
+	Status   string          `json:"status"`
+	Database *databaseHealth `json:"database,omitempty"`
+}
+
+// databaseHealth is whether the database answers and the state of the pool
+// of connections to it.
+type databaseHealth struct {
+	Status          string `json:"status"`
+	Error           string `json:"error,omitempty"`
+	OpenConnections int    `json:"open_connections"`
+	InUse           int    `json:"in_use"`
+	Idle            int    `json:"idle"`
+	MaxOpen         int    `json:"max_open"`
+	WaitCount       int64  `json:"wait_count"`
+}
+
+// Ready is the readiness check: unlike Ping, it fails with 503 while the
+// dashboard can't serve requests because its database is unavailable, say
+// during platform maintenance. The pool reconnects by itself once the database
+// is back.
+func (c *Context) Ready(rw web.ResponseWriter, req *web.Request) {
+	data := readyData{Status: "ready"}
+	if c.Settings.DB != nil {
+		ctx, cancel := context.WithTimeout(req.Context(), readyTimeout)
+		defer cancel()
+		err := c.Settings.DB.PingContext(ctx)
+		stats := c.Settings.DB.Stats()
+		data.Database = &databaseHealth{
+			Status:          "ok",
+			OpenConnections: stats.OpenConnections,
+			InUse:           stats.InUse,
+			Idle:            stats.Idle,
+			MaxOpen:         stats.MaxOpenConnections,
+			WaitCount:       stats.WaitCount,
+		}
+		if err != nil {
+			c.logger(healthLog).Warnf("readiness check: the database is unavailable: %v", err)
+			data.Status, data.Database.Status, data.Database.Error = "unavailable", "unavailable", err.Error()
+		}
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	if data.Status != "ready" {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(rw).Encode(data)
+}
+
+// Healthz is the deep health check: it checks the dashboard can reach the CF
+// API, UAA, the database and the SMTP server, and fails with 503 when a
+// critical dependency is down. Only the SMTP server isn't critical; without
+// it the status is degraded. The result is reused for a few seconds, so the
+// checks stay cheap for the dependencies.
+func (c *Context) Healthz(rw web.ResponseWriter, req *web.Request) {
+	report := c.Settings.Health.Check(req.Context())
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	if report.Status == helpers.HealthUnavailable {
+		for name, dependency := range report.Dependencies {
+			if dependency.Critical && dependency.Status != helpers.HealthOK {
+				c.logger(healthLog).Warnf("health check: %s is unavailable: %s", name, dependency.Error)
+			}
+		}
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(rw).Encode(report)
+}
+
+// Metrics serves the dashboard's own metrics in the Prometheus text format,
+// to scrapers sending the metrics token as a bearer token. Without a metrics
+// token, it's not served at all.
+func (c *Context) Metrics(rw web.ResponseWriter, req *web.Request) {
+	token := c.Settings.MetricsToken
+	if token == "" {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	sent := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	promhttp.Handler().ServeHTTP(rw, req.Request)
+}
+
+// responseMetricsMiddleware counts the responses by status class, which the
+// error rate alert is evaluated on, and by route.
+func responseMetricsMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	start := time.Now()
+	next(rw, req)
+	status := rw.StatusCode()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	helpers.RecordResponse(status)
+	helpers.ObserveRequest(req.RoutePath(), req.Method, status, time.Since(start))
+}
+
 // LoginHandshake is the handler where we authenticate the user and the user authorizes this application access to information.
+// The page to go back to after the login can be given in the next parameter,
+// e.g. /handshake?next=/%23/org/<guid>.
 func (c *Context) LoginHandshake(rw web.ResponseWriter, req *web.Request) {
 	if token := helpers.GetValidToken(req.Request, rw, c.Settings); token != nil {
 		// We should just go to dashboard if the user already has a valid token.
-		dashboardURL := fmt.Sprintf("%s%s", c.Settings.AppURL, "/#/dashboard")
-		http.Redirect(rw, req.Request, dashboardURL, http.StatusFound)
+		http.Redirect(rw, req.Request, c.afterLoginURL(req.URL.Query().Get(nextParam)), http.StatusFound)
 
 	} else {
 		// Redirect to the Cloud Foundry Login place.
-		err := c.redirect(rw, req)
-		if err != nil {
-			fmt.Println("Error on oauth redirect: ", err.Error())
+		if err := c.redirect(rw, req); err == helpers.ErrSessionStoreBusy {
+			c.sessionStoreBusy(rw, err)
+		} else if err != nil {
+			c.loginFailed(rw, http.StatusInternalServerError, "Your login could not be started.",
+				fmt.Errorf("unable to redirect to UAA: %v", err))
 		}
 	}
 }
@@ -114,83 +265,209 @@ func (c *Context) OAuthCallback(rw web.ResponseWriter, req *web.Request) {
 	code := req.URL.Query().Get("code")
 	state := req.URL.Query().Get("state")
 
-	if len(code) < 1 {
-		// TODO: error.
-	}
-
 	// Ignore error, Get will return a session, existing or new.
 	session, _ := c.Settings.Sessions.Get(req.Request, "session")
 
 	if state == "" || state != session.Values["state"] {
-		rw.WriteHeader(http.StatusUnauthorized)
+		c.Settings.Logins.Record(helpers.LoginStateMismatch)
+		c.analyzeLoginFailure(req)
+		c.loginFailed(rw, http.StatusUnauthorized, "Your login took too long or was started in another window.",
+			fmt.Errorf("callback state mismatch (state given: %t, session is new: %t, remote_addr=%s)",
+				state != "", session.IsNew, req.RemoteAddr))
 		return
 	}
 
-	// Assume we'll use the standard config
-	tokenExchangeConfig := c.Settings.OAuthConfig
+	if len(code) < 1 {
+		// UAA sends the user back without a code when the login is denied.
+		c.loginFailed(rw, http.StatusBadRequest, "The login was cancelled or denied.",
+			fmt.Errorf("callback without a code (error=%q)", req.URL.Query().Get("error")))
+		return
+	}
 
-	// Since we use an opaque token, we'll clone the normal config
-	// but add a parameter the URL requesting the token format be opaque (smaller).
-	tokenExchangeConfig = &oauth2.Config{
-		ClientID:     c.Settings.OAuthConfig.ClientID,
-		ClientSecret: c.Settings.OAuthConfig.ClientSecret,
-		RedirectURL:  c.Settings.OAuthConfig.RedirectURL,
-		Scopes:       c.Settings.OAuthConfig.Scopes,
-		Endpoint: oauth2.Endpoint{
-			TokenURL: c.Settings.OAuthConfig.Endpoint.TokenURL + "?token_format=opaque",
-		},
+	// Sessions kept in cookies need opaque tokens, since they're smaller.
+	// Server-side sessions keep the standard tokens unless opaque access
+	// tokens were asked for.
+	tokenParams := url.Values{}
+	serverSideSessions := c.Settings.ServerSideSessions()
+	if !serverSideSessions || c.Settings.OpaqueAccessTokens {
+		tokenParams.Set("token_format", "opaque")
 	}
 
-	// Exchange the code for a token.
-	token, err := tokenExchangeConfig.Exchange(c.Settings.CreateContext(), code)
+	// Exchange the code for a token, proving it's the dashboard that started
+	// the login. Logins started before PKCE was used have no verifier; UAA
+	// only requires one when the code was issued for a challenge.
+	if verifier, ok := session.Values[codeVerifierSessionKey].(string); ok {
+		tokenParams.Set("code_verifier", verifier)
+	}
+	token, err := helpers.ExchangeCode(c.Settings.CreateContext(), c.Settings.OAuthConfig, code, tokenParams)
+	helpers.RecordOAuthTokenRequest(helpers.OAuthAuthorizationCode, err)
 	if err != nil {
-		fmt.Println("Unable to get access token from code " + code + " error " + err.Error())
+		c.Settings.Logins.Record(helpers.LoginExchangeFailed)
+		c.analyzeLoginFailure(req)
+		c.loginFailed(rw, http.StatusBadGateway, "The login service could not be reached.",
+			fmt.Errorf("unable to exchange the code for a token: %v", err))
 		return
-		// TODO: Handle. Return 500.
 	}
 
 	// Now, since CF (unlike UAA) hasn't yet been updated to understand an opaque access token,
 	// we'll use our new opaque refresh token to immediately refresh a standard JWT access token.
 	// The combined size of an opaque refresh token + a JWT access token is small enough to meet
 	// our needs (fits in a secure cookie).
-	originalRefreshToken := token.RefreshToken
+	// Deployments where CF accepts opaque tokens keep them and rely on introspection instead.
+	if !c.Settings.OpaqueAccessTokens && !serverSideSessions {
+		originalRefreshToken := token.RefreshToken
+
+		token.AccessToken = ""     // wipe out our access token
+		token.Expiry = time.Time{} // and to be sure, force an expiry
+		token, err = c.Settings.OAuthConfig.TokenSource(c.Settings.CreateContext(), token).Token()
+		helpers.RecordOAuthTokenRequest(helpers.OAuthRefreshToken, err)
+		if err != nil {
+			c.Settings.Logins.Record(helpers.LoginExchangeFailed)
+			c.loginFailed(rw, http.StatusBadGateway, "The login service could not be reached.",
+				fmt.Errorf("unable to refresh the opaque token for a JWT: %v", err))
+			return
+		}
+
+		// Now, keep our original refresh token, it was smaller (and can be used over and over)
+		token.RefreshToken = originalRefreshToken
+	}
+
+	session.Values["token"] = *token
+	helpers.StartSession(session, time.Now())
+	delete(session.Values, "state")
+	delete(session.Values, codeVerifierSessionKey)
+	next, _ := session.Values[nextSessionKey].(string)
+	delete(session.Values, nextSessionKey)
 
-	token.AccessToken = ""     // wipe out our access token
-	token.Expiry = time.Time{} // and to be sure, force an expiry
-	token, err = c.Settings.OAuthConfig.TokenSource(c.Settings.CreateContext(), token).Token()
+	// Save session, under a new ID if it's kept server-side.
+	err = helpers.RenewSessionID(session)
+	if err == nil {
+		err = session.Save(req.Request, rw)
+	}
+	if err == helpers.ErrSessionStoreBusy {
+		c.Settings.Logins.Record(helpers.LoginSessionFailed)
+		c.sessionStoreBusy(rw, err)
+		return
+	}
 	if err != nil {
-		fmt.Println("Unable to get access token from code " + code + " error " + err.Error())
+		c.Settings.Logins.Record(helpers.LoginSessionFailed)
+		c.loginFailed(rw, http.StatusInternalServerError, "Your session could not be saved.",
+			fmt.Errorf("unable to save the session after the login: %v", err))
 		return
-		// TODO: Handle. Return 500.
+	}
+	c.Settings.Logins.Record(helpers.LoginCompleted)
+	if claims, err := helpers.ParseTokenClaims(token.AccessToken); err == nil {
+		c.Settings.RecordAuditEvent(req.Request, claims.UserID, "login", nil)
+		c.analyzeLogin(req, claims.UserID)
+		c.acceptInvite(claims.UserID)
 	}
 
-	// Now, keep our original refresh token, it was smaller (and can be used over and over)
-	token.RefreshToken = originalRefreshToken
+	// Redirect to the page the user was going to, or the dashboard.
+	http.Redirect(rw, req.Request, c.afterLoginURL(next), http.StatusFound)
+}
 
-	session.Values["token"] = *token
-	delete(session.Values, "state")
+// analyzeLogin looks for anomalies in the user's login in the background,
+// so the user isn't kept waiting for the notifications.
+func (c *Context) analyzeLogin(req *web.Request, userID string) {
+	ip, err := GetClientIP(req.Request)
+	if c.Settings.AuthAnomalies == nil || err != nil || ip == "" {
+		return
+	}
+	go func() {
+		if _, err := c.Settings.AuthAnomalies.Login(userID, ip, time.Now()); err != nil {
+			loginLog.Errorf("unable to look for anomalies in the login of %s: %v", userID, err)
+		}
+	}()
+}
 
-	// Save session.
-	err = session.Save(req.Request, rw)
+// acceptInvite measures how long the user took to accept their invite, if
+// this is their first login since they were invited.
+func (c *Context) acceptInvite(userID string) {
+	if c.Settings.PendingInvites == nil {
+		return
+	}
+	invitedAt, err := c.Settings.PendingInvites.AcceptInvite(userID)
 	if err != nil {
-		fmt.Println("callback error: " + err.Error())
+		loginLog.Errorf("unable to accept the invite of %s: %v", userID, err)
+		return
+	}
+	if !invitedAt.IsZero() {
+		helpers.ObserveInviteAcceptance(invitedAt, time.Now())
 	}
+}
 
-	// Redirect to the dashboard.
-	dashboardURL := fmt.Sprintf("%s%s", c.Settings.AppURL, "/#/dashboard")
-	http.Redirect(rw, req.Request, dashboardURL, http.StatusFound)
-	// TODO. Redirect to the original route.
+// analyzeLoginFailure counts a failed login towards the failure bursts of
+// the client's IP.
+func (c *Context) analyzeLoginFailure(req *web.Request) {
+	ip, err := GetClientIP(req.Request)
+	if c.Settings.AuthAnomalies == nil || err != nil || ip == "" {
+		return
+	}
+	go c.Settings.AuthAnomalies.LoginFailed(ip, time.Now())
+}
+
+const (
+	// nextParam is the handshake's parameter with the page to go back to
+	// after the login.
+	nextParam = "next"
+	// nextSessionKey keeps the page to go back to during the login.
+	nextSessionKey = "next"
+	// codeVerifierSessionKey keeps the PKCE code verifier during the login.
+	codeVerifierSessionKey = "code_verifier"
+)
+
+// afterLoginURL is the URL to redirect to after the login: the page the user
+// was going to if it's on the dashboard, else the dashboard's home.
+func (c *Context) afterLoginURL(next string) string {
+	if target, ok := sameOriginURL(c.Settings.AppURL, next); ok {
+		return target
+	}
+	return c.Settings.AppURL + "/#/dashboard"
+}
+
+// sameOriginURL returns the absolute URL of the target if it's on the app,
+// so redirects to it can't send users to another site. Targets are paths,
+// e.g. /#/org/<guid>, or absolute URLs of the app.
+func sameOriginURL(appURL, target string) (string, bool) {
+	// Browsers treat backslashes as slashes, so /\evil.com is another site.
+	if target == "" || strings.Contains(target, "\\") {
+		return "", false
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", false
+	}
+	if u.Scheme == "" && u.Host == "" {
+		if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+			return "", false
+		}
+		return strings.TrimSuffix(appURL, "/") + target, true
+	}
+	app, err := url.Parse(appURL)
+	if err != nil || u.Scheme != app.Scheme || u.Host != app.Host {
+		return "", false
+	}
+	return target, true
 }
 
 // Logout is a handler that will attempt to clear the session information for the current user.
 func (c *Context) Logout(rw web.ResponseWriter, req *web.Request) {
 	session, _ := c.Settings.Sessions.Get(req.Request, "session")
-	// Clear the token
-	session.Values["token"] = nil
-	// Force the session to expire
-	session.Options.MaxAge = -1
-	session.Save(req.Request, rw)
-	logoutURL := fmt.Sprintf("%s%s", c.Settings.LoginURL, "/logout.do")
+	if token, ok := session.Values["token"].(oauth2.Token); ok {
+		if claims, err := helpers.ParseTokenClaims(token.AccessToken); err == nil {
+			c.Settings.RecordAuditEvent(req.Request, claims.UserID, "logout", nil)
+		}
+		// Otherwise the refresh token stays valid at UAA after the session
+		// is gone. Failing to revoke it must not keep the user logged in.
+		if token.RefreshToken != "" && c.Settings.TokenRevoker != nil {
+			if err := c.Settings.TokenRevoker.Revoke(req.Context(), token.RefreshToken, "refresh_token"); err != nil {
+				c.logger(loginLog).Warnf("could not revoke the refresh token on logout: %v", err)
+			}
+		}
+	}
+	// Clear the token and force the session to expire
+	helpers.ClearSession(req.Request, rw, session)
+	logoutURL := fmt.Sprintf("%s%s", c.Settings.UAAFailover.LoginURL(c.Settings.LoginURL), "/logout.do")
 	http.Redirect(rw, req.Request, logoutURL, http.StatusFound)
 }
 
@@ -200,14 +477,32 @@ func (c *Context) redirect(rw web.ResponseWriter, req *web.Request) error {
 	if err != nil {
 		return err
 	}
+	verifier, err := helpers.NewPKCEVerifier()
+	if err != nil {
+		return err
+	}
 
 	session.Values["state"] = state
+	session.Values[codeVerifierSessionKey] = verifier
+	delete(session.Values, nextSessionKey)
+	if next := req.URL.Query().Get(nextParam); next != "" {
+		if _, ok := sameOriginURL(c.Settings.AppURL, next); ok {
+			session.Values[nextSessionKey] = next
+		}
+	}
 	err = session.Save(req.Request, rw)
 	if err != nil {
 		return err
 	}
 
-	http.Redirect(rw, req.Request, c.Settings.OAuthConfig.AuthCodeURL(state, oauth2.AccessTypeOnline), http.StatusFound)
+	c.Settings.Logins.Record(helpers.LoginStarted)
+	// Send users to the login server that's up.
+	config := *c.Settings.OAuthConfig
+	config.Endpoint.AuthURL = c.Settings.UAAFailover.LoginURL(config.Endpoint.AuthURL)
+	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOnline,
+		oauth2.SetAuthURLParam("code_challenge", helpers.PKCEChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+	http.Redirect(rw, req.Request, authURL, http.StatusFound)
 
 	return nil
 }
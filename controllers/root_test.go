@@ -1,14 +1,30 @@
 package controllers_test
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	"github.com/cloudfoundry-community/go-cfenv"
+	"github.com/gocraft/web"
 	"github.com/govau/cf-common/env"
+	"golang.org/x/oauth2"
 
 	"github.com/18F/cg-dashboard/controllers"
+	"github.com/18F/cg-dashboard/helpers"
 	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+	"github.com/18F/cg-dashboard/helpers/testhelpers/mocks"
 )
 
 func TestPing(t *testing.T) {
@@ -31,6 +47,155 @@ func TestPing(t *testing.T) {
 	}
 }
 
+func TestMetrics(t *testing.T) {
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.MetricsTokenEnvVar] = "scrape-token"
+	app, _ := cfenv.Current()
+	router, _, err := controllers.InitApp(env.NewVarSet(env.WithMapLookup(envVars)), app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, request := NewTestRequest("GET", "/metrics", nil)
+	request.Header.Set("Authorization", "Bearer scrape-token")
+	router.ServeHTTP(response, request)
+	if response.Code != 200 {
+		t.Errorf("Expected code %d. Found %d", 200, response.Code)
+	}
+	if !strings.Contains(response.Body.String(), "dashboard_session_cookie_bytes") {
+		t.Errorf("Expected the session cookie size metric. Found %s\n", response.Body.String())
+	}
+}
+
+// Without a metrics token, the metrics aren't served at all.
+func TestMetricsWithoutToken(t *testing.T) {
+	app, _ := cfenv.Current()
+	router, _, err := controllers.InitApp(env.NewVarSet(env.WithMapLookup(GetMockCompleteEnvVars())), app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, request := NewTestRequest("GET", "/metrics", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected code %d. Found %d", http.StatusNotFound, response.Code)
+	}
+	if strings.Contains(response.Body.String(), "dashboard_") {
+		t.Errorf("Expected no metrics. Found %s", response.Body.String())
+	}
+}
+
+func TestMetricsToken(t *testing.T) {
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.MetricsTokenEnvVar] = "scrape-token"
+	app, _ := cfenv.Current()
+	router, _, err := controllers.InitApp(env.NewVarSet(env.WithMapLookup(envVars)), app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, authorization := range []string{"", "Bearer wrong-token"} {
+		response, request := NewTestRequest("GET", "/metrics", nil)
+		request.Header.Set("Authorization", authorization)
+		router.ServeHTTP(response, request)
+		if response.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %q to be refused. Found %d", authorization, response.Code)
+		}
+	}
+
+	response, request := NewTestRequest("GET", "/metrics", nil)
+	request.Header.Set("Authorization", "Bearer scrape-token")
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected code %d. Found %d", http.StatusOK, response.Code)
+	}
+	// The refused scrapes are counted by route.
+	if !strings.Contains(response.Body.String(), `dashboard_http_requests_total{method="GET",route="/metrics",status="401"}`) {
+		t.Errorf("Expected the requests by route. Found %s", response.Body.String())
+	}
+}
+
+func TestReady(t *testing.T) {
+	conn, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	closed, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	testCases := []struct {
+		name         string
+		db           *sql.DB
+		expectedCode int
+		expected     string
+	}{
+		{"without a database", nil, http.StatusOK, `{"status": "ready"}`},
+		{"database up", conn, http.StatusOK, `{"status": "ready", "database": {
+			"status": "ok", "open_connections": 1, "in_use": 0, "idle": 1, "max_open": 0, "wait_count": 0
+		}}`},
+		{"database down", closed, http.StatusServiceUnavailable, `{"status": "unavailable", "database": {
+			"status": "unavailable", "error": "sql: database is closed",
+			"open_connections": 0, "in_use": 0, "idle": 0, "max_open": 0, "wait_count": 0
+		}}`},
+	}
+	for _, test := range testCases {
+		settings := helpers.Settings{}
+		app, _ := cfenv.Current()
+		settings.InitSettings(env.NewVarSet(env.WithMapLookup(GetMockCompleteEnvVars())), app)
+		settings.DB = test.db
+		router := controllers.InitRouter(&settings, &helpers.Templates{}, &mocks.Mailer{})
+
+		response, request := NewTestRequest("GET", "/ready", nil)
+		router.ServeHTTP(response, request)
+		if response.Code != test.expectedCode {
+			t.Errorf("%s: expected code %d. Found %d", test.name, test.expectedCode, response.Code)
+		}
+		if !NewJSONResponseContentTester(test.expected).Check(t, response.Body.String()) {
+			t.Errorf("%s: unexpected response %s", test.name, response.Body.String())
+		}
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	up := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("connection refused") }
+	testCases := []struct {
+		name         string
+		cfAPI, smtp  func(ctx context.Context) error
+		expectedCode int
+		expected     string
+	}{
+		{"all up", up, up, http.StatusOK, `{"status": "ok"}`},
+		{"smtp down", up, down, http.StatusOK, `{"status": "degraded"}`},
+		{"cf api down", down, up, http.StatusServiceUnavailable, `{"status": "unavailable"}`},
+	}
+	for _, test := range testCases {
+		settings := helpers.Settings{}
+		app, _ := cfenv.Current()
+		settings.InitSettings(env.NewVarSet(env.WithMapLookup(GetMockCompleteEnvVars())), app)
+		settings.Health = &helpers.HealthChecker{Timeout: time.Second, Checks: []helpers.HealthCheck{
+			{Name: "cf_api", Critical: true, Check: test.cfAPI},
+			{Name: "smtp", Check: test.smtp},
+		}}
+		router := controllers.InitRouter(&settings, &helpers.Templates{}, &mocks.Mailer{})
+
+		response, request := NewTestRequest("GET", "/healthz", nil)
+		router.ServeHTTP(response, request)
+		if response.Code != test.expectedCode {
+			t.Errorf("%s: expected code %d. Found %d", test.name, test.expectedCode, response.Code)
+		}
+		var report struct {
+			Status       string                              `json:"status"`
+			Dependencies map[string]helpers.DependencyHealth `json:"dependencies"`
+		}
+		json.NewDecoder(response.Body).Decode(&report)
+		if !NewJSONResponseContentTester(test.expected).Check(t, `{"status": "`+report.Status+`"}`) || len(report.Dependencies) != 2 {
+			t.Errorf("%s: unexpected report %+v", test.name, report)
+		}
+	}
+}
+
 var loginHandshakeTests = []BasicConsoleUnitTest{
 	{
 		TestName:    "Login Handshake With Already Authenticated User",
@@ -64,6 +229,83 @@ func TestLoginHandshake(t *testing.T) {
 
 }
 
+func TestLoginHandshakeNext(t *testing.T) {
+	tests := []struct {
+		next     string
+		expected string
+	}{
+		{"/#/org/org-guid/spaces/space-guid", "https://hostname/#/org/org-guid/spaces/space-guid"},
+		{"https://hostname/#/marketplace", "https://hostname/#/marketplace"},
+		{"//evil.com/#/dashboard", "https://hostname/#/dashboard"},
+		{"https://evil.com/", "https://hostname/#/dashboard"},
+		{`/\evil.com`, "https://hostname/#/dashboard"},
+		{"javascript:alert(1)", "https://hostname/#/dashboard"},
+	}
+	for _, test := range tests {
+		router, _ := CreateRouterWithMockSession(ValidTokenData, GetMockCompleteEnvVars())
+		response, request := NewTestRequest("GET", "/handshake?next="+url.QueryEscape(test.next), nil)
+		router.ServeHTTP(response, request)
+		if location := response.Header().Get("Location"); location != test.expected {
+			t.Errorf("%s: expected the redirect to %s. Found %s", test.next, test.expected, location)
+		}
+	}
+
+	// The page is kept in the session during the login.
+	router, store := CreateRouterWithMockSession(nil, GetMockCompleteEnvVars())
+	response, request := NewTestRequest("GET", "/handshake?next="+url.QueryEscape("/#/org/org-guid"), nil)
+	router.ServeHTTP(response, request)
+	if next := store.Session.Values["next"]; next != "/#/org/org-guid" {
+		t.Errorf("Expected the page in the session, found %v", next)
+	}
+	response, request = NewTestRequest("GET", "/handshake?next="+url.QueryEscape("https://evil.com"), nil)
+	router.ServeHTTP(response, request)
+	if next, ok := store.Session.Values["next"]; ok {
+		t.Errorf("Expected no page in the session, found %v", next)
+	}
+}
+
+func TestLoginPKCE(t *testing.T) {
+	var verifier, query string
+	uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("grant_type") == "authorization_code" {
+			verifier = r.PostForm.Get("code_verifier")
+			query = r.URL.RawQuery
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "access", "refresh_token": "refresh", "token_type": "bearer", "expires_in": 3600}`))
+	}))
+	defer uaa.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.UAAURLEnvVar] = uaa.URL
+
+	router, store := CreateRouterWithMockSession(nil, envVars)
+	response, request := NewTestRequest("GET", "/handshake", nil)
+	router.ServeHTTP(response, request)
+	sent, ok := store.Session.Values["code_verifier"].(string)
+	if !ok || len(sent) < 43 {
+		t.Fatalf("Expected a code verifier in the session. Found %v", store.Session.Values["code_verifier"])
+	}
+	location, _ := url.Parse(response.Header().Get("Location"))
+	if query := location.Query(); query.Get("code_challenge") != helpers.PKCEChallenge(sent) || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("Expected the S256 code challenge of the verifier. Found %s", location)
+	}
+
+	state, _ := store.Session.Values["state"].(string)
+	response, request = NewTestRequest("GET", "/oauth2callback?code=code&state="+url.QueryEscape(state), nil)
+	router.ServeHTTP(response, request)
+	if verifier != sent {
+		t.Errorf("Expected the verifier in the token exchange. Found %q", verifier)
+	}
+	// The verifier must not be logged with the token URL.
+	if query != "" {
+		t.Errorf("Expected no query in the token URL. Found %q", query)
+	}
+	if _, ok := store.Session.Values["code_verifier"]; ok {
+		t.Error("Expected the verifier to be dropped after the login")
+	}
+}
+
 var logoutTests = []BasicSecureTest{
 	{
 		BasicConsoleUnitTest: BasicConsoleUnitTest{
@@ -101,3 +343,86 @@ func TestLogout(t *testing.T) {
 		}
 	}
 }
+
+func TestLogoutRevokesRefreshToken(t *testing.T) {
+	revoked := make(chan string, 1)
+	uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth/token/revoke" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		r.ParseForm()
+		revoked <- r.Form.Get("token")
+		// Failing to revoke doesn't stop the logout.
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer uaa.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.UAAURLEnvVar] = uaa.URL
+
+	response, request := NewTestRequest("GET", "/logout", nil)
+	router, store := CreateRouterWithMockSession(map[string]interface{}{
+		"token": oauth2.Token{AccessToken: "sampletoken", RefreshToken: "refreshtoken"},
+	}, envVars)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusFound || response.Header().Get("location") != "https://loginurl/logout.do" {
+		t.Errorf("Expected a redirect to the logout page. Found %d %s", response.Code, response.Header().Get("location"))
+	}
+	if store.Session.Values["token"] != nil {
+		t.Error("Logout does not clear the token stored in the session")
+	}
+	select {
+	case token := <-revoked:
+		if token != "refreshtoken" {
+			t.Errorf("Expected the refresh token to be revoked. Found %s", token)
+		}
+	default:
+		t.Error("Expected the refresh token to be revoked")
+	}
+}
+
+func TestStaticAssets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "assets"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "assets", "bundle.0123456789abcdef.js"), []byte("hashed"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "assets", "favicon.ico"), []byte("plain"), 0644)
+	manifest := []byte(`{"bundle.js":"bundle.0123456789abcdef.js"}`)
+	ioutil.WriteFile(filepath.Join(dir, "assets", "manifest.json"), manifest, 0644)
+
+	router := web.New(controllers.Context{})
+	router.Middleware(controllers.StaticMiddleware(dir, helpers.AssetManifest{"bundle.js": "bundle.0123456789abcdef.js"}))
+	for path, cacheControl := range map[string]string{
+		"/assets/bundle.0123456789abcdef.js": "public, max-age=31536000, immutable",
+		"/assets/favicon.ico":                "public, must-revalidate",
+	} {
+		response, request := NewTestRequest("GET", path, nil)
+		router.ServeHTTP(response, request)
+		if response.Code != 200 {
+			t.Errorf("%s: expected code 200. Found %d", path, response.Code)
+		}
+		if got := response.Header().Get("Cache-Control"); got != cacheControl {
+			t.Errorf("%s: expected Cache-Control %q. Found %q", path, cacheControl, got)
+		}
+	}
+
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.AssetManifestPathEnvVar] = filepath.Join(dir, "assets", "manifest.json")
+	router, _ = CreateRouterWithMockSession(map[string]interface{}{}, envVars)
+	response, request := NewTestRequest("GET", "/api/assets", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != 200 || strings.TrimSpace(response.Body.String()) != string(manifest) {
+		t.Errorf("Expected the manifest. Found %d %s", response.Code, response.Body.String())
+	}
+	response, request = NewTestRequest("GET", "/", nil)
+	router.ServeHTTP(response, request)
+	if !strings.Contains(response.Body.String(), `src="assets/bundle.0123456789abcdef.js"`) {
+		t.Error("Expected the index to refer to the hashed bundle")
+	}
+	if got := response.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Expected the index not to be cached. Found %q", got)
+	}
+}
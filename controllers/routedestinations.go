@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gocraft/web"
+)
+
+// routeDestination is an app process a route sends traffic to. Weight is the
+// percentage of the route's traffic it gets, or nil when the traffic is split
+// evenly.
+type routeDestination struct {
+	GUID        string `json:"guid,omitempty"`
+	AppGUID     string `json:"app_guid"`
+	AppName     string `json:"app_name,omitempty"`
+	ProcessType string `json:"process_type"`
+	Port        int    `json:"port,omitempty"`
+	Weight      *int   `json:"weight"`
+}
+
+// routeDestinations is the response of Destinations and UpdateDestinations.
+type routeDestinations struct {
+	RouteGUID    string             `json:"route_guid"`
+	Destinations []routeDestination `json:"destinations"`
+}
+
+// routeDestinationsFields are the fields of Destinations that can be
+// selected.
+var routeDestinationsFields = fieldsOf(routeDestinations{})
+
+// ccV3Destination is a destination of a v3 CF API route.
+type ccV3Destination struct {
+	GUID string `json:"guid,omitempty"`
+	App  struct {
+		GUID    string `json:"guid"`
+		Process struct {
+			Type string `json:"type"`
+		} `json:"process"`
+	} `json:"app"`
+	Weight *int `json:"weight,omitempty"`
+	Port   int  `json:"port,omitempty"`
+}
+
+// validateDestinations checks the destinations can replace the route's.
+// Either no destination has a weight, and the traffic is split evenly, or
+// they all have one and the weights sum to 100.
+func validateDestinations(destinations []routeDestination) error {
+	if len(destinations) == 0 {
+		return errors.New("destinations are required.")
+	}
+	weighted, total := 0, 0
+	seen := map[string]bool{}
+	for _, d := range destinations {
+		if d.AppGUID == "" {
+			return errors.New("app_guid is required for each destination.")
+		}
+		key := d.AppGUID + "/" + d.ProcessType + "/" + fmt.Sprint(d.Port)
+		if seen[key] {
+			return fmt.Errorf("app %s is a destination more than once.", d.AppGUID)
+		}
+		seen[key] = true
+		if d.Weight == nil {
+			continue
+		}
+		if *d.Weight < 1 || *d.Weight > 100 {
+			return errors.New("weights must be between 1 and 100.")
+		}
+		weighted++
+		total += *d.Weight
+	}
+	if weighted > 0 && weighted < len(destinations) {
+		return errors.New("either all destinations or none must have a weight.")
+	}
+	if weighted > 0 && total != 100 {
+		return fmt.Errorf("weights must sum to 100, not %d.", total)
+	}
+	return nil
+}
+
+// destinations returns the route's destinations with the names of their
+// apps.
+func (c *RouteContext) destinations(guid string) ([]routeDestination, error) {
+	var response struct {
+		Destinations []ccV3Destination `json:"destinations"`
+	}
+	if err := c.ccRequest("GET", "/v3/routes/"+url.PathEscape(guid)+"/destinations", nil, &response); err != nil {
+		return nil, err
+	}
+	destinations := make([]routeDestination, len(response.Destinations))
+	var appGUIDs []string
+	for i, d := range response.Destinations {
+		destinations[i] = routeDestination{
+			GUID:        d.GUID,
+			AppGUID:     d.App.GUID,
+			ProcessType: d.App.Process.Type,
+			Port:        d.Port,
+			Weight:      d.Weight,
+		}
+		appGUIDs = append(appGUIDs, d.App.GUID)
+	}
+	if len(appGUIDs) == 0 {
+		return destinations, nil
+	}
+	apps, err := c.ccGetAllV3("/v3/apps?" + url.Values{"guids": {strings.Join(appGUIDs, ",")}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, resource := range apps {
+		var app ccV3App
+		if err := json.Unmarshal(resource, &app); err != nil {
+			return nil, err
+		}
+		names[app.GUID] = app.Name
+	}
+	for i := range destinations {
+		destinations[i].AppName = names[destinations[i].AppGUID]
+	}
+	return destinations, nil
+}
+
+// Destinations lists the app processes the route sends traffic to, with the
+// share of the traffic each gets.
+func (c *RouteContext) Destinations(rw web.ResponseWriter, req *web.Request) {
+	guid := req.PathParams["guid"]
+	destinations, err := c.destinations(guid)
+	if isCCNotFound(err) {
+		newUaaError(http.StatusNotFound, "unknown route.").writeTo(rw)
+		return
+	}
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	c.writeAggregate(rw, req, routeDestinationsFields, routeDestinations{RouteGUID: guid, Destinations: destinations})
+}
+
+// UpdateDestinations replaces the route's destinations, e.g. to send a share
+// of the traffic to a canary app. Weights are checked before the CF API is
+// called, and must sum to 100.
+func (c *RouteContext) UpdateDestinations(rw web.ResponseWriter, req *web.Request) {
+	guid := req.PathParams["guid"]
+	var body struct {
+		Destinations []routeDestination `json:"destinations"`
+	}
+	if err := readBodyToStruct(req.Body, &body); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	for i := range body.Destinations {
+		if body.Destinations[i].ProcessType == "" {
+			body.Destinations[i].ProcessType = "web"
+		}
+	}
+	if err := validateDestinations(body.Destinations); err != nil {
+		newUaaError(http.StatusBadRequest, err.Error()).writeTo(rw)
+		return
+	}
+	replacement := struct {
+		Destinations []ccV3Destination `json:"destinations"`
+	}{make([]ccV3Destination, len(body.Destinations))}
+	for i, d := range body.Destinations {
+		replacement.Destinations[i].App.GUID = d.AppGUID
+		replacement.Destinations[i].App.Process.Type = d.ProcessType
+		replacement.Destinations[i].Port = d.Port
+		replacement.Destinations[i].Weight = d.Weight
+	}
+	if err := c.ccRequest("PATCH", "/v3/routes/"+url.PathEscape(guid)+"/destinations", replacement, nil); err != nil {
+		newUaaError(ccErrorStatus(err), err.Error()).writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "update_route_destinations", struct {
+		RouteGUID    string            `json:"route_guid"`
+		Destinations []ccV3Destination `json:"destinations"`
+	}{guid, replacement.Destinations})
+	destinations, err := c.destinations(guid)
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	c.writeAggregate(rw, req, routeDestinationsFields, routeDestinations{RouteGUID: guid, Destinations: destinations})
+}
@@ -0,0 +1,96 @@
+package controllers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestRouteDestinations(t *testing.T) {
+	var (
+		mu           sync.Mutex
+		destinations = []byte(`{"destinations": [
+			{"guid": "dest-1", "app": {"guid": "app-1", "process": {"type": "web"}}, "weight": null, "port": 8080}]}`)
+		patched string
+	)
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method + " " + r.URL.Path {
+		case "GET /v3/routes/route-1/destinations":
+			w.Write(destinations)
+		case "PATCH /v3/routes/route-1/destinations":
+			b, _ := ioutil.ReadAll(r.Body)
+			patched = string(b)
+			destinations = []byte(`{"destinations": [
+				{"guid": "dest-2", "app": {"guid": "app-1", "process": {"type": "web"}}, "weight": 90, "port": 8080},
+				{"guid": "dest-3", "app": {"guid": "app-2", "process": {"type": "web"}}, "weight": 10, "port": 8080}]}`)
+			w.Write(destinations)
+		case "GET /v3/apps":
+			w.Write([]byte(`{"pagination": {"next": null}, "resources": [
+				{"guid": "app-1", "name": "web"},
+				{"guid": "app-2", "name": "web-canary"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": [{"code": 10010, "title": "CF-ResourceNotFound", "detail": "Route not found"}]}`))
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	response, request := NewTestRequest("GET", "/routes/route-1/destinations", nil)
+	router.ServeHTTP(response, request)
+	expected := NewJSONResponseContentTester(`{"route_guid": "route-1", "destinations": [
+		{"guid": "dest-1", "app_guid": "app-1", "app_name": "web", "process_type": "web", "port": 8080, "weight": null}]}`)
+	if response.Code != http.StatusOK || !expected.Check(t, response.Body.String()) {
+		t.Errorf("Unexpected destinations %d %s", response.Code, response.Body.String())
+	}
+
+	response, request = NewTestRequest("GET", "/routes/route-2/destinations", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown route to be not found. Found %d", response.Code)
+	}
+
+	for _, body := range []string{
+		`{"destinations": []}`,
+		`{"destinations": [{"app_guid": "app-1", "weight": 90}, {"app_guid": "app-2", "weight": 20}]}`,
+		`{"destinations": [{"app_guid": "app-1", "weight": 90}, {"app_guid": "app-2"}]}`,
+		`{"destinations": [{"app_guid": "app-1", "weight": 100}, {"app_guid": "app-2", "weight": 0}]}`,
+		`{"destinations": [{"app_guid": "app-1", "weight": 50}, {"app_guid": "app-1", "process_type": "web", "weight": 50}]}`,
+		`{"destinations": [{"weight": 100}]}`,
+	} {
+		response, request = NewTestRequest("PUT", "/routes/route-1/destinations", []byte(body))
+		router.ServeHTTP(response, request)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be refused. Found %d", body, response.Code)
+		}
+	}
+	if patched != "" {
+		t.Fatalf("Expected invalid destinations not to be sent. Found %s", patched)
+	}
+
+	response, request = NewTestRequest("PUT", "/routes/route-1/destinations", []byte(`{"destinations": [
+		{"app_guid": "app-1", "port": 8080, "weight": 90},
+		{"app_guid": "app-2", "port": 8080, "weight": 10}]}`))
+	router.ServeHTTP(response, request)
+	expected = NewJSONResponseContentTester(`{"route_guid": "route-1", "destinations": [
+		{"guid": "dest-2", "app_guid": "app-1", "app_name": "web", "process_type": "web", "port": 8080, "weight": 90},
+		{"guid": "dest-3", "app_guid": "app-2", "app_name": "web-canary", "process_type": "web", "port": 8080, "weight": 10}]}`)
+	if response.Code != http.StatusOK || !expected.Check(t, response.Body.String()) {
+		t.Errorf("Unexpected destinations %d %s", response.Code, response.Body.String())
+	}
+	sent := NewJSONResponseContentTester(`{"destinations": [
+		{"app": {"guid": "app-1", "process": {"type": "web"}}, "weight": 90, "port": 8080},
+		{"app": {"guid": "app-2", "process": {"type": "web"}}, "weight": 10, "port": 8080}]}`)
+	if !sent.Check(t, patched) {
+		t.Errorf("Unexpected destinations sent to the CF API %s", patched)
+	}
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gocraft/web"
+)
+
+// RouteContext stores the session info and access token per user.
+// All routes within RouteContext help users with their app routes.
+type RouteContext struct {
+	*SecureContext // Required.
+}
+
+// routeOwner is the space a route belongs to.
+type routeOwner struct {
+	SpaceGUID string `json:"space_guid"`
+	SpaceName string `json:"space_name"`
+	OrgGUID   string `json:"org_guid"`
+	OrgName   string `json:"org_name"`
+}
+
+// routeApp is an app a route is mapped to.
+type routeApp struct {
+	GUID  string `json:"guid"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// routeOwnership explains who owns a route.
+type routeOwnership struct {
+	Host     string `json:"host"`
+	Domain   string `json:"domain"`
+	Path     string `json:"path,omitempty"`
+	Reserved bool   `json:"reserved"`
+	// Visible is false when the route is reserved by a space the user
+	// can't see.
+	Visible     bool        `json:"visible"`
+	Owner       *routeOwner `json:"owner"`
+	Apps        []routeApp  `json:"apps"`
+	Explanation string      `json:"explanation"`
+}
+
+// routeOwnershipFields are the fields of Ownership that can be selected.
+var routeOwnershipFields = fieldsOf(routeOwnership{})
+
+// Ownership reports whether a route is taken and, if the user can see it,
+// which space owns it and which apps it's mapped to. It answers "route is
+// already taken" errors. All lookups use the user's own token, so users only
+// learn the owner of routes in spaces they can see.
+func (c *RouteContext) Ownership(rw web.ResponseWriter, req *web.Request) {
+	query := req.URL.Query()
+	host := strings.ToLower(strings.TrimSpace(query.Get("host")))
+	domainName := strings.ToLower(strings.TrimSpace(query.Get("domain")))
+	path := query.Get("path")
+	if domainName == "" {
+		newUaaError(http.StatusBadRequest, "domain is required.").writeTo(rw)
+		return
+	}
+
+	domains, err := c.ccGetAll("/v2/domains?" + url.Values{"q": {"name:" + domainName}}.Encode())
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	if len(domains) == 0 {
+		newUaaError(http.StatusNotFound, "the domain "+domainName+" doesn't exist or is private to an org you're not a member of.").writeTo(rw)
+		return
+	}
+	domainGUID := domains[0].Metadata.GUID
+
+	ownership := routeOwnership{
+		Host:   host,
+		Domain: domainName,
+		Path:   path,
+		Apps:   []routeApp{},
+	}
+	reservedPath := "/v2/routes/reserved/domain/" + url.PathEscape(domainGUID)
+	if host != "" {
+		reservedPath += "/host/" + url.PathEscape(host)
+	}
+	if path != "" {
+		reservedPath += "?" + url.Values{"path": {path}}.Encode()
+	}
+	err = c.ccRequest("GET", reservedPath, nil, nil)
+	switch {
+	case err == nil:
+		ownership.Reserved = true
+	case isCCNotFound(err):
+		ownership.Explanation = "The route is available."
+		c.writeAggregate(rw, req, routeOwnershipFields, ownership)
+		return
+	default:
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+
+	routes, err := c.ccGetAll("/v2/routes?" + url.Values{
+		"q": {"host:" + host, "domain_guid:" + domainGUID},
+	}.Encode())
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	var route *ccResource
+	for i := range routes {
+		var entity struct {
+			Path string `json:"path"`
+		}
+		if err := json.Unmarshal(routes[i].Entity, &entity); err != nil {
+			newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+			return
+		}
+		if entity.Path == path {
+			route = &routes[i]
+			break
+		}
+	}
+	if route == nil {
+		ownership.Explanation = "The route is taken by a space you're not a member of. Ask the owners to unmap and delete it, or contact support."
+		c.writeAggregate(rw, req, routeOwnershipFields, ownership)
+		return
+	}
+
+	ownership.Visible = true
+	if ownership.Owner, err = c.routeOwner(route); err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	apps, err := c.ccGetAll("/v2/routes/" + route.Metadata.GUID + "/apps")
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	for _, resource := range apps {
+		var app ccApp
+		if err := json.Unmarshal(resource.Entity, &app); err != nil {
+			newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+			return
+		}
+		ownership.Apps = append(ownership.Apps, routeApp{
+			GUID:  resource.Metadata.GUID,
+			Name:  app.Name,
+			State: app.State,
+		})
+	}
+	if len(ownership.Apps) == 0 {
+		ownership.Explanation = "The route is taken by the " + ownership.Owner.SpaceName + " space of the " + ownership.Owner.OrgName + " org but isn't mapped to any app. It can be deleted from that space."
+	} else {
+		ownership.Explanation = "The route is taken by the " + ownership.Owner.SpaceName + " space of the " + ownership.Owner.OrgName + " org and is mapped to its apps."
+	}
+	c.writeAggregate(rw, req, routeOwnershipFields, ownership)
+}
+
+// routeOwner looks up the space and org of the route.
+func (c *RouteContext) routeOwner(route *ccResource) (*routeOwner, error) {
+	var entity struct {
+		SpaceGUID string `json:"space_guid"`
+	}
+	if err := json.Unmarshal(route.Entity, &entity); err != nil {
+		return nil, err
+	}
+	var space struct {
+		Entity struct {
+			Name             string `json:"name"`
+			OrganizationGUID string `json:"organization_guid"`
+		} `json:"entity"`
+	}
+	if err := c.ccRequest("GET", "/v2/spaces/"+url.PathEscape(entity.SpaceGUID), nil, &space); err != nil {
+		return nil, err
+	}
+	var org struct {
+		Entity struct {
+			Name string `json:"name"`
+		} `json:"entity"`
+	}
+	if err := c.ccRequest("GET", "/v2/organizations/"+url.PathEscape(space.Entity.OrganizationGUID), nil, &org); err != nil {
+		return nil, err
+	}
+	return &routeOwner{
+		SpaceGUID: entity.SpaceGUID,
+		SpaceName: space.Entity.Name,
+		OrgGUID:   space.Entity.OrganizationGUID,
+		OrgName:   org.Entity.Name,
+	}, nil
+}
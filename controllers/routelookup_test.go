@@ -0,0 +1,104 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestRouteOwnership(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/domains":
+			if r.URL.Query().Get("q") != "name:apps.example.com" {
+				w.Write([]byte(`{"next_url": null, "resources": []}`))
+				return
+			}
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "domain-1"}, "entity": {"name": "apps.example.com"}}]}`))
+		case "/v2/routes/reserved/domain/domain-1/host/free":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		case "/v2/routes/reserved/domain/domain-1/host/www", "/v2/routes/reserved/domain/domain-1/host/hidden":
+			w.WriteHeader(http.StatusNoContent)
+		case "/v2/routes":
+			if r.URL.Query()["q"][0] != "host:www" {
+				w.Write([]byte(`{"next_url": null, "resources": []}`))
+				return
+			}
+			w.Write([]byte(`{"next_url": null, "resources": [
+				{"metadata": {"guid": "route-2"}, "entity": {"host": "www", "path": "/docs", "space_guid": "space-2"}},
+				{"metadata": {"guid": "route-1"}, "entity": {"host": "www", "path": "", "space_guid": "space-1"}}
+			]}`))
+		case "/v2/spaces/space-1":
+			w.Write([]byte(`{"metadata": {"guid": "space-1"}, "entity": {"name": "prod", "organization_guid": "org-1"}}`))
+		case "/v2/organizations/org-1":
+			w.Write([]byte(`{"metadata": {"guid": "org-1"}, "entity": {"name": "agency"}}`))
+		case "/v2/routes/route-1/apps":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "app-1"}, "entity": {"name": "web", "state": "STARTED"}}]}`))
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+
+	tests := []struct {
+		name             string
+		location         string
+		expectedCode     int
+		expectedResponse string
+	}{
+		{
+			name:             "Without Domain",
+			location:         "/routes/ownership?host=www",
+			expectedCode:     http.StatusBadRequest,
+			expectedResponse: `{"status": "failure", "data": "domain is required."}`,
+		},
+		{
+			name:             "Unknown Domain",
+			location:         "/routes/ownership?host=www&domain=other.example.com",
+			expectedCode:     http.StatusNotFound,
+			expectedResponse: `{"status": "failure", "data": "the domain other.example.com doesn't exist or is private to an org you're not a member of."}`,
+		},
+		{
+			name:         "Available",
+			location:     "/routes/ownership?host=free&domain=apps.example.com",
+			expectedCode: http.StatusOK,
+			expectedResponse: `{"host": "free", "domain": "apps.example.com", "reserved": false, "visible": false, "owner": null, "apps": [],
+				"explanation": "The route is available."}`,
+		},
+		{
+			name:         "Taken By Another Space",
+			location:     "/routes/ownership?host=hidden&domain=apps.example.com",
+			expectedCode: http.StatusOK,
+			expectedResponse: `{"host": "hidden", "domain": "apps.example.com", "reserved": true, "visible": false, "owner": null, "apps": [],
+				"explanation": "The route is taken by a space you're not a member of. Ask the owners to unmap and delete it, or contact support."}`,
+		},
+		{
+			name:         "Taken By A Visible Space",
+			location:     "/routes/ownership?host=WWW&domain=apps.example.com",
+			expectedCode: http.StatusOK,
+			expectedResponse: `{"host": "www", "domain": "apps.example.com", "reserved": true, "visible": true,
+				"owner": {"space_guid": "space-1", "space_name": "prod", "org_guid": "org-1", "org_name": "agency"},
+				"apps": [{"guid": "app-1", "name": "web", "state": "STARTED"}],
+				"explanation": "The route is taken by the prod space of the agency org and is mapped to its apps."}`,
+		},
+	}
+	for _, test := range tests {
+		response, request := NewTestRequest("GET", test.location, nil)
+		router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+		router.ServeHTTP(response, request)
+		if response.Code != test.expectedCode {
+			t.Errorf("Test %s: expected code %d. Found %d", test.name, test.expectedCode, response.Code)
+		}
+		expected := NewJSONResponseContentTester(test.expectedResponse)
+		if !expected.Check(t, response.Body.String()) {
+			t.Errorf("Test %s: expected %s. Found %s", test.name, expected.Display(), response.Body.String())
+		}
+	}
+}
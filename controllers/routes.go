@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"strings"
+
 	"github.com/cloudfoundry-community/go-cfenv"
 	"github.com/gocraft/web"
 	"github.com/govau/cf-common/env"
@@ -9,6 +11,44 @@ import (
 	"github.com/18F/cg-dashboard/mailer"
 )
 
+// sessionlessPaths are the paths (or path prefixes, when ending in "/") that
+// never need a user session. Requests to them skip the session and CSRF
+// handling so that health checks and static assets keep working even when
+// the session configuration is broken.
+var sessionlessPaths = []string{
+	"/ping",
+	"/ready",
+	"/healthz",
+	"/metrics",
+	"/api/config",
+	"/api/assets",
+	"/login",
+	"/logged-out",
+	"/assets/",
+}
+
+// IsSessionlessPath returns true if the given request path should be served
+// without session or CSRF handling.
+func IsSessionlessPath(path string) bool {
+	for _, p := range sessionlessPaths {
+		if strings.HasSuffix(p, "/") {
+			if strings.HasPrefix(path, p) {
+				return true
+			}
+		} else if path == p {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStreamPath returns true if the given request path is a long lived
+// stream, such as an app's log stream, which the request timeout doesn't
+// apply to.
+func IsStreamPath(path string) bool {
+	return strings.HasPrefix(path, "/v2/apps/") && strings.HasSuffix(path, "/logstream")
+}
+
 // InitRouter sets up the router (and subrouters).
 // It also includes the closure middleware where we load the global Settings reference into each request.
 func InitRouter(settings *helpers.Settings, templates *helpers.Templates, mailer mailer.Mailer) *web.Router {
@@ -16,6 +56,10 @@ func InitRouter(settings *helpers.Settings, templates *helpers.Templates, mailer
 		return nil
 	}
 	router := web.New(Context{})
+	router.Middleware(responseMetricsMiddleware)
+	if settings.Telemetry != nil {
+		router.Middleware(telemetryMiddleware(settings.Telemetry))
+	}
 
 	// A closure that effectively loads the Settings into every request.
 	router.Middleware(func(c *Context, resp web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
@@ -24,15 +68,36 @@ func InitRouter(settings *helpers.Settings, templates *helpers.Templates, mailer
 		c.mailer = mailer
 		next(resp, req)
 	})
+	router.Middleware((*Context).RequestIDMiddleware)
+	router.Middleware((*Context).SecurityHeadersMiddleware)
+	if settings.VerboseLogging {
+		router.Middleware((*Context).AccessLogMiddleware)
+	}
+	router.Middleware((*Context).MaintenanceMiddleware)
+	router.Middleware((*Context).SessionActivityMiddleware)
+	if store, ok := settings.Sessions.(*helpers.ServerSideStore); ok && store.MigrateCookies {
+		router.Middleware((*Context).SessionMigrationMiddleware)
+	}
+	router.NotFound((*Context).NotFound)
 
 	router.Get("/", (*Context).Index)
 
 	// Backend Route Initialization
 	// Initialize the Gocraft Router with the basic context and routes
 	router.Get("/ping", (*Context).Ping)
+	router.Get("/ready", (*Context).Ready)
+	router.Get("/healthz", (*Context).Healthz)
+	router.Get("/metrics", (*Context).Metrics)
+	router.Get("/api/config", (*Context).Config)
+	router.Get("/api/assets", (*Context).Assets)
 	router.Get("/handshake", (*Context).LoginHandshake)
 	router.Get("/oauth2callback", (*Context).OAuthCallback)
 	router.Get("/logout", (*Context).Logout)
+	router.Get("/login", (*Context).Login)
+	router.Get("/logged-out", (*Context).LoggedOut)
+	if settings.LocalCF {
+		router.Get("/dev-login", (*Context).DevLogin)
+	}
 
 	// Secure all the other routes
 	secureRouter := router.Subrouter(SecureContext{}, "/")
@@ -43,6 +108,7 @@ func InitRouter(settings *helpers.Settings, templates *helpers.Templates, mailer
 	// All routes accepted
 	apiRouter.Get("/authstatus", (*APIContext).AuthStatus)
 	apiRouter.Get("/profile", (*APIContext).UserProfile)
+	apiRouter.Get("/apps/:guid/logstream", (*APIContext).LogStream)
 	apiRouter.Get("/:*", (*APIContext).APIProxy)
 	apiRouter.Put("/:*", (*APIContext).APIProxy)
 	apiRouter.Post("/:*", (*APIContext).APIProxy)
@@ -54,42 +120,231 @@ func InitRouter(settings *helpers.Settings, templates *helpers.Templates, mailer
 	uaaRouter.Get("/userinfo", (*UAAContext).UserInfo)
 	uaaRouter.Get("/uaainfo", (*UAAContext).UaaInfo)
 	uaaRouter.Post("/invite/users", (*UAAContext).InviteUserToOrg)
+	uaaRouter.Post("/invite/bulk", (*UAAContext).BulkInviteUsers)
 
 	// Setup the /log subrouter.
 	logRouter := secureRouter.Subrouter(LogContext{}, "/log")
 	logRouter.Middleware((*LogContext).OAuth)
 	logRouter.Get("/recent", (*LogContext).RecentLogs)
 
+	// Setup the /jobs subrouter.
+	jobsRouter := secureRouter.Subrouter(JobsContext{}, "/jobs")
+	jobsRouter.Middleware((*JobsContext).OAuth)
+	jobsRouter.Get("/:id", (*JobsContext).GetJob)
+
+	// Setup the /stacks subrouter.
+	stackRouter := secureRouter.Subrouter(StackContext{}, "/stacks")
+	stackRouter.Middleware((*StackContext).OAuth)
+	stackRouter.Get("/migration", (*StackContext).MigrationReport)
+	stackRouter.Post("/migration", (*StackContext).Migrate)
+
+	// Setup the /routes subrouter.
+	routeRouter := secureRouter.Subrouter(RouteContext{}, "/routes")
+	routeRouter.Middleware((*RouteContext).OAuth)
+	routeRouter.Get("/ownership", (*RouteContext).Ownership)
+	routeRouter.Get("/:guid/destinations", (*RouteContext).Destinations)
+	routeRouter.Put("/:guid/destinations", (*RouteContext).UpdateDestinations)
+
+	// Setup the /api subrouter for the change feed.
+	changesRouter := secureRouter.Subrouter(ChangesContext{}, "/api")
+	changesRouter.Middleware((*ChangesContext).OAuth)
+	changesRouter.Get("/changes", (*ChangesContext).Changes)
+
+	// Setup the /api/me subrouter.
+	meRouter := secureRouter.Subrouter(MeContext{}, "/api/me")
+	meRouter.Middleware((*MeContext).OAuth)
+	meRouter.Get("/preferences", (*MeContext).Preferences)
+	meRouter.Put("/preferences", (*MeContext).UpdatePreferences)
+	meRouter.Get("/activity", (*MeContext).Activity)
+	meRouter.Get("/session", (*MeContext).Session)
+	meRouter.Post("/downloads", (*MeContext).CreateDownloadURL)
+	meRouter.Post("/ssh_code", (*MeContext).SSHCode)
+	meRouter.Post("/secrets/:id", (*MeContext).RetrieveSecret)
+
+	// Setup the /api/apps subrouter.
+	appRouter := secureRouter.Subrouter(AppContext{}, "/api/apps")
+	appRouter.Middleware((*AppContext).OAuth)
+	appRouter.Get("/:guid/diagnostics", (*AppContext).Diagnostics)
+
+	// Setup the /api/orgs subrouter.
+	orgRouter := secureRouter.Subrouter(OrgContext{}, "/api/orgs")
+	orgRouter.Middleware((*OrgContext).OAuth)
+	orgRouter.Get("/:org_guid/activity", (*OrgContext).Activity)
+	orgRouter.Get("/:org_guid/provenance", (*OrgContext).Provenance)
+
+	// Setup the /api/spaces subrouter.
+	spaceRouter := secureRouter.Subrouter(SpaceContext{}, "/api/spaces")
+	spaceRouter.Middleware((*SpaceContext).OAuth)
+	spaceRouter.Get("/:guid/topology", (*SpaceContext).Topology)
+
+	// Setup the /api/service_instances subrouter.
+	serviceInstanceRouter := secureRouter.Subrouter(ServiceInstanceContext{}, "/api/service_instances")
+	serviceInstanceRouter.Middleware((*ServiceInstanceContext).OAuth)
+	serviceInstanceRouter.Get("/:guid/shared_spaces", (*ServiceInstanceContext).SharedSpaces)
+	serviceInstanceRouter.Post("/:guid/shared_spaces", (*ServiceInstanceContext).Share)
+	serviceInstanceRouter.Delete("/:guid/shared_spaces/:space_guid", (*ServiceInstanceContext).Unshare)
+	serviceInstanceRouter.Post("/:guid/service_keys", (*ServiceInstanceContext).CreateServiceKey)
+
+	// Setup the /api/role_requests subrouter.
+	roleRequestRouter := secureRouter.Subrouter(RoleRequestContext{}, "/api/role_requests")
+	roleRequestRouter.Middleware((*RoleRequestContext).OAuth)
+	roleRequestRouter.Get("/", (*RoleRequestContext).List)
+	roleRequestRouter.Post("/", (*RoleRequestContext).Create)
+	roleRequestRouter.Post("/:id/approve", (*RoleRequestContext).Approve)
+	roleRequestRouter.Post("/:id/deny", (*RoleRequestContext).Deny)
+
+	// Setup the /api/role_grants subrouter for org managers granting roles
+	// for a limited time.
+	roleGrantRouter := secureRouter.Subrouter(RoleGrantContext{}, "/api/role_grants")
+	roleGrantRouter.Middleware((*RoleGrantContext).OAuth)
+	roleGrantRouter.Get("/", (*RoleGrantContext).List)
+	roleGrantRouter.Post("/", (*RoleGrantContext).Create)
+	roleGrantRouter.Post("/:id/revoke", (*RoleGrantContext).Revoke)
+
+	// Setup the /api/access_reviews subrouter for org managers reviewing
+	// their orgs' members.
+	accessReviewRouter := secureRouter.Subrouter(AccessReviewContext{}, "/api/access_reviews")
+	accessReviewRouter.Middleware((*AccessReviewContext).OAuth)
+	accessReviewRouter.Get("/", (*AccessReviewContext).List)
+	accessReviewRouter.Get("/:id", (*AccessReviewContext).Get)
+	accessReviewRouter.Get("/:id/report", (*AccessReviewContext).Report)
+	accessReviewRouter.Post("/:id/decisions", (*AccessReviewContext).Decide)
+
+	// Setup the /api/changelog subrouter for the release notes.
+	changelogRouter := secureRouter.Subrouter(ChangelogContext{}, "/api/changelog")
+	changelogRouter.Middleware((*ChangelogContext).OAuth)
+	changelogRouter.Get("/", (*ChangelogContext).List)
+	changelogRouter.Put("/seen", (*ChangelogContext).MarkSeen)
+
+	// Setup the /api/webhooks subrouter.
+	webhookRouter := secureRouter.Subrouter(WebhookContext{}, "/api/webhooks")
+	webhookRouter.Middleware((*WebhookContext).OAuth)
+	webhookRouter.Get("/", (*WebhookContext).List)
+	webhookRouter.Post("/", (*WebhookContext).Create)
+	webhookRouter.Delete("/:id", (*WebhookContext).Delete)
+	webhookRouter.Get("/:id/deliveries", (*WebhookContext).Deliveries)
+
+	// Setup the /changesets subrouter.
+	changeSetRouter := secureRouter.Subrouter(ChangeSetContext{}, "/changesets")
+	changeSetRouter.Middleware((*ChangeSetContext).OAuth)
+	changeSetRouter.Post("/apply", (*ChangeSetContext).Apply)
+	changeSetRouter.Post("/:kind/plan", (*ChangeSetContext).Plan)
+
+	// Setup the /admin subrouter for platform admins.
+	adminRouter := secureRouter.Subrouter(AdminContext{}, "/admin")
+	adminRouter.Middleware((*AdminContext).OAuth)
+	adminRouter.Middleware(scopesRequired(adminScope))
+	adminRouter.Get("/buildpacks/impact", (*AdminContext).BuildpackImpact)
+	adminRouter.Get("/logins", (*AdminContext).LoginReport)
+	adminRouter.Get("/audit_log", (*AdminContext).AdminAuditLog)
+	adminRouter.Get("/proxy_quotas", (*AdminContext).ProxyQuotaUsage)
+	adminRouter.Post("/broadcast/preview", (*AdminContext).PreviewBroadcast)
+	adminRouter.Post("/broadcast", (*AdminContext).SendBroadcast)
+	adminRouter.Get("/content", (*AdminContext).Content)
+	adminRouter.Put("/content", (*AdminContext).UpdateContent)
+	adminRouter.Put("/changelog/:version", (*AdminContext).SaveChangelogEntry)
+	adminRouter.Delete("/changelog/:version", (*AdminContext).DeleteChangelogEntry)
+	adminRouter.Get("/shared_domains", (*AdminContext).SharedDomains)
+	adminRouter.Post("/shared_domains", (*AdminContext).CreateSharedDomain)
+	adminRouter.Delete("/shared_domains/:guid", (*AdminContext).DeleteSharedDomain)
+	adminRouter.Post("/restarts", (*AdminContext).ScheduleRestarts)
+	adminRouter.Get("/pending_changes", (*AdminContext).PendingChanges)
+	adminRouter.Post("/access_reviews", (*AdminContext).StartAccessReviewCampaign)
+	adminRouter.Get("/access_reviews/report", (*AdminContext).AccessReviewReport)
+	adminRouter.Post("/pending_changes/:id/approve", (*AdminContext).ApprovePendingChange)
+	adminRouter.Post("/pending_changes/:id/reject", (*AdminContext).RejectPendingChange)
+	adminRouter.Get("/export", (*AdminContext).ExportData)
+	adminRouter.Post("/import", (*AdminContext).ImportData)
+	adminRouter.Get("/log_levels", (*AdminContext).LogLevels)
+	adminRouter.Put("/log_levels", (*AdminContext).UpdateLogLevels)
+	adminRouter.Get("/traces", (*AdminContext).Traces)
+	adminRouter.Post("/traces", (*AdminContext).StartTrace)
+	adminRouter.Get("/traces/:user_id", (*AdminContext).Trace)
+	adminRouter.Delete("/traces/:user_id", (*AdminContext).StopTrace)
+
+	// Setup the admin-only /api subrouter.
+	adminAPIRouter := secureRouter.Subrouter(AdminContext{}, "/api")
+	adminAPIRouter.Middleware((*AdminContext).OAuth)
+	adminAPIRouter.Middleware(scopesRequired(adminScope))
+	adminAPIRouter.Get("/startup-report", (*AdminContext).StartupReport)
+
+	// Setup the /api/platform subrouter for the platform health widget.
+	platformAPIRouter := secureRouter.Subrouter(PlatformContext{}, "/api/platform")
+	platformAPIRouter.Middleware((*PlatformContext).OAuth)
+	platformAPIRouter.Get("/health", (*PlatformContext).Health)
+
+	// Setup the /platform subrouter for platform operators.
+	if settings.LogCacheURL != "" {
+		platformRouter := secureRouter.Subrouter(PlatformContext{}, "/platform")
+		platformRouter.Middleware((*PlatformContext).OAuth)
+		platformRouter.Middleware(scopesRequired(helpers.FirehoseScope))
+		platformRouter.Get("/metrics", (*PlatformContext).ListMetrics)
+		platformRouter.Get("/metrics/:metric", (*PlatformContext).Metric)
+
+		// The capacity report is for platform admins too.
+		capacityRouter := secureRouter.Subrouter(PlatformContext{}, "/platform")
+		capacityRouter.Middleware((*PlatformContext).OAuth)
+		capacityRouter.Middleware(scopesRequired(helpers.FirehoseScope, adminScope))
+		capacityRouter.Get("/capacity", (*PlatformContext).CapacityReport)
+	}
+
 	// Add auth middleware
 	secureRouter.Middleware((*SecureContext).LoginRequired)
+	secureRouter.Middleware((*SecureContext).TraceMiddleware)
 
 	// Frontend Route Initialization
 	// Set up static file serving to load from the static folder.
-	router.Middleware(StaticMiddleware("static"))
+	router.Middleware(StaticMiddleware("static", settings.Assets))
 
 	return router
 }
 
 // InitApp takes in envars and sets up the router and settings that will be used for the unstarted server.
 func InitApp(envVars *env.VarSet, app *cfenv.App) (*web.Router, *helpers.Settings, error) {
+	return InitAppReporting(envVars, app, nil)
+}
+
+// InitAppReporting is InitApp timing each step in the startup report, which
+// is then served to admins.
+func InitAppReporting(envVars *env.VarSet, app *cfenv.App, report *helpers.StartupReport) (*web.Router, *helpers.Settings, error) {
 	// Initialize the settings.
-	settings := helpers.Settings{}
-	if err := settings.InitSettings(envVars, app); err != nil {
+	settings := helpers.Settings{Startup: report}
+	if err := report.Step("settings", func() error {
+		return settings.InitSettings(envVars, app)
+	}); err != nil {
 		return nil, nil, err
 	}
-	mailer, err := mailer.InitSMTPMailer(settings)
-	if err != nil {
+	var smtpMailer mailer.Mailer
+	if err := report.Step("mailer", func() (err error) {
+		smtpMailer, err = mailer.InitMailer(settings)
+		return err
+	}); err != nil {
 		return nil, nil, err
 	}
+	if settings.MailQueue != nil {
+		smtpMailer = mailer.NewQueue(smtpMailer, *settings.MailQueue)
+	}
 
 	// Cache templates
-	templates, err := helpers.InitTemplates(settings.TemplatesPath)
-	if err != nil {
+	var templates *helpers.Templates
+	if err := report.Step("templates", func() (err error) {
+		templates, err = helpers.InitTemplates(settings.TemplatesPath, settings.EmailTemplatesPath)
+		return err
+	}); err != nil {
 		return nil, nil, err
 	}
+	templates.Assets = settings.Assets
+	if settings.OrgMailer != nil {
+		settings.OrgMailer.Mailer = smtpMailer
+		settings.OrgMailer.Templates = templates
+	}
 
 	// Initialize the router
-	router := InitRouter(&settings, templates, mailer)
+	var router *web.Router
+	report.Step("router", func() error {
+		router = InitRouter(&settings, templates, smtpMailer)
+		return nil
+	})
 
 	return router, &settings, nil
 }
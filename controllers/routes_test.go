@@ -81,3 +81,24 @@ func TestInitRouter(t *testing.T) {
 		}
 	}
 }
+
+var sessionlessPathTests = []struct {
+	path string
+	want bool
+}{
+	{"/ping", true},
+	{"/metrics", true},
+	{"/assets/bundle.js", true},
+	{"/assets", false},
+	{"/pingpong", false},
+	{"/v2/authstatus", false},
+	{"/", false},
+}
+
+func TestIsSessionlessPath(t *testing.T) {
+	for _, test := range sessionlessPathTests {
+		if got := controllers.IsSessionlessPath(test.path); got != test.want {
+			t.Errorf("IsSessionlessPath(%q) = %t, want %t", test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/gocraft/web"
+)
+
+// scopesRequired returns a middleware for the subrouters whose routes need
+// the scopes. It goes after the subrouter's OAuth middleware, and turns away
+// users without the scopes before the handler runs, instead of relying on the
+// CF API to reject what the handler asks of it.
+func scopesRequired(scopes ...string) func(interface{}, web.ResponseWriter, *web.Request, web.NextMiddlewareFunc) {
+	return func(ctx interface{}, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		// Every subrouter's context embeds the SecureContext.
+		if !ctx.(interface {
+			checkScopes(web.ResponseWriter, []string) bool
+		}).checkScopes(rw, scopes) {
+			return
+		}
+		next(rw, req)
+	}
+}
+
+// scopes returns the scopes of the user's access token: its claims for a
+// JWT, or what UAA's token introspection says for an opaque token. Opaque
+// tokens have no scopes the dashboard can read without introspection.
+func (c *SecureContext) scopes() ([]string, error) {
+	if claims, err := helpers.ParseTokenClaims(c.Token.AccessToken); err == nil {
+		return claims.Scope, nil
+	}
+	if c.Settings.TokenIntrospector == nil {
+		return nil, nil
+	}
+	return c.Settings.TokenIntrospector.Scopes(c.Token.AccessToken)
+}
+
+// checkScopes responds with a 403 and returns false if the user's token
+// lacks any of the required scopes.
+func (c *SecureContext) checkScopes(rw web.ResponseWriter, required []string) bool {
+	scopes, err := c.scopes()
+	if err != nil {
+		c.logger(proxyLog).Errorf("unable to read the scopes of the token: %v", err)
+		newUaaError(http.StatusBadGateway, "unable to read the scopes of the token.").writeTo(rw)
+		return false
+	}
+	granted := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		granted[scope] = true
+	}
+	var missing []string
+	for _, scope := range required {
+		if !granted[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		c.forbidden(rw, missing...)
+		return false
+	}
+	return true
+}
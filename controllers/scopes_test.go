@@ -0,0 +1,57 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestRouteScopesOfOpaqueTokens(t *testing.T) {
+	scopes := `["openid", "cloud_controller.read"]`
+	uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/introspect" {
+			t.Errorf("Unexpected UAA path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"active": true, "scope": ` + scopes + `}`))
+	}))
+	defer uaa.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.UAAURLEnvVar] = uaa.URL
+	envVars[helpers.OpaqueAccessTokensEnvVar] = "true"
+	envVars[helpers.LogCacheURLEnvVar] = "https://log-cache"
+
+	// The capacity report needs both the platform and the admin scope.
+	response, request := NewTestRequest("GET", "/platform/capacity", nil)
+	router, _ := CreateRouterWithMockSession(ValidTokenData, envVars)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected code %d. Found %d", http.StatusForbidden, response.Code)
+	}
+	expected := NewJSONResponseContentTester(`{"status": "forbidden",
+		"error_description": "This needs the doppler.firehose, cloud_controller.admin scope.",
+		"required_scope": "doppler.firehose", "required_scopes": ["doppler.firehose", "cloud_controller.admin"]}`)
+	if !expected.Check(t, response.Body.String()) {
+		t.Errorf("Expected %s. Found %s", expected.Display(), response.Body.String())
+	}
+
+	// Subrouters without required scopes let anyone logged in through.
+	response, request = NewTestRequest("GET", "/api/changelog/", nil)
+	router, _ = CreateRouterWithMockSession(ValidTokenData, envVars)
+	router.ServeHTTP(response, request)
+	if response.Code == http.StatusForbidden {
+		t.Errorf("Expected the changelog to be allowed. Found %s", response.Body.String())
+	}
+
+	// Introspected scopes let admins through.
+	scopes = `["openid", "cloud_controller.admin"]`
+	response, request = NewTestRequest("GET", "/api/startup-report", nil)
+	router, _ = CreateRouterWithMockSession(ValidTokenData, envVars)
+	router.ServeHTTP(response, request)
+	if response.Code == http.StatusForbidden {
+		t.Errorf("Expected admins to be allowed. Found %s", response.Body.String())
+	}
+}
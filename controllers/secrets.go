@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+// The kinds of secrets users retrieve once.
+const (
+	secretServiceKey = "service_key"
+	secretSSHCode    = "ssh_code"
+)
+
+// secretHandle is returned instead of a secret: the token the secret can be
+// retrieved with, exactly once.
+type secretHandle struct {
+	Kind           string    `json:"kind"`
+	RetrievalToken string    `json:"retrieval_token"`
+	RetrieveURL    string    `json:"retrieve_url"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// keepSecret keeps the JSON encoded secret for the user to retrieve once,
+// and returns the handle to retrieve it with.
+func (c *SecureContext) keepSecret(kind string, value []byte) (secretHandle, error) {
+	secret, err := c.Settings.Secrets.CreateSecret(db.Secret{Kind: kind, Owner: c.userID(), Value: value})
+	if err != nil {
+		return secretHandle{}, err
+	}
+	return secretHandle{
+		Kind:           kind,
+		RetrievalToken: secret.ID,
+		RetrieveURL:    c.Settings.AppURL + "/api/me/secrets/" + secret.ID,
+		ExpiresAt:      secret.ExpiresAt,
+	}, nil
+}
+
+// RetrieveSecret returns one of the user's secrets and drops it, so it can
+// only be retrieved once. It's a POST so that browsers never prefetch or
+// cache it.
+func (c *MeContext) RetrieveSecret(rw web.ResponseWriter, req *web.Request) {
+	secret, err := c.Settings.Secrets.TakeSecret(req.PathParams["id"], c.userID())
+	if err == db.ErrSecretNotFound {
+		newUaaError(http.StatusNotFound, err.Error()).writeTo(rw)
+		return
+	}
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "retrieve_secret", struct {
+		Kind string `json:"kind"`
+	}{secret.Kind})
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(struct {
+		Kind   string          `json:"kind"`
+		Secret json.RawMessage `json:"secret"`
+	}{secret.Kind, secret.Value})
+}
+
+// CreateServiceKey creates a key of the service instance. Its credentials
+// aren't in the response, which the browser may keep, but are retrieved once
+// with the returned handle.
+func (c *ServiceInstanceContext) CreateServiceKey(rw web.ResponseWriter, req *web.Request) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := readBodyToStruct(req.Body, &body); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	if body.Name == "" {
+		newUaaError(http.StatusBadRequest, "name is required.").writeTo(rw)
+		return
+	}
+	guid := req.PathParams["guid"]
+	var key struct {
+		Metadata struct {
+			GUID string `json:"guid"`
+		} `json:"metadata"`
+		Entity struct {
+			Name        string          `json:"name"`
+			Credentials json.RawMessage `json:"credentials"`
+		} `json:"entity"`
+	}
+	if err := c.ccRequest("POST", "/v2/service_keys", struct {
+		ServiceInstanceGUID string `json:"service_instance_guid"`
+		Name                string `json:"name"`
+	}{guid, body.Name}, &key); err != nil {
+		newUaaError(ccErrorStatus(err), err.Error()).writeTo(rw)
+		return
+	}
+	handle, err := c.keepSecret(secretServiceKey, key.Entity.Credentials)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "create_service_key", struct {
+		ServiceInstanceGUID string `json:"service_instance_guid"`
+		ServiceKeyGUID      string `json:"service_key_guid"`
+	}{guid, key.Metadata.GUID})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(struct {
+		GUID   string       `json:"guid"`
+		Name   string       `json:"name"`
+		Secret secretHandle `json:"secret"`
+	}{key.Metadata.GUID, key.Entity.Name, handle})
+}
+
+// SSHCode gets a one-time code to cf ssh into the user's apps with, and
+// returns the handle to retrieve it once.
+func (c *MeContext) SSHCode(rw web.ResponseWriter, req *web.Request) {
+	var info struct {
+		SSHOAuthClient string `json:"app_ssh_oauth_client"`
+	}
+	if err := c.ccRequest("GET", "/v2/info", nil, &info); err != nil {
+		newUaaError(ccErrorStatus(err), err.Error()).writeTo(rw)
+		return
+	}
+	code, err := c.sshCode(info.SSHOAuthClient)
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	value, _ := json.Marshal(code)
+	handle, err := c.keepSecret(secretSSHCode, value)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(handle)
+}
+
+// sshCode asks UAA for an authorization code of the SSH proxy's client, as
+// cf ssh-code does. UAA returns it in the redirect, which isn't followed.
+func (c *SecureContext) sshCode(clientID string) (string, error) {
+	client := *c.Settings.HTTPClient
+	client.Timeout = 20 * time.Second
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	query := url.Values{"response_type": {"code"}, "client_id": {clientID}}
+	req, _ := http.NewRequest("GET", c.Settings.UaaURL+"/oauth/authorize?"+query.Encode(), nil)
+	c.Token.SetAuthHeader(req)
+	c.forwardRequestID(req.Header)
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	location, err := res.Location()
+	if err != nil || location.Query().Get("code") == "" {
+		return "", fmt.Errorf("UAA returned no SSH code (status %d)", res.StatusCode)
+	}
+	return location.Query().Get("code"), nil
+}
@@ -0,0 +1,60 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestCreateServiceKeyRetrievedOnce(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method+" "+r.URL.Path != "POST /v2/service_keys" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"metadata": {"guid": "key-1"},
+			"entity": {"name": "ci", "credentials": {"username": "u", "password": "p"}}}`))
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	response, request := NewTestRequest("POST", "/api/service_instances/si-1/service_keys", []byte(`{"name": "ci"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusCreated, response.Code, response.Body.String())
+	}
+	if strings.Contains(response.Body.String(), "password") {
+		t.Errorf("Expected the credentials not to be in the response. Found %s", response.Body.String())
+	}
+	var key struct {
+		GUID   string `json:"guid"`
+		Secret struct {
+			RetrievalToken string `json:"retrieval_token"`
+		} `json:"secret"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &key)
+	if key.GUID != "key-1" || key.Secret.RetrievalToken == "" {
+		t.Fatalf("Expected the key and a retrieval token. Found %s", response.Body.String())
+	}
+
+	response, request = NewTestRequest("POST", "/api/me/secrets/"+key.Secret.RetrievalToken, nil)
+	router.ServeHTTP(response, request)
+	expected := `{"kind":"service_key","secret":{"username":"u","password":"p"}}`
+	if response.Code != http.StatusOK || strings.TrimSpace(response.Body.String()) != expected {
+		t.Errorf("Expected the credentials. Found %d: %s", response.Code, response.Body.String())
+	}
+
+	response, request = NewTestRequest("POST", "/api/me/secrets/"+key.Secret.RetrievalToken, nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected the credentials to be retrieved only once. Found %d: %s", response.Code, response.Body.String())
+	}
+}
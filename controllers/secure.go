@@ -1,18 +1,29 @@
 package controllers
 
 import (
+	"bytes"
+	"encoding/json"
 	"io"
-	"log"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/logging"
 	"github.com/gocraft/web"
 	"golang.org/x/oauth2"
 )
 
+var proxyLog = logging.New("proxy")
+
+// maxRetriedBodyBytes is the largest request body kept to retry the request.
+// Larger requests are streamed upstream and not retried.
+const maxRetriedBodyBytes = 1 << 20
+
 // SecureContext stores the session info and access token per user.
 type SecureContext struct {
 	*Context // Required.
@@ -29,9 +40,12 @@ func (c *SecureContext) OAuth(rw web.ResponseWriter, req *web.Request, next web.
 	// Get valid token if it exists from session store.
 	if token := helpers.GetValidToken(req.Request, rw, c.Settings); token != nil {
 		c.Token = *token
+		if userID := c.userID(); userID != "" {
+			c.logFields = append(c.logFields, "user_id", userID)
+		}
 	} else {
 		// If no token, return unauthorized.
-		http.Error(rw, "{\"status\": \"unauthorized\"}", http.StatusUnauthorized)
+		c.unauthorized(rw, req.Request)
 		return
 	}
 	// Proceed to the next middleware or to the handler if last middleware.
@@ -59,8 +73,99 @@ func (c *SecureContext) LoginRequired(rw web.ResponseWriter, r *web.Request, nex
 	} else {
 		// Respond with Unauthorized, the client should detect this,
 		// show appropriate messaging or redirect to login
-		rw.WriteHeader(http.StatusUnauthorized)
+		c.unauthorized(rw, r.Request)
+	}
+}
+
+// unauthorizedResponse is the body sent to API clients whose session or
+// token is missing or expired.
+type unauthorizedResponse struct {
+	Status   string `json:"status"`
+	LoginURL string `json:"login_url"`
+}
+
+// isNavigation returns true if the request looks like a top-level browser
+// navigation rather than an API call made by the frontend.
+func isNavigation(req *http.Request) bool {
+	return req.Header.Get("X-Requested-With") == "" &&
+		strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+// unauthorized responds to a request without a valid token. Top-level
+// navigations are redirected to the login handshake. Everything else gets a
+// 401 with a JSON body containing the login URL, since the frontend's fetch
+// calls would otherwise follow the redirect and mangle the response.
+func (c *SecureContext) unauthorized(rw http.ResponseWriter, req *http.Request) {
+	loginURL := c.Settings.AppURL + "/handshake"
+	if open := c.Settings.UAABreaker.OpenError(); open != nil && !isNavigation(req) {
+		// The token couldn't be refreshed because UAA is down, which logging
+		// in again won't fix.
+		upstreamUnavailable(rw, open)
+		return
+	}
+	if isNavigation(req) {
+		// Come back to the page after the login.
+		http.Redirect(rw, req, loginURL+"?"+nextParam+"="+url.QueryEscape(req.URL.RequestURI()), http.StatusFound)
+		return
 	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(rw).Encode(unauthorizedResponse{
+		Status:   "unauthorized",
+		LoginURL: loginURL,
+	})
+}
+
+// upstreamUnavailable responds to a request that failed fast because the
+// circuit of an upstream is open.
+func upstreamUnavailable(rw http.ResponseWriter, open *helpers.CircuitOpenError) {
+	retryAfter := int((open.RetryAfter + time.Second - 1) / time.Second)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	rw.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(rw).Encode(struct {
+		Status      string `json:"status"`
+		Description string `json:"error_description"`
+		Upstream    string `json:"upstream"`
+		RetryAfter  int    `json:"retry_after"`
+	}{
+		Status:      "upstream_unavailable",
+		Description: "The " + open.Upstream + " upstream is unavailable. Try again later.",
+		Upstream:    open.Upstream,
+		RetryAfter:  retryAfter,
+	})
+}
+
+// hasScope returns true if the user's access token has the given scope.
+func (c *SecureContext) hasScope(scope string) bool {
+	scopes, err := c.scopes()
+	if err != nil {
+		return false
+	}
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// forbidden responds to a request from a user without the scopes required
+// for the route. required_scope is the first of them, for older clients.
+func (c *SecureContext) forbidden(rw http.ResponseWriter, scopes ...string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(rw).Encode(struct {
+		Status         string   `json:"status"`
+		Description    string   `json:"error_description"`
+		RequiredScope  string   `json:"required_scope"`
+		RequiredScopes []string `json:"required_scopes"`
+	}{
+		Status:         "forbidden",
+		Description:    "This needs the " + strings.Join(scopes, ", ") + " scope.",
+		RequiredScope:  scopes[0],
+		RequiredScopes: scopes,
+	})
 }
 
 // PrivilegedProxy is an internal function that will construct the client using
@@ -93,58 +198,151 @@ func (c *SecureContext) submitRequest(rw http.ResponseWriter, req *http.Request,
 		defer req.Body.Close()
 	}
 	req.Close = true
-	// Make a new request.
-	request, _ := http.NewRequest(req.Method, url, req.Body)
-	// In case the body is not of io.Closer.
-	if request.Body != nil {
-		defer request.Body.Close()
+	upstream := "other"
+	switch {
+	case strings.HasPrefix(url, c.Settings.ConsoleAPI):
+		upstream = "cf_api"
+	case strings.HasPrefix(url, c.Settings.UaaURL):
+		upstream = "uaa"
+	}
+	// Only CF API requests are retried.
+	var policy *helpers.RetryPolicy
+	if upstream == "cf_api" {
+		policy = c.Settings.RetryPolicy
 	}
-	// We need to transfer over the headers we want manually.
-	// The UAA checks for it and will fail with a 415 Response Code if it is
-	// missing during a POST request. (The CF API does not have this requirement).
-	if contentHeader := req.Header.Get("Content-Type"); len(contentHeader) > 0 {
-		request.Header.Set("Content-Type", contentHeader)
+	var (
+		body   []byte
+		stream io.Reader = req.Body
+	)
+	if req.Body != nil && policy.Retries(req.Method) {
+		// Keep the body to send it again, unless it's too large to hold.
+		var err error
+		if body, err = ioutil.ReadAll(io.LimitReader(req.Body, maxRetriedBodyBytes+1)); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			rw.Write([]byte("unable to read the request body"))
+			return
+		}
+		if len(body) > maxRetriedBodyBytes {
+			stream = io.MultiReader(bytes.NewReader(body), req.Body)
+			body = nil
+			policy = nil
+		}
 	}
 
 	// Get RemoteAddr from the request
+	var clientIP string
 	if c.Settings.TICSecret != "" {
-		clientIP, err := GetClientIP(req)
+		var err error
+		clientIP, err = GetClientIP(req)
 		if err != nil {
-			log.Println(err)
+			c.logger(proxyLog).Errorf("unable to parse the client IP: %v", err)
 			rw.WriteHeader(http.StatusInternalServerError)
 			rw.Write([]byte("error parsing client ip"))
 		}
+	}
+
+	newRequest := func() *http.Request {
+		reqBody := stream
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+		// Make a new request.
+		request, _ := http.NewRequest(req.Method, url, reqBody)
+		// We need to transfer over the headers we want manually.
+		// The UAA checks for it and will fail with a 415 Response Code if it is
+		// missing during a POST request. (The CF API does not have this requirement).
+		if contentHeader := req.Header.Get("Content-Type"); len(contentHeader) > 0 {
+			request.Header.Set("Content-Type", contentHeader)
+		}
+		c.forwardRequestID(request.Header)
 		if clientIP != "" {
 			// Set headers for requests to CF API proxy
 			request.Header.Add("X-Client-IP", clientIP)
 			request.Header.Add("X-TIC-Secret", c.Settings.TICSecret)
 		}
+		request.Close = true
+		return request
 	}
 
-	request.Close = true
-	// Send the request.
-	res, err := client.Do(request)
+	// Send the request, retrying transient failures.
+	if policy != nil {
+		policy.Budget.Deposit()
+	}
+	start := time.Now()
+	var (
+		request *http.Request
+		res     *http.Response
+		err     error
+	)
+	for attempt := 1; ; attempt++ {
+		request = newRequest()
+		attemptStart := time.Now()
+		end := c.annotations.StartUpstream(upstream)
+		res, err = client.Do(request)
+		end()
+		status := 0
+		if err == nil {
+			status = res.StatusCode
+		}
+		helpers.ObserveProxiedRequest(upstream, request.Method, status, time.Since(attemptStart))
+		// Retrying an open circuit would only fail fast again.
+		if helpers.AsCircuitOpen(err) != nil || !policy.ShouldRetry(upstream, request.Method, attempt, status) {
+			break
+		}
+		logger := c.logger(proxyLog)
+		if res != nil {
+			if upstreamID := res.Header.Get(vcapRequestIDHeader); upstreamID != "" {
+				logger = logger.With("cf_request_id", upstreamID)
+			}
+			res.Body.Close()
+		}
+		logger.Warnf("retrying %s %s after attempt %d failed with status %d",
+			request.Method, request.URL.Path, attempt, status)
+		time.Sleep(policy.Backoff(attempt))
+	}
+	helpers.ObserveUpstreamRequest(time.Since(start))
+	if request.Body != nil {
+		defer request.Body.Close()
+	}
+	if err == nil {
+		c.logger(proxyLog).Debugf("%s %s returned %d in %v",
+			request.Method, request.URL.Path, res.StatusCode, time.Since(start))
+	}
 	if res != nil {
 		defer res.Body.Close()
 	}
+	if open := helpers.AsCircuitOpen(err); open != nil {
+		c.logger(proxyLog).Warnf("%s %s failed fast: %v", request.Method, request.URL.Path, open)
+		upstreamUnavailable(rw, open)
+		return
+	}
 	if err != nil {
-		log.Println(err)
+		c.logger(proxyLog).Errorf("%s %s failed: %v", request.Method, request.URL.Path, err)
 		rw.WriteHeader(http.StatusInternalServerError)
-		rw.Write([]byte("unknown error. try again"))
+		rw.Write([]byte("unknown error. try again (request ID " + c.requestID + ")"))
 		return
 	}
+	// Pass on the upstream's ID of the request, so failures can be traced
+	// through the CF API's logs.
+	if upstreamID := res.Header.Get(vcapRequestIDHeader); upstreamID != "" {
+		rw.Header().Set(cfRequestIDHeader, upstreamID)
+		if res.StatusCode >= 400 {
+			c.logger(proxyLog).With("cf_request_id", upstreamID).Warnf("%s %s failed with status %d",
+				request.Method, request.URL.Path, res.StatusCode)
+		}
+	}
 	responseHandler(rw, res)
 }
 
 // GenericResponseHandler is a normal handler for responses received from the proxy requests.
 func (c *SecureContext) GenericResponseHandler(rw http.ResponseWriter, response *http.Response) {
-	// Should return the same status.
-	rw.WriteHeader(response.StatusCode)
-
 	// Write the body into response that is going back to the frontend.
-	_, err := io.Copy(rw, response.Body)
+	err := c.copyResponse(rw, response)
+	if err == errResponseTooLarge {
+		return
+	}
 	if err != nil {
-		log.Println(err)
+		c.logger(proxyLog).Errorf("unable to copy the response of %s: %v", response.Request.URL.Path, err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		rw.Write([]byte("unknown error. try again"))
 		return
@@ -1,7 +1,9 @@
 package controllers_test
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -29,13 +31,14 @@ var oauthTests = []BasicSecureTest{
 			TestName:    "Basic Invalid OAuth Session",
 			SessionData: InvalidTokenData,
 		},
-		ExpectedResponse: NewJSONResponseContentTester(`{"status": "unauthorized"}`),
+		ExpectedResponse: NewJSONResponseContentTester(`{"status": "unauthorized", "login_url": "http://hostname.com/handshake"}`),
 		ExpectedCode:     401,
 	},
 }
 
 func TestOAuth(t *testing.T) {
 	mockSettings := helpers.Settings{}
+	mockSettings.AppURL = "http://hostname.com"
 	mockSettings.OAuthConfig = &oauth2.Config{
 		ClientID:     "ClientID",
 		ClientSecret: "ClientSecret",
@@ -77,6 +80,54 @@ func TestOAuth(t *testing.T) {
 	}
 }
 
+var loginRequiredTests = []struct {
+	BasicSecureTest
+	accept string
+}{
+	{
+		BasicSecureTest: BasicSecureTest{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "Expired Session API Request",
+				EnvVars:     GetMockCompleteEnvVars(),
+				SessionData: InvalidTokenData,
+			},
+			ExpectedResponse: NewJSONResponseContentTester(`{"status": "unauthorized", "login_url": "https://hostname/handshake"}`),
+			ExpectedCode:     http.StatusUnauthorized,
+		},
+		accept: "application/json",
+	},
+	{
+		BasicSecureTest: BasicSecureTest{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "Expired Session Top-Level Navigation",
+				EnvVars:     GetMockCompleteEnvVars(),
+				SessionData: InvalidTokenData,
+			},
+			ExpectedCode:     http.StatusFound,
+			ExpectedLocation: "https://hostname/handshake?next=%2Fv2%2Fauthstatus",
+		},
+		accept: "text/html,application/xhtml+xml",
+	},
+}
+
+func TestLoginRequired(t *testing.T) {
+	for _, test := range loginRequiredTests {
+		response, request := NewTestRequest("GET", "/v2/authstatus", nil)
+		request.Header.Set("Accept", test.accept)
+		router, _ := CreateRouterWithMockSession(test.SessionData, test.EnvVars)
+		router.ServeHTTP(response, request)
+		if response.Code != test.ExpectedCode {
+			t.Errorf("Test %s did not meet expected code.\nExpected %d.\nFound %d.\n", test.TestName, test.ExpectedCode, response.Code)
+		}
+		if test.ExpectedResponse != nil && !test.ExpectedResponse.Check(t, response.Body.String()) {
+			t.Errorf("Test %s did not contain expected value.\nExpected %s.\n Found (%s)\n.", test.TestName, test.ExpectedResponse.Display(), response.Body.String())
+		}
+		if location := response.Header().Get("Location"); location != test.ExpectedLocation {
+			t.Errorf("Test %s did not meet expected location.\nExpected %s.\nFound %s.\n", test.TestName, test.ExpectedLocation, location)
+		}
+	}
+}
+
 func TestPrivilegedProxy(t *testing.T) {
 	for _, test := range proxyTests {
 		// We can only get this after the server has started.
@@ -164,3 +215,43 @@ func TestProxy(t *testing.T) {
 		testServer.Close()
 	}
 }
+
+func TestProxyDoesNotRetryLargeBodies(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		size          int
+		expectedCalls int
+	}{
+		{"small body", 1024, 2},
+		{"large body", 2 << 20, 1},
+	} {
+		var calls, received int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			calls++
+			received = len(body)
+			if calls == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+		c := &controllers.SecureContext{
+			Context: &controllers.Context{Settings: &helpers.Settings{
+				ConsoleAPI:  server.URL,
+				OAuthConfig: &oauth2.Config{},
+				RetryPolicy: &helpers.RetryPolicy{MaxAttempts: 2, Budget: helpers.NewRetryBudget(1, 10)},
+			}},
+			Token: ValidTokenData["token"].(oauth2.Token),
+		}
+		response, request := NewTestRequest("PUT", server.URL+"/v2/apps/app-guid", bytes.Repeat([]byte("a"), test.size))
+		c.Proxy(response, request, server.URL+"/v2/apps/app-guid", EchoResponseHandler)
+		if calls != test.expectedCalls {
+			t.Errorf("%s: expected %d calls upstream, found %d", test.name, test.expectedCalls, calls)
+		}
+		if received != test.size {
+			t.Errorf("%s: expected the last call to send %d bytes, found %d", test.name, test.size, received)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+// securityLog logs the failures to secure responses.
+var securityLog = logging.New("security")
+
+// SecurityHeadersMiddleware sends the security headers, like the content
+// security policy and HSTS, with every response. The policy's nonce is kept
+// for the index's inline scripts.
+func (c *Context) SecurityHeadersMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	if c.Settings.SecurityHeaders == nil {
+		next(rw, req)
+		return
+	}
+	nonce, err := helpers.NewCSPNonce()
+	if err != nil {
+		// Without a nonce the policy still applies, but no inline script
+		// runs.
+		c.logger(securityLog).Errorf("unable to create a CSP nonce: %v", err)
+	}
+	c.cspNonce = nonce
+	c.Settings.SecurityHeaders.Write(rw.Header(), nonce)
+	next(rw, req)
+}
@@ -0,0 +1,47 @@
+package controllers_test
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+var cspNoncePattern = regexp.MustCompile(`'nonce-([^']+)'`)
+
+func TestSecurityHeadersMiddleware(t *testing.T) {
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.GATrackingIDEnvVar] = "UA-123456-11"
+	router, _ := CreateRouterWithMockSession(map[string]interface{}{}, envVars)
+
+	response, request := NewTestRequest("GET", "/", nil)
+	router.ServeHTTP(response, request)
+	csp := response.Header().Get("Content-Security-Policy")
+	match := cspNoncePattern.FindStringSubmatch(csp)
+	if match == nil {
+		t.Fatalf("Expected a nonce in the policy. Found %q", csp)
+	}
+	if !strings.Contains(csp, "https://www.google-analytics.com") {
+		t.Errorf("Expected GA to be allowed. Found %q", csp)
+	}
+	// The template escapes a + in the nonce, which browsers unescape.
+	if n := strings.Count(html.UnescapeString(response.Body.String()), `nonce="`+match[1]+`"`); n != 3 {
+		t.Errorf("Expected the 3 inline scripts to carry the nonce. Found %d", n)
+	}
+	if got := response.Header().Get("Strict-Transport-Security"); got != "max-age=31536000" {
+		t.Errorf("Expected HSTS with secure cookies. Found %q", got)
+	}
+
+	// Every response is secured, and gets a new nonce.
+	response, request = NewTestRequest("GET", "/ping", nil)
+	router.ServeHTTP(response, request)
+	if other := cspNoncePattern.FindStringSubmatch(response.Header().Get("Content-Security-Policy")); other == nil || other[1] == match[1] {
+		t.Errorf("Expected a new nonce. Found %q", response.Header().Get("Content-Security-Policy"))
+	}
+	if response.Header().Get("X-Frame-Options") != "DENY" || response.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("Unexpected headers %v", response.Header())
+	}
+}
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gocraft/web"
+)
+
+// ServiceInstanceContext stores the session info and access token per user.
+// All routes within ServiceInstanceContext manage where service instances
+// are shared.
+type ServiceInstanceContext struct {
+	*SecureContext // Required.
+}
+
+// sharedSpace is a space a service instance is shared with.
+type sharedSpace struct {
+	GUID    string `json:"guid"`
+	Name    string `json:"name"`
+	OrgGUID string `json:"org_guid"`
+	OrgName string `json:"org_name"`
+}
+
+// sharedSpaceFields are the fields of SharedSpaces that can be selected.
+var sharedSpaceFields = fieldsOf(sharedSpace{})
+
+// brokenBinding is a binding that unsharing a service instance deletes.
+type brokenBinding struct {
+	GUID    string `json:"guid"`
+	AppGUID string `json:"app_guid"`
+	AppName string `json:"app_name"`
+}
+
+// ccV3Relationships is a v3 CF API to-many relationship.
+type ccV3Relationships struct {
+	Data []struct {
+		GUID string `json:"guid"`
+	} `json:"data"`
+}
+
+// serviceInstance looks up the name of the service instance of the path. It
+// responds with an error and returns false if it can't.
+func (c *ServiceInstanceContext) serviceInstance(rw http.ResponseWriter, guid string) (string, bool) {
+	var instance struct {
+		Name string `json:"name"`
+	}
+	err := c.ccRequest("GET", "/v3/service_instances/"+url.PathEscape(guid), nil, &instance)
+	if isCCNotFound(err) {
+		newUaaError(http.StatusNotFound, "unknown service instance.").writeTo(rw)
+		return "", false
+	}
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return "", false
+	}
+	return instance.Name, true
+}
+
+// sharedSpaces lists the spaces the service instance is shared with.
+func (c *ServiceInstanceContext) sharedSpaces(guid string) ([]sharedSpace, error) {
+	var relationships ccV3Relationships
+	if err := c.ccRequest("GET", "/v3/service_instances/"+url.PathEscape(guid)+"/relationships/shared_spaces", nil, &relationships); err != nil {
+		return nil, err
+	}
+	spaces := []sharedSpace{}
+	if len(relationships.Data) == 0 {
+		return spaces, nil
+	}
+	guids := make([]string, len(relationships.Data))
+	for i, space := range relationships.Data {
+		guids[i] = space.GUID
+	}
+	resources, err := c.ccGetAllV3("/v3/spaces?" + url.Values{"guids": {strings.Join(guids, ",")}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var orgGUIDs []string
+	for _, resource := range resources {
+		var space struct {
+			GUID          string `json:"guid"`
+			Name          string `json:"name"`
+			Relationships struct {
+				Organization struct {
+					Data struct {
+						GUID string `json:"guid"`
+					} `json:"data"`
+				} `json:"organization"`
+			} `json:"relationships"`
+		}
+		if err := json.Unmarshal(resource, &space); err != nil {
+			return nil, err
+		}
+		orgGUID := space.Relationships.Organization.Data.GUID
+		spaces = append(spaces, sharedSpace{GUID: space.GUID, Name: space.Name, OrgGUID: orgGUID})
+		orgGUIDs = append(orgGUIDs, orgGUID)
+	}
+	orgs, err := c.ccGetAllV3("/v3/organizations?" + url.Values{"guids": {strings.Join(orgGUIDs, ",")}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	orgNames := map[string]string{}
+	for _, resource := range orgs {
+		var org struct {
+			GUID string `json:"guid"`
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(resource, &org); err != nil {
+			return nil, err
+		}
+		orgNames[org.GUID] = org.Name
+	}
+	for i := range spaces {
+		spaces[i].OrgName = orgNames[spaces[i].OrgGUID]
+	}
+	return spaces, nil
+}
+
+// bindingsInSpace lists the app bindings of the service instance in the
+// space, which unsharing the instance from the space deletes.
+func (c *ServiceInstanceContext) bindingsInSpace(guid, spaceGUID string) ([]brokenBinding, error) {
+	resources, err := c.ccGetAllV3("/v3/service_credential_bindings?" + url.Values{
+		"type":                   {"app"},
+		"service_instance_guids": {guid},
+	}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	bindings := []brokenBinding{}
+	if len(resources) == 0 {
+		return bindings, nil
+	}
+	appGUIDs := make([]string, 0, len(resources))
+	byApp := map[string][]string{}
+	for _, resource := range resources {
+		var binding struct {
+			GUID          string `json:"guid"`
+			Relationships struct {
+				App struct {
+					Data struct {
+						GUID string `json:"guid"`
+					} `json:"data"`
+				} `json:"app"`
+			} `json:"relationships"`
+		}
+		if err := json.Unmarshal(resource, &binding); err != nil {
+			return nil, err
+		}
+		app := binding.Relationships.App.Data.GUID
+		if _, ok := byApp[app]; !ok {
+			appGUIDs = append(appGUIDs, app)
+		}
+		byApp[app] = append(byApp[app], binding.GUID)
+	}
+	apps, err := c.ccGetAllV3("/v3/apps?" + url.Values{
+		"guids":       {strings.Join(appGUIDs, ",")},
+		"space_guids": {spaceGUID},
+	}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	for _, resource := range apps {
+		var app struct {
+			GUID string `json:"guid"`
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(resource, &app); err != nil {
+			return nil, err
+		}
+		for _, binding := range byApp[app.GUID] {
+			bindings = append(bindings, brokenBinding{GUID: binding, AppGUID: app.GUID, AppName: app.Name})
+		}
+	}
+	return bindings, nil
+}
+
+// SharedSpaces lists the spaces the service instance is shared with.
+func (c *ServiceInstanceContext) SharedSpaces(rw web.ResponseWriter, req *web.Request) {
+	guid := req.PathParams["guid"]
+	if _, ok := c.serviceInstance(rw, guid); !ok {
+		return
+	}
+	spaces, err := c.sharedSpaces(guid)
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	c.writeAggregate(rw, req, sharedSpaceFields, spaces)
+}
+
+// Share shares the service instance with the spaces of the body, which may
+// be in other orgs, and responds with all the spaces it's shared with.
+func (c *ServiceInstanceContext) Share(rw web.ResponseWriter, req *web.Request) {
+	guid := req.PathParams["guid"]
+	var body struct {
+		SpaceGUIDs []string `json:"space_guids"`
+	}
+	if err := readBodyToStruct(req.Body, &body); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	if len(body.SpaceGUIDs) == 0 {
+		newUaaError(http.StatusBadRequest, "space_guids are required.").writeTo(rw)
+		return
+	}
+	name, ok := c.serviceInstance(rw, guid)
+	if !ok {
+		return
+	}
+	var relationships ccV3Relationships
+	for _, spaceGUID := range body.SpaceGUIDs {
+		relationships.Data = append(relationships.Data, struct {
+			GUID string `json:"guid"`
+		}{spaceGUID})
+	}
+	if err := c.ccRequest("POST", "/v3/service_instances/"+url.PathEscape(guid)+"/relationships/shared_spaces", relationships, nil); err != nil {
+		newUaaError(ccErrorStatus(err), err.Error()).writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "share_service_instance", struct {
+		ServiceInstanceGUID string   `json:"service_instance_guid"`
+		ServiceInstanceName string   `json:"service_instance_name"`
+		SpaceGUIDs          []string `json:"space_guids"`
+	}{guid, name, body.SpaceGUIDs})
+	spaces, err := c.sharedSpaces(guid)
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	c.writeAggregate(rw, req, sharedSpaceFields, spaces)
+}
+
+// Unshare stops sharing the service instance with the space. That deletes
+// the bindings of the space's apps to the instance, so when there are some,
+// the first request only lists them, and the instance is unshared when the
+// request is repeated with ?confirm=<service instance name>.
+func (c *ServiceInstanceContext) Unshare(rw web.ResponseWriter, req *web.Request) {
+	guid, spaceGUID := req.PathParams["guid"], req.PathParams["space_guid"]
+	name, ok := c.serviceInstance(rw, guid)
+	if !ok {
+		return
+	}
+	if req.URL.Query().Get("confirm") != name {
+		bindings, err := c.bindingsInSpace(guid, spaceGUID)
+		if err != nil {
+			newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+			return
+		}
+		if len(bindings) > 0 {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusConflict)
+			json.NewEncoder(rw).Encode(struct {
+				Status      string          `json:"status"`
+				Description string          `json:"error_description"`
+				Bindings    []brokenBinding `json:"bindings"`
+			}{
+				Status:      "confirmation_required",
+				Description: "Unsharing the service instance deletes its bindings to these apps, which lose access to it. Repeat the request with ?confirm=" + name + " to unshare it.",
+				Bindings:    bindings,
+			})
+			return
+		}
+	}
+	path := "/v3/service_instances/" + url.PathEscape(guid) + "/relationships/shared_spaces/" + url.PathEscape(spaceGUID)
+	if err := c.ccRequest("DELETE", path, nil, nil); err != nil {
+		newUaaError(ccErrorStatus(err), err.Error()).writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "unshare_service_instance", struct {
+		ServiceInstanceGUID string `json:"service_instance_guid"`
+		ServiceInstanceName string `json:"service_instance_name"`
+		SpaceGUID           string `json:"space_guid"`
+	}{guid, name, spaceGUID})
+	rw.WriteHeader(http.StatusNoContent)
+}
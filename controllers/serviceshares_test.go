@@ -0,0 +1,111 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestServiceInstanceSharing(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		shared = []string{"space-2"}
+	)
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method + " " + r.URL.Path {
+		case "GET /v3/service_instances/si-1":
+			w.Write([]byte(`{"guid": "si-1", "name": "db"}`))
+		case "GET /v3/service_instances/si-1/relationships/shared_spaces":
+			data := make([]map[string]string, len(shared))
+			for i, guid := range shared {
+				data[i] = map[string]string{"guid": guid}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		case "POST /v3/service_instances/si-1/relationships/shared_spaces":
+			var body struct {
+				Data []struct {
+					GUID string `json:"guid"`
+				} `json:"data"`
+			}
+			b, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(b, &body)
+			for _, space := range body.Data {
+				shared = append(shared, space.GUID)
+			}
+			w.Write(b)
+		case "DELETE /v3/service_instances/si-1/relationships/shared_spaces/space-2":
+			shared = nil
+			w.WriteHeader(http.StatusNoContent)
+		case "GET /v3/spaces":
+			w.Write([]byte(`{"pagination": {"next": null}, "resources": [
+				{"guid": "space-2", "name": "staging", "relationships": {"organization": {"data": {"guid": "org-2"}}}},
+				{"guid": "space-3", "name": "prod", "relationships": {"organization": {"data": {"guid": "org-2"}}}}]}`))
+		case "GET /v3/organizations":
+			w.Write([]byte(`{"pagination": {"next": null}, "resources": [{"guid": "org-2", "name": "partner"}]}`))
+		case "GET /v3/service_credential_bindings":
+			w.Write([]byte(`{"pagination": {"next": null}, "resources": [
+				{"guid": "binding-1", "relationships": {"app": {"data": {"guid": "app-1"}}}},
+				{"guid": "binding-2", "relationships": {"app": {"data": {"guid": "app-2"}}}}]}`))
+		case "GET /v3/apps":
+			if r.URL.Query().Get("space_guids") != "space-2" {
+				t.Errorf("Expected the apps of the unshared space. Found %s", r.URL)
+			}
+			w.Write([]byte(`{"pagination": {"next": null}, "resources": [{"guid": "app-2", "name": "reporting"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	response, request := NewTestRequest("GET", "/api/service_instances/si-9/shared_spaces", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown instance to be not found. Found %d", response.Code)
+	}
+
+	response, request = NewTestRequest("POST", "/api/service_instances/si-1/shared_spaces", []byte(`{"space_guids": ["space-3"]}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+	expected := NewJSONResponseContentTester(`[
+		{"guid": "space-2", "name": "staging", "org_guid": "org-2", "org_name": "partner"},
+		{"guid": "space-3", "name": "prod", "org_guid": "org-2", "org_name": "partner"}]`)
+	if !expected.Check(t, response.Body.String()) {
+		t.Errorf("Unexpected shared spaces %s", response.Body.String())
+	}
+
+	// Unsharing warns about the bindings that break first.
+	response, request = NewTestRequest("DELETE", "/api/service_instances/si-1/shared_spaces/space-2", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusConflict {
+		t.Fatalf("Expected a confirmation to be required. Found %d", response.Code)
+	}
+	var conflict struct {
+		Bindings []struct {
+			GUID    string `json:"guid"`
+			AppName string `json:"app_name"`
+		} `json:"bindings"`
+	}
+	json.NewDecoder(response.Body).Decode(&conflict)
+	if len(conflict.Bindings) != 1 || conflict.Bindings[0].GUID != "binding-2" || conflict.Bindings[0].AppName != "reporting" {
+		t.Errorf("Expected the binding in the space to be listed. Found %+v", conflict.Bindings)
+	}
+
+	response, request = NewTestRequest("DELETE", "/api/service_instances/si-1/shared_spaces/space-2?confirm=db", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNoContent {
+		t.Errorf("Expected the instance to be unshared. Found %d", response.Code)
+	}
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var sessionLog = logging.New("sessions")
+
+// noActivityPaths are the paths whose requests are not activity of the user,
+// so polling them doesn't keep idle sessions alive.
+var noActivityPaths = map[string]bool{
+	"/api/me/session": true,
+}
+
+// sessionExpiryFields are the fields of Session that can be selected.
+var sessionExpiryFields = fieldsOf(helpers.SessionExpiry{})
+
+// SessionActivityMiddleware marks the requests to noActivityPaths as not
+// being activity of the user. It must run before the session is loaded.
+func (c *Context) SessionActivityMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	if noActivityPaths[req.URL.Path] {
+		req.Request = helpers.WithoutSessionActivity(req.Request)
+	}
+	next(rw, req)
+}
+
+// SessionMigrationMiddleware moves the sessions still kept in cookies into
+// the server-side store, after SESSION_BACKEND is switched from cookies with
+// SESSION_MIGRATE_COOKIES set.
+func (c *Context) SessionMigrationMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	if store, ok := c.Settings.Sessions.(*helpers.ServerSideStore); ok {
+		if err := store.MigrateCookieSession(rw, req.Request, "session"); err != nil {
+			// The cookie still works, so try again on the next request.
+			c.logger(sessionLog).Errorf("unable to migrate the session from its cookie: %v", err)
+		}
+	}
+	next(rw, req)
+}
+
+// Session returns when the current session ends unless the user does
+// something, so the frontend can warn them before it does.
+func (c *MeContext) Session(rw web.ResponseWriter, req *web.Request) {
+	session, err := c.Settings.Sessions.Get(req.Request, "session")
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, "unable to load the session.").writeTo(rw)
+		return
+	}
+	c.writeAggregate(rw, req, sessionExpiryFields, c.Settings.SessionTimeouts.Expiry(session, time.Now()))
+}
@@ -0,0 +1,61 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestSessionExpiry(t *testing.T) {
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.SessionIdleTimeoutEnvVar] = "30m"
+	envVars[helpers.SessionAbsoluteTimeoutEnvVar] = "12h"
+	now := time.Now()
+	lastActivity := now.Add(-10 * time.Minute).Unix()
+	sessionData := map[string]interface{}{
+		"token":            oauth2.Token{AccessToken: "sampletoken", Expiry: now.Add(time.Hour)},
+		"logged_in_at":     now.Add(-time.Hour).Unix(),
+		"last_activity_at": lastActivity,
+	}
+
+	router, store := CreateRouterWithMockSession(sessionData, envVars)
+	response, request := NewTestRequest("GET", "/api/me/session", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected code %d. Found %d", http.StatusOK, response.Code)
+	}
+	var expiry helpers.SessionExpiry
+	json.NewDecoder(response.Body).Decode(&expiry)
+	if expiry.Reason != "idle" || expiry.IdleTimeoutSeconds != 30*60 || expiry.RemainingSeconds < 19*60 || expiry.RemainingSeconds > 20*60 {
+		t.Errorf("Unexpected expiry %+v", expiry)
+	}
+	// Asking when the session ends doesn't keep it alive.
+	if store.Session.Values["last_activity_at"] != lastActivity {
+		t.Errorf("Expected the last activity to be unchanged. Found %v", store.Session.Values["last_activity_at"])
+	}
+
+	// Other requests do.
+	response, request = NewTestRequest("GET", "/api/me/preferences", nil)
+	router.ServeHTTP(response, request)
+	if store.Session.Values["last_activity_at"] == lastActivity {
+		t.Error("Expected the last activity to be saved")
+	}
+
+	// Idle sessions end.
+	sessionData["last_activity_at"] = now.Add(-31 * time.Minute).Unix()
+	router, store = CreateRouterWithMockSession(sessionData, envVars)
+	response, request = NewTestRequest("GET", "/api/me/session", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected code %d. Found %d", http.StatusUnauthorized, response.Code)
+	}
+	if store.Session.Values["token"] != nil {
+		t.Error("Expected the token of the idle session to be cleared")
+	}
+}
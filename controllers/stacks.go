@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+// StackContext stores the session info and access token per user.
+// All routes within StackContext help with moving apps between stacks.
+type StackContext struct {
+	*SecureContext // Required.
+}
+
+// ccApp is a partial representation of a v2 CF API app entity.
+type ccApp struct {
+	Name       string `json:"name"`
+	SpaceGUID  string `json:"space_guid"`
+	StackGUID  string `json:"stack_guid"`
+	State      string `json:"state"`
+	Instances  int    `json:"instances"`
+	Memory     int    `json:"memory"`
+	DiskQuota  int    `json:"disk_quota"`
+	Buildpack  string `json:"buildpack"`
+	Detected   string `json:"detected_buildpack"`
+	PackageAge string `json:"package_updated_at"`
+}
+
+// stackMigrationApp is an app on the deprecated stack and the impact of
+// restaging it.
+type stackMigrationApp struct {
+	GUID      string `json:"guid"`
+	Name      string `json:"name"`
+	SpaceGUID string `json:"space_guid"`
+	State     string `json:"state"`
+	Instances int    `json:"instances"`
+	MemoryMB  int    `json:"memory_mb"`
+	DiskMB    int    `json:"disk_quota_mb"`
+	Buildpack string `json:"buildpack"`
+	// RestageImpact is "downtime" for started apps, since a restage restarts
+	// all instances, and "none" for stopped apps.
+	RestageImpact string `json:"restage_impact"`
+}
+
+// stackMigrationSummary estimates the impact of restaging all the apps.
+type stackMigrationSummary struct {
+	Apps             int `json:"apps"`
+	StartedApps      int `json:"started_apps"`
+	StartedInstances int `json:"started_instances"`
+	// StagingMemoryMB is the memory needed to stage all the apps at once.
+	StagingMemoryMB int `json:"staging_memory_mb"`
+}
+
+// stackMigrationReport is the response of MigrationReport.
+type stackMigrationReport struct {
+	OrgGUID   string                `json:"org_guid"`
+	FromStack string                `json:"from"`
+	Apps      []stackMigrationApp   `json:"apps"`
+	Summary   stackMigrationSummary `json:"summary"`
+}
+
+// stackMigrationFields are the fields of MigrationReport that can be
+// selected.
+var stackMigrationFields = fieldsOf(stackMigrationReport{})
+
+// stackMigrationRequest is the body to start a bulk restage onto a new stack.
+type stackMigrationRequest struct {
+	OrgGUID   string `json:"org_guid"`
+	FromStack string `json:"from"`
+	ToStack   string `json:"to"`
+	// AppGUIDs optionally limits the migration to some of the apps.
+	AppGUIDs []string `json:"app_guids"`
+}
+
+// appsOnStack returns the apps in the org that are on the stack.
+func (c *StackContext) appsOnStack(orgGUID, stackName string) ([]stackMigrationApp, error) {
+	stack, err := c.ccGetOne("/v2/stacks?" + url.Values{"q": {"name:" + stackName}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	apps := []stackMigrationApp{}
+	err = c.ccEach("/v2/apps?"+url.Values{
+		"q":                {"organization_guid:" + orgGUID, "stack_guid:" + stack.Metadata.GUID},
+		"results-per-page": {"100"},
+	}.Encode(), func(dec *json.Decoder) error {
+		var resource struct {
+			Metadata struct {
+				GUID string `json:"guid"`
+			} `json:"metadata"`
+			Entity ccApp `json:"entity"`
+		}
+		if err := dec.Decode(&resource); err != nil {
+			return err
+		}
+		app := resource.Entity
+		buildpack := app.Buildpack
+		if buildpack == "" {
+			buildpack = app.Detected
+		}
+		impact := "none"
+		if app.State == "STARTED" {
+			impact = "downtime"
+		}
+		apps = append(apps, stackMigrationApp{
+			GUID:          resource.Metadata.GUID,
+			Name:          app.Name,
+			SpaceGUID:     app.SpaceGUID,
+			State:         app.State,
+			Instances:     app.Instances,
+			MemoryMB:      app.Memory,
+			DiskMB:        app.DiskQuota,
+			Buildpack:     buildpack,
+			RestageImpact: impact,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+// MigrationReport lists the apps in an org that are on a deprecated stack
+// and estimates the impact of restaging them.
+func (c *StackContext) MigrationReport(rw web.ResponseWriter, req *web.Request) {
+	orgGUID := req.URL.Query().Get("org_guid")
+	fromStack := req.URL.Query().Get("from")
+	if orgGUID == "" || fromStack == "" {
+		newUaaError(http.StatusBadRequest, "org_guid and from are required.").writeTo(rw)
+		return
+	}
+	apps, err := c.appsOnStack(orgGUID, fromStack)
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	summary := stackMigrationSummary{Apps: len(apps)}
+	for _, app := range apps {
+		summary.StagingMemoryMB += app.MemoryMB
+		if app.State == "STARTED" {
+			summary.StartedApps++
+			summary.StartedInstances += app.Instances
+		}
+	}
+	c.writeAggregate(rw, req, stackMigrationFields, stackMigrationReport{
+		OrgGUID:   orgGUID,
+		FromStack: fromStack,
+		Apps:      apps,
+		Summary:   summary,
+	})
+}
+
+// Migrate queues a throttled restage of the apps onto the new stack. The
+// per app results can be followed with the returned job.
+func (c *StackContext) Migrate(rw web.ResponseWriter, req *web.Request) {
+	var migration stackMigrationRequest
+	if err := readBodyToStruct(req.Body, &migration); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	if migration.OrgGUID == "" || migration.FromStack == "" || migration.ToStack == "" {
+		newUaaError(http.StatusBadRequest, "org_guid, from and to are required.").writeTo(rw)
+		return
+	}
+	toStack, err := c.ccGetOne("/v2/stacks?" + url.Values{"q": {"name:" + migration.ToStack}}.Encode())
+	if err != nil {
+		newUaaError(http.StatusBadRequest, "unknown stack "+migration.ToStack).writeTo(rw)
+		return
+	}
+	apps, err := c.appsOnStack(migration.OrgGUID, migration.FromStack)
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+
+	selected := make(map[string]bool)
+	for _, guid := range migration.AppGUIDs {
+		selected[guid] = true
+	}
+	var tasks []jobs.Task
+	for _, app := range apps {
+		if len(selected) > 0 && !selected[app.GUID] {
+			continue
+		}
+		appGUID := app.GUID
+		tasks = append(tasks, jobs.Task{
+			Name: appGUID,
+			Run: func() error {
+				err := c.ccRequest("PUT", "/v2/apps/"+appGUID, map[string]string{
+					"stack_guid": toStack.Metadata.GUID,
+				}, nil)
+				if err != nil {
+					return err
+				}
+				return c.ccRequest("POST", "/v2/apps/"+appGUID+"/restage", nil, nil)
+			},
+		})
+	}
+	c.submitJob(rw, "stack-migration", tasks)
+}
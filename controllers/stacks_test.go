@@ -0,0 +1,273 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+// newStackCC returns a fake CF API with two stacks and two apps on the old
+// one. It records the app updates it receives.
+func newStackCC(t *testing.T, mu *sync.Mutex, calls *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/v2/stacks" && r.URL.Query().Get("q") == "name:cflinuxfs2":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "old-stack"}, "entity": {}}]}`))
+		case r.URL.Path == "/v2/stacks" && r.URL.Query().Get("q") == "name:cflinuxfs3":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "new-stack"}, "entity": {}}]}`))
+		case r.URL.Path == "/v2/stacks":
+			w.Write([]byte(`{"next_url": null, "resources": []}`))
+		case r.URL.Path == "/v2/apps" && r.Method == "GET":
+			q := r.URL.Query()["q"]
+			if len(q) != 2 || q[0] != "organization_guid:org-guid" || q[1] != "stack_guid:old-stack" {
+				t.Errorf("Unexpected apps query %v", q)
+			}
+			w.Write([]byte(`{"next_url": null, "resources": [
+				{"metadata": {"guid": "app-1"}, "entity": {"name": "web", "space_guid": "space-guid", "state": "STARTED", "instances": 2, "memory": 256, "disk_quota": 1024, "buildpack": null, "detected_buildpack": "ruby"}},
+				{"metadata": {"guid": "app-2"}, "entity": {"name": "worker", "space_guid": "space-guid", "state": "STOPPED", "instances": 1, "memory": 128, "disk_quota": 512, "buildpack": "go_buildpack"}}
+			]}`))
+		case r.Method == "PUT" || r.Method == "POST":
+			body, _ := ioutil.ReadAll(r.Body)
+			*calls = append(*calls, r.Method+" "+r.URL.Path+" "+string(body))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestStackMigrationReport(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	cc := newStackCC(t, &mu, &calls)
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+
+	response, request := NewTestRequest("GET", "/stacks/migration?org_guid=org-guid&from=cflinuxfs2", nil)
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+	router.ServeHTTP(response, request)
+	expected := NewJSONResponseContentTester(`{
+		"org_guid": "org-guid",
+		"from": "cflinuxfs2",
+		"apps": [
+			{"guid": "app-1", "name": "web", "space_guid": "space-guid", "state": "STARTED", "instances": 2, "memory_mb": 256, "disk_quota_mb": 1024, "buildpack": "ruby", "restage_impact": "downtime"},
+			{"guid": "app-2", "name": "worker", "space_guid": "space-guid", "state": "STOPPED", "instances": 1, "memory_mb": 128, "disk_quota_mb": 512, "buildpack": "go_buildpack", "restage_impact": "none"}
+		],
+		"summary": {"apps": 2, "started_apps": 1, "started_instances": 2, "staging_memory_mb": 384}
+	}`)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected code %d. Found %d", http.StatusOK, response.Code)
+	}
+	if !expected.Check(t, response.Body.String()) {
+		t.Errorf("Expected %s. Found %s", expected.Display(), response.Body.String())
+	}
+
+	response, request = NewTestRequest("GET", "/stacks/migration?org_guid=org-guid", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected code %d without a stack. Found %d", http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestStackMigrationReportFields(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	cc := newStackCC(t, &mu, &calls)
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	tests := []struct {
+		fields           string
+		expectedCode     int
+		expectedResponse string
+	}{
+		{
+			fields:       "apps.name,apps.memory_mb,summary.apps",
+			expectedCode: http.StatusOK,
+			expectedResponse: `{
+				"apps": [{"name": "web", "memory_mb": 256}, {"name": "worker", "memory_mb": 128}],
+				"summary": {"apps": 2}
+			}`,
+		},
+		{
+			fields:           "org_guid,summary,summary.apps",
+			expectedCode:     http.StatusOK,
+			expectedResponse: `{"org_guid": "org-guid", "summary": {"apps": 2, "started_apps": 1, "started_instances": 2, "staging_memory_mb": 384}}`,
+		},
+		{
+			fields:       "apps.owner",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		response, request := NewTestRequest("GET", "/stacks/migration?org_guid=org-guid&from=cflinuxfs2&fields="+test.fields, nil)
+		router.ServeHTTP(response, request)
+		if response.Code != test.expectedCode {
+			t.Errorf("Fields %s: expected code %d. Found %d", test.fields, test.expectedCode, response.Code)
+		}
+		if test.expectedResponse == "" {
+			continue
+		}
+		expected := NewJSONResponseContentTester(test.expectedResponse)
+		if !expected.Check(t, response.Body.String()) {
+			t.Errorf("Fields %s: expected %s. Found %s", test.fields, expected.Display(), response.Body.String())
+		}
+	}
+}
+
+func TestStackMigrationReportPages(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/stacks":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "old-stack"}, "entity": {}}]}`))
+		case r.URL.Path == "/v2/apps" && r.URL.Query().Get("page") == "":
+			w.Write([]byte(`{"total_results": 2, "next_url": "/v2/apps?page=2", "resources": [
+				{"metadata": {"guid": "app-1"}, "entity": {"name": "web", "state": "STOPPED", "environment_json": {"LARGE": "` + strings.Repeat("x", 1024) + `"}}}
+			]}`))
+		case r.URL.Path == "/v2/apps":
+			w.Write([]byte(`{"resources": [{"metadata": {"guid": "app-2"}, "entity": {"name": "worker", "state": "STOPPED"}}], "next_url": null}`))
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	response, request := NewTestRequest("GET", "/stacks/migration?org_guid=org-guid&from=cflinuxfs2&fields=apps.guid", nil)
+	router.ServeHTTP(response, request)
+	expected := NewJSONResponseContentTester(`{"apps": [{"guid": "app-1"}, {"guid": "app-2"}]}`)
+	if !expected.Check(t, response.Body.String()) {
+		t.Errorf("Expected %s. Found %s", expected.Display(), response.Body.String())
+	}
+}
+
+func TestStackMigrationReportETag(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	cc := newStackCC(t, &mu, &calls)
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	response, request := NewTestRequest("GET", "/stacks/migration?org_guid=org-guid&from=cflinuxfs2", nil)
+	router.ServeHTTP(response, request)
+	etag := response.Header().Get("ETag")
+	if response.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected code %d with an ETag. Found %d with %q", http.StatusOK, response.Code, etag)
+	}
+
+	tests := []struct {
+		ifNoneMatch  string
+		expectedCode int
+	}{
+		{etag, http.StatusNotModified},
+		{`"other", W/` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"other"`, http.StatusOK},
+	}
+	for _, test := range tests {
+		response, request := NewTestRequest("GET", "/stacks/migration?org_guid=org-guid&from=cflinuxfs2", nil)
+		request.Header.Set("If-None-Match", test.ifNoneMatch)
+		router.ServeHTTP(response, request)
+		if response.Code != test.expectedCode {
+			t.Errorf("If-None-Match %s: expected code %d. Found %d", test.ifNoneMatch, test.expectedCode, response.Code)
+		}
+		if response.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %s: expected ETag %s. Found %s", test.ifNoneMatch, etag, response.Header().Get("ETag"))
+		}
+		if test.expectedCode == http.StatusNotModified && response.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: expected an empty body. Found %s", test.ifNoneMatch, response.Body.String())
+		}
+	}
+
+	// Selecting fields changes the response, so it changes the ETag.
+	response, request = NewTestRequest("GET", "/stacks/migration?org_guid=org-guid&from=cflinuxfs2&fields=summary", nil)
+	request.Header.Set("If-None-Match", etag)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected code %d with fields. Found %d", http.StatusOK, response.Code)
+	}
+}
+
+func TestStackMigrate(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	cc := newStackCC(t, &mu, &calls)
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	response, request := NewTestRequest("POST", "/stacks/migration",
+		[]byte(`{"org_guid": "org-guid", "from": "cflinuxfs2", "to": "cflinuxfs4"}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected code %d for an unknown stack. Found %d", http.StatusBadRequest, response.Code)
+	}
+
+	response, request = NewTestRequest("POST", "/stacks/migration",
+		[]byte(`{"org_guid": "org-guid", "from": "cflinuxfs2", "to": "cflinuxfs3", "app_guids": ["app-2"]}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusAccepted, response.Code, response.Body.String())
+	}
+	var job struct {
+		ID      string `json:"id"`
+		Status  string `json:"status"`
+		Results []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+		} `json:"results"`
+	}
+	json.NewDecoder(response.Body).Decode(&job)
+	if len(job.Results) != 1 || job.Results[0].Name != "app-2" {
+		t.Fatalf("Expected only app-2 to be migrated. Found %+v", job)
+	}
+
+	// Follow the job until it is done.
+	for i := 0; i < 100 && job.Status != "succeeded" && job.Status != "failed"; i++ {
+		time.Sleep(10 * time.Millisecond)
+		response, request = NewTestRequest("GET", "/jobs/"+job.ID, nil)
+		router.ServeHTTP(response, request)
+		json.NewDecoder(response.Body).Decode(&job)
+	}
+	if job.Status != "succeeded" {
+		t.Errorf("Expected job to succeed. Found %+v", job)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expectedCalls := []string{
+		`PUT /v2/apps/app-2 {"stack_guid":"new-stack"}`,
+		`POST /v2/apps/app-2/restage `,
+	}
+	if strings.Join(calls, "\n") != strings.Join(expectedCalls, "\n") {
+		t.Errorf("Expected calls %v. Found %v", expectedCalls, calls)
+	}
+
+	// Other users can't see the job.
+	response, request = NewTestRequest("GET", "/jobs/"+job.ID, nil)
+	otherRouter, _ := CreateRouterWithMockSession(operatorTokenData, envVars)
+	otherRouter.ServeHTTP(response, request)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected code %d for another user's job. Found %d", http.StatusNotFound, response.Code)
+	}
+}
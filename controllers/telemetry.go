@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// resourceNamePattern matches CF API resource names such as "apps" or
+// "service_instances", but not GUIDs or names chosen by users.
+var resourceNamePattern = regexp.MustCompile(`^[a-z_]+$`)
+
+// telemetryFeature names the feature used by the request. It is the route
+// pattern, so it never includes GUIDs or other user data. For the CF API
+// proxy the resource type is included, e.g. "GET /v2/apps".
+func telemetryFeature(req *web.Request) string {
+	route := req.RoutePath()
+	if strings.HasSuffix(route, ":*") {
+		resource := strings.SplitN(req.PathParams["*"], "/", 2)[0]
+		if !resourceNamePattern.MatchString(resource) {
+			resource = ":*"
+		}
+		route = strings.TrimSuffix(route, ":*") + resource
+	}
+	return req.Method + " " + route
+}
+
+// telemetryMiddleware counts the use of each routed feature.
+func telemetryMiddleware(telemetry *helpers.Telemetry) func(web.ResponseWriter, *web.Request, web.NextMiddlewareFunc) {
+	return func(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		next(rw, req)
+		if req.IsRouted() {
+			telemetry.Record(telemetryFeature(req))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfenv"
+	"github.com/govau/cf-common/env"
+
+	"github.com/18F/cg-dashboard/controllers"
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestTelemetryFeatures(t *testing.T) {
+	var report helpers.TelemetryReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&report)
+	}))
+	defer server.Close()
+
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.TelemetryURLEnvVar] = server.URL
+	app, _ := cfenv.Current()
+	router, settings, err := controllers.InitApp(env.NewVarSet(env.WithMapLookup(envVars)), app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/ping", "/ping", "/v2/apps/some-guid", "/v2/Secret-Name", "/jobs/some-id"} {
+		response, request := NewTestRequest("GET", path, nil)
+		router.ServeHTTP(response, request)
+	}
+	if err := settings.Telemetry.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int64{
+		"GET /ping":     2,
+		"GET /v2/apps":  1,
+		"GET /v2/:*":    1,
+		"GET /jobs/:id": 1,
+	}
+	if len(report.Features) != len(expected) {
+		t.Errorf("Expected features %v. Found %v", expected, report.Features)
+	}
+	for feature, count := range expected {
+		if report.Features[feature] != count {
+			t.Errorf("Expected %d uses of %s. Found %v", count, feature, report.Features)
+		}
+	}
+
+	// Opting out turns telemetry off.
+	envVars[helpers.TelemetryOptOutEnvVar] = "true"
+	_, settings, _ = controllers.InitApp(env.NewVarSet(env.WithMapLookup(envVars)), app)
+	if settings.Telemetry != nil {
+		t.Error("Expected telemetry to be off")
+	}
+}
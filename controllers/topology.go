@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gocraft/web"
+)
+
+// SpaceContext stores the session info and access token per user.
+// All routes within SpaceContext are about a space the user can see.
+type SpaceContext struct {
+	*SecureContext // Required.
+}
+
+// The types of the topology's nodes.
+const (
+	topologyApp             = "app"
+	topologyServiceInstance = "service_instance"
+	topologyRoute           = "route"
+	// topologyExternalApp is an app of another space that a network policy
+	// allows traffic from or to.
+	topologyExternalApp = "external_app"
+)
+
+// The types of the topology's edges.
+const (
+	topologyBinding       = "binding"
+	topologyRouteMapping  = "route"
+	topologyNetworkPolicy = "network_policy"
+)
+
+// topologyNode is an app, service instance or route of the space.
+type topologyNode struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	State string `json:"state,omitempty"`
+	// Service is the service offering of a managed service instance.
+	Service string `json:"service,omitempty"`
+	// Shared is true for service instances shared with the space by
+	// another space.
+	Shared bool `json:"shared,omitempty"`
+}
+
+// topologyEdge connects two nodes: an app to its bound service instances
+// and routes, or to the apps its network policies allow it to reach.
+type topologyEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Type   string `json:"type"`
+	// Protocol and Ports are those of network policies.
+	Protocol string `json:"protocol,omitempty"`
+	Ports    string `json:"ports,omitempty"`
+}
+
+// spaceTopology is the response of Topology.
+type spaceTopology struct {
+	SpaceGUID string         `json:"space_guid"`
+	Nodes     []topologyNode `json:"nodes"`
+	Edges     []topologyEdge `json:"edges"`
+	// Warnings are the parts of the topology that couldn't be loaded.
+	Warnings []string `json:"warnings"`
+}
+
+// spaceTopologyFields are the fields of Topology that can be selected.
+var spaceTopologyFields = fieldsOf(spaceTopology{})
+
+// ccSpaceSummary is a partial v2 CF API space summary.
+type ccSpaceSummary struct {
+	Apps []struct {
+		GUID   string `json:"guid"`
+		Name   string `json:"name"`
+		State  string `json:"state"`
+		Routes []struct {
+			GUID   string `json:"guid"`
+			Host   string `json:"host"`
+			Path   string `json:"path"`
+			Domain struct {
+				Name string `json:"name"`
+			} `json:"domain"`
+		} `json:"routes"`
+		ServiceNames []string `json:"service_names"`
+	} `json:"apps"`
+	Services []struct {
+		GUID        string `json:"guid"`
+		Name        string `json:"name"`
+		ServicePlan *struct {
+			Service struct {
+				Label string `json:"label"`
+			} `json:"service"`
+		} `json:"service_plan"`
+	} `json:"services"`
+}
+
+// ccNetworkPolicies is the response of the container networking policy API.
+type ccNetworkPolicies struct {
+	Policies []struct {
+		Source struct {
+			ID string `json:"id"`
+		} `json:"source"`
+		Destination struct {
+			ID       string `json:"id"`
+			Protocol string `json:"protocol"`
+			Ports    struct {
+				Start int `json:"start"`
+				End   int `json:"end"`
+			} `json:"ports"`
+		} `json:"destination"`
+	} `json:"policies"`
+}
+
+// Topology returns the space's apps, their bound service instances, including
+// the instances shared with the space, their routes and the container
+// network policies between apps, as a graph for the UI to draw. Everything is
+// looked up with the user's own token. The network policies are left out,
+// with a warning, when they can't be read.
+func (c *SpaceContext) Topology(rw web.ResponseWriter, req *web.Request) {
+	spaceGUID := req.PathParams["guid"]
+	var summary ccSpaceSummary
+	if err := c.ccRequest("GET", "/v2/spaces/"+url.PathEscape(spaceGUID)+"/summary", nil, &summary); err != nil {
+		if isCCNotFound(err) {
+			newUaaError(http.StatusNotFound, "unknown space.").writeTo(rw)
+			return
+		}
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	owned, err := c.ccGetAll("/v2/spaces/" + url.PathEscape(spaceGUID) + "/service_instances?return_user_provided_service_instances=true")
+	if err != nil {
+		newUaaError(http.StatusBadGateway, err.Error()).writeTo(rw)
+		return
+	}
+	isOwned := map[string]bool{}
+	for _, instance := range owned {
+		isOwned[instance.Metadata.GUID] = true
+	}
+
+	topology := spaceTopology{
+		SpaceGUID: spaceGUID,
+		Nodes:     []topologyNode{},
+		Edges:     []topologyEdge{},
+		Warnings:  []string{},
+	}
+	instances := map[string]string{}
+	for _, service := range summary.Services {
+		node := topologyNode{ID: service.GUID, Type: topologyServiceInstance, Name: service.Name, Shared: !isOwned[service.GUID]}
+		if service.ServicePlan != nil {
+			node.Service = service.ServicePlan.Service.Label
+		}
+		topology.Nodes = append(topology.Nodes, node)
+		instances[service.Name] = service.GUID
+	}
+	apps := map[string]bool{}
+	routes := map[string]bool{}
+	for _, app := range summary.Apps {
+		apps[app.GUID] = true
+		topology.Nodes = append(topology.Nodes, topologyNode{ID: app.GUID, Type: topologyApp, Name: app.Name, State: app.State})
+		for _, name := range app.ServiceNames {
+			if guid, ok := instances[name]; ok {
+				topology.Edges = append(topology.Edges, topologyEdge{Source: app.GUID, Target: guid, Type: topologyBinding})
+			}
+		}
+		for _, route := range app.Routes {
+			if !routes[route.GUID] {
+				routes[route.GUID] = true
+				topology.Nodes = append(topology.Nodes, topologyNode{ID: route.GUID, Type: topologyRoute, Name: routeURL(route.Host, route.Domain.Name, route.Path)})
+			}
+			topology.Edges = append(topology.Edges, topologyEdge{Source: app.GUID, Target: route.GUID, Type: topologyRouteMapping})
+		}
+	}
+
+	if len(apps) > 0 {
+		if err := c.addNetworkPolicies(&topology, apps); err != nil {
+			topology.Warnings = append(topology.Warnings, "network policies could not be loaded: "+err.Error())
+		}
+	}
+	c.writeAggregate(rw, req, spaceTopologyFields, topology)
+}
+
+// addNetworkPolicies adds the network policies from and to the apps, and the
+// apps of other spaces they connect to.
+func (c *SpaceContext) addNetworkPolicies(topology *spaceTopology, apps map[string]bool) error {
+	guids := make([]string, 0, len(apps))
+	for guid := range apps {
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+	var policies ccNetworkPolicies
+	if err := c.ccRequest("GET", "/networking/v1/external/policies?"+url.Values{"id": {strings.Join(guids, ",")}}.Encode(), nil, &policies); err != nil {
+		return err
+	}
+	external := map[string]bool{}
+	for _, policy := range policies.Policies {
+		for _, guid := range []string{policy.Source.ID, policy.Destination.ID} {
+			if !apps[guid] && !external[guid] {
+				external[guid] = true
+				topology.Nodes = append(topology.Nodes, topologyNode{ID: guid, Type: topologyExternalApp})
+			}
+		}
+		ports := policy.Destination.Ports
+		edge := topologyEdge{
+			Source:   policy.Source.ID,
+			Target:   policy.Destination.ID,
+			Type:     topologyNetworkPolicy,
+			Protocol: policy.Destination.Protocol,
+			Ports:    fmt.Sprint(ports.Start),
+		}
+		if ports.End > ports.Start {
+			edge.Ports += fmt.Sprintf("-%d", ports.End)
+		}
+		topology.Edges = append(topology.Edges, edge)
+	}
+	return nil
+}
+
+// routeURL is the URL of a route without its scheme, e.g.
+// app.example.com/path.
+func routeURL(host, domain, path string) string {
+	if host == "" {
+		return domain + path
+	}
+	return host + "." + domain + path
+}
@@ -0,0 +1,126 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestSpaceTopology(t *testing.T) {
+	policiesStatus := http.StatusOK
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/spaces/space-1/summary":
+			w.Write([]byte(`{"guid": "space-1", "apps": [
+				{"guid": "app-1", "name": "web", "state": "STARTED", "service_names": ["db", "cache"],
+				 "routes": [{"guid": "route-1", "host": "web", "path": "", "domain": {"name": "example.com"}}]},
+				{"guid": "app-2", "name": "worker", "state": "STOPPED", "service_names": ["db"], "routes": []}],
+				"services": [
+				{"guid": "si-1", "name": "db", "service_plan": {"service": {"label": "postgres"}}},
+				{"guid": "si-2", "name": "cache", "service_plan": null}]}`))
+		case "/v2/spaces/space-1/service_instances":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "si-1"}, "entity": {}}]}`))
+		case "/networking/v1/external/policies":
+			if r.URL.Query().Get("id") != "app-1,app-2" {
+				t.Errorf("Expected the policies of the space's apps. Found %s", r.URL)
+			}
+			w.WriteHeader(policiesStatus)
+			w.Write([]byte(`{"total_policies": 1, "policies": [
+				{"source": {"id": "app-1"}, "destination": {"id": "app-9", "protocol": "tcp", "ports": {"start": 8080, "end": 8090}}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, _ := CreateRouterWithMockSession(userTokenData, envVars)
+
+	response, request := NewTestRequest("GET", "/api/spaces/space-2/topology", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown space to be not found. Found %d", response.Code)
+	}
+
+	response, request = NewTestRequest("GET", "/api/spaces/space-1/topology", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+	var topology struct {
+		Nodes []struct {
+			ID      string `json:"id"`
+			Type    string `json:"type"`
+			Name    string `json:"name"`
+			Service string `json:"service"`
+			Shared  bool   `json:"shared"`
+		} `json:"nodes"`
+		Edges []struct {
+			Source string `json:"source"`
+			Target string `json:"target"`
+			Type   string `json:"type"`
+			Ports  string `json:"ports"`
+		} `json:"edges"`
+		Warnings []string `json:"warnings"`
+	}
+	json.NewDecoder(response.Body).Decode(&topology)
+	nodes := map[string]string{}
+	for _, node := range topology.Nodes {
+		nodes[node.ID] = node.Type + ":" + node.Name
+		if node.ID == "si-1" && (node.Shared || node.Service != "postgres") {
+			t.Errorf("Expected the space's own postgres instance. Found %+v", node)
+		}
+		if node.ID == "si-2" && !node.Shared {
+			t.Errorf("Expected the instance from another space to be shared. Found %+v", node)
+		}
+	}
+	expectedNodes := map[string]string{
+		"app-1":   "app:web",
+		"app-2":   "app:worker",
+		"si-1":    "service_instance:db",
+		"si-2":    "service_instance:cache",
+		"route-1": "route:web.example.com",
+		"app-9":   "external_app:",
+	}
+	for id, node := range expectedNodes {
+		if nodes[id] != node {
+			t.Errorf("Expected node %s to be %s. Found %q", id, node, nodes[id])
+		}
+	}
+	if len(topology.Nodes) != len(expectedNodes) {
+		t.Errorf("Unexpected nodes %+v", topology.Nodes)
+	}
+	edges := map[string]string{}
+	for _, edge := range topology.Edges {
+		edges[edge.Source+"->"+edge.Target] = edge.Type + edge.Ports
+	}
+	expectedEdges := map[string]string{
+		"app-1->si-1":    "binding",
+		"app-1->si-2":    "binding",
+		"app-2->si-1":    "binding",
+		"app-1->route-1": "route",
+		"app-1->app-9":   "network_policy8080-8090",
+	}
+	for edge, kind := range expectedEdges {
+		if edges[edge] != kind {
+			t.Errorf("Expected edge %s to be %s. Found %q", edge, kind, edges[edge])
+		}
+	}
+	if len(topology.Edges) != len(expectedEdges) || len(topology.Warnings) != 0 {
+		t.Errorf("Unexpected edges %+v, warnings %v", topology.Edges, topology.Warnings)
+	}
+
+	// The rest of the graph is shown when the policies can't be read.
+	policiesStatus = http.StatusForbidden
+	response, request = NewTestRequest("GET", "/api/spaces/space-1/topology", nil)
+	router.ServeHTTP(response, request)
+	topology.Warnings = nil
+	json.NewDecoder(response.Body).Decode(&topology)
+	if response.Code != http.StatusOK || len(topology.Warnings) != 1 {
+		t.Errorf("Expected a warning about the policies. Found %d, %v", response.Code, topology.Warnings)
+	}
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// tracedResponseWriter keeps the beginning of the response for a trace.
+type tracedResponseWriter struct {
+	web.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *tracedResponseWriter) Write(b []byte) (int, error) {
+	if room := helpers.MaxTracedBodyBytes + 1 - w.body.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		w.body.Write(b[:room])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// TraceMiddleware records the requests of the users being traced, and the
+// responses to them. Nothing is done while no one is traced.
+func (c *SecureContext) TraceMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	tracer := c.Settings.Tracer
+	start := time.Now()
+	if tracer == nil || !tracer.Any(start) {
+		next(rw, req)
+		return
+	}
+
+	var requestBody []byte
+	if req.Body != nil {
+		requestBody, _ = ioutil.ReadAll(io.LimitReader(req.Body, helpers.MaxTracedBodyBytes+1))
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(requestBody), req.Body), req.Body}
+	}
+	traced := &tracedResponseWriter{ResponseWriter: rw}
+	next(traced, req)
+
+	// The user is only known once the route's OAuth middleware has run.
+	userID := c.userID()
+	if userID == "" || !tracer.Traced(userID, start) {
+		return
+	}
+	tracer.Record(helpers.TraceEntry{
+		Time:           start.UTC(),
+		UserID:         userID,
+		RequestID:      c.requestID,
+		Method:         req.Method,
+		URL:            helpers.SanitizeTraceURL(req.URL),
+		RequestHeaders: helpers.SanitizeTraceHeaders(req.Header),
+		RequestBody: helpers.SanitizeTraceBody(req.Header.Get("Content-Type"), requestBody,
+			len(requestBody) > helpers.MaxTracedBodyBytes),
+		Status:          rw.StatusCode(),
+		ResponseHeaders: helpers.SanitizeTraceHeaders(rw.Header()),
+		ResponseBody: helpers.SanitizeTraceBody(rw.Header().Get("Content-Type"), traced.body.Bytes(),
+			traced.body.Len() > helpers.MaxTracedBodyBytes),
+		DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+	})
+}
+
+// traceRequest is the body of a request to trace a user.
+type traceRequest struct {
+	UserID string `json:"user_id"`
+	// Minutes is how long to trace the user for. Defaults to 15.
+	Minutes int `json:"minutes"`
+}
+
+// Traces lists the users being traced.
+func (c *AdminContext) Traces(rw web.ResponseWriter, req *web.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(c.Settings.Tracer.Targets(time.Now()))
+}
+
+// StartTrace records the sanitized requests and responses of a user for a
+// while, to debug their problem without debug logs for everyone.
+func (c *AdminContext) StartTrace(rw web.ResponseWriter, req *web.Request) {
+	var body traceRequest
+	if err := readBodyToStruct(req.Body, &body); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	duration := helpers.DefaultTraceDuration
+	if body.Minutes != 0 {
+		duration = time.Duration(body.Minutes) * time.Minute
+	}
+	if body.UserID == "" || duration <= 0 || duration > helpers.MaxTraceDuration {
+		newUaaError(http.StatusBadRequest, fmt.Sprintf("a user_id is required, and minutes must be between 1 and %d.",
+			helpers.MaxTraceDuration/time.Minute)).writeTo(rw)
+		return
+	}
+	until := time.Now().Add(duration)
+	c.Settings.Tracer.Enable(body.UserID, until)
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "start_trace", body)
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(helpers.TraceTarget{UserID: body.UserID, Until: until})
+}
+
+// Trace returns the traced requests of a user, oldest first.
+func (c *AdminContext) Trace(rw web.ResponseWriter, req *web.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(c.Settings.Tracer.Entries(req.PathParams["user_id"]))
+}
+
+// StopTrace stops tracing a user before the trace expires.
+func (c *AdminContext) StopTrace(rw web.ResponseWriter, req *web.Request) {
+	userID := req.PathParams["user_id"]
+	if !c.Settings.Tracer.Disable(userID) {
+		newUaaError(http.StatusNotFound, "this user is not traced.").writeTo(rw)
+		return
+	}
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "stop_trace", struct {
+		UserID string `json:"user_id"`
+	}{userID})
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,60 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestTrace(t *testing.T) {
+	router, _ := CreateRouterWithMockSession(adminTokenData, GetMockCompleteEnvVars())
+
+	response, request := NewTestRequest("POST", "/admin/traces", []byte(`{"user_id": "admin-guid", "minutes": 500}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected code %d. Found %d", http.StatusBadRequest, response.Code)
+	}
+
+	response, request = NewTestRequest("POST", "/admin/traces", []byte(`{"user_id": "admin-guid", "minutes": 5}`))
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusOK, response.Code, response.Body.String())
+	}
+
+	response, request = NewTestRequest("PUT", "/api/me/preferences?token=abc", []byte(`{"locale": "fr", "timezone": "UTC"}`))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(response, request)
+
+	response, request = NewTestRequest("GET", "/admin/traces/admin-guid", nil)
+	router.ServeHTTP(response, request)
+	var entries []helpers.TraceEntry
+	json.NewDecoder(response.Body).Decode(&entries)
+	var traced *helpers.TraceEntry
+	for i := range entries {
+		if strings.HasPrefix(entries[i].URL, "/api/me/preferences") {
+			traced = &entries[i]
+		}
+	}
+	if traced == nil {
+		t.Fatalf("Expected the preferences update to be traced, found %+v", entries)
+	}
+	if traced.Method != "PUT" || traced.Status != http.StatusOK || !strings.Contains(traced.RequestBody, `"locale":"fr"`) ||
+		!strings.Contains(traced.ResponseBody, `"locale":"fr"`) || strings.Contains(traced.URL, "abc") {
+		t.Errorf("Unexpected entry %+v", traced)
+	}
+
+	response, request = NewTestRequest("DELETE", "/admin/traces/admin-guid", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNoContent {
+		t.Errorf("Expected code %d. Found %d", http.StatusNoContent, response.Code)
+	}
+	response, request = NewTestRequest("DELETE", "/admin/traces/admin-guid", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected code %d. Found %d", http.StatusNotFound, response.Code)
+	}
+}
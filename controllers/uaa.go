@@ -11,12 +11,19 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"time"
 
 	"github.com/gocraft/web"
 
 	uuid "github.com/satori/go.uuid"
+
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/logging"
+	"github.com/18F/cg-dashboard/mailer"
 )
 
+var inviteLog = logging.New("invites")
+
 // UAAContext stores the session info and access token per user.
 // All routes within UAAContext represent the routes to the UAA service.
 type UAAContext struct {
@@ -69,7 +76,8 @@ func readBodyToStruct(rawBody io.ReadCloser, obj interface{}) *UaaError {
 // UaaError contains metadata for a particular UAA error.
 type UaaError struct {
 	statusCode int
-	err        []byte
+	data       string
+	proxyData  string
 }
 
 func newUaaError(statusCode int, data string) *UaaError {
@@ -77,31 +85,37 @@ func newUaaError(statusCode int, data string) *UaaError {
 }
 
 func newUaaErrorWithProxyData(statusCode int, data, proxyData string) *UaaError {
-	jb, err := json.Marshal(struct {
-		Status    string `json:"status"`
-		Data      string `json:"data"`
-		ProxyData string `json:"proxy-data,omitempty"`
-	}{
-		Status:    "failure",
-		Data:      data,
-		ProxyData: proxyData,
-	})
-	if err != nil {
-		// If we get here, we're having a really bad day
-		return &UaaError{
-			statusCode: statusCode,
-			err:        []byte("cannot marshal proper error"),
-		}
-	}
 	return &UaaError{
 		statusCode: statusCode,
-		err:        jb,
+		data:       data,
+		proxyData:  proxyData,
 	}
 }
 
 func (e *UaaError) writeTo(rw http.ResponseWriter) {
+	body := struct {
+		Status      string `json:"status"`
+		Data        string `json:"data"`
+		ProxyData   string `json:"proxy-data,omitempty"`
+		RequestID   string `json:"request_id,omitempty"`
+		CFRequestID string `json:"cf_request_id,omitempty"`
+	}{
+		Status:    "failure",
+		Data:      e.data,
+		ProxyData: e.proxyData,
+	}
+	// Server errors come with the request IDs for users to give support.
+	if e.statusCode >= 500 {
+		body.RequestID = rw.Header().Get(requestIDHeader)
+		body.CFRequestID = rw.Header().Get(cfRequestIDHeader)
+	}
+	jb, err := json.Marshal(body)
+	if err != nil {
+		// If we get here, we're having a really bad day
+		jb = []byte("cannot marshal proper error")
+	}
 	rw.WriteHeader(e.statusCode)
-	rw.Write(e.err)
+	rw.Write(jb)
 }
 
 type inviteUAAUserRequest struct {
@@ -221,45 +235,17 @@ func (c *UAAContext) InviteUserToOrg(rw web.ResponseWriter, req *web.Request) {
 		return
 	}
 
-	var getUserResp GetUAAUserResponse
-	getUserResp, err = c.GetUAAUserByEmail(inviteUserToOrgRequest.Email)
-	if err != nil {
-		err.writeTo(rw)
+	email, checkErr := c.Settings.Invites.CheckAddress(inviteUserToOrgRequest.Email)
+	if checkErr != nil {
+		helpers.RecordInvite(helpers.InviteRejected)
+		newUaaError(http.StatusBadRequest, checkErr.Error()+".").writeTo(rw)
 		return
 	}
-	if !getUserResp.Verified {
-		// Try to invite the user to UAA.
-		inviteResponse, err := c.InviteUAAuser(inviteUserToOrgRequest)
-		if err != nil {
-			err.writeTo(rw)
-			return
-		}
-
-		// If we don't have a successful invite, we return an error.
-		if len(inviteResponse.NewInvites) < 1 {
-			newUaaError(http.StatusInternalServerError, "no successful invites created.").writeTo(rw)
-			return
-		}
-		userInvite := inviteResponse.NewInvites[0]
 
-		// Next try to create the user in CF
-		err = c.CreateCFuser(userInvite)
-		if err != nil {
-			err.writeTo(rw)
-			return
-		}
-
-		// Trigger the e-mail invite.
-		err = c.TriggerInvite(inviteEmailRequest{
-			Email:     userInvite.Email,
-			InviteURL: userInvite.InviteLink,
-		})
-		if err != nil {
-			err.writeTo(rw)
-			return
-		}
-		// Set the user info that get from the newly invited user.
-		getUserResp.ID = userInvite.UserID
+	getUserResp, err := c.inviteUser(req.Request, email)
+	if err != nil {
+		err.writeTo(rw)
+		return
 	}
 
 	rw.WriteHeader(http.StatusOK)
@@ -274,6 +260,75 @@ func (c *UAAContext) InviteUserToOrg(rw web.ResponseWriter, req *web.Request) {
 	})
 }
 
+// inviteUser invites the user of the e-mail in both UAA and CF, and sends
+// them the e-mail, unless they already are a verified user. req is the
+// request the invite was asked for in.
+func (c *UAAContext) inviteUser(req *http.Request, email string) (getUserResp GetUAAUserResponse, err *UaaError) {
+	defer func() {
+		switch {
+		case err != nil:
+			helpers.RecordInvite(helpers.InviteFailed)
+		case getUserResp.Verified:
+			helpers.RecordInvite(helpers.InviteExisting)
+		default:
+			helpers.RecordInvite(helpers.InviteInvited)
+		}
+	}()
+	getUserResp, err = c.GetUAAUserByEmail(email)
+	if err != nil {
+		return
+	}
+	if getUserResp.Verified {
+		return
+	}
+	// Try to invite the user to UAA.
+	inviteResponse, err := c.InviteUAAuser(InviteUserToOrgRequest{Email: email})
+	if err != nil {
+		return
+	}
+
+	// If we don't have a successful invite, we return an error.
+	if len(inviteResponse.NewInvites) < 1 {
+		err = newUaaError(http.StatusInternalServerError, "no successful invites created.")
+		return
+	}
+	userInvite := inviteResponse.NewInvites[0]
+	// The user exists in UAA from here on, so the invite is audited even if
+	// it can't be completed.
+	defer func() {
+		details := struct {
+			UserGUID string `json:"user_guid"`
+			Error    string `json:"error,omitempty"`
+		}{UserGUID: userInvite.UserID}
+		if err != nil {
+			details.Error = err.data
+		}
+		c.Settings.AdminAudit.Record(req, c.userID(), "invite_user", email, details)
+	}()
+
+	// Next try to create the user in CF
+	if err = c.CreateCFuser(userInvite); err != nil {
+		return
+	}
+
+	// Trigger the e-mail invite.
+	err = c.TriggerInvite(inviteEmailRequest{
+		Email:     userInvite.Email,
+		InviteURL: userInvite.InviteLink,
+	})
+	if err != nil {
+		return
+	}
+	// Set the user info that get from the newly invited user.
+	getUserResp.ID = userInvite.UserID
+	if c.Settings.PendingInvites != nil {
+		if recordErr := c.Settings.PendingInvites.RecordInvite(userInvite.UserID, time.Now()); recordErr != nil {
+			c.logger(inviteLog).Warnf("unable to record the invite of %s: %v", userInvite.UserID, recordErr)
+		}
+	}
+	return
+}
+
 // ListUAAUserResponse is the response representation of the User list query.
 // https://docs.cloudfoundry.org/api/uaa/#list63
 type ListUAAUserResponse struct {
@@ -331,12 +386,17 @@ func (c *UAAContext) TriggerInvite(inviteReq inviteEmailRequest) *UaaError {
 	if inviteReq.Email == "" || inviteReq.InviteURL == "" {
 		return newUaaError(http.StatusBadRequest, "Missing correct params.")
 	}
-	emailHTML := new(bytes.Buffer)
-	tplErr := c.templates.GetInviteEmail(emailHTML, inviteReq.InviteURL)
+	emailHTML, emailText := new(bytes.Buffer), new(bytes.Buffer)
+	branding := helpers.NewEmailBranding(c.theme(), c.Settings.AppURL)
+	tplErr := c.templates.GetInviteEmail(emailHTML, emailText, inviteReq.InviteURL, branding)
 	if tplErr != nil {
 		return newUaaError(http.StatusInternalServerError, tplErr.Error())
 	}
-	emailErr := c.mailer.SendEmail(inviteReq.Email, "Invitation to join cloud.gov", emailHTML.Bytes())
+	emailErr := c.mailer.SendMultipartEmail(inviteReq.Email, "Invitation to join "+branding.ProductName,
+		emailHTML.Bytes(), emailText.Bytes())
+	if emailErr == mailer.ErrQueueFull {
+		return newUaaError(http.StatusServiceUnavailable, emailErr.Error())
+	}
 	if emailErr != nil {
 		return newUaaError(http.StatusInternalServerError, emailErr.Error())
 	}
@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"github.com/18F/cg-dashboard/controllers"
+	"github.com/18F/cg-dashboard/helpers"
 	. "github.com/18F/cg-dashboard/helpers/testhelpers"
 
 	"fmt"
@@ -212,6 +213,79 @@ func TestInviteUsers(t *testing.T) {
 	}
 }
 
+func bulkInviteEnvVars() map[string]string {
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.InviteAllowedDomainsEnvVar] = "example.com"
+	envVars[helpers.InviteRateEnvVar] = "0"
+	return envVars
+}
+
+var bulkInviteTests = []BasicProxyTest{
+	{
+		BasicSecureTest: BasicSecureTest{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "UAA Bulk Invite without e-mails",
+				SessionData: ValidTokenData,
+				EnvVars:     bulkInviteEnvVars(),
+			},
+			ExpectedResponse: NewJSONResponseContentTester(`{"status": "failure", "data": "no e-mails to invite."}`),
+			ExpectedCode:     http.StatusBadRequest,
+		},
+		RequestMethod: "POST",
+		RequestPath:   "/uaa/invite/bulk",
+		RequestBody:   []byte(`[]`),
+	},
+	{
+		BasicSecureTest: BasicSecureTest{
+			BasicConsoleUnitTest: BasicConsoleUnitTest{
+				TestName:    "UAA Bulk Invite with an allowed and a rejected e-mail",
+				SessionData: ValidTokenData,
+				EnvVars:     bulkInviteEnvVars(),
+			},
+			ExpectedResponse: NewJSONResponseContentTester(fmt.Sprintf(`{"status": "partial", "invited": 1, "existing": 0, "failed": 1, "results": [
+				{"email": "test@example.com", "status": "invited", "userGuid": "%s"},
+				{"email": "someone@example.org", "status": "rejected", "error": "users from example.org can't be invited"}]}`, testUserGUID)),
+			ExpectedCode: http.StatusOK,
+		},
+		RequestMethod: "POST",
+		RequestPath:   "/uaa/invite/bulk",
+		RequestBody:   []byte(`{"emails": ["test@example.com", "someone@example.org", "TEST@example.com"]}`),
+		Handlers: []Handler{
+			{
+				RequestMethod: "POST",
+				ExpectedPath:  "/invite_users?redirect_uri=https%3A%2F%2Fhostname",
+				Response:      fmt.Sprintf("{\"new_invites\": [{\"email\": \"test@example.com\", \"userId\": \"%s\", \"inviteLink\": \"http://some.link\"}]}", testUserGUID),
+				ResponseCode:  http.StatusOK,
+			},
+			{
+				RequestMethod: "GET",
+				ExpectedPath:  "/Users?filter=email+eq+%22test%40example.com%22",
+				ResponseCode:  http.StatusOK,
+				Response:      "{\"resources\": []}",
+			},
+			{
+				RequestMethod: "POST",
+				ExpectedPath:  "/v2/users",
+				ResponseCode:  http.StatusCreated,
+			},
+		},
+	},
+}
+
+func TestBulkInviteUsers(t *testing.T) {
+	for _, tt := range bulkInviteTests {
+		t.Run(tt.BasicSecureTest.TestName, func(t *testing.T) {
+			testServer := CreateExternalServerForPrivileged(t, tt)
+			defer testServer.Close()
+			fullURL := fmt.Sprintf("%s%s", testServer.URL, tt.RequestPath)
+			c := &controllers.UAAContext{SecureContext: &controllers.SecureContext{Context: &controllers.Context{}}}
+			response, request, router := PrepareExternalServerCall(t, c.SecureContext, testServer, fullURL, tt)
+			router.ServeHTTP(response, request)
+			VerifyExternalCallResponse(t, response, &tt)
+		})
+	}
+}
+
 var uaainfoTests = []BasicProxyTest{
 	{
 		BasicSecureTest: BasicSecureTest{
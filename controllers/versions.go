@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+// versionETag is the ETag of a version of a dashboard-managed resource.
+func versionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// expectedVersion returns the version of a dashboard-managed resource an
+// update was made against: the one in the If-Match header if any, else the
+// one in the body. If-Match: * updates whatever the current version is. An
+// If-Match that isn't one of our ETags can't match, so it's a conflict.
+func expectedVersion(req *web.Request, bodyVersion int) (int, *UaaError) {
+	ifMatch := strings.TrimSpace(req.Header.Get("If-Match"))
+	if ifMatch == "" {
+		if bodyVersion < 0 {
+			return 0, newUaaError(http.StatusBadRequest, "the version must not be negative.")
+		}
+		return bodyVersion, nil
+	}
+	if ifMatch == "*" {
+		return db.AnyVersion, nil
+	}
+	tag := strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+	version, err := strconv.Atoi(tag)
+	if err != nil || version < 0 {
+		return 0, newVersionConflictError()
+	}
+	return version, nil
+}
+
+// newVersionConflictError is the error for an update made against a version
+// that's no longer the current one, for example because another admin saved
+// their changes first.
+func newVersionConflictError() *UaaError {
+	return newUaaError(http.StatusPreconditionFailed,
+		"this was changed by someone else since you loaded it. Reload it and make your changes again.")
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/gocraft/web"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// webhookDeliveriesLimit is how many deliveries of a webhook are shown.
+const webhookDeliveriesLimit = 100
+
+// WebhookContext stores the session info and access token per user.
+// All routes within WebhookContext let org managers subscribe webhooks to
+// the events of their orgs.
+type WebhookContext struct {
+	*SecureContext // Required.
+}
+
+// webhookBody is the body to subscribe a webhook.
+type webhookBody struct {
+	OrgGUID string   `json:"org_guid"`
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+}
+
+// writeWebhookJSON responds with the subscriptions or deliveries.
+func writeWebhookJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
+
+// requireOrgManager responds with an error and returns false unless the user
+// manages the org.
+func (c *WebhookContext) requireOrgManager(rw http.ResponseWriter, orgGUID string) bool {
+	ok, uaaErr := c.managesOrg(orgGUID)
+	if uaaErr != nil {
+		uaaErr.writeTo(rw)
+		return false
+	}
+	if !ok {
+		newUaaError(http.StatusForbidden, "only org managers can manage the org's webhooks.").writeTo(rw)
+		return false
+	}
+	return true
+}
+
+// webhookForManager gets the webhook of the path and checks the user manages
+// its org. It responds with an error and returns false otherwise.
+func (c *WebhookContext) webhookForManager(rw web.ResponseWriter, req *web.Request) (db.WebhookSubscription, bool) {
+	webhook, err := c.Settings.Webhooks.Store.Webhook(req.PathParams["id"])
+	if err == db.ErrWebhookNotFound {
+		newUaaError(http.StatusNotFound, err.Error()+".").writeTo(rw)
+		return webhook, false
+	}
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return webhook, false
+	}
+	return webhook, c.requireOrgManager(rw, webhook.OrgGUID)
+}
+
+// List returns the webhooks of the org of ?org_guid=, without their secrets.
+func (c *WebhookContext) List(rw web.ResponseWriter, req *web.Request) {
+	orgGUID := req.URL.Query().Get("org_guid")
+	if orgGUID == "" {
+		newUaaError(http.StatusBadRequest, "org_guid is required.").writeTo(rw)
+		return
+	}
+	if !c.requireOrgManager(rw, orgGUID) {
+		return
+	}
+	webhooks, err := c.Settings.Webhooks.Store.Webhooks(orgGUID)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	if webhooks == nil {
+		webhooks = []db.WebhookSubscription{}
+	}
+	writeWebhookJSON(rw, http.StatusOK, webhooks)
+}
+
+// Create subscribes a webhook to events of an org the user manages. The
+// response has the secret the deliveries are signed with; it's not shown
+// again.
+func (c *WebhookContext) Create(rw web.ResponseWriter, req *web.Request) {
+	var body webhookBody
+	if err := readBodyToStruct(req.Body, &body); err != nil {
+		err.writeTo(rw)
+		return
+	}
+	webhook := db.WebhookSubscription{
+		OrgGUID:   body.OrgGUID,
+		URL:       body.URL,
+		Events:    body.Events,
+		CreatedBy: c.userID(),
+	}
+	if err := webhook.Validate(helpers.WebhookEvents); err != nil {
+		newUaaError(http.StatusBadRequest, err.Error()+".").writeTo(rw)
+		return
+	}
+	if !c.requireOrgManager(rw, webhook.OrgGUID) {
+		return
+	}
+	secret, err := helpers.NewWebhookSecret()
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	webhook.Secret = secret
+	webhook, err = c.Settings.Webhooks.Store.CreateWebhook(webhook)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	audited := webhook
+	audited.Secret = ""
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "create_webhook", audited)
+	writeWebhookJSON(rw, http.StatusCreated, webhook)
+}
+
+// Delete unsubscribes the webhook.
+func (c *WebhookContext) Delete(rw web.ResponseWriter, req *web.Request) {
+	webhook, ok := c.webhookForManager(rw, req)
+	if !ok {
+		return
+	}
+	if err := c.Settings.Webhooks.Store.DeleteWebhook(webhook.ID); err != nil && err != db.ErrWebhookNotFound {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	webhook.Secret = ""
+	c.Settings.RecordAuditEvent(req.Request, c.userID(), "delete_webhook", webhook)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// Deliveries returns the latest deliveries of the webhook, newest first.
+func (c *WebhookContext) Deliveries(rw web.ResponseWriter, req *web.Request) {
+	webhook, ok := c.webhookForManager(rw, req)
+	if !ok {
+		return
+	}
+	deliveries, err := c.Settings.Webhooks.Store.Deliveries(webhook.ID, webhookDeliveriesLimit)
+	if err != nil {
+		newUaaError(http.StatusInternalServerError, err.Error()).writeTo(rw)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []db.WebhookDelivery{}
+	}
+	writeWebhookJSON(rw, http.StatusOK, deliveries)
+}
+
+// orgUserPathPattern matches the CF API path adding a user to an org.
+var orgUserPathPattern = regexp.MustCompile(`^/v2/organizations/([^/]+)/users/([^/]+)$`)
+
+// orgUserAdded is the data of the org.user_added event.
+type orgUserAdded struct {
+	UserGUID string `json:"user_guid"`
+	// Role is the role the user was added for, when it was requested.
+	Role    string `json:"role,omitempty"`
+	AddedBy string `json:"added_by"`
+}
+
+// publishUserAdded publishes the org.user_added event. Adding the user
+// stands even if the event can't be published.
+func (c *SecureContext) publishUserAdded(orgGUID, userGUID, role string) {
+	err := c.Settings.Webhooks.Publish(helpers.WebhookOrgUserAdded, orgGUID, orgUserAdded{
+		UserGUID: userGUID,
+		Role:     role,
+		AddedBy:  c.userID(),
+	})
+	if err != nil {
+		c.logger(notifyLog).Errorf("could not publish the user %s added to org %s: %v", userGUID, orgGUID, err)
+	}
+}
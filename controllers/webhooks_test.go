@@ -0,0 +1,98 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+	. "github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestWebhooks(t *testing.T) {
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/users/admin-guid/managed_organizations":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "org-1"}}]}`))
+		case "/v2/users/user-guid/managed_organizations":
+			w.Write([]byte(`{"next_url": null, "resources": []}`))
+		default:
+			t.Errorf("Unexpected CC request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cc.Close()
+	envVars := GetMockCompleteEnvVars()
+	envVars[helpers.APIURLEnvVar] = cc.URL
+	router, store := CreateRouterWithMockSession(userTokenData, envVars)
+
+	body := []byte(`{"org_guid": "org-1", "url": "https://hooks.example.com/cg", "events": ["org.user_added"]}`)
+	response, request := NewTestRequest("POST", "/api/webhooks", body)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected only org managers to subscribe webhooks. Found %d", response.Code)
+	}
+
+	switchSession(store, adminTokenData)
+	for _, invalid := range []string{
+		`{"org_guid": "org-1", "url": "http://hooks.example.com/cg", "events": ["org.user_added"]}`,
+		`{"org_guid": "org-1", "url": "https://hooks.example.com/cg", "events": ["org.deleted"]}`,
+		`{"org_guid": "org-1", "url": "https://hooks.example.com/cg", "events": []}`,
+	} {
+		response, request = NewTestRequest("POST", "/api/webhooks", []byte(invalid))
+		router.ServeHTTP(response, request)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be refused. Found %d", invalid, response.Code)
+		}
+	}
+
+	response, request = NewTestRequest("POST", "/api/webhooks", body)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected code %d. Found %d: %s", http.StatusCreated, response.Code, response.Body.String())
+	}
+	var created struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	json.NewDecoder(response.Body).Decode(&created)
+	if created.ID == "" || created.Secret == "" {
+		t.Fatalf("Expected the webhook and its secret. Found %+v", created)
+	}
+
+	response, request = NewTestRequest("GET", "/api/webhooks?org_guid=org-1", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), created.ID) {
+		t.Errorf("Expected the org's webhooks. Found %d: %s", response.Code, response.Body.String())
+	}
+	if strings.Contains(response.Body.String(), created.Secret) {
+		t.Error("Expected the secret to be shown only once")
+	}
+
+	response, request = NewTestRequest("GET", "/api/webhooks/"+created.ID+"/deliveries", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK || strings.TrimSpace(response.Body.String()) != "[]" {
+		t.Errorf("Expected no deliveries. Found %d: %s", response.Code, response.Body.String())
+	}
+
+	switchSession(store, userTokenData)
+	response, request = NewTestRequest("DELETE", "/api/webhooks/"+created.ID, nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected only org managers to unsubscribe webhooks. Found %d", response.Code)
+	}
+
+	switchSession(store, adminTokenData)
+	response, request = NewTestRequest("DELETE", "/api/webhooks/"+created.ID, nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNoContent {
+		t.Errorf("Expected code %d. Found %d", http.StatusNoContent, response.Code)
+	}
+	response, request = NewTestRequest("GET", "/api/webhooks/"+created.ID+"/deliveries", nil)
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected the webhook to be gone. Found %d", response.Code)
+	}
+}
@@ -0,0 +1,344 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The statuses of an access review.
+const (
+	AccessReviewOpen      = "open"
+	AccessReviewCompleted = "completed"
+)
+
+// The decisions on a member of an org under review.
+const (
+	AccessConfirm = "confirm"
+	AccessRevoke  = "revoke"
+)
+
+var (
+	// ErrAccessReviewNotFound is returned for an unknown access review.
+	ErrAccessReviewNotFound = errors.New("access review not found")
+	// ErrAccessReviewCompleted is returned when deciding on a review that
+	// was already completed.
+	ErrAccessReviewCompleted = errors.New("access review was already completed")
+)
+
+// AccessReviewEntry is a member of the org under review, with their org
+// roles, and the decision of the org's managers on them.
+type AccessReviewEntry struct {
+	UserID    string     `json:"user_id"`
+	Username  string     `json:"username"`
+	Roles     []string   `json:"roles"`
+	Decision  string     `json:"decision,omitempty"`
+	DecidedBy string     `json:"decided_by,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+}
+
+// AccessReview is the review of an org's members by its managers, in a
+// campaign reviewing all the orgs at once. It's completed once every member
+// is confirmed or revoked.
+type AccessReview struct {
+	ID          string              `json:"id"`
+	Campaign    string              `json:"campaign"`
+	OrgGUID     string              `json:"org_guid"`
+	OrgName     string              `json:"org_name"`
+	Status      string              `json:"status"`
+	CreatedAt   time.Time           `json:"created_at"`
+	DueAt       time.Time           `json:"due_at"`
+	CompletedAt *time.Time          `json:"completed_at,omitempty"`
+	Entries     []AccessReviewEntry `json:"entries"`
+}
+
+// AccessReviewDecision is a decision on a member of the org under review.
+type AccessReviewDecision struct {
+	UserID   string `json:"user_id"`
+	Decision string `json:"decision"`
+}
+
+// Validate checks the decisions are on members of the review.
+func (r AccessReview) Validate(decisions []AccessReviewDecision) error {
+	if len(decisions) == 0 {
+		return errors.New("decisions are required")
+	}
+	for _, d := range decisions {
+		if d.Decision != AccessConfirm && d.Decision != AccessRevoke {
+			return fmt.Errorf("%q is not a decision, expected confirm or revoke", d.Decision)
+		}
+		if r.entry(d.UserID) == nil {
+			return fmt.Errorf("user %q is not under review", d.UserID)
+		}
+	}
+	return nil
+}
+
+func (r AccessReview) entry(userID string) *AccessReviewEntry {
+	for i := range r.Entries {
+		if r.Entries[i].UserID == userID {
+			return &r.Entries[i]
+		}
+	}
+	return nil
+}
+
+// decide records the decisions, and completes the review once every member
+// is decided on.
+func (r *AccessReview) decide(decisions []AccessReviewDecision, decidedBy string) error {
+	if r.Status != AccessReviewOpen {
+		return ErrAccessReviewCompleted
+	}
+	if err := r.Validate(decisions); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, d := range decisions {
+		e := r.entry(d.UserID)
+		e.Decision, e.DecidedBy, e.DecidedAt = d.Decision, decidedBy, &now
+	}
+	for _, e := range r.Entries {
+		if e.Decision == "" {
+			return nil
+		}
+	}
+	r.Status, r.CompletedAt = AccessReviewCompleted, &now
+	return nil
+}
+
+// AccessReviewFilter selects access reviews. Empty fields match all.
+type AccessReviewFilter struct {
+	Campaign string
+	OrgGUID  string
+	Status   string
+	// Limit is how many reviews are returned at most, or all when 0.
+	Limit int
+}
+
+func (f AccessReviewFilter) matches(r AccessReview) bool {
+	return (f.Campaign == "" || f.Campaign == r.Campaign) &&
+		(f.OrgGUID == "" || f.OrgGUID == r.OrgGUID) &&
+		(f.Status == "" || f.Status == r.Status)
+}
+
+// AccessReviewStore keeps the access reviews.
+type AccessReviewStore interface {
+	// CreateAccessReview keeps a new open review and returns it with its
+	// ID.
+	CreateAccessReview(r AccessReview) (AccessReview, error)
+	// AccessReview returns the review, or ErrAccessReviewNotFound.
+	AccessReview(id string) (AccessReview, error)
+	// AccessReviews returns the reviews matching the filter, newest first.
+	AccessReviews(filter AccessReviewFilter) ([]AccessReview, error)
+	// DecideAccessReview records the decisions on members of an open
+	// review, or returns ErrAccessReviewCompleted if it's not open anymore.
+	DecideAccessReview(id string, decisions []AccessReviewDecision, decidedBy string) (AccessReview, error)
+}
+
+// newAccessReview sets the ID, status and creation time of a new review.
+func newAccessReview(r AccessReview) (AccessReview, error) {
+	id, err := newID()
+	if err != nil {
+		return AccessReview{}, err
+	}
+	r.ID, r.Status, r.CreatedAt, r.CompletedAt = id, AccessReviewOpen, time.Now().UTC(), nil
+	if r.Entries == nil {
+		r.Entries = []AccessReviewEntry{}
+	}
+	return r, nil
+}
+
+// SQLAccessReviewStore keeps the access reviews in the database.
+type SQLAccessReviewStore struct {
+	DB *sql.DB
+}
+
+const accessReviewColumns = `id, campaign, org_guid, org_name, status, created_at, due_at, completed_at, entries`
+
+func scanAccessReview(row interface {
+	Scan(dest ...interface{}) error
+}) (AccessReview, error) {
+	var (
+		r       AccessReview
+		entries []byte
+	)
+	if err := row.Scan(&r.ID, &r.Campaign, &r.OrgGUID, &r.OrgName, &r.Status, &r.CreatedAt, &r.DueAt,
+		&r.CompletedAt, &entries); err != nil {
+		return AccessReview{}, err
+	}
+	return r, json.Unmarshal(entries, &r.Entries)
+}
+
+// CreateAccessReview keeps a new open review.
+func (s *SQLAccessReviewStore) CreateAccessReview(r AccessReview) (AccessReview, error) {
+	r, err := newAccessReview(r)
+	if err != nil {
+		return AccessReview{}, err
+	}
+	entries, err := json.Marshal(r.Entries)
+	if err != nil {
+		return AccessReview{}, err
+	}
+	_, err = s.DB.Exec(`INSERT INTO access_reviews (`+accessReviewColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULL, $8)`,
+		r.ID, r.Campaign, r.OrgGUID, r.OrgName, r.Status, r.CreatedAt, r.DueAt, entries)
+	return r, err
+}
+
+// AccessReview returns the review.
+func (s *SQLAccessReviewStore) AccessReview(id string) (AccessReview, error) {
+	r, err := scanAccessReview(s.DB.QueryRow(`SELECT `+accessReviewColumns+` FROM access_reviews WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return AccessReview{}, ErrAccessReviewNotFound
+	}
+	return r, err
+}
+
+// AccessReviews returns the reviews matching the filter, newest first.
+func (s *SQLAccessReviewStore) AccessReviews(filter AccessReviewFilter) ([]AccessReview, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	for _, f := range []struct{ column, value string }{
+		{"campaign", filter.Campaign},
+		{"org_guid", filter.OrgGUID},
+		{"status", filter.Status},
+	} {
+		if f.value != "" {
+			args = append(args, f.value)
+			where = append(where, fmt.Sprintf("%s = $%d", f.column, len(args)))
+		}
+	}
+	query := `SELECT ` + accessReviewColumns + ` FROM access_reviews`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY created_at DESC`
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reviews := []AccessReview{}
+	for rows.Next() {
+		r, err := scanAccessReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, r)
+	}
+	return reviews, rows.Err()
+}
+
+// DecideAccessReview records the decisions. The review is locked while
+// they're recorded, so concurrent decisions by several managers are all
+// kept.
+func (s *SQLAccessReviewStore) DecideAccessReview(id string, decisions []AccessReviewDecision, decidedBy string) (AccessReview, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return AccessReview{}, err
+	}
+	r, err := scanAccessReview(tx.QueryRow(`SELECT `+accessReviewColumns+` FROM access_reviews WHERE id = $1 FOR UPDATE`, id))
+	if err == sql.ErrNoRows {
+		err = ErrAccessReviewNotFound
+	}
+	if err == nil {
+		err = r.decide(decisions, decidedBy)
+	}
+	var entries []byte
+	if err == nil {
+		entries, err = json.Marshal(r.Entries)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE access_reviews SET status = $2, completed_at = $3, entries = $4 WHERE id = $1`,
+			r.ID, r.Status, r.CompletedAt, entries)
+	}
+	if err != nil {
+		tx.Rollback()
+		return AccessReview{}, err
+	}
+	return r, tx.Commit()
+}
+
+// MemoryAccessReviewStore keeps the access reviews in memory. It's used when
+// no database is configured, so they're lost when the app restarts.
+type MemoryAccessReviewStore struct {
+	mu      sync.Mutex
+	reviews map[string]AccessReview
+}
+
+// CreateAccessReview keeps a new open review.
+func (s *MemoryAccessReviewStore) CreateAccessReview(r AccessReview) (AccessReview, error) {
+	r, err := newAccessReview(r)
+	if err != nil {
+		return AccessReview{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reviews == nil {
+		s.reviews = make(map[string]AccessReview)
+	}
+	s.reviews[r.ID] = copyAccessReview(r)
+	return r, nil
+}
+
+// AccessReview returns the review.
+func (s *MemoryAccessReviewStore) AccessReview(id string) (AccessReview, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reviews[id]
+	if !ok {
+		return AccessReview{}, ErrAccessReviewNotFound
+	}
+	return copyAccessReview(r), nil
+}
+
+// AccessReviews returns the reviews matching the filter, newest first.
+func (s *MemoryAccessReviewStore) AccessReviews(filter AccessReviewFilter) ([]AccessReview, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reviews := []AccessReview{}
+	for _, r := range s.reviews {
+		if filter.matches(r) {
+			reviews = append(reviews, copyAccessReview(r))
+		}
+	}
+	sort.Slice(reviews, func(i, j int) bool { return reviews[i].CreatedAt.After(reviews[j].CreatedAt) })
+	if filter.Limit > 0 && len(reviews) > filter.Limit {
+		reviews = reviews[:filter.Limit]
+	}
+	return reviews, nil
+}
+
+// DecideAccessReview records the decisions.
+func (s *MemoryAccessReviewStore) DecideAccessReview(id string, decisions []AccessReviewDecision, decidedBy string) (AccessReview, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reviews[id]
+	if !ok {
+		return AccessReview{}, ErrAccessReviewNotFound
+	}
+	r = copyAccessReview(r)
+	if err := r.decide(decisions, decidedBy); err != nil {
+		return AccessReview{}, err
+	}
+	s.reviews[id] = r
+	return copyAccessReview(r), nil
+}
+
+// copyAccessReview copies the review's entries, so the kept review isn't
+// changed through the returned one.
+func copyAccessReview(r AccessReview) AccessReview {
+	r.Entries = append([]AccessReviewEntry{}, r.Entries...)
+	return r
+}
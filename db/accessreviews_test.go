@@ -0,0 +1,48 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestMemoryAccessReviewStore(t *testing.T) {
+	store := &db.MemoryAccessReviewStore{}
+	review, err := store.CreateAccessReview(db.AccessReview{
+		Campaign: "2026-10-01",
+		OrgGUID:  "org-1",
+		Entries: []db.AccessReviewEntry{
+			{UserID: "user-1", Username: "one@example.com", Roles: []string{"users", "managers"}},
+			{UserID: "user-2", Username: "two@example.com", Roles: []string{"users"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if review.ID == "" || review.Status != db.AccessReviewOpen {
+		t.Errorf("Expected a new open review. Found %+v", review)
+	}
+
+	if _, err := store.DecideAccessReview(review.ID, []db.AccessReviewDecision{{UserID: "user-3", Decision: db.AccessConfirm}}, "manager"); err == nil {
+		t.Error("Expected decisions on users not under review to fail")
+	}
+	review, err = store.DecideAccessReview(review.ID, []db.AccessReviewDecision{{UserID: "user-1", Decision: db.AccessConfirm}}, "manager")
+	if err != nil || review.Status != db.AccessReviewOpen || review.Entries[0].Decision != db.AccessConfirm {
+		t.Errorf("Expected the decision to be recorded on the open review. Found %+v, %v", review, err)
+	}
+	review, err = store.DecideAccessReview(review.ID, []db.AccessReviewDecision{{UserID: "user-2", Decision: db.AccessRevoke}}, "manager")
+	if err != nil || review.Status != db.AccessReviewCompleted || review.CompletedAt == nil {
+		t.Errorf("Expected the review to be completed once all are decided. Found %+v, %v", review, err)
+	}
+	if _, err := store.DecideAccessReview(review.ID, []db.AccessReviewDecision{{UserID: "user-2", Decision: db.AccessConfirm}}, "manager"); err != db.ErrAccessReviewCompleted {
+		t.Errorf("Expected a completed review not to be decided again. Found %v", err)
+	}
+
+	reviews, _ := store.AccessReviews(db.AccessReviewFilter{OrgGUID: "org-1", Status: db.AccessReviewCompleted})
+	if len(reviews) != 1 || reviews[0].Entries[1].DecidedBy != "manager" {
+		t.Errorf("Expected the completed review. Found %+v", reviews)
+	}
+	if _, err := store.AccessReview("unknown"); err != db.ErrAccessReviewNotFound {
+		t.Errorf("Expected an unknown review not to be found. Found %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AdminAuditEntry is a privileged action the dashboard took with its own
+// credentials on behalf of a user, such as an invite or a role change.
+type AdminAuditEntry struct {
+	Time time.Time `json:"time"`
+	// Actor is the user the action was taken for, or AdminAuditSystem when
+	// the dashboard took it on its own.
+	Actor  string `json:"actor"`
+	Action string `json:"action"`
+	// Target is what the action was taken on, e.g. the invited address.
+	Target string `json:"target"`
+	// RequestID is the ID of the request the action was taken in, empty for
+	// background actions.
+	RequestID string          `json:"request_id,omitempty"`
+	Details   json.RawMessage `json:"details,omitempty"`
+}
+
+// AdminAuditSystem is the actor of the privileged actions the dashboard took
+// on its own, such as expiring role grants.
+const AdminAuditSystem = "dashboard"
+
+// AdminAuditFilter selects entries of the admin audit log. Empty fields
+// select any.
+type AdminAuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+}
+
+// match returns whether the entry is selected by the filter.
+func (f AdminAuditFilter) match(e AdminAuditEntry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Target == "" || e.Target == f.Target) &&
+		!e.Time.Before(f.Since)
+}
+
+// AdminAuditStore keeps the admin audit log until it's purged.
+type AdminAuditStore interface {
+	// RecordAdminAction keeps the entry.
+	RecordAdminAction(entry AdminAuditEntry) error
+	// AdminActions returns at most limit of the entries selected by the
+	// filter, newest first.
+	AdminActions(filter AdminAuditFilter, limit int) ([]AdminAuditEntry, error)
+	// PurgeAdminActions drops the entries older than the time and returns
+	// how many it dropped.
+	PurgeAdminActions(before time.Time) (int64, error)
+}
+
+// SQLAdminAuditStore keeps the admin audit log in the database.
+type SQLAdminAuditStore struct {
+	DB *sql.DB
+}
+
+// RecordAdminAction keeps the entry.
+func (s *SQLAdminAuditStore) RecordAdminAction(entry AdminAuditEntry) error {
+	details := []byte(entry.Details)
+	if len(details) == 0 {
+		details = []byte("null")
+	}
+	_, err := s.DB.Exec(`INSERT INTO admin_audit_log (time, actor, action, target, request_id, details)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		entry.Time, entry.Actor, entry.Action, entry.Target, entry.RequestID, details)
+	return err
+}
+
+// AdminActions returns the entries selected by the filter, newest first.
+func (s *SQLAdminAuditStore) AdminActions(filter AdminAuditFilter, limit int) ([]AdminAuditEntry, error) {
+	args := []interface{}{filter.Since}
+	where := []string{"time >= $1"}
+	for _, f := range []struct{ column, value string }{
+		{"actor", filter.Actor},
+		{"action", filter.Action},
+		{"target", filter.Target},
+	} {
+		if f.value != "" {
+			args = append(args, f.value)
+			where = append(where, fmt.Sprintf("%s = $%d", f.column, len(args)))
+		}
+	}
+	args = append(args, limit)
+	rows, err := s.DB.Query(`SELECT time, actor, action, target, request_id, details FROM admin_audit_log
+		WHERE `+strings.Join(where, " AND ")+fmt.Sprintf(` ORDER BY time DESC LIMIT $%d`, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []AdminAuditEntry{}
+	for rows.Next() {
+		var (
+			entry   AdminAuditEntry
+			details []byte
+		)
+		if err := rows.Scan(&entry.Time, &entry.Actor, &entry.Action, &entry.Target, &entry.RequestID, &details); err != nil {
+			return nil, err
+		}
+		if string(details) != "null" {
+			entry.Details = details
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// PurgeAdminActions drops the entries older than the time.
+func (s *SQLAdminAuditStore) PurgeAdminActions(before time.Time) (int64, error) {
+	result, err := s.DB.Exec(`DELETE FROM admin_audit_log WHERE time < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MemoryAdminAuditStore keeps the latest entries of the admin audit log in
+// memory. It's used when no database is configured, so the entries are lost
+// when the app restarts.
+type MemoryAdminAuditStore struct {
+	mu      sync.Mutex
+	entries []AdminAuditEntry
+}
+
+// RecordAdminAction keeps the entry, dropping the oldest ones past the size
+// limit.
+func (s *MemoryAdminAuditStore) RecordAdminAction(entry AdminAuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry)
+	if drop := len(s.entries) - maxMemoryAuditEvents; drop > 0 {
+		s.entries = append([]AdminAuditEntry{}, s.entries[drop:]...)
+	}
+	return nil
+}
+
+// AdminActions returns the entries selected by the filter, newest first.
+func (s *MemoryAdminAuditStore) AdminActions(filter AdminAuditFilter, limit int) ([]AdminAuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := []AdminAuditEntry{}
+	for i := len(s.entries) - 1; i >= 0 && len(entries) < limit; i-- {
+		if filter.match(s.entries[i]) {
+			entries = append(entries, s.entries[i])
+		}
+	}
+	return entries, nil
+}
+
+// PurgeAdminActions drops the entries older than the time.
+func (s *MemoryAdminAuditStore) PurgeAdminActions(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The entries are recorded in order, so the expired ones come first.
+	drop := 0
+	for drop < len(s.entries) && s.entries[drop].Time.Before(before) {
+		drop++
+	}
+	if drop > 0 {
+		s.entries = append([]AdminAuditEntry{}, s.entries[drop:]...)
+	}
+	return int64(drop), nil
+}
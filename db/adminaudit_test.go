@@ -0,0 +1,31 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestMemoryAdminAuditStore(t *testing.T) {
+	store := &db.MemoryAdminAuditStore{}
+	now := time.Now()
+	store.RecordAdminAction(db.AdminAuditEntry{Time: now.Add(-100 * 24 * time.Hour), Actor: "admin-guid", Action: "invite_user", Target: "old@example.gov"})
+	store.RecordAdminAction(db.AdminAuditEntry{Time: now.Add(-time.Hour), Actor: "admin-guid", Action: "invite_user", Target: "a@example.gov"})
+	store.RecordAdminAction(db.AdminAuditEntry{Time: now.Add(-time.Minute), Actor: "manager-guid", Action: "grant_role", Target: "user-guid"})
+	store.RecordAdminAction(db.AdminAuditEntry{Time: now, Actor: "admin-guid", Action: "invite_user", Target: "b@example.gov"})
+
+	entries, _ := store.AdminActions(db.AdminAuditFilter{Action: "invite_user", Since: now.Add(-db.AuditRetention)}, 10)
+	if len(entries) != 2 || entries[0].Target != "b@example.gov" {
+		t.Errorf("Expected the recent invites newest first. Found %+v", entries)
+	}
+	if entries, _ := store.AdminActions(db.AdminAuditFilter{}, 1); len(entries) != 1 || entries[0].Target != "b@example.gov" {
+		t.Errorf("Expected only the newest entry. Found %+v", entries)
+	}
+	if entries, _ := store.AdminActions(db.AdminAuditFilter{Target: "user-guid"}, 10); len(entries) != 1 || entries[0].Actor != "manager-guid" {
+		t.Errorf("Expected the entry of the target. Found %+v", entries)
+	}
+	if purged, _ := store.PurgeAdminActions(now.Add(-db.AuditRetention)); purged != 1 {
+		t.Errorf("Expected 1 entry to be purged. Found %d", purged)
+	}
+}
@@ -0,0 +1,454 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ArchiveFormat is the version of the archive format written by Export. It
+// changes when archives written by older dashboards can't be imported as they
+// are anymore.
+const ArchiveFormat = 8
+
+// Archive is all the dashboard's own data, for backups and for moving it to
+// another database service. Sessions, one-time secrets, login locations and
+// webhook deliveries are short-lived and aren't archived.
+type Archive struct {
+	Format int `json:"format"`
+	// SchemaVersion is the schema the data was exported from.
+	SchemaVersion    int                     `json:"schema_version"`
+	CreatedAt        time.Time               `json:"created_at"`
+	Content          *ArchivedContent        `json:"content,omitempty"`
+	Preferences      []ArchivedPreferences   `json:"preferences"`
+	AuditEvents      []AuditEvent            `json:"audit_events"`
+	RoleRequests     []RoleRequest           `json:"role_requests"`
+	PendingChanges   []PendingChange         `json:"pending_changes"`
+	Webhooks         []WebhookSubscription   `json:"webhooks"`
+	Incidents        []Incident              `json:"incidents"`
+	AccessReviews    []AccessReview          `json:"access_reviews"`
+	ChangelogEntries []ChangelogEntry        `json:"changelog_entries"`
+	ChangelogSeen    []ArchivedChangelogSeen `json:"changelog_seen"`
+	RoleGrants       []RoleGrant             `json:"role_grants"`
+	PendingInvites   []ArchivedInvite        `json:"pending_invites"`
+	AdminActions     []AdminAuditEntry       `json:"admin_actions"`
+}
+
+// ArchivedContent is the saved deployment content.
+type ArchivedContent struct {
+	Content   json.RawMessage `json:"content"`
+	UpdatedBy string          `json:"updated_by"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Version   int             `json:"version"`
+}
+
+// ArchivedPreferences are a user's saved preferences.
+type ArchivedPreferences struct {
+	UserID    string    `json:"user_id"`
+	Locale    string    `json:"locale"`
+	Timezone  string    `json:"timezone"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version"`
+}
+
+// ArchivedChangelogSeen is the last changelog version a user has seen.
+type ArchivedChangelogSeen struct {
+	UserID  string    `json:"user_id"`
+	Version string    `json:"version"`
+	SeenAt  time.Time `json:"seen_at"`
+}
+
+// ArchivedInvite is when a user who hasn't logged in yet was invited.
+type ArchivedInvite struct {
+	UserID    string    `json:"user_id"`
+	InvitedAt time.Time `json:"invited_at"`
+}
+
+// Summary counts the records of the archive, for logs and audit events.
+func (a Archive) Summary() map[string]int {
+	content := 0
+	if a.Content != nil {
+		content = 1
+	}
+	return map[string]int{
+		"content":           content,
+		"preferences":       len(a.Preferences),
+		"audit_events":      len(a.AuditEvents),
+		"role_requests":     len(a.RoleRequests),
+		"pending_changes":   len(a.PendingChanges),
+		"webhooks":          len(a.Webhooks),
+		"incidents":         len(a.Incidents),
+		"access_reviews":    len(a.AccessReviews),
+		"changelog_entries": len(a.ChangelogEntries),
+		"changelog_seen":    len(a.ChangelogSeen),
+		"role_grants":       len(a.RoleGrants),
+		"pending_invites":   len(a.PendingInvites),
+		"admin_actions":     len(a.AdminActions),
+	}
+}
+
+// Export reads all the dashboard's own data in a single transaction, so the
+// archive is consistent. Encrypted columns are decrypted with the cipher, so
+// the archive can be imported with another key.
+func Export(conn *sql.DB, cipher *ColumnCipher) (Archive, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return Archive{}, err
+	}
+	// Nothing is written.
+	defer tx.Rollback()
+
+	archive := Archive{
+		Format:           ArchiveFormat,
+		SchemaVersion:    LatestVersion(),
+		CreatedAt:        time.Now().UTC(),
+		Preferences:      []ArchivedPreferences{},
+		AuditEvents:      []AuditEvent{},
+		RoleRequests:     []RoleRequest{},
+		PendingChanges:   []PendingChange{},
+		Webhooks:         []WebhookSubscription{},
+		Incidents:        []Incident{},
+		AccessReviews:    []AccessReview{},
+		ChangelogEntries: []ChangelogEntry{},
+		ChangelogSeen:    []ArchivedChangelogSeen{},
+		RoleGrants:       []RoleGrant{},
+		PendingInvites:   []ArchivedInvite{},
+		AdminActions:     []AdminAuditEntry{},
+	}
+
+	var content ArchivedContent
+	var raw []byte
+	err = tx.QueryRow(`SELECT content, updated_by, updated_at, version FROM deployment_content WHERE id = 1`).
+		Scan(&raw, &content.UpdatedBy, &content.UpdatedAt, &content.Version)
+	switch {
+	case err == nil:
+		content.Content = raw
+		archive.Content = &content
+	case err != sql.ErrNoRows:
+		return Archive{}, fmt.Errorf("could not export the content: %v", err)
+	}
+
+	if err := exportRows(tx, `SELECT user_id, locale, timezone, updated_at, version FROM user_preferences ORDER BY user_id`,
+		func(rows *sql.Rows) error {
+			var p ArchivedPreferences
+			if err := rows.Scan(&p.UserID, &p.Locale, &p.Timezone, &p.UpdatedAt, &p.Version); err != nil {
+				return err
+			}
+			archive.Preferences = append(archive.Preferences, p)
+			return nil
+		}); err != nil {
+		return Archive{}, fmt.Errorf("could not export the preferences: %v", err)
+	}
+
+	if err := exportRows(tx, `SELECT time, actor, action, remote_addr, details FROM audit_events ORDER BY id`,
+		func(rows *sql.Rows) error {
+			var (
+				event   AuditEvent
+				details []byte
+			)
+			if err := rows.Scan(&event.Time, &event.Actor, &event.Action, &event.RemoteAddr, &details); err != nil {
+				return err
+			}
+			if string(details) != "null" {
+				event.Details = details
+			}
+			archive.AuditEvents = append(archive.AuditEvents, event)
+			return nil
+		}); err != nil {
+		return Archive{}, fmt.Errorf("could not export the audit events: %v", err)
+	}
+
+	if err := exportRows(tx, `SELECT `+roleRequestColumns+` FROM role_requests ORDER BY created_at`,
+		func(rows *sql.Rows) error {
+			r, err := scanRoleRequest(rows)
+			if err != nil {
+				return err
+			}
+			archive.RoleRequests = append(archive.RoleRequests, r)
+			return nil
+		}); err != nil {
+		return Archive{}, fmt.Errorf("could not export the role requests: %v", err)
+	}
+
+	if err := exportRows(tx, `SELECT `+pendingChangeColumns+` FROM pending_changes ORDER BY created_at`,
+		func(rows *sql.Rows) error {
+			c, err := scanPendingChange(rows, cipher)
+			if err != nil {
+				return err
+			}
+			archive.PendingChanges = append(archive.PendingChanges, c)
+			return nil
+		}); err != nil {
+		return Archive{}, fmt.Errorf("could not export the pending changes: %v", err)
+	}
+
+	if err := exportRows(tx, `SELECT `+webhookColumns+` FROM webhook_subscriptions ORDER BY created_at`,
+		func(rows *sql.Rows) error {
+			w, err := scanWebhook(rows, cipher)
+			if err != nil {
+				return err
+			}
+			archive.Webhooks = append(archive.Webhooks, w)
+			return nil
+		}); err != nil {
+		return Archive{}, fmt.Errorf("could not export the webhooks: %v", err)
+	}
+
+	if err := exportRows(tx, `SELECT `+incidentColumns+` FROM incidents ORDER BY opened_at`,
+		func(rows *sql.Rows) error {
+			var i Incident
+			if err := rows.Scan(&i.ID, &i.Kind, &i.OrgGUID, &i.SpaceGUID, &i.AppGUID, &i.AppName, &i.Crashes,
+				&i.LastExitDescription, &i.OpenedAt, &i.LastSeenAt, &i.EscalatedAt, &i.ResolvedAt); err != nil {
+				return err
+			}
+			archive.Incidents = append(archive.Incidents, i)
+			return nil
+		}); err != nil {
+		return Archive{}, fmt.Errorf("could not export the incidents: %v", err)
+	}
+
+	if err := exportRows(tx, `SELECT `+accessReviewColumns+` FROM access_reviews ORDER BY created_at`,
+		func(rows *sql.Rows) error {
+			r, err := scanAccessReview(rows)
+			if err != nil {
+				return err
+			}
+			archive.AccessReviews = append(archive.AccessReviews, r)
+			return nil
+		}); err != nil {
+		return Archive{}, fmt.Errorf("could not export the access reviews: %v", err)
+	}
+
+	if err := exportRows(tx, `SELECT version, title, body, published_at, updated_by FROM changelog_entries ORDER BY published_at`,
+		func(rows *sql.Rows) error {
+			var e ChangelogEntry
+			if err := rows.Scan(&e.Version, &e.Title, &e.Body, &e.PublishedAt, &e.UpdatedBy); err != nil {
+				return err
+			}
+			archive.ChangelogEntries = append(archive.ChangelogEntries, e)
+			return nil
+		}); err != nil {
+		return Archive{}, fmt.Errorf("could not export the changelog: %v", err)
+	}
+
+	if err := exportRows(tx, `SELECT user_id, version, seen_at FROM changelog_seen ORDER BY user_id`,
+		func(rows *sql.Rows) error {
+			var seen ArchivedChangelogSeen
+			if err := rows.Scan(&seen.UserID, &seen.Version, &seen.SeenAt); err != nil {
+				return err
+			}
+			archive.ChangelogSeen = append(archive.ChangelogSeen, seen)
+			return nil
+		}); err != nil {
+		return Archive{}, fmt.Errorf("could not export the seen changelog versions: %v", err)
+	}
+
+	if err := exportRows(tx, `SELECT `+roleGrantColumns+` FROM role_grants ORDER BY created_at`,
+		func(rows *sql.Rows) error {
+			g, err := scanRoleGrant(rows)
+			if err != nil {
+				return err
+			}
+			archive.RoleGrants = append(archive.RoleGrants, g)
+			return nil
+		}); err != nil {
+		return Archive{}, fmt.Errorf("could not export the role grants: %v", err)
+	}
+
+	if err := exportRows(tx, `SELECT user_id, invited_at FROM pending_invites ORDER BY invited_at`,
+		func(rows *sql.Rows) error {
+			var invite ArchivedInvite
+			if err := rows.Scan(&invite.UserID, &invite.InvitedAt); err != nil {
+				return err
+			}
+			archive.PendingInvites = append(archive.PendingInvites, invite)
+			return nil
+		}); err != nil {
+		return Archive{}, fmt.Errorf("could not export the pending invites: %v", err)
+	}
+
+	if err := exportRows(tx, `SELECT time, actor, action, target, request_id, details FROM admin_audit_log ORDER BY id`,
+		func(rows *sql.Rows) error {
+			var (
+				entry   AdminAuditEntry
+				details []byte
+			)
+			if err := rows.Scan(&entry.Time, &entry.Actor, &entry.Action, &entry.Target, &entry.RequestID, &details); err != nil {
+				return err
+			}
+			if string(details) != "null" {
+				entry.Details = details
+			}
+			archive.AdminActions = append(archive.AdminActions, entry)
+			return nil
+		}); err != nil {
+		return Archive{}, fmt.Errorf("could not export the admin audit log: %v", err)
+	}
+	return archive, nil
+}
+
+// exportRows calls scan for each row of the query.
+func exportRows(tx *sql.Tx, query string, scan func(*sql.Rows) error) error {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Import replaces all the dashboard's own data with the archive's, in a
+// single transaction so a failed import changes nothing, encrypting the
+// sensitive columns with the cipher. Archives from a newer schema than this
+// build's are refused, since they may hold data it would lose.
+func Import(conn *sql.DB, archive Archive, cipher *ColumnCipher) error {
+	if archive.Format != ArchiveFormat {
+		return fmt.Errorf("unsupported archive format %d, expected %d", archive.Format, ArchiveFormat)
+	}
+	if archive.SchemaVersion > LatestVersion() {
+		return fmt.Errorf("the archive is from schema version %d, newer than this dashboard's %d",
+			archive.SchemaVersion, LatestVersion())
+	}
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	if err := importArchive(tx, archive, cipher); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// archivedTables are the tables an import replaces.
+var archivedTables = []string{
+	"deployment_content", "user_preferences", "audit_events", "role_requests", "pending_changes",
+	"webhook_subscriptions", "incidents", "access_reviews", "changelog_entries", "changelog_seen",
+	"role_grants", "pending_invites", "admin_audit_log",
+}
+
+func importArchive(tx *sql.Tx, archive Archive, cipher *ColumnCipher) error {
+	// Deleting the webhooks also deletes their deliveries.
+	for _, table := range archivedTables {
+		if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+			return fmt.Errorf("could not clear %s: %v", table, err)
+		}
+	}
+
+	if c := archive.Content; c != nil {
+		if _, err := tx.Exec(`INSERT INTO deployment_content (id, content, updated_by, updated_at, version)
+			VALUES (1, $1, $2, $3, $4)`, []byte(c.Content), c.UpdatedBy, c.UpdatedAt, c.Version); err != nil {
+			return fmt.Errorf("could not import the content: %v", err)
+		}
+	}
+	for _, p := range archive.Preferences {
+		if _, err := tx.Exec(`INSERT INTO user_preferences (user_id, locale, timezone, updated_at, version)
+			VALUES ($1, $2, $3, $4, $5)`, p.UserID, p.Locale, p.Timezone, p.UpdatedAt, p.Version); err != nil {
+			return fmt.Errorf("could not import the preferences of %s: %v", p.UserID, err)
+		}
+	}
+	for _, event := range archive.AuditEvents {
+		details := []byte(event.Details)
+		if len(details) == 0 {
+			details = []byte("null")
+		}
+		if _, err := tx.Exec(`INSERT INTO audit_events (time, actor, action, remote_addr, details)
+			VALUES ($1, $2, $3, $4, $5)`,
+			event.Time, event.Actor, event.Action, event.RemoteAddr, details); err != nil {
+			return fmt.Errorf("could not import an audit event: %v", err)
+		}
+	}
+	for _, r := range archive.RoleRequests {
+		if _, err := tx.Exec(`INSERT INTO role_requests (`+roleRequestColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			r.ID, r.UserID, r.OrgGUID, r.SpaceGUID, r.Role, r.Reason, r.Status, r.CreatedAt, r.DecidedBy, r.DecidedAt); err != nil {
+			return fmt.Errorf("could not import role request %s: %v", r.ID, err)
+		}
+	}
+	for _, c := range archive.PendingChanges {
+		body, err := cipher.Encrypt(c.Body)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO pending_changes (`+pendingChangeColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			c.ID, c.Class, c.Method, c.Path, body, c.RequestedBy, c.Status, c.CreatedAt, c.DecidedBy, c.DecidedAt); err != nil {
+			return fmt.Errorf("could not import pending change %s: %v", c.ID, err)
+		}
+	}
+	for _, w := range archive.Webhooks {
+		secret, err := cipher.Encrypt([]byte(w.Secret))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO webhook_subscriptions (`+webhookColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			w.ID, w.OrgGUID, w.URL, strings.Join(w.Events, ","), secret, w.CreatedBy, w.CreatedAt); err != nil {
+			return fmt.Errorf("could not import webhook %s: %v", w.ID, err)
+		}
+	}
+	for _, i := range archive.Incidents {
+		if _, err := tx.Exec(`INSERT INTO incidents (`+incidentColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			i.ID, i.Kind, i.OrgGUID, i.SpaceGUID, i.AppGUID, i.AppName, i.Crashes, i.LastExitDescription,
+			i.OpenedAt, i.LastSeenAt, i.EscalatedAt, i.ResolvedAt); err != nil {
+			return fmt.Errorf("could not import incident %s: %v", i.ID, err)
+		}
+	}
+	for _, r := range archive.AccessReviews {
+		entries, err := json.Marshal(r.Entries)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO access_reviews (`+accessReviewColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			r.ID, r.Campaign, r.OrgGUID, r.OrgName, r.Status, r.CreatedAt, r.DueAt, r.CompletedAt, entries); err != nil {
+			return fmt.Errorf("could not import access review %s: %v", r.ID, err)
+		}
+	}
+	for _, e := range archive.ChangelogEntries {
+		if _, err := tx.Exec(`INSERT INTO changelog_entries (version, title, body, published_at, updated_by)
+			VALUES ($1, $2, $3, $4, $5)`, e.Version, e.Title, e.Body, e.PublishedAt, e.UpdatedBy); err != nil {
+			return fmt.Errorf("could not import changelog entry %s: %v", e.Version, err)
+		}
+	}
+	for _, seen := range archive.ChangelogSeen {
+		if _, err := tx.Exec(`INSERT INTO changelog_seen (user_id, version, seen_at) VALUES ($1, $2, $3)`,
+			seen.UserID, seen.Version, seen.SeenAt); err != nil {
+			return fmt.Errorf("could not import the seen changelog version of %s: %v", seen.UserID, err)
+		}
+	}
+	for _, g := range archive.RoleGrants {
+		if _, err := tx.Exec(`INSERT INTO role_grants (`+roleGrantColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+			g.ID, g.UserID, g.OrgGUID, g.SpaceGUID, g.Role, g.Reason, g.AddedToOrg, g.GrantedBy,
+			g.CreatedAt, g.ExpiresAt, g.Status, g.EndedBy, g.EndedAt); err != nil {
+			return fmt.Errorf("could not import role grant %s: %v", g.ID, err)
+		}
+	}
+	for _, invite := range archive.PendingInvites {
+		if _, err := tx.Exec(`INSERT INTO pending_invites (user_id, invited_at) VALUES ($1, $2)`,
+			invite.UserID, invite.InvitedAt); err != nil {
+			return fmt.Errorf("could not import the pending invite of %s: %v", invite.UserID, err)
+		}
+	}
+	for _, entry := range archive.AdminActions {
+		details := []byte(entry.Details)
+		if len(details) == 0 {
+			details = []byte("null")
+		}
+		if _, err := tx.Exec(`INSERT INTO admin_audit_log (time, actor, action, target, request_id, details)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			entry.Time, entry.Actor, entry.Action, entry.Target, entry.RequestID, details); err != nil {
+			return fmt.Errorf("could not import an admin audit entry: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package db_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestExport(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	now := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT content, updated_by, updated_at, version FROM deployment_content").
+		WillReturnRows(sqlmock.NewRows([]string{"content", "updated_by", "updated_at", "version"}).
+			AddRow([]byte(`{"docs_url":"https://docs.example.com"}`), "admin-guid", now, 2))
+	mock.ExpectQuery("SELECT user_id, locale, timezone, updated_at, version FROM user_preferences").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "locale", "timezone", "updated_at", "version"}).
+			AddRow("user-guid", "en-GB", "Europe/London", now, 1))
+	mock.ExpectQuery("SELECT time, actor, action, remote_addr, details FROM audit_events").
+		WillReturnRows(sqlmock.NewRows([]string{"time", "actor", "action", "remote_addr", "details"}).
+			AddRow(now, "user-guid", "login", "10.0.0.1", []byte("null")))
+	mock.ExpectQuery("SELECT id, user_id, .* FROM role_requests").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "org_guid", "space_guid", "role", "reason", "status", "created_at", "decided_by", "decided_at"}).
+			AddRow("request-1", "user-guid", "org-1", "", "managers", "", "approved", now, "manager-guid", now))
+	mock.ExpectQuery("SELECT id, class, .* FROM pending_changes").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "class", "method", "path", "body", "requested_by", "status", "created_at", "decided_by", "decided_at"}))
+	mock.ExpectQuery("SELECT id, org_guid, .* FROM webhook_subscriptions").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "org_guid", "url", "events", "secret", "created_by", "created_at"}).
+			AddRow("webhook-1", "org-1", "https://hooks.example.com", "app.crashed,incident.opened", []byte("s3cret"), "manager-guid", now))
+	mock.ExpectQuery("SELECT id, kind, .* FROM incidents").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "org_guid", "space_guid", "app_guid", "app_name", "crashes", "last_exit_description", "opened_at", "last_seen_at", "escalated_at", "resolved_at"}))
+	mock.ExpectQuery("SELECT id, campaign, .* FROM access_reviews").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "campaign", "org_guid", "org_name", "status", "created_at", "due_at", "completed_at", "entries"}))
+	mock.ExpectQuery("SELECT version, title, .* FROM changelog_entries").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "title", "body", "published_at", "updated_by"}).
+			AddRow("1.4.0", "", "Faster logs.", now, "admin-guid"))
+	mock.ExpectQuery("SELECT user_id, version, seen_at FROM changelog_seen").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "version", "seen_at"}))
+	mock.ExpectQuery("SELECT id, user_id, .* FROM role_grants").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "org_guid", "space_guid", "role", "reason", "added_to_org", "granted_by", "created_at", "expires_at", "status", "ended_by", "ended_at"}).
+			AddRow("grant-1", "user-guid", "org-1", "", "managers", "incident", true, "manager-guid", now, now.Add(time.Hour), "active", "", nil))
+	mock.ExpectQuery("SELECT user_id, invited_at FROM pending_invites").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "invited_at"}).AddRow("invited-guid", now))
+	mock.ExpectQuery("SELECT time, actor, .* FROM admin_audit_log").
+		WillReturnRows(sqlmock.NewRows([]string{"time", "actor", "action", "target", "request_id", "details"}).
+			AddRow(now, "manager-guid", "invite_user", "someone@example.com", "request-1", []byte(`{"org":"org-1"}`)))
+	mock.ExpectRollback()
+
+	archive, err := db.Export(conn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.Format != db.ArchiveFormat || archive.SchemaVersion == 0 {
+		t.Errorf("Expected the archive to be versioned. Found format %d, schema %d", archive.Format, archive.SchemaVersion)
+	}
+	if archive.Content == nil || archive.Content.Version != 2 || string(archive.Content.Content) != `{"docs_url":"https://docs.example.com"}` {
+		t.Errorf("Unexpected content %+v", archive.Content)
+	}
+	expected := map[string]int{
+		"content": 1, "preferences": 1, "audit_events": 1, "role_requests": 1, "pending_changes": 0,
+		"webhooks": 1, "incidents": 0, "access_reviews": 0, "changelog_entries": 1, "changelog_seen": 0,
+		"role_grants": 1, "pending_invites": 1, "admin_actions": 1,
+	}
+	for kind, count := range archive.Summary() {
+		if expected[kind] != count {
+			t.Errorf("Expected %d %s. Found %d", expected[kind], kind, count)
+		}
+	}
+	if archive.AuditEvents[0].Details != nil || archive.RoleRequests[0].DecidedBy != "manager-guid" {
+		t.Errorf("Unexpected archive %+v", archive)
+	}
+	if w := archive.Webhooks[0]; w.Secret != "s3cret" || len(w.Events) != 2 {
+		t.Errorf("Expected the webhook with its secret. Found %+v", w)
+	}
+	if g := archive.RoleGrants[0]; !g.AddedToOrg || g.EndedAt != nil {
+		t.Errorf("Unexpected role grant %+v", g)
+	}
+	if string(archive.AdminActions[0].Details) != `{"org":"org-1"}` {
+		t.Errorf("Unexpected admin action %+v", archive.AdminActions[0])
+	}
+	// Empty kinds are still listed, so the archive shows there was nothing.
+	if archive.PendingChanges == nil {
+		t.Error("Expected an empty list of pending changes")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	now := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	archive := db.Archive{
+		Format:        db.ArchiveFormat,
+		SchemaVersion: 1,
+		Content:       &db.ArchivedContent{Content: json.RawMessage(`{}`), UpdatedBy: "admin-guid", UpdatedAt: now, Version: 3},
+		Preferences:   []db.ArchivedPreferences{{UserID: "user-guid", Locale: "de-DE", UpdatedAt: now, Version: 1}},
+		AuditEvents:   []db.AuditEvent{{Time: now, Actor: "user-guid", Action: "login"}},
+		PendingChanges: []db.PendingChange{{
+			ID: "change-1", Class: "org_deletion", Method: "DELETE", Path: "/v2/organizations/org-1",
+			RequestedBy: "admin-guid", Status: db.PendingChangePending, CreatedAt: now,
+		}},
+	}
+
+	mock.ExpectBegin()
+	for _, table := range []string{
+		"deployment_content", "user_preferences", "audit_events", "role_requests", "pending_changes",
+		"webhook_subscriptions", "incidents", "access_reviews", "changelog_entries", "changelog_seen",
+		"role_grants", "pending_invites", "admin_audit_log",
+	} {
+		mock.ExpectExec("DELETE FROM " + table).WillReturnResult(sqlmock.NewResult(0, 5))
+	}
+	mock.ExpectExec("INSERT INTO deployment_content").
+		WithArgs([]byte(`{}`), "admin-guid", now, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO user_preferences").
+		WithArgs("user-guid", "de-DE", "", now, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(now, "user-guid", "login", "", []byte("null")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO pending_changes").
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	if err := db.Import(conn, archive, nil); err == nil {
+		t.Error("Expected the failed insert to fail the import")
+	}
+
+	// Archives this build can't read are refused before anything is changed.
+	// Older formats lack tables, which the import would clear.
+	for _, invalid := range []db.Archive{
+		{Format: db.ArchiveFormat - 1},
+		{Format: db.ArchiveFormat + 1},
+		{Format: db.ArchiveFormat, SchemaVersion: 1000},
+	} {
+		if err := db.Import(conn, invalid, nil); err == nil {
+			t.Errorf("Expected archive format %d, schema %d to be refused", invalid.Format, invalid.SchemaVersion)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportNewerTables(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	now := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	archive := db.Archive{
+		Format:        db.ArchiveFormat,
+		SchemaVersion: db.LatestVersion(),
+		Webhooks: []db.WebhookSubscription{{
+			ID: "webhook-1", OrgGUID: "org-1", URL: "https://hooks.example.com",
+			Events: []string{"app.crashed", "incident.opened"}, Secret: "s3cret", CreatedBy: "manager-guid", CreatedAt: now,
+		}},
+		RoleGrants: []db.RoleGrant{{
+			ID: "grant-1", UserID: "user-guid", OrgGUID: "org-1", Role: "managers", GrantedBy: "manager-guid",
+			CreatedAt: now, ExpiresAt: now.Add(time.Hour), Status: "active",
+		}},
+		PendingInvites: []db.ArchivedInvite{{UserID: "invited-guid", InvitedAt: now}},
+		AdminActions:   []db.AdminAuditEntry{{Time: now, Actor: "manager-guid", Action: "invite_user", Target: "someone@example.com"}},
+	}
+
+	mock.ExpectBegin()
+	for i := 0; i < 13; i++ {
+		mock.ExpectExec("DELETE FROM").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("INSERT INTO webhook_subscriptions").
+		WithArgs("webhook-1", "org-1", "https://hooks.example.com", "app.crashed,incident.opened", []byte("s3cret"),
+			"manager-guid", now).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO role_grants").
+		WithArgs("grant-1", "user-guid", "org-1", "", "managers", "", false, "manager-guid",
+			now, now.Add(time.Hour), "active", "", nil).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO pending_invites").
+		WithArgs("invited-guid", now).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO admin_audit_log").
+		WithArgs(now, "manager-guid", "invite_user", "someone@example.com", "", []byte("null")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	if err := db.Import(conn, archive, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
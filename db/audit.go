@@ -0,0 +1,134 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// AuditRetention is how long audit events are kept, unless configured
+// otherwise.
+const AuditRetention = 90 * 24 * time.Hour
+
+// maxMemoryAuditEvents is the most events MemoryAuditStore keeps.
+const maxMemoryAuditEvents = 10000
+
+// AuditEvent is an action a user took in the dashboard.
+type AuditEvent struct {
+	Time       time.Time       `json:"time"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	RemoteAddr string          `json:"remote_addr"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+// AuditStore keeps the audit events until they're purged.
+type AuditStore interface {
+	// RecordEvent keeps the event.
+	RecordEvent(event AuditEvent) error
+	// Events returns at most limit of the actor's events since the time,
+	// newest first.
+	Events(actor string, since time.Time, limit int) ([]AuditEvent, error)
+	// PurgeEvents drops the events older than the time and returns how many
+	// it dropped.
+	PurgeEvents(before time.Time) (int64, error)
+}
+
+// SQLAuditStore keeps the audit events in the database.
+type SQLAuditStore struct {
+	DB *sql.DB
+}
+
+// RecordEvent keeps the event.
+func (s *SQLAuditStore) RecordEvent(event AuditEvent) error {
+	details := []byte(event.Details)
+	if len(details) == 0 {
+		details = []byte("null")
+	}
+	_, err := s.DB.Exec(`INSERT INTO audit_events (time, actor, action, remote_addr, details)
+		VALUES ($1, $2, $3, $4, $5)`,
+		event.Time, event.Actor, event.Action, event.RemoteAddr, details)
+	return err
+}
+
+// Events returns the actor's events since the time, newest first.
+func (s *SQLAuditStore) Events(actor string, since time.Time, limit int) ([]AuditEvent, error) {
+	rows, err := s.DB.Query(`SELECT time, actor, action, remote_addr, details FROM audit_events
+		WHERE actor = $1 AND time >= $2 ORDER BY time DESC LIMIT $3`, actor, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []AuditEvent{}
+	for rows.Next() {
+		var (
+			event   AuditEvent
+			details []byte
+		)
+		if err := rows.Scan(&event.Time, &event.Actor, &event.Action, &event.RemoteAddr, &details); err != nil {
+			return nil, err
+		}
+		if string(details) != "null" {
+			event.Details = details
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// PurgeEvents drops the events older than the time.
+func (s *SQLAuditStore) PurgeEvents(before time.Time) (int64, error) {
+	result, err := s.DB.Exec(`DELETE FROM audit_events WHERE time < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MemoryAuditStore keeps the latest audit events in memory. It's used when no
+// database is configured, so the events are lost when the app restarts.
+type MemoryAuditStore struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+// RecordEvent keeps the event, dropping the oldest ones past the size limit.
+func (s *MemoryAuditStore) RecordEvent(event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	if drop := len(s.events) - maxMemoryAuditEvents; drop > 0 {
+		s.events = append([]AuditEvent{}, s.events[drop:]...)
+	}
+	return nil
+}
+
+// Events returns the actor's events since the time, newest first.
+func (s *MemoryAuditStore) Events(actor string, since time.Time, limit int) ([]AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := []AuditEvent{}
+	for i := len(s.events) - 1; i >= 0 && len(events) < limit; i-- {
+		event := s.events[i]
+		if event.Actor == actor && !event.Time.Before(since) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// PurgeEvents drops the events older than the time.
+func (s *MemoryAuditStore) PurgeEvents(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The events are recorded in order, so the expired ones come first.
+	drop := 0
+	for drop < len(s.events) && s.events[drop].Time.Before(before) {
+		drop++
+	}
+	if drop > 0 {
+		s.events = append([]AuditEvent{}, s.events[drop:]...)
+	}
+	return int64(drop), nil
+}
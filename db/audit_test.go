@@ -0,0 +1,72 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestSQLAuditStore(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	store := &db.SQLAuditStore{DB: conn}
+
+	now := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(now, "user-guid", "login", "10.0.0.1", []byte("null")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := store.RecordEvent(db.AuditEvent{Time: now, Actor: "user-guid", Action: "login", RemoteAddr: "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT time, actor, action, remote_addr, details FROM audit_events").
+		WithArgs("user-guid", now.Add(-time.Hour), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"time", "actor", "action", "remote_addr", "details"}).
+			AddRow(now, "user-guid", "cf_api_request", "10.0.0.1", []byte(`{"method":"DELETE"}`)).
+			AddRow(now, "user-guid", "login", "10.0.0.1", []byte("null")))
+	events, err := store.Events("user-guid", now.Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || string(events[0].Details) != `{"method":"DELETE"}` || events[1].Details != nil {
+		t.Errorf("Unexpected events %+v", events)
+	}
+
+	mock.ExpectExec("DELETE FROM audit_events WHERE time < \\$1").
+		WithArgs(now.Add(-db.AuditRetention)).
+		WillReturnResult(sqlmock.NewResult(0, 42))
+	if purged, err := store.PurgeEvents(now.Add(-db.AuditRetention)); err != nil || purged != 42 {
+		t.Errorf("Expected 42 events purged. Found %d, %v", purged, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryAuditStore(t *testing.T) {
+	store := &db.MemoryAuditStore{}
+	now := time.Now().UTC()
+	store.RecordEvent(db.AuditEvent{Time: now.Add(-db.AuditRetention - time.Hour), Actor: "user-guid", Action: "expired"})
+	store.RecordEvent(db.AuditEvent{Time: now.Add(-time.Hour), Actor: "user-guid", Action: "login"})
+	store.RecordEvent(db.AuditEvent{Time: now.Add(-time.Minute), Actor: "other-guid", Action: "login"})
+	store.RecordEvent(db.AuditEvent{Time: now, Actor: "user-guid", Action: "logout"})
+	if purged, _ := store.PurgeEvents(now.Add(-db.AuditRetention)); purged != 1 {
+		t.Errorf("Expected the expired event to be purged. Found %d purged", purged)
+	}
+
+	events, _ := store.Events("user-guid", now.Add(-2*db.AuditRetention), 10)
+	if len(events) != 2 || events[0].Action != "logout" || events[1].Action != "login" {
+		t.Errorf("Expected the user's events within the retention, newest first. Found %+v", events)
+	}
+	events, _ = store.Events("user-guid", now.Add(-2*db.AuditRetention), 1)
+	if len(events) != 1 || events[0].Action != "logout" {
+		t.Errorf("Expected the newest event. Found %+v", events)
+	}
+}
@@ -0,0 +1,250 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxChangelogBodyLength is the longest release notes an entry can have.
+const MaxChangelogBodyLength = 20000
+
+// ErrChangelogEntryNotFound is returned for versions without an entry.
+var ErrChangelogEntryNotFound = errors.New("no changelog entry of this version")
+
+// changelogVersionPattern matches the versions of changelog entries, e.g.
+// 1.4, v2.0.1 or 2.1.0-beta.1.
+var changelogVersionPattern = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+){0,2}(-[0-9A-Za-z.-]+)?$`)
+
+// ChangelogEntry is the release notes of a version of the dashboard, shown to
+// users after the version is deployed.
+type ChangelogEntry struct {
+	Version string `json:"version"`
+	Title   string `json:"title,omitempty"`
+	// Body is the release notes in markdown.
+	Body        string    `json:"body"`
+	PublishedAt time.Time `json:"published_at"`
+	UpdatedBy   string    `json:"updated_by,omitempty"`
+}
+
+// Validate checks the entry has a version and release notes.
+func (e ChangelogEntry) Validate() error {
+	if !changelogVersionPattern.MatchString(e.Version) {
+		return fmt.Errorf("%q is not a version like 1.4.0", e.Version)
+	}
+	if strings.TrimSpace(e.Body) == "" {
+		return errors.New("the release notes are empty")
+	}
+	if len(e.Body) > MaxChangelogBodyLength {
+		return fmt.Errorf("the release notes are longer than %d characters", MaxChangelogBodyLength)
+	}
+	return nil
+}
+
+// ValidChangelogVersion returns true if the version can be the version of a
+// changelog entry.
+func ValidChangelogVersion(version string) bool {
+	return changelogVersionPattern.MatchString(version)
+}
+
+// CompareChangelogVersions compares two versions number by number, and
+// returns -1, 0 or 1. A pre-release sorts before its release.
+func CompareChangelogVersions(a, b string) int {
+	a, b = strings.TrimPrefix(a, "v"), strings.TrimPrefix(b, "v")
+	aNumbers, aPre := splitChangelogVersion(a)
+	bNumbers, bPre := splitChangelogVersion(b)
+	for i := 0; i < 3; i++ {
+		if aNumbers[i] != bNumbers[i] {
+			if aNumbers[i] < bNumbers[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	case aPre < bPre:
+		return -1
+	}
+	return 1
+}
+
+func splitChangelogVersion(version string) (numbers [3]int, pre string) {
+	if i := strings.Index(version, "-"); i >= 0 {
+		version, pre = version[:i], version[i+1:]
+	}
+	for i, part := range strings.SplitN(version, ".", 3) {
+		numbers[i], _ = strconv.Atoi(part)
+	}
+	return numbers, pre
+}
+
+// SortChangelog sorts the entries newest version first.
+func SortChangelog(entries []ChangelogEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return CompareChangelogVersions(entries[i].Version, entries[j].Version) > 0
+	})
+}
+
+// ChangelogStore keeps the changelog, and the latest version each user has
+// seen the release notes of.
+type ChangelogStore interface {
+	// ChangelogEntries returns the entries, newest version first.
+	ChangelogEntries() ([]ChangelogEntry, error)
+	// SaveChangelogEntry creates the entry of its version, or replaces it.
+	SaveChangelogEntry(entry ChangelogEntry) (ChangelogEntry, error)
+	// DeleteChangelogEntry deletes the entry of the version, or returns
+	// ErrChangelogEntryNotFound.
+	DeleteChangelogEntry(version string) error
+	// LastSeenVersion returns the latest version the user has seen, or an
+	// empty string.
+	LastSeenVersion(userID string) (string, error)
+	// SetLastSeenVersion records the user has seen the version.
+	SetLastSeenVersion(userID, version string) error
+}
+
+// SQLChangelogStore keeps the changelog in the database.
+type SQLChangelogStore struct {
+	DB *sql.DB
+}
+
+// ChangelogEntries returns the entries, newest version first.
+func (s *SQLChangelogStore) ChangelogEntries() ([]ChangelogEntry, error) {
+	rows, err := s.DB.Query(`SELECT version, title, body, published_at, updated_by FROM changelog_entries`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []ChangelogEntry{}
+	for rows.Next() {
+		var e ChangelogEntry
+		if err := rows.Scan(&e.Version, &e.Title, &e.Body, &e.PublishedAt, &e.UpdatedBy); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Versions don't sort as text, e.g. 1.10 and 1.9.
+	SortChangelog(entries)
+	return entries, nil
+}
+
+// SaveChangelogEntry creates the entry of its version, or replaces it.
+func (s *SQLChangelogStore) SaveChangelogEntry(e ChangelogEntry) (ChangelogEntry, error) {
+	if e.PublishedAt.IsZero() {
+		e.PublishedAt = time.Now().UTC()
+	}
+	_, err := s.DB.Exec(`INSERT INTO changelog_entries (version, title, body, published_at, updated_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (version) DO UPDATE SET title = $2, body = $3, published_at = $4, updated_by = $5`,
+		e.Version, e.Title, e.Body, e.PublishedAt, e.UpdatedBy)
+	if err != nil {
+		return ChangelogEntry{}, err
+	}
+	return e, nil
+}
+
+// DeleteChangelogEntry deletes the entry of the version.
+func (s *SQLChangelogStore) DeleteChangelogEntry(version string) error {
+	res, err := s.DB.Exec(`DELETE FROM changelog_entries WHERE version = $1`, version)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrChangelogEntryNotFound
+	}
+	return err
+}
+
+// LastSeenVersion returns the latest version the user has seen.
+func (s *SQLChangelogStore) LastSeenVersion(userID string) (string, error) {
+	var version string
+	err := s.DB.QueryRow(`SELECT version FROM changelog_seen WHERE user_id = $1`, userID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return version, err
+}
+
+// SetLastSeenVersion records the user has seen the version.
+func (s *SQLChangelogStore) SetLastSeenVersion(userID, version string) error {
+	_, err := s.DB.Exec(`INSERT INTO changelog_seen (user_id, version, seen_at) VALUES ($1, $2, now())
+		ON CONFLICT (user_id) DO UPDATE SET version = $2, seen_at = now()`, userID, version)
+	return err
+}
+
+// MemoryChangelogStore keeps the changelog in memory. It's used when no
+// database is configured, so it's lost when the app restarts.
+type MemoryChangelogStore struct {
+	mu      sync.Mutex
+	entries map[string]ChangelogEntry
+	seen    map[string]string
+}
+
+// ChangelogEntries returns the entries, newest version first.
+func (s *MemoryChangelogStore) ChangelogEntries() ([]ChangelogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]ChangelogEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	SortChangelog(entries)
+	return entries, nil
+}
+
+// SaveChangelogEntry creates the entry of its version, or replaces it.
+func (s *MemoryChangelogStore) SaveChangelogEntry(e ChangelogEntry) (ChangelogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.PublishedAt.IsZero() {
+		e.PublishedAt = time.Now().UTC()
+	}
+	if s.entries == nil {
+		s.entries = make(map[string]ChangelogEntry)
+	}
+	s.entries[e.Version] = e
+	return e, nil
+}
+
+// DeleteChangelogEntry deletes the entry of the version.
+func (s *MemoryChangelogStore) DeleteChangelogEntry(version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[version]; !ok {
+		return ErrChangelogEntryNotFound
+	}
+	delete(s.entries, version)
+	return nil
+}
+
+// LastSeenVersion returns the latest version the user has seen.
+func (s *MemoryChangelogStore) LastSeenVersion(userID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen[userID], nil
+}
+
+// SetLastSeenVersion records the user has seen the version.
+func (s *MemoryChangelogStore) SetLastSeenVersion(userID, version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen == nil {
+		s.seen = make(map[string]string)
+	}
+	s.seen[userID] = version
+	return nil
+}
@@ -0,0 +1,44 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestCompareChangelogVersions(t *testing.T) {
+	for _, tt := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.10", "1.9", 1},
+		{"v1.4.0", "1.4", 0},
+		{"1.4.0-beta.1", "1.4.0", -1},
+		{"1.4.0-beta.2", "1.4.0-beta.1", 1},
+		{"2", "10.0.1", -1},
+	} {
+		if result := db.CompareChangelogVersions(tt.a, tt.b); result != tt.expected {
+			t.Errorf("Expected %s compared to %s to be %d. Found %d", tt.a, tt.b, tt.expected, result)
+		}
+	}
+}
+
+func TestMemoryChangelogStore(t *testing.T) {
+	store := &db.MemoryChangelogStore{}
+	for _, version := range []string{"1.9", "1.10", "1.9.1"} {
+		if _, err := store.SaveChangelogEntry(db.ChangelogEntry{Version: version, Body: "Notes"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, _ := store.ChangelogEntries()
+	if len(entries) != 3 || entries[0].Version != "1.10" || entries[2].Version != "1.9" {
+		t.Errorf("Expected the entries newest first. Found %+v", entries)
+	}
+	if err := store.DeleteChangelogEntry("2.0"); err != db.ErrChangelogEntryNotFound {
+		t.Errorf("Expected %v. Found %v", db.ErrChangelogEntryNotFound, err)
+	}
+	store.SetLastSeenVersion("user-guid", "1.9.1")
+	if version, _ := store.LastSeenVersion("user-guid"); version != "1.9.1" {
+		t.Errorf("Expected 1.9.1 to be seen. Found %q", version)
+	}
+}
@@ -0,0 +1,268 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxQuickLinks is the most quick links a deployment can have.
+const MaxQuickLinks = 20
+
+// QuickLink is a link in the dashboard's quick links panel.
+type QuickLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// SupportContact is how users reach the support team of the deployment.
+type SupportContact struct {
+	Email string `json:"email,omitempty"`
+	Phone string `json:"phone,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// MaxFooterLength is the longest footer text a theme can have.
+const MaxFooterLength = 1000
+
+// colorPattern matches the hex colors a theme can use, e.g. #205493.
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Theme brands the dashboard of a deployment. Fields left empty keep the
+// dashboard's own branding.
+type Theme struct {
+	ProductName  string `json:"product_name,omitempty"`
+	LogoURL      string `json:"logo_url,omitempty"`
+	PrimaryColor string `json:"primary_color,omitempty"`
+	AccentColor  string `json:"accent_color,omitempty"`
+	Footer       string `json:"footer,omitempty"`
+}
+
+// Validate checks the theme is safe to put in the page.
+func (t Theme) Validate() error {
+	// The logo can also be served by the dashboard itself, e.g. from a skin.
+	isLocalPath := strings.HasPrefix(t.LogoURL, "/") && !strings.HasPrefix(t.LogoURL, "//")
+	if t.LogoURL != "" && !isLocalPath {
+		if err := validateURL(t.LogoURL); err != nil {
+			return fmt.Errorf("logo_url: %v", err)
+		}
+	}
+	for name, color := range map[string]string{"primary_color": t.PrimaryColor, "accent_color": t.AccentColor} {
+		if color != "" && !colorPattern.MatchString(color) {
+			return fmt.Errorf("%s: %q is not a hex color like #205493", name, color)
+		}
+	}
+	if len(t.Footer) > MaxFooterLength {
+		return fmt.Errorf("the footer is longer than %d characters", MaxFooterLength)
+	}
+	return nil
+}
+
+// WithDefaults fills the fields left empty from the defaults.
+func (t Theme) WithDefaults(defaults Theme) Theme {
+	return Theme{
+		ProductName:  orDefault(t.ProductName, defaults.ProductName),
+		LogoURL:      orDefault(t.LogoURL, defaults.LogoURL),
+		PrimaryColor: orDefault(t.PrimaryColor, defaults.PrimaryColor),
+		AccentColor:  orDefault(t.AccentColor, defaults.AccentColor),
+		Footer:       orDefault(t.Footer, defaults.Footer),
+	}
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// DeploymentContent is the content shown in the dashboard that differs from
+// one deployment to another, managed by platform admins.
+type DeploymentContent struct {
+	QuickLinks []QuickLink    `json:"quick_links"`
+	DocsURL    string         `json:"docs_url,omitempty"`
+	Support    SupportContact `json:"support"`
+	Theme      Theme          `json:"theme"`
+	UpdatedBy  string         `json:"updated_by,omitempty"`
+	UpdatedAt  *time.Time     `json:"updated_at,omitempty"`
+	// Version counts the saves of the content, so concurrent edits can't
+	// overwrite each other. It's 0 until the content is first saved.
+	Version int `json:"version,omitempty"`
+}
+
+// Validate checks the content is safe to show to users.
+func (c DeploymentContent) Validate() error {
+	if len(c.QuickLinks) > MaxQuickLinks {
+		return fmt.Errorf("at most %d quick links are allowed", MaxQuickLinks)
+	}
+	for i, link := range c.QuickLinks {
+		if strings.TrimSpace(link.Title) == "" {
+			return fmt.Errorf("quick link %d has no title", i+1)
+		}
+		if err := validateURL(link.URL); err != nil {
+			return fmt.Errorf("quick link %d: %v", i+1, err)
+		}
+	}
+	if c.DocsURL != "" {
+		if err := validateURL(c.DocsURL); err != nil {
+			return fmt.Errorf("docs_url: %v", err)
+		}
+	}
+	if c.Support.URL != "" {
+		if err := validateURL(c.Support.URL); err != nil {
+			return fmt.Errorf("support url: %v", err)
+		}
+	}
+	if c.Support.Email != "" && !strings.Contains(c.Support.Email, "@") {
+		return errors.New("support email is not an email address")
+	}
+	return c.Theme.Validate()
+}
+
+// validateURL only allows absolute http and https URLs, so links can't run
+// scripts in the dashboard.
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute http or https URL", raw)
+	}
+	return nil
+}
+
+// ContentStore keeps the deployment content.
+type ContentStore interface {
+	// Content returns the current content, which is empty until it's first
+	// saved.
+	Content() (DeploymentContent, error)
+	// SaveContent replaces the content if it's still at content.Version, or
+	// returns ErrVersionConflict, and returns it with its new version.
+	SaveContent(content DeploymentContent) (DeploymentContent, error)
+}
+
+// emptyContent is the content of a deployment that hasn't saved any yet.
+func emptyContent() DeploymentContent {
+	return DeploymentContent{QuickLinks: []QuickLink{}}
+}
+
+// SQLContentStore keeps the deployment content in the database.
+type SQLContentStore struct {
+	DB *sql.DB
+}
+
+// Content returns the current content.
+func (s *SQLContentStore) Content() (DeploymentContent, error) {
+	var (
+		raw       []byte
+		updatedBy string
+		updatedAt time.Time
+		version   int
+	)
+	err := s.DB.QueryRow(`SELECT content, updated_by, updated_at, version FROM deployment_content WHERE id = 1`).
+		Scan(&raw, &updatedBy, &updatedAt, &version)
+	if err == sql.ErrNoRows {
+		return emptyContent(), nil
+	}
+	if err != nil {
+		return DeploymentContent{}, err
+	}
+	content := emptyContent()
+	if err := json.Unmarshal(raw, &content); err != nil {
+		return DeploymentContent{}, err
+	}
+	content.UpdatedBy = updatedBy
+	content.UpdatedAt = &updatedAt
+	content.Version = version
+	return content, nil
+}
+
+// SaveContent replaces the content if it's still at content.Version.
+func (s *SQLContentStore) SaveContent(content DeploymentContent) (DeploymentContent, error) {
+	saved := content
+	updatedBy, updatedAt, version := content.UpdatedBy, time.Now().UTC(), content.Version
+	if content.UpdatedAt != nil {
+		updatedAt = *content.UpdatedAt
+	}
+	// The update metadata has its own columns.
+	content.UpdatedBy, content.UpdatedAt, content.Version = "", nil, 0
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return DeploymentContent{}, err
+	}
+	var query string
+	switch version {
+	case AnyVersion:
+		query = `INSERT INTO deployment_content (id, content, updated_by, updated_at, version)
+		VALUES (1, $1, $2, $3, 1)
+		ON CONFLICT (id) DO UPDATE SET content = $1, updated_by = $2, updated_at = $3,
+			version = deployment_content.version + 1
+		RETURNING version`
+	case 0:
+		query = `INSERT INTO deployment_content (id, content, updated_by, updated_at, version)
+		VALUES (1, $1, $2, $3, 1)
+		ON CONFLICT (id) DO NOTHING
+		RETURNING version`
+	default:
+		query = `UPDATE deployment_content SET content = $1, updated_by = $2, updated_at = $3, version = version + 1
+		WHERE id = 1 AND version = $4
+		RETURNING version`
+	}
+	args := []interface{}{raw, updatedBy, updatedAt}
+	if version > 0 {
+		args = append(args, version)
+	}
+	err = s.DB.QueryRow(query, args...).Scan(&saved.Version)
+	if err == sql.ErrNoRows {
+		return DeploymentContent{}, ErrVersionConflict
+	}
+	if err != nil {
+		return DeploymentContent{}, err
+	}
+	saved.UpdatedAt = &updatedAt
+	return saved, nil
+}
+
+// MemoryContentStore keeps the deployment content in memory. It's used when
+// no database is configured, so the content is lost when the app restarts.
+type MemoryContentStore struct {
+	mu      sync.Mutex
+	content DeploymentContent
+	saved   bool
+}
+
+// Content returns the current content.
+func (s *MemoryContentStore) Content() (DeploymentContent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.saved {
+		return emptyContent(), nil
+	}
+	content := s.content
+	content.QuickLinks = append([]QuickLink{}, s.content.QuickLinks...)
+	return content, nil
+}
+
+// SaveContent replaces the content if it's still at content.Version.
+func (s *MemoryContentStore) SaveContent(content DeploymentContent) (DeploymentContent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if content.Version != AnyVersion && content.Version != s.content.Version {
+		return DeploymentContent{}, ErrVersionConflict
+	}
+	if content.UpdatedAt == nil {
+		now := time.Now().UTC()
+		content.UpdatedAt = &now
+	}
+	content.QuickLinks = append([]QuickLink{}, content.QuickLinks...)
+	content.Version = s.content.Version + 1
+	s.content = content
+	s.saved = true
+	saved := content
+	saved.QuickLinks = append([]QuickLink{}, content.QuickLinks...)
+	return saved, nil
+}
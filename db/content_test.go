@@ -0,0 +1,193 @@
+package db_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+var validateTests = []struct {
+	name    string
+	content db.DeploymentContent
+	valid   bool
+}{
+	{
+		name:  "empty",
+		valid: true,
+	},
+	{
+		name: "complete",
+		content: db.DeploymentContent{
+			QuickLinks: []db.QuickLink{{Title: "Status", URL: "https://status.example.com"}},
+			DocsURL:    "https://docs.example.com",
+			Support:    db.SupportContact{Email: "support@example.com", Phone: "555-0100", URL: "http://help.example.com"},
+		},
+		valid: true,
+	},
+	{
+		name:    "untitled link",
+		content: db.DeploymentContent{QuickLinks: []db.QuickLink{{Title: " ", URL: "https://status.example.com"}}},
+	},
+	{
+		name:    "script link",
+		content: db.DeploymentContent{QuickLinks: []db.QuickLink{{Title: "Status", URL: "javascript:alert(1)"}}},
+	},
+	{
+		name:    "relative docs url",
+		content: db.DeploymentContent{DocsURL: "/docs"},
+	},
+	{
+		name:    "bad support email",
+		content: db.DeploymentContent{Support: db.SupportContact{Email: "support"}},
+	},
+	{
+		name:    "too many links",
+		content: db.DeploymentContent{QuickLinks: make([]db.QuickLink, db.MaxQuickLinks+1)},
+	},
+	{
+		name: "theme",
+		content: db.DeploymentContent{Theme: db.Theme{
+			ProductName:  "Agency Cloud",
+			LogoURL:      "/skins/agency/logo.svg",
+			PrimaryColor: "#205493",
+			AccentColor:  "#fff",
+			Footer:       "Operated by the Agency",
+		}},
+		valid: true,
+	},
+	{
+		name:    "protocol-relative logo",
+		content: db.DeploymentContent{Theme: db.Theme{LogoURL: "//evil.example.com/logo.svg"}},
+	},
+	{
+		name:    "script logo",
+		content: db.DeploymentContent{Theme: db.Theme{LogoURL: "javascript:alert(1)"}},
+	},
+	{
+		name:    "css in color",
+		content: db.DeploymentContent{Theme: db.Theme{PrimaryColor: "red; background: url(x)"}},
+	},
+	{
+		name:    "long footer",
+		content: db.DeploymentContent{Theme: db.Theme{Footer: strings.Repeat("a", db.MaxFooterLength+1)}},
+	},
+}
+
+func TestThemeWithDefaults(t *testing.T) {
+	theme := db.Theme{ProductName: "Agency Cloud"}.WithDefaults(db.Theme{ProductName: "Default", Footer: "Footer"})
+	if theme != (db.Theme{ProductName: "Agency Cloud", Footer: "Footer"}) {
+		t.Errorf("Unexpected theme %+v", theme)
+	}
+}
+
+func TestDeploymentContentValidate(t *testing.T) {
+	for _, test := range validateTests {
+		err := test.content.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestSQLContentStore(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	store := &db.SQLContentStore{DB: conn}
+
+	// Nothing saved yet.
+	mock.ExpectQuery("SELECT content, updated_by, updated_at, version FROM deployment_content").
+		WillReturnRows(sqlmock.NewRows([]string{"content", "updated_by", "updated_at", "version"}))
+	content, err := store.Content()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.QuickLinks == nil || len(content.QuickLinks) != 0 || content.UpdatedAt != nil {
+		t.Errorf("Expected empty content, got %+v", content)
+	}
+
+	updatedAt := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	raw := []byte(`{"quick_links":[{"title":"Status","url":"https://status.example.com"}],"support":{},"theme":{}}`)
+	mock.ExpectQuery("INSERT INTO deployment_content .* DO NOTHING").
+		WithArgs(raw, "admin-guid", updatedAt).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+	content, err = store.SaveContent(db.DeploymentContent{
+		QuickLinks: []db.QuickLink{{Title: "Status", URL: "https://status.example.com"}},
+		UpdatedBy:  "admin-guid",
+		UpdatedAt:  &updatedAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.Version != 1 || content.UpdatedBy != "admin-guid" {
+		t.Errorf("Unexpected saved content %+v", content)
+	}
+
+	mock.ExpectQuery("SELECT content, updated_by, updated_at, version FROM deployment_content").
+		WillReturnRows(sqlmock.NewRows([]string{"content", "updated_by", "updated_at", "version"}).
+			AddRow([]byte(`{"quick_links":[{"title":"Status","url":"https://status.example.com"}],"docs_url":"https://docs.example.com","support":{}}`), "admin-guid", updatedAt, 3))
+	content, err = store.Content()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content.QuickLinks) != 1 || content.DocsURL != "https://docs.example.com" ||
+		content.UpdatedBy != "admin-guid" || !content.UpdatedAt.Equal(updatedAt) || content.Version != 3 {
+		t.Errorf("Unexpected content %+v", content)
+	}
+
+	// Saving over a version someone else has since replaced conflicts.
+	mock.ExpectQuery("UPDATE deployment_content SET .* WHERE id = 1 AND version = \\$4").
+		WithArgs(raw, "admin-guid", updatedAt, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	_, err = store.SaveContent(db.DeploymentContent{
+		QuickLinks: []db.QuickLink{{Title: "Status", URL: "https://status.example.com"}},
+		UpdatedBy:  "admin-guid",
+		UpdatedAt:  &updatedAt,
+		Version:    2,
+	})
+	if err != db.ErrVersionConflict {
+		t.Errorf("Expected %v. Found %v", db.ErrVersionConflict, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryContentStore(t *testing.T) {
+	store := &db.MemoryContentStore{}
+	content, _ := store.Content()
+	if content.QuickLinks == nil || len(content.QuickLinks) != 0 {
+		t.Errorf("Expected empty content, got %+v", content)
+	}
+
+	links := []db.QuickLink{{Title: "Status", URL: "https://status.example.com"}}
+	store.SaveContent(db.DeploymentContent{QuickLinks: links, UpdatedBy: "admin-guid"})
+	// Changes to the saved links must not change the store.
+	links[0].Title = "Changed"
+
+	content, _ = store.Content()
+	if len(content.QuickLinks) != 1 || content.QuickLinks[0].Title != "Status" {
+		t.Errorf("Unexpected quick links %+v", content.QuickLinks)
+	}
+	if content.UpdatedBy != "admin-guid" || content.UpdatedAt == nil || content.Version != 1 {
+		t.Errorf("Expected update metadata, got %+v", content)
+	}
+
+	if _, err := store.SaveContent(db.DeploymentContent{Version: 0}); err != db.ErrVersionConflict {
+		t.Errorf("Expected %v. Found %v", db.ErrVersionConflict, err)
+	}
+	saved, err := store.SaveContent(db.DeploymentContent{Version: db.AnyVersion})
+	if err != nil || saved.Version != 2 {
+		t.Errorf("Expected version 2. Found %+v, %v", saved, err)
+	}
+}
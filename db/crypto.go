@@ -0,0 +1,115 @@
+package db
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// encryptedPrefix marks the values encrypted by a ColumnCipher. Values
+// without it were written before encryption was turned on, and are read as
+// they are.
+var encryptedPrefix = []byte("enc1:")
+
+// ErrNoColumnKey is returned when reading an encrypted value without the key
+// it was encrypted with.
+var ErrNoColumnKey = errors.New("the value is encrypted with a key that isn't configured")
+
+// ColumnCipher encrypts sensitive columns with AES-256-GCM before they're
+// written to the database, so they're protected beyond the disk encryption of
+// the database service. Values are encrypted with the current key, and read
+// with the current or a previous key, so keys can be rotated without
+// rewriting the existing rows first.
+type ColumnCipher struct {
+	keys []cipher.AEAD
+}
+
+// NewColumnCipher creates a ColumnCipher encrypting with the first key and
+// decrypting with any of them. Keys are 32 bytes.
+func NewColumnCipher(keys ...[]byte) (*ColumnCipher, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no key")
+	}
+	c := &ColumnCipher{}
+	for i, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %d is %d bytes, expected 32", i+1, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.keys = append(c.keys, aead)
+	}
+	return c, nil
+}
+
+// ParseColumnCipher creates a ColumnCipher from the hex encoded current key
+// and comma separated previous keys. It returns nil without a current key, so
+// columns are written as they are.
+func ParseColumnCipher(current, previous string) (*ColumnCipher, error) {
+	if current == "" {
+		if previous != "" {
+			return nil, errors.New("previous keys are set without a current key")
+		}
+		return nil, nil
+	}
+	var keys [][]byte
+	for _, k := range append([]string{current}, strings.Split(previous, ",")...) {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+		key, err := hex.DecodeString(k)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return NewColumnCipher(keys...)
+}
+
+// Encrypt returns the value encrypted with the current key. A nil
+// ColumnCipher returns it as it is.
+func (c *ColumnCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	if c == nil || plaintext == nil {
+		return plaintext, nil
+	}
+	aead := c.keys[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append([]byte{}, encryptedPrefix...), nonce...)
+	return aead.Seal(sealed, nonce, plaintext, nil), nil
+}
+
+// Decrypt returns the value decrypted with whichever key it was encrypted
+// with. Values that aren't encrypted are returned as they are.
+func (c *ColumnCipher) Decrypt(value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	if c == nil {
+		return nil, ErrNoColumnKey
+	}
+	value = value[len(encryptedPrefix):]
+	for _, aead := range c.keys {
+		if len(value) < aead.NonceSize() {
+			break
+		}
+		nonce, sealed := value[:aead.NonceSize()], value[aead.NonceSize():]
+		if plaintext, err := aead.Open(nil, nonce, sealed, nil); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, ErrNoColumnKey
+}
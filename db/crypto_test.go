@@ -0,0 +1,129 @@
+package db_test
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestColumnCipher(t *testing.T) {
+	old, err := db.NewColumnCipher(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte(`{"credentials":{"password":"hunter2"}}`)
+	encrypted, err := old.Encrypt(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, []byte("hunter2")) {
+		t.Errorf("Expected the value to be encrypted. Found %q", encrypted)
+	}
+
+	// After the rotation, values are written with the new key and the old
+	// ones are still read.
+	rotated, err := db.NewColumnCipher(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err := rotated.Decrypt(encrypted); err != nil || !bytes.Equal(decrypted, secret) {
+		t.Errorf("Expected the old value to be read. Found %q, %v", decrypted, err)
+	}
+	reencrypted, _ := rotated.Encrypt(secret)
+	if _, err := old.Decrypt(reencrypted); err != db.ErrNoColumnKey {
+		t.Errorf("Expected the new value to need the new key. Found %v", err)
+	}
+	newOnly, _ := db.NewColumnCipher(newKey)
+	if _, err := newOnly.Decrypt(encrypted); err != db.ErrNoColumnKey {
+		t.Errorf("Expected the old value to need the old key. Found %v", err)
+	}
+
+	// Values written before encryption was turned on are read as they are.
+	if decrypted, err := rotated.Decrypt(secret); err != nil || !bytes.Equal(decrypted, secret) {
+		t.Errorf("Expected the plain value. Found %q, %v", decrypted, err)
+	}
+	var none *db.ColumnCipher
+	if plain, err := none.Encrypt(secret); err != nil || !bytes.Equal(plain, secret) {
+		t.Errorf("Expected no encryption without a cipher. Found %q, %v", plain, err)
+	}
+	if _, err := none.Decrypt(encrypted); err != db.ErrNoColumnKey {
+		t.Errorf("Expected %v. Found %v", db.ErrNoColumnKey, err)
+	}
+}
+
+func TestParseColumnCipher(t *testing.T) {
+	key := strings.Repeat("ab", 32)
+	testCases := []struct {
+		current, previous string
+		expectCipher      bool
+		expectErr         bool
+	}{
+		{"", "", false, false},
+		{key, "", true, false},
+		{key, strings.Repeat("cd", 32) + ", " + strings.Repeat("ef", 32), true, false},
+		{"", key, false, true},
+		{"abcd", "", false, true},
+		{"not hex", "", false, true},
+	}
+	for _, test := range testCases {
+		cipher, err := db.ParseColumnCipher(test.current, test.previous)
+		if (cipher != nil) != test.expectCipher || (err != nil) != test.expectErr {
+			t.Errorf("%q, %q: unexpected %v, %v", test.current, test.previous, cipher, err)
+		}
+	}
+}
+
+// encryptedArg matches a value that was encrypted.
+type encryptedArg struct{}
+
+func (encryptedArg) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	return ok && bytes.HasPrefix(b, []byte("enc1:")) && !bytes.Contains(b, []byte("hunter2"))
+}
+
+func TestSQLPendingChangeStoreEncryption(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cipher, _ := db.NewColumnCipher(newKey)
+	store := &db.SQLPendingChangeStore{DB: conn, Cipher: cipher}
+
+	body := []byte(`{"credentials":{"password":"hunter2"}}`)
+	mock.ExpectExec("INSERT INTO pending_changes").
+		WithArgs(sqlmock.AnyArg(), "user_provided_service", "PUT", "/v2/user_provided_service_instances/ups-1",
+			encryptedArg{}, "admin-guid", db.PendingChangePending, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	change, err := store.CreatePendingChange(db.PendingChange{
+		Class: "user_provided_service", Method: "PUT", Path: "/v2/user_provided_service_instances/ups-1",
+		Body: body, RequestedBy: "admin-guid",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, _ := cipher.Encrypt(body)
+	mock.ExpectQuery("SELECT .* FROM pending_changes WHERE id").WithArgs(change.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "class", "method", "path", "body", "requested_by", "status", "created_at", "decided_by", "decided_at"}).
+			AddRow(change.ID, change.Class, change.Method, change.Path, encrypted, "admin-guid", db.PendingChangePending, time.Now(), "", nil))
+	read, err := store.PendingChange(change.ID)
+	if err != nil || string(read.Body) != string(body) {
+		t.Errorf("Expected the body to be decrypted. Found %q, %v", read.Body, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,50 @@
+// Package db stores the dashboard's own data, such as operator-managed
+// content, in a PostgreSQL database. Cloud Foundry data is never stored here.
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	// Registers the postgres driver with database/sql.
+	_ "github.com/lib/pq"
+)
+
+// AnyVersion saves a versioned resource whatever its current version.
+const AnyVersion = -1
+
+// ErrVersionConflict is returned when saving a versioned resource, such as
+// the deployment content, that was changed since the version being saved was
+// read.
+var ErrVersionConflict = errors.New("it was changed since it was read")
+
+// Open connects to the PostgreSQL database at the URL, retrying for a while if
+// it's unavailable, and brings its schema up to date.
+func Open(url string, options PoolOptions) (*sql.DB, error) {
+	conn, err := Connect(url, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := Migrate(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Connect connects to the PostgreSQL database at the URL, retrying for a while
+// if it's unavailable, without touching its schema.
+func Connect(url string, options PoolOptions) (*sql.DB, error) {
+	conn, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	options.apply(conn)
+	if err := retryWithBackoff(conn.Ping, options.ConnectTimeout, time.Sleep); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not connect to the database: %v", err)
+	}
+	return conn, nil
+}
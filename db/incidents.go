@@ -0,0 +1,200 @@
+package db
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// IncidentCrashLoop is the kind of the incidents of apps crashing repeatedly.
+const IncidentCrashLoop = "crash_loop"
+
+// Incident is something going wrong with an app of an org, like a crash
+// loop. It's open until it stops happening.
+type Incident struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	OrgGUID   string `json:"org_guid"`
+	SpaceGUID string `json:"space_guid"`
+	AppGUID   string `json:"app_guid"`
+	AppName   string `json:"app_name"`
+	// Crashes is how many times the app crashed since the incident opened.
+	Crashes int `json:"crashes"`
+	// LastExitDescription is why the app last crashed.
+	LastExitDescription string    `json:"last_exit_description,omitempty"`
+	OpenedAt            time.Time `json:"opened_at"`
+	LastSeenAt          time.Time `json:"last_seen_at"`
+	// EscalatedAt is when the org was notified again because the incident
+	// went on.
+	EscalatedAt *time.Time `json:"escalated_at,omitempty"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Open returns true until the incident is resolved.
+func (i Incident) Open() bool {
+	return i.ResolvedAt == nil
+}
+
+// IncidentStore keeps the incidents of the orgs' apps.
+type IncidentStore interface {
+	// CreateIncident keeps a new incident and returns it with its ID.
+	CreateIncident(i Incident) (Incident, error)
+	// UpdateIncident replaces the incident with its ID.
+	UpdateIncident(i Incident) error
+	// OpenIncidents returns the incidents that aren't resolved, oldest
+	// first.
+	OpenIncidents() ([]Incident, error)
+	// Incidents returns at most limit of the org's incidents seen since the
+	// time, newest first.
+	Incidents(orgGUID string, since time.Time, limit int) ([]Incident, error)
+	// PurgeIncidents drops the incidents resolved before the time and
+	// returns how many it dropped.
+	PurgeIncidents(before time.Time) (int64, error)
+}
+
+// SQLIncidentStore keeps the incidents in the database.
+type SQLIncidentStore struct {
+	DB *sql.DB
+}
+
+const incidentColumns = `id, kind, org_guid, space_guid, app_guid, app_name, crashes, last_exit_description,
+	opened_at, last_seen_at, escalated_at, resolved_at`
+
+// CreateIncident keeps a new incident.
+func (s *SQLIncidentStore) CreateIncident(i Incident) (Incident, error) {
+	id, err := newID()
+	if err != nil {
+		return Incident{}, err
+	}
+	i.ID = id
+	_, err = s.DB.Exec(`INSERT INTO incidents (`+incidentColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		i.ID, i.Kind, i.OrgGUID, i.SpaceGUID, i.AppGUID, i.AppName, i.Crashes, i.LastExitDescription,
+		i.OpenedAt, i.LastSeenAt, i.EscalatedAt, i.ResolvedAt)
+	return i, err
+}
+
+// UpdateIncident replaces the incident.
+func (s *SQLIncidentStore) UpdateIncident(i Incident) error {
+	_, err := s.DB.Exec(`UPDATE incidents SET app_name = $2, crashes = $3, last_exit_description = $4,
+		last_seen_at = $5, escalated_at = $6, resolved_at = $7 WHERE id = $1`,
+		i.ID, i.AppName, i.Crashes, i.LastExitDescription, i.LastSeenAt, i.EscalatedAt, i.ResolvedAt)
+	return err
+}
+
+// OpenIncidents returns the incidents that aren't resolved, oldest first.
+func (s *SQLIncidentStore) OpenIncidents() ([]Incident, error) {
+	return s.query(`SELECT ` + incidentColumns + ` FROM incidents WHERE resolved_at IS NULL ORDER BY opened_at`)
+}
+
+// Incidents returns the org's incidents seen since the time, newest first.
+func (s *SQLIncidentStore) Incidents(orgGUID string, since time.Time, limit int) ([]Incident, error) {
+	return s.query(`SELECT `+incidentColumns+` FROM incidents
+		WHERE org_guid = $1 AND last_seen_at >= $2 ORDER BY opened_at DESC LIMIT $3`, orgGUID, since, limit)
+}
+
+func (s *SQLIncidentStore) query(query string, args ...interface{}) ([]Incident, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	incidents := []Incident{}
+	for rows.Next() {
+		var i Incident
+		if err := rows.Scan(&i.ID, &i.Kind, &i.OrgGUID, &i.SpaceGUID, &i.AppGUID, &i.AppName, &i.Crashes,
+			&i.LastExitDescription, &i.OpenedAt, &i.LastSeenAt, &i.EscalatedAt, &i.ResolvedAt); err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, i)
+	}
+	return incidents, rows.Err()
+}
+
+// PurgeIncidents drops the incidents resolved before the time.
+func (s *SQLIncidentStore) PurgeIncidents(before time.Time) (int64, error) {
+	result, err := s.DB.Exec(`DELETE FROM incidents WHERE resolved_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MemoryIncidentStore keeps the incidents in memory. It's used when no
+// database is configured, so they're lost when the app restarts.
+type MemoryIncidentStore struct {
+	mu        sync.Mutex
+	incidents []Incident
+}
+
+// CreateIncident keeps a new incident.
+func (s *MemoryIncidentStore) CreateIncident(i Incident) (Incident, error) {
+	id, err := newID()
+	if err != nil {
+		return Incident{}, err
+	}
+	i.ID = id
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.incidents = append(s.incidents, i)
+	return i, nil
+}
+
+// UpdateIncident replaces the incident.
+func (s *MemoryIncidentStore) UpdateIncident(i Incident) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for n := range s.incidents {
+		if s.incidents[n].ID == i.ID {
+			s.incidents[n] = i
+		}
+	}
+	return nil
+}
+
+// OpenIncidents returns the incidents that aren't resolved, oldest first.
+func (s *MemoryIncidentStore) OpenIncidents() ([]Incident, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	incidents := []Incident{}
+	for _, i := range s.incidents {
+		if i.Open() {
+			incidents = append(incidents, i)
+		}
+	}
+	sort.SliceStable(incidents, func(a, b int) bool { return incidents[a].OpenedAt.Before(incidents[b].OpenedAt) })
+	return incidents, nil
+}
+
+// Incidents returns the org's incidents seen since the time, newest first.
+func (s *MemoryIncidentStore) Incidents(orgGUID string, since time.Time, limit int) ([]Incident, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	incidents := []Incident{}
+	for _, i := range s.incidents {
+		if i.OrgGUID == orgGUID && !i.LastSeenAt.Before(since) {
+			incidents = append(incidents, i)
+		}
+	}
+	sort.SliceStable(incidents, func(a, b int) bool { return incidents[a].OpenedAt.After(incidents[b].OpenedAt) })
+	if len(incidents) > limit {
+		incidents = incidents[:limit]
+	}
+	return incidents, nil
+}
+
+// PurgeIncidents drops the incidents resolved before the time.
+func (s *MemoryIncidentStore) PurgeIncidents(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.incidents[:0]
+	for _, i := range s.incidents {
+		if i.Open() || !i.ResolvedAt.Before(before) {
+			kept = append(kept, i)
+		}
+	}
+	purged := int64(len(s.incidents) - len(kept))
+	s.incidents = kept
+	return purged, nil
+}
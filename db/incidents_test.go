@@ -0,0 +1,42 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestMemoryIncidentStore(t *testing.T) {
+	store := &db.MemoryIncidentStore{}
+	now := time.Now()
+	incident, err := store.CreateIncident(db.Incident{Kind: db.IncidentCrashLoop, OrgGUID: "org-1", AppGUID: "app-1",
+		Crashes: 3, OpenedAt: now.Add(-time.Hour), LastSeenAt: now.Add(-time.Hour)})
+	if err != nil || incident.ID == "" {
+		t.Fatalf("Expected a new incident. Found %+v, %v", incident, err)
+	}
+	store.CreateIncident(db.Incident{Kind: db.IncidentCrashLoop, OrgGUID: "org-2", AppGUID: "app-2", OpenedAt: now, LastSeenAt: now})
+
+	incident.Crashes, incident.LastSeenAt = 5, now
+	store.UpdateIncident(incident)
+	incidents, _ := store.Incidents("org-1", now.Add(-time.Minute), 10)
+	if len(incidents) != 1 || incidents[0].Crashes != 5 {
+		t.Errorf("Expected the org's updated incident. Found %+v", incidents)
+	}
+	if open, _ := store.OpenIncidents(); len(open) != 2 || open[0].ID != incident.ID {
+		t.Errorf("Expected both incidents open, oldest first. Found %+v", open)
+	}
+
+	resolved := now.Add(-48 * time.Hour)
+	incident.ResolvedAt = &resolved
+	store.UpdateIncident(incident)
+	if open, _ := store.OpenIncidents(); len(open) != 1 || open[0].OrgGUID != "org-2" {
+		t.Errorf("Expected the resolved incident to be closed. Found %+v", open)
+	}
+	if n, _ := store.PurgeIncidents(now.Add(-24 * time.Hour)); n != 1 {
+		t.Errorf("Expected the resolved incident to be purged. Found %d", n)
+	}
+	if incidents, _ := store.Incidents("org-1", time.Time{}, 10); len(incidents) != 0 {
+		t.Errorf("Expected no incidents left in org-1. Found %+v", incidents)
+	}
+}
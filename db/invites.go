@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// PendingInviteRetention is how long invites are waited on. Users who accept
+// later aren't measured.
+const PendingInviteRetention = 30 * 24 * time.Hour
+
+// PendingInviteStore keeps when users were invited until they first log in,
+// so how long they took to accept can be measured.
+type PendingInviteStore interface {
+	// RecordInvite keeps when the user was last invited.
+	RecordInvite(userID string, at time.Time) error
+	// AcceptInvite drops the user's pending invite and returns when it was
+	// sent, or the zero time when the user has none.
+	AcceptInvite(userID string) (time.Time, error)
+	// PurgeInvites drops the invites sent before the time and returns how
+	// many it dropped.
+	PurgeInvites(before time.Time) (int64, error)
+}
+
+// SQLPendingInviteStore keeps the pending invites in the database, so the
+// invited users can log in through any instance of the dashboard.
+type SQLPendingInviteStore struct {
+	DB *sql.DB
+}
+
+// RecordInvite keeps when the user was last invited.
+func (s *SQLPendingInviteStore) RecordInvite(userID string, at time.Time) error {
+	_, err := s.DB.Exec(`INSERT INTO pending_invites (user_id, invited_at) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET invited_at = $2`, userID, at)
+	return err
+}
+
+// AcceptInvite drops the user's pending invite and returns when it was sent.
+func (s *SQLPendingInviteStore) AcceptInvite(userID string) (time.Time, error) {
+	var invitedAt time.Time
+	err := s.DB.QueryRow(`DELETE FROM pending_invites WHERE user_id = $1 RETURNING invited_at`, userID).Scan(&invitedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return invitedAt, err
+}
+
+// PurgeInvites drops the invites sent before the time.
+func (s *SQLPendingInviteStore) PurgeInvites(before time.Time) (int64, error) {
+	result, err := s.DB.Exec(`DELETE FROM pending_invites WHERE invited_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MemoryPendingInviteStore keeps the pending invites in memory. It's used
+// when no database is configured, so they're lost when the app restarts.
+type MemoryPendingInviteStore struct {
+	mu      sync.Mutex
+	invites map[string]time.Time
+}
+
+// RecordInvite keeps when the user was last invited.
+func (s *MemoryPendingInviteStore) RecordInvite(userID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.invites == nil {
+		s.invites = make(map[string]time.Time)
+	}
+	s.invites[userID] = at
+	return nil
+}
+
+// AcceptInvite drops the user's pending invite and returns when it was sent.
+func (s *MemoryPendingInviteStore) AcceptInvite(userID string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	invitedAt := s.invites[userID]
+	delete(s.invites, userID)
+	return invitedAt, nil
+}
+
+// PurgeInvites drops the invites sent before the time.
+func (s *MemoryPendingInviteStore) PurgeInvites(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purged int64
+	for userID, invitedAt := range s.invites {
+		if invitedAt.Before(before) {
+			delete(s.invites, userID)
+			purged++
+		}
+	}
+	return purged, nil
+}
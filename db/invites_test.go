@@ -0,0 +1,26 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestMemoryPendingInviteStore(t *testing.T) {
+	store := &db.MemoryPendingInviteStore{}
+	now := time.Now()
+	store.RecordInvite("user-guid", now.Add(-2*time.Hour))
+	store.RecordInvite("user-guid", now.Add(-time.Hour))
+	store.RecordInvite("old-guid", now.Add(-db.PendingInviteRetention-time.Hour))
+
+	if invitedAt, _ := store.AcceptInvite("user-guid"); !invitedAt.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Expected the last invite to be accepted. Found %v", invitedAt)
+	}
+	if invitedAt, _ := store.AcceptInvite("user-guid"); !invitedAt.IsZero() {
+		t.Errorf("Expected the invite to be accepted once. Found %v", invitedAt)
+	}
+	if purged, _ := store.PurgeInvites(now.Add(-db.PendingInviteRetention)); purged != 1 {
+		t.Errorf("Expected 1 invite to be purged. Found %d", purged)
+	}
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LoginLocationRetention is how long the users' login locations are kept.
+// They're compared with new logins over the same period.
+const LoginLocationRetention = 90 * 24 * time.Hour
+
+// LoginLocation is where a user logged in from, as found in the operator's
+// geo database. Country and ASN are empty when the IP isn't in it, and the
+// coordinates are nil when it has none for the IP.
+type LoginLocation struct {
+	UserID    string    `json:"user_id"`
+	Time      time.Time `json:"time"`
+	IP        string    `json:"ip"`
+	Country   string    `json:"country,omitempty"`
+	ASN       int64     `json:"asn,omitempty"`
+	Latitude  *float64  `json:"latitude,omitempty"`
+	Longitude *float64  `json:"longitude,omitempty"`
+}
+
+// LoginLocationStore keeps where the users logged in from, so new logins can
+// be compared with them.
+type LoginLocationStore interface {
+	// RecordLoginLocation keeps where the user logged in from.
+	RecordLoginLocation(l LoginLocation) error
+	// LoginLocations returns the user's logins since the time, newest first.
+	LoginLocations(userID string, since time.Time) ([]LoginLocation, error)
+	// PurgeLoginLocations drops the logins before the time and returns how
+	// many it dropped.
+	PurgeLoginLocations(before time.Time) (int64, error)
+}
+
+// SQLLoginLocationStore keeps the login locations in the database, so all
+// the instances of the dashboard compare logins with the same history.
+type SQLLoginLocationStore struct {
+	DB *sql.DB
+}
+
+// RecordLoginLocation keeps where the user logged in from.
+func (s *SQLLoginLocationStore) RecordLoginLocation(l LoginLocation) error {
+	_, err := s.DB.Exec(`INSERT INTO login_locations
+		(user_id, logged_in_at, ip, country, asn, latitude, longitude)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		l.UserID, l.Time, l.IP, l.Country, l.ASN, l.Latitude, l.Longitude)
+	return err
+}
+
+// LoginLocations returns the user's logins since the time, newest first.
+func (s *SQLLoginLocationStore) LoginLocations(userID string, since time.Time) ([]LoginLocation, error) {
+	rows, err := s.DB.Query(`SELECT user_id, logged_in_at, ip, country, asn, latitude, longitude
+		FROM login_locations WHERE user_id = $1 AND logged_in_at >= $2
+		ORDER BY logged_in_at DESC`, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	locations := []LoginLocation{}
+	for rows.Next() {
+		var l LoginLocation
+		if err := rows.Scan(&l.UserID, &l.Time, &l.IP, &l.Country, &l.ASN, &l.Latitude, &l.Longitude); err != nil {
+			return nil, err
+		}
+		locations = append(locations, l)
+	}
+	return locations, rows.Err()
+}
+
+// PurgeLoginLocations drops the logins before the time.
+func (s *SQLLoginLocationStore) PurgeLoginLocations(before time.Time) (int64, error) {
+	result, err := s.DB.Exec(`DELETE FROM login_locations WHERE logged_in_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MemoryLoginLocationStore keeps the login locations in memory. It's used
+// when no database is configured, so they're lost when the app restarts.
+type MemoryLoginLocationStore struct {
+	mu        sync.Mutex
+	locations map[string][]LoginLocation
+}
+
+// RecordLoginLocation keeps where the user logged in from.
+func (s *MemoryLoginLocationStore) RecordLoginLocation(l LoginLocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locations == nil {
+		s.locations = make(map[string][]LoginLocation)
+	}
+	s.locations[l.UserID] = append(s.locations[l.UserID], l)
+	return nil
+}
+
+// LoginLocations returns the user's logins since the time, newest first.
+func (s *MemoryLoginLocationStore) LoginLocations(userID string, since time.Time) ([]LoginLocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	locations := []LoginLocation{}
+	for _, l := range s.locations[userID] {
+		if !l.Time.Before(since) {
+			locations = append(locations, l)
+		}
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i].Time.After(locations[j].Time) })
+	return locations, nil
+}
+
+// PurgeLoginLocations drops the logins before the time.
+func (s *MemoryLoginLocationStore) PurgeLoginLocations(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purged int64
+	for userID, locations := range s.locations {
+		kept := locations[:0]
+		for _, l := range locations {
+			if l.Time.Before(before) {
+				purged++
+			} else {
+				kept = append(kept, l)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.locations, userID)
+		} else {
+			s.locations[userID] = kept
+		}
+	}
+	return purged, nil
+}
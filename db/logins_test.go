@@ -0,0 +1,25 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestMemoryLoginLocationStore(t *testing.T) {
+	store := &db.MemoryLoginLocationStore{}
+	now := time.Now()
+	for _, ago := range []time.Duration{48 * time.Hour, time.Hour, 100 * 24 * time.Hour} {
+		store.RecordLoginLocation(db.LoginLocation{UserID: "user-guid", Time: now.Add(-ago), IP: "203.0.113.7"})
+	}
+	store.RecordLoginLocation(db.LoginLocation{UserID: "other-guid", Time: now, IP: "198.51.100.1"})
+
+	locations, _ := store.LoginLocations("user-guid", now.Add(-db.LoginLocationRetention))
+	if len(locations) != 2 || !locations[0].Time.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Expected the user's recent logins newest first. Found %+v", locations)
+	}
+	if purged, _ := store.PurgeLoginLocations(now.Add(-db.LoginLocationRetention)); purged != 1 {
+		t.Errorf("Expected 1 login to be purged. Found %d", purged)
+	}
+}
@@ -0,0 +1,527 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// migrationLockID is the Postgres advisory lock held while migrating, so only
+// one instance of the dashboard migrates the schema at a time.
+const migrationLockID = 0x63676462 // "cgdb"
+
+// migration is a change to the schema. Migrations are never edited once
+// released; change the schema by adding a new one.
+type migration struct {
+	Version     int
+	Description string
+	Up          string
+	// Down reverts Up, losing the data Up added.
+	Down string
+}
+
+// migrations are applied in order.
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "create deployment_content",
+		Up: `CREATE TABLE deployment_content (
+			id integer PRIMARY KEY CHECK (id = 1),
+			content jsonb NOT NULL,
+			updated_by text NOT NULL,
+			updated_at timestamptz NOT NULL
+		)`,
+		Down: `DROP TABLE deployment_content`,
+	},
+	{
+		Version:     2,
+		Description: "create user_preferences",
+		Up: `CREATE TABLE user_preferences (
+			user_id text PRIMARY KEY,
+			locale text NOT NULL,
+			timezone text NOT NULL,
+			updated_at timestamptz NOT NULL
+		)`,
+		Down: `DROP TABLE user_preferences`,
+	},
+	{
+		Version:     3,
+		Description: "create audit_events",
+		Up: `CREATE TABLE audit_events (
+			id bigserial PRIMARY KEY,
+			time timestamptz NOT NULL,
+			actor text NOT NULL,
+			action text NOT NULL,
+			remote_addr text NOT NULL,
+			details jsonb NOT NULL
+		);
+		CREATE INDEX audit_events_actor_time ON audit_events (actor, time)`,
+		Down: `DROP TABLE audit_events`,
+	},
+	{
+		Version:     4,
+		Description: "create role_requests",
+		Up: `CREATE TABLE role_requests (
+			id text PRIMARY KEY,
+			user_id text NOT NULL,
+			org_guid text NOT NULL,
+			space_guid text NOT NULL,
+			role text NOT NULL,
+			reason text NOT NULL,
+			status text NOT NULL,
+			created_at timestamptz NOT NULL,
+			decided_by text NOT NULL,
+			decided_at timestamptz
+		);
+		CREATE INDEX role_requests_org_status ON role_requests (org_guid, status)`,
+		Down: `DROP TABLE role_requests`,
+	},
+	{
+		Version:     5,
+		Description: "create pending_changes",
+		Up: `CREATE TABLE pending_changes (
+			id text PRIMARY KEY,
+			class text NOT NULL,
+			method text NOT NULL,
+			path text NOT NULL,
+			body bytea,
+			requested_by text NOT NULL,
+			status text NOT NULL,
+			created_at timestamptz NOT NULL,
+			decided_by text NOT NULL,
+			decided_at timestamptz
+		);
+		CREATE INDEX pending_changes_status ON pending_changes (status, created_at)`,
+		Down: `DROP TABLE pending_changes`,
+	},
+	{
+		Version:     6,
+		Description: "add versions to deployment_content and user_preferences",
+		Up: `ALTER TABLE deployment_content ADD COLUMN version integer NOT NULL DEFAULT 1;
+		ALTER TABLE user_preferences ADD COLUMN version integer NOT NULL DEFAULT 1`,
+		Down: `ALTER TABLE deployment_content DROP COLUMN version;
+		ALTER TABLE user_preferences DROP COLUMN version`,
+	},
+	{
+		Version:     7,
+		Description: "index audit_events by time for purging",
+		Up:          `CREATE INDEX audit_events_time ON audit_events (time)`,
+		Down:        `DROP INDEX audit_events_time`,
+	},
+	{
+		Version:     8,
+		Description: "create sessions",
+		Up: `CREATE TABLE sessions (
+			id text PRIMARY KEY,
+			data bytea NOT NULL,
+			expires_at timestamptz NOT NULL
+		);
+		CREATE INDEX sessions_expires_at ON sessions (expires_at)`,
+		Down: `DROP TABLE sessions`,
+	},
+	{
+		Version:     9,
+		Description: "create webhook_subscriptions and webhook_deliveries",
+		Up: `CREATE TABLE webhook_subscriptions (
+			id text PRIMARY KEY,
+			org_guid text NOT NULL,
+			url text NOT NULL,
+			events text NOT NULL,
+			secret bytea NOT NULL,
+			created_by text NOT NULL,
+			created_at timestamptz NOT NULL
+		);
+		CREATE INDEX webhook_subscriptions_org ON webhook_subscriptions (org_guid);
+		CREATE TABLE webhook_deliveries (
+			id text PRIMARY KEY,
+			subscription_id text NOT NULL REFERENCES webhook_subscriptions ON DELETE CASCADE,
+			event_id text NOT NULL,
+			event text NOT NULL,
+			status text NOT NULL,
+			attempts integer NOT NULL,
+			response_status integer NOT NULL,
+			error text NOT NULL,
+			created_at timestamptz NOT NULL,
+			finished_at timestamptz
+		);
+		CREATE INDEX webhook_deliveries_subscription ON webhook_deliveries (subscription_id, created_at)`,
+		Down: `DROP TABLE webhook_deliveries;
+		DROP TABLE webhook_subscriptions`,
+	},
+	{
+		Version:     10,
+		Description: "create incidents",
+		Up: `CREATE TABLE incidents (
+			id text PRIMARY KEY,
+			kind text NOT NULL,
+			org_guid text NOT NULL,
+			space_guid text NOT NULL,
+			app_guid text NOT NULL,
+			app_name text NOT NULL,
+			crashes integer NOT NULL,
+			last_exit_description text NOT NULL,
+			opened_at timestamptz NOT NULL,
+			last_seen_at timestamptz NOT NULL,
+			escalated_at timestamptz,
+			resolved_at timestamptz
+		);
+		CREATE INDEX incidents_org ON incidents (org_guid, last_seen_at);
+		CREATE INDEX incidents_open ON incidents (opened_at) WHERE resolved_at IS NULL`,
+		Down: `DROP TABLE incidents`,
+	},
+	{
+		Version:     11,
+		Description: "create secrets",
+		Up: `CREATE TABLE secrets (
+			id text PRIMARY KEY,
+			kind text NOT NULL,
+			owner text NOT NULL,
+			value bytea NOT NULL,
+			expires_at timestamptz NOT NULL
+		)`,
+		Down: `DROP TABLE secrets`,
+	},
+	{
+		Version:     12,
+		Description: "create access reviews",
+		Up: `CREATE TABLE access_reviews (
+			id text PRIMARY KEY,
+			campaign text NOT NULL,
+			org_guid text NOT NULL,
+			org_name text NOT NULL,
+			status text NOT NULL,
+			created_at timestamptz NOT NULL,
+			due_at timestamptz NOT NULL,
+			completed_at timestamptz,
+			entries jsonb NOT NULL
+		);
+		CREATE INDEX access_reviews_org ON access_reviews (org_guid, created_at);`,
+		Down: `DROP TABLE access_reviews`,
+	},
+	{
+		Version:     13,
+		Description: "create changelog",
+		Up: `CREATE TABLE changelog_entries (
+			version text PRIMARY KEY,
+			title text NOT NULL,
+			body text NOT NULL,
+			published_at timestamptz NOT NULL,
+			updated_by text NOT NULL
+		);
+		CREATE TABLE changelog_seen (
+			user_id text PRIMARY KEY,
+			version text NOT NULL,
+			seen_at timestamptz NOT NULL
+		)`,
+		Down: `DROP TABLE changelog_seen; DROP TABLE changelog_entries`,
+	},
+	{
+		Version:     14,
+		Description: "create role grants",
+		Up: `CREATE TABLE role_grants (
+			id text PRIMARY KEY,
+			user_id text NOT NULL,
+			org_guid text NOT NULL,
+			space_guid text NOT NULL,
+			role text NOT NULL,
+			reason text NOT NULL,
+			added_to_org boolean NOT NULL,
+			granted_by text NOT NULL,
+			created_at timestamptz NOT NULL,
+			expires_at timestamptz NOT NULL,
+			status text NOT NULL,
+			ended_by text NOT NULL,
+			ended_at timestamptz
+		);
+		CREATE INDEX role_grants_org ON role_grants (org_guid, created_at);
+		CREATE INDEX role_grants_due ON role_grants (expires_at) WHERE status = 'active'`,
+		Down: `DROP TABLE role_grants`,
+	},
+	{
+		Version:     15,
+		Description: "create login locations",
+		Up: `CREATE TABLE login_locations (
+			user_id text NOT NULL,
+			logged_in_at timestamptz NOT NULL,
+			ip text NOT NULL,
+			country text NOT NULL,
+			asn bigint NOT NULL,
+			latitude double precision,
+			longitude double precision
+		);
+		CREATE INDEX login_locations_user ON login_locations (user_id, logged_in_at)`,
+		Down: `DROP TABLE login_locations`,
+	},
+	{
+		Version:     16,
+		Description: "create pending invites",
+		Up: `CREATE TABLE pending_invites (
+			user_id text PRIMARY KEY,
+			invited_at timestamptz NOT NULL
+		)`,
+		Down: `DROP TABLE pending_invites`,
+	},
+	{
+		Version:     17,
+		Description: "create admin_audit_log",
+		Up: `CREATE TABLE admin_audit_log (
+			id bigserial PRIMARY KEY,
+			time timestamptz NOT NULL,
+			actor text NOT NULL,
+			action text NOT NULL,
+			target text NOT NULL,
+			request_id text NOT NULL,
+			details jsonb NOT NULL
+		);
+		CREATE INDEX admin_audit_log_time ON admin_audit_log (time)`,
+		Down: `DROP TABLE admin_audit_log`,
+	},
+}
+
+// LatestVersion is the schema version this build migrates databases to.
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// MigrationStep is a migration to apply, or to revert when Down is true.
+type MigrationStep struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	Down        bool   `json:"down"`
+	SQL         string `json:"sql"`
+}
+
+func (s MigrationStep) String() string {
+	direction := "up"
+	if s.Down {
+		direction = "down"
+	}
+	return fmt.Sprintf("%s %d: %s", direction, s.Version, s.Description)
+}
+
+// MigrationStatus is whether a migration was applied to the database.
+type MigrationStatus struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+}
+
+// Migrate applies the migrations the database hasn't seen yet, each in its
+// own transaction.
+func Migrate(conn *sql.DB) error {
+	return MigrateTo(conn, LatestVersion(), false, ioutil.Discard)
+}
+
+// MigrateTo applies or reverts migrations until the schema is at the target
+// version, logging each step to out. With dryRun it only writes the SQL it
+// would run. The steps are planned and run while holding an advisory lock, so
+// instances starting at the same time don't migrate the schema twice. They
+// run on the connection holding the lock, so a pool of one connection is
+// enough.
+func MigrateTo(conn *sql.DB, target int, dryRun bool, out io.Writer) error {
+	if target < 0 || target > LatestVersion() {
+		return fmt.Errorf("unknown schema version %d, the latest is %d", target, LatestVersion())
+	}
+	if err := createMigrationsTable(conn); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	// Advisory locks belong to a connection, so one is kept for the lock.
+	lockConn, err := conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer lockConn.Close()
+	if _, err := lockConn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("could not lock the schema: %v", err)
+	}
+	defer lockConn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	current, err := currentVersion(lockConn.QueryRowContext(ctx, currentVersionQuery))
+	if err != nil {
+		return err
+	}
+	for _, step := range plan(current, target) {
+		if dryRun {
+			fmt.Fprintf(out, "-- %s\n%s;\n\n", step, step.SQL)
+			continue
+		}
+		fmt.Fprintln(out, step)
+		if err := apply(ctx, lockConn, step); err != nil {
+			return fmt.Errorf("migration %s failed: %v", step, err)
+		}
+	}
+	return nil
+}
+
+// Plan returns the steps that would bring the schema to the target version.
+func Plan(conn *sql.DB, target int) ([]MigrationStep, error) {
+	if target < 0 || target > LatestVersion() {
+		return nil, fmt.Errorf("unknown schema version %d, the latest is %d", target, LatestVersion())
+	}
+	if err := createMigrationsTable(conn); err != nil {
+		return nil, err
+	}
+	current, err := CurrentVersion(conn)
+	if err != nil {
+		return nil, err
+	}
+	return plan(current, target), nil
+}
+
+// plan returns the migrations to apply, oldest first, or to revert, newest
+// first, to go from the current version to the target.
+func plan(current, target int) []MigrationStep {
+	steps := []MigrationStep{}
+	if target >= current {
+		for _, m := range migrations {
+			if m.Version > current && m.Version <= target {
+				steps = append(steps, MigrationStep{Version: m.Version, Description: m.Description, SQL: m.Up})
+			}
+		}
+		return steps
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if m := migrations[i]; m.Version <= current && m.Version > target {
+			steps = append(steps, MigrationStep{Version: m.Version, Description: m.Description, Down: true, SQL: m.Down})
+		}
+	}
+	return steps
+}
+
+// CurrentVersion returns the version of the database's schema, 0 for an
+// empty database.
+func CurrentVersion(conn *sql.DB) (int, error) {
+	return currentVersion(conn.QueryRow(currentVersionQuery))
+}
+
+const currentVersionQuery = `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`
+
+func currentVersion(row *sql.Row) (int, error) {
+	var current int
+	if err := row.Scan(&current); err != nil {
+		return 0, fmt.Errorf("could not read the schema version: %v", err)
+	}
+	return current, nil
+}
+
+// Statuses lists all the migrations this build knows and when they were
+// applied to the database.
+func Statuses(conn *sql.DB) ([]MigrationStatus, error) {
+	if err := createMigrationsTable(conn); err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	statuses := []MigrationStatus{}
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Description: m.Description}
+		if appliedAt, ok := applied[m.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// CheckSchema returns an error unless the database's schema is at the latest
+// version, for deployments whose migrations are run by operators.
+func CheckSchema(conn *sql.DB) error {
+	if err := createMigrationsTable(conn); err != nil {
+		return err
+	}
+	current, err := CurrentVersion(conn)
+	if err != nil {
+		return err
+	}
+	if current != LatestVersion() {
+		return fmt.Errorf("the database schema is at version %d, this dashboard needs version %d: "+
+			"run cg-dashboard migrate up", current, LatestVersion())
+	}
+	return nil
+}
+
+// CheckMigratable checks the schema can be brought up to date by this build,
+// and returns the pending migrations. The pending migrations are run in a
+// single transaction, which is always rolled back, so the checks need the
+// same rights as the migrations without changing the schema.
+func CheckMigratable(conn *sql.DB) ([]MigrationStep, error) {
+	if err := createMigrationsTable(conn); err != nil {
+		return nil, err
+	}
+	current, err := CurrentVersion(conn)
+	if err != nil {
+		return nil, err
+	}
+	if current > LatestVersion() {
+		return nil, fmt.Errorf("the database schema is at version %d, newer than this dashboard's %d",
+			current, LatestVersion())
+	}
+	steps := plan(current, LatestVersion())
+	if len(steps) == 0 {
+		return steps, nil
+	}
+	tx, err := conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for _, step := range steps {
+		if _, err := tx.Exec(step.SQL); err != nil {
+			return nil, fmt.Errorf("migration %s would fail: %v", step, err)
+		}
+	}
+	return steps, nil
+}
+
+func createMigrationsTable(conn *sql.DB) error {
+	if _, err := conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("could not create schema_migrations: %v", err)
+	}
+	return nil
+}
+
+// apply runs the step and records it in schema_migrations, in a single
+// transaction on the connection.
+func apply(ctx context.Context, conn *sql.Conn, step MigrationStep) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(step.SQL); err != nil {
+		tx.Rollback()
+		return err
+	}
+	record := `INSERT INTO schema_migrations (version) VALUES ($1)`
+	if step.Down {
+		record = `DELETE FROM schema_migrations WHERE version = $1`
+	}
+	if _, err := tx.Exec(record, step.Version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,261 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The statuses of a pending change.
+const (
+	PendingChangePending  = "pending"
+	PendingChangeApproved = "approved"
+	PendingChangeRejected = "rejected"
+)
+
+// PendingChangeExpiry is how long a pending change can be approved for. Older
+// changes have to be made again, since the platform may have changed since.
+const PendingChangeExpiry = 24 * time.Hour
+
+var (
+	// ErrPendingChangeNotFound is returned for an unknown pending change.
+	ErrPendingChangeNotFound = errors.New("pending change not found")
+	// ErrPendingChangeDecided is returned when deciding a change that was
+	// already approved or rejected.
+	ErrPendingChangeDecided = errors.New("change was already decided")
+)
+
+// PendingChange is a CF API request from a platform admin that needs a
+// second admin's approval before it's made.
+type PendingChange struct {
+	ID string `json:"id"`
+	// Class is the operation class that needs approval, e.g. org_deletion.
+	Class string `json:"class"`
+	// Method, Path (with the query) and Body are the parked CF API request.
+	Method      string          `json:"method"`
+	Path        string          `json:"path"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	RequestedBy string          `json:"requested_by"`
+	Status      string          `json:"status"`
+	CreatedAt   time.Time       `json:"created_at"`
+	DecidedBy   string          `json:"decided_by,omitempty"`
+	DecidedAt   *time.Time      `json:"decided_at,omitempty"`
+}
+
+// Expired returns true if the change is too old to be approved.
+func (c PendingChange) Expired(now time.Time) bool {
+	return now.Sub(c.CreatedAt) > PendingChangeExpiry
+}
+
+// PendingChangeStore keeps the changes waiting for approval.
+type PendingChangeStore interface {
+	// CreatePendingChange keeps a new pending change and returns it with its
+	// ID.
+	CreatePendingChange(c PendingChange) (PendingChange, error)
+	// PendingChange returns the change, or ErrPendingChangeNotFound.
+	PendingChange(id string) (PendingChange, error)
+	// PendingChanges returns the changes with the status, or all of them
+	// when it's empty, newest first.
+	PendingChanges(status string) ([]PendingChange, error)
+	// DecidePendingChange approves or rejects a pending change, or returns
+	// ErrPendingChangeDecided if it's not pending anymore.
+	DecidePendingChange(id, status, decidedBy string) (PendingChange, error)
+	// PurgePendingChanges drops the changes decided, or left to expire,
+	// before the time and returns how many it dropped.
+	PurgePendingChanges(before time.Time) (int64, error)
+}
+
+// SQLPendingChangeStore keeps the pending changes in the database. Their
+// bodies can hold credentials, e.g. of user-provided services, so they're
+// encrypted with Cipher when it's set.
+type SQLPendingChangeStore struct {
+	DB     *sql.DB
+	Cipher *ColumnCipher
+}
+
+const pendingChangeColumns = `id, class, method, path, body, requested_by, status, created_at, decided_by, decided_at`
+
+func scanPendingChange(row interface {
+	Scan(dest ...interface{}) error
+}, cipher *ColumnCipher) (PendingChange, error) {
+	var (
+		c         PendingChange
+		body      []byte
+		decidedAt *time.Time
+	)
+	if err := row.Scan(&c.ID, &c.Class, &c.Method, &c.Path, &body, &c.RequestedBy, &c.Status,
+		&c.CreatedAt, &c.DecidedBy, &decidedAt); err != nil {
+		return c, err
+	}
+	body, err := cipher.Decrypt(body)
+	if err != nil {
+		return PendingChange{}, fmt.Errorf("could not read the body of pending change %s: %v", c.ID, err)
+	}
+	if len(body) > 0 {
+		c.Body = body
+	}
+	c.DecidedAt = decidedAt
+	return c, nil
+}
+
+// CreatePendingChange keeps a new pending change.
+func (s *SQLPendingChangeStore) CreatePendingChange(c PendingChange) (PendingChange, error) {
+	id, err := newID()
+	if err != nil {
+		return PendingChange{}, err
+	}
+	c.ID, c.Status, c.CreatedAt = id, PendingChangePending, time.Now().UTC()
+	c.DecidedBy, c.DecidedAt = "", nil
+	body, err := s.Cipher.Encrypt(c.Body)
+	if err != nil {
+		return PendingChange{}, err
+	}
+	_, err = s.DB.Exec(`INSERT INTO pending_changes (`+pendingChangeColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, '', NULL)`,
+		c.ID, c.Class, c.Method, c.Path, body, c.RequestedBy, c.Status, c.CreatedAt)
+	return c, err
+}
+
+// PendingChange returns the change.
+func (s *SQLPendingChangeStore) PendingChange(id string) (PendingChange, error) {
+	c, err := scanPendingChange(s.DB.QueryRow(`SELECT `+pendingChangeColumns+` FROM pending_changes WHERE id = $1`, id), s.Cipher)
+	if err == sql.ErrNoRows {
+		return PendingChange{}, ErrPendingChangeNotFound
+	}
+	return c, err
+}
+
+// PendingChanges returns the changes with the status, newest first.
+func (s *SQLPendingChangeStore) PendingChanges(status string) ([]PendingChange, error) {
+	rows, err := s.DB.Query(`SELECT `+pendingChangeColumns+` FROM pending_changes
+		WHERE $1 = '' OR status = $1
+		ORDER BY created_at DESC`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := []PendingChange{}
+	for rows.Next() {
+		c, err := scanPendingChange(rows, s.Cipher)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// DecidePendingChange approves or rejects a pending change.
+func (s *SQLPendingChangeStore) DecidePendingChange(id, status, decidedBy string) (PendingChange, error) {
+	c, err := scanPendingChange(s.DB.QueryRow(`UPDATE pending_changes
+		SET status = $2, decided_by = $3, decided_at = $4
+		WHERE id = $1 AND status = 'pending'
+		RETURNING `+pendingChangeColumns, id, status, decidedBy, time.Now().UTC()), s.Cipher)
+	if err != sql.ErrNoRows {
+		return c, err
+	}
+	// Either there's no such change or it was decided already.
+	if _, err := s.PendingChange(id); err != nil {
+		return PendingChange{}, err
+	}
+	return PendingChange{}, ErrPendingChangeDecided
+}
+
+// PurgePendingChanges drops the changes decided, or left to expire, before
+// the time.
+func (s *SQLPendingChangeStore) PurgePendingChanges(before time.Time) (int64, error) {
+	result, err := s.DB.Exec(`DELETE FROM pending_changes
+		WHERE (status <> $1 AND decided_at < $2) OR (status = $1 AND created_at < $3)`,
+		PendingChangePending, before, before.Add(-PendingChangeExpiry))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MemoryPendingChangeStore keeps the pending changes in memory. It's used when
+// no database is configured, so they're lost when the app restarts.
+type MemoryPendingChangeStore struct {
+	mu      sync.Mutex
+	changes map[string]PendingChange
+}
+
+// CreatePendingChange keeps a new pending change.
+func (s *MemoryPendingChangeStore) CreatePendingChange(c PendingChange) (PendingChange, error) {
+	id, err := newID()
+	if err != nil {
+		return PendingChange{}, err
+	}
+	c.ID, c.Status, c.CreatedAt = id, PendingChangePending, time.Now().UTC()
+	c.DecidedBy, c.DecidedAt = "", nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.changes == nil {
+		s.changes = make(map[string]PendingChange)
+	}
+	s.changes[c.ID] = c
+	return c, nil
+}
+
+// PendingChange returns the change.
+func (s *MemoryPendingChangeStore) PendingChange(id string) (PendingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.changes[id]
+	if !ok {
+		return PendingChange{}, ErrPendingChangeNotFound
+	}
+	return c, nil
+}
+
+// PendingChanges returns the changes with the status, newest first.
+func (s *MemoryPendingChangeStore) PendingChanges(status string) ([]PendingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changes := []PendingChange{}
+	for _, c := range s.changes {
+		if status == "" || c.Status == status {
+			changes = append(changes, c)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].CreatedAt.After(changes[j].CreatedAt) })
+	return changes, nil
+}
+
+// DecidePendingChange approves or rejects a pending change.
+func (s *MemoryPendingChangeStore) DecidePendingChange(id, status, decidedBy string) (PendingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.changes[id]
+	if !ok {
+		return PendingChange{}, ErrPendingChangeNotFound
+	}
+	if c.Status != PendingChangePending {
+		return PendingChange{}, ErrPendingChangeDecided
+	}
+	now := time.Now().UTC()
+	c.Status, c.DecidedBy, c.DecidedAt = status, decidedBy, &now
+	s.changes[id] = c
+	return c, nil
+}
+
+// PurgePendingChanges drops the changes decided, or left to expire, before
+// the time.
+func (s *MemoryPendingChangeStore) PurgePendingChanges(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purged int64
+	for id, c := range s.changes {
+		decided := c.Status != PendingChangePending && c.DecidedAt != nil && c.DecidedAt.Before(before)
+		expired := c.Status == PendingChangePending && c.Expired(before)
+		if decided || expired {
+			delete(s.changes, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,56 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestMemoryPendingChangeStore(t *testing.T) {
+	store := &db.MemoryPendingChangeStore{}
+	change, err := store.CreatePendingChange(db.PendingChange{
+		Class:       "org_deletion",
+		Method:      "DELETE",
+		Path:        "/v2/organizations/org-1?recursive=true",
+		RequestedBy: "admin-guid",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.ID == "" || change.Status != db.PendingChangePending || change.Expired(time.Now()) {
+		t.Errorf("Expected a new pending change. Found %+v", change)
+	}
+	if !change.Expired(time.Now().Add(db.PendingChangeExpiry + time.Minute)) {
+		t.Error("Expected the change to expire")
+	}
+	other, _ := store.CreatePendingChange(db.PendingChange{Class: "quota_increase", Method: "PUT", RequestedBy: "admin-guid"})
+
+	decided, err := store.DecidePendingChange(change.ID, db.PendingChangeApproved, "other-admin-guid")
+	if err != nil || decided.Status != db.PendingChangeApproved || decided.DecidedBy != "other-admin-guid" || decided.DecidedAt == nil {
+		t.Errorf("Expected the change to be approved. Found %+v, %v", decided, err)
+	}
+	if _, err := store.DecidePendingChange(change.ID, db.PendingChangeRejected, "other-admin-guid"); err != db.ErrPendingChangeDecided {
+		t.Errorf("Expected %v. Found %v", db.ErrPendingChangeDecided, err)
+	}
+	if _, err := store.PendingChange("unknown"); err != db.ErrPendingChangeNotFound {
+		t.Errorf("Expected %v. Found %v", db.ErrPendingChangeNotFound, err)
+	}
+
+	changes, _ := store.PendingChanges(db.PendingChangePending)
+	if len(changes) != 1 || changes[0].ID != other.ID {
+		t.Errorf("Expected only the pending change. Found %+v", changes)
+	}
+	changes, _ = store.PendingChanges("")
+	if len(changes) != 2 {
+		t.Errorf("Expected all the changes. Found %+v", changes)
+	}
+
+	if purged, _ := store.PurgePendingChanges(time.Now().Add(time.Minute)); purged != 1 {
+		t.Errorf("Expected the approved change to be purged. Found %d purged", purged)
+	}
+	// Pending changes are purged once they've expired.
+	if purged, _ := store.PurgePendingChanges(time.Now().Add(db.PendingChangeExpiry + time.Minute)); purged != 1 {
+		t.Errorf("Expected the expired change to be purged. Found %d purged", purged)
+	}
+}
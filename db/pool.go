@@ -0,0 +1,83 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+const (
+	// DefaultMaxOpenConns is the most connections to the database the
+	// dashboard opens, unless configured otherwise. Database services on
+	// small plans only allow a few dozen connections for all their clients.
+	DefaultMaxOpenConns = 10
+	// DefaultMaxIdleConns is the most idle connections kept open.
+	DefaultMaxIdleConns = 5
+	// DefaultConnMaxLifetime is how long a connection is used before it's
+	// replaced, so connections to a failed over database don't linger.
+	DefaultConnMaxLifetime = 30 * time.Minute
+	// DefaultConnectTimeout is how long the dashboard keeps trying to reach
+	// the database on startup, say while the database service is being
+	// restarted for maintenance.
+	DefaultConnectTimeout = time.Minute
+
+	// The delay between connection attempts starts at minBackoff and doubles
+	// up to maxBackoff.
+	minBackoff = time.Second
+	maxBackoff = 15 * time.Second
+)
+
+// Logf logs the retried connections. The helpers package points it at its
+// db logger.
+var Logf = log.Printf
+
+// PoolOptions tunes the pool of connections to the database.
+type PoolOptions struct {
+	// MaxOpenConns is the most open connections. 0 means no limit.
+	MaxOpenConns int
+	// MaxIdleConns is the most idle connections. 0 keeps none.
+	MaxIdleConns int
+	// ConnMaxLifetime is how long a connection is reused. 0 means forever.
+	ConnMaxLifetime time.Duration
+	// ConnectTimeout is how long to keep trying to connect on startup.
+	ConnectTimeout time.Duration
+}
+
+// DefaultPoolOptions returns the pool options for a database service on a
+// small plan.
+func DefaultPoolOptions() PoolOptions {
+	return PoolOptions{
+		MaxOpenConns:    DefaultMaxOpenConns,
+		MaxIdleConns:    DefaultMaxIdleConns,
+		ConnMaxLifetime: DefaultConnMaxLifetime,
+		ConnectTimeout:  DefaultConnectTimeout,
+	}
+}
+
+// apply sets the options on the pool. Once connected, broken connections are
+// replaced by the pool on their next use, so the dashboard reconnects by
+// itself after the database is briefly unavailable.
+func (o PoolOptions) apply(conn *sql.DB) {
+	conn.SetMaxOpenConns(o.MaxOpenConns)
+	conn.SetMaxIdleConns(o.MaxIdleConns)
+	conn.SetConnMaxLifetime(o.ConnMaxLifetime)
+}
+
+// retryWithBackoff calls attempt until it succeeds or the timeout has passed,
+// waiting longer after each failure. It returns the last error.
+func retryWithBackoff(attempt func() error, timeout time.Duration, sleep func(time.Duration)) error {
+	var waited time.Duration
+	backoff := minBackoff
+	for {
+		err := attempt()
+		if err == nil || waited+backoff > timeout {
+			return err
+		}
+		Logf("could not connect to the database, retrying in %s: %v", backoff, err)
+		sleep(backoff)
+		waited += backoff
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryWithBackoff(t *testing.T) {
+	unavailable := errors.New("the database system is starting up")
+	testCases := []struct {
+		name          string
+		failures      int
+		timeout       time.Duration
+		expectedErr   error
+		expectedSlept []time.Duration
+	}{
+		{"available", 0, time.Minute, nil, nil},
+		{"no retries", 1, 0, unavailable, nil},
+		{"briefly unavailable", 3, time.Minute, nil, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}},
+		{"backoff is capped", 6, time.Minute, nil, []time.Duration{
+			time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 15 * time.Second, 15 * time.Second,
+		}},
+		{"gives up", 100, 10 * time.Second, unavailable, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}},
+	}
+	for _, test := range testCases {
+		attempts := 0
+		var slept []time.Duration
+		err := retryWithBackoff(func() error {
+			attempts++
+			if attempts <= test.failures {
+				return unavailable
+			}
+			return nil
+		}, test.timeout, func(d time.Duration) { slept = append(slept, d) })
+		if err != test.expectedErr {
+			t.Errorf("%s: expected error %v. Found %v", test.name, test.expectedErr, err)
+		}
+		if len(slept) != len(test.expectedSlept) {
+			t.Errorf("%s: expected to wait %v. Waited %v", test.name, test.expectedSlept, slept)
+			continue
+		}
+		for i := range slept {
+			if slept[i] != test.expectedSlept[i] {
+				t.Errorf("%s: expected to wait %v. Waited %v", test.name, test.expectedSlept, slept)
+				break
+			}
+		}
+	}
+}
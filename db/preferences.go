@@ -0,0 +1,131 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// localePattern matches BCP 47 language tags like en, en-US or zh-Hant-TW.
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// Preferences are a user's own settings for the dashboard. Empty fields use
+// the defaults.
+type Preferences struct {
+	// Locale is the BCP 47 language tag dates and numbers are formatted for,
+	// e.g. en-US.
+	Locale string `json:"locale,omitempty"`
+	// Timezone is the IANA time zone times are shown in, e.g. America/New_York.
+	Timezone string `json:"timezone,omitempty"`
+	// Version counts the saves of the preferences, so concurrent edits can't
+	// overwrite each other. It's 0 until they're first saved.
+	Version int `json:"version,omitempty"`
+}
+
+// Validate checks the preferences are a known locale and time zone.
+func (p Preferences) Validate() error {
+	if p.Locale != "" && !localePattern.MatchString(p.Locale) {
+		return fmt.Errorf("%q is not a locale like en-US", p.Locale)
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil || p.Timezone == "Local" {
+			return fmt.Errorf("%q is not a time zone like America/New_York", p.Timezone)
+		}
+	}
+	return nil
+}
+
+// PreferenceStore keeps the users' preferences.
+type PreferenceStore interface {
+	// Preferences returns the user's preferences, which are empty until
+	// they're first saved.
+	Preferences(userID string) (Preferences, error)
+	// SavePreferences replaces the user's preferences if they're still at
+	// preferences.Version, or returns ErrVersionConflict, and returns them
+	// with their new version.
+	SavePreferences(userID string, preferences Preferences) (Preferences, error)
+}
+
+// SQLPreferenceStore keeps the users' preferences in the database.
+type SQLPreferenceStore struct {
+	DB *sql.DB
+}
+
+// Preferences returns the user's preferences.
+func (s *SQLPreferenceStore) Preferences(userID string) (Preferences, error) {
+	var p Preferences
+	err := s.DB.QueryRow(`SELECT locale, timezone, version FROM user_preferences WHERE user_id = $1`, userID).
+		Scan(&p.Locale, &p.Timezone, &p.Version)
+	if err == sql.ErrNoRows {
+		return Preferences{}, nil
+	}
+	return p, err
+}
+
+// SavePreferences replaces the user's preferences if they're still at
+// p.Version.
+func (s *SQLPreferenceStore) SavePreferences(userID string, p Preferences) (Preferences, error) {
+	var query string
+	switch p.Version {
+	case AnyVersion:
+		query = `INSERT INTO user_preferences (user_id, locale, timezone, updated_at, version)
+		VALUES ($1, $2, $3, now(), 1)
+		ON CONFLICT (user_id) DO UPDATE SET locale = $2, timezone = $3, updated_at = now(),
+			version = user_preferences.version + 1
+		RETURNING version`
+	case 0:
+		query = `INSERT INTO user_preferences (user_id, locale, timezone, updated_at, version)
+		VALUES ($1, $2, $3, now(), 1)
+		ON CONFLICT (user_id) DO NOTHING
+		RETURNING version`
+	default:
+		query = `UPDATE user_preferences SET locale = $2, timezone = $3, updated_at = now(), version = version + 1
+		WHERE user_id = $1 AND version = $4
+		RETURNING version`
+	}
+	args := []interface{}{userID, p.Locale, p.Timezone}
+	if p.Version > 0 {
+		args = append(args, p.Version)
+	}
+	err := s.DB.QueryRow(query, args...).Scan(&p.Version)
+	if err == sql.ErrNoRows {
+		return Preferences{}, ErrVersionConflict
+	}
+	if err != nil {
+		return Preferences{}, err
+	}
+	return p, nil
+}
+
+// MemoryPreferenceStore keeps the users' preferences in memory. It's used
+// when no database is configured, so they're lost when the app restarts.
+type MemoryPreferenceStore struct {
+	mu          sync.Mutex
+	preferences map[string]Preferences
+}
+
+// Preferences returns the user's preferences.
+func (s *MemoryPreferenceStore) Preferences(userID string) (Preferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.preferences[userID], nil
+}
+
+// SavePreferences replaces the user's preferences if they're still at
+// p.Version.
+func (s *MemoryPreferenceStore) SavePreferences(userID string, p Preferences) (Preferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current := s.preferences[userID]
+	if p.Version != AnyVersion && p.Version != current.Version {
+		return Preferences{}, ErrVersionConflict
+	}
+	if s.preferences == nil {
+		s.preferences = make(map[string]Preferences)
+	}
+	p.Version = current.Version + 1
+	s.preferences[userID] = p
+	return p, nil
+}
@@ -0,0 +1,82 @@
+package db_test
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestPreferencesValidate(t *testing.T) {
+	valid := []db.Preferences{
+		{},
+		{Locale: "en-US", Timezone: "America/New_York"},
+		{Locale: "zh-Hant-TW", Timezone: "UTC"},
+	}
+	for _, p := range valid {
+		if err := p.Validate(); err != nil {
+			t.Errorf("%+v: unexpected error %v", p, err)
+		}
+	}
+	invalid := []db.Preferences{
+		{Locale: "en_US"},
+		{Locale: "<script>"},
+		{Timezone: "Mars/Olympus_Mons"},
+		{Timezone: "Local"},
+	}
+	for _, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("%+v: expected an error", p)
+		}
+	}
+}
+
+func TestSQLPreferenceStore(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	store := &db.SQLPreferenceStore{DB: conn}
+
+	// Nothing saved yet.
+	mock.ExpectQuery("SELECT locale, timezone, version FROM user_preferences").WithArgs("user-guid").
+		WillReturnRows(sqlmock.NewRows([]string{"locale", "timezone", "version"}))
+	preferences, err := store.Preferences("user-guid")
+	if err != nil || preferences != (db.Preferences{}) {
+		t.Errorf("Expected no preferences. Found %+v, %v", preferences, err)
+	}
+
+	mock.ExpectQuery("INSERT INTO user_preferences .* DO NOTHING").WithArgs("user-guid", "en-GB", "Europe/London").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+	preferences, err = store.SavePreferences("user-guid", db.Preferences{Locale: "en-GB", Timezone: "Europe/London"})
+	if err != nil || preferences.Version != 1 {
+		t.Fatalf("Expected version 1. Found %+v, %v", preferences, err)
+	}
+
+	mock.ExpectQuery("SELECT locale, timezone, version FROM user_preferences").WithArgs("user-guid").
+		WillReturnRows(sqlmock.NewRows([]string{"locale", "timezone", "version"}).AddRow("en-GB", "Europe/London", 1))
+	preferences, err = store.Preferences("user-guid")
+	if err != nil || preferences != (db.Preferences{Locale: "en-GB", Timezone: "Europe/London", Version: 1}) {
+		t.Errorf("Unexpected preferences %+v, %v", preferences, err)
+	}
+
+	mock.ExpectQuery("UPDATE user_preferences SET .* AND version = \\$4").WithArgs("user-guid", "de-DE", "", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+	preferences, err = store.SavePreferences("user-guid", db.Preferences{Locale: "de-DE", Version: 1})
+	if err != nil || preferences.Version != 2 {
+		t.Errorf("Expected version 2. Found %+v, %v", preferences, err)
+	}
+
+	// Someone else saved version 2 first.
+	mock.ExpectQuery("UPDATE user_preferences SET .* AND version = \\$4").WithArgs("user-guid", "fr-FR", "", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	if _, err := store.SavePreferences("user-guid", db.Preferences{Locale: "fr-FR", Version: 1}); err != db.ErrVersionConflict {
+		t.Errorf("Expected %v. Found %v", db.ErrVersionConflict, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,293 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The statuses of a temporary role grant.
+const (
+	RoleGrantActive  = "active"
+	RoleGrantRevoked = "revoked"
+	RoleGrantExpired = "expired"
+)
+
+const (
+	// MinRoleGrantDuration is the shortest a role can be granted for.
+	MinRoleGrantDuration = 15 * time.Minute
+	// MaxRoleGrantDuration is the longest a role can be granted for. Longer
+	// needs are better met with a lasting role.
+	MaxRoleGrantDuration = 7 * 24 * time.Hour
+)
+
+// grantableOrgRoles are the org roles that can be granted temporarily. Org
+// membership itself isn't, since it comes with any other role.
+var grantableOrgRoles = map[string]bool{"managers": true, "billing_managers": true, "auditors": true}
+
+var (
+	// ErrRoleGrantNotFound is returned for an unknown role grant.
+	ErrRoleGrantNotFound = errors.New("role grant not found")
+	// ErrRoleGrantEnded is returned when ending a role grant that was
+	// already revoked or expired.
+	ErrRoleGrantEnded = errors.New("role grant has already ended")
+)
+
+// RoleGrant is a role in an org, or in a space when SpaceGUID is set, that an
+// org manager gave a user until ExpiresAt, e.g. to respond to an incident.
+type RoleGrant struct {
+	ID        string `json:"id"`
+	UserID    string `json:"user_id"`
+	OrgGUID   string `json:"org_guid"`
+	SpaceGUID string `json:"space_guid,omitempty"`
+	Role      string `json:"role"`
+	Reason    string `json:"reason,omitempty"`
+	// AddedToOrg is true when the user wasn't in the org before the grant,
+	// so they're removed from it again when it ends.
+	AddedToOrg bool       `json:"added_to_org"`
+	GrantedBy  string     `json:"granted_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Status     string     `json:"status"`
+	EndedBy    string     `json:"ended_by,omitempty"`
+	EndedAt    *time.Time `json:"ended_at,omitempty"`
+}
+
+// Validate checks the grant is for a role that can be granted temporarily,
+// for a supported duration from now.
+func (g RoleGrant) Validate(now time.Time) error {
+	if g.OrgGUID == "" || g.UserID == "" {
+		return errors.New("org_guid and user_id are required")
+	}
+	if g.SpaceGUID == "" && !grantableOrgRoles[g.Role] {
+		return fmt.Errorf("%q is not an org role that can be granted, expected one of managers, billing_managers or auditors", g.Role)
+	}
+	if g.SpaceGUID != "" && !spaceRoles[g.Role] {
+		return fmt.Errorf("%q is not a space role, expected one of developers, managers or auditors", g.Role)
+	}
+	if len(g.Reason) > MaxRoleRequestReasonLength {
+		return fmt.Errorf("the reason is longer than %d characters", MaxRoleRequestReasonLength)
+	}
+	if d := g.ExpiresAt.Sub(now); d < MinRoleGrantDuration || d > MaxRoleGrantDuration {
+		return fmt.Errorf("roles can be granted for %s to %s", MinRoleGrantDuration, MaxRoleGrantDuration)
+	}
+	return nil
+}
+
+// RoleGrantFilter selects role grants. Empty fields match all.
+type RoleGrantFilter struct {
+	UserID  string
+	OrgGUID string
+	Status  string
+}
+
+func (f RoleGrantFilter) matches(g RoleGrant) bool {
+	return (f.UserID == "" || f.UserID == g.UserID) &&
+		(f.OrgGUID == "" || f.OrgGUID == g.OrgGUID) &&
+		(f.Status == "" || f.Status == g.Status)
+}
+
+// RoleGrantStore keeps the temporary role grants.
+type RoleGrantStore interface {
+	// CreateRoleGrant keeps a new active grant and returns it with its ID.
+	CreateRoleGrant(g RoleGrant) (RoleGrant, error)
+	// RoleGrant returns the grant, or ErrRoleGrantNotFound.
+	RoleGrant(id string) (RoleGrant, error)
+	// RoleGrants returns the grants matching the filter, newest first.
+	RoleGrants(filter RoleGrantFilter) ([]RoleGrant, error)
+	// DueRoleGrants returns the active grants that expired by the time.
+	DueRoleGrants(now time.Time) ([]RoleGrant, error)
+	// EndRoleGrant marks an active grant revoked or expired, or returns
+	// ErrRoleGrantEnded if it's not active anymore.
+	EndRoleGrant(id, status, endedBy string) (RoleGrant, error)
+}
+
+// SQLRoleGrantStore keeps the role grants in the database.
+type SQLRoleGrantStore struct {
+	DB *sql.DB
+}
+
+const roleGrantColumns = `id, user_id, org_guid, space_guid, role, reason, added_to_org, granted_by,
+	created_at, expires_at, status, ended_by, ended_at`
+
+func scanRoleGrant(row interface {
+	Scan(dest ...interface{}) error
+}) (RoleGrant, error) {
+	var (
+		g       RoleGrant
+		endedAt *time.Time
+	)
+	err := row.Scan(&g.ID, &g.UserID, &g.OrgGUID, &g.SpaceGUID, &g.Role, &g.Reason, &g.AddedToOrg, &g.GrantedBy,
+		&g.CreatedAt, &g.ExpiresAt, &g.Status, &g.EndedBy, &endedAt)
+	g.EndedAt = endedAt
+	return g, err
+}
+
+func scanRoleGrants(rows *sql.Rows, err error) ([]RoleGrant, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	grants := []RoleGrant{}
+	for rows.Next() {
+		g, err := scanRoleGrant(rows)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// CreateRoleGrant keeps a new active grant.
+func (s *SQLRoleGrantStore) CreateRoleGrant(g RoleGrant) (RoleGrant, error) {
+	id, err := newID()
+	if err != nil {
+		return RoleGrant{}, err
+	}
+	g.ID, g.Status, g.CreatedAt = id, RoleGrantActive, time.Now().UTC()
+	g.EndedBy, g.EndedAt = "", nil
+	_, err = s.DB.Exec(`INSERT INTO role_grants (`+roleGrantColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, '', NULL)`,
+		g.ID, g.UserID, g.OrgGUID, g.SpaceGUID, g.Role, g.Reason, g.AddedToOrg, g.GrantedBy,
+		g.CreatedAt, g.ExpiresAt, g.Status)
+	return g, err
+}
+
+// RoleGrant returns the grant.
+func (s *SQLRoleGrantStore) RoleGrant(id string) (RoleGrant, error) {
+	g, err := scanRoleGrant(s.DB.QueryRow(`SELECT `+roleGrantColumns+` FROM role_grants WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return RoleGrant{}, ErrRoleGrantNotFound
+	}
+	return g, err
+}
+
+// RoleGrants returns the grants matching the filter, newest first.
+func (s *SQLRoleGrantStore) RoleGrants(filter RoleGrantFilter) ([]RoleGrant, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	for _, f := range []struct{ column, value string }{
+		{"user_id", filter.UserID},
+		{"org_guid", filter.OrgGUID},
+		{"status", filter.Status},
+	} {
+		if f.value != "" {
+			args = append(args, f.value)
+			where = append(where, fmt.Sprintf("%s = $%d", f.column, len(args)))
+		}
+	}
+	query := `SELECT ` + roleGrantColumns + ` FROM role_grants`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	return scanRoleGrants(s.DB.Query(query+` ORDER BY created_at DESC`, args...))
+}
+
+// DueRoleGrants returns the active grants that expired by the time.
+func (s *SQLRoleGrantStore) DueRoleGrants(now time.Time) ([]RoleGrant, error) {
+	return scanRoleGrants(s.DB.Query(`SELECT `+roleGrantColumns+` FROM role_grants
+		WHERE status = $1 AND expires_at <= $2 ORDER BY expires_at`, RoleGrantActive, now))
+}
+
+// EndRoleGrant marks an active grant revoked or expired.
+func (s *SQLRoleGrantStore) EndRoleGrant(id, status, endedBy string) (RoleGrant, error) {
+	g, err := scanRoleGrant(s.DB.QueryRow(`UPDATE role_grants
+		SET status = $2, ended_by = $3, ended_at = $4
+		WHERE id = $1 AND status = 'active'
+		RETURNING `+roleGrantColumns, id, status, endedBy, time.Now().UTC()))
+	if err != sql.ErrNoRows {
+		return g, err
+	}
+	// Either there's no such grant or it ended already.
+	if _, err := s.RoleGrant(id); err != nil {
+		return RoleGrant{}, err
+	}
+	return RoleGrant{}, ErrRoleGrantEnded
+}
+
+// MemoryRoleGrantStore keeps the role grants in memory. It's used when no
+// database is configured, so they're lost when the app restarts.
+type MemoryRoleGrantStore struct {
+	mu     sync.Mutex
+	grants map[string]RoleGrant
+}
+
+// CreateRoleGrant keeps a new active grant.
+func (s *MemoryRoleGrantStore) CreateRoleGrant(g RoleGrant) (RoleGrant, error) {
+	id, err := newID()
+	if err != nil {
+		return RoleGrant{}, err
+	}
+	g.ID, g.Status, g.CreatedAt = id, RoleGrantActive, time.Now().UTC()
+	g.EndedBy, g.EndedAt = "", nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.grants == nil {
+		s.grants = make(map[string]RoleGrant)
+	}
+	s.grants[g.ID] = g
+	return g, nil
+}
+
+// RoleGrant returns the grant.
+func (s *MemoryRoleGrantStore) RoleGrant(id string) (RoleGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.grants[id]
+	if !ok {
+		return RoleGrant{}, ErrRoleGrantNotFound
+	}
+	return g, nil
+}
+
+// RoleGrants returns the grants matching the filter, newest first.
+func (s *MemoryRoleGrantStore) RoleGrants(filter RoleGrantFilter) ([]RoleGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	grants := []RoleGrant{}
+	for _, g := range s.grants {
+		if filter.matches(g) {
+			grants = append(grants, g)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool { return grants[i].CreatedAt.After(grants[j].CreatedAt) })
+	return grants, nil
+}
+
+// DueRoleGrants returns the active grants that expired by the time.
+func (s *MemoryRoleGrantStore) DueRoleGrants(now time.Time) ([]RoleGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	grants := []RoleGrant{}
+	for _, g := range s.grants {
+		if g.Status == RoleGrantActive && !g.ExpiresAt.After(now) {
+			grants = append(grants, g)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool { return grants[i].ExpiresAt.Before(grants[j].ExpiresAt) })
+	return grants, nil
+}
+
+// EndRoleGrant marks an active grant revoked or expired.
+func (s *MemoryRoleGrantStore) EndRoleGrant(id, status, endedBy string) (RoleGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.grants[id]
+	if !ok {
+		return RoleGrant{}, ErrRoleGrantNotFound
+	}
+	if g.Status != RoleGrantActive {
+		return RoleGrant{}, ErrRoleGrantEnded
+	}
+	now := time.Now().UTC()
+	g.Status, g.EndedBy, g.EndedAt = status, endedBy, &now
+	s.grants[id] = g
+	return g, nil
+}
@@ -0,0 +1,26 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestRoleGrantValidate(t *testing.T) {
+	now := time.Now()
+	valid := db.RoleGrant{UserID: "u", OrgGUID: "o", SpaceGUID: "s", Role: "developers", ExpiresAt: now.Add(8 * time.Hour)}
+	if err := valid.Validate(now); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	for name, g := range map[string]db.RoleGrant{
+		"org membership": {UserID: "u", OrgGUID: "o", Role: "users", ExpiresAt: now.Add(time.Hour)},
+		"too short":      {UserID: "u", OrgGUID: "o", Role: "auditors", ExpiresAt: now.Add(time.Minute)},
+		"too long":       {UserID: "u", OrgGUID: "o", Role: "auditors", ExpiresAt: now.Add(30 * 24 * time.Hour)},
+		"no user":        {OrgGUID: "o", Role: "auditors", ExpiresAt: now.Add(time.Hour)},
+	} {
+		if err := g.Validate(now); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
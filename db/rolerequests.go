@@ -0,0 +1,295 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The statuses of a role request.
+const (
+	RoleRequestPending  = "pending"
+	RoleRequestApproved = "approved"
+	RoleRequestDenied   = "denied"
+)
+
+// MaxRoleRequestReasonLength is the longest reason a role request can give.
+const MaxRoleRequestReasonLength = 500
+
+var (
+	// orgRoles are the org roles that can be requested, named like the CF
+	// API's org role endpoints.
+	orgRoles = map[string]bool{"users": true, "managers": true, "billing_managers": true, "auditors": true}
+	// spaceRoles are the space roles that can be requested.
+	spaceRoles = map[string]bool{"developers": true, "managers": true, "auditors": true}
+)
+
+var (
+	// ErrRoleRequestNotFound is returned for an unknown role request.
+	ErrRoleRequestNotFound = errors.New("role request not found")
+	// ErrRoleRequestDecided is returned when deciding a role request that
+	// was already approved or denied.
+	ErrRoleRequestDecided = errors.New("role request was already decided")
+)
+
+// RoleRequest is a user's request for a role in an org, or in a space when
+// SpaceGUID is set, which the org's managers approve or deny.
+type RoleRequest struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	OrgGUID   string     `json:"org_guid"`
+	SpaceGUID string     `json:"space_guid,omitempty"`
+	Role      string     `json:"role"`
+	Reason    string     `json:"reason,omitempty"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	DecidedBy string     `json:"decided_by,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+}
+
+// Validate checks the request is for a known role.
+func (r RoleRequest) Validate() error {
+	if r.OrgGUID == "" {
+		return errors.New("org_guid is required")
+	}
+	if r.SpaceGUID == "" && !orgRoles[r.Role] {
+		return fmt.Errorf("%q is not an org role, expected one of users, managers, billing_managers or auditors", r.Role)
+	}
+	if r.SpaceGUID != "" && !spaceRoles[r.Role] {
+		return fmt.Errorf("%q is not a space role, expected one of developers, managers or auditors", r.Role)
+	}
+	if len(r.Reason) > MaxRoleRequestReasonLength {
+		return fmt.Errorf("the reason is longer than %d characters", MaxRoleRequestReasonLength)
+	}
+	return nil
+}
+
+// RoleRequestFilter selects role requests. Empty fields match all.
+type RoleRequestFilter struct {
+	UserID  string
+	OrgGUID string
+	Status  string
+}
+
+func (f RoleRequestFilter) matches(r RoleRequest) bool {
+	return (f.UserID == "" || f.UserID == r.UserID) &&
+		(f.OrgGUID == "" || f.OrgGUID == r.OrgGUID) &&
+		(f.Status == "" || f.Status == r.Status)
+}
+
+// RoleRequestStore keeps the role requests.
+type RoleRequestStore interface {
+	// CreateRoleRequest keeps a new pending request and returns it with its
+	// ID.
+	CreateRoleRequest(r RoleRequest) (RoleRequest, error)
+	// RoleRequest returns the request, or ErrRoleRequestNotFound.
+	RoleRequest(id string) (RoleRequest, error)
+	// RoleRequests returns the requests matching the filter, newest first.
+	RoleRequests(filter RoleRequestFilter) ([]RoleRequest, error)
+	// DecideRoleRequest approves or denies a pending request, or returns
+	// ErrRoleRequestDecided if it's not pending anymore.
+	DecideRoleRequest(id, status, decidedBy string) (RoleRequest, error)
+	// PurgeRoleRequests drops the requests decided before the time and
+	// returns how many it dropped. Pending requests are kept.
+	PurgeRoleRequests(before time.Time) (int64, error)
+}
+
+// newID returns a random ID for a role request or pending change.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SQLRoleRequestStore keeps the role requests in the database.
+type SQLRoleRequestStore struct {
+	DB *sql.DB
+}
+
+const roleRequestColumns = `id, user_id, org_guid, space_guid, role, reason, status, created_at, decided_by, decided_at`
+
+func scanRoleRequest(row interface {
+	Scan(dest ...interface{}) error
+}) (RoleRequest, error) {
+	var (
+		r         RoleRequest
+		decidedAt *time.Time
+	)
+	err := row.Scan(&r.ID, &r.UserID, &r.OrgGUID, &r.SpaceGUID, &r.Role, &r.Reason, &r.Status,
+		&r.CreatedAt, &r.DecidedBy, &decidedAt)
+	r.DecidedAt = decidedAt
+	return r, err
+}
+
+// CreateRoleRequest keeps a new pending request.
+func (s *SQLRoleRequestStore) CreateRoleRequest(r RoleRequest) (RoleRequest, error) {
+	id, err := newID()
+	if err != nil {
+		return RoleRequest{}, err
+	}
+	r.ID, r.Status, r.CreatedAt = id, RoleRequestPending, time.Now().UTC()
+	r.DecidedBy, r.DecidedAt = "", nil
+	_, err = s.DB.Exec(`INSERT INTO role_requests (`+roleRequestColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, '', NULL)`,
+		r.ID, r.UserID, r.OrgGUID, r.SpaceGUID, r.Role, r.Reason, r.Status, r.CreatedAt)
+	return r, err
+}
+
+// RoleRequest returns the request.
+func (s *SQLRoleRequestStore) RoleRequest(id string) (RoleRequest, error) {
+	r, err := scanRoleRequest(s.DB.QueryRow(`SELECT `+roleRequestColumns+` FROM role_requests WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return RoleRequest{}, ErrRoleRequestNotFound
+	}
+	return r, err
+}
+
+// RoleRequests returns the requests matching the filter, newest first.
+func (s *SQLRoleRequestStore) RoleRequests(filter RoleRequestFilter) ([]RoleRequest, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	for _, f := range []struct{ column, value string }{
+		{"user_id", filter.UserID},
+		{"org_guid", filter.OrgGUID},
+		{"status", filter.Status},
+	} {
+		if f.value != "" {
+			args = append(args, f.value)
+			where = append(where, fmt.Sprintf("%s = $%d", f.column, len(args)))
+		}
+	}
+	query := `SELECT ` + roleRequestColumns + ` FROM role_requests`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	rows, err := s.DB.Query(query+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	requests := []RoleRequest{}
+	for rows.Next() {
+		r, err := scanRoleRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, r)
+	}
+	return requests, rows.Err()
+}
+
+// DecideRoleRequest approves or denies a pending request.
+func (s *SQLRoleRequestStore) DecideRoleRequest(id, status, decidedBy string) (RoleRequest, error) {
+	r, err := scanRoleRequest(s.DB.QueryRow(`UPDATE role_requests
+		SET status = $2, decided_by = $3, decided_at = $4
+		WHERE id = $1 AND status = 'pending'
+		RETURNING `+roleRequestColumns, id, status, decidedBy, time.Now().UTC()))
+	if err != sql.ErrNoRows {
+		return r, err
+	}
+	// Either there's no such request or it was decided already.
+	if _, err := s.RoleRequest(id); err != nil {
+		return RoleRequest{}, err
+	}
+	return RoleRequest{}, ErrRoleRequestDecided
+}
+
+// PurgeRoleRequests drops the requests decided before the time.
+func (s *SQLRoleRequestStore) PurgeRoleRequests(before time.Time) (int64, error) {
+	result, err := s.DB.Exec(`DELETE FROM role_requests WHERE status <> $1 AND decided_at < $2`,
+		RoleRequestPending, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MemoryRoleRequestStore keeps the role requests in memory. It's used when no
+// database is configured, so they're lost when the app restarts.
+type MemoryRoleRequestStore struct {
+	mu       sync.Mutex
+	requests map[string]RoleRequest
+}
+
+// CreateRoleRequest keeps a new pending request.
+func (s *MemoryRoleRequestStore) CreateRoleRequest(r RoleRequest) (RoleRequest, error) {
+	id, err := newID()
+	if err != nil {
+		return RoleRequest{}, err
+	}
+	r.ID, r.Status, r.CreatedAt = id, RoleRequestPending, time.Now().UTC()
+	r.DecidedBy, r.DecidedAt = "", nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.requests == nil {
+		s.requests = make(map[string]RoleRequest)
+	}
+	s.requests[r.ID] = r
+	return r, nil
+}
+
+// RoleRequest returns the request.
+func (s *MemoryRoleRequestStore) RoleRequest(id string) (RoleRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.requests[id]
+	if !ok {
+		return RoleRequest{}, ErrRoleRequestNotFound
+	}
+	return r, nil
+}
+
+// RoleRequests returns the requests matching the filter, newest first.
+func (s *MemoryRoleRequestStore) RoleRequests(filter RoleRequestFilter) ([]RoleRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := []RoleRequest{}
+	for _, r := range s.requests {
+		if filter.matches(r) {
+			requests = append(requests, r)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].CreatedAt.After(requests[j].CreatedAt) })
+	return requests, nil
+}
+
+// DecideRoleRequest approves or denies a pending request.
+func (s *MemoryRoleRequestStore) DecideRoleRequest(id, status, decidedBy string) (RoleRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.requests[id]
+	if !ok {
+		return RoleRequest{}, ErrRoleRequestNotFound
+	}
+	if r.Status != RoleRequestPending {
+		return RoleRequest{}, ErrRoleRequestDecided
+	}
+	now := time.Now().UTC()
+	r.Status, r.DecidedBy, r.DecidedAt = status, decidedBy, &now
+	s.requests[id] = r
+	return r, nil
+}
+
+// PurgeRoleRequests drops the requests decided before the time.
+func (s *MemoryRoleRequestStore) PurgeRoleRequests(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purged int64
+	for id, r := range s.requests {
+		if r.Status != RoleRequestPending && r.DecidedAt != nil && r.DecidedAt.Before(before) {
+			delete(s.requests, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,81 @@
+package db_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestRoleRequestValidate(t *testing.T) {
+	tests := []struct {
+		request db.RoleRequest
+		err     string
+	}{
+		{db.RoleRequest{OrgGUID: "org-1", Role: "billing_managers"}, ""},
+		{db.RoleRequest{OrgGUID: "org-1", SpaceGUID: "space-1", Role: "developers"}, ""},
+		{db.RoleRequest{Role: "users"}, "org_guid is required"},
+		{db.RoleRequest{OrgGUID: "org-1", Role: "developers"}, "not an org role"},
+		{db.RoleRequest{OrgGUID: "org-1", SpaceGUID: "space-1", Role: "billing_managers"}, "not a space role"},
+		{db.RoleRequest{OrgGUID: "org-1", Role: "users", Reason: strings.Repeat("a", 501)}, "longer than 500"},
+	}
+	for _, test := range tests {
+		err := test.request.Validate()
+		if test.err == "" && err != nil {
+			t.Errorf("Expected %+v to be valid. Found %v", test.request, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("Expected %+v to fail with %q. Found %v", test.request, test.err, err)
+		}
+	}
+}
+
+func TestMemoryRoleRequestStore(t *testing.T) {
+	store := &db.MemoryRoleRequestStore{}
+	request, err := store.CreateRoleRequest(db.RoleRequest{UserID: "user-guid", OrgGUID: "org-1", Role: "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.ID == "" || request.Status != db.RoleRequestPending {
+		t.Errorf("Expected a new pending request. Found %+v", request)
+	}
+	store.CreateRoleRequest(db.RoleRequest{UserID: "other-guid", OrgGUID: "org-1", Role: "auditors"})
+	store.CreateRoleRequest(db.RoleRequest{UserID: "user-guid", OrgGUID: "org-2", Role: "users"})
+
+	requests, _ := store.RoleRequests(db.RoleRequestFilter{OrgGUID: "org-1"})
+	if len(requests) != 2 {
+		t.Errorf("Expected the org's 2 requests. Found %+v", requests)
+	}
+	requests, _ = store.RoleRequests(db.RoleRequestFilter{UserID: "user-guid", OrgGUID: "org-1"})
+	if len(requests) != 1 || requests[0].ID != request.ID {
+		t.Errorf("Expected the user's request. Found %+v", requests)
+	}
+
+	decided, err := store.DecideRoleRequest(request.ID, db.RoleRequestDenied, "admin-guid")
+	if err != nil || decided.Status != db.RoleRequestDenied || decided.DecidedBy != "admin-guid" || decided.DecidedAt == nil {
+		t.Errorf("Expected the request to be denied. Found %+v, %v", decided, err)
+	}
+	if _, err := store.DecideRoleRequest(request.ID, db.RoleRequestApproved, "admin-guid"); err != db.ErrRoleRequestDecided {
+		t.Errorf("Expected %v. Found %v", db.ErrRoleRequestDecided, err)
+	}
+	if _, err := store.RoleRequest("unknown"); err != db.ErrRoleRequestNotFound {
+		t.Errorf("Expected %v. Found %v", db.ErrRoleRequestNotFound, err)
+	}
+	requests, _ = store.RoleRequests(db.RoleRequestFilter{OrgGUID: "org-1", Status: db.RoleRequestPending})
+	if len(requests) != 1 || requests[0].UserID != "other-guid" {
+		t.Errorf("Expected only the pending request. Found %+v", requests)
+	}
+
+	// Only decided requests are purged.
+	if purged, _ := store.PurgeRoleRequests(time.Now().Add(-time.Hour)); purged != 0 {
+		t.Errorf("Expected nothing decided an hour ago. Found %d purged", purged)
+	}
+	if purged, _ := store.PurgeRoleRequests(time.Now().Add(time.Minute)); purged != 1 {
+		t.Errorf("Expected the denied request to be purged. Found %d purged", purged)
+	}
+	requests, _ = store.RoleRequests(db.RoleRequestFilter{OrgGUID: "org-1"})
+	if len(requests) != 1 || requests[0].Status != db.RoleRequestPending {
+		t.Errorf("Expected only the pending request left. Found %+v", requests)
+	}
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+// SecretExpiry is how long a secret can be retrieved for. Secrets that are
+// never retrieved are dropped after that.
+const SecretExpiry = 2 * time.Minute
+
+// ErrSecretNotFound is returned for secrets that are unknown, of another
+// user, expired or already retrieved.
+var ErrSecretNotFound = errors.New("secret not found, expired or already retrieved")
+
+// Secret is a credential, e.g. a service key, kept for its owner to retrieve
+// exactly once, so it never sits in the responses the browser keeps in its
+// history or caches.
+type Secret struct {
+	// ID is the retrieval token.
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Owner     string    `json:"-"`
+	Value     []byte    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SecretStore keeps the secrets until they're retrieved.
+type SecretStore interface {
+	// CreateSecret keeps a new secret and returns it with its ID and expiry.
+	CreateSecret(s Secret) (Secret, error)
+	// TakeSecret returns the owner's secret and drops it, or returns
+	// ErrSecretNotFound.
+	TakeSecret(id, owner string) (Secret, error)
+	// PurgeSecrets drops the secrets that expired before the time and
+	// returns how many it dropped.
+	PurgeSecrets(before time.Time) (int64, error)
+}
+
+// newSecret sets the ID and expiry of a new secret.
+func newSecret(s Secret) (Secret, error) {
+	id, err := newID()
+	if err != nil {
+		return Secret{}, err
+	}
+	s.ID, s.ExpiresAt = id, time.Now().UTC().Add(SecretExpiry)
+	return s, nil
+}
+
+// SQLSecretStore keeps the secrets in the database, so they can be retrieved
+// from any instance of the dashboard. They're encrypted with Cipher when it's
+// set.
+type SQLSecretStore struct {
+	DB     *sql.DB
+	Cipher *ColumnCipher
+}
+
+// CreateSecret keeps a new secret.
+func (s *SQLSecretStore) CreateSecret(secret Secret) (Secret, error) {
+	secret, err := newSecret(secret)
+	if err != nil {
+		return Secret{}, err
+	}
+	value, err := s.Cipher.Encrypt(secret.Value)
+	if err != nil {
+		return Secret{}, err
+	}
+	_, err = s.DB.Exec(`INSERT INTO secrets (id, kind, owner, value, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		secret.ID, secret.Kind, secret.Owner, value, secret.ExpiresAt)
+	return secret, err
+}
+
+// TakeSecret returns the owner's secret and drops it. Deleting it in the same
+// statement makes sure it's only ever returned once.
+func (s *SQLSecretStore) TakeSecret(id, owner string) (Secret, error) {
+	secret := Secret{ID: id, Owner: owner}
+	err := s.DB.QueryRow(`DELETE FROM secrets
+		WHERE id = $1 AND owner = $2 AND expires_at > $3
+		RETURNING kind, value, expires_at`, id, owner, time.Now().UTC()).
+		Scan(&secret.Kind, &secret.Value, &secret.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return Secret{}, ErrSecretNotFound
+	}
+	if err != nil {
+		return Secret{}, err
+	}
+	if secret.Value, err = s.Cipher.Decrypt(secret.Value); err != nil {
+		return Secret{}, err
+	}
+	return secret, nil
+}
+
+// PurgeSecrets drops the secrets that expired before the time.
+func (s *SQLSecretStore) PurgeSecrets(before time.Time) (int64, error) {
+	result, err := s.DB.Exec(`DELETE FROM secrets WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MemorySecretStore keeps the secrets in memory. It's used when no database
+// is configured, so a secret can only be retrieved from the instance that
+// kept it.
+type MemorySecretStore struct {
+	mu      sync.Mutex
+	secrets map[string]Secret
+}
+
+// CreateSecret keeps a new secret.
+func (s *MemorySecretStore) CreateSecret(secret Secret) (Secret, error) {
+	secret, err := newSecret(secret)
+	if err != nil {
+		return Secret{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.secrets == nil {
+		s.secrets = make(map[string]Secret)
+	}
+	s.secrets[secret.ID] = secret
+	return secret, nil
+}
+
+// TakeSecret returns the owner's secret and drops it.
+func (s *MemorySecretStore) TakeSecret(id, owner string) (Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[id]
+	if !ok || secret.Owner != owner || !secret.ExpiresAt.After(time.Now()) {
+		return Secret{}, ErrSecretNotFound
+	}
+	delete(s.secrets, id)
+	return secret, nil
+}
+
+// PurgeSecrets drops the secrets that expired before the time.
+func (s *MemorySecretStore) PurgeSecrets(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purged int64
+	for id, secret := range s.secrets {
+		if secret.ExpiresAt.Before(before) {
+			delete(s.secrets, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,38 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestMemorySecretStore(t *testing.T) {
+	store := &db.MemorySecretStore{}
+	secret, err := store.CreateSecret(db.Secret{Kind: "service_key", Owner: "user-1", Value: []byte(`{"password":"p"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.ID == "" || !secret.ExpiresAt.After(time.Now()) {
+		t.Errorf("Expected a new secret. Found %+v", secret)
+	}
+
+	if _, err := store.TakeSecret(secret.ID, "user-2"); err != db.ErrSecretNotFound {
+		t.Errorf("Expected another user not to retrieve the secret. Found %v", err)
+	}
+	taken, err := store.TakeSecret(secret.ID, "user-1")
+	if err != nil || string(taken.Value) != `{"password":"p"}` || taken.Kind != "service_key" {
+		t.Errorf("Expected the secret. Found %+v, %v", taken, err)
+	}
+	if _, err := store.TakeSecret(secret.ID, "user-1"); err != db.ErrSecretNotFound {
+		t.Errorf("Expected the secret to be retrieved only once. Found %v", err)
+	}
+
+	store.CreateSecret(db.Secret{Kind: "ssh_code", Owner: "user-1", Value: []byte("code")})
+	if purged, _ := store.PurgeSecrets(time.Now()); purged != 0 {
+		t.Errorf("Expected the secret not to be purged before it expires. Found %d purged", purged)
+	}
+	if purged, _ := store.PurgeSecrets(time.Now().Add(db.SecretExpiry + time.Minute)); purged != 1 {
+		t.Errorf("Expected the expired secret to be purged. Found %d purged", purged)
+	}
+}
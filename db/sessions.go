@@ -0,0 +1,105 @@
+package db
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// SQLSessionStore keeps the users' encoded sessions in the database, so only
+// the session ID is sent in the cookie.
+type SQLSessionStore struct {
+	DB *sql.DB
+}
+
+// Session returns the encoded session, or nil if it doesn't exist or
+// expired.
+func (s *SQLSessionStore) Session(id string) ([]byte, error) {
+	var data []byte
+	err := s.DB.QueryRow(`SELECT data FROM sessions WHERE id = $1 AND expires_at > now()`, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return data, err
+}
+
+// SaveSession saves the encoded session until it expires.
+func (s *SQLSessionStore) SaveSession(id string, data []byte, ttl time.Duration) error {
+	_, err := s.DB.Exec(`INSERT INTO sessions (id, data, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET data = $2, expires_at = $3`,
+		id, data, time.Now().UTC().Add(ttl))
+	return err
+}
+
+// DeleteSession drops the session.
+func (s *SQLSessionStore) DeleteSession(id string) error {
+	_, err := s.DB.Exec(`DELETE FROM sessions WHERE id = $1`, id)
+	return err
+}
+
+// PurgeSessions drops the sessions that expired before the time.
+func (s *SQLSessionStore) PurgeSessions(before time.Time) (int64, error) {
+	result, err := s.DB.Exec(`DELETE FROM sessions WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MemorySessionStore keeps the encoded sessions in memory. The sessions are
+// lost when the app restarts and aren't shared between instances, so it's
+// only meant for tests and local development.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+type memorySession struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// Session returns the encoded session, or nil if it doesn't exist or
+// expired.
+func (s *MemorySessionStore) Session(id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || !time.Now().Before(session.expiresAt) {
+		return nil, nil
+	}
+	return session.data, nil
+}
+
+// SaveSession saves the encoded session until it expires.
+func (s *MemorySessionStore) SaveSession(id string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = map[string]memorySession{}
+	}
+	s.sessions[id] = memorySession{data: data, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// DeleteSession drops the session.
+func (s *MemorySessionStore) DeleteSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// PurgeSessions drops the sessions that expired before the time.
+func (s *MemorySessionStore) PurgeSessions(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purged int64
+	for id, session := range s.sessions {
+		if session.expiresAt.Before(before) {
+			delete(s.sessions, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
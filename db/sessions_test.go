@@ -0,0 +1,78 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestSQLSessionStore(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	store := &db.SQLSessionStore{DB: conn}
+
+	mock.ExpectExec("INSERT INTO sessions").
+		WithArgs("session-1", []byte("encoded"), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.SaveSession("session-1", []byte("encoded"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT data FROM sessions WHERE id = \\$1 AND expires_at > now\\(\\)").
+		WithArgs("session-1").
+		WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow([]byte("encoded")))
+	if data, err := store.Session("session-1"); err != nil || string(data) != "encoded" {
+		t.Errorf("Expected the session. Found %q, %v", data, err)
+	}
+
+	mock.ExpectQuery("SELECT data FROM sessions").
+		WithArgs("expired").
+		WillReturnRows(sqlmock.NewRows([]string{"data"}))
+	if data, err := store.Session("expired"); err != nil || data != nil {
+		t.Errorf("Expected no session. Found %q, %v", data, err)
+	}
+
+	mock.ExpectExec("DELETE FROM sessions WHERE id = \\$1").
+		WithArgs("session-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.DeleteSession("session-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectExec("DELETE FROM sessions WHERE expires_at < \\$1").
+		WithArgs(now).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	if purged, err := store.PurgeSessions(now); err != nil || purged != 3 {
+		t.Errorf("Expected 3 sessions purged. Found %d, %v", purged, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	store := &db.MemorySessionStore{}
+	store.SaveSession("session-1", []byte("encoded"), time.Hour)
+	store.SaveSession("expired", []byte("old"), -time.Hour)
+	if data, _ := store.Session("session-1"); string(data) != "encoded" {
+		t.Errorf("Expected the session. Found %q", data)
+	}
+	if data, _ := store.Session("expired"); data != nil {
+		t.Errorf("Expected no session. Found %q", data)
+	}
+	if purged, _ := store.PurgeSessions(time.Now()); purged != 1 {
+		t.Errorf("Expected 1 session purged. Found %d", purged)
+	}
+	store.DeleteSession("session-1")
+	if data, _ := store.Session("session-1"); data != nil {
+		t.Errorf("Expected the session to be deleted. Found %q", data)
+	}
+}
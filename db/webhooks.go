@@ -0,0 +1,350 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The statuses of a webhook delivery.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// ErrWebhookNotFound is returned for an unknown webhook subscription.
+var ErrWebhookNotFound = errors.New("webhook subscription not found")
+
+// WebhookSubscription is an org manager's webhook, which is posted the org's
+// events of the subscribed types.
+type WebhookSubscription struct {
+	ID      string   `json:"id"`
+	OrgGUID string   `json:"org_guid"`
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	// Secret signs the deliveries. It's only shown when the subscription is
+	// created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate checks the subscription posts to an HTTPS URL and subscribes to
+// some of the known events.
+func (s WebhookSubscription) Validate(knownEvents map[string]bool) error {
+	if s.OrgGUID == "" {
+		return errors.New("org_guid is required")
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return fmt.Errorf("%q is not an https URL", s.URL)
+	}
+	if len(s.Events) == 0 {
+		return errors.New("events are required")
+	}
+	for _, event := range s.Events {
+		if !knownEvents[event] {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// Subscribes returns true if the subscription is for the event type.
+func (s WebhookSubscription) Subscribes(event string) bool {
+	for _, e := range s.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is the delivery of an event to a subscription, and the
+// outcome of its attempts.
+type WebhookDelivery struct {
+	ID             string `json:"id"`
+	SubscriptionID string `json:"subscription_id"`
+	EventID        string `json:"event_id"`
+	Event          string `json:"event"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	// ResponseStatus is the status of the webhook's last response. 0 if it
+	// couldn't be reached.
+	ResponseStatus int        `json:"response_status,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// WebhookStore keeps the webhook subscriptions and their delivery log.
+type WebhookStore interface {
+	// CreateWebhook keeps a new subscription and returns it with its ID.
+	CreateWebhook(s WebhookSubscription) (WebhookSubscription, error)
+	// Webhook returns the subscription, or ErrWebhookNotFound.
+	Webhook(id string) (WebhookSubscription, error)
+	// Webhooks returns the org's subscriptions, or all of them for an empty
+	// org GUID, oldest first.
+	Webhooks(orgGUID string) ([]WebhookSubscription, error)
+	// DeleteWebhook drops the subscription and its deliveries, or returns
+	// ErrWebhookNotFound.
+	DeleteWebhook(id string) error
+	// RecordDelivery keeps the delivery, replacing the one with its ID.
+	RecordDelivery(d WebhookDelivery) error
+	// Deliveries returns at most limit of the subscription's deliveries,
+	// newest first.
+	Deliveries(subscriptionID string, limit int) ([]WebhookDelivery, error)
+	// PurgeDeliveries drops the deliveries created before the time and
+	// returns how many it dropped.
+	PurgeDeliveries(before time.Time) (int64, error)
+}
+
+// SQLWebhookStore keeps the webhook subscriptions in the database. Their
+// secrets are encrypted with Cipher when it's set.
+type SQLWebhookStore struct {
+	DB     *sql.DB
+	Cipher *ColumnCipher
+}
+
+const webhookColumns = `id, org_guid, url, events, secret, created_by, created_at`
+
+func scanWebhook(row interface {
+	Scan(dest ...interface{}) error
+}, cipher *ColumnCipher) (WebhookSubscription, error) {
+	var (
+		s      WebhookSubscription
+		events string
+		secret []byte
+	)
+	if err := row.Scan(&s.ID, &s.OrgGUID, &s.URL, &events, &secret, &s.CreatedBy, &s.CreatedAt); err != nil {
+		return s, err
+	}
+	secret, err := cipher.Decrypt(secret)
+	if err != nil {
+		return WebhookSubscription{}, fmt.Errorf("could not read the secret of webhook %s: %v", s.ID, err)
+	}
+	s.Events = strings.Split(events, ",")
+	s.Secret = string(secret)
+	return s, nil
+}
+
+// CreateWebhook keeps a new subscription.
+func (s *SQLWebhookStore) CreateWebhook(w WebhookSubscription) (WebhookSubscription, error) {
+	id, err := newID()
+	if err != nil {
+		return WebhookSubscription{}, err
+	}
+	w.ID, w.CreatedAt = id, time.Now().UTC()
+	secret, err := s.Cipher.Encrypt([]byte(w.Secret))
+	if err != nil {
+		return WebhookSubscription{}, err
+	}
+	_, err = s.DB.Exec(`INSERT INTO webhook_subscriptions (`+webhookColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		w.ID, w.OrgGUID, w.URL, strings.Join(w.Events, ","), secret, w.CreatedBy, w.CreatedAt)
+	return w, err
+}
+
+// Webhook returns the subscription.
+func (s *SQLWebhookStore) Webhook(id string) (WebhookSubscription, error) {
+	w, err := scanWebhook(s.DB.QueryRow(`SELECT `+webhookColumns+` FROM webhook_subscriptions WHERE id = $1`, id), s.Cipher)
+	if err == sql.ErrNoRows {
+		return WebhookSubscription{}, ErrWebhookNotFound
+	}
+	return w, err
+}
+
+// Webhooks returns the org's subscriptions, oldest first.
+func (s *SQLWebhookStore) Webhooks(orgGUID string) ([]WebhookSubscription, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhook_subscriptions`
+	var args []interface{}
+	if orgGUID != "" {
+		query += ` WHERE org_guid = $1`
+		args = append(args, orgGUID)
+	}
+	rows, err := s.DB.Query(query+` ORDER BY created_at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := []WebhookSubscription{}
+	for rows.Next() {
+		w, err := scanWebhook(rows, s.Cipher)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook drops the subscription. Its deliveries are dropped with it.
+func (s *SQLWebhookStore) DeleteWebhook(id string) error {
+	result, err := s.DB.Exec(`DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+// RecordDelivery keeps the delivery.
+func (s *SQLWebhookStore) RecordDelivery(d WebhookDelivery) error {
+	_, err := s.DB.Exec(`INSERT INTO webhook_deliveries
+		(id, subscription_id, event_id, event, status, attempts, response_status, error, created_at, finished_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE SET status = $5, attempts = $6, response_status = $7, error = $8, finished_at = $10`,
+		d.ID, d.SubscriptionID, d.EventID, d.Event, d.Status, d.Attempts, d.ResponseStatus, d.Error, d.CreatedAt, d.FinishedAt)
+	return err
+}
+
+// Deliveries returns the subscription's deliveries, newest first.
+func (s *SQLWebhookStore) Deliveries(subscriptionID string, limit int) ([]WebhookDelivery, error) {
+	rows, err := s.DB.Query(`SELECT id, subscription_id, event_id, event, status, attempts, response_status, error,
+		created_at, finished_at FROM webhook_deliveries
+		WHERE subscription_id = $1 ORDER BY created_at DESC LIMIT $2`, subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var (
+			d          WebhookDelivery
+			finishedAt *time.Time
+		)
+		if err := rows.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.Event, &d.Status, &d.Attempts,
+			&d.ResponseStatus, &d.Error, &d.CreatedAt, &finishedAt); err != nil {
+			return nil, err
+		}
+		d.FinishedAt = finishedAt
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// PurgeDeliveries drops the deliveries created before the time.
+func (s *SQLWebhookStore) PurgeDeliveries(before time.Time) (int64, error) {
+	result, err := s.DB.Exec(`DELETE FROM webhook_deliveries WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MemoryWebhookStore keeps the webhook subscriptions in memory. It's used when
+// no database is configured, so they're lost when the app restarts.
+type MemoryWebhookStore struct {
+	mu         sync.Mutex
+	webhooks   map[string]WebhookSubscription
+	deliveries []WebhookDelivery
+}
+
+// CreateWebhook keeps a new subscription.
+func (s *MemoryWebhookStore) CreateWebhook(w WebhookSubscription) (WebhookSubscription, error) {
+	id, err := newID()
+	if err != nil {
+		return WebhookSubscription{}, err
+	}
+	w.ID, w.CreatedAt = id, time.Now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.webhooks == nil {
+		s.webhooks = make(map[string]WebhookSubscription)
+	}
+	s.webhooks[w.ID] = w
+	return w, nil
+}
+
+// Webhook returns the subscription.
+func (s *MemoryWebhookStore) Webhook(id string) (WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.webhooks[id]
+	if !ok {
+		return WebhookSubscription{}, ErrWebhookNotFound
+	}
+	return w, nil
+}
+
+// Webhooks returns the org's subscriptions, oldest first.
+func (s *MemoryWebhookStore) Webhooks(orgGUID string) ([]WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhooks := []WebhookSubscription{}
+	for _, w := range s.webhooks {
+		if orgGUID == "" || w.OrgGUID == orgGUID {
+			webhooks = append(webhooks, w)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt) })
+	return webhooks, nil
+}
+
+// DeleteWebhook drops the subscription and its deliveries.
+func (s *MemoryWebhookStore) DeleteWebhook(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(s.webhooks, id)
+	kept := s.deliveries[:0]
+	for _, d := range s.deliveries {
+		if d.SubscriptionID != id {
+			kept = append(kept, d)
+		}
+	}
+	s.deliveries = kept
+	return nil
+}
+
+// RecordDelivery keeps the delivery.
+func (s *MemoryWebhookStore) RecordDelivery(d WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == d.ID {
+			s.deliveries[i] = d
+			return nil
+		}
+	}
+	s.deliveries = append(s.deliveries, d)
+	return nil
+}
+
+// Deliveries returns the subscription's deliveries, newest first.
+func (s *MemoryWebhookStore) Deliveries(subscriptionID string, limit int) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := []WebhookDelivery{}
+	for i := len(s.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if s.deliveries[i].SubscriptionID == subscriptionID {
+			deliveries = append(deliveries, s.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+// PurgeDeliveries drops the deliveries created before the time.
+func (s *MemoryWebhookStore) PurgeDeliveries(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.deliveries[:0]
+	for _, d := range s.deliveries {
+		if !d.CreatedAt.Before(before) {
+			kept = append(kept, d)
+		}
+	}
+	purged := int64(len(s.deliveries) - len(kept))
+	s.deliveries = kept
+	return purged, nil
+}
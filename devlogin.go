@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfenv"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// runDevLogin implements the dev-login subcommand. It resolves the settings
+// like the server does and mints a session logged in as a synthetic user, so
+// the dashboard can be developed against the mock backend without UAA. It
+// only works with LOCAL_CF, and prints the session cookie, or a URL of the
+// dashboard that sets it:
+//
+//	dev-login [-user dev] [-role user|operator|admin] [-scopes a,b] [-ttl 8h] [-url]
+func runDevLogin(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("dev-login", flag.ContinueOnError)
+	name := flags.String("user", "dev", "name of the synthetic user")
+	role := flags.String("role", "user", "role of the user: user, operator or admin")
+	scopes := flags.String("scopes", "", "comma separated scopes added to the role's")
+	ttl := flags.Duration("ttl", 8*time.Hour, "how long the session lasts")
+	loginURL := flags.Bool("url", false, "print a URL that logs in instead of the cookie")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	user := helpers.DevUser{Name: *name, Role: *role}
+	for _, scope := range strings.Split(*scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			user.Scopes = append(user.Scopes, scope)
+		}
+	}
+
+	app, _ := cfenv.Current()
+	envVars, err := withProfileDefaults(makeEnvVarSetOpts(app))
+	if err != nil {
+		return err
+	}
+	var settings helpers.Settings
+	if err := settings.InitSettings(envVars, app); err != nil {
+		return err
+	}
+	if settings.DB != nil {
+		defer settings.DB.Close()
+	}
+	cookie, err := helpers.MintDevSession(&settings, user, *ttl)
+	if err != nil {
+		return err
+	}
+	if *loginURL {
+		fmt.Fprintf(out, "%s/dev-login?session=%s\n", settings.AppURL, url.QueryEscape(cookie.Value))
+		return nil
+	}
+	fmt.Fprintf(out, "%s=%s\n", cookie.Name, cookie.Value)
+	return nil
+}
+
+// devLoginMain runs the dev-login subcommand and exits.
+func devLoginMain(args []string) {
+	if err := runDevLogin(args, os.Stdout); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,234 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var accessReviewLog = logging.New("access_reviews")
+
+// DefaultAccessReviewDueIn is how long org managers have to complete their
+// reviews unless configured otherwise.
+const DefaultAccessReviewDueIn = 14 * 24 * time.Hour
+
+// accessReviewCheckInterval is how often the scheduler checks whether a
+// campaign is due.
+const accessReviewCheckInterval = time.Hour
+
+// ccOrgRoles maps the org roles of the CF API's user_roles to the names of
+// its org role endpoints, which revoking them uses.
+var ccOrgRoles = map[string]string{
+	"org_user":        "users",
+	"org_manager":     "managers",
+	"billing_manager": "billing_managers",
+	"org_auditor":     "auditors",
+}
+
+// AccessReviews runs the access review campaigns: every Interval, a review
+// of each org's members and their roles is created, and the org's managers
+// are emailed to confirm or revoke each member before the review is due.
+// The orgs and members are listed with the dashboard's own credentials.
+type AccessReviews struct {
+	Store  db.AccessReviewStore
+	Mailer *OrgMailer
+	APIURL string
+	Client *http.Client
+	// Interval is how often a campaign starts, e.g. every 90 days. Campaigns
+	// are only started on demand when it's 0.
+	Interval time.Duration
+	DueIn    time.Duration
+}
+
+// NewAccessReviews creates AccessReviews started on demand, with the default
+// due date.
+func NewAccessReviews(store db.AccessReviewStore, mailer *OrgMailer, apiURL string, client *http.Client) *AccessReviews {
+	return &AccessReviews{Store: store, Mailer: mailer, APIURL: apiURL, Client: client, DueIn: DefaultAccessReviewDueIn}
+}
+
+// Due returns true if no campaign was started in the last Interval.
+func (a *AccessReviews) Due(now time.Time) (bool, error) {
+	if a.Interval <= 0 {
+		return false, nil
+	}
+	last, err := a.Store.AccessReviews(db.AccessReviewFilter{Limit: 1})
+	if err != nil {
+		return false, err
+	}
+	return len(last) == 0 || now.Sub(last[0].CreatedAt) >= a.Interval, nil
+}
+
+// StartCampaign creates the reviews of all the orgs with members, and
+// returns the campaign's name. It goes on with the other orgs when one can't
+// be reviewed, and returns the first error.
+func (a *AccessReviews) StartCampaign(now time.Time) (string, error) {
+	campaign := now.UTC().Format("2006-01-02")
+	var orgs []ccQuotaOrg
+	err := eachCCPage(a.Client, a.APIURL, "/v2/organizations?results-per-page=100", func(resources json.RawMessage) error {
+		var page []ccQuotaOrg
+		if err := json.Unmarshal(resources, &page); err != nil {
+			return err
+		}
+		orgs = append(orgs, page...)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	var firstErr error
+	for _, org := range orgs {
+		if err := a.reviewOrg(campaign, org, now); err != nil {
+			accessReviewLog.Warnf("could not start the access review of org %s: %v", org.Metadata.GUID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	accessReviewLog.Infof("started the %s access review campaign of %d orgs", campaign, len(orgs))
+	return campaign, firstErr
+}
+
+// reviewOrg creates the review of the org's members and emails its
+// managers.
+func (a *AccessReviews) reviewOrg(campaign string, org ccQuotaOrg, now time.Time) error {
+	var entries []db.AccessReviewEntry
+	path := "/v2/organizations/" + url.PathEscape(org.Metadata.GUID) + "/user_roles?results-per-page=100"
+	err := eachCCPage(a.Client, a.APIURL, path, func(resources json.RawMessage) error {
+		var page []struct {
+			Metadata struct {
+				GUID string `json:"guid"`
+			} `json:"metadata"`
+			Entity struct {
+				Username          string   `json:"username"`
+				OrganizationRoles []string `json:"organization_roles"`
+			} `json:"entity"`
+		}
+		if err := json.Unmarshal(resources, &page); err != nil {
+			return err
+		}
+		for _, user := range page {
+			entry := db.AccessReviewEntry{UserID: user.Metadata.GUID, Username: user.Entity.Username}
+			for _, role := range user.Entity.OrganizationRoles {
+				if name, ok := ccOrgRoles[role]; ok {
+					entry.Roles = append(entry.Roles, name)
+				}
+			}
+			sort.Strings(entry.Roles)
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	review, err := a.Store.CreateAccessReview(db.AccessReview{
+		Campaign: campaign,
+		OrgGUID:  org.Metadata.GUID,
+		OrgName:  org.Entity.Name,
+		DueAt:    now.UTC().Add(a.DueIn),
+		Entries:  entries,
+	})
+	if err != nil {
+		return err
+	}
+	subject := "Access review of org " + org.Entity.Name
+	message := fmt.Sprintf("Please review who has access to your org %s: confirm each of its %d members "+
+		"still needs their roles, or revoke them, by %s.", org.Entity.Name, len(entries), review.DueAt.Format("January 2, 2006"))
+	if err := a.Mailer.EmailManagers(org.Metadata.GUID, subject, message); err != nil {
+		accessReviewLog.Errorf("could not email the managers of org %s about access review %s: %v", org.Metadata.GUID, review.ID, err)
+	}
+	return nil
+}
+
+// Start starts a campaign whenever one is due, in the background.
+func (a *AccessReviews) Start() {
+	go func() {
+		for tick := time.Tick(accessReviewCheckInterval); ; <-tick {
+			due, err := a.Due(time.Now())
+			if err != nil {
+				accessReviewLog.Warnf("could not check whether an access review campaign is due: %v", err)
+				continue
+			}
+			if !due {
+				continue
+			}
+			if _, err := a.StartCampaign(time.Now()); err != nil {
+				accessReviewLog.Warnf("could not start the access review campaign: %v", err)
+			}
+		}
+	}()
+}
+
+// AccessReviewReport summarizes the reviews of a campaign, e.g. as evidence
+// of the control for auditors.
+type AccessReviewReport struct {
+	Campaign  string                  `json:"campaign"`
+	Orgs      int                     `json:"orgs"`
+	Completed int                     `json:"completed"`
+	Overdue   int                     `json:"overdue"`
+	Members   int                     `json:"members"`
+	Confirmed int                     `json:"confirmed"`
+	Revoked   int                     `json:"revoked"`
+	Undecided int                     `json:"undecided"`
+	Reviews   []AccessReviewOrgReport `json:"reviews"`
+}
+
+// AccessReviewOrgReport summarizes the review of an org.
+type AccessReviewOrgReport struct {
+	ID          string     `json:"id"`
+	OrgGUID     string     `json:"org_guid"`
+	OrgName     string     `json:"org_name"`
+	Status      string     `json:"status"`
+	DueAt       time.Time  `json:"due_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Confirmed   int        `json:"confirmed"`
+	Undecided   int        `json:"undecided"`
+	// RevokedUsers are the usernames of the members whose roles were
+	// revoked.
+	RevokedUsers []string `json:"revoked_users"`
+}
+
+// NewAccessReviewReport summarizes the reviews of the campaign as of now.
+func NewAccessReviewReport(campaign string, reviews []db.AccessReview, now time.Time) AccessReviewReport {
+	report := AccessReviewReport{Campaign: campaign, Reviews: []AccessReviewOrgReport{}}
+	for _, r := range reviews {
+		org := AccessReviewOrgReport{
+			ID:           r.ID,
+			OrgGUID:      r.OrgGUID,
+			OrgName:      r.OrgName,
+			Status:       r.Status,
+			DueAt:        r.DueAt,
+			CompletedAt:  r.CompletedAt,
+			RevokedUsers: []string{},
+		}
+		for _, e := range r.Entries {
+			switch e.Decision {
+			case db.AccessConfirm:
+				org.Confirmed++
+			case db.AccessRevoke:
+				org.RevokedUsers = append(org.RevokedUsers, e.Username)
+			default:
+				org.Undecided++
+			}
+		}
+		report.Orgs++
+		report.Members += len(r.Entries)
+		report.Confirmed += org.Confirmed
+		report.Revoked += len(org.RevokedUsers)
+		report.Undecided += org.Undecided
+		if r.Status == db.AccessReviewCompleted {
+			report.Completed++
+		} else if now.After(r.DueAt) {
+			report.Overdue++
+		}
+		report.Reviews = append(report.Reviews, org)
+	}
+	sort.Slice(report.Reviews, func(i, j int) bool { return report.Reviews[i].OrgName < report.Reviews[j].OrgName })
+	return report
+}
@@ -0,0 +1,79 @@
+package helpers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestAccessReviews(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/organizations":
+			w.Write([]byte(`{"next_url": null, "resources": [
+				{"metadata": {"guid": "org-1"}, "entity": {"name": "sandbox"}},
+				{"metadata": {"guid": "org-2"}, "entity": {"name": "empty"}}]}`))
+		case "/v2/organizations/org-1/user_roles":
+			w.Write([]byte(`{"next_url": null, "resources": [
+				{"metadata": {"guid": "user-1"}, "entity": {"username": "one@example.com", "organization_roles": ["org_user", "org_manager"]}},
+				{"metadata": {"guid": "user-2"}, "entity": {"username": "two@example.com", "organization_roles": ["org_user"]}}]}`))
+		case "/v2/organizations/org-2/user_roles":
+			w.Write([]byte(`{"next_url": null, "resources": []}`))
+		case "/v2/organizations/org-1/managers":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "user-1"}}]}`))
+		case "/Users":
+			w.Write([]byte(`{"resources": [{"id": "user-1", "emails": [{"value": "one@example.com", "primary": true}]}]}`))
+		default:
+			t.Errorf("Unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	templates, err := helpers.InitTemplates(filepath.Join("testdata", "templates"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := &recordingMailer{subjects: map[string][]string{}}
+	orgMailer := helpers.NewOrgMailer(server.URL, server.URL, server.Client())
+	orgMailer.Mailer, orgMailer.Templates = mailer, templates
+	store := &db.MemoryAccessReviewStore{}
+	reviews := helpers.NewAccessReviews(store, orgMailer, server.URL, server.Client())
+	reviews.Interval = 90 * 24 * time.Hour
+
+	now := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	if due, err := reviews.Due(now); err != nil || !due {
+		t.Fatalf("Expected the first campaign to be due. Found %v, %v", due, err)
+	}
+	campaign, err := reviews.StartCampaign(now)
+	if err != nil || campaign != "2026-10-01" {
+		t.Fatalf("Expected the campaign to start. Found %q, %v", campaign, err)
+	}
+	started, _ := store.AccessReviews(db.AccessReviewFilter{Campaign: campaign})
+	if len(started) != 1 || started[0].OrgGUID != "org-1" || !started[0].DueAt.Equal(now.Add(helpers.DefaultAccessReviewDueIn)) {
+		t.Fatalf("Expected a review of the org with members. Found %+v", started)
+	}
+	if roles := started[0].Entries[0].Roles; !reflect.DeepEqual(roles, []string{"managers", "users"}) {
+		t.Errorf("Expected the member's org roles. Found %v", roles)
+	}
+	if len(mailer.subjects["one@example.com"]) != 1 {
+		t.Errorf("Expected the org manager to be emailed. Found %v", mailer.subjects)
+	}
+	if due, _ := reviews.Due(time.Now()); due {
+		t.Error("Expected no campaign to be due right after one started")
+	}
+
+	store.DecideAccessReview(started[0].ID, []db.AccessReviewDecision{{UserID: "user-2", Decision: db.AccessRevoke}}, "user-1")
+	started, _ = store.AccessReviews(db.AccessReviewFilter{Campaign: campaign})
+	report := helpers.NewAccessReviewReport(campaign, started, now.Add(30*24*time.Hour))
+	if report.Orgs != 1 || report.Members != 2 || report.Revoked != 1 || report.Undecided != 1 || report.Overdue != 1 ||
+		!reflect.DeepEqual(report.Reviews[0].RevokedUsers, []string{"two@example.com"}) {
+		t.Errorf("Expected the campaign's report. Found %+v", report)
+	}
+}
@@ -0,0 +1,56 @@
+package helpers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var adminAuditLog = logging.New("admin_audit")
+
+// AdminAudit records the actions the dashboard takes with its own privileged
+// credentials on behalf of users, such as invites and role changes, which
+// otherwise leave no trace of who asked for them. Each action is logged, whatever
+// the log levels, with its actor, target and request ID, and kept in Store
+// for admins to query.
+type AdminAudit struct {
+	Store db.AdminAuditStore
+}
+
+// Record logs the privileged action and keeps it in the store. req is nil
+// for the actions the dashboard takes in the background, and actor is empty
+// when it takes them on its own.
+func (a *AdminAudit) Record(req *http.Request, actor, action, target string, details interface{}) {
+	entry := db.AdminAuditEntry{
+		Time:   time.Now().UTC(),
+		Actor:  actor,
+		Action: action,
+		Target: target,
+	}
+	if entry.Actor == "" {
+		entry.Actor = db.AdminAuditSystem
+	}
+	if req != nil {
+		entry.RequestID = Annotations(req).RequestID()
+	}
+	if details != nil {
+		raw, err := json.Marshal(details)
+		if err != nil {
+			adminAuditLog.Errorf("unable to marshal the details of %s on %s by %s: %v", action, target, entry.Actor, err)
+		} else {
+			entry.Details = raw
+		}
+	}
+	adminAuditLog.With("actor", entry.Actor, "action", action, "target", target,
+		"request_id", entry.RequestID, "details", string(entry.Details)).
+		Eventf("privileged action: %s on %s by %s", action, target, entry.Actor)
+	if a == nil || a.Store == nil {
+		return
+	}
+	if err := a.Store.RecordAdminAction(entry); err != nil {
+		adminAuditLog.Errorf("unable to store %s on %s by %s: %v", action, target, entry.Actor, err)
+	}
+}
@@ -0,0 +1,239 @@
+package helpers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var alertLog = logging.New("alerts")
+
+// Defaults of the alert rules.
+const (
+	defaultAlertInterval = 5 * time.Minute
+	// DefaultAlertErrorRate is the share of 5xx responses that fires the
+	// error rate alert.
+	DefaultAlertErrorRate = 0.05
+	// DefaultAlertUpstreamLatency is the mean duration of the CF API and UAA
+	// requests that fires the upstream latency alert.
+	DefaultAlertUpstreamLatency = 2 * time.Second
+	// DefaultAlertLoginFailures is the number of failed logins that fires the
+	// login failures alert.
+	DefaultAlertLoginFailures = 20
+	// alertMinResponses is the fewest responses the error rate is evaluated
+	// over, so a single error on a quiet night doesn't page anyone.
+	alertMinResponses = 20
+)
+
+// The alert rules.
+const (
+	AlertErrorRate       = "error_rate"
+	AlertUpstreamLatency = "upstream_latency"
+	AlertLoginFailures   = "login_failures"
+)
+
+// The states alerts are notified in.
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+var (
+	httpResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_http_responses_total",
+		Help: "Responses of the dashboard, by status class, e.g. 5xx.",
+	}, []string{"class"})
+	upstreamDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "dashboard_upstream_request_duration_seconds",
+		Help:    "Duration of the dashboard's requests to the CF API and UAA.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+func init() {
+	prometheus.MustRegister(httpResponses, upstreamDuration)
+}
+
+// RecordResponse counts a response of the dashboard by its status class.
+func RecordResponse(status int) {
+	httpResponses.WithLabelValues(fmt.Sprintf("%dxx", status/100)).Inc()
+}
+
+// ObserveUpstreamRequest records how long a request to the CF API or UAA
+// took, including failed ones.
+func ObserveUpstreamRequest(d time.Duration) {
+	upstreamDuration.Observe(d.Seconds())
+}
+
+// loginFailureEvents are the login steps that mean a login failed.
+var loginFailureEvents = map[string]bool{
+	LoginStateMismatch:  true,
+	LoginExchangeFailed: true,
+	LoginSessionFailed:  true,
+}
+
+// alertSample is the value of the metrics the rules use at a point in time.
+type alertSample struct {
+	responses       float64
+	serverErrors    float64
+	upstreamCount   float64
+	upstreamSeconds float64
+	loginFailures   float64
+}
+
+// sampleMetrics reads the metrics the rules use from the gatherer.
+func sampleMetrics(gatherer prometheus.Gatherer) (alertSample, error) {
+	var s alertSample
+	families, err := gatherer.Gather()
+	if err != nil {
+		return s, err
+	}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			switch family.GetName() {
+			case "dashboard_http_responses_total":
+				s.responses += m.GetCounter().GetValue()
+				if labels["class"] == "5xx" {
+					s.serverErrors += m.GetCounter().GetValue()
+				}
+			case "dashboard_upstream_request_duration_seconds":
+				s.upstreamCount += float64(m.GetHistogram().GetSampleCount())
+				s.upstreamSeconds += m.GetHistogram().GetSampleSum()
+			case "dashboard_login_events_total":
+				if loginFailureEvents[labels["event"]] {
+					s.loginFailures += m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+// Alert is an alert rule changing state, as notified.
+type Alert struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Source    string    `json:"source,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// AlertEvaluator checks the dashboard's own metrics every Interval and
+// notifies when an alert fires or resolves, for deployments without their
+// own monitoring. Each rule is evaluated over the last Interval.
+type AlertEvaluator struct {
+	Notifier *WebhookNotifier
+	// Gatherer is where the metrics are read from.
+	Gatherer prometheus.Gatherer
+	Interval time.Duration
+	// Source tells which dashboard the alerts come from, e.g. its URL.
+	Source string
+
+	ErrorRate       float64
+	UpstreamLatency time.Duration
+	LoginFailures   int
+
+	mu     sync.Mutex
+	last   *alertSample
+	firing map[string]bool
+}
+
+// NewAlertEvaluator creates an AlertEvaluator with the default rules,
+// notifying through the notifier.
+func NewAlertEvaluator(notifier *WebhookNotifier, source string) *AlertEvaluator {
+	return &AlertEvaluator{
+		Notifier:        notifier,
+		Gatherer:        prometheus.DefaultGatherer,
+		Interval:        defaultAlertInterval,
+		Source:          source,
+		ErrorRate:       DefaultAlertErrorRate,
+		UpstreamLatency: DefaultAlertUpstreamLatency,
+		LoginFailures:   DefaultAlertLoginFailures,
+		firing:          make(map[string]bool),
+	}
+}
+
+// Evaluate checks the rules over the metrics since the last evaluation and
+// notifies the alerts that changed state. The first evaluation only takes
+// the baseline. Alerts that can't be notified are tried again the next time.
+func (e *AlertEvaluator) Evaluate() error {
+	sample, err := sampleMetrics(e.Gatherer)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	last := e.last
+	e.last = &sample
+	if last == nil {
+		return nil
+	}
+	window := e.Interval.String()
+
+	var firstErr error
+	check := func(name string, value, threshold float64, firing bool, message string) {
+		if firing == e.firing[name] {
+			return
+		}
+		alert := Alert{Name: name, Value: value, Threshold: threshold, Source: e.Source, Time: time.Now().UTC(), State: AlertResolved}
+		if firing {
+			alert.State = AlertFiring
+		}
+		text := fmt.Sprintf("[%s] %s", alert.State, message)
+		if e.Source != "" {
+			text += " (" + e.Source + ")"
+		}
+		if err := e.Notifier.Notify(text, alert); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		e.firing[name] = firing
+	}
+
+	// Too few responses keep the alert as it is.
+	if responses := sample.responses - last.responses; responses >= alertMinResponses {
+		rate := (sample.serverErrors - last.serverErrors) / responses
+		check(AlertErrorRate, rate, e.ErrorRate, rate > e.ErrorRate, fmt.Sprintf(
+			"%.1f%% of the %.0f dashboard responses over the last %s were errors, the threshold is %.1f%%.",
+			rate*100, responses, window, e.ErrorRate*100))
+	}
+
+	if count := sample.upstreamCount - last.upstreamCount; count > 0 {
+		mean := time.Duration((sample.upstreamSeconds - last.upstreamSeconds) / count * float64(time.Second))
+		check(AlertUpstreamLatency, mean.Seconds(), e.UpstreamLatency.Seconds(), mean > e.UpstreamLatency, fmt.Sprintf(
+			"CF API and UAA requests took %s on average over the last %s, the threshold is %s.",
+			mean.Round(time.Millisecond), window, e.UpstreamLatency))
+	} else {
+		check(AlertUpstreamLatency, 0, e.UpstreamLatency.Seconds(), false, fmt.Sprintf(
+			"No CF API or UAA requests over the last %s.", window))
+	}
+
+	failures := sample.loginFailures - last.loginFailures
+	check(AlertLoginFailures, failures, float64(e.LoginFailures), failures > float64(e.LoginFailures), fmt.Sprintf(
+		"%.0f logins failed over the last %s, the threshold is %d.", failures, window, e.LoginFailures))
+
+	return firstErr
+}
+
+// Start evaluates the rules every Interval until the process exits.
+func (e *AlertEvaluator) Start() {
+	go func() {
+		// The first evaluation takes the baseline.
+		for tick := time.Tick(e.Interval); ; <-tick {
+			if err := e.Evaluate(); err != nil {
+				alertLog.Errorf("unable to evaluate the alerts: %v", err)
+			}
+		}
+	}()
+}
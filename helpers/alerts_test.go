@@ -0,0 +1,118 @@
+package helpers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestAlertEvaluator(t *testing.T) {
+	var notifications []struct {
+		Text    string        `json:"text"`
+		Details helpers.Alert `json:"details"`
+	}
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n struct {
+			Text    string        `json:"text"`
+			Details helpers.Alert `json:"details"`
+		}
+		json.NewDecoder(r.Body).Decode(&n)
+		notifications = append(notifications, n)
+	}))
+	defer webhook.Close()
+
+	// The same metrics as the dashboard's, in their own registry.
+	registry := prometheus.NewRegistry()
+	responses := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dashboard_http_responses_total", Help: "."}, []string{"class"})
+	upstream := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "dashboard_upstream_request_duration_seconds", Help: "."})
+	logins := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dashboard_login_events_total", Help: "."}, []string{"event"})
+	registry.MustRegister(responses, upstream, logins)
+
+	alerts := helpers.NewAlertEvaluator(helpers.NewWebhookNotifier(webhook.URL), "https://dashboard.example.com")
+	alerts.Gatherer = registry
+	alerts.Interval = time.Minute
+	alerts.LoginFailures = 5
+
+	responses.WithLabelValues("2xx").Add(1000)
+	if err := alerts.Evaluate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 0 {
+		t.Errorf("Expected the first evaluation to only take the baseline. Found %+v", notifications)
+	}
+
+	responses.WithLabelValues("2xx").Add(80)
+	responses.WithLabelValues("5xx").Add(20)
+	upstream.Observe(0.5)
+	upstream.Observe(5.5)
+	logins.WithLabelValues(helpers.LoginExchangeFailed).Add(4)
+	logins.WithLabelValues(helpers.LoginCompleted).Add(10)
+	if err := alerts.Evaluate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 2 {
+		t.Fatalf("Expected the error rate and upstream latency alerts to fire. Found %+v", notifications)
+	}
+	if n := notifications[0]; n.Details.Name != helpers.AlertErrorRate || n.Details.State != helpers.AlertFiring ||
+		n.Details.Value != 0.2 || !strings.HasPrefix(n.Text, "[firing] 20.0% of the 100 dashboard responses") ||
+		!strings.HasSuffix(n.Text, "(https://dashboard.example.com)") {
+		t.Errorf("Unexpected error rate alert %+v", n)
+	}
+	if n := notifications[1]; n.Details.Name != helpers.AlertUpstreamLatency || n.Details.Value != 3 || n.Details.Threshold != 2 {
+		t.Errorf("Unexpected upstream latency alert %+v", n)
+	}
+
+	// Firing alerts are only notified again when they resolve.
+	notifications = nil
+	responses.WithLabelValues("5xx").Add(50)
+	upstream.Observe(0.1)
+	logins.WithLabelValues(helpers.LoginStateMismatch).Add(6)
+	if err := alerts.Evaluate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 2 {
+		t.Fatalf("Expected the upstream latency alert to resolve and the login failures to fire. Found %+v", notifications)
+	}
+	if n := notifications[0]; n.Details.Name != helpers.AlertUpstreamLatency || n.Details.State != helpers.AlertResolved {
+		t.Errorf("Unexpected upstream latency alert %+v", n)
+	}
+	if n := notifications[1]; n.Details.Name != helpers.AlertLoginFailures || n.Details.State != helpers.AlertFiring || n.Details.Value != 6 {
+		t.Errorf("Unexpected login failures alert %+v", n)
+	}
+}
+
+func TestAlertEvaluatorRetriesNotifications(t *testing.T) {
+	status := http.StatusInternalServerError
+	var notified int
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notified++
+		w.WriteHeader(status)
+	}))
+	defer webhook.Close()
+	registry := prometheus.NewRegistry()
+	logins := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dashboard_login_events_total", Help: "."}, []string{"event"})
+	registry.MustRegister(logins)
+	alerts := helpers.NewAlertEvaluator(helpers.NewWebhookNotifier(webhook.URL), "")
+	alerts.Gatherer = registry
+
+	alerts.Evaluate()
+	logins.WithLabelValues(helpers.LoginSessionFailed).Add(100)
+	if err := alerts.Evaluate(); err == nil {
+		t.Error("Expected the failed notification to be an error")
+	}
+	status = http.StatusOK
+	logins.WithLabelValues(helpers.LoginSessionFailed).Add(100)
+	if err := alerts.Evaluate(); err != nil {
+		t.Fatal(err)
+	}
+	if notified != 2 {
+		t.Errorf("Expected the alert to be notified again. Found %d notifications", notified)
+	}
+}
@@ -0,0 +1,272 @@
+package helpers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var anomalyLog = logging.New("auth_anomalies")
+
+// The kinds of authentication anomalies.
+const (
+	// AnomalyNewCountry is a login from a country the user hasn't logged in
+	// from recently.
+	AnomalyNewCountry = "new_country"
+	// AnomalyNewASN is a login from a network the user hasn't logged in from
+	// recently, in a country they have.
+	AnomalyNewASN = "new_asn"
+	// AnomalyImpossibleTravel is a login too far from the user's last one to
+	// have travelled there since.
+	AnomalyImpossibleTravel = "impossible_travel"
+	// AnomalyFailureBurst is many failed logins from the same IP in a short
+	// time.
+	AnomalyFailureBurst = "failure_burst"
+)
+
+const (
+	// DefaultMaxTravelSpeed is the fastest, in km/h, users are expected to
+	// travel between logins, about an airliner's.
+	DefaultMaxTravelSpeed = 1000
+	// DefaultFailureBurst is how many failed logins from an IP within the
+	// failure window are an anomaly.
+	DefaultFailureBurst = 10
+	// DefaultFailureWindow is the window failed logins are counted over.
+	DefaultFailureWindow = 5 * time.Minute
+	// minTravelDistance is the shortest distance, in km, checked for
+	// impossible travel, since geo databases locate IPs roughly.
+	minTravelDistance = 500
+	// maxTrackedIPs is how many IPs with failed logins are tracked before
+	// those without recent failures are forgotten.
+	maxTrackedIPs = 10000
+)
+
+var authAnomalies = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "dashboard_auth_anomalies_total",
+	Help: "Anomalies found in the logins, by kind.",
+}, []string{"kind"})
+
+func init() {
+	prometheus.MustRegister(authAnomalies)
+}
+
+// AuthAnomaly is a login, or failed logins, that look unlike the user's.
+type AuthAnomaly struct {
+	Kind    string    `json:"kind"`
+	UserID  string    `json:"user_id,omitempty"`
+	IP      string    `json:"ip"`
+	Country string    `json:"country,omitempty"`
+	ASN     int64     `json:"asn,omitempty"`
+	Time    time.Time `json:"time"`
+	// Previous is the login the new one is compared with, for impossible
+	// travel.
+	Previous *db.LoginLocation `json:"previous,omitempty"`
+	// Speed is how fast, in km/h, the user would have travelled.
+	Speed float64 `json:"speed_kmh,omitempty"`
+	// Failures is how many logins failed within the window.
+	Failures int `json:"failures,omitempty"`
+}
+
+func (a AuthAnomaly) String() string {
+	switch a.Kind {
+	case AnomalyNewCountry:
+		return fmt.Sprintf("user %s logged in from a new country, %s (%s)", a.UserID, a.Country, a.IP)
+	case AnomalyNewASN:
+		return fmt.Sprintf("user %s logged in from a new network, AS%d (%s)", a.UserID, a.ASN, a.IP)
+	case AnomalyImpossibleTravel:
+		return fmt.Sprintf("user %s logged in from %s (%s) %s after %s (%s), at %.0f km/h",
+			a.UserID, a.Country, a.IP, a.Time.Sub(a.Previous.Time).Round(time.Minute),
+			a.Previous.Country, a.Previous.IP, a.Speed)
+	case AnomalyFailureBurst:
+		return fmt.Sprintf("%d failed logins from %s", a.Failures, a.IP)
+	}
+	return a.Kind
+}
+
+// AuthAnalyzer looks for anomalies in the logins: users logging in from a
+// new country or network, or from too far to have travelled since their
+// last login, and bursts of failed logins. The anomalies are counted, kept
+// in the users' audit events and notified to the operator.
+type AuthAnalyzer struct {
+	Store db.LoginLocationStore
+	// Geo locates the IPs. Only failure bursts are looked for without it.
+	Geo *GeoDB
+	// Notifier is notified of the anomalies. Nil when they're only logged.
+	Notifier *WebhookNotifier
+	// Record records the anomalies as audit events of their users.
+	Record func(req *http.Request, actor, action string, details interface{})
+	// Source tells which dashboard the notifications come from.
+	Source string
+
+	// MaxSpeed is the fastest, in km/h, users are expected to travel.
+	MaxSpeed float64
+	// FailureBurst is how many failed logins from an IP within
+	// FailureWindow are an anomaly.
+	FailureBurst  int
+	FailureWindow time.Duration
+
+	mu       sync.Mutex
+	failures map[string][]time.Time
+}
+
+// NewAuthAnalyzer creates an AuthAnalyzer comparing logins with the ones in
+// the store, with the default thresholds.
+func NewAuthAnalyzer(store db.LoginLocationStore, geo *GeoDB) *AuthAnalyzer {
+	return &AuthAnalyzer{
+		Store:         store,
+		Geo:           geo,
+		MaxSpeed:      DefaultMaxTravelSpeed,
+		FailureBurst:  DefaultFailureBurst,
+		FailureWindow: DefaultFailureWindow,
+		failures:      make(map[string][]time.Time),
+	}
+}
+
+// Login compares the user's login from the IP with their logins over the
+// last db.LoginLocationRetention, keeps it, and raises the anomalies found.
+// A user's first login has nothing to be compared with.
+func (a *AuthAnalyzer) Login(userID, ip string, now time.Time) ([]AuthAnomaly, error) {
+	loc, _ := a.Geo.Lookup(ip)
+	login := db.LoginLocation{UserID: userID, Time: now.UTC(), IP: ip,
+		Country: loc.Country, ASN: loc.ASN, Latitude: loc.Latitude, Longitude: loc.Longitude}
+	history, err := a.Store.LoginLocations(userID, now.Add(-db.LoginLocationRetention))
+	if err != nil {
+		return nil, err
+	}
+	if err := a.Store.RecordLoginLocation(login); err != nil {
+		return nil, err
+	}
+	anomalies := compareLogin(login, history, a.MaxSpeed)
+	for _, anomaly := range anomalies {
+		a.raise(anomaly)
+	}
+	return anomalies, nil
+}
+
+// compareLogin returns the anomalies of the login compared with the user's
+// previous logins, newest first.
+func compareLogin(login db.LoginLocation, history []db.LoginLocation, maxSpeed float64) []AuthAnomaly {
+	var anomalies []AuthAnomaly
+	anomaly := func(kind string) AuthAnomaly {
+		return AuthAnomaly{Kind: kind, UserID: login.UserID, IP: login.IP,
+			Country: login.Country, ASN: login.ASN, Time: login.Time}
+	}
+	countries, networks := map[string]bool{}, map[int64]bool{}
+	for _, l := range history {
+		if l.Country != "" {
+			countries[l.Country] = true
+		}
+		if l.ASN != 0 {
+			networks[l.ASN] = true
+		}
+	}
+	// Logins from before the IPs could be located don't make every country
+	// new.
+	newCountry := login.Country != "" && len(countries) > 0 && !countries[login.Country]
+	if newCountry {
+		anomalies = append(anomalies, anomaly(AnomalyNewCountry))
+	} else if login.ASN != 0 && len(networks) > 0 && !networks[login.ASN] {
+		anomalies = append(anomalies, anomaly(AnomalyNewASN))
+	}
+
+	if login.Latitude == nil {
+		return anomalies
+	}
+	for _, previous := range history {
+		if previous.Latitude == nil {
+			continue
+		}
+		// Only the last located login is compared, since the earlier ones
+		// were compared with it in turn.
+		distance := haversine(*previous.Latitude, *previous.Longitude, *login.Latitude, *login.Longitude)
+		// Logins within the same minute count as a minute apart, so the
+		// speed stays finite.
+		hours := math.Max(login.Time.Sub(previous.Time).Hours(), 1.0/60)
+		if speed := distance / hours; distance >= minTravelDistance && speed > maxSpeed {
+			travel := anomaly(AnomalyImpossibleTravel)
+			previous := previous
+			travel.Previous, travel.Speed = &previous, speed
+			anomalies = append(anomalies, travel)
+		}
+		break
+	}
+	return anomalies
+}
+
+// earthRadius is the mean radius of the Earth in km.
+const earthRadius = 6371
+
+// haversine returns the distance in km between two coordinates.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// LoginFailed counts a failed login from the IP, and raises an anomaly when
+// FailureBurst logins failed from it within FailureWindow. The IP's count
+// starts over once the anomaly is raised, so a long attack raises one per
+// burst. Failures are counted by each instance of the dashboard.
+func (a *AuthAnalyzer) LoginFailed(ip string, now time.Time) *AuthAnomaly {
+	if ip == "" || a.FailureBurst <= 0 {
+		return nil
+	}
+	a.mu.Lock()
+	cutoff := now.Add(-a.FailureWindow)
+	if len(a.failures) >= maxTrackedIPs {
+		for other, times := range a.failures {
+			if !times[len(times)-1].After(cutoff) {
+				delete(a.failures, other)
+			}
+		}
+	}
+	times := append(a.failures[ip], now)
+	for len(times) > 0 && !times[0].After(cutoff) {
+		times = times[1:]
+	}
+	failures := len(times)
+	if failures >= a.FailureBurst {
+		delete(a.failures, ip)
+	} else {
+		a.failures[ip] = times
+	}
+	a.mu.Unlock()
+	if failures < a.FailureBurst {
+		return nil
+	}
+	anomaly := AuthAnomaly{Kind: AnomalyFailureBurst, IP: ip, Time: now.UTC(), Failures: failures}
+	if loc, ok := a.Geo.Lookup(ip); ok {
+		anomaly.Country, anomaly.ASN = loc.Country, loc.ASN
+	}
+	a.raise(anomaly)
+	return &anomaly
+}
+
+// raise counts, logs, records and notifies the anomaly. Failure bursts have
+// no user, so they're only logged as audit events.
+func (a *AuthAnalyzer) raise(anomaly AuthAnomaly) {
+	authAnomalies.WithLabelValues(anomaly.Kind).Inc()
+	anomalyLog.Warnf("%s", anomaly)
+	if a.Record != nil {
+		a.Record(nil, anomaly.UserID, "auth_anomaly", anomaly)
+	}
+	if a.Notifier == nil {
+		return
+	}
+	text := "[security] " + anomaly.String()
+	if a.Source != "" {
+		text += " (" + a.Source + ")"
+	}
+	if err := a.Notifier.Notify(text, anomaly); err != nil {
+		anomalyLog.Errorf("unable to notify the %s anomaly: %v", anomaly.Kind, err)
+	}
+}
@@ -0,0 +1,82 @@
+package helpers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func testAuthAnalyzer(t *testing.T) *helpers.AuthAnalyzer {
+	geo, err := helpers.ParseGeoDB(strings.NewReader(testGeoDB + "192.0.2.0/24,AU,64503,-37.81,144.96\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return helpers.NewAuthAnalyzer(&db.MemoryLoginLocationStore{}, geo)
+}
+
+func anomalyKinds(anomalies []helpers.AuthAnomaly) []string {
+	kinds := []string{}
+	for _, a := range anomalies {
+		kinds = append(kinds, a.Kind)
+	}
+	return kinds
+}
+
+func TestAuthAnalyzerLogin(t *testing.T) {
+	analyzer := testAuthAnalyzer(t)
+	var recorded []string
+	analyzer.Record = func(_ *http.Request, actor, action string, details interface{}) {
+		recorded = append(recorded, actor+" "+action)
+	}
+	now := time.Now()
+	for _, tt := range []struct {
+		name     string
+		ip       string
+		after    time.Duration
+		expected string
+	}{
+		{"first login", "203.0.113.7", 0, ""},
+		{"same network", "203.0.113.8", time.Hour, ""},
+		// Sydney to Melbourne is about 710 km.
+		{"new network after a flight", "192.0.2.1", 3 * time.Hour, helpers.AnomalyNewASN},
+		{"new country too soon", "203.0.113.200", 4 * time.Hour, helpers.AnomalyNewCountry + "," + helpers.AnomalyImpossibleTravel},
+		{"unknown IP", "233.252.0.1", 5 * time.Hour, ""},
+	} {
+		anomalies, err := analyzer.Login("user-guid", tt.ip, now.Add(tt.after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kinds := strings.Join(anomalyKinds(anomalies), ","); kinds != tt.expected {
+			t.Errorf("%s: expected anomalies %q. Found %q", tt.name, tt.expected, kinds)
+		}
+	}
+	if len(recorded) != 3 || recorded[0] != "user-guid auth_anomaly" {
+		t.Errorf("Expected the anomalies to be recorded for the user. Found %v", recorded)
+	}
+}
+
+func TestAuthAnalyzerLoginFailed(t *testing.T) {
+	analyzer := testAuthAnalyzer(t)
+	analyzer.FailureBurst = 3
+	now := time.Now()
+	analyzer.LoginFailed("203.0.113.7", now.Add(-10*time.Minute))
+	for i := 0; i < 2; i++ {
+		if anomaly := analyzer.LoginFailed("203.0.113.7", now); anomaly != nil {
+			t.Fatalf("Expected failures outside the window not to count. Found %+v", anomaly)
+		}
+	}
+	if anomaly := analyzer.LoginFailed("198.51.100.1", now); anomaly != nil {
+		t.Errorf("Expected failures to be counted by IP. Found %+v", anomaly)
+	}
+	anomaly := analyzer.LoginFailed("203.0.113.7", now)
+	if anomaly == nil || anomaly.Kind != helpers.AnomalyFailureBurst || anomaly.Failures != 3 || anomaly.Country != "AU" {
+		t.Fatalf("Expected a failure burst from AU. Found %+v", anomaly)
+	}
+	if anomaly := analyzer.LoginFailed("203.0.113.7", now); anomaly != nil {
+		t.Errorf("Expected the count to start over after a burst. Found %+v", anomaly)
+	}
+}
@@ -0,0 +1,89 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The operation classes that can require a second admin's approval.
+const (
+	// ApprovalOrgDeletion is deleting an org.
+	ApprovalOrgDeletion = "org_deletion"
+	// ApprovalQuotaIncrease is creating or updating an org or space quota
+	// with a memory limit over the threshold.
+	ApprovalQuotaIncrease = "quota_increase"
+	// ApprovalAdminRoleGrant is making a user an org manager, who
+	// administers the org.
+	ApprovalAdminRoleGrant = "admin_role_grant"
+)
+
+var (
+	orgDeletionPath     = regexp.MustCompile(`^/v2/organizations/[^/]+$`)
+	quotaPath           = regexp.MustCompile(`^/v2/(space_)?quota_definitions(/[^/]+)?$`)
+	orgManagerGrantPath = regexp.MustCompile(`^/v2/(organizations/[^/]+/managers(/[^/]+)?|users/[^/]+/managed_organizations/[^/]+)$`)
+)
+
+// ApprovalPolicy selects the CF API requests of platform admins that are
+// parked until a second admin approves them.
+type ApprovalPolicy struct {
+	classes map[string]bool
+	// QuotaThresholdMB is the memory limit in MB quotas can be given without
+	// approval.
+	QuotaThresholdMB int
+}
+
+// NewApprovalPolicy makes a policy for the comma separated operation
+// classes. It returns nil when there are none.
+func NewApprovalPolicy(classes string, quotaThresholdMB int) (*ApprovalPolicy, error) {
+	p := &ApprovalPolicy{classes: make(map[string]bool), QuotaThresholdMB: quotaThresholdMB}
+	for _, class := range strings.Split(classes, ",") {
+		switch class = strings.TrimSpace(class); class {
+		case "":
+		case ApprovalOrgDeletion, ApprovalQuotaIncrease, ApprovalAdminRoleGrant:
+			p.classes[class] = true
+		default:
+			return nil, fmt.Errorf("unknown operation class %q, expected %s, %s or %s",
+				class, ApprovalOrgDeletion, ApprovalQuotaIncrease, ApprovalAdminRoleGrant)
+		}
+	}
+	if len(p.classes) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// NeedsBody returns true if the body of the request is needed to classify
+// it.
+func (p *ApprovalPolicy) NeedsBody(method, path string) bool {
+	return p.classes[ApprovalQuotaIncrease] && (method == "POST" || method == "PUT") && quotaPath.MatchString(path)
+}
+
+// Classify returns the operation class of the CF API request if it needs
+// approval, or an empty string.
+func (p *ApprovalPolicy) Classify(method, path string, body []byte) string {
+	switch {
+	case method == "DELETE" && orgDeletionPath.MatchString(path):
+		return p.enabled(ApprovalOrgDeletion)
+	case p.NeedsBody(method, path):
+		var quota struct {
+			MemoryLimit int `json:"memory_limit"`
+		}
+		// Bodies the CF API can't read either are left for it to reject.
+		if json.Unmarshal(body, &quota) == nil && quota.MemoryLimit > p.QuotaThresholdMB {
+			return ApprovalQuotaIncrease
+		}
+	case method == "PUT" && orgManagerGrantPath.MatchString(path):
+		return p.enabled(ApprovalAdminRoleGrant)
+	}
+	return ""
+}
+
+// enabled returns the class if it needs approval, or an empty string.
+func (p *ApprovalPolicy) enabled(class string) string {
+	if p.classes[class] {
+		return class
+	}
+	return ""
+}
@@ -0,0 +1,46 @@
+package helpers_test
+
+import (
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestApprovalPolicy(t *testing.T) {
+	if p, err := helpers.NewApprovalPolicy("", 0); p != nil || err != nil {
+		t.Errorf("Expected no policy without classes. Found %v, %v", p, err)
+	}
+	if _, err := helpers.NewApprovalPolicy("org_deletion,app_deletion", 0); err == nil {
+		t.Error("Expected an unknown class to be an error")
+	}
+	policy, err := helpers.NewApprovalPolicy("org_deletion, quota_increase,admin_role_grant", 10240)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method, path, body string
+		class              string
+	}{
+		{"DELETE", "/v2/organizations/org-1", "", helpers.ApprovalOrgDeletion},
+		{"DELETE", "/v2/organizations/org-1/users/user-1", "", ""},
+		{"DELETE", "/v2/spaces/space-1", "", ""},
+		{"PUT", "/v2/quota_definitions/quota-1", `{"memory_limit": 20480}`, helpers.ApprovalQuotaIncrease},
+		{"POST", "/v2/space_quota_definitions", `{"name": "big", "memory_limit": 20480}`, helpers.ApprovalQuotaIncrease},
+		{"PUT", "/v2/quota_definitions/quota-1", `{"memory_limit": 10240}`, ""},
+		{"PUT", "/v2/quota_definitions/quota-1", `{"name": "renamed"}`, ""},
+		{"PUT", "/v2/organizations/org-1/managers/user-1", "", helpers.ApprovalAdminRoleGrant},
+		{"PUT", "/v2/organizations/org-1/managers", `{"username": "user@example.com"}`, helpers.ApprovalAdminRoleGrant},
+		{"PUT", "/v2/users/user-1/managed_organizations/org-1", "", helpers.ApprovalAdminRoleGrant},
+		{"PUT", "/v2/organizations/org-1/auditors/user-1", "", ""},
+	}
+	for _, test := range tests {
+		if class := policy.Classify(test.method, test.path, []byte(test.body)); class != test.class {
+			t.Errorf("Expected %s %s to be %q. Found %q", test.method, test.path, test.class, class)
+		}
+	}
+
+	policy, _ = helpers.NewApprovalPolicy("quota_increase", 0)
+	if class := policy.Classify("DELETE", "/v2/organizations/org-1", nil); class != "" {
+		t.Errorf("Expected only quota increases to need approval. Found %q", class)
+	}
+}
@@ -0,0 +1,50 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// AssetManifest maps the names of the frontend assets, e.g. bundle.js, to the
+// content-hashed file names webpack gave them, e.g. bundle.3f2a9c.js.
+// It is written to manifest.json by the production webpack build.
+type AssetManifest map[string]string
+
+// LoadAssetManifest reads the manifest at path. A missing manifest is not an
+// error: development builds don't hash the assets, so they have none.
+func LoadAssetManifest(path string) (AssetManifest, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest AssetManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid asset manifest %s: %v", path, err)
+	}
+	return manifest, nil
+}
+
+// Path returns the file name to serve the named asset from. Assets that are
+// not in the manifest keep their name.
+func (m AssetManifest) Path(name string) string {
+	if hashed, ok := m[name]; ok {
+		return hashed
+	}
+	return name
+}
+
+// IsHashed returns true if the file is a content-hashed asset from the
+// manifest. Those never change, so they can be cached forever.
+func (m AssetManifest) IsHashed(file string) bool {
+	for name, hashed := range m {
+		if hashed == file && hashed != name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package helpers_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestLoadAssetManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A missing manifest means the assets are not hashed.
+	manifest, err := helpers.LoadAssetManifest(filepath.Join(dir, "manifest.json"))
+	if err != nil || manifest != nil {
+		t.Errorf("Expected no manifest and no error. Found %v, %v", manifest, err)
+	}
+	if path := manifest.Path("bundle.js"); path != "bundle.js" {
+		t.Errorf("Expected bundle.js without a manifest. Found %s", path)
+	}
+
+	path := filepath.Join(dir, "manifest.json")
+	ioutil.WriteFile(path, []byte(`{"bundle.js":"bundle.0123456789abcdef.js","style.css":"style.css"}`), 0644)
+	manifest, err = helpers.LoadAssetManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := manifest.Path("bundle.js"); path != "bundle.0123456789abcdef.js" {
+		t.Errorf("Expected the hashed bundle. Found %s", path)
+	}
+	if path := manifest.Path("img/favicon.ico"); path != "img/favicon.ico" {
+		t.Errorf("Expected assets not in the manifest to keep their name. Found %s", path)
+	}
+	if !manifest.IsHashed("bundle.0123456789abcdef.js") {
+		t.Error("Expected the hashed bundle to be hashed")
+	}
+	if manifest.IsHashed("style.css") || manifest.IsHashed("bundle.js") {
+		t.Error("Expected unhashed names not to be hashed")
+	}
+
+	ioutil.WriteFile(path, []byte(`not json`), 0644)
+	if _, err := helpers.LoadAssetManifest(path); err == nil {
+		t.Error("Expected an error for an invalid manifest")
+	}
+}
+
+func TestGetIndexAssets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, sub := range []string{"web", "mail"} {
+		os.Mkdir(filepath.Join(dir, sub), 0755)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "web", "index.html"), []byte(`<script src="assets/{{asset "bundle.js"}}"></script>`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "web", "page.html"), []byte(``), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "mail", "invite.html"), []byte(``), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "mail", "invite.txt"), []byte(``), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "mail", "broadcast.html"), []byte(``), 0644)
+
+	templates, err := helpers.InitTemplates(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := new(bytes.Buffer)
+	if err := templates.GetIndex(body, "", "", "", "", "", db.Theme{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body.String(), `src="assets/bundle.js"`) {
+		t.Errorf("Expected the plain bundle without a manifest. Found %s", body.String())
+	}
+
+	templates.Assets = helpers.AssetManifest{"bundle.js": "bundle.0123456789abcdef.js"}
+	body.Reset()
+	if err := templates.GetIndex(body, "", "", "", "", "", db.Theme{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body.String(), `src="assets/bundle.0123456789abcdef.js"`) {
+		t.Errorf("Expected the hashed bundle. Found %s", body.String())
+	}
+}
@@ -0,0 +1,222 @@
+package helpers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var breakerLog = logging.New("breakers")
+
+// Defaults of the circuit breakers.
+const (
+	// DefaultBreakerThreshold is how many failures in a row open a circuit.
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long a circuit stays open before a
+	// request is let through to try the upstream again.
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// The states of a circuit breaker.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+var breakerOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "dashboard_circuit_breaker_open",
+	Help: "1 while the circuit breaker of an upstream is open and its requests fail fast.",
+}, []string{"upstream"})
+
+func init() {
+	prometheus.MustRegister(breakerOpen)
+}
+
+// CircuitOpenError is returned for the requests to an upstream whose circuit
+// is open.
+type CircuitOpenError struct {
+	Upstream   string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s is unavailable, retry in %s", e.Upstream, e.RetryAfter)
+}
+
+// AsCircuitOpen returns the CircuitOpenError that err is or wraps, e.g. in
+// a *url.Error from an http.Client, or nil.
+func AsCircuitOpen(err error) *CircuitOpenError {
+	if e, ok := err.(*url.Error); ok {
+		err = e.Err
+	}
+	e, _ := err.(*CircuitOpenError)
+	return e
+}
+
+// BreakerState is the state of a circuit breaker, as shown in the health
+// report.
+type BreakerState struct {
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	// RetryAfter is how many seconds an open circuit stays open.
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// CircuitBreaker fails the requests to an upstream fast once Threshold of
+// them failed in a row, instead of having every request wait for the
+// upstream to time out. After Cooldown one request is let through, and the
+// circuit closes again if it succeeds.
+type CircuitBreaker struct {
+	Name      string
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker creates a closed CircuitBreaker of the upstream.
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	breakerOpen.WithLabelValues(name).Set(0)
+	return &CircuitBreaker{Name: name, Threshold: threshold, Cooldown: cooldown, state: BreakerClosed}
+}
+
+// Allow returns a *CircuitOpenError if requests to the upstream should fail
+// fast. Once the cooldown is over, a single request is allowed to try the
+// upstream.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerClosed {
+		return nil
+	}
+	if b.state == BreakerOpen {
+		if elapsed := time.Since(b.openedAt); elapsed < b.Cooldown {
+			return &CircuitOpenError{Upstream: b.Name, RetryAfter: b.Cooldown - elapsed}
+		}
+		b.state = BreakerHalfOpen
+		return nil
+	}
+	// Half open: the trial request is still running.
+	return &CircuitOpenError{Upstream: b.Name, RetryAfter: time.Second}
+}
+
+// Record records the outcome of an allowed request.
+func (b *CircuitBreaker) Record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		if b.state != BreakerClosed {
+			breakerLog.Infof("the circuit of %s closed", b.Name)
+		}
+		b.state, b.failures = BreakerClosed, 0
+		breakerOpen.WithLabelValues(b.Name).Set(0)
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.Threshold {
+		if b.state == BreakerClosed {
+			breakerLog.Warnf("the circuit of %s opened after %d failures", b.Name, b.failures)
+		}
+		b.state, b.openedAt = BreakerOpen, time.Now()
+		breakerOpen.WithLabelValues(b.Name).Set(1)
+	}
+}
+
+// Abandon records that an allowed request ended without telling whether the
+// upstream is up, e.g. because its caller gave up. A trial request is then
+// made again by the next request.
+func (b *CircuitBreaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.state, b.openedAt = BreakerOpen, time.Time{}
+	}
+}
+
+// OpenError returns a *CircuitOpenError while the circuit is open, or nil.
+// Unlike Allow, it never lets a trial request through. A nil breaker is
+// never open.
+func (b *CircuitBreaker) OpenError() *CircuitOpenError {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerOpen {
+		return nil
+	}
+	retryAfter := b.Cooldown - time.Since(b.openedAt)
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+	return &CircuitOpenError{Upstream: b.Name, RetryAfter: retryAfter}
+}
+
+// State returns the state of the breaker.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := BreakerState{State: b.state, Failures: b.failures}
+	if b.state == BreakerOpen {
+		if retryAfter := b.Cooldown - time.Since(b.openedAt); retryAfter > 0 {
+			state.RetryAfter = int((retryAfter + time.Second - 1) / time.Second)
+		}
+	}
+	return state
+}
+
+// breakerTransport sends the requests to each upstream through its circuit
+// breaker. A request fails if it can't reach the upstream, or the upstream
+// answers that it's unavailable.
+type breakerTransport struct {
+	base     http.RoundTripper
+	prefixes []string
+	breakers []*CircuitBreaker
+}
+
+// newBreakerTransport wraps the base transport with the breakers of the
+// upstreams at the URL prefixes.
+func newBreakerTransport(base http.RoundTripper, upstreams map[string]*CircuitBreaker) *breakerTransport {
+	t := &breakerTransport{base: base}
+	for prefix, breaker := range upstreams {
+		t.prefixes = append(t.prefixes, prefix)
+		t.breakers = append(t.breakers, breaker)
+	}
+	return t
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var breaker *CircuitBreaker
+	for i, prefix := range t.prefixes {
+		if strings.HasPrefix(req.URL.String(), prefix) {
+			breaker = t.breakers[i]
+			break
+		}
+	}
+	if breaker == nil {
+		return t.base.RoundTrip(req)
+	}
+	if err := breaker.Allow(); err != nil {
+		return nil, err
+	}
+	res, err := t.base.RoundTrip(req)
+	if err != nil && req.Context().Err() != nil {
+		// The caller gave up, which says nothing about the upstream.
+		breaker.Abandon()
+		return res, err
+	}
+	breaker.Record(err != nil || res.StatusCode == http.StatusBadGateway ||
+		res.StatusCode == http.StatusServiceUnavailable || res.StatusCode == http.StatusGatewayTimeout)
+	return res, err
+}
@@ -0,0 +1,75 @@
+package helpers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker("uaa", 2, time.Hour)
+	breaker.Record(true)
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("expected the circuit to stay closed under the threshold. Found %v", err)
+	}
+	breaker.Record(true)
+	open, ok := breaker.Allow().(*CircuitOpenError)
+	if !ok || open.Upstream != "uaa" || open.RetryAfter <= 0 {
+		t.Fatalf("expected the circuit to open at the threshold. Found %v", open)
+	}
+	if state := breaker.State(); state.State != BreakerOpen || state.RetryAfter != 3600 {
+		t.Errorf("expected an open state retrying after an hour. Found %+v", state)
+	}
+
+	// After the cooldown, a single trial request is let through.
+	breaker.openedAt = time.Now().Add(-time.Hour)
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("expected a trial request after the cooldown. Found %v", err)
+	}
+	if breaker.Allow() == nil {
+		t.Error("expected only one trial request")
+	}
+	breaker.Record(true)
+	if breaker.OpenError() == nil {
+		t.Fatal("expected a failed trial to open the circuit again")
+	}
+
+	breaker.openedAt = time.Now().Add(-time.Hour)
+	breaker.Allow()
+	breaker.Record(false)
+	if state := breaker.State(); state.State != BreakerClosed || state.Failures != 0 {
+		t.Errorf("expected a successful trial to close the circuit. Found %+v", state)
+	}
+
+	var none *CircuitBreaker
+	if none.OpenError() != nil {
+		t.Error("expected a nil breaker to never be open")
+	}
+}
+
+func TestBreakerTransport(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+	breaker := NewCircuitBreaker("cf_api", 1, time.Minute)
+	client := &http.Client{Transport: newBreakerTransport(http.DefaultTransport,
+		map[string]*CircuitBreaker{upstream.URL + "/v2": breaker})}
+
+	res, err := client.Get(upstream.URL + "/v2/info")
+	if err != nil || res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the first request to reach the upstream. Found %v", err)
+	}
+	res.Body.Close()
+	_, err = client.Get(upstream.URL + "/v2/info")
+	if open := AsCircuitOpen(err); open == nil || open.Upstream != "cf_api" {
+		t.Errorf("expected the second request to fail fast. Found %v", err)
+	}
+	// Other URLs don't go through the breaker.
+	if res, err := client.Get(upstream.URL + "/other"); err != nil {
+		t.Errorf("expected other URLs to reach the upstream. Found %v", err)
+	} else {
+		res.Body.Close()
+	}
+}
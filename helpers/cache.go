@@ -0,0 +1,235 @@
+package helpers
+
+import (
+	"container/list"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_cache_requests_total",
+		Help: "Cache lookups, by cache and result: hit, stale (served while refreshed) or miss.",
+	}, []string{"cache", "result"})
+	cacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_cache_evictions_total",
+		Help: "Entries evicted to keep caches within their size, by cache.",
+	}, []string{"cache"})
+	cacheBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dashboard_cache_bytes",
+		Help: "Approximate memory used by the entries of each cache.",
+	}, []string{"cache"})
+)
+
+func init() {
+	prometheus.MustRegister(cacheRequests, cacheEvictions, cacheBytes)
+}
+
+// CacheItem is a value loaded into a Cache.
+type CacheItem struct {
+	Value interface{}
+	// Size is the approximate memory used by the value, in bytes.
+	Size int64
+	// TTL is how long the value is fresh. Items with no TTL aren't cached.
+	TTL time.Duration
+	// Stale is how long after its TTL the value is still returned, while
+	// it's loaded again in the background.
+	Stale time.Duration
+}
+
+type cacheEntry struct {
+	key        string
+	item       CacheItem
+	expires    time.Time
+	staleUntil time.Time
+}
+
+// cacheCall is a load in progress. Other lookups of the key wait for it
+// instead of loading the value again.
+type cacheCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// Cache is an in-memory LRU cache bounded by the size of its entries. TTLs
+// are shortened by a random jitter so entries loaded together don't all
+// expire together, only one load per key runs at a time, and stale entries
+// can be served while they're refreshed.
+type Cache struct {
+	// Name labels the cache's metrics.
+	Name string
+	// MaxBytes bounds the total size of the entries.
+	MaxBytes int64
+	// Jitter is the largest fraction TTLs are shortened by, e.g. 0.1.
+	Jitter float64
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	size     int64
+	inflight map[string]*cacheCall
+	now      func() time.Time
+}
+
+// NewCache creates an empty Cache with a 10% TTL jitter.
+func NewCache(name string, maxBytes int64) *Cache {
+	return &Cache{
+		Name:     name,
+		MaxBytes: maxBytes,
+		Jitter:   0.1,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		inflight: make(map[string]*cacheCall),
+		now:      time.Now,
+	}
+}
+
+// Get returns the value of the key, calling load if it isn't cached. Errors
+// from load are returned and never cached.
+func (c *Cache) Get(key string, load func() (CacheItem, error)) (interface{}, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		now := c.now()
+		if now.Before(entry.expires) {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			cacheRequests.WithLabelValues(c.Name, "hit").Inc()
+			return entry.item.Value, nil
+		}
+		if now.Before(entry.staleUntil) {
+			c.lru.MoveToFront(elem)
+			if _, loading := c.inflight[key]; !loading {
+				call := c.startLoad(key)
+				go c.load(key, call, load)
+			}
+			c.mu.Unlock()
+			cacheRequests.WithLabelValues(c.Name, "stale").Inc()
+			return entry.item.Value, nil
+		}
+	}
+	cacheRequests.WithLabelValues(c.Name, "miss").Inc()
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := c.startLoad(key)
+	c.mu.Unlock()
+	c.load(key, call, load)
+	return call.value, call.err
+}
+
+// startLoad registers a load of the key. Must be called with the lock held.
+func (c *Cache) startLoad(key string) *cacheCall {
+	call := &cacheCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	return call
+}
+
+func (c *Cache) load(key string, call *cacheCall, load func() (CacheItem, error)) {
+	item, err := load()
+	call.value, call.err = item.Value, err
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.inflight, key)
+	close(call.done)
+	if err != nil {
+		return
+	}
+	c.store(key, item)
+}
+
+// Peek returns the value of the key if it's fresh, without loading it.
+func (c *Cache) Peek(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok || !c.now().Before(elem.Value.(*cacheEntry).expires) {
+		cacheRequests.WithLabelValues(c.Name, "miss").Inc()
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	cacheRequests.WithLabelValues(c.Name, "hit").Inc()
+	return elem.Value.(*cacheEntry).item.Value, true
+}
+
+// Set caches the item under the key, replacing its value.
+func (c *Cache) Set(key string, item CacheItem) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store(key, item)
+}
+
+// store caches the item, evicting the least recently used entries to make
+// room. Must be called with the lock held.
+func (c *Cache) store(key string, item CacheItem) {
+	c.remove(key)
+	if item.TTL <= 0 || item.Size > c.MaxBytes {
+		cacheBytes.WithLabelValues(c.Name).Set(float64(c.size))
+		return
+	}
+	ttl := item.TTL
+	if c.Jitter > 0 {
+		ttl -= time.Duration(rand.Float64() * c.Jitter * float64(ttl))
+	}
+	expires := c.now().Add(ttl)
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:        key,
+		item:       item,
+		expires:    expires,
+		staleUntil: expires.Add(item.Stale),
+	})
+	c.size += item.Size
+	for c.size > c.MaxBytes {
+		oldest := c.lru.Back()
+		c.remove(oldest.Value.(*cacheEntry).key)
+		cacheEvictions.WithLabelValues(c.Name).Inc()
+	}
+	cacheBytes.WithLabelValues(c.Name).Set(float64(c.size))
+}
+
+// Delete removes the key, e.g. when the value is known to have changed.
+func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+	cacheBytes.WithLabelValues(c.Name).Set(float64(c.size))
+}
+
+// DeletePrefix removes the keys starting with the prefix, e.g. all the pages
+// of a list whose keys are URLs with query strings.
+func (c *Cache) DeletePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(key)
+		}
+	}
+	cacheBytes.WithLabelValues(c.Name).Set(float64(c.size))
+}
+
+// remove removes the key. Must be called with the lock held.
+func (c *Cache) remove(key string) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.lru.Remove(elem)
+	delete(c.entries, key)
+	c.size -= elem.Value.(*cacheEntry).item.Size
+}
+
+// Len returns the number of entries, including stale ones.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,158 @@
+package helpers_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// loader returns a load function counting its calls.
+func loader(calls *int32, value string, size int64, ttl, stale time.Duration) func() (helpers.CacheItem, error) {
+	return func() (helpers.CacheItem, error) {
+		atomic.AddInt32(calls, 1)
+		return helpers.CacheItem{Value: value, Size: size, TTL: ttl, Stale: stale}, nil
+	}
+}
+
+func TestCacheHitAndExpiry(t *testing.T) {
+	cache := helpers.NewCache("test", 1000)
+	cache.Jitter = 0
+	var calls int32
+	for i := 0; i < 3; i++ {
+		value, err := cache.Get("key", loader(&calls, "value", 10, 50*time.Millisecond, 0))
+		if err != nil || value != "value" {
+			t.Fatalf("Unexpected value %v and error %v", value, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 load. Found %d", calls)
+	}
+	time.Sleep(60 * time.Millisecond)
+	cache.Get("key", loader(&calls, "value", 10, 50*time.Millisecond, 0))
+	if calls != 2 {
+		t.Errorf("Expected the expired value to be loaded again. Found %d loads", calls)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := helpers.NewCache("test", 100)
+	var calls int32
+	cache.Get("a", loader(&calls, "a", 40, time.Minute, 0))
+	cache.Get("b", loader(&calls, "b", 40, time.Minute, 0))
+	// Using a makes b the least recently used.
+	cache.Get("a", loader(&calls, "a", 40, time.Minute, 0))
+	cache.Get("c", loader(&calls, "c", 40, time.Minute, 0))
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 entries. Found %d", cache.Len())
+	}
+	calls = 0
+	cache.Get("a", loader(&calls, "a", 40, time.Minute, 0))
+	cache.Get("c", loader(&calls, "c", 40, time.Minute, 0))
+	if calls != 0 {
+		t.Errorf("Expected a and c to be cached. Found %d loads", calls)
+	}
+	cache.Get("b", loader(&calls, "b", 40, time.Minute, 0))
+	if calls != 1 {
+		t.Errorf("Expected b to be evicted. Found %d loads", calls)
+	}
+
+	// Values larger than the cache aren't kept.
+	cache.Get("large", loader(&calls, "large", 200, time.Minute, 0))
+	if cache.Len() != 2 {
+		t.Errorf("Expected the large value not to be cached. Found %d entries", cache.Len())
+	}
+}
+
+func TestCacheDeletePrefix(t *testing.T) {
+	cache := helpers.NewCache("test", 1000)
+	var calls int32
+	for _, key := range []string{
+		"/v2/shared_domains",
+		"/v2/shared_domains?page=2",
+		"/v2/shared_domains?page=2&results-per-page=100",
+		"/v2/shared_domains/domain-1",
+		"/v2/stacks",
+	} {
+		cache.Get(key, loader(&calls, key, 10, time.Minute, 0))
+	}
+	cache.DeletePrefix("/v2/shared_domains")
+	if cache.Len() != 1 {
+		t.Errorf("Expected only /v2/stacks to be kept. Found %d entries", cache.Len())
+	}
+	calls = 0
+	cache.Get("/v2/shared_domains?page=2", loader(&calls, "page 2", 10, time.Minute, 0))
+	cache.Get("/v2/stacks", loader(&calls, "/v2/stacks", 10, time.Minute, 0))
+	if calls != 1 {
+		t.Errorf("Expected the paged list to be loaded again and the stacks to be cached. Found %d loads", calls)
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	cache := helpers.NewCache("test", 1000)
+	cache.Jitter = 0
+	var calls int32
+	cache.Get("key", loader(&calls, "old", 10, 20*time.Millisecond, time.Minute))
+	time.Sleep(30 * time.Millisecond)
+
+	refreshed := make(chan struct{})
+	value, _ := cache.Get("key", func() (helpers.CacheItem, error) {
+		defer close(refreshed)
+		return helpers.CacheItem{Value: "new", Size: 10, TTL: time.Minute}, nil
+	})
+	if value != "old" {
+		t.Errorf("Expected the stale value. Found %v", value)
+	}
+	<-refreshed
+	// Let the refresh store the value.
+	time.Sleep(10 * time.Millisecond)
+	value, _ = cache.Get("key", loader(&calls, "unexpected", 10, time.Minute, 0))
+	if value != "new" {
+		t.Errorf("Expected the refreshed value. Found %v", value)
+	}
+}
+
+func TestCacheStampede(t *testing.T) {
+	cache := helpers.NewCache("test", 1000)
+	var calls int32
+	release := make(chan struct{})
+	load := func() (helpers.CacheItem, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return helpers.CacheItem{Value: "value", Size: 10, TTL: time.Minute}, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, _ := cache.Get("key", load); value != "value" {
+				t.Errorf("Unexpected value %v", value)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("Expected 1 load. Found %d", calls)
+	}
+}
+
+func TestCacheErrors(t *testing.T) {
+	cache := helpers.NewCache("test", 1000)
+	errLoad := errors.New("unavailable")
+	if _, err := cache.Get("key", func() (helpers.CacheItem, error) {
+		return helpers.CacheItem{}, errLoad
+	}); err != errLoad {
+		t.Errorf("Expected the load error. Found %v", err)
+	}
+	var calls int32
+	cache.Get("key", loader(&calls, "value", 10, time.Minute, 0))
+	if calls != 1 {
+		t.Errorf("Expected errors not to be cached. Found %d loads", calls)
+	}
+}
@@ -0,0 +1,129 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/govau/cf-common/env"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+// ErrChangelogReadOnly is returned when admins change a changelog read from a
+// file.
+var ErrChangelogReadOnly = errors.New("the changelog is read from a file; change the file and redeploy instead")
+
+// changelogHeading matches the headings of the versions of a markdown
+// changelog, e.g. "## [1.4.0] - 2018-03-01" or "## 1.4.0 - 2018-03-01 - New
+// navigation". Headings of other sections, such as "## [Unreleased]", are
+// skipped along with their notes.
+var changelogHeading = regexp.MustCompile(`^##\s+\[?(v?[0-9][0-9A-Za-z.-]*)\]?(?:\s+-\s+([0-9]{4}-[0-9]{2}-[0-9]{2}))?(?:\s+-\s+(.+))?\s*$`)
+
+// FileChangelog serves the changelog from a markdown file shipped with the
+// deployment. Who has seen which version is still kept in the embedded store.
+type FileChangelog struct {
+	db.ChangelogStore
+	entries []db.ChangelogEntry
+}
+
+// NewFileChangelog reads the markdown changelog at path. Each version is a
+// level 2 heading with its release notes below it.
+func NewFileChangelog(path string, seen db.ChangelogStore) (*FileChangelog, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ParseChangelog(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &FileChangelog{ChangelogStore: seen, entries: entries}, nil
+}
+
+// ParseChangelog reads the entries of a markdown changelog, newest version
+// first.
+func ParseChangelog(body []byte) ([]db.ChangelogEntry, error) {
+	entries := []db.ChangelogEntry{}
+	var current *db.ChangelogEntry
+	var notes []string
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		current.Body = strings.TrimSpace(strings.Join(notes, "\n"))
+		if err := current.Validate(); err != nil {
+			return fmt.Errorf("version %s: %v", current.Version, err)
+		}
+		entries = append(entries, *current)
+		current, notes = nil, nil
+		return nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "## ") {
+			if current != nil {
+				notes = append(notes, line)
+			}
+			continue
+		}
+		if err := finish(); err != nil {
+			return nil, err
+		}
+		match := changelogHeading.FindStringSubmatch(line)
+		if match == nil || !db.ValidChangelogVersion(match[1]) {
+			continue
+		}
+		current = &db.ChangelogEntry{Version: match[1], Title: strings.TrimSpace(match[3])}
+		if match[2] != "" {
+			published, err := time.Parse("2006-01-02", match[2])
+			if err != nil {
+				return nil, fmt.Errorf("version %s: %v", match[1], err)
+			}
+			current.PublishedAt = published
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	db.SortChangelog(entries)
+	return entries, nil
+}
+
+// ChangelogEntries returns the file's entries, newest version first.
+func (c *FileChangelog) ChangelogEntries() ([]db.ChangelogEntry, error) {
+	return c.entries, nil
+}
+
+// SaveChangelogEntry refuses to change the file's entries.
+func (c *FileChangelog) SaveChangelogEntry(db.ChangelogEntry) (db.ChangelogEntry, error) {
+	return db.ChangelogEntry{}, ErrChangelogReadOnly
+}
+
+// DeleteChangelogEntry refuses to change the file's entries.
+func (c *FileChangelog) DeleteChangelogEntry(string) error {
+	return ErrChangelogReadOnly
+}
+
+// parseChangelog uses the changelog file when configured, or else the
+// entries saved by admins.
+func parseChangelog(envVars *env.VarSet, seen db.ChangelogStore) (db.ChangelogStore, error) {
+	path := envVars.String(ChangelogPathEnvVar, "")
+	if path == "" {
+		return seen, nil
+	}
+	changelog, err := NewFileChangelog(path, seen)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the changelog: %v", err)
+	}
+	return changelog, nil
+}
@@ -0,0 +1,35 @@
+package helpers
+
+import (
+	"testing"
+)
+
+func TestParseChangelog(t *testing.T) {
+	entries, err := ParseChangelog([]byte(`# Changelog
+
+## [Unreleased]
+- Not shown yet.
+
+## [1.10.0] - 2018-03-01 - New navigation
+- The orgs are in the side bar.
+
+## 1.9.0
+- Bulk invites.
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries. Found %+v", entries)
+	}
+	if e := entries[0]; e.Version != "1.10.0" || e.Title != "New navigation" || e.Body != "- The orgs are in the side bar." || e.PublishedAt.Format("2006-01-02") != "2018-03-01" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	if e := entries[1]; e.Version != "1.9.0" || e.Body != "- Bulk invites." {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+
+	if _, err := ParseChangelog([]byte("## [1.0.0] - 2018-03-01\n\n## [0.9.0]\n- Notes\n")); err == nil {
+		t.Error("Expected a version without release notes to be refused")
+	}
+}
@@ -0,0 +1,132 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultChangeSetTTL is how long a planned change set can be applied.
+const DefaultChangeSetTTL = 15 * time.Minute
+
+var (
+	// ErrChangeSetInvalid is returned for change sets that weren't planned by
+	// the dashboard for the user, or were modified since.
+	ErrChangeSetInvalid = errors.New("the change set is invalid or was modified")
+	// ErrChangeSetExpired is returned for change sets planned too long ago.
+	ErrChangeSetExpired = errors.New("the change set expired, plan it again")
+	// ErrChangeSetApplied is returned for change sets that were already
+	// applied.
+	ErrChangeSetApplied = errors.New("the change set was already applied")
+)
+
+// Change is a single planned change of a bulk operation.
+type Change struct {
+	// Action is what will be done, e.g. "restage".
+	Action string `json:"action"`
+	// Target is the resource that will change, e.g. a CF API path.
+	Target string `json:"target"`
+	// Description explains the change to the user.
+	Description string `json:"description"`
+	// Current is the state of the target seen when planning. The change is
+	// not applied if the target no longer is in this state.
+	Current string `json:"current,omitempty"`
+	// Desired is the state the target is changed to.
+	Desired string `json:"desired,omitempty"`
+}
+
+// ChangeSet is the list of changes planned for a bulk operation. It's
+// signed so that applying it only ever does what the user was shown.
+type ChangeSet struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Owner     string    `json:"-"`
+	Changes   []Change  `json:"changes"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Signature string    `json:"signature"`
+}
+
+// ChangeSetSigner signs planned change sets and checks them before they're
+// applied. Each change set can only be applied once by each instance of the
+// dashboard.
+type ChangeSetSigner struct {
+	TTL time.Duration
+
+	key     []byte
+	mu      sync.Mutex
+	applied map[string]time.Time
+}
+
+// NewChangeSetSigner creates a ChangeSetSigner. The key must be the same on
+// all the instances of the dashboard.
+func NewChangeSetSigner(key []byte) *ChangeSetSigner {
+	// Derive a key so the given key is never used for two purposes.
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("change sets"))
+	return &ChangeSetSigner{
+		TTL:     DefaultChangeSetTTL,
+		key:     mac.Sum(nil),
+		applied: make(map[string]time.Time),
+	}
+}
+
+// Plan returns a signed change set of the changes, which only the owner can
+// apply.
+func (s *ChangeSetSigner) Plan(kind, owner string, changes []Change) (ChangeSet, error) {
+	id, err := GenerateRandomString(16)
+	if err != nil {
+		return ChangeSet{}, err
+	}
+	cs := ChangeSet{
+		ID:        id,
+		Kind:      kind,
+		Owner:     owner,
+		Changes:   changes,
+		ExpiresAt: time.Now().Add(s.TTL).UTC().Truncate(time.Second),
+	}
+	cs.Signature = s.sign(cs)
+	return cs, nil
+}
+
+// Claim checks the change set was planned for the owner, is unchanged and
+// hasn't expired, then marks it as applied.
+func (s *ChangeSetSigner) Claim(cs ChangeSet, owner string) error {
+	cs.Owner = owner
+	if owner == "" || !hmac.Equal([]byte(cs.Signature), []byte(s.sign(cs))) {
+		return ErrChangeSetInvalid
+	}
+	now := time.Now()
+	if now.After(cs.ExpiresAt) {
+		return ErrChangeSetExpired
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Forget change sets that can't be applied anymore anyway.
+	for id, expiresAt := range s.applied {
+		if now.After(expiresAt) {
+			delete(s.applied, id)
+		}
+	}
+	if _, ok := s.applied[cs.ID]; ok {
+		return ErrChangeSetApplied
+	}
+	s.applied[cs.ID] = cs.ExpiresAt
+	return nil
+}
+
+// sign returns the signature of the change set, including its owner.
+func (s *ChangeSetSigner) sign(cs ChangeSet) string {
+	cs.Signature = ""
+	payload, _ := json.Marshal(struct {
+		ChangeSet
+		Owner string `json:"owner"`
+	}{cs, cs.Owner})
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
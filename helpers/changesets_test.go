@@ -0,0 +1,42 @@
+package helpers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestChangeSetSigner(t *testing.T) {
+	signer := helpers.NewChangeSetSigner([]byte("key"))
+	changes := []helpers.Change{{Action: "restage", Target: "/v2/apps/app-1", Current: "2018-01-01T00:00:00Z"}}
+
+	tampered, _ := signer.Plan("restage", "user-guid", changes)
+	tampered.Changes = []helpers.Change{{Action: "restage", Target: "/v2/apps/app-2"}}
+	if err := signer.Claim(tampered, "user-guid"); err != helpers.ErrChangeSetInvalid {
+		t.Errorf("Expected %v for a modified change set. Found %v", helpers.ErrChangeSetInvalid, err)
+	}
+
+	cs, _ := signer.Plan("restage", "user-guid", changes)
+	if err := signer.Claim(cs, "other-user-guid"); err != helpers.ErrChangeSetInvalid {
+		t.Errorf("Expected %v for another user. Found %v", helpers.ErrChangeSetInvalid, err)
+	}
+	if err := signer.Claim(cs, "user-guid"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := signer.Claim(cs, "user-guid"); err != helpers.ErrChangeSetApplied {
+		t.Errorf("Expected %v when applied twice. Found %v", helpers.ErrChangeSetApplied, err)
+	}
+
+	other := helpers.NewChangeSetSigner([]byte("other key"))
+	cs, _ = signer.Plan("restage", "user-guid", changes)
+	if err := other.Claim(cs, "user-guid"); err != helpers.ErrChangeSetInvalid {
+		t.Errorf("Expected %v for another key. Found %v", helpers.ErrChangeSetInvalid, err)
+	}
+
+	signer.TTL = -time.Minute
+	cs, _ = signer.Plan("restage", "user-guid", changes)
+	if err := signer.Claim(cs, "user-guid"); err != helpers.ErrChangeSetExpired {
+		t.Errorf("Expected %v. Found %v", helpers.ErrChangeSetExpired, err)
+	}
+}
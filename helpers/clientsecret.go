@@ -0,0 +1,188 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var clientSecretLog = logging.New("client_secret")
+
+// The OAuth client secrets.
+const (
+	ClientSecretCurrent = "current"
+	ClientSecretNext    = "next"
+)
+
+var clientSecretInUse = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "dashboard_oauth_client_secret_in_use",
+	Help: "1 for the OAuth client secret UAA last accepted: current or next.",
+}, []string{"secret"})
+
+func init() {
+	prometheus.MustRegister(clientSecretInUse)
+}
+
+// ClientSecrets are the current and next secrets of the dashboard's OAuth
+// client, so UAA can be given the next secret before or after the dashboard
+// is, without a synchronized deploy. Requests authenticated as the client use
+// the secret UAA last accepted, and are retried with the other one when UAA
+// rejects it.
+type ClientSecrets struct {
+	ClientID string
+	Current  string
+	Next     string
+
+	mu    sync.Mutex
+	inUse string
+}
+
+// NewClientSecrets creates the ClientSecrets of the client. The next secret
+// is optional.
+func NewClientSecrets(clientID, current, next string) *ClientSecrets {
+	s := &ClientSecrets{ClientID: clientID, Current: current, Next: next}
+	s.use(ClientSecretCurrent)
+	return s
+}
+
+// InUse returns which secret UAA last accepted, current or next.
+func (s *ClientSecrets) InUse() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inUse
+}
+
+func (s *ClientSecrets) use(secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inUse != "" && s.inUse != secret {
+		clientSecretLog.Warnf("UAA rejected the %s client secret, now using the %s one", s.inUse, secret)
+	}
+	s.inUse = secret
+	for _, name := range []string{ClientSecretCurrent, ClientSecretNext} {
+		value := 0.0
+		if name == secret {
+			value = 1
+		}
+		clientSecretInUse.WithLabelValues(name).Set(value)
+	}
+}
+
+func (s *ClientSecrets) secret(name string) string {
+	if name == ClientSecretNext {
+		return s.Next
+	}
+	return s.Current
+}
+
+// Transport wraps the base transport, authenticating the requests made as
+// the client with the secret in use. It returns the base transport when
+// there's no next secret.
+func (s *ClientSecrets) Transport(base http.RoundTripper) http.RoundTripper {
+	if s.Next == "" {
+		return base
+	}
+	return &clientSecretTransport{secrets: s, base: base}
+}
+
+type clientSecretTransport struct {
+	secrets *ClientSecrets
+	base    http.RoundTripper
+}
+
+func (t *clientSecretTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	user, _, basic := req.BasicAuth()
+	form := strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded")
+	if !(basic && user == t.secrets.ClientID) && !form {
+		// Other requests, e.g. uploads to the CF API, aren't buffered.
+		return t.base.RoundTrip(req)
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	inUse := t.secrets.InUse()
+	clientReq, ok := t.withSecret(req, body, inUse)
+	if !ok {
+		// Not authenticated as the client.
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return t.base.RoundTrip(req)
+	}
+	res, err := t.base.RoundTrip(clientReq)
+	if err != nil || !isInvalidClient(res) {
+		return res, err
+	}
+	other := ClientSecretNext
+	if inUse == ClientSecretNext {
+		other = ClientSecretCurrent
+	}
+	retry, _ := t.withSecret(req, body, other)
+	retried, err := t.base.RoundTrip(retry)
+	if err != nil {
+		return res, nil
+	}
+	if isInvalidClient(retried) {
+		retried.Body.Close()
+		return res, nil
+	}
+	res.Body.Close()
+	t.secrets.use(other)
+	return retried, nil
+}
+
+// withSecret returns a copy of the request authenticated with the secret,
+// in its basic auth or its form, and false if the request isn't
+// authenticated as the client.
+func (t *clientSecretTransport) withSecret(req *http.Request, body []byte, name string) (*http.Request, bool) {
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		clone.Header[k] = v
+	}
+	secret := t.secrets.secret(name)
+	ok := false
+	if user, _, basic := req.BasicAuth(); basic && user == t.secrets.ClientID {
+		clone.SetBasicAuth(user, secret)
+		ok = true
+	}
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if form, err := url.ParseQuery(string(body)); err == nil &&
+			form.Get("client_id") == t.secrets.ClientID && form.Get("client_secret") != "" {
+			form.Set("client_secret", secret)
+			body = []byte(form.Encode())
+			clone.ContentLength = int64(len(body))
+			ok = true
+		}
+	}
+	clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return clone, ok
+}
+
+// isInvalidClient returns true if UAA rejected the client's credentials,
+// leaving the response's body readable.
+func isInvalidClient(res *http.Response) bool {
+	if res.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	var oauthErr struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(body, &oauthErr)
+	return oauthErr.Error == "invalid_client" || oauthErr.Error == "unauthorized"
+}
@@ -0,0 +1,56 @@
+package helpers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+func TestClientSecrets(t *testing.T) {
+	accepted := "old"
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if _, secret, _ := r.BasicAuth(); secret != accepted {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "unauthorized", "error_description": "Bad credentials"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "token", "token_type": "bearer", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	secrets := NewClientSecrets("dashboard", "old", "new")
+	client := &http.Client{Transport: secrets.Transport(http.DefaultTransport)}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+	config := &clientcredentials.Config{ClientID: "dashboard", ClientSecret: "old", TokenURL: server.URL}
+
+	if _, err := config.Token(ctx); err != nil || secrets.InUse() != ClientSecretCurrent || requests != 1 {
+		t.Errorf("Expected the current secret to be used. Found %v, %s after %d requests", err, secrets.InUse(), requests)
+	}
+
+	// UAA is given the next secret.
+	accepted, requests = "new", 0
+	if _, err := config.Token(ctx); err != nil || secrets.InUse() != ClientSecretNext || requests != 2 {
+		t.Errorf("Expected a retry with the next secret. Found %v, %s after %d requests", err, secrets.InUse(), requests)
+	}
+	requests = 0
+	if _, err := config.Token(ctx); err != nil || requests != 1 {
+		t.Errorf("Expected the next secret to be used first. Found %v after %d requests", err, requests)
+	}
+
+	accepted = "neither"
+	if _, err := config.Token(ctx); err == nil || secrets.InUse() != ClientSecretNext {
+		t.Errorf("Expected both secrets to be refused. Found %v, %s", err, secrets.InUse())
+	}
+
+	if NewClientSecrets("dashboard", "old", "").Transport(http.DefaultTransport) != http.DefaultTransport {
+		t.Error("Expected no rotation without a next secret")
+	}
+}
@@ -0,0 +1,280 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var incidentLog = logging.New("incidents")
+
+// Defaults of the CrashWatcher.
+const (
+	// DefaultCrashPollInterval is how often the CF API is asked for crashes.
+	DefaultCrashPollInterval = time.Minute
+	// DefaultCrashThreshold is how many crashes within DefaultCrashWindow
+	// make an app crash repeatedly.
+	DefaultCrashThreshold = 3
+	// DefaultCrashWindow is the time the crashes are counted over. A crash
+	// loop is resolved once the app doesn't crash for as long.
+	DefaultCrashWindow = 10 * time.Minute
+	// DefaultCrashEscalation is how long a crash loop goes on before the org
+	// is notified again.
+	DefaultCrashEscalation = time.Hour
+)
+
+// ccCrashEvent is a partial v2 CF API app.crash event.
+type ccCrashEvent struct {
+	Entity struct {
+		Actee            string    `json:"actee"`
+		ActeeName        string    `json:"actee_name"`
+		SpaceGUID        string    `json:"space_guid"`
+		OrganizationGUID string    `json:"organization_guid"`
+		Timestamp        time.Time `json:"timestamp"`
+		Metadata         struct {
+			ExitDescription string `json:"exit_description"`
+		} `json:"metadata"`
+	} `json:"entity"`
+}
+
+// AppCrashes is the data of the app.crashed_repeatedly event.
+type AppCrashes struct {
+	IncidentID string `json:"incident_id"`
+	AppGUID    string `json:"app_guid"`
+	AppName    string `json:"app_name"`
+	SpaceGUID  string `json:"space_guid"`
+	Crashes    int    `json:"crashes"`
+	// WindowSeconds is the time the crashes happened in.
+	WindowSeconds int `json:"window_seconds"`
+	// Escalated is true when the app is still crashing Escalation after the
+	// crash loop was first reported.
+	Escalated bool `json:"escalated"`
+	// LastExitDescription is why the app last crashed.
+	LastExitDescription string `json:"last_exit_description,omitempty"`
+}
+
+// CrashWatcher polls the CF API's app.crash events with the dashboard's own
+// credentials. When an app crashes Threshold times within Window, it records
+// a crash loop incident, which shows in the org's activity, publishes an
+// app.crashed_repeatedly event and emails the org managers. They're notified
+// again if the app is still crashing after Escalation, and the incident is
+// resolved once the app hasn't crashed for Window.
+type CrashWatcher struct {
+	Webhooks   *Webhooks
+	Incidents  db.IncidentStore
+	Mailer     *OrgMailer
+	APIURL     string
+	Client     *http.Client
+	Interval   time.Duration
+	Threshold  int
+	Window     time.Duration
+	Escalation time.Duration
+
+	mu      sync.Mutex
+	since   time.Time
+	crashes map[string][]time.Time
+}
+
+// NewCrashWatcher creates a CrashWatcher with the default thresholds.
+func NewCrashWatcher(webhooks *Webhooks, incidents db.IncidentStore, mailer *OrgMailer, apiURL string, client *http.Client) *CrashWatcher {
+	return &CrashWatcher{
+		Webhooks:   webhooks,
+		Incidents:  incidents,
+		Mailer:     mailer,
+		APIURL:     apiURL,
+		Client:     client,
+		Interval:   DefaultCrashPollInterval,
+		Threshold:  DefaultCrashThreshold,
+		Window:     DefaultCrashWindow,
+		Escalation: DefaultCrashEscalation,
+		crashes:    map[string][]time.Time{},
+	}
+}
+
+// Poll looks for the crashes since the last poll, opens or escalates the
+// incidents of the apps crashing repeatedly and resolves those of the apps
+// that stopped.
+func (w *CrashWatcher) Poll(now time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	since := w.since
+	if since.IsZero() || now.Sub(since) > w.Window {
+		since = now.Add(-w.Window)
+	}
+	events, err := w.crashEvents(since)
+	if err != nil {
+		return err
+	}
+	open, err := w.Incidents.OpenIncidents()
+	if err != nil {
+		return err
+	}
+	w.since = now
+	incidents := map[string]db.Incident{}
+	for _, incident := range open {
+		if incident.Kind == db.IncidentCrashLoop {
+			incidents[incident.AppGUID] = incident
+		}
+	}
+	last := map[string]ccCrashEvent{}
+	crashedNow := map[string]int{}
+	for _, event := range events {
+		app := event.Entity.Actee
+		w.crashes[app] = append(w.crashes[app], event.Entity.Timestamp)
+		last[app] = event
+		crashedNow[app]++
+	}
+	for app, times := range w.crashes {
+		// Forget the crashes that are out of the window.
+		recent := times[:0]
+		for _, t := range times {
+			if now.Sub(t) <= w.Window {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) == 0 {
+			delete(w.crashes, app)
+		} else {
+			w.crashes[app] = recent
+		}
+		event, crashed := last[app]
+		if !crashed {
+			continue
+		}
+		incident, isOpen := incidents[app]
+		switch {
+		case isOpen:
+			incident.Crashes += crashedNow[app]
+			incident.AppName = event.Entity.ActeeName
+			incident.LastExitDescription = event.Entity.Metadata.ExitDescription
+			incident.LastSeenAt = event.Entity.Timestamp
+			escalate := incident.EscalatedAt == nil && now.Sub(incident.OpenedAt) >= w.Escalation
+			if escalate {
+				incident.EscalatedAt = &now
+			}
+			if err := w.Incidents.UpdateIncident(incident); err != nil {
+				incidentLog.Errorf("could not update the crash loop of app %s: %v", app, err)
+				continue
+			}
+			if escalate {
+				w.notify(incident, len(recent))
+			}
+		case len(recent) >= w.Threshold:
+			incident, err := w.Incidents.CreateIncident(db.Incident{
+				Kind:                db.IncidentCrashLoop,
+				OrgGUID:             event.Entity.OrganizationGUID,
+				SpaceGUID:           event.Entity.SpaceGUID,
+				AppGUID:             app,
+				AppName:             event.Entity.ActeeName,
+				Crashes:             len(recent),
+				LastExitDescription: event.Entity.Metadata.ExitDescription,
+				OpenedAt:            now,
+				LastSeenAt:          event.Entity.Timestamp,
+			})
+			if err != nil {
+				incidentLog.Errorf("could not record the crash loop of app %s: %v", app, err)
+				continue
+			}
+			w.notify(incident, len(recent))
+		}
+	}
+	for app, incident := range incidents {
+		if _, crashed := last[app]; crashed || now.Sub(incident.LastSeenAt) <= w.Window {
+			continue
+		}
+		incident.ResolvedAt = &now
+		if err := w.Incidents.UpdateIncident(incident); err != nil {
+			incidentLog.Errorf("could not resolve the crash loop of app %s: %v", app, err)
+		}
+	}
+	return nil
+}
+
+// notify publishes the app.crashed_repeatedly event of the incident and
+// emails the org managers about it. The incident stands even if they can't
+// be notified.
+func (w *CrashWatcher) notify(incident db.Incident, crashes int) {
+	escalated := incident.EscalatedAt != nil
+	err := w.Webhooks.Publish(WebhookAppCrashedRepeatedly, incident.OrgGUID, AppCrashes{
+		IncidentID:          incident.ID,
+		AppGUID:             incident.AppGUID,
+		AppName:             incident.AppName,
+		SpaceGUID:           incident.SpaceGUID,
+		Crashes:             crashes,
+		WindowSeconds:       int(w.Window / time.Second),
+		Escalated:           escalated,
+		LastExitDescription: incident.LastExitDescription,
+	})
+	if err != nil {
+		webhookLog.Errorf("could not publish the crashes of app %s: %v", incident.AppGUID, err)
+	}
+	subject := fmt.Sprintf("App %s is crashing repeatedly", incident.AppName)
+	message := fmt.Sprintf("Your app %s crashed %d times in the last %s.", incident.AppName, crashes, w.Window)
+	if escalated {
+		subject = fmt.Sprintf("App %s is still crashing", incident.AppName)
+		message = fmt.Sprintf("Your app %s has been crashing for %s, %d times so far.",
+			incident.AppName, incident.EscalatedAt.Sub(incident.OpenedAt).Round(time.Minute), incident.Crashes)
+	}
+	if incident.LastExitDescription != "" {
+		message += " It last exited with: " + incident.LastExitDescription + "."
+	}
+	message += " Check its logs and recent changes."
+	if err := w.Mailer.EmailManagers(incident.OrgGUID, subject, message); err != nil {
+		incidentLog.Errorf("could not email the crash loop of app %s to the managers of org %s: %v",
+			incident.AppGUID, incident.OrgGUID, err)
+	}
+}
+
+// crashEvents returns the app.crash events since the time, oldest first.
+func (w *CrashWatcher) crashEvents(since time.Time) ([]ccCrashEvent, error) {
+	query := url.Values{
+		"q":                []string{"type:app.crash", "timestamp>" + since.UTC().Format(time.RFC3339)},
+		"order-direction":  []string{"asc"},
+		"results-per-page": []string{"100"},
+	}
+	next := "/v2/events?" + query.Encode()
+	var events []ccCrashEvent
+	for next != "" {
+		var page struct {
+			NextURL   string         `json:"next_url"`
+			Resources []ccCrashEvent `json:"resources"`
+		}
+		if err := getCCJSON(w.Client, w.APIURL+next, &page); err != nil {
+			return nil, err
+		}
+		events = append(events, page.Resources...)
+		next = page.NextURL
+	}
+	return events, nil
+}
+
+// getCCJSON gets the CF API or UAA URL with the client and decodes the JSON
+// response into v.
+func getCCJSON(client *http.Client, url string, v interface{}) error {
+	res, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", res.StatusCode, res.Request.URL.Path)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// Start polls every interval, in the background.
+func (w *CrashWatcher) Start() {
+	go func() {
+		for range time.Tick(w.Interval) {
+			if err := w.Poll(time.Now()); err != nil {
+				incidentLog.Warnf("could not look for app crashes: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,107 @@
+package helpers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+func TestCrashWatcher(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		crashes []time.Time
+	)
+	crashAt := func(times ...time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		crashes = times
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/events":
+			mu.Lock()
+			defer mu.Unlock()
+			resources := make([]string, len(crashes))
+			for i, at := range crashes {
+				timestamp, _ := json.Marshal(at)
+				resources[i] = fmt.Sprintf(`{"entity": {"actee": "app-1", "actee_name": "web", "space_guid": "space-1",
+					"organization_guid": "org-1", "timestamp": %s, "metadata": {"exit_description": "out of memory"}}}`, timestamp)
+			}
+			fmt.Fprintf(w, `{"next_url": null, "resources": [%s]}`, strings.Join(resources, ","))
+		case "/v2/organizations/org-1/managers":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "manager-guid"}}]}`))
+		case "/Users":
+			w.Write([]byte(`{"resources": [{"id": "manager-guid", "emails": [{"value": "manager@example.com", "primary": true}]}]}`))
+		default:
+			t.Errorf("Unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	templates, err := helpers.InitTemplates(filepath.Join("testdata", "templates"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := &recordingMailer{subjects: map[string][]string{}}
+	orgMailer := helpers.NewOrgMailer(server.URL, server.URL, server.Client())
+	orgMailer.Mailer, orgMailer.Templates = mailer, templates
+	incidents := &db.MemoryIncidentStore{}
+	webhooks := helpers.NewWebhooks(&db.MemoryWebhookStore{}, jobs.NewRunner(1, 0))
+	watcher := helpers.NewCrashWatcher(webhooks, incidents, orgMailer, server.URL, server.Client())
+	emailed := func() []string {
+		mailer.mu.Lock()
+		defer mailer.mu.Unlock()
+		return mailer.subjects["manager@example.com"]
+	}
+
+	now := time.Now().Truncate(time.Second)
+	crashAt(now.Add(-3*time.Minute), now.Add(-2*time.Minute))
+	watcher.Poll(now)
+	if open, _ := incidents.OpenIncidents(); len(open) != 0 {
+		t.Fatalf("Expected no incident under the threshold. Found %+v", open)
+	}
+
+	crashAt(now.Add(30 * time.Second))
+	watcher.Poll(now.Add(time.Minute))
+	open, _ := incidents.OpenIncidents()
+	if len(open) != 1 || open[0].Kind != db.IncidentCrashLoop || open[0].Crashes != 3 || open[0].AppName != "web" {
+		t.Fatalf("Expected a crash loop incident. Found %+v", open)
+	}
+	if subjects := emailed(); len(subjects) != 1 || subjects[0] != "App web is crashing repeatedly" {
+		t.Errorf("Expected the org managers to be emailed. Found %v", subjects)
+	}
+	if activity, _ := incidents.Incidents("org-1", now.Add(-time.Hour), 10); len(activity) != 1 {
+		t.Errorf("Expected the incident in the org's activity. Found %+v", activity)
+	}
+
+	// Still crashing: counted, and escalated once.
+	crashAt(now.Add(watcher.Escalation))
+	watcher.Poll(now.Add(watcher.Escalation + time.Minute))
+	crashAt(now.Add(watcher.Escalation + time.Minute))
+	watcher.Poll(now.Add(watcher.Escalation + 2*time.Minute))
+	open, _ = incidents.OpenIncidents()
+	if len(open) != 1 || open[0].Crashes != 5 || open[0].EscalatedAt == nil {
+		t.Fatalf("Expected the incident to be escalated. Found %+v", open)
+	}
+	if subjects := emailed(); len(subjects) != 2 || subjects[1] != "App web is still crashing" {
+		t.Errorf("Expected the escalation to be emailed once. Found %v", subjects)
+	}
+
+	// Quiet for the window: resolved.
+	crashAt()
+	watcher.Poll(now.Add(watcher.Escalation + watcher.Window + 2*time.Minute))
+	if open, _ := incidents.OpenIncidents(); len(open) != 0 {
+		t.Errorf("Expected the incident to be resolved. Found %+v", open)
+	}
+}
@@ -0,0 +1,60 @@
+package helpers
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dbPool is the database whose connection pool is reported in the metrics.
+var dbPool struct {
+	sync.Mutex
+	db *sql.DB
+}
+
+// dbStat returns a function reading one of the pool's stats, which is 0
+// without a database.
+func dbStat(stat func(sql.DBStats) float64) func() float64 {
+	return func() float64 {
+		dbPool.Lock()
+		defer dbPool.Unlock()
+		if dbPool.db == nil {
+			return 0
+		}
+		return stat(dbPool.db.Stats())
+	}
+}
+
+func init() {
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "dashboard_db_connections_open",
+			Help: "Connections to the database currently open.",
+		}, dbStat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "dashboard_db_connections_in_use",
+			Help: "Connections to the database currently running queries.",
+		}, dbStat(func(s sql.DBStats) float64 { return float64(s.InUse) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "dashboard_db_connections_max_open",
+			Help: "The most connections to the database the pool opens, 0 without a limit.",
+		}, dbStat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "dashboard_db_connection_waits_total",
+			Help: "Queries that waited for a connection because the pool was full.",
+		}, dbStat(func(s sql.DBStats) float64 { return float64(s.WaitCount) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "dashboard_db_connection_wait_seconds_total",
+			Help: "Time queries spent waiting for a connection because the pool was full.",
+		}, dbStat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })),
+	)
+}
+
+// WatchDBPool reports the stats of the database's connection pool in the
+// metrics.
+func WatchDBPool(conn *sql.DB) {
+	dbPool.Lock()
+	defer dbPool.Unlock()
+	dbPool.db = conn
+}
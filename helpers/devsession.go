@@ -0,0 +1,109 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DevSessionName is the session dev sessions are minted in, the one the
+// login saves the token in.
+const DevSessionName = "session"
+
+// DevRoles are the scopes of the roles dev sessions are minted with. Org and
+// space roles still come from the mock CF API.
+var DevRoles = map[string][]string{
+	"user":     {"openid", "cloud_controller.read", "cloud_controller.write", "scim.read"},
+	"operator": {"openid", "cloud_controller.read", "cloud_controller.write", "scim.read", FirehoseScope},
+	"admin":    {"openid", "cloud_controller.read", "cloud_controller.write", "scim.read", FirehoseScope, "cloud_controller.admin"},
+}
+
+// DevUser is the synthetic user a dev session is logged in as.
+type DevUser struct {
+	Name string
+	// Role is one of DevRoles.
+	Role string
+	// Scopes are added to the role's.
+	Scopes []string
+}
+
+// Token returns the user's unsigned access token, which expires after the
+// TTL.
+func (u DevUser) Token(ttl time.Duration) (oauth2.Token, error) {
+	roleScopes, ok := DevRoles[u.Role]
+	if !ok {
+		var roles []string
+		for role := range DevRoles {
+			roles = append(roles, role)
+		}
+		sort.Strings(roles)
+		return oauth2.Token{}, fmt.Errorf("unknown role %q, expected one of %v", u.Role, roles)
+	}
+	claims := TokenClaims{
+		UserID:   "dev-" + u.Name,
+		UserName: u.Name,
+		Email:    u.Name + "@dev.invalid",
+		Scope:    append([]string(nil), roleScopes...),
+	}
+	for _, scope := range u.Scopes {
+		if !claims.HasScope(scope) {
+			claims.Scope = append(claims.Scope, scope)
+		}
+	}
+	return UnsignedToken(claims, ttl), nil
+}
+
+// MintDevSession saves a session logged in as the user in the settings'
+// session store and returns its cookie, so the dashboard can be used without
+// UAA. The token expires after the TTL, and can't be refreshed. Only local
+// settings, with JWT access tokens, can mint sessions.
+func MintDevSession(s *Settings, user DevUser, ttl time.Duration) (*http.Cookie, error) {
+	if !s.LocalCF {
+		return nil, fmt.Errorf("dev sessions need %s", LocalCFEnvVar)
+	}
+	if s.OpaqueAccessTokens {
+		return nil, fmt.Errorf("dev sessions can't be introspected, unset %s", OpaqueAccessTokensEnvVar)
+	}
+	token, err := user.Token(ttl)
+	if err != nil {
+		return nil, err
+	}
+	req, _ := http.NewRequest("GET", s.AppURL, nil)
+	session, err := s.Sessions.New(req, DevSessionName)
+	if err != nil {
+		return nil, err
+	}
+	session.Values["token"] = token
+	StartSession(session, time.Now())
+	w := httptest.NewRecorder()
+	if err := session.Save(req, w); err != nil {
+		return nil, err
+	}
+	for _, cookie := range (&http.Response{Header: w.Header()}).Cookies() {
+		if cookie.Name == DevSessionName {
+			return cookie, nil
+		}
+	}
+	return nil, errors.New("the session store didn't set a cookie")
+}
+
+// DevSessionToken returns the access token of the dev session the cookie
+// value points to, or nil if there is none.
+func DevSessionToken(s *Settings, value string) *oauth2.Token {
+	req, _ := http.NewRequest("GET", s.AppURL, nil)
+	req.AddCookie(&http.Cookie{Name: DevSessionName, Value: value})
+	session, err := s.Sessions.New(req, DevSessionName)
+	if err != nil {
+		return nil
+	}
+	token, ok := session.Values["token"].(oauth2.Token)
+	if !ok {
+		return nil
+	}
+	return &token
+}
@@ -0,0 +1,65 @@
+package helpers_test
+
+import (
+	"encoding/gob"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"golang.org/x/oauth2"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestDevUserToken(t *testing.T) {
+	token, err := helpers.DevUser{Name: "ada", Role: "operator", Scopes: []string{"scim.write", "openid"}}.Token(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := helpers.ParseTokenClaims(token.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserName != "ada" || claims.UserID != "dev-ada" {
+		t.Errorf("Expected the token of ada, found %+v", claims)
+	}
+	if !claims.HasScope(helpers.FirehoseScope) || !claims.HasScope("scim.write") || claims.HasScope("cloud_controller.admin") {
+		t.Errorf("Expected the operator's scopes and scim.write, found %v", claims.Scope)
+	}
+	if len(claims.Scope) != len(helpers.DevRoles["operator"])+1 {
+		t.Errorf("Expected no duplicate scopes, found %v", claims.Scope)
+	}
+
+	if _, err := (helpers.DevUser{Name: "ada", Role: "root"}).Token(time.Hour); err == nil {
+		t.Error("Expected an unknown role to be refused")
+	}
+}
+
+func TestMintDevSession(t *testing.T) {
+	// InitSettings registers the token for the sessions.
+	gob.Register(oauth2.Token{})
+	s := &helpers.Settings{
+		AppURL:   "http://localhost:9999",
+		Sessions: sessions.NewCookieStore(testSessionAuthKey, testSessionEncKey),
+	}
+	user := helpers.DevUser{Name: "ada", Role: "admin"}
+	if _, err := helpers.MintDevSession(s, user, time.Hour); err == nil {
+		t.Error("Expected dev sessions to need LocalCF")
+	}
+
+	s.LocalCF = true
+	cookie, err := helpers.MintDevSession(s, user, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := helpers.DevSessionToken(s, cookie.Value)
+	if token == nil {
+		t.Fatal("Expected the cookie to hold the session")
+	}
+	if claims, _ := helpers.ParseTokenClaims(token.AccessToken); claims == nil || !claims.HasScope("cloud_controller.admin") {
+		t.Errorf("Expected the admin's token, found %v", token.AccessToken)
+	}
+	if token := helpers.DevSessionToken(s, cookie.Value+"x"); token != nil {
+		t.Error("Expected a tampered cookie to be refused")
+	}
+}
@@ -0,0 +1,140 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// DefaultDownloadURLTTL is how long a signed download URL can be used.
+	DefaultDownloadURLTTL = 5 * time.Minute
+
+	// DownloadParam is the query parameter of signed download URLs holding
+	// the grant.
+	DownloadParam = "download"
+)
+
+// DownloadPaths are the patterns, as matched by path.Match, of the paths
+// signed download URLs can be made for: logs, droplets and reports.
+var DownloadPaths = []string{
+	"/log/recent",
+	"/v2/apps/*/droplet/download",
+	"/stacks/migration",
+	"/admin/buildpacks/impact",
+	"/admin/logins",
+	"/admin/export",
+	"/api/orgs/*/activity",
+	"/api/me/activity",
+	"/platform/capacity",
+}
+
+// ErrNotDownloadable is returned for paths signed download URLs can't be made
+// for.
+var ErrNotDownloadable = errors.New("the path is not a download")
+
+// downloadGrant is what a signed download URL carries: the request it allows
+// and the access token it's made with.
+type downloadGrant struct {
+	URI   string
+	Token string
+}
+
+// DownloadSigner makes short-lived signed URLs for file downloads, so the
+// browser or a download manager can fetch them with a plain GET, without the
+// user's session. Each URL only allows the one download it was made for, and
+// expires quickly in case it's shared by accident. The user's access token is
+// encrypted into the URL, so they work on all the instances of the dashboard.
+type DownloadSigner struct {
+	TTL time.Duration
+
+	codec *securecookie.SecureCookie
+}
+
+// NewDownloadSigner creates a DownloadSigner. The keys must be the same on
+// all the instances of the dashboard.
+func NewDownloadSigner(hashKey, blockKey []byte, ttl time.Duration) *DownloadSigner {
+	// Derive keys so the given keys are never used for two purposes.
+	codec := securecookie.New(deriveKey(hashKey, "downloads"), deriveKey(blockKey, "downloads"))
+	codec.MaxAge(int((ttl + time.Second - 1) / time.Second))
+	// Tokens don't fit in the default limit of a cookie.
+	codec.MaxLength(0)
+	return &DownloadSigner{TTL: ttl, codec: codec}
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Sign returns the signed URL, relative to the dashboard, to GET the URI (a
+// path and query) with the token, and when it expires.
+func (s *DownloadSigner) Sign(uri string, token oauth2.Token) (string, time.Time, error) {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil || !IsDownloadPath(u.Path) {
+		return "", time.Time{}, ErrNotDownloadable
+	}
+	query := u.Query()
+	query.Del(DownloadParam)
+	u.RawQuery = query.Encode()
+	grant, err := s.codec.Encode(DownloadParam, downloadGrant{
+		URI:   u.RequestURI(),
+		Token: token.AccessToken,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiry := time.Now().Add(s.TTL)
+	if !token.Expiry.IsZero() && token.Expiry.Before(expiry) {
+		// The URL can't outlive the token it's made with.
+		expiry = token.Expiry
+	}
+	query.Set(DownloadParam, grant)
+	u.RawQuery = query.Encode()
+	return u.RequestURI(), expiry, nil
+}
+
+// Token returns the access token of the request's signed download URL, or
+// nil if it doesn't have one that's valid for the request.
+func (s *DownloadSigner) Token(req *http.Request) *oauth2.Token {
+	if s == nil {
+		return nil
+	}
+	value := req.URL.Query().Get(DownloadParam)
+	if value == "" || (req.Method != "GET" && req.Method != "HEAD") {
+		return nil
+	}
+	var grant downloadGrant
+	if err := s.codec.Decode(DownloadParam, value, &grant); err != nil {
+		LogSecurityEvent(req, "invalid or expired download URL")
+		return nil
+	}
+	u := *req.URL
+	query := u.Query()
+	query.Del(DownloadParam)
+	u.RawQuery = query.Encode()
+	if u.RequestURI() != grant.URI {
+		LogSecurityEvent(req, "download URL used for another request")
+		return nil
+	}
+	return &oauth2.Token{AccessToken: grant.Token, TokenType: "bearer"}
+}
+
+// IsDownloadPath returns true if signed download URLs can be made for the
+// path.
+func IsDownloadPath(p string) bool {
+	for _, pattern := range DownloadPaths {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
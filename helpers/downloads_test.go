@@ -0,0 +1,63 @@
+package helpers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestDownloadSigner(t *testing.T) {
+	key := []byte("00112233445566778899aabbccddeeff")
+	signer := NewDownloadSigner(key, key, time.Minute)
+	token := oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+
+	if _, _, err := signer.Sign("/v2/organizations", token); err != ErrNotDownloadable {
+		t.Errorf("expected ErrNotDownloadable for a path that isn't a download. Found %v", err)
+	}
+	signed, expiresAt, err := signer.Sign("/api/orgs/org-1/activity?format=csv", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expiresAt); d <= 0 || d > time.Minute {
+		t.Errorf("expected the URL to expire within the TTL. Found %v", d)
+	}
+	if strings.Contains(signed, "refresh") {
+		t.Error("expected the refresh token not to be in the URL")
+	}
+
+	for i, test := range []struct {
+		method string
+		uri    string
+		valid  bool
+	}{
+		{"GET", signed, true},
+		{"HEAD", signed, true},
+		// The URL only allows the request it was made for.
+		{"POST", signed, false},
+		{"GET", strings.Replace(signed, "org-1", "org-2", 1), false},
+		{"GET", strings.Replace(signed, "format=csv", "format=xlsx", 1), false},
+		{"GET", signed[:len(signed)-4], false},
+		{"GET", "/api/orgs/org-1/activity?format=csv", false},
+	} {
+		req, _ := http.NewRequest(test.method, test.uri, nil)
+		got := signer.Token(req)
+		if !test.valid {
+			if got != nil {
+				t.Errorf("%d: expected no token for %s %s", i, test.method, test.uri)
+			}
+			continue
+		}
+		if got == nil || got.AccessToken != "access" || got.RefreshToken != "" {
+			t.Errorf("%d: expected the access token only. Found %+v", i, got)
+		}
+	}
+
+	other := NewDownloadSigner([]byte("ffeeddccbbaa99887766554433221100"), key, time.Minute)
+	req, _ := http.NewRequest("GET", signed, nil)
+	if other.Token(req) != nil {
+		t.Error("expected a URL signed with another key to be rejected")
+	}
+}
@@ -0,0 +1,74 @@
+package helpers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/govau/cf-common/env"
+)
+
+// The backends emails can be sent with.
+const (
+	// EmailBackendSMTP sends emails through the SMTP server at SMTP_HOST.
+	EmailBackendSMTP = "smtp"
+	// EmailBackendSES sends emails with the AWS SES API over HTTPS.
+	EmailBackendSES = "ses"
+	// EmailBackendSendGrid sends emails with the SendGrid API over HTTPS.
+	EmailBackendSendGrid = "sendgrid"
+)
+
+// DefaultSendGridURL is the SendGrid API's endpoint sending emails.
+const DefaultSendGridURL = "https://api.sendgrid.com/v3/mail/send"
+
+// SESSettings are the AWS region and credentials of the SES backend.
+type SESSettings struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is set for temporary credentials.
+	SessionToken string
+	// Endpoint overrides the region's SES endpoint, e.g. for a VPC endpoint.
+	Endpoint string
+}
+
+// URL returns the SES API's endpoint sending emails.
+func (s SESSettings) URL() string {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://email." + s.Region + ".amazonaws.com"
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/v2/email/outbound-emails"
+}
+
+// SendGridSettings are the API key and endpoint of the SendGrid backend.
+type SendGridSettings struct {
+	APIKey string
+	URL    string
+}
+
+// parseEmailBackend reads the backend emails are sent with and its settings.
+// SMTP_HOST is only required by the SMTP backend, the default.
+func parseEmailBackend(envVars *env.VarSet, s *Settings) error {
+	s.EmailBackend = envVars.String(EmailBackendEnvVar, EmailBackendSMTP)
+	switch s.EmailBackend {
+	case EmailBackendSMTP:
+		s.SMTPHost = envVars.MustString(SMTPHostEnvVar)
+	case EmailBackendSES:
+		s.SES = &SESSettings{
+			Region:          envVars.MustString(SESRegionEnvVar),
+			AccessKeyID:     envVars.MustString(AWSAccessKeyIDEnvVar),
+			SecretAccessKey: envVars.MustString(AWSSecretAccessKeyEnvVar),
+			SessionToken:    envVars.String(AWSSessionTokenEnvVar, ""),
+			Endpoint:        envVars.String(SESEndpointEnvVar, ""),
+		}
+	case EmailBackendSendGrid:
+		s.SendGrid = &SendGridSettings{
+			APIKey: envVars.MustString(SendGridAPIKeyEnvVar),
+			URL:    envVars.String(SendGridURLEnvVar, DefaultSendGridURL),
+		}
+	default:
+		return fmt.Errorf("env var %q must be one of %s, %s or %s, not %q",
+			EmailBackendEnvVar, EmailBackendSMTP, EmailBackendSES, EmailBackendSendGrid, s.EmailBackend)
+	}
+	return nil
+}
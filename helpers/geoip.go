@@ -0,0 +1,110 @@
+package helpers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GeoLocation is what the geo database knows about an IP.
+type GeoLocation struct {
+	Country   string
+	ASN       int64
+	Latitude  *float64
+	Longitude *float64
+}
+
+// GeoDB looks up the country, ASN and coordinates of IPs in an offline
+// database, so logins can be located without sending their IPs anywhere.
+// The most specific network containing an IP wins.
+type GeoDB struct {
+	// networks are the database's networks by prefix length, keyed by
+	// their masked 16-byte address.
+	networks map[int]map[string]GeoLocation
+	// prefixes are the prefix lengths in the database, longest first.
+	prefixes []int
+}
+
+// LoadGeoDB reads the geo database from the CSV file at path. Each row is a
+// network, its country code, ASN, latitude and longitude, e.g.
+// 203.0.113.0/24,AU,64500,-33.87,151.21. Any but the network can be empty,
+// and lines starting with # are comments.
+func LoadGeoDB(path string) (*GeoDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseGeoDB(f)
+}
+
+// ParseGeoDB reads a geo database in the CSV format of LoadGeoDB.
+func ParseGeoDB(r io.Reader) (*GeoDB, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 5
+	reader.TrimLeadingSpace = true
+	g := &GeoDB{networks: make(map[int]map[string]GeoLocation)}
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, network, err := net.ParseCIDR(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", row, err)
+		}
+		loc := GeoLocation{Country: strings.ToUpper(record[1])}
+		if record[2] != "" {
+			if loc.ASN, err = strconv.ParseInt(strings.TrimPrefix(strings.ToUpper(record[2]), "AS"), 10, 64); err != nil {
+				return nil, fmt.Errorf("row %d: invalid ASN %q", row, record[2])
+			}
+		}
+		if record[3] != "" || record[4] != "" {
+			lat, latErr := strconv.ParseFloat(record[3], 64)
+			lon, lonErr := strconv.ParseFloat(record[4], 64)
+			if latErr != nil || lonErr != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+				return nil, fmt.Errorf("row %d: invalid coordinates %q,%q", row, record[3], record[4])
+			}
+			loc.Latitude, loc.Longitude = &lat, &lon
+		}
+		ones, bits := network.Mask.Size()
+		prefix := ones + 128 - bits
+		if g.networks[prefix] == nil {
+			g.networks[prefix] = make(map[string]GeoLocation)
+			g.prefixes = append(g.prefixes, prefix)
+		}
+		g.networks[prefix][maskedKey(network.IP, prefix)] = loc
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(g.prefixes)))
+	return g, nil
+}
+
+// maskedKey returns the first prefix bits of the IP as a 16-byte key, so
+// IPv4 addresses match the networks of both notations.
+func maskedKey(ip net.IP, prefix int) string {
+	return string(ip.To16().Mask(net.CIDRMask(prefix, 128)))
+}
+
+// Lookup returns what the database knows about the IP, or false when the
+// IP isn't in any of its networks.
+func (g *GeoDB) Lookup(ip string) (GeoLocation, bool) {
+	parsed := net.ParseIP(ip)
+	if g == nil || parsed == nil {
+		return GeoLocation{}, false
+	}
+	for _, prefix := range g.prefixes {
+		if loc, ok := g.networks[prefix][maskedKey(parsed, prefix)]; ok {
+			return loc, true
+		}
+	}
+	return GeoLocation{}, false
+}
@@ -0,0 +1,49 @@
+package helpers_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+const testGeoDB = `# network,country,asn,latitude,longitude
+203.0.113.0/24,au,AS64500,-33.87,151.21
+203.0.113.128/25,NZ,64501,-41.29,174.78
+2001:db8::/32,US,64502,,
+198.51.100.0/24,,,,
+`
+
+func TestGeoDBLookup(t *testing.T) {
+	geo, err := helpers.ParseGeoDB(strings.NewReader(testGeoDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		ip      string
+		country string
+		asn     int64
+		found   bool
+	}{
+		{"203.0.113.7", "AU", 64500, true},
+		{"203.0.113.200", "NZ", 64501, true},
+		{"2001:db8::1", "US", 64502, true},
+		{"198.51.100.1", "", 0, true},
+		{"192.0.2.1", "", 0, false},
+		{"not an ip", "", 0, false},
+	} {
+		loc, found := geo.Lookup(tt.ip)
+		if found != tt.found || loc.Country != tt.country || loc.ASN != tt.asn {
+			t.Errorf("Expected %s to be found %v in %s AS%d. Found %v, %+v", tt.ip, tt.found, tt.country, tt.asn, found, loc)
+		}
+	}
+	if loc, _ := geo.Lookup("2001:db8::1"); loc.Latitude != nil {
+		t.Errorf("Expected no coordinates. Found %v", *loc.Latitude)
+	}
+
+	for _, invalid := range []string{"203.0.113.0,AU,1,0,0\n", "203.0.113.0/24,AU,x,0,0\n", "203.0.113.0/24,AU,1,91,0\n"} {
+		if _, err := helpers.ParseGeoDB(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected %q to be refused", invalid)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults of the HealthChecker.
+const (
+	// DefaultHealthCheckTimeout is how long a dependency has to answer.
+	DefaultHealthCheckTimeout = 2 * time.Second
+	// DefaultHealthCheckTTL is how long the result of the checks is reused,
+	// so frequent health checks don't load the dependencies.
+	DefaultHealthCheckTTL = 10 * time.Second
+)
+
+// The statuses of the health report and its dependencies.
+const (
+	HealthOK          = "ok"
+	HealthDegraded    = "degraded"
+	HealthUnavailable = "unavailable"
+)
+
+// HealthCheck checks a dependency of the dashboard. The dashboard can't serve
+// requests while a critical dependency is down.
+type HealthCheck struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+// DependencyHealth is the result of a HealthCheck.
+type DependencyHealth struct {
+	Status    string `json:"status"`
+	Critical  bool   `json:"critical"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// HealthReport is the result of the checks of all the dependencies. Its
+// Status is unavailable when a critical dependency is down, and degraded
+// when another one is.
+type HealthReport struct {
+	Status       string                      `json:"status"`
+	CheckedAt    time.Time                   `json:"checked_at"`
+	Dependencies map[string]DependencyHealth `json:"dependencies"`
+	// Breakers are the current states of the upstreams' circuit breakers.
+	Breakers map[string]BreakerState `json:"breakers,omitempty"`
+	// ClientSecret is the OAuth client secret UAA last accepted, current or
+	// next, while the secret is rotated.
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// HealthChecker checks the dependencies concurrently, and reuses the report
+// for TTL.
+type HealthChecker struct {
+	Checks  []HealthCheck
+	Timeout time.Duration
+	TTL     time.Duration
+	// Breakers are reported as they are at each check, not reused.
+	Breakers []*CircuitBreaker
+	// ClientSecrets are reported while the secret is rotated.
+	ClientSecrets *ClientSecrets
+
+	mu     sync.Mutex
+	report *HealthReport
+}
+
+// NewHealthChecker creates a HealthChecker of the dashboard's dependencies:
+// the CF API and UAA, which are critical, the database when it's configured,
+// which is too, and the SMTP server.
+func NewHealthChecker(s *Settings, client *http.Client) *HealthChecker {
+	checks := []HealthCheck{
+		{Name: "cf_api", Critical: true, Check: httpHealthCheck(client, s.ConsoleAPI+"/v2/info")},
+		{Name: "uaa", Critical: true, Check: httpHealthCheck(client, s.UaaURL+"/info")},
+	}
+	if s.DB != nil {
+		checks = append(checks, HealthCheck{Name: "database", Critical: true, Check: s.DB.PingContext})
+	}
+	if s.SMTPHost != "" {
+		checks = append(checks, HealthCheck{Name: "smtp", Check: tcpHealthCheck(net.JoinHostPort(s.SMTPHost, s.SMTPPort))})
+	}
+	return &HealthChecker{Checks: checks, Timeout: DefaultHealthCheckTimeout, TTL: DefaultHealthCheckTTL}
+}
+
+// httpHealthCheck checks the URL answers with 200.
+func httpHealthCheck(client *http.Client, url string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		res, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %d", res.StatusCode)
+		}
+		return nil
+	}
+}
+
+// tcpHealthCheck checks the address accepts connections.
+func tcpHealthCheck(addr string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// Check returns the report of the last checks if it's recent enough, and
+// checks the dependencies again otherwise.
+func (h *HealthChecker) Check(ctx context.Context) HealthReport {
+	report := h.checkDependencies(ctx)
+	if len(h.Breakers) > 0 {
+		report.Breakers = make(map[string]BreakerState, len(h.Breakers))
+		for _, breaker := range h.Breakers {
+			report.Breakers[breaker.Name] = breaker.State()
+		}
+	}
+	if h.ClientSecrets != nil && h.ClientSecrets.Next != "" {
+		report.ClientSecret = h.ClientSecrets.InUse()
+	}
+	return report
+}
+
+func (h *HealthChecker) checkDependencies(ctx context.Context) HealthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.report != nil && time.Since(h.report.CheckedAt) < h.TTL {
+		return *h.report
+	}
+	report := HealthReport{
+		Status:       HealthOK,
+		CheckedAt:    time.Now().UTC(),
+		Dependencies: make(map[string]DependencyHealth, len(h.Checks)),
+	}
+	results := make([]DependencyHealth, len(h.Checks))
+	var wg sync.WaitGroup
+	for i, check := range h.Checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+			defer cancel()
+			start := time.Now()
+			err := check.Check(ctx)
+			results[i] = DependencyHealth{
+				Status:    HealthOK,
+				Critical:  check.Critical,
+				LatencyMS: int64(time.Since(start) / time.Millisecond),
+			}
+			if err != nil {
+				results[i].Status, results[i].Error = HealthUnavailable, err.Error()
+			}
+		}(i, check)
+	}
+	wg.Wait()
+	for i, check := range h.Checks {
+		result := results[i]
+		report.Dependencies[check.Name] = result
+		switch {
+		case result.Status == HealthOK:
+		case check.Critical:
+			report.Status = HealthUnavailable
+		case report.Status == HealthOK:
+			report.Status = HealthDegraded
+		}
+	}
+	h.report = &report
+	return report
+}
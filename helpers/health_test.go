@@ -0,0 +1,71 @@
+package helpers_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestHealthChecker(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	requested := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(requests)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path != "/v2/info" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+	smtp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer smtp.Close()
+	host, port, _ := net.SplitHostPort(smtp.Addr().String())
+
+	settings := &helpers.Settings{ConsoleAPI: server.URL, UaaURL: server.URL + "/uaa", SMTPHost: host, SMTPPort: port}
+	checker := helpers.NewHealthChecker(settings, server.Client())
+	report := checker.Check(context.Background())
+	if report.Status != helpers.HealthUnavailable {
+		t.Errorf("Expected the dashboard to be unavailable without UAA. Found %+v", report)
+	}
+	if uaa := report.Dependencies["uaa"]; uaa.Status != helpers.HealthUnavailable || !uaa.Critical || uaa.Error == "" {
+		t.Errorf("Expected UAA to be down. Found %+v", uaa)
+	}
+	if report.Dependencies["cf_api"].Status != helpers.HealthOK || report.Dependencies["smtp"].Status != helpers.HealthOK {
+		t.Errorf("Expected the CF API and SMTP to be up. Found %+v", report.Dependencies)
+	}
+
+	// The report is reused within the TTL.
+	checker.Check(context.Background())
+	if requested() != 2 {
+		t.Errorf("Expected the report to be reused. Found requests %v", requests)
+	}
+	checker.TTL = 0
+	checker.Check(context.Background())
+	if requested() != 4 {
+		t.Errorf("Expected the dependencies to be checked again. Found requests %v", requests)
+	}
+
+	smtp.Close()
+	checker.Checks = checker.Checks[len(checker.Checks)-1:]
+	checker.Timeout = time.Second
+	if report := checker.Check(context.Background()); report.Status != helpers.HealthDegraded {
+		t.Errorf("Expected the dashboard to be degraded without SMTP. Found %+v", report)
+	}
+}
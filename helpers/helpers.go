@@ -2,13 +2,33 @@ package helpers
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/gorilla/context"
+	"github.com/gorilla/sessions"
 	"golang.org/x/oauth2"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var (
+	tokenLog    = logging.New("tokens")
+	auditLog    = logging.New("audit")
+	securityLog = logging.New("security")
+	dbLog       = logging.New("db")
 )
 
+func init() {
+	// The db package can't import the loggers, so it's given one.
+	db.Logf = dbLog.Warnf
+}
+
 // TimeoutConstant is a constant which holds how long any incoming request should wait until we timeout.
 // This is useful as some calls from the Go backend to the external API may take a long time.
 // If the user decides to refresh or if the client is polling, multiple requests might build up. This timecaps them.
@@ -16,6 +36,18 @@ var TimeoutConstant = time.Second * 20
 
 // GetValidToken is a helper function that returns a token struct only if it finds a non expired token for the session.
 func GetValidToken(req *http.Request, rw http.ResponseWriter, settings *Settings) *oauth2.Token {
+	if settings.SyntheticUsers {
+		if name := req.Header.Get(SyntheticUserHeader); name != "" {
+			token := SyntheticToken(name)
+			return &token
+		}
+	}
+
+	// Signed download URLs carry their own token.
+	if token := settings.Downloads.Token(req); token != nil {
+		return token
+	}
+
 	// Get session from session store.
 	session, _ := settings.Sessions.Get(req, "session")
 	// If for some reason we can't get or create a session, bail out.
@@ -29,28 +61,167 @@ func GetValidToken(req *http.Request, rw http.ResponseWriter, settings *Settings
 		return nil
 	}
 
+	now := time.Now()
+	if settings.SessionTimeouts.Expired(session, now) {
+		LogSecurityEvent(req, "session timed out")
+		ClearSession(req, rw, session)
+		return nil
+	}
+	touched := isSessionActivity(req) && settings.SessionTimeouts.Touch(session, now)
+
 	// Save our original refresh token, we might need it further down
 	originalRefreshToken := token.RefreshToken
 
 	// Will ensure not expired
+	refreshing := !token.Valid()
 	rv, err := settings.OAuthConfig.TokenSource(settings.CreateContext(), &token).Token()
+	if refreshing {
+		RecordOAuthTokenRequest(OAuthRefreshToken, err)
+	}
 	if err != nil {
+		if isInvalidGrant(err) {
+			// The refresh token has been revoked (e.g. the user changed their
+			// password). Destroy the session so the user is sent to login once
+			// instead of retrying the refresh on every request.
+			LogSecurityEvent(req, "refresh token rejected by UAA, session destroyed")
+			ClearSession(req, rw, session)
+		}
 		return nil
 	}
 
+	// Opaque tokens can't be checked locally, so ask UAA (cached) whether
+	// they are still active.
+	if settings.TokenIntrospector != nil {
+		active, err := settings.TokenIntrospector.IsActive(rv.AccessToken)
+		if err != nil {
+			tokenLog.Errorf("unable to introspect token: %v", err)
+			return nil
+		}
+		if !active {
+			return nil
+		}
+	}
+
 	// Did it change? if so save it in our cookie so we don't have to refresh again on every request
 	if rv.AccessToken != token.AccessToken || !rv.Expiry.Equal(token.Expiry) {
 		// We are using opaque UAA tokens, so make sure we replace any new refresh
 		// token received with the smaller opaque one that we saved off earlier, or we
-		// may hit session storage limits.
-		rv.RefreshToken = originalRefreshToken
+		// may hit session storage limits. Server-side sessions have no such limits.
+		if !settings.ServerSideSessions() {
+			rv.RefreshToken = originalRefreshToken
+		}
 		session.Values["token"] = *rv
+		touched = true
+	}
+	if touched {
 		session.Save(req, rw)
 	}
 
 	return rv
 }
 
+// SessionToken returns the token of the request's session as it's stored
+// now, or nil if the session ended. Unlike GetValidToken, it neither renews
+// the token nor saves the session, for connections that outlive their
+// request, e.g. websockets, and can't set cookies anymore.
+func SessionToken(req *http.Request, settings *Settings) *oauth2.Token {
+	// A copy of the request reads the session anew, instead of the one
+	// cached for the request when it started.
+	req = req.WithContext(req.Context())
+	defer context.Clear(req)
+	session, _ := settings.Sessions.Get(req, "session")
+	if session == nil {
+		return nil
+	}
+	token, ok := session.Values["token"].(oauth2.Token)
+	if !ok || settings.SessionTimeouts.Expired(session, time.Now()) {
+		return nil
+	}
+	if settings.TokenIntrospector != nil {
+		if active, err := settings.TokenIntrospector.IsActive(token.AccessToken); err != nil || !active {
+			return nil
+		}
+	}
+	return &token
+}
+
+// isInvalidGrant returns true if the error came from UAA rejecting the
+// refresh token. The error body is checked rather than the error type since
+// it is only available in the message for older versions of oauth2.
+func isInvalidGrant(err error) bool {
+	return strings.Contains(err.Error(), "invalid_grant")
+}
+
+// ClearSession removes the token from the session and forces the session
+// cookie to expire.
+func ClearSession(req *http.Request, rw http.ResponseWriter, session *sessions.Session) error {
+	delete(session.Values, "token")
+	session.Options.MaxAge = -1
+	return session.Save(req, rw)
+}
+
+// LogSecurityEvent logs an event that is relevant for auditing the security
+// of user sessions. Like audit events, it's logged whatever the log levels.
+func LogSecurityEvent(req *http.Request, event string) {
+	securityLog.With("remote_addr", req.RemoteAddr, "path", req.URL.Path).Eventf("security event: %s", event)
+}
+
+// LogAuditEvent records an action taken by a user on behalf of others, such
+// as a broadcast email, as a single JSON log line. req is nil for the actions
+// the dashboard takes in the background.
+func LogAuditEvent(req *http.Request, actor, action string, details interface{}) {
+	var remoteAddr string
+	if req != nil {
+		remoteAddr = req.RemoteAddr
+	}
+	record, err := json.Marshal(struct {
+		Time       time.Time   `json:"time"`
+		Actor      string      `json:"actor"`
+		Action     string      `json:"action"`
+		RemoteAddr string      `json:"remote_addr"`
+		Details    interface{} `json:"details"`
+	}{
+		Time:       time.Now().UTC(),
+		Actor:      actor,
+		Action:     action,
+		RemoteAddr: remoteAddr,
+		Details:    details,
+	})
+	if err != nil {
+		auditLog.Eventf("audit event: %s by %s (unable to marshal details: %v)", action, actor, err)
+		return
+	}
+	auditLog.Eventf("audit event: %s", record)
+}
+
+// RecordAuditEvent logs the event like LogAuditEvent and keeps it in the
+// audit store, so the actor can review their own activity.
+func (s *Settings) RecordAuditEvent(req *http.Request, actor, action string, details interface{}) {
+	LogAuditEvent(req, actor, action, details)
+	if s.Audit == nil || actor == "" {
+		return
+	}
+	event := db.AuditEvent{
+		Time:   time.Now().UTC(),
+		Actor:  actor,
+		Action: action,
+	}
+	if req != nil {
+		event.RemoteAddr = req.RemoteAddr
+	}
+	if details != nil {
+		raw, err := json.Marshal(details)
+		if err != nil {
+			auditLog.Errorf("unable to store audit event %s by %s: %v", action, actor, err)
+			return
+		}
+		event.Details = raw
+	}
+	if err := s.Audit.RecordEvent(event); err != nil {
+		auditLog.Errorf("unable to store audit event %s by %s: %v", action, actor, err)
+	}
+}
+
 // GenerateRandomBytes returns securely generated random bytes.
 // Borrowed from https://elithrar.github.io/article/generating-secure-random-numbers-crypto-rand/
 func GenerateRandomBytes(n int) ([]byte, error) {
@@ -71,3 +242,16 @@ func GenerateRandomString(s int) (string, error) {
 	b, err := GenerateRandomBytes(s)
 	return base64.URLEncoding.EncodeToString(b), err
 }
+
+// NewPKCEVerifier returns a random PKCE code verifier: 43 characters, which
+// is the shortest verifier RFC 7636 allows, with 256 bits of entropy.
+func NewPKCEVerifier() (string, error) {
+	b, err := GenerateRandomBytes(32)
+	return base64.RawURLEncoding.EncodeToString(b), err
+}
+
+// PKCEChallenge returns the S256 code challenge of the PKCE code verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -1,12 +1,17 @@
 package helpers_test
 
 import (
+	"encoding/gob"
 	"net/http/httptest"
+	"time"
 
 	"github.com/18F/cg-dashboard/helpers"
 	"github.com/18F/cg-dashboard/helpers/testhelpers"
+	"github.com/gorilla/sessions"
+	"golang.org/x/oauth2"
 
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -58,3 +63,78 @@ func TestGetValidToken(t *testing.T) {
 		}
 	}
 }
+
+func TestGetValidTokenInvalidGrant(t *testing.T) {
+	// UAA rejects the refresh token, e.g. after a password change.
+	uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"invalid_grant","error_description":"Invalid refresh token"}`))
+	}))
+	defer uaa.Close()
+
+	mockRequest, _ := http.NewRequest("GET", "/v2/info", nil)
+	mockResponse := httptest.NewRecorder()
+	mockSettings := helpers.Settings{
+		OAuthConfig: &oauth2.Config{
+			Endpoint: oauth2.Endpoint{TokenURL: uaa.URL + "/oauth/token"},
+		},
+	}
+	store := testhelpers.MockSessionStore{}
+	store.ResetSessionData(map[string]interface{}{
+		"token": oauth2.Token{
+			AccessToken:  "expired",
+			RefreshToken: "revoked",
+			Expiry:       time.Now().Add(-1 * time.Minute),
+		},
+	}, "")
+	mockSettings.Sessions = store
+
+	if value := helpers.GetValidToken(mockRequest, mockResponse, &mockSettings); value != nil {
+		t.Errorf("Expected nil token. Found %v", value)
+	}
+	if _, ok := store.Session.Values["token"]; ok {
+		t.Error("Expected token to be removed from the session")
+	}
+	if store.Session.Options.MaxAge != -1 {
+		t.Errorf("Expected session MaxAge -1. Found %d", store.Session.Options.MaxAge)
+	}
+}
+
+func TestSessionToken(t *testing.T) {
+	gob.Register(oauth2.Token{})
+	settings := &helpers.Settings{Sessions: sessions.NewCookieStore(testSessionAuthKey, testSessionEncKey)}
+	login, _ := http.NewRequest("GET", "/", nil)
+	session, _ := settings.Sessions.Get(login, "session")
+	session.Values["token"] = oauth2.Token{AccessToken: "stored", Expiry: time.Now().Add(time.Hour)}
+	saved := httptest.NewRecorder()
+	session.Save(login, saved)
+
+	// The session is read anew, not the one cached for the request.
+	req, _ := http.NewRequest("GET", "/v2/apps/app-1/logstream", nil)
+	req.Header.Set("Cookie", saved.Header().Get("Set-Cookie"))
+	cached, _ := settings.Sessions.Get(req, "session")
+	delete(cached.Values, "token")
+	if token := helpers.SessionToken(req, settings); token == nil || token.AccessToken != "stored" {
+		t.Errorf("Expected the stored token. Found %v", token)
+	}
+
+	req, _ = http.NewRequest("GET", "/v2/apps/app-1/logstream", nil)
+	if token := helpers.SessionToken(req, settings); token != nil {
+		t.Errorf("Expected no token without a session. Found %v", token)
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	verifier, err := helpers.NewPKCEVerifier()
+	if err != nil || len(verifier) != 43 || strings.ContainsAny(verifier, "+/=") {
+		t.Errorf("Expected a 43 character URL-safe verifier. Found %q, %v", verifier, err)
+	}
+	if other, _ := helpers.NewPKCEVerifier(); other == verifier {
+		t.Error("Expected a new verifier each time")
+	}
+	challenge := helpers.PKCEChallenge("dBjftJeZ4CVP-mJ0kgGZ6HJ4IGu5AI6u3Vod2YfDmJ0")
+	if challenge != "H0EksPrTx32BQMO6zeuFFVzN5sEKgYNk6V8yB7KVS54" {
+		t.Errorf("Unexpected S256 challenge %s", challenge)
+	}
+}
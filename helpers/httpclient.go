@@ -0,0 +1,46 @@
+package helpers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// newUpstreamTransport creates the transport the CF API and UAA are reached
+// with. It trusts the CAs in the PEM bundle at caBundlePath, if any, besides
+// the system's, or doesn't verify certificates at all when insecure, e.g. for
+// a local CF whose certificates are self-signed.
+func newUpstreamTransport(caBundlePath string, insecure bool) (*http.Transport, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if caBundlePath != "" {
+		pem, err := ioutil.ReadFile(caBundlePath)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + caBundlePath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	// The same settings as http.DefaultTransport, which can't be copied.
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}, nil
+}
@@ -0,0 +1,94 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/govau/cf-common/env"
+)
+
+const (
+	// DefaultReadHeaderTimeout is how long clients have to send the headers
+	// of a request unless configured otherwise. Slow clients otherwise hold
+	// their connections open for as long as they like.
+	DefaultReadHeaderTimeout = 10 * time.Second
+	// DefaultReadTimeout is how long clients have to send a whole request
+	// unless configured otherwise.
+	DefaultReadTimeout = 30 * time.Second
+	// DefaultIdleTimeout is how long idle keep-alive connections are held
+	// open unless configured otherwise.
+	DefaultIdleTimeout = 2 * time.Minute
+	// writeTimeoutMargin is how much longer than the longest request timeout
+	// the write timeout is by default, so the timeout responses get written.
+	writeTimeoutMargin = 10 * time.Second
+)
+
+// ServerLimits are the timeouts and header size limit of the dashboard's
+// HTTP servers.
+type ServerLimits struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+}
+
+// Server returns an HTTP server of the handler with the limits.
+func (l *ServerLimits) Server(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: l.ReadHeaderTimeout,
+		ReadTimeout:       l.ReadTimeout,
+		WriteTimeout:      l.WriteTimeout,
+		IdleTimeout:       l.IdleTimeout,
+		MaxHeaderBytes:    l.MaxHeaderBytes,
+	}
+}
+
+// parseServerLimits reads the limits of the HTTP servers. It must be called
+// after the request timeouts are parsed, since the write timeout defaults to
+// a little longer than the longest of them.
+func parseServerLimits(envVars *env.VarSet, s *Settings) (*ServerLimits, error) {
+	l := &ServerLimits{
+		ReadHeaderTimeout: DefaultReadHeaderTimeout,
+		ReadTimeout:       DefaultReadTimeout,
+		WriteTimeout:      s.RequestTimeout,
+		IdleTimeout:       DefaultIdleTimeout,
+		MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+	}
+	for _, timeout := range s.RouteTimeouts {
+		if timeout > l.WriteTimeout {
+			l.WriteTimeout = timeout
+		}
+	}
+	l.WriteTimeout += writeTimeoutMargin
+	for name, timeout := range map[string]*time.Duration{
+		ServerReadHeaderTimeoutEnvVar: &l.ReadHeaderTimeout,
+		ServerReadTimeoutEnvVar:       &l.ReadTimeout,
+		ServerWriteTimeoutEnvVar:      &l.WriteTimeout,
+		ServerIdleTimeoutEnvVar:       &l.IdleTimeout,
+	} {
+		if value := envVars.String(name, ""); value != "" {
+			var err error
+			if *timeout, err = time.ParseDuration(value); err == nil && *timeout <= 0 {
+				err = errors.New("must be positive")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not parse env var %q: %v", name, err)
+			}
+		}
+	}
+	if value := envVars.String(ServerMaxHeaderBytesEnvVar, ""); value != "" {
+		var err error
+		if l.MaxHeaderBytes, err = strconv.Atoi(value); err == nil && l.MaxHeaderBytes <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", ServerMaxHeaderBytesEnvVar, err)
+		}
+	}
+	return l, nil
+}
@@ -0,0 +1,159 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultIntrospectTTL is how long an active token is trusted before
+	// asking UAA again.
+	defaultIntrospectTTL = 30 * time.Second
+	// defaultIntrospectNegativeTTL is how long an inactive token is remembered
+	// so that repeated requests with it don't hit UAA.
+	defaultIntrospectNegativeTTL = 10 * time.Second
+	// maxIntrospectCacheEntries bounds the size of the cache.
+	maxIntrospectCacheEntries = 10000
+	// introspectEntrySize is roughly the memory used by a cached result and
+	// its key.
+	introspectEntrySize = 128
+)
+
+// introspectResponse is the subset of the UAA /introspect response we use.
+// https://docs.cloudfoundry.org/api/uaa/#introspect-token
+type introspectResponse struct {
+	Active bool     `json:"active"`
+	Exp    int64    `json:"exp"`
+	Scope  []string `json:"scope"`
+	UserID string   `json:"user_id"`
+}
+
+// TokenIntrospector validates opaque access tokens with the UAA /introspect
+// endpoint. Results, including negative ones, are cached for a short time so
+// the auth middleware doesn't call UAA on every request.
+type TokenIntrospector struct {
+	// URL is the full URL of the UAA introspect endpoint.
+	URL          string
+	ClientID     string
+	ClientSecret string
+	// TTL is the maximum time an active result is cached.
+	TTL time.Duration
+	// NegativeTTL is the time an inactive result is cached.
+	NegativeTTL time.Duration
+	Client      *http.Client
+
+	cache *Cache
+	now   func() time.Time
+}
+
+// NewTokenIntrospector creates a TokenIntrospector for the given UAA with
+// the default cache TTLs.
+func NewTokenIntrospector(uaaURL, clientID, clientSecret string, client *http.Client) *TokenIntrospector {
+	return &TokenIntrospector{
+		URL:          strings.TrimRight(uaaURL, "/") + "/introspect",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TTL:          defaultIntrospectTTL,
+		NegativeTTL:  defaultIntrospectNegativeTTL,
+		Client:       client,
+		cache:        NewCache("introspect", maxIntrospectCacheEntries*introspectEntrySize),
+		now:          time.Now,
+	}
+}
+
+// IsActive returns whether UAA considers the access token active. Errors
+// talking to UAA are returned and never cached.
+func (t *TokenIntrospector) IsActive(accessToken string) (bool, error) {
+	resp, err := t.lookup(accessToken)
+	if err != nil {
+		return false, err
+	}
+	return resp.Active, nil
+}
+
+// Scopes returns the scopes of the access token, or none if it isn't
+// active. They're cached with the token's activity.
+func (t *TokenIntrospector) Scopes(accessToken string) ([]string, error) {
+	resp, err := t.lookup(accessToken)
+	if err != nil || !resp.Active {
+		return nil, err
+	}
+	return resp.Scope, nil
+}
+
+// UserID returns the UAA user ID of the access token, or none if it isn't
+// active.
+func (t *TokenIntrospector) UserID(accessToken string) (string, error) {
+	resp, err := t.lookup(accessToken)
+	if err != nil || !resp.Active {
+		return "", err
+	}
+	return resp.UserID, nil
+}
+
+// lookup returns the cached introspection of the access token, or asks UAA.
+func (t *TokenIntrospector) lookup(accessToken string) (*introspectResponse, error) {
+	// Never keep the raw tokens around in memory.
+	sum := sha256.Sum256([]byte(accessToken))
+	key := hex.EncodeToString(sum[:])
+
+	value, err := t.cache.Get(key, func() (CacheItem, error) {
+		resp, err := t.introspect(accessToken)
+		if err != nil {
+			return CacheItem{}, err
+		}
+		item := CacheItem{Value: resp, Size: introspectEntrySize + int64(len(resp.UserID)), TTL: t.NegativeTTL}
+		for _, scope := range resp.Scope {
+			item.Size += int64(len(scope))
+		}
+		if resp.Active {
+			item.TTL = t.TTL
+			// Don't trust the token past its own expiry.
+			if resp.Exp > 0 {
+				if untilExp := time.Unix(resp.Exp, 0).Sub(t.now()); untilExp < item.TTL {
+					item.TTL = untilExp
+				}
+			}
+		}
+		return item, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*introspectResponse), nil
+}
+
+func (t *TokenIntrospector) introspect(accessToken string) (*introspectResponse, error) {
+	req, err := http.NewRequest("POST", t.URL,
+		strings.NewReader(url.Values{"token": {accessToken}}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(t.ClientID, t.ClientSecret)
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from token introspection: %d", res.StatusCode)
+	}
+	var resp introspectResponse
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,121 @@
+package helpers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+type introspectTest struct {
+	testName      string
+	active        bool
+	ttl           time.Duration
+	negativeTTL   time.Duration
+	expectedCalls int
+}
+
+var introspectTests = []introspectTest{
+	{
+		testName:      "Active Token Is Cached",
+		active:        true,
+		ttl:           time.Minute,
+		expectedCalls: 1,
+	},
+	{
+		testName:      "Inactive Token Is Cached",
+		active:        false,
+		negativeTTL:   time.Minute,
+		expectedCalls: 1,
+	},
+	{
+		testName:      "Expired Cache Entry Is Refreshed",
+		active:        true,
+		expectedCalls: 2,
+	},
+}
+
+func TestTokenIntrospector(t *testing.T) {
+	for _, test := range introspectTests {
+		calls := 0
+		uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if r.URL.Path != "/introspect" {
+				t.Errorf("Test %s: unexpected path %s", test.testName, r.URL.Path)
+			}
+			if user, pass, ok := r.BasicAuth(); !ok || user != "ID" || pass != "Secret" {
+				t.Errorf("Test %s: expected client basic auth", test.testName)
+			}
+			if r.FormValue("token") != "opaque-token" {
+				t.Errorf("Test %s: unexpected token %s", test.testName, r.FormValue("token"))
+			}
+			if test.active {
+				w.Write([]byte(`{"active": true}`))
+			} else {
+				w.Write([]byte(`{"active": false}`))
+			}
+		}))
+
+		introspector := helpers.NewTokenIntrospector(uaa.URL, "ID", "Secret", nil)
+		introspector.TTL = test.ttl
+		introspector.NegativeTTL = test.negativeTTL
+		for i := 0; i < 2; i++ {
+			active, err := introspector.IsActive("opaque-token")
+			if err != nil {
+				t.Errorf("Test %s: expected nil error, found %s", test.testName, err.Error())
+			}
+			if active != test.active {
+				t.Errorf("Test %s: expected active %t, found %t", test.testName, test.active, active)
+			}
+		}
+		if calls != test.expectedCalls {
+			t.Errorf("Test %s: expected %d calls to UAA, found %d", test.testName, test.expectedCalls, calls)
+		}
+		uaa.Close()
+	}
+}
+
+func TestTokenIntrospectorError(t *testing.T) {
+	uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer uaa.Close()
+
+	introspector := helpers.NewTokenIntrospector(uaa.URL, "ID", "Secret", nil)
+	if _, err := introspector.IsActive("opaque-token"); err == nil {
+		t.Error("Expected non nil error")
+	}
+}
+
+func TestTokenIntrospectorScopes(t *testing.T) {
+	calls := 0
+	uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.FormValue("token") == "revoked-token" {
+			w.Write([]byte(`{"active": false, "scope": ["openid"]}`))
+			return
+		}
+		w.Write([]byte(`{"active": true, "scope": ["openid", "cloud_controller.admin"], "user_id": "user-1"}`))
+	}))
+	defer uaa.Close()
+
+	introspector := helpers.NewTokenIntrospector(uaa.URL, "ID", "Secret", nil)
+	if active, _ := introspector.IsActive("opaque-token"); !active {
+		t.Fatal("Expected the token to be active")
+	}
+	scopes, err := introspector.Scopes("opaque-token")
+	if err != nil || len(scopes) != 2 || scopes[1] != "cloud_controller.admin" {
+		t.Errorf("Expected the token's scopes. Found %v, %v", scopes, err)
+	}
+	if userID, err := introspector.UserID("opaque-token"); err != nil || userID != "user-1" {
+		t.Errorf("Expected the token's user ID. Found %q, %v", userID, err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the scopes to be cached with the activity. Found %d calls", calls)
+	}
+	if scopes, _ := introspector.Scopes("revoked-token"); len(scopes) != 0 {
+		t.Errorf("Expected no scopes for an inactive token. Found %v", scopes)
+	}
+}
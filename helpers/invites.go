@@ -0,0 +1,178 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/govau/cf-common/env"
+)
+
+const (
+	// DefaultBulkInviteMax is the most addresses invited at once unless
+	// configured otherwise.
+	DefaultBulkInviteMax = 100
+	// DefaultInviteRate is how many invites are sent per second, by all the
+	// bulk invites of the instance, unless configured otherwise.
+	DefaultInviteRate = 5
+)
+
+// InvitePolicy is who can be invited, and how fast.
+type InvitePolicy struct {
+	// AllowedDomains are the email domains users can be invited from,
+	// including their subdomains. Any domain is allowed when empty.
+	AllowedDomains []string
+	// MaxBulk is the most addresses invited at once.
+	MaxBulk int
+	// Interval is the least time between two invites of bulk invites.
+	Interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// CheckAddress returns the normalized address, or an error if it isn't a
+// valid address from an allowed domain.
+func (p *InvitePolicy) CheckAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(strings.TrimSpace(address))
+	if err != nil || parsed.Name != "" {
+		return "", errors.New("not a valid email address")
+	}
+	email := strings.ToLower(parsed.Address)
+	if len(p.AllowedDomains) == 0 {
+		return email, nil
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	for _, allowed := range p.AllowedDomains {
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return email, nil
+		}
+	}
+	return "", fmt.Errorf("users from %s can't be invited", domain)
+}
+
+// Wait blocks until the next invite can be sent, pacing the invites of all
+// the bulk invites.
+func (p *InvitePolicy) Wait() {
+	if p.Interval <= 0 {
+		return
+	}
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	wait := p.next.Sub(now)
+	p.next = p.next.Add(p.Interval)
+	p.mu.Unlock()
+	time.Sleep(wait)
+}
+
+// ParseInviteAddresses reads the addresses of a bulk invite: a JSON array of
+// addresses or an object with them in "emails", or a CSV with them in the
+// "email" column, or the first one when there's no header. Duplicates and
+// blank lines are dropped.
+func ParseInviteAddresses(contentType string, body []byte) ([]string, error) {
+	var addresses []string
+	if strings.HasPrefix(contentType, "text/csv") {
+		r := csv.NewReader(bytes.NewReader(body))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		column := 0
+		for line := 0; ; line++ {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid CSV: %v", err)
+			}
+			if line == 0 {
+				if i := indexOf(record, "email"); i >= 0 {
+					column = i
+					continue
+				}
+			}
+			if column < len(record) {
+				addresses = append(addresses, record[column])
+			}
+		}
+	} else {
+		trimmed := bytes.TrimSpace(body)
+		var err error
+		if len(trimmed) > 0 && trimmed[0] == '{' {
+			var wrapped struct {
+				Emails []string `json:"emails"`
+			}
+			err = json.Unmarshal(trimmed, &wrapped)
+			addresses = wrapped.Emails
+		} else {
+			err = json.Unmarshal(trimmed, &addresses)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+	}
+	seen := make(map[string]bool, len(addresses))
+	unique := addresses[:0]
+	for _, address := range addresses {
+		key := strings.ToLower(strings.TrimSpace(address))
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, strings.TrimSpace(address))
+	}
+	return unique, nil
+}
+
+func indexOf(record []string, column string) int {
+	for i, name := range record {
+		if strings.EqualFold(strings.TrimSpace(name), column) {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseInvitePolicy reads the allowed domains and the limits of the bulk
+// invites.
+func parseInvitePolicy(envVars *env.VarSet) (*InvitePolicy, error) {
+	p := &InvitePolicy{MaxBulk: DefaultBulkInviteMax, Interval: time.Second / DefaultInviteRate}
+	for _, domain := range strings.Split(envVars.String(InviteAllowedDomainsEnvVar, ""), ",") {
+		if domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@")); domain != "" {
+			p.AllowedDomains = append(p.AllowedDomains, domain)
+		}
+	}
+	if value := envVars.String(BulkInviteMaxEnvVar, ""); value != "" {
+		var err error
+		if p.MaxBulk, err = strconv.Atoi(value); err == nil && p.MaxBulk <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", BulkInviteMaxEnvVar, err)
+		}
+	}
+	if value := envVars.String(InviteRateEnvVar, ""); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err == nil && rate < 0 {
+			err = errors.New("must not be negative")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", InviteRateEnvVar, err)
+		}
+		p.Interval = 0
+		if rate > 0 {
+			p.Interval = time.Duration(float64(time.Second) / rate)
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,47 @@
+package helpers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInvitePolicyCheckAddress(t *testing.T) {
+	policy := &InvitePolicy{AllowedDomains: []string{"example.gov"}}
+	for address, expected := range map[string]string{
+		"Jane@Example.gov":        "jane@example.gov",
+		" joe@agency.example.gov": "joe@agency.example.gov",
+		"joe@notexample.gov":      "",
+		"joe@example.com":         "",
+		"not an address":          "",
+		"Joe <joe@example.gov>":   "",
+	} {
+		email, err := policy.CheckAddress(address)
+		if email != expected || (expected == "") != (err != nil) {
+			t.Errorf("Expected %q to be checked as %q. Found %q, %v", address, expected, email, err)
+		}
+	}
+	if _, err := (&InvitePolicy{}).CheckAddress("joe@example.com"); err != nil {
+		t.Errorf("Expected any domain to be allowed without a list. Found %v", err)
+	}
+}
+
+func TestParseInviteAddresses(t *testing.T) {
+	for _, tt := range []struct {
+		contentType string
+		body        string
+		expected    []string
+	}{
+		{"application/json", `["a@example.gov", "b@example.gov", "A@example.gov"]`, []string{"a@example.gov", "b@example.gov"}},
+		{"application/json", `{"emails": ["a@example.gov", " "]}`, []string{"a@example.gov"}},
+		{"text/csv", "name,email\nA,a@example.gov\nB,b@example.gov\n", []string{"a@example.gov", "b@example.gov"}},
+		{"text/csv; charset=utf-8", "a@example.gov\n\nb@example.gov,extra\n", []string{"a@example.gov", "b@example.gov"}},
+	} {
+		addresses, err := ParseInviteAddresses(tt.contentType, []byte(tt.body))
+		if err != nil || !reflect.DeepEqual(addresses, tt.expected) {
+			t.Errorf("Expected %v from %q. Found %v, %v", tt.expected, tt.body, addresses, err)
+		}
+	}
+	if _, err := ParseInviteAddresses("application/json", []byte(`"a@example.gov"`)); err == nil {
+		t.Error("Expected a JSON string to be refused")
+	}
+}
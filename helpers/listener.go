@@ -0,0 +1,157 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/govau/cf-common/env"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultMaxConnections is how many connections the dashboard holds open at
+// once unless configured otherwise.
+const DefaultMaxConnections = 4096
+
+var (
+	openConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dashboard_open_connections",
+		Help: "Client connections currently open.",
+	})
+	connectedIPs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dashboard_connected_ips",
+		Help: "Client IPs with at least one connection currently open.",
+	})
+	rejectedConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_rejected_connections_total",
+		Help: "Client connections closed on accept, by the limit they were over: total or per_ip.",
+	}, []string{"limit"})
+)
+
+func init() {
+	prometheus.MustRegister(openConnections, connectedIPs, rejectedConnections)
+}
+
+// ConnLimiter caps the connections the dashboard holds open, in all and per
+// client IP, closing the connections over a cap as soon as they're accepted.
+// It's shared by all the listeners of the process.
+type ConnLimiter struct {
+	// MaxConns caps the open connections in all, and MaxConnsPerIP those
+	// from a client IP. 0 doesn't cap them.
+	MaxConns      int
+	MaxConnsPerIP int
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+// Listen listens on the TCP port on all the interfaces, both IPv6 and IPv4,
+// or only IPv4 where the host has no IPv6, and caps the accepted connections.
+// It returns the network it listens on.
+func (l *ConnLimiter) Listen(port string) (net.Listener, string, error) {
+	// Go listens dual-stack on the IPv6 wildcard address, accepting IPv4
+	// connections as mapped addresses.
+	network := "tcp"
+	ln, err := net.Listen(network, net.JoinHostPort("::", port))
+	if err != nil {
+		network = "tcp4"
+		if ln, err = net.Listen(network, net.JoinHostPort("0.0.0.0", port)); err != nil {
+			return nil, "", err
+		}
+	}
+	return &limitListener{Listener: ln, limiter: l}, network, nil
+}
+
+// acquire opens a slot for a connection from the IP, or returns the limit it
+// is over.
+func (l *ConnLimiter) acquire(ip string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.MaxConns > 0 && l.total >= l.MaxConns {
+		return "total", false
+	}
+	if l.MaxConnsPerIP > 0 && l.perIP[ip] >= l.MaxConnsPerIP {
+		return "per_ip", false
+	}
+	if l.perIP == nil {
+		l.perIP = make(map[string]int)
+	}
+	l.total++
+	l.perIP[ip]++
+	openConnections.Set(float64(l.total))
+	connectedIPs.Set(float64(len(l.perIP)))
+	return "", true
+}
+
+func (l *ConnLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+	openConnections.Set(float64(l.total))
+	connectedIPs.Set(float64(len(l.perIP)))
+}
+
+// limitListener closes the accepted connections over the limits of its
+// ConnLimiter.
+type limitListener struct {
+	net.Listener
+	limiter *ConnLimiter
+}
+
+func (ln *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := conn.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		if limit, ok := ln.limiter.acquire(ip); !ok {
+			rejectedConnections.WithLabelValues(limit).Inc()
+			conn.Close()
+			continue
+		}
+		return &limitedConn{Conn: conn, release: func() { ln.limiter.release(ip) }}, nil
+	}
+}
+
+// limitedConn releases its slot once closed.
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// parseConnLimiter reads the caps of the client connections.
+func parseConnLimiter(envVars *env.VarSet) (*ConnLimiter, error) {
+	l := &ConnLimiter{MaxConns: DefaultMaxConnections}
+	for name, max := range map[string]*int{
+		MaxConnectionsEnvVar:      &l.MaxConns,
+		MaxConnectionsPerIPEnvVar: &l.MaxConnsPerIP,
+	} {
+		if value := envVars.String(name, ""); value != "" {
+			var err error
+			if *max, err = strconv.Atoi(value); err == nil && *max < 0 {
+				err = errors.New("must not be negative")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not parse env var %q: %v", name, err)
+			}
+		}
+	}
+	return l, nil
+}
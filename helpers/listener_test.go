@@ -0,0 +1,63 @@
+package helpers
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestConnLimiter(t *testing.T) {
+	limiter := &ConnLimiter{MaxConns: 2, MaxConnsPerIP: 1}
+	ln, _, err := limiter.Listen("0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 3)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	dial := func(ip string) net.Conn {
+		conn, err := net.Dial("tcp", net.JoinHostPort(ip, strconv.Itoa(addr.Port)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	first := dial("127.0.0.1")
+	defer first.Close()
+	conn := <-accepted
+
+	// The second connection from the same IP is closed on accept.
+	second := dial("127.0.0.1")
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := second.Read(make([]byte, 1)); err == nil {
+		t.Error("expected the connection over the per-IP cap to be closed")
+	}
+	select {
+	case <-accepted:
+		t.Error("expected the connection over the per-IP cap not to be accepted")
+	default:
+	}
+
+	// Closing the first connection releases its slot.
+	conn.Close()
+	third := dial("127.0.0.1")
+	defer third.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(time.Second):
+		t.Error("expected a connection once the first one closed")
+	}
+}
@@ -0,0 +1,328 @@
+// Package logging logs the lines of the dashboard's modules with zap, at
+// levels that can be changed per module while the dashboard runs.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Level is how important a log line is.
+type Level int
+
+// The log levels, from the most verbose.
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+	// eventLevel is the level of audit and security events, which are logged
+	// whatever the levels.
+	eventLevel
+)
+
+var levelNames = map[Level]string{
+	DebugLevel: "debug",
+	InfoLevel:  "info",
+	WarnLevel:  "warn",
+	ErrorLevel: "error",
+}
+
+func (l Level) String() string {
+	if l == eventLevel {
+		return "event"
+	}
+	return levelNames[l]
+}
+
+// zapEventLevel is the zap level of the events. zap has none above fatal, so
+// the events get the next one, which only the level encoder knows.
+const zapEventLevel = zapcore.FatalLevel + 1
+
+func (l Level) zap() zapcore.Level {
+	switch l {
+	case DebugLevel:
+		return zapcore.DebugLevel
+	case InfoLevel:
+		return zapcore.InfoLevel
+	case WarnLevel:
+		return zapcore.WarnLevel
+	case ErrorLevel:
+		return zapcore.ErrorLevel
+	}
+	return zapEventLevel
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	for level, name := range levelNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", s)
+}
+
+// ParseLevels parses a default level followed by comma separated overrides
+// of module levels, e.g. "warn,proxy=debug,login=debug".
+func ParseLevels(spec string) (Level, map[string]Level, error) {
+	level := InfoLevel
+	overrides := map[string]Level{}
+	for _, part := range strings.Split(spec, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		i := strings.Index(part, "=")
+		if i < 0 {
+			var err error
+			if level, err = ParseLevel(part); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+		l, err := ParseLevel(part[i+1:])
+		if err != nil {
+			return 0, nil, fmt.Errorf("module %s: %v", part[:i], err)
+		}
+		overrides[strings.TrimSpace(part[:i])] = l
+	}
+	return level, overrides, nil
+}
+
+// LevelSet is the levels of the modules' loggers: a default level, and
+// overrides for some modules, e.g. to debug the login flow in production
+// without the noise of every other module.
+type LevelSet struct {
+	mu        sync.RWMutex
+	level     Level
+	overrides map[string]Level
+	modules   map[string]bool
+}
+
+// Snapshot is a copy of the LevelSet, and the format of the lines.
+type Snapshot struct {
+	Default string `json:"default"`
+	Format  string `json:"format"`
+	// Modules are the modules' overrides of the default level.
+	Modules map[string]string `json:"modules"`
+	// Known are all the modules logging.
+	Known []string `json:"known"`
+}
+
+// Levels are the levels of all the loggers.
+var Levels = &LevelSet{level: InfoLevel, overrides: map[string]Level{}, modules: map[string]bool{}}
+
+// Set replaces the default level and the overrides. Overrides of modules
+// that don't log are refused, since they're most likely typos.
+func (l *LevelSet) Set(level Level, overrides map[string]Level) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for module := range overrides {
+		if !l.modules[module] {
+			return fmt.Errorf("unknown module %q", module)
+		}
+	}
+	l.level = level
+	l.overrides = make(map[string]Level, len(overrides))
+	for module, level := range overrides {
+		l.overrides[module] = level
+	}
+	return nil
+}
+
+// Parse sets the levels from a spec in the format of ParseLevels.
+func (l *LevelSet) Parse(spec string) error {
+	level, overrides, err := ParseLevels(spec)
+	if err != nil {
+		return err
+	}
+	return l.Set(level, overrides)
+}
+
+// Enabled returns true if the module logs lines of the level.
+func (l *LevelSet) Enabled(module string, level Level) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	min, ok := l.overrides[module]
+	if !ok {
+		min = l.level
+	}
+	return level >= min
+}
+
+// Snapshot returns a copy of the levels.
+func (l *LevelSet) Snapshot() Snapshot {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	s := Snapshot{Default: l.level.String(), Format: Format(), Modules: map[string]string{}, Known: []string{}}
+	for module, level := range l.overrides {
+		s.Modules[module] = level.String()
+	}
+	for module := range l.modules {
+		s.Known = append(s.Known, module)
+	}
+	sort.Strings(s.Known)
+	return s
+}
+
+func (l *LevelSet) register(module string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.modules[module] = true
+}
+
+// The formats of the log lines.
+const (
+	// TextFormat logs the time, level, module and message separated by tabs,
+	// followed by the logger's fields as JSON.
+	TextFormat = "text"
+	// JSONFormat logs a JSON object per line, with time, level, module and
+	// msg, and the logger's fields.
+	JSONFormat = "json"
+)
+
+// output is where and how the lines are written.
+var output = struct {
+	sync.RWMutex
+	format string
+	sink   zapcore.WriteSyncer
+	core   zapcore.Core
+}{format: TextFormat, sink: zapcore.Lock(os.Stderr)}
+
+func init() {
+	output.core = newCore(output.format, output.sink)
+}
+
+// newCore creates the zap core writing the lines in the format. Every level
+// is enabled since the loggers check the module's level themselves.
+func newCore(format string, sink zapcore.WriteSyncer) zapcore.Core {
+	config := zapcore.EncoderConfig{
+		TimeKey:    "time",
+		LevelKey:   "level",
+		NameKey:    "module",
+		MessageKey: "msg",
+		EncodeTime: func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+			enc.AppendString(t.UTC().Format(time.RFC3339Nano))
+		},
+		EncodeLevel: func(level zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+			if level == zapEventLevel {
+				enc.AppendString(eventLevel.String())
+				return
+			}
+			zapcore.LowercaseLevelEncoder(level, enc)
+		},
+		EncodeDuration: zapcore.StringDurationEncoder,
+	}
+	encoder := zapcore.NewConsoleEncoder(config)
+	if format == JSONFormat {
+		encoder = zapcore.NewJSONEncoder(config)
+	}
+	return zapcore.NewCore(encoder, sink, zap.LevelEnablerFunc(func(zapcore.Level) bool { return true }))
+}
+
+// SetFormat sets the format of the log lines, text or json.
+func SetFormat(format string) error {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format != TextFormat && format != JSONFormat {
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	output.Lock()
+	defer output.Unlock()
+	output.format = format
+	output.core = newCore(format, output.sink)
+	return nil
+}
+
+// Format returns the format of the log lines.
+func Format() string {
+	output.RLock()
+	defer output.RUnlock()
+	return output.format
+}
+
+// SetOutput sets where the lines are written, the standard error by default.
+func SetOutput(w io.Writer) {
+	output.Lock()
+	defer output.Unlock()
+	output.sink = zapcore.Lock(zapcore.AddSync(w))
+	output.core = newCore(output.format, output.sink)
+}
+
+func currentCore() zapcore.Core {
+	output.RLock()
+	defer output.RUnlock()
+	return output.core
+}
+
+// Logger logs the lines of a module at or above the module's level in
+// Levels, with its fields.
+type Logger struct {
+	module string
+	// fields are logged with every line.
+	fields []zapcore.Field
+}
+
+// New creates the logger of a module.
+func New(module string) *Logger {
+	Levels.register(module)
+	return &Logger{module: module}
+}
+
+// With returns a logger of the same module that also logs the key and value
+// pairs, e.g. With("request_id", id).
+func (lg *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]zapcore.Field, 0, len(lg.fields)+len(keyvals)/2)
+	fields = append(fields, lg.fields...)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fields = append(fields, zap.Any(fmt.Sprint(keyvals[i]), keyvals[i+1]))
+	}
+	return &Logger{module: lg.module, fields: fields}
+}
+
+// Debugf logs details only useful when investigating a problem.
+func (lg *Logger) Debugf(format string, args ...interface{}) {
+	lg.logf(DebugLevel, format, args...)
+}
+
+// Infof logs what the dashboard is doing.
+func (lg *Logger) Infof(format string, args ...interface{}) {
+	lg.logf(InfoLevel, format, args...)
+}
+
+// Warnf logs problems the dashboard recovers from.
+func (lg *Logger) Warnf(format string, args ...interface{}) {
+	lg.logf(WarnLevel, format, args...)
+}
+
+// Errorf logs failures.
+func (lg *Logger) Errorf(format string, args ...interface{}) {
+	lg.logf(ErrorLevel, format, args...)
+}
+
+// Eventf logs audit and security events, whatever the levels.
+func (lg *Logger) Eventf(format string, args ...interface{}) {
+	lg.logf(eventLevel, format, args...)
+}
+
+func (lg *Logger) logf(level Level, format string, args ...interface{}) {
+	if level != eventLevel && !Levels.Enabled(lg.module, level) {
+		return
+	}
+	entry := zapcore.Entry{
+		LoggerName: lg.module,
+		Time:       time.Now(),
+		Level:      level.zap(),
+		Message:    fmt.Sprintf(format, args...),
+	}
+	if checked := currentCore().Check(entry, nil); checked != nil {
+		checked.Write(lg.fields...)
+	}
+}
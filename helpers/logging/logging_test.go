@@ -0,0 +1,101 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+func TestParseLevels(t *testing.T) {
+	level, overrides, err := logging.ParseLevels("warn, login=debug,proxy=ERROR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != logging.WarnLevel || overrides["login"] != logging.DebugLevel || overrides["proxy"] != logging.ErrorLevel {
+		t.Errorf("unexpected level %v and overrides %v", level, overrides)
+	}
+	if level, overrides, err := logging.ParseLevels(""); err != nil || level != logging.InfoLevel || len(overrides) != 0 {
+		t.Errorf("expected the info default, got %v %v %v", level, overrides, err)
+	}
+	for _, spec := range []string{"verbose", "info,login=loud"} {
+		if _, _, err := logging.ParseLevels(spec); err == nil {
+			t.Errorf("expected %q to be refused", spec)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var out bytes.Buffer
+	logging.SetOutput(&out)
+	defer logging.SetOutput(os.Stderr)
+	defer logging.Levels.Set(logging.InfoLevel, nil)
+
+	logger := logging.New("test-module")
+	if err := logging.Levels.Parse("warn,test-module=debug"); err != nil {
+		t.Fatal(err)
+	}
+	logger.Debugf("visible %d", 1)
+	logging.New("other-module").Infof("hidden")
+	if !strings.Contains(out.String(), "\tdebug\ttest-module\tvisible 1") || strings.Contains(out.String(), "hidden") {
+		t.Errorf("unexpected log %q", out.String())
+	}
+
+	if err := logging.Levels.Parse("info,tset-module=debug"); err == nil {
+		t.Error("expected an unknown module to be refused")
+	}
+	out.Reset()
+	if err := logging.Levels.Parse("error"); err != nil {
+		t.Fatal(err)
+	}
+	logger.Warnf("hidden")
+	if out.Len() != 0 {
+		t.Errorf("unexpected log %q", out.String())
+	}
+	snapshot := logging.Levels.Snapshot()
+	if snapshot.Default != "error" || len(snapshot.Modules) != 0 {
+		t.Errorf("unexpected levels %+v", snapshot)
+	}
+}
+
+func TestLoggerFields(t *testing.T) {
+	var out bytes.Buffer
+	logging.SetOutput(&out)
+	defer logging.SetOutput(os.Stderr)
+	defer logging.SetFormat(logging.TextFormat)
+
+	logger := logging.New("fields-module").With("request_id", "abc", "path", "/v2/a b")
+	logger.Warnf("slow %d", 3)
+	if !strings.Contains(out.String(), "\twarn\tfields-module\tslow 3\t{\"request_id\": \"abc\", \"path\": \"/v2/a b\"}") {
+		t.Errorf("unexpected log %q", out.String())
+	}
+
+	if err := logging.SetFormat("yaml"); err == nil {
+		t.Error("expected an unknown format to be refused")
+	}
+	if err := logging.SetFormat("JSON"); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	logger.With("user_id", "user-guid").Errorf("failed: %v", "boom")
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON line, got %q", out.String())
+	}
+	if entry["level"] != "error" || entry["module"] != "fields-module" || entry["msg"] != "failed: boom" ||
+		entry["request_id"] != "abc" || entry["user_id"] != "user-guid" || entry["time"] == nil {
+		t.Errorf("unexpected entry %v", entry)
+	}
+
+	// Events are logged whatever the levels.
+	logging.Levels.Parse("error")
+	defer logging.Levels.Set(logging.InfoLevel, nil)
+	out.Reset()
+	logger.Eventf("audit event")
+	if !strings.Contains(out.String(), `"level":"event"`) {
+		t.Errorf("expected the event to be logged, got %q", out.String())
+	}
+}
@@ -0,0 +1,87 @@
+package helpers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Steps of the login flow recorded by the LoginFunnel.
+const (
+	// LoginStarted is recorded when a user is sent to UAA to log in.
+	LoginStarted = "handshake_started"
+	// LoginStateMismatch is recorded when the callback state doesn't match
+	// the session, e.g. because the session cookie was lost.
+	LoginStateMismatch = "state_mismatch"
+	// LoginExchangeFailed is recorded when the code can't be exchanged for
+	// tokens.
+	LoginExchangeFailed = "exchange_failed"
+	// LoginSessionFailed is recorded when the tokens can't be saved in the
+	// session.
+	LoginSessionFailed = "session_save_failed"
+	// LoginCompleted is recorded when the user is logged in.
+	LoginCompleted = "completed"
+)
+
+var loginEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "dashboard_login_events_total",
+	Help: "Steps of the login flow reached by users.",
+}, []string{"event"})
+
+func init() {
+	prometheus.MustRegister(loginEvents)
+}
+
+// LoginFunnel counts how many logins reach each step of the login flow. Only
+// aggregate counts are kept, never who the users are.
+type LoginFunnel struct {
+	mu     sync.Mutex
+	since  time.Time
+	counts map[string]int64
+}
+
+// LoginFunnelReport is a snapshot of the LoginFunnel.
+type LoginFunnelReport struct {
+	Since  time.Time        `json:"since"`
+	Counts map[string]int64 `json:"counts"`
+	// CompletionRate is the share of started logins that completed.
+	CompletionRate float64 `json:"completion_rate"`
+}
+
+// NewLoginFunnel creates an empty LoginFunnel.
+func NewLoginFunnel() *LoginFunnel {
+	return &LoginFunnel{since: time.Now(), counts: make(map[string]int64)}
+}
+
+// Record counts a step of the login flow.
+func (f *LoginFunnel) Record(event string) {
+	loginEvents.WithLabelValues(event).Inc()
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts[event]++
+}
+
+// Report returns the counts since the funnel was created. A nil funnel
+// reports no logins.
+func (f *LoginFunnel) Report() LoginFunnelReport {
+	report := LoginFunnelReport{Counts: make(map[string]int64)}
+	var counts map[string]int64
+	if f != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		report.Since = f.since
+		counts = f.counts
+	}
+	for _, event := range []string{LoginStarted, LoginStateMismatch,
+		LoginExchangeFailed, LoginSessionFailed, LoginCompleted} {
+		report.Counts[event] = counts[event]
+	}
+	if started := counts[LoginStarted]; started > 0 {
+		report.CompletionRate = float64(counts[LoginCompleted]) / float64(started)
+	}
+	return report
+}
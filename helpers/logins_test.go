@@ -0,0 +1,39 @@
+package helpers_test
+
+import (
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestLoginFunnel(t *testing.T) {
+	funnel := helpers.NewLoginFunnel()
+	if report := funnel.Report(); report.CompletionRate != 0 || report.Counts[helpers.LoginStarted] != 0 {
+		t.Errorf("Expected an empty report, found %+v", report)
+	}
+	for i := 0; i < 4; i++ {
+		funnel.Record(helpers.LoginStarted)
+	}
+	funnel.Record(helpers.LoginStateMismatch)
+	funnel.Record(helpers.LoginCompleted)
+	funnel.Record(helpers.LoginCompleted)
+	funnel.Record(helpers.LoginCompleted)
+
+	report := funnel.Report()
+	if report.Counts[helpers.LoginStarted] != 4 || report.Counts[helpers.LoginStateMismatch] != 1 ||
+		report.Counts[helpers.LoginExchangeFailed] != 0 || report.Counts[helpers.LoginCompleted] != 3 {
+		t.Errorf("Unexpected counts %v", report.Counts)
+	}
+	if report.CompletionRate != 0.75 {
+		t.Errorf("Expected completion rate 0.75, found %f", report.CompletionRate)
+	}
+}
+
+func TestNilLoginFunnel(t *testing.T) {
+	var funnel *helpers.LoginFunnel
+	funnel.Record(helpers.LoginStarted)
+	report := funnel.Report()
+	if len(report.Counts) != 5 || report.Counts[helpers.LoginStarted] != 0 || report.CompletionRate != 0 {
+		t.Errorf("Expected an empty report, found %+v", report)
+	}
+}
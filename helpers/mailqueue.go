@@ -0,0 +1,86 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/govau/cf-common/env"
+)
+
+const (
+	// DefaultMailQueueWorkers is how many emails are sent at once, unless
+	// configured otherwise.
+	DefaultMailQueueWorkers = 2
+	// DefaultMailQueueSize is how many emails can wait to be sent before
+	// more are refused.
+	DefaultMailQueueSize = 1000
+	// DefaultMailMaxAttempts is how many times an email is tried in all
+	// before it's given up on.
+	DefaultMailMaxAttempts = 5
+	// DefaultMailRetryBaseDelay is the delay before the first retry of an
+	// email. It doubles with each retry, up to DefaultMailRetryMaxDelay.
+	DefaultMailRetryBaseDelay = 2 * time.Second
+	// DefaultMailRetryMaxDelay caps the delay between the retries of an
+	// email.
+	DefaultMailRetryMaxDelay = 5 * time.Minute
+)
+
+// MailQueueSettings configures the queue the emails are sent from in the
+// background, so a transient SMTP failure is retried instead of losing the
+// email.
+type MailQueueSettings struct {
+	Workers     int
+	Size        int
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// parseMailQueue reads the mail queue's settings. It returns nil when
+// MAIL_QUEUE_WORKERS is 0, so emails are sent while the request waits.
+func parseMailQueue(envVars *env.VarSet) (*MailQueueSettings, error) {
+	q := &MailQueueSettings{
+		Workers:     DefaultMailQueueWorkers,
+		Size:        DefaultMailQueueSize,
+		MaxAttempts: DefaultMailMaxAttempts,
+		BaseDelay:   DefaultMailRetryBaseDelay,
+		MaxDelay:    DefaultMailRetryMaxDelay,
+	}
+	var err error
+	for name, n := range map[string]*int{
+		MailQueueWorkersEnvVar: &q.Workers,
+		MailQueueSizeEnvVar:    &q.Size,
+		MailMaxAttemptsEnvVar:  &q.MaxAttempts,
+	} {
+		if value := envVars.String(name, ""); value != "" {
+			if *n, err = strconv.Atoi(value); err == nil && *n < 0 {
+				err = errors.New("must not be negative")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not parse env var %q: %v", name, err)
+			}
+		}
+	}
+	if q.Workers == 0 {
+		return nil, nil
+	}
+	if q.Size < 1 || q.MaxAttempts < 1 {
+		return nil, fmt.Errorf("env vars %q and %q must be at least 1", MailQueueSizeEnvVar, MailMaxAttemptsEnvVar)
+	}
+	for name, d := range map[string]*time.Duration{
+		MailRetryBaseDelayEnvVar: &q.BaseDelay,
+		MailRetryMaxDelayEnvVar:  &q.MaxDelay,
+	} {
+		if value := envVars.String(name, ""); value != "" {
+			if *d, err = time.ParseDuration(value); err == nil && *d <= 0 {
+				err = errors.New("must be positive")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not parse env var %q: %v", name, err)
+			}
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,149 @@
+package helpers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The results the OAuth and session metrics are labelled with.
+const (
+	metricSuccess = "success"
+	metricFailure = "failure"
+)
+
+// The operations of the session stores.
+const (
+	sessionLoad   = "load"
+	sessionSave   = "save"
+	sessionDelete = "delete"
+	// A session kept in a cookie moved into the backend.
+	sessionMigrate = "migrate"
+)
+
+// sessionSaveOperation returns whether saving the session saves or deletes
+// it.
+func sessionSaveOperation(session *sessions.Session) string {
+	if session.Options != nil && session.Options.MaxAge < 0 {
+		return sessionDelete
+	}
+	return sessionSave
+}
+
+// The results of the invites.
+const (
+	// InviteInvited is a user created and emailed an invite.
+	InviteInvited = "invited"
+	// InviteExisting is an address of an existing user, who isn't emailed.
+	InviteExisting = "existing"
+	// InviteFailed is an invite that couldn't be created or emailed.
+	InviteFailed = "failed"
+	// InviteRejected is an address the invite policy rejected.
+	InviteRejected = "rejected"
+)
+
+// The OAuth grants the dashboard requests tokens with.
+const (
+	OAuthAuthorizationCode = "authorization_code"
+	OAuthRefreshToken      = "refresh_token"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_http_requests_total",
+		Help: "Requests to the dashboard, by route, method and status.",
+	}, []string{"route", "method", "status"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dashboard_http_request_duration_seconds",
+		Help:    "Duration of the requests to the dashboard, by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+	oauthTokenRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_oauth_token_requests_total",
+		Help: "Tokens requested from UAA for users, by grant and result.",
+	}, []string{"grant", "result"})
+	sessionOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_session_operations_total",
+		Help: "Sessions loaded, saved and deleted by the session store, by result.",
+	}, []string{"operation", "result"})
+	sessionOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dashboard_session_operation_duration_seconds",
+		Help:    "Duration of the session store's operations.",
+		Buckets: []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+	proxiedRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dashboard_proxied_request_duration_seconds",
+		Help:    "Duration of the requests proxied to the CF API and UAA, by upstream, method and status class.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"upstream", "method", "status"})
+	invites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_invites_total",
+		Help: "Addresses invited, by whether they were invited, already had a user, failed or were rejected.",
+	}, []string{"result"})
+	inviteAcceptance = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "dashboard_invite_acceptance_seconds",
+		Help: "Time between inviting users and their first login.",
+		// From a minute to about six months.
+		Buckets: prometheus.ExponentialBuckets(60, 4, 10),
+	})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpRequestDuration, oauthTokenRequests,
+		sessionOperations, sessionOperationDuration, proxiedRequestDuration,
+		invites, inviteAcceptance)
+}
+
+// ObserveRequest records a request to the dashboard. The route is the
+// pattern it was routed by, e.g. /api/role_requests/:id/approve, so the
+// metrics don't grow with every GUID.
+func ObserveRequest(route, method string, status int, d time.Duration) {
+	if route == "" {
+		route = "unrouted"
+	}
+	httpRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	httpRequestDuration.WithLabelValues(route, method).Observe(d.Seconds())
+}
+
+// RecordOAuthTokenRequest counts a token request of the grant, failed if err
+// isn't nil.
+func RecordOAuthTokenRequest(grant string, err error) {
+	oauthTokenRequests.WithLabelValues(grant, metricResult(err)).Inc()
+}
+
+// observeSessionOperation records an operation of the session store that
+// started at start.
+func observeSessionOperation(operation string, start time.Time, err error) {
+	sessionOperations.WithLabelValues(operation, metricResult(err)).Inc()
+	sessionOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveProxiedRequest records a request proxied to the upstream, cf_api or
+// uaa. A status of 0 means the upstream couldn't be reached.
+func ObserveProxiedRequest(upstream, method string, status int, d time.Duration) {
+	class := "error"
+	if status > 0 {
+		class = strconv.Itoa(status/100) + "xx"
+	}
+	proxiedRequestDuration.WithLabelValues(upstream, method, class).Observe(d.Seconds())
+}
+
+// RecordInvite counts an invited address with its result, e.g. InviteInvited.
+func RecordInvite(result string) {
+	invites.WithLabelValues(result).Inc()
+}
+
+// ObserveInviteAcceptance records a user invited at invitedAt logging in for
+// the first time at now.
+func ObserveInviteAcceptance(invitedAt, now time.Time) {
+	inviteAcceptance.Observe(now.Sub(invitedAt).Seconds())
+}
+
+func metricResult(err error) string {
+	if err != nil {
+		return metricFailure
+	}
+	return metricSuccess
+}
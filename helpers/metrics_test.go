@@ -0,0 +1,93 @@
+package helpers_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// metricValue returns the value of the counter, or the sample count of the
+// histogram, with the labels.
+func metricValue(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if want, ok := labels[label.GetName()]; ok && want != label.GetValue() {
+					continue metrics
+				}
+			}
+			if m.GetHistogram() != nil {
+				return float64(m.GetHistogram().GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestRequestMetrics(t *testing.T) {
+	labels := map[string]string{"route": "/api/role_requests/:id/approve", "method": "POST", "status": "403"}
+	before := metricValue(t, "dashboard_http_requests_total", labels)
+	helpers.ObserveRequest("/api/role_requests/:id/approve", "POST", 403, time.Millisecond)
+	if after := metricValue(t, "dashboard_http_requests_total", labels); after != before+1 {
+		t.Errorf("Expected the request to be counted by route. Found %v then %v", before, after)
+	}
+
+	failed := map[string]string{"grant": helpers.OAuthRefreshToken, "result": "failure"}
+	before = metricValue(t, "dashboard_oauth_token_requests_total", failed)
+	helpers.RecordOAuthTokenRequest(helpers.OAuthRefreshToken, errors.New("invalid_grant"))
+	if after := metricValue(t, "dashboard_oauth_token_requests_total", failed); after != before+1 {
+		t.Errorf("Expected the failed refresh to be counted. Found %v then %v", before, after)
+	}
+
+	proxied := map[string]string{"upstream": "cf_api", "method": "GET", "status": "5xx"}
+	before = metricValue(t, "dashboard_proxied_request_duration_seconds", proxied)
+	helpers.ObserveProxiedRequest("cf_api", "GET", 502, time.Second)
+	if after := metricValue(t, "dashboard_proxied_request_duration_seconds", proxied); after != before+1 {
+		t.Errorf("Expected the proxied request to be observed. Found %v then %v", before, after)
+	}
+}
+
+func TestSessionOperationMetrics(t *testing.T) {
+	store := helpers.NewServerSideStore(&db.MemorySessionStore{}, testSessionAuthKey, testSessionEncKey)
+	loads := map[string]string{"operation": "load", "result": "success"}
+	saves := map[string]string{"operation": "save", "result": "success"}
+	deletes := map[string]string{"operation": "delete", "result": "success"}
+	loaded, saved, deleted := metricValue(t, "dashboard_session_operations_total", loads),
+		metricValue(t, "dashboard_session_operations_total", saves),
+		metricValue(t, "dashboard_session_operations_total", deletes)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	session, err := store.New(req, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Values["key"] = "value"
+	if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+	session.Options.MaxAge = -1
+	if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	if metricValue(t, "dashboard_session_operations_total", loads) != loaded+1 ||
+		metricValue(t, "dashboard_session_operations_total", saves) != saved+1 ||
+		metricValue(t, "dashboard_session_operations_total", deletes) != deleted+1 {
+		t.Error("Expected the session to be counted as loaded, saved and deleted")
+	}
+}
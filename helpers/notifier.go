@@ -0,0 +1,47 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookNotifier posts notifications, such as alerts, to an operator's
+// webhook. The body has a "text" field, so Slack incoming webhooks can be
+// used as they are.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// webhookNotification is the body posted to the webhook.
+type webhookNotification struct {
+	// Text is the message, as shown by Slack.
+	Text string `json:"text"`
+	// Details are for webhooks that process the notification.
+	Details interface{} `json:"details,omitempty"`
+}
+
+// NewWebhookNotifier creates a WebhookNotifier posting to the URL.
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Notify posts the message and its details to the webhook.
+func (n *WebhookNotifier) Notify(text string, details interface{}) error {
+	body, err := json.Marshal(webhookNotification{Text: text, Details: details})
+	if err != nil {
+		return err
+	}
+	res, err := n.Client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d from the notification webhook", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// orgMailerMaxManagers is the most org managers that are emailed, as many as
+// UAA looks up at once.
+const orgMailerMaxManagers = 50
+
+// Emailer sends emails, like mailer.Mailer.
+type Emailer interface {
+	SendEmail(emailAddress string, subject string, body []byte) error
+}
+
+// OrgMailer emails the managers of an org about it, with the dashboard's own
+// credentials, for the background checks that have no user to email.
+type OrgMailer struct {
+	APIURL string
+	UAAURL string
+	Client *http.Client
+	// Mailer and Templates send the emails. Nothing is emailed without them.
+	Mailer    Emailer
+	Templates *Templates
+	AppURL    string
+}
+
+// NewOrgMailer creates an OrgMailer. Its Mailer and Templates are set once
+// they're initialized.
+func NewOrgMailer(apiURL, uaaURL string, client *http.Client) *OrgMailer {
+	return &OrgMailer{APIURL: apiURL, UAAURL: uaaURL, Client: client}
+}
+
+// EmailManagers emails the message to the org's managers, with a link to
+// the dashboard.
+func (m *OrgMailer) EmailManagers(orgGUID, subject, message string) error {
+	if m == nil || m.Mailer == nil || m.Templates == nil {
+		return nil
+	}
+	var ids []string
+	err := eachCCPage(m.Client, m.APIURL, "/v2/organizations/"+url.PathEscape(orgGUID)+"/managers?results-per-page=100", func(resources json.RawMessage) error {
+		var page []struct {
+			Metadata struct {
+				GUID string `json:"guid"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(resources, &page); err != nil {
+			return err
+		}
+		for _, manager := range page {
+			ids = append(ids, manager.Metadata.GUID)
+		}
+		return nil
+	})
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	if len(ids) > orgMailerMaxManagers {
+		ids = ids[:orgMailerMaxManagers]
+	}
+	emails, err := m.userEmails(ids)
+	if err != nil {
+		return err
+	}
+	if m.AppURL != "" {
+		message += "\n\nReview the org in the dashboard at " + m.AppURL + "."
+	}
+	body := new(bytes.Buffer)
+	if err := m.Templates.GetBroadcastEmail(body, subject, message); err != nil {
+		return err
+	}
+	for _, email := range emails {
+		if err := m.Mailer.SendEmail(email, subject, body.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userEmails looks up the primary emails of the users in UAA.
+func (m *OrgMailer) userEmails(ids []string) ([]string, error) {
+	filters := make([]string, len(ids))
+	for i, id := range ids {
+		idJSON, err := json.Marshal(id)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = fmt.Sprintf("id eq %s", idJSON)
+	}
+	query := url.Values{
+		"filter":     {strings.Join(filters, " or ")},
+		"attributes": {"id,emails"},
+		"count":      {fmt.Sprint(len(ids))},
+	}
+	var users struct {
+		Resources []struct {
+			Emails []struct {
+				Value   string `json:"value"`
+				Primary bool   `json:"primary"`
+			} `json:"emails"`
+		} `json:"resources"`
+	}
+	if err := getCCJSON(m.Client, m.UAAURL+"/Users?"+query.Encode(), &users); err != nil {
+		return nil, err
+	}
+	var emails []string
+	for _, user := range users.Resources {
+		email := ""
+		for _, e := range user.Emails {
+			if e.Primary || email == "" {
+				email = e.Value
+			}
+		}
+		if email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails, nil
+}
+
+// eachCCPage calls fn with the resources of each page of the v2 CF API path.
+func eachCCPage(client *http.Client, apiURL, path string, fn func(resources json.RawMessage) error) error {
+	for next := path; next != ""; {
+		var page struct {
+			NextURL   string          `json:"next_url"`
+			Resources json.RawMessage `json:"resources"`
+		}
+		if err := getCCJSON(client, apiURL+next, &page); err != nil {
+			return err
+		}
+		if err := fn(page.Resources); err != nil {
+			return err
+		}
+		next = page.NextURL
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults of the PlatformHealth.
+const (
+	// DefaultPlatformHealthTTL is how long the summary is reused, so the
+	// header widget of every user doesn't load the upstreams.
+	DefaultPlatformHealthTTL = 30 * time.Second
+	// DefaultSlowUpstream is how long the CF API and UAA can take to answer
+	// before they're yellow.
+	DefaultSlowUpstream = time.Second
+	// DefaultLogCacheStale is how old the newest logs in log cache can be
+	// before it's yellow, and DefaultLogCacheDown before it's red.
+	DefaultLogCacheStale = time.Minute
+	DefaultLogCacheDown  = 5 * time.Minute
+	// DefaultHeadroomLow is the share of the cells' memory left under which
+	// the capacity is yellow, and DefaultHeadroomCritical red.
+	DefaultHeadroomLow      = 0.2
+	DefaultHeadroomCritical = 0.1
+)
+
+// The traffic lights of the platform health summary and its components.
+const (
+	PlatformGreen  = "green"
+	PlatformYellow = "yellow"
+	PlatformRed    = "red"
+)
+
+// platformHealthCapacity is the component only shown to admins.
+const platformHealthCapacity = "capacity"
+
+// PlatformComponent is the traffic light of a part of the platform, with a
+// short detail for the widget's tooltip.
+type PlatformComponent struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// PlatformHealthSummary is the traffic light of the platform, the worst of
+// its components'.
+type PlatformHealthSummary struct {
+	Status     string                       `json:"status"`
+	CheckedAt  time.Time                    `json:"checked_at"`
+	Components map[string]PlatformComponent `json:"components"`
+}
+
+// PlatformHealth summarizes the health of the platform for users: whether
+// the CF API and UAA answer, how fresh log cache's logs are and, for admins,
+// how much memory the cells have left. Log cache is read with the
+// dashboard's own credentials, so the summary is the same for everyone and
+// is reused for TTL.
+type PlatformHealth struct {
+	APIURL      string
+	UAAURL      string
+	LogCacheURL string
+	// Client checks the CF API and UAA, and PrivilegedClient reads log
+	// cache.
+	Client           *http.Client
+	PrivilegedClient *http.Client
+	Timeout          time.Duration
+	TTL              time.Duration
+
+	SlowUpstream     time.Duration
+	LogCacheStale    time.Duration
+	LogCacheDown     time.Duration
+	HeadroomLow      float64
+	HeadroomCritical float64
+
+	mu      sync.Mutex
+	summary *PlatformHealthSummary
+}
+
+// NewPlatformHealth creates a PlatformHealth of the settings' upstreams with
+// the default thresholds. Log cache isn't checked when it isn't configured.
+func NewPlatformHealth(s *Settings, client, privileged *http.Client) *PlatformHealth {
+	return &PlatformHealth{
+		APIURL:           s.ConsoleAPI,
+		UAAURL:           s.UaaURL,
+		LogCacheURL:      s.LogCacheURL,
+		Client:           client,
+		PrivilegedClient: privileged,
+		Timeout:          DefaultHealthCheckTimeout,
+		TTL:              DefaultPlatformHealthTTL,
+		SlowUpstream:     DefaultSlowUpstream,
+		LogCacheStale:    DefaultLogCacheStale,
+		LogCacheDown:     DefaultLogCacheDown,
+		HeadroomLow:      DefaultHeadroomLow,
+		HeadroomCritical: DefaultHeadroomCritical,
+	}
+}
+
+// Summary returns the summary of the last checks if it's recent enough, and
+// checks the platform again otherwise. The capacity is only included for
+// admins.
+func (p *PlatformHealth) Summary(ctx context.Context, admin bool) PlatformHealthSummary {
+	full := p.check(ctx)
+	summary := PlatformHealthSummary{
+		Status:     PlatformGreen,
+		CheckedAt:  full.CheckedAt,
+		Components: make(map[string]PlatformComponent, len(full.Components)),
+	}
+	for name, component := range full.Components {
+		if name == platformHealthCapacity && !admin {
+			continue
+		}
+		summary.Components[name] = component
+		summary.Status = worstLight(summary.Status, component.Status)
+	}
+	return summary
+}
+
+func (p *PlatformHealth) check(ctx context.Context) PlatformHealthSummary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.summary != nil && time.Since(p.summary.CheckedAt) < p.TTL {
+		return *p.summary
+	}
+	checks := map[string]func(ctx context.Context) PlatformComponent{
+		"cf_api": p.upstreamCheck(p.APIURL + "/v2/info"),
+		"uaa":    p.upstreamCheck(p.UAAURL + "/info"),
+	}
+	if p.LogCacheURL != "" {
+		checks["log_cache"] = p.checkLogCache
+		checks[platformHealthCapacity] = p.checkCapacity
+	}
+	summary := PlatformHealthSummary{
+		CheckedAt:  time.Now().UTC(),
+		Components: make(map[string]PlatformComponent, len(checks)),
+	}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) PlatformComponent) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+			defer cancel()
+			component := check(ctx)
+			mu.Lock()
+			summary.Components[name] = component
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	p.summary = &summary
+	return summary
+}
+
+// upstreamCheck returns a check that the URL answers with 200, in time.
+func (p *PlatformHealth) upstreamCheck(url string) func(ctx context.Context) PlatformComponent {
+	check := httpHealthCheck(p.Client, url)
+	return func(ctx context.Context) PlatformComponent {
+		start := time.Now()
+		if err := check(ctx); err != nil {
+			return PlatformComponent{Status: PlatformRed, Detail: err.Error()}
+		}
+		latency := time.Since(start)
+		component := PlatformComponent{Status: PlatformGreen,
+			Detail: fmt.Sprintf("answered in %dms", latency/time.Millisecond)}
+		if latency > p.SlowUpstream {
+			component.Status = PlatformYellow
+		}
+		return component
+	}
+}
+
+// logCacheGet gets the log cache path with the dashboard's credentials and
+// decodes its JSON response into v.
+func (p *PlatformHealth) logCacheGet(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequest("GET", p.LogCacheURL+path, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.PrivilegedClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// checkLogCache checks how old the newest envelope in log cache is, which
+// shows whether logs and metrics are still flowing.
+func (p *PlatformHealth) checkLogCache(ctx context.Context) PlatformComponent {
+	var meta struct {
+		Meta map[string]struct {
+			// NewestTimestamp is in nanoseconds, as a string.
+			NewestTimestamp string `json:"newestTimestamp"`
+		} `json:"meta"`
+	}
+	if err := p.logCacheGet(ctx, "/api/v1/meta", &meta); err != nil {
+		return PlatformComponent{Status: PlatformRed, Detail: err.Error()}
+	}
+	var newest int64
+	for _, source := range meta.Meta {
+		if ts, err := strconv.ParseInt(source.NewestTimestamp, 10, 64); err == nil && ts > newest {
+			newest = ts
+		}
+	}
+	if newest == 0 {
+		return PlatformComponent{Status: PlatformRed, Detail: "no logs"}
+	}
+	age := time.Since(time.Unix(0, newest))
+	if age < 0 {
+		age = 0
+	}
+	component := PlatformComponent{Status: PlatformGreen,
+		Detail: fmt.Sprintf("newest logs %s old", age.Round(time.Second))}
+	switch {
+	case age > p.LogCacheDown:
+		component.Status = PlatformRed
+	case age > p.LogCacheStale:
+		component.Status = PlatformYellow
+	}
+	return component
+}
+
+// checkCapacity checks the share of the cells' memory left for new app
+// instances.
+func (p *PlatformHealth) checkCapacity(ctx context.Context) PlatformComponent {
+	remaining, err := p.logCacheSum(ctx, `sum(CapacityRemainingMemory{source_id="rep"})`)
+	if err != nil {
+		return PlatformComponent{Status: PlatformRed, Detail: err.Error()}
+	}
+	total, err := p.logCacheSum(ctx, `sum(CapacityTotalMemory{source_id="rep"})`)
+	if err != nil {
+		return PlatformComponent{Status: PlatformRed, Detail: err.Error()}
+	}
+	if total <= 0 {
+		return PlatformComponent{Status: PlatformRed, Detail: "no cells"}
+	}
+	headroom := remaining / total
+	component := PlatformComponent{Status: PlatformGreen,
+		Detail: fmt.Sprintf("%.0f%% of the cells' memory free", headroom*100)}
+	switch {
+	case headroom < p.HeadroomCritical:
+		component.Status = PlatformRed
+	case headroom < p.HeadroomLow:
+		component.Status = PlatformYellow
+	}
+	return component
+}
+
+// logCacheSum returns the value of a PromQL query summing a metric.
+func (p *PlatformHealth) logCacheSum(ctx context.Context, query string) (float64, error) {
+	var resp struct {
+		Data struct {
+			Result []struct {
+				// Value is a [timestamp, "value"] pair.
+				Value []interface{} `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := p.logCacheGet(ctx, "/api/v1/query?"+url.Values{"query": {query}}.Encode(), &resp); err != nil {
+		return 0, err
+	}
+	if len(resp.Data.Result) == 0 || len(resp.Data.Result[0].Value) != 2 {
+		return 0, nil
+	}
+	raw, _ := resp.Data.Result[0].Value[1].(string)
+	return strconv.ParseFloat(raw, 64)
+}
+
+// worstLight returns the worse of two traffic lights.
+func worstLight(a, b string) string {
+	rank := map[string]int{PlatformGreen: 0, PlatformYellow: 1, PlatformRed: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,59 @@
+package helpers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestPlatformHealth(t *testing.T) {
+	var requests int32
+	newest := time.Now().Add(-2 * time.Minute).UnixNano()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/v2/info":
+			w.Write([]byte(`{}`))
+		case "/api/v1/meta":
+			fmt.Fprintf(w, `{"meta": {"gorouter": {"newestTimestamp": "%d"}, "rep": {"newestTimestamp": "1"}}}`, newest)
+		case "/api/v1/query":
+			value := "100"
+			if r.URL.Query().Get("query") == `sum(CapacityRemainingMemory{source_id="rep"})` {
+				value = "5"
+			}
+			fmt.Fprintf(w, `{"status": "success", "data": {"result": [{"value": [1, "%s"]}]}}`, value)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	settings := &helpers.Settings{ConsoleAPI: server.URL, UaaURL: server.URL + "/uaa", LogCacheURL: server.URL}
+	health := helpers.NewPlatformHealth(settings, server.Client(), server.Client())
+
+	summary := health.Summary(context.Background(), false)
+	if summary.Components["cf_api"].Status != helpers.PlatformGreen || summary.Components["uaa"].Status != helpers.PlatformRed {
+		t.Errorf("Expected the CF API to be green and UAA red. Found %+v", summary.Components)
+	}
+	if summary.Components["log_cache"].Status != helpers.PlatformYellow {
+		t.Errorf("Expected logs 2 minutes old to be yellow. Found %+v", summary.Components["log_cache"])
+	}
+	if _, ok := summary.Components["capacity"]; ok || summary.Status != helpers.PlatformRed {
+		t.Errorf("Expected a red summary without the capacity. Found %+v", summary)
+	}
+
+	// The summary is reused within the TTL, and admins see the capacity.
+	before := atomic.LoadInt32(&requests)
+	summary = health.Summary(context.Background(), true)
+	if atomic.LoadInt32(&requests) != before {
+		t.Errorf("Expected the summary to be reused.")
+	}
+	if capacity := summary.Components["capacity"]; capacity.Status != helpers.PlatformRed {
+		t.Errorf("Expected 5%% of the memory free to be red. Found %+v", capacity)
+	}
+}
@@ -0,0 +1,180 @@
+package helpers
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+// DefaultPreflightTimeout is how long each preflight check can take unless
+// configured otherwise.
+const DefaultPreflightTimeout = 10 * time.Second
+
+// The results of the preflight checks.
+const (
+	PreflightOK   = "ok"
+	PreflightWarn = "warn"
+	PreflightFail = "fail"
+	PreflightSkip = "skip"
+)
+
+// PreflightResult is the result of a preflight check.
+type PreflightResult struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Detail string `json:"detail"`
+}
+
+// PreflightReady returns true if none of the checks failed.
+func PreflightReady(results []PreflightResult) bool {
+	for _, r := range results {
+		if r.Result == PreflightFail {
+			return false
+		}
+	}
+	return true
+}
+
+// RunPreflightChecks checks the dependencies of the settings are live before
+// traffic is sent to a new deployment: the client can get a token from UAA,
+// the CF API answers, the redirect URI is registered with UAA, the SMTP
+// server greets and the database schema can be migrated. Each check can take
+// up to timeout. The settings must be initialized with Preflight set, so the
+// database isn't migrated.
+func RunPreflightChecks(s *Settings, timeout time.Duration) []PreflightResult {
+	checks := []struct {
+		name  string
+		check func(ctx context.Context) (result, detail string)
+	}{
+		{"uaa_token", s.preflightUAAToken},
+		{"cf_api", s.preflightCFAPI},
+		{"redirect_uri", s.preflightRedirectURI},
+		{"smtp", s.preflightSMTP},
+		{"database", s.preflightDatabase},
+	}
+	results := make([]PreflightResult, 0, len(checks))
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		result, detail := c.check(ctx)
+		cancel()
+		results = append(results, PreflightResult{Name: c.name, Result: result, Detail: detail})
+	}
+	return results
+}
+
+func (s *Settings) preflightUAAToken(ctx context.Context) (string, string) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, s.HTTPClient)
+	if _, err := s.HighPrivilegedOauthConfig.Token(ctx); err != nil {
+		return PreflightFail, fmt.Sprintf("no token from %s: %v", s.HighPrivilegedOauthConfig.TokenURL, err)
+	}
+	detail := "client credentials accepted by " + s.HighPrivilegedOauthConfig.TokenURL
+	if s.ClientSecrets != nil && s.ClientSecrets.Next != "" {
+		detail += " with the " + s.ClientSecrets.InUse() + " secret"
+	}
+	return PreflightOK, detail
+}
+
+func (s *Settings) preflightCFAPI(ctx context.Context) (string, string) {
+	req, err := http.NewRequest("GET", s.ConsoleAPI+"/v2/info", nil)
+	if err != nil {
+		return PreflightFail, err.Error()
+	}
+	res, err := s.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return PreflightFail, err.Error()
+	}
+	defer res.Body.Close()
+	var info struct {
+		APIVersion string `json:"api_version"`
+	}
+	if res.StatusCode != http.StatusOK {
+		return PreflightFail, fmt.Sprintf("unexpected status %d from %s/v2/info", res.StatusCode, s.ConsoleAPI)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return PreflightFail, fmt.Sprintf("unexpected response from %s/v2/info: %v", s.ConsoleAPI, err)
+	}
+	return PreflightOK, "API version " + info.APIVersion
+}
+
+// preflightRedirectURI needs the clients.read authority, which is optional,
+// so it only warns when the registration can't be read.
+func (s *Settings) preflightRedirectURI(ctx context.Context) (string, string) {
+	registration, err := s.FetchUAAClientRegistration()
+	if err != nil {
+		return PreflightWarn, "unable to read the client registration, which needs clients.read: " + err.Error()
+	}
+	for _, pattern := range registration.RedirectURI {
+		if redirectURIMatches(pattern, s.OAuthConfig.RedirectURL) {
+			return PreflightOK, s.OAuthConfig.RedirectURL + " is registered"
+		}
+	}
+	return PreflightFail, fmt.Sprintf("%s is not registered (registered: %s)",
+		s.OAuthConfig.RedirectURL, strings.Join(registration.RedirectURI, ", "))
+}
+
+// preflightSMTP only warns, since the dashboard works without e-mails.
+func (s *Settings) preflightSMTP(ctx context.Context) (string, string) {
+	if s.SMTPHost == "" {
+		return PreflightSkip, "no SMTP server"
+	}
+	port := s.SMTPPort
+	if port == "" {
+		port = "25"
+	}
+	addr := net.JoinHostPort(s.SMTPHost, port)
+	dialer := &net.Dialer{}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+	var conn net.Conn
+	var err error
+	if port == "465" {
+		// Implicit TLS: the banner comes after the handshake.
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: s.SMTPHost})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return PreflightWarn, err.Error()
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return PreflightWarn, fmt.Sprintf("no banner from %s: %v", addr, err)
+	}
+	banner = strings.TrimSpace(banner)
+	if !strings.HasPrefix(banner, "220") {
+		return PreflightWarn, fmt.Sprintf("unexpected banner from %s: %q", addr, banner)
+	}
+	return PreflightOK, banner
+}
+
+func (s *Settings) preflightDatabase(ctx context.Context) (string, string) {
+	if s.DB == nil {
+		return PreflightSkip, "no database"
+	}
+	if err := s.DB.PingContext(ctx); err != nil {
+		return PreflightFail, err.Error()
+	}
+	steps, err := db.CheckMigratable(s.DB)
+	if err != nil {
+		return PreflightFail, err.Error()
+	}
+	if len(steps) == 0 {
+		return PreflightOK, fmt.Sprintf("schema at version %d", db.LatestVersion())
+	}
+	return PreflightOK, fmt.Sprintf("%d pending migrations can be applied, up to version %d", len(steps), db.LatestVersion())
+}
@@ -0,0 +1,84 @@
+package helpers
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+func TestRunPreflightChecks(t *testing.T) {
+	redirectURIs := `["https://dashboard.example.com/oauth2callback"]`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth/token":
+			w.Write([]byte(`{"access_token": "token", "token_type": "bearer", "expires_in": 3600}`))
+		case "/v2/info":
+			w.Write([]byte(`{"api_version": "2.150.0"}`))
+		case "/oauth/clients/dashboard":
+			w.Write([]byte(`{"client_id": "dashboard", "redirect_uri": ` + redirectURIs + `}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	smtp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer smtp.Close()
+	go func() {
+		for {
+			conn, err := smtp.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("220 smtp.example.com ESMTP\r\n"))
+			conn.Close()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(smtp.Addr().String())
+
+	s := &Settings{
+		ConsoleAPI: server.URL,
+		UaaURL:     server.URL,
+		HTTPClient: server.Client(),
+		SMTPHost:   host,
+		SMTPPort:   port,
+		OAuthConfig: &oauth2.Config{
+			ClientID:    "dashboard",
+			RedirectURL: "https://dashboard.example.com/oauth2callback",
+		},
+		HighPrivilegedOauthConfig: &clientcredentials.Config{
+			ClientID:     "dashboard",
+			ClientSecret: "secret",
+			TokenURL:     server.URL + "/oauth/token",
+		},
+	}
+	expected := map[string]string{
+		"uaa_token":    PreflightOK,
+		"cf_api":       PreflightOK,
+		"redirect_uri": PreflightOK,
+		"smtp":         PreflightOK,
+		"database":     PreflightSkip,
+	}
+	results := RunPreflightChecks(s, time.Second)
+	for _, r := range results {
+		if r.Result != expected[r.Name] {
+			t.Errorf("Expected the %s check to be %s. Found %+v", r.Name, expected[r.Name], r)
+		}
+	}
+	if len(results) != len(expected) || !PreflightReady(results) {
+		t.Errorf("Expected every check to pass. Found %+v", results)
+	}
+
+	redirectURIs = `["https://other.example.com/**"]`
+	if results := RunPreflightChecks(s, time.Second); PreflightReady(results) || results[2].Result != PreflightFail {
+		t.Errorf("Expected an unregistered redirect URI to fail. Found %+v", results)
+	}
+}
@@ -0,0 +1,59 @@
+package helpers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Names of the configuration profiles selected with EnvironmentEnvVar.
+const (
+	ProfileDevelopment = "development"
+	ProfileStaging     = "staging"
+	ProfileProduction  = "production"
+)
+
+// profiles are bundles of defaults for the settings that usually change
+// between environments. Env vars that are set explicitly always win over the
+// profile defaults.
+var profiles = map[string]map[string]string{
+	ProfileDevelopment: {
+		SecureCookiesEnvVar:  "false",
+		LocalCFEnvVar:        "true",
+		PProfEnabledEnvVar:   "true",
+		VerboseLoggingEnvVar: "true",
+	},
+	ProfileStaging: {
+		SecureCookiesEnvVar:  "true",
+		LocalCFEnvVar:        "false",
+		PProfEnabledEnvVar:   "false",
+		VerboseLoggingEnvVar: "true",
+	},
+	ProfileProduction: {
+		SecureCookiesEnvVar:  "true",
+		LocalCFEnvVar:        "false",
+		PProfEnabledEnvVar:   "false",
+		VerboseLoggingEnvVar: "false",
+	},
+}
+
+// ProfileDefaults returns the env var defaults of the named profile, to be
+// used as the last lookup of the env var set.
+func ProfileDefaults(name string) (map[string]string, error) {
+	defaults, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		var names []string
+		for profile := range profiles {
+			names = append(names, profile)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown %s %q, must be one of %s",
+			EnvironmentEnvVar, name, strings.Join(names, ", "))
+	}
+	// Return a copy so callers can't change the profile.
+	copied := make(map[string]string, len(defaults))
+	for k, v := range defaults {
+		copied[k] = v
+	}
+	return copied, nil
+}
@@ -0,0 +1,131 @@
+package helpers_test
+
+import (
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfenv"
+	"github.com/govau/cf-common/env"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestProfileDefaults(t *testing.T) {
+	defaults, err := helpers.ProfileDefaults("Production")
+	if err != nil {
+		t.Fatalf("Expected nil error, found %s", err.Error())
+	}
+	if defaults[helpers.SecureCookiesEnvVar] != "true" || defaults[helpers.PProfEnabledEnvVar] != "false" {
+		t.Errorf("Unexpected production defaults %v", defaults)
+	}
+	// Callers can't change the profile.
+	defaults[helpers.PProfEnabledEnvVar] = "true"
+	defaults, _ = helpers.ProfileDefaults(helpers.ProfileProduction)
+	if defaults[helpers.PProfEnabledEnvVar] != "false" {
+		t.Error("Expected the production profile not to be changed")
+	}
+
+	if _, err := helpers.ProfileDefaults("qa"); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
+
+func TestInitSettingsWithProfile(t *testing.T) {
+	baseEnvVars := map[string]string{
+		helpers.ClientIDEnvVar:              "ID",
+		helpers.ClientSecretEnvVar:          "Secret",
+		helpers.HostnameEnvVar:              "hostname",
+		helpers.LoginURLEnvVar:              "loginurl",
+		helpers.UAAURLEnvVar:                "uaaurl",
+		helpers.APIURLEnvVar:                "apiurl",
+		helpers.LogURLEnvVar:                "logurl",
+		helpers.SessionEncryptionEnvVar:     "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff",
+		helpers.SessionAuthenticationEnvVar: "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff",
+		helpers.CSRFKeyEnvVar:               "00112233445566778899aabbccddeeff",
+		helpers.SMTPFromEnvVar:              "blah@blah.com",
+		helpers.SMTPHostEnvVar:              "localhost",
+	}
+	tests := []struct {
+		testName        string
+		profile         string
+		envVars         map[string]string
+		wantNilError    bool
+		wantSecure      bool
+		wantPProf       bool
+		wantVerbose     bool
+		wantLocalCF     bool
+		wantEnvironment string
+	}{
+		{
+			testName:        "Development Defaults",
+			profile:         helpers.ProfileDevelopment,
+			wantNilError:    true,
+			wantPProf:       true,
+			wantVerbose:     true,
+			wantLocalCF:     true,
+			wantEnvironment: helpers.ProfileDevelopment,
+		},
+		{
+			testName:        "Staging Defaults",
+			profile:         helpers.ProfileStaging,
+			wantNilError:    true,
+			wantSecure:      true,
+			wantVerbose:     true,
+			wantEnvironment: helpers.ProfileStaging,
+		},
+		{
+			testName:        "Production Defaults",
+			profile:         helpers.ProfileProduction,
+			wantNilError:    true,
+			wantSecure:      true,
+			wantEnvironment: helpers.ProfileProduction,
+		},
+		{
+			testName:        "Explicit Env Var Overrides Profile",
+			profile:         helpers.ProfileProduction,
+			envVars:         map[string]string{helpers.VerboseLoggingEnvVar: "true"},
+			wantNilError:    true,
+			wantSecure:      true,
+			wantVerbose:     true,
+			wantEnvironment: helpers.ProfileProduction,
+		},
+		{
+			testName: "PProf In Production",
+			profile:  helpers.ProfileProduction,
+			envVars:  map[string]string{helpers.PProfEnabledEnvVar: "true"},
+		},
+		{
+			testName: "Insecure Cookies In Production",
+			profile:  helpers.ProfileProduction,
+			envVars:  map[string]string{helpers.SecureCookiesEnvVar: "false"},
+		},
+	}
+	app, _ := cfenv.Current()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			envVars := map[string]string{helpers.EnvironmentEnvVar: tt.profile}
+			for k, v := range baseEnvVars {
+				envVars[k] = v
+			}
+			for k, v := range tt.envVars {
+				envVars[k] = v
+			}
+			defaults, err := helpers.ProfileDefaults(tt.profile)
+			if err != nil {
+				t.Fatalf("Expected nil error, found %s", err.Error())
+			}
+			s := helpers.Settings{}
+			err = s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars), env.WithMapLookup(defaults)), app)
+			if (err == nil) != tt.wantNilError {
+				t.Fatalf("return value: got %t, want %t (%v)", (err == nil), tt.wantNilError, err)
+			}
+			if err != nil {
+				return
+			}
+			if s.SecureCookies != tt.wantSecure || s.PProfEnabled != tt.wantPProf ||
+				s.VerboseLogging != tt.wantVerbose || s.LocalCF != tt.wantLocalCF ||
+				s.Environment != tt.wantEnvironment {
+				t.Errorf("Unexpected settings %+v", s)
+			}
+		})
+	}
+}
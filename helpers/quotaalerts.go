@@ -0,0 +1,257 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defaults of the QuotaAlerts.
+const (
+	// DefaultQuotaAlertInterval is how often the quotas are checked.
+	DefaultQuotaAlertInterval = 15 * time.Minute
+	// DefaultQuotaAlertCooldown is how long an org isn't alerted again about
+	// a resource still over the same threshold.
+	DefaultQuotaAlertCooldown = 24 * time.Hour
+)
+
+// The resources of an org quota that are checked.
+const (
+	QuotaMemory   = "memory"
+	QuotaServices = "services"
+	QuotaRoutes   = "routes"
+)
+
+// QuotaThreshold is the data of the org.quota_threshold event.
+type QuotaThreshold struct {
+	OrgName  string `json:"org_name"`
+	Resource string `json:"resource"`
+	// Used and Limit are in MB for memory, and counts otherwise.
+	Used  int `json:"used"`
+	Limit int `json:"limit"`
+	// Percent is how much of the quota is used, and Threshold the highest
+	// threshold it crossed.
+	Percent   float64 `json:"percent"`
+	Threshold float64 `json:"threshold"`
+}
+
+// subject is the subject of the email alerting the org managers.
+func (t QuotaThreshold) subject() string {
+	return fmt.Sprintf("Org %s is using %.0f%% of its %s quota", t.OrgName, t.Percent, t.Resource)
+}
+
+// message is the message of the email alerting the org managers.
+func (t QuotaThreshold) message() string {
+	unit := ""
+	if t.Resource == QuotaMemory {
+		unit = " MB"
+	}
+	return fmt.Sprintf("Your org %s is using %d%s of its %d%s %s quota (%.0f%%). "+
+		"Deploys will fail once it runs out. Free some up, or ask for a larger quota.",
+		t.OrgName, t.Used, unit, t.Limit, unit, t.Resource, t.Percent)
+}
+
+// quotaAlertState is the last alert of an org's resource.
+type quotaAlertState struct {
+	threshold float64
+	at        time.Time
+}
+
+// QuotaAlerts checks how much of their quota the orgs use every Interval,
+// with the dashboard's own credentials, and alerts an org's managers by email
+// and its webhooks when a resource crosses one of the Thresholds, before
+// deploys start failing. An org is alerted again about a resource when it
+// crosses a higher threshold, or after Cooldown.
+type QuotaAlerts struct {
+	Webhooks *Webhooks
+	// Mailer emails the org managers.
+	Mailer *OrgMailer
+	APIURL string
+	Client *http.Client
+	// Thresholds are percentages of the quotas, in increasing order.
+	Thresholds []float64
+	Interval   time.Duration
+	Cooldown   time.Duration
+
+	mu      sync.Mutex
+	alerted map[string]quotaAlertState
+}
+
+// NewQuotaAlerts creates QuotaAlerts with the thresholds and the default
+// interval and cooldown.
+func NewQuotaAlerts(webhooks *Webhooks, mailer *OrgMailer, apiURL string, client *http.Client, thresholds []float64) *QuotaAlerts {
+	thresholds = append([]float64(nil), thresholds...)
+	sort.Float64s(thresholds)
+	return &QuotaAlerts{
+		Webhooks:   webhooks,
+		Mailer:     mailer,
+		APIURL:     apiURL,
+		Client:     client,
+		Thresholds: thresholds,
+		Interval:   DefaultQuotaAlertInterval,
+		Cooldown:   DefaultQuotaAlertCooldown,
+		alerted:    map[string]quotaAlertState{},
+	}
+}
+
+// ccQuotaOrg is a partial v2 CF API org.
+type ccQuotaOrg struct {
+	Metadata struct {
+		GUID string `json:"guid"`
+	} `json:"metadata"`
+	Entity struct {
+		Name                string `json:"name"`
+		QuotaDefinitionGUID string `json:"quota_definition_guid"`
+	} `json:"entity"`
+}
+
+// ccQuotaDefinition is a partial v2 CF API org quota. Limits of -1 are
+// unlimited.
+type ccQuotaDefinition struct {
+	Metadata struct {
+		GUID string `json:"guid"`
+	} `json:"metadata"`
+	Entity struct {
+		MemoryLimit   int `json:"memory_limit"`
+		TotalServices int `json:"total_services"`
+		TotalRoutes   int `json:"total_routes"`
+	} `json:"entity"`
+}
+
+// Evaluate checks the quotas of all the orgs and sends the alerts that are
+// due. It goes on with the other orgs when one can't be checked, and returns
+// the first error.
+func (q *QuotaAlerts) Evaluate(now time.Time) error {
+	quotas := map[string]ccQuotaDefinition{}
+	err := eachCCPage(q.Client, q.APIURL, "/v2/quota_definitions?results-per-page=100", func(resources json.RawMessage) error {
+		var page []ccQuotaDefinition
+		if err := json.Unmarshal(resources, &page); err != nil {
+			return err
+		}
+		for _, quota := range page {
+			quotas[quota.Metadata.GUID] = quota
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var orgs []ccQuotaOrg
+	err = eachCCPage(q.Client, q.APIURL, "/v2/organizations?results-per-page=100", func(resources json.RawMessage) error {
+		var page []ccQuotaOrg
+		if err := json.Unmarshal(resources, &page); err != nil {
+			return err
+		}
+		orgs = append(orgs, page...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, org := range orgs {
+		quota, ok := quotas[org.Entity.QuotaDefinitionGUID]
+		if !ok {
+			continue
+		}
+		if err := q.evaluateOrg(org, quota, now); err != nil {
+			alertLog.Warnf("could not check the quota of org %s: %v", org.Metadata.GUID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// evaluateOrg checks the limited resources of the org.
+func (q *QuotaAlerts) evaluateOrg(org ccQuotaOrg, quota ccQuotaDefinition, now time.Time) error {
+	guid := url.PathEscape(org.Metadata.GUID)
+	if limit := quota.Entity.MemoryLimit; limit > 0 {
+		var usage struct {
+			MemoryUsageInMB int `json:"memory_usage_in_mb"`
+		}
+		if err := getCCJSON(q.Client, q.APIURL+"/v2/organizations/"+guid+"/memory_usage", &usage); err != nil {
+			return err
+		}
+		q.check(org, QuotaMemory, usage.MemoryUsageInMB, limit, now)
+	}
+	counted := []struct {
+		resource, path string
+		limit          int
+	}{
+		{QuotaServices, "/v2/service_instances", quota.Entity.TotalServices},
+		{QuotaRoutes, "/v2/routes", quota.Entity.TotalRoutes},
+	}
+	for _, c := range counted {
+		if c.limit <= 0 {
+			continue
+		}
+		var page struct {
+			TotalResults int `json:"total_results"`
+		}
+		query := url.Values{"q": {"organization_guid:" + org.Metadata.GUID}, "results-per-page": {"1"}}
+		if err := getCCJSON(q.Client, q.APIURL+c.path+"?"+query.Encode(), &page); err != nil {
+			return err
+		}
+		q.check(org, c.resource, page.TotalResults, c.limit, now)
+	}
+	return nil
+}
+
+// check alerts about the resource if it crossed a higher threshold than it
+// was last alerted about, or the cooldown passed.
+func (q *QuotaAlerts) check(org ccQuotaOrg, resource string, used, limit int, now time.Time) {
+	percent := float64(used) / float64(limit) * 100
+	var crossed float64
+	for _, threshold := range q.Thresholds {
+		if percent >= threshold {
+			crossed = threshold
+		}
+	}
+	key := org.Metadata.GUID + "/" + resource
+	q.mu.Lock()
+	last, alerted := q.alerted[key]
+	if crossed == 0 {
+		// Back under every threshold: the next crossing is news again.
+		delete(q.alerted, key)
+		q.mu.Unlock()
+		return
+	}
+	if alerted && crossed <= last.threshold && now.Sub(last.at) < q.Cooldown {
+		q.mu.Unlock()
+		return
+	}
+	q.alerted[key] = quotaAlertState{threshold: crossed, at: now}
+	q.mu.Unlock()
+
+	data := QuotaThreshold{
+		OrgName:   org.Entity.Name,
+		Resource:  resource,
+		Used:      used,
+		Limit:     limit,
+		Percent:   percent,
+		Threshold: crossed,
+	}
+	if err := q.Webhooks.Publish(WebhookQuotaThreshold, org.Metadata.GUID, data); err != nil {
+		alertLog.Errorf("could not publish the quota alert of org %s: %v", org.Metadata.GUID, err)
+	}
+	if err := q.Mailer.EmailManagers(org.Metadata.GUID, data.subject(), data.message()); err != nil {
+		alertLog.Errorf("could not email the quota alert to the managers of org %s: %v", org.Metadata.GUID, err)
+	}
+}
+
+// Start checks the quotas every Interval, in the background.
+func (q *QuotaAlerts) Start() {
+	go func() {
+		for tick := time.Tick(q.Interval); ; <-tick {
+			if err := q.Evaluate(time.Now()); err != nil {
+				alertLog.Warnf("could not check the org quotas: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,120 @@
+package helpers_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+// recordingMailer records who was emailed.
+type recordingMailer struct {
+	mu       sync.Mutex
+	subjects map[string][]string
+}
+
+func (m *recordingMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subjects[emailAddress] = append(m.subjects[emailAddress], subject)
+	return nil
+}
+
+func TestQuotaAlerts(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		memoryUsage = 850
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v2/quota_definitions":
+			w.Write([]byte(`{"next_url": null, "resources": [
+				{"metadata": {"guid": "quota-1"}, "entity": {"memory_limit": 1000, "total_services": -1, "total_routes": 10}}]}`))
+		case "/v2/organizations":
+			w.Write([]byte(`{"next_url": null, "resources": [
+				{"metadata": {"guid": "org-1"}, "entity": {"name": "sandbox", "quota_definition_guid": "quota-1"}}]}`))
+		case "/v2/organizations/org-1/memory_usage":
+			fmt.Fprintf(w, `{"memory_usage_in_mb": %d}`, memoryUsage)
+		case "/v2/routes":
+			if r.URL.Query().Get("q") != "organization_guid:org-1" {
+				t.Errorf("Expected the org's routes to be counted. Found %s", r.URL)
+			}
+			w.Write([]byte(`{"total_results": 2, "resources": []}`))
+		case "/v2/organizations/org-1/managers":
+			w.Write([]byte(`{"next_url": null, "resources": [{"metadata": {"guid": "manager-guid"}}]}`))
+		case "/Users":
+			w.Write([]byte(`{"resources": [{"id": "manager-guid", "emails": [{"value": "manager@example.com", "primary": true}]}]}`))
+		default:
+			t.Errorf("Unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	store := &db.MemoryWebhookStore{}
+	webhooks := helpers.NewWebhooks(store, jobs.NewRunner(1, 0))
+	webhooks.Client = server.Client()
+	templates, err := helpers.InitTemplates(filepath.Join("testdata", "templates"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := &recordingMailer{subjects: map[string][]string{}}
+	orgMailer := helpers.NewOrgMailer(server.URL, server.URL, server.Client())
+	orgMailer.Mailer, orgMailer.Templates = mailer, templates
+	alerts := helpers.NewQuotaAlerts(webhooks, orgMailer, server.URL, server.Client(), []float64{90, 80})
+	emailed := func() int {
+		mailer.mu.Lock()
+		defer mailer.mu.Unlock()
+		return len(mailer.subjects["manager@example.com"])
+	}
+
+	now := time.Now()
+	if err := alerts.Evaluate(now); err != nil {
+		t.Fatal(err)
+	}
+	if emailed() != 1 {
+		t.Fatalf("Expected the manager to be alerted about the memory at 85%%. Found %v", mailer.subjects)
+	}
+
+	// Still over 80%, within the cooldown.
+	alerts.Evaluate(now.Add(time.Hour))
+	if emailed() != 1 {
+		t.Errorf("Expected no alert within the cooldown. Found %v", mailer.subjects)
+	}
+
+	// A higher threshold is alerted right away.
+	mu.Lock()
+	memoryUsage = 950
+	mu.Unlock()
+	alerts.Evaluate(now.Add(2 * time.Hour))
+	if emailed() != 2 {
+		t.Errorf("Expected an alert about crossing 90%%. Found %v", mailer.subjects)
+	}
+
+	alerts.Evaluate(now.Add(2*time.Hour + alerts.Cooldown))
+	if emailed() != 3 {
+		t.Errorf("Expected another alert after the cooldown. Found %v", mailer.subjects)
+	}
+
+	// Back under every threshold, the next crossing alerts again.
+	mu.Lock()
+	memoryUsage = 100
+	mu.Unlock()
+	alerts.Evaluate(now.Add(3*time.Hour + alerts.Cooldown))
+	mu.Lock()
+	memoryUsage = 820
+	mu.Unlock()
+	alerts.Evaluate(now.Add(4*time.Hour + alerts.Cooldown))
+	if emailed() != 4 {
+		t.Errorf("Expected an alert about crossing 80%% again. Found %v", mailer.subjects)
+	}
+}
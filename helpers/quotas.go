@@ -0,0 +1,119 @@
+package helpers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Scopes of the proxy quotas.
+const (
+	QuotaScopeUser = "user"
+	QuotaScopeOrg  = "org"
+)
+
+var proxyQuotaRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "dashboard_proxy_quota_rejections_total",
+	Help: "Requests to the CF API proxy rejected because a quota was exceeded, by quota scope.",
+}, []string{"scope"})
+
+func init() {
+	prometheus.MustRegister(proxyQuotaRejections)
+}
+
+// ProxyQuota limits the requests each user, and each org, can send to the
+// CF API through the proxy in an hour. It protects the Cloud Controller from
+// runaway automation using dashboard sessions.
+type ProxyQuota struct {
+	// PerUser and PerOrg are the requests allowed per hour. Zero means no
+	// limit.
+	PerUser int
+	PerOrg  int
+
+	mu     sync.Mutex
+	window time.Time
+	users  map[string]int
+	orgs   map[string]int
+}
+
+// ProxyQuotaUsage is the usage of the proxy in the current hour.
+type ProxyQuotaUsage struct {
+	WindowStart time.Time      `json:"window_start"`
+	ResetsAt    time.Time      `json:"resets_at"`
+	PerUser     int            `json:"per_user_limit"`
+	PerOrg      int            `json:"per_org_limit"`
+	Users       map[string]int `json:"users"`
+	Orgs        map[string]int `json:"orgs"`
+}
+
+// NewProxyQuota creates a ProxyQuota with the hourly limits.
+func NewProxyQuota(perUser, perOrg int) *ProxyQuota {
+	return &ProxyQuota{
+		PerUser: perUser,
+		PerOrg:  perOrg,
+		users:   make(map[string]int),
+		orgs:    make(map[string]int),
+	}
+}
+
+// rollWindow starts counting again when the hour is over. Must be called
+// with the lock held.
+func (q *ProxyQuota) rollWindow() {
+	window := time.Now().UTC().Truncate(time.Hour)
+	if window.Equal(q.window) {
+		return
+	}
+	q.window = window
+	q.users = make(map[string]int)
+	q.orgs = make(map[string]int)
+}
+
+// Allow counts a request by the user for the org, either of which can be
+// empty when unknown. If the request is over a quota, it's not counted and
+// the scope of the exceeded quota is returned with when the quota resets.
+func (q *ProxyQuota) Allow(user, org string) (exceeded string, resetsAt time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollWindow()
+	resetsAt = q.window.Add(time.Hour)
+	switch {
+	case user != "" && q.PerUser > 0 && q.users[user] >= q.PerUser:
+		exceeded = QuotaScopeUser
+	case org != "" && q.PerOrg > 0 && q.orgs[org] >= q.PerOrg:
+		exceeded = QuotaScopeOrg
+	}
+	if exceeded != "" {
+		proxyQuotaRejections.WithLabelValues(exceeded).Inc()
+		return exceeded, resetsAt
+	}
+	if user != "" {
+		q.users[user]++
+	}
+	if org != "" {
+		q.orgs[org]++
+	}
+	return "", resetsAt
+}
+
+// Usage returns the requests counted in the current hour.
+func (q *ProxyQuota) Usage() ProxyQuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollWindow()
+	usage := ProxyQuotaUsage{
+		WindowStart: q.window,
+		ResetsAt:    q.window.Add(time.Hour),
+		PerUser:     q.PerUser,
+		PerOrg:      q.PerOrg,
+		Users:       make(map[string]int, len(q.users)),
+		Orgs:        make(map[string]int, len(q.orgs)),
+	}
+	for user, count := range q.users {
+		usage.Users[user] = count
+	}
+	for org, count := range q.orgs {
+		usage.Orgs[org] = count
+	}
+	return usage
+}
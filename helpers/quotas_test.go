@@ -0,0 +1,44 @@
+package helpers_test
+
+import (
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestProxyQuota(t *testing.T) {
+	quota := helpers.NewProxyQuota(2, 2)
+	for _, test := range []struct {
+		user, org string
+		expected  string
+	}{
+		{"user-1", "org-1", ""},
+		{"user-1", "", ""},
+		{"user-1", "org-1", helpers.QuotaScopeUser},
+		{"user-2", "org-1", ""},
+		{"user-3", "org-1", helpers.QuotaScopeOrg},
+		{"user-3", "org-2", ""},
+		{"", "", ""},
+	} {
+		if exceeded, _ := quota.Allow(test.user, test.org); exceeded != test.expected {
+			t.Errorf("%s in %s: expected exceeded quota %q, found %q", test.user, test.org, test.expected, exceeded)
+		}
+	}
+
+	usage := quota.Usage()
+	if usage.Users["user-1"] != 2 || usage.Users["user-3"] != 1 || usage.Orgs["org-1"] != 2 || usage.Orgs["org-2"] != 1 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+	if usage.ResetsAt.Sub(usage.WindowStart).Hours() != 1 || usage.PerUser != 2 || usage.PerOrg != 2 {
+		t.Errorf("Unexpected usage window %+v", usage)
+	}
+}
+
+func TestProxyQuotaUnlimited(t *testing.T) {
+	quota := helpers.NewProxyQuota(0, 1)
+	for i := 0; i < 5; i++ {
+		if exceeded, _ := quota.Allow("user-1", ""); exceeded != "" {
+			t.Fatalf("Expected no user limit, found %q exceeded", exceeded)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package helpers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/gorilla/securecookie"
+)
+
+// The backends API_CACHE selects.
+const (
+	MemoryAPICacheBackend = "memory"
+	RedisAPICacheBackend  = "redis"
+)
+
+const (
+	// DefaultAPICacheBytes bounds the memory used by the in-memory API cache
+	// unless configured otherwise.
+	DefaultAPICacheBytes = 32 << 20
+
+	// redisAPICacheKeyPrefix namespaces the cached responses in Redis, so the
+	// database can be shared, e.g. with the sessions.
+	redisAPICacheKeyPrefix = "cg-dashboard:api-cache:"
+	// apiCacheGenerationTTL is how long a user's generation is kept. It must
+	// outlive the cached responses, so they aren't served after a change.
+	apiCacheGenerationTTL = 24 * time.Hour
+)
+
+// DefaultAPICacheTTLs are how long the responses of each type of CF API
+// resource are cached unless configured otherwise. The frontend fetches
+// these on every page load. Other resources aren't cached.
+var DefaultAPICacheTTLs = map[string]time.Duration{
+	"organizations": time.Minute,
+	"spaces":        time.Minute,
+	"apps":          15 * time.Second,
+}
+
+// ResponseCacheBackend keeps the encoded responses by key.
+type ResponseCacheBackend interface {
+	// Get returns the response of the key, or nil if it isn't cached.
+	Get(key string) ([]byte, error)
+	// Set caches the response of the key for ttl.
+	Set(key string, data []byte, ttl time.Duration) error
+}
+
+// ResponseCache caches the responses of proxied CF API GETs for each user,
+// for the TTL of the type of resource. A change a user makes through the
+// dashboard invalidates all their cached responses, by starting a new
+// generation of their keys.
+type ResponseCache struct {
+	Backend ResponseCacheBackend
+	// TTLs are how long responses are cached by resource type, e.g. apps.
+	TTLs map[string]time.Duration
+}
+
+// TTL returns how long the response of the CF API path is cached, or zero
+// if it isn't. The type of resource is the last collection in the path,
+// e.g. spaces for /v2/organizations/<guid>/spaces.
+func (c *ResponseCache) TTL(path string) time.Duration {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(path, "/v2/"), "/"), "/")
+	return c.TTLs[segments[(len(segments)-1)/2*2]]
+}
+
+// Key returns the key of the user's request for the URI, in the user's
+// current generation.
+func (c *ResponseCache) Key(user, requestURI string) (string, error) {
+	generation, err := c.generation(user)
+	if err != nil {
+		return "", err
+	}
+	return "response:" + user + ":" + generation + ":" + requestURI, nil
+}
+
+// Invalidate drops the user's cached responses, e.g. after they made a
+// change.
+func (c *ResponseCache) Invalidate(user string) error {
+	return c.Backend.Set(c.generationKey(user), newCacheGeneration(), apiCacheGenerationTTL)
+}
+
+func (c *ResponseCache) generation(user string) (string, error) {
+	generation, err := c.Backend.Get(c.generationKey(user))
+	if err != nil || generation != nil {
+		return string(generation), err
+	}
+	generation = newCacheGeneration()
+	return string(generation), c.Backend.Set(c.generationKey(user), generation, apiCacheGenerationTTL)
+}
+
+func (c *ResponseCache) generationKey(user string) string {
+	return "generation:" + user
+}
+
+func newCacheGeneration() []byte {
+	return []byte(fmt.Sprintf("%x", securecookie.GenerateRandomKey(8)))
+}
+
+// MemoryResponseCache keeps the responses in an in-memory LRU cache, per
+// instance.
+type MemoryResponseCache struct {
+	Cache *Cache
+}
+
+// NewMemoryResponseCache creates an in-memory backend bounded by maxBytes.
+func NewMemoryResponseCache(maxBytes int64) *MemoryResponseCache {
+	return &MemoryResponseCache{Cache: NewCache("api", maxBytes)}
+}
+
+// Get returns the response of the key, or nil if it isn't cached.
+func (m *MemoryResponseCache) Get(key string) ([]byte, error) {
+	if value, ok := m.Cache.Peek(key); ok {
+		return value.([]byte), nil
+	}
+	return nil, nil
+}
+
+// Set caches the response of the key for ttl.
+func (m *MemoryResponseCache) Set(key string, data []byte, ttl time.Duration) error {
+	m.Cache.Set(key, CacheItem{Value: data, Size: int64(len(key) + len(data)), TTL: ttl})
+	return nil
+}
+
+// RedisResponseCache keeps the responses in Redis, shared by all instances.
+type RedisResponseCache struct {
+	Pool *redis.Pool
+}
+
+// NewRedisResponseCache connects to Redis at the URL, e.g.
+// redis://:password@host:6379/1.
+func NewRedisResponseCache(redisURL string) (*RedisResponseCache, error) {
+	pool, err := newRedisPool(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisResponseCache{Pool: pool}, nil
+}
+
+// Get returns the response of the key, or nil if it isn't cached.
+func (r *RedisResponseCache) Get(key string) ([]byte, error) {
+	conn := r.Pool.Get()
+	defer conn.Close()
+	data, err := redis.Bytes(conn.Do("GET", redisAPICacheKeyPrefix+key))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Set caches the response of the key for ttl.
+func (r *RedisResponseCache) Set(key string, data []byte, ttl time.Duration) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", redisAPICacheKeyPrefix+key, data, "PX", int64(ttl/time.Millisecond))
+	return err
+}
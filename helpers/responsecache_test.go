@@ -0,0 +1,45 @@
+package helpers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestResponseCacheTTL(t *testing.T) {
+	cache := &helpers.ResponseCache{
+		Backend: helpers.NewMemoryResponseCache(1 << 20),
+		TTLs:    helpers.DefaultAPICacheTTLs,
+	}
+	for path, ttl := range map[string]time.Duration{
+		"/v2/organizations":                  time.Minute,
+		"/v2/organizations/org-1":            time.Minute,
+		"/v2/organizations/org-1/spaces":     time.Minute,
+		"/v2/spaces/space-1/apps":            15 * time.Second,
+		"/v2/apps/app-1/stats":               0,
+		"/v2/organizations/org-1/user_roles": 0,
+	} {
+		if got := cache.TTL(path); got != ttl {
+			t.Errorf("Expected %s to be cached for %s. Found %s", path, ttl, got)
+		}
+	}
+}
+
+func TestResponseCacheInvalidate(t *testing.T) {
+	cache := &helpers.ResponseCache{Backend: helpers.NewMemoryResponseCache(1 << 20)}
+	key, err := cache.Key("user-1", "/v2/apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := cache.Key("user-1", "/v2/apps"); again != key {
+		t.Errorf("Expected the same key. Found %s and %s", key, again)
+	}
+	if other, _ := cache.Key("user-2", "/v2/apps"); other == key {
+		t.Error("Expected the users to have different keys")
+	}
+	cache.Invalidate("user-1")
+	if after, _ := cache.Key("user-1", "/v2/apps"); after == key {
+		t.Error("Expected a new key after the invalidation")
+	}
+}
@@ -0,0 +1,106 @@
+package helpers
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers/logging"
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+var retentionLog = logging.New("retention")
+
+const (
+	// DefaultPurgeInterval is how often old data is purged, unless
+	// configured otherwise.
+	DefaultPurgeInterval = time.Hour
+	// DefaultDecisionRetention is how long decided role requests and pending
+	// changes are kept, unless configured otherwise.
+	DefaultDecisionRetention = 30 * 24 * time.Hour
+)
+
+var (
+	purgedRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_purged_records_total",
+		Help: "Records dropped by the retention purges, by kind.",
+	}, []string{"kind"})
+	purgeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_purge_failures_total",
+		Help: "Retention purges that failed, by kind.",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(purgedRecords, purgeFailures)
+}
+
+// PurgeTarget is a kind of data dropped once it's older than its retention.
+type PurgeTarget struct {
+	// Kind labels the target in the metrics and logs, e.g. audit_events.
+	Kind      string
+	Retention time.Duration
+	// Purge drops the records older than the time and returns how many it
+	// dropped.
+	Purge func(before time.Time) (int64, error)
+}
+
+// Purger regularly drops the dashboard's own data that's past its
+// retention, so the database doesn't grow unbounded.
+type Purger struct {
+	Targets  []PurgeTarget
+	Interval time.Duration
+}
+
+// NewPurger creates a Purger for the stores and the job runner, with the
+// default retentions.
+func NewPurger(audit db.AuditStore, roleRequests db.RoleRequestStore, pendingChanges db.PendingChangeStore,
+	runner *jobs.Runner) *Purger {
+	return &Purger{
+		Interval: DefaultPurgeInterval,
+		Targets: []PurgeTarget{
+			{Kind: "audit_events", Retention: db.AuditRetention, Purge: audit.PurgeEvents},
+			{Kind: "role_requests", Retention: DefaultDecisionRetention, Purge: roleRequests.PurgeRoleRequests},
+			{Kind: "pending_changes", Retention: DefaultDecisionRetention, Purge: pendingChanges.PurgePendingChanges},
+			{Kind: "jobs", Retention: runner.Retention, Purge: func(before time.Time) (int64, error) {
+				return int64(runner.Purge(before)), nil
+			}},
+		},
+	}
+}
+
+// SetRetention changes the retention of the targets of the kind.
+func (p *Purger) SetRetention(kind string, retention time.Duration) {
+	for i := range p.Targets {
+		if p.Targets[i].Kind == kind {
+			p.Targets[i].Retention = retention
+		}
+	}
+}
+
+// PurgeAll purges each target once. A target that fails doesn't stop the
+// others from being purged.
+func (p *Purger) PurgeAll(now time.Time) {
+	for _, target := range p.Targets {
+		purged, err := target.Purge(now.Add(-target.Retention))
+		if err != nil {
+			purgeFailures.WithLabelValues(target.Kind).Inc()
+			retentionLog.Errorf("unable to purge the %s older than %s: %v", target.Kind, target.Retention, err)
+			continue
+		}
+		purgedRecords.WithLabelValues(target.Kind).Add(float64(purged))
+		if purged > 0 {
+			retentionLog.Infof("purged %d %s older than %s", purged, target.Kind, target.Retention)
+		}
+	}
+}
+
+// Start purges now, then every interval, in the background.
+func (p *Purger) Start() {
+	go func() {
+		for tick := time.Tick(p.Interval); ; <-tick {
+			p.PurgeAll(time.Now())
+		}
+	}()
+}
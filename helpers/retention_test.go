@@ -0,0 +1,46 @@
+package helpers_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+func TestPurger(t *testing.T) {
+	now := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	audit := &db.MemoryAuditStore{}
+	audit.RecordEvent(db.AuditEvent{Time: now.Add(-db.AuditRetention - time.Hour), Actor: "user-guid", Action: "login"})
+	audit.RecordEvent(db.AuditEvent{Time: now.Add(-time.Hour), Actor: "user-guid", Action: "login"})
+
+	purger := helpers.NewPurger(audit, &db.MemoryRoleRequestStore{}, &db.MemoryPendingChangeStore{}, jobs.NewRunner(1, 0))
+	var failingBefore time.Time
+	purger.Targets = append([]helpers.PurgeTarget{{
+		Kind:      "broken",
+		Retention: time.Hour,
+		Purge: func(before time.Time) (int64, error) {
+			failingBefore = before
+			return 0, errors.New("connection refused")
+		},
+	}}, purger.Targets...)
+
+	purger.PurgeAll(now)
+	if !failingBefore.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Expected the broken target to purge before %s. Found %s", now.Add(-time.Hour), failingBefore)
+	}
+	// The failing target doesn't stop the others.
+	events, _ := audit.Events("user-guid", time.Time{}, 10)
+	if len(events) != 1 {
+		t.Errorf("Expected the expired audit event to be purged. Found %+v", events)
+	}
+
+	purger.SetRetention("audit_events", 30*time.Minute)
+	purger.PurgeAll(now)
+	events, _ = audit.Events("user-guid", time.Time{}, 10)
+	if len(events) != 0 {
+		t.Errorf("Expected the shorter retention to purge all events. Found %+v", events)
+	}
+}
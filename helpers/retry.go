@@ -0,0 +1,152 @@
+package helpers
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultRetryMaxAttempts is how many times a proxied CF API request is
+	// tried in all, unless configured otherwise.
+	DefaultRetryMaxAttempts = 3
+	// DefaultRetryBaseDelay is the delay before the first retry. It doubles
+	// with each retry, up to DefaultRetryMaxDelay.
+	DefaultRetryBaseDelay = 100 * time.Millisecond
+	// DefaultRetryMaxDelay caps the delay between retries.
+	DefaultRetryMaxDelay = 2 * time.Second
+	// DefaultRetryBudgetRatio is the share of requests that can be retried,
+	// e.g. 0.1 allows a retry for every 10 requests.
+	DefaultRetryBudgetRatio = 0.1
+	// DefaultRetryBudgetMax is how many retries the budget holds at most, so
+	// a quiet dashboard can still retry, but not a storm of retries at once.
+	DefaultRetryBudgetMax = 10
+)
+
+var upstreamRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "dashboard_upstream_retries_total",
+	Help: "Retries of proxied upstream requests, by upstream and whether they were made or refused by the retry budget.",
+}, []string{"upstream", "result"})
+
+func init() {
+	prometheus.MustRegister(upstreamRetries)
+}
+
+// RetryPolicy retries the proxied requests that failed transiently, i.e.
+// couldn't reach the upstream or got a 502, 503 or 504, with an exponential
+// backoff. Retries are limited by a budget shared by all requests, so an
+// upstream that's down isn't sent a storm of retries.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// IdempotentOnly only retries the requests that can be made twice
+	// safely, i.e. not POSTs and PATCHes.
+	IdempotentOnly bool
+	Budget         *RetryBudget
+}
+
+// NewRetryPolicy creates a RetryPolicy with the defaults.
+func NewRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    DefaultRetryMaxAttempts,
+		BaseDelay:      DefaultRetryBaseDelay,
+		MaxDelay:       DefaultRetryMaxDelay,
+		IdempotentOnly: true,
+		Budget:         NewRetryBudget(DefaultRetryBudgetRatio, DefaultRetryBudgetMax),
+	}
+}
+
+// Retries returns true if the policy could retry requests of the method.
+func (p *RetryPolicy) Retries(method string) bool {
+	if p == nil || p.MaxAttempts < 2 {
+		return false
+	}
+	return !p.IdempotentOnly || isIdempotent(method)
+}
+
+// ShouldRetry returns true if the attempt at a request of the method to the
+// upstream, which got the status (0 if it couldn't reach the upstream),
+// should be retried. It takes the retry out of the budget.
+func (p *RetryPolicy) ShouldRetry(upstream, method string, attempt, status int) bool {
+	if !p.Retries(method) || attempt >= p.MaxAttempts {
+		return false
+	}
+	switch status {
+	case 0, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+	default:
+		return false
+	}
+	if !p.Budget.Withdraw() {
+		upstreamRetries.WithLabelValues(upstream, "refused").Inc()
+		return false
+	}
+	upstreamRetries.WithLabelValues(upstream, "retried").Inc()
+	return true
+}
+
+// Backoff returns how long to wait before retrying after the attempt: a
+// random delay up to the base delay doubled for each attempt, capped at the
+// max delay, so retries of concurrent requests spread out.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.BaseDelay << uint(attempt-1)
+	if d > p.MaxDelay || d <= 0 {
+		d = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// RetryBudget limits the retries to a share of the requests. Each request
+// deposits Ratio of a retry, and each retry withdraws one. The balance is
+// capped, so a long quiet spell doesn't allow a storm later.
+type RetryBudget struct {
+	Ratio float64
+	Max   float64
+
+	mu      sync.Mutex
+	balance float64
+}
+
+// NewRetryBudget creates a full RetryBudget allowing a ratio of the requests
+// to be retried, holding at most max retries.
+func NewRetryBudget(ratio float64, max int) *RetryBudget {
+	return &RetryBudget{Ratio: ratio, Max: float64(max), balance: float64(max)}
+}
+
+// Deposit records a request.
+func (b *RetryBudget) Deposit() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.balance += b.Ratio; b.balance > b.Max {
+		b.balance = b.Max
+	}
+}
+
+// Withdraw takes a retry out of the budget. It returns false if the budget
+// is spent.
+func (b *RetryBudget) Withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.balance < 1 {
+		return false
+	}
+	b.balance--
+	return true
+}
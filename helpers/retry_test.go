@@ -0,0 +1,61 @@
+package helpers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := NewRetryPolicy()
+	policy.Budget = NewRetryBudget(0.5, 2)
+
+	for i, test := range []struct {
+		method  string
+		attempt int
+		status  int
+		retry   bool
+	}{
+		{"GET", 1, http.StatusServiceUnavailable, true},
+		{"DELETE", 2, 0, true},
+		// The budget is spent.
+		{"GET", 1, http.StatusBadGateway, false},
+		{"GET", 1, http.StatusOK, false},
+		{"GET", 3, http.StatusGatewayTimeout, false},
+		{"POST", 1, http.StatusServiceUnavailable, false},
+		{"GET", 1, http.StatusInternalServerError, false},
+	} {
+		if got := policy.ShouldRetry("cf_api", test.method, test.attempt, test.status); got != test.retry {
+			t.Errorf("%d: expected retry %v for %s attempt %d with status %d. Found %v",
+				i, test.retry, test.method, test.attempt, test.status, got)
+		}
+	}
+
+	// Two requests earn a retry.
+	policy.Budget.Deposit()
+	policy.Budget.Deposit()
+	if !policy.ShouldRetry("cf_api", "GET", 1, http.StatusBadGateway) {
+		t.Error("expected the requests to replenish the budget")
+	}
+
+	policy.IdempotentOnly = false
+	policy.Budget = nil
+	if !policy.ShouldRetry("cf_api", "POST", 1, http.StatusServiceUnavailable) {
+		t.Error("expected POSTs to be retried when not only retrying idempotent requests")
+	}
+
+	var none *RetryPolicy
+	if none.ShouldRetry("cf_api", "GET", 1, http.StatusServiceUnavailable) {
+		t.Error("expected no retries without a policy")
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := &RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 64: time.Second} {
+		if d := policy.Backoff(attempt); d < 0 || d > max {
+			t.Errorf("expected the backoff of attempt %d to be at most %v. Found %v", attempt, max, d)
+		}
+	}
+}
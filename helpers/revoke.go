@@ -0,0 +1,68 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultRevokeTimeout bounds how long logging out waits for UAA.
+const defaultRevokeTimeout = 5 * time.Second
+
+// TokenRevoker revokes tokens with the UAA /oauth/token/revoke endpoint
+// (RFC 7009), so a refresh token stops working once its user logs out.
+type TokenRevoker struct {
+	// URL is the full URL of the revocation endpoint.
+	URL          string
+	ClientID     string
+	ClientSecret string
+	// Timeout is how long to wait for the endpoint.
+	Timeout time.Duration
+	Client  *http.Client
+}
+
+// NewTokenRevoker creates a TokenRevoker for the revocation endpoint, which
+// defaults to UAA's when revokeURL is empty.
+func NewTokenRevoker(uaaURL, revokeURL, clientID, clientSecret string, client *http.Client) *TokenRevoker {
+	if revokeURL == "" {
+		revokeURL = strings.TrimRight(uaaURL, "/") + "/oauth/token/revoke"
+	}
+	return &TokenRevoker{
+		URL:          revokeURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Timeout:      defaultRevokeTimeout,
+		Client:       client,
+	}
+}
+
+// Revoke revokes the token. The hint is the type of the token,
+// refresh_token or access_token.
+func (r *TokenRevoker) Revoke(ctx context.Context, token, hint string) error {
+	req, err := http.NewRequest("POST", r.URL,
+		strings.NewReader(url.Values{"token": {token}, "token_type_hint": {hint}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(r.ClientID, r.ClientSecret)
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from token revocation: %d", res.StatusCode)
+	}
+	return nil
+}
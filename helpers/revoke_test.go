@@ -0,0 +1,41 @@
+package helpers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestTokenRevoker(t *testing.T) {
+	uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth/token/revoke" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" {
+			t.Errorf("Unexpected client %s:%s", id, secret)
+		}
+		r.ParseForm()
+		if r.Form.Get("token_type_hint") != "refresh_token" {
+			t.Errorf("Unexpected hint %s", r.Form.Get("token_type_hint"))
+		}
+		if r.Form.Get("token") != "refresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer uaa.Close()
+
+	revoker := helpers.NewTokenRevoker(uaa.URL, "", "client", "secret", nil)
+	if err := revoker.Revoke(context.Background(), "refresh", "refresh_token"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := revoker.Revoke(context.Background(), "other", "refresh_token"); err == nil {
+		t.Error("Expected an error when UAA refuses the revocation")
+	}
+
+	if revoker := helpers.NewTokenRevoker(uaa.URL, "https://revoke.example.com/revoke", "client", "secret", nil); revoker.URL != "https://revoke.example.com/revoke" {
+		t.Errorf("Expected the configured endpoint. Found %s", revoker.URL)
+	}
+}
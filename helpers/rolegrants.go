@@ -0,0 +1,146 @@
+package helpers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var roleGrantLog = logging.New("role_grants")
+
+// DefaultRoleGrantCheckInterval is how often the expired role grants are
+// revoked, unless configured otherwise.
+const DefaultRoleGrantCheckInterval = time.Minute
+
+// RoleMembersPath returns the CF API path listing the users with the grant's
+// role, e.g. /v2/spaces/:guid/developers.
+func RoleMembersPath(g db.RoleGrant) string {
+	if g.SpaceGUID != "" {
+		return "/v2/spaces/" + url.PathEscape(g.SpaceGUID) + "/" + g.Role
+	}
+	return "/v2/organizations/" + url.PathEscape(g.OrgGUID) + "/" + g.Role
+}
+
+// RoleGrantPath returns the CF API path of the grant's role, e.g.
+// /v2/spaces/:guid/developers/:user_guid, which is PUT to give the role and
+// DELETEd to revoke it.
+func RoleGrantPath(g db.RoleGrant) string {
+	return RoleMembersPath(g) + "/" + url.PathEscape(g.UserID)
+}
+
+// OrgUsersPath returns the CF API path listing the members of the org.
+func OrgUsersPath(orgGUID string) string {
+	return "/v2/organizations/" + url.PathEscape(orgGUID) + "/users"
+}
+
+// OrgUserPath returns the CF API path of the user's membership of the org.
+func OrgUserPath(orgGUID, userID string) string {
+	return OrgUsersPath(orgGUID) + "/" + url.PathEscape(userID)
+}
+
+// RoleGrants revokes the temporary role grants of org managers. The expired
+// grants are revoked every Interval with the dashboard's own credentials,
+// since the manager who granted them may not be logged in.
+type RoleGrants struct {
+	Store    db.RoleGrantStore
+	APIURL   string
+	Client   *http.Client
+	Interval time.Duration
+	// Record records the audit events of the revocations.
+	Record func(req *http.Request, actor, action string, details interface{})
+	// AdminAudit records the expirations as privileged actions.
+	AdminAudit *AdminAudit
+}
+
+// NewRoleGrants creates RoleGrants checking for expired grants every
+// DefaultRoleGrantCheckInterval.
+func NewRoleGrants(store db.RoleGrantStore, apiURL string, client *http.Client) *RoleGrants {
+	return &RoleGrants{Store: store, APIURL: apiURL, Client: client, Interval: DefaultRoleGrantCheckInterval}
+}
+
+// Revoke removes the grant's role from the user and marks the grant ended
+// with the status, revoked or expired, by endedBy. The user is also removed
+// from the org if the grant added them to it and none of their other grants
+// in the org is active. It returns db.ErrRoleGrantEnded if another instance
+// ended the grant first.
+func (r *RoleGrants) Revoke(g db.RoleGrant, status, endedBy string) (db.RoleGrant, error) {
+	if err := r.deleteCC(RoleGrantPath(g)); err != nil {
+		return g, fmt.Errorf("could not revoke the role: %v", err)
+	}
+	ended, err := r.Store.EndRoleGrant(g.ID, status, endedBy)
+	if err != nil || !g.AddedToOrg {
+		return ended, err
+	}
+	active, err := r.Store.RoleGrants(db.RoleGrantFilter{UserID: g.UserID, OrgGUID: g.OrgGUID, Status: db.RoleGrantActive})
+	if err != nil || len(active) > 0 {
+		return ended, nil
+	}
+	// The CF API keeps members with other roles, e.g. given since the grant.
+	if err := r.deleteCC(OrgUserPath(g.OrgGUID, g.UserID)); err != nil {
+		roleGrantLog.Warnf("could not remove user %s from org %s after role grant %s ended: %v", g.UserID, g.OrgGUID, g.ID, err)
+	}
+	return ended, nil
+}
+
+// ExpireDue revokes the grants that expired by now. It goes on with the
+// other grants when one can't be revoked, so it's retried at the next check,
+// and returns how many it revoked.
+func (r *RoleGrants) ExpireDue(now time.Time) (int, error) {
+	due, err := r.Store.DueRoleGrants(now)
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	for _, g := range due {
+		g, err := r.Revoke(g, db.RoleGrantExpired, "")
+		if err == db.ErrRoleGrantEnded {
+			continue
+		}
+		if err != nil {
+			roleGrantLog.Warnf("could not expire role grant %s of user %s: %v", g.ID, g.UserID, err)
+			continue
+		}
+		expired++
+		// Recorded for the manager who granted the role, so it shows in
+		// their activity next to the grant.
+		if r.Record != nil {
+			r.Record(nil, g.GrantedBy, "expire_role_grant", g)
+		}
+		r.AdminAudit.Record(nil, "", "expire_role_grant", g.UserID, g)
+	}
+	return expired, nil
+}
+
+// deleteCC deletes the CF API resource at path. Resources that are already
+// gone are fine.
+func (r *RoleGrants) deleteCC(path string) error {
+	req, err := http.NewRequest("DELETE", r.APIURL+path, nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return fmt.Errorf("unexpected status %d from %s", res.StatusCode, req.URL.Path)
+}
+
+// Start revokes the expired grants every interval, in the background.
+func (r *RoleGrants) Start() {
+	go func() {
+		for range time.Tick(r.Interval) {
+			if _, err := r.ExpireDue(time.Now()); err != nil {
+				roleGrantLog.Warnf("could not look for expired role grants: %v", err)
+			}
+		}
+	}()
+}
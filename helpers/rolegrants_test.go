@@ -0,0 +1,46 @@
+package helpers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+func TestRoleGrantsExpireDue(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			deleted = append(deleted, r.URL.Path)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	store := &db.MemoryRoleGrantStore{}
+	now := time.Now()
+	expired, _ := store.CreateRoleGrant(db.RoleGrant{UserID: "u1", OrgGUID: "org-1", Role: "auditors", GrantedBy: "m1", ExpiresAt: now.Add(-time.Minute)})
+	store.CreateRoleGrant(db.RoleGrant{UserID: "u2", OrgGUID: "org-1", Role: "auditors", ExpiresAt: now.Add(time.Hour)})
+
+	var recorded []string
+	grants := NewRoleGrants(store, server.URL, server.Client())
+	grants.Record = func(req *http.Request, actor, action string, details interface{}) {
+		recorded = append(recorded, actor+" "+action)
+	}
+	if n, err := grants.ExpireDue(now); n != 1 || err != nil {
+		t.Fatalf("Expected 1 grant to expire. Found %d, %v", n, err)
+	}
+	if len(deleted) != 1 || deleted[0] != "/v2/organizations/org-1/auditors/u1" {
+		t.Errorf("Expected the expired role to be revoked. Found %v", deleted)
+	}
+	if len(recorded) != 1 || recorded[0] != "m1 expire_role_grant" {
+		t.Errorf("Expected the expiry to be audited. Found %v", recorded)
+	}
+	if g, _ := store.RoleGrant(expired.ID); g.Status != db.RoleGrantExpired || g.EndedAt == nil {
+		t.Errorf("Expected the grant to be expired. Found %+v", g)
+	}
+	if n, _ := grants.ExpireDue(now); n != 0 {
+		t.Errorf("Expected nothing left to expire. Found %d", n)
+	}
+}
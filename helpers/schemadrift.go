@@ -0,0 +1,273 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var schemaLog = logging.New("schema")
+
+// DefaultSchemaCheckInterval is how often the upstream responses are checked
+// for drift after the startup.
+const DefaultSchemaCheckInterval = time.Hour
+
+// AlertSchemaDrift is the alert of an upstream response not having the
+// expected shape.
+const AlertSchemaDrift = "schema_drift"
+
+var schemaDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "dashboard_upstream_schema_drift",
+	Help: "1 while a canonical CF API or UAA response doesn't have the shape the dashboard expects, by check.",
+}, []string{"check"})
+
+func init() {
+	prometheus.MustRegister(schemaDrift)
+}
+
+// SchemaCheck is a canonical upstream response and the shape the dashboard
+// relies on it having.
+type SchemaCheck struct {
+	Name string
+	URL  string
+	// Privileged checks are made with the dashboard's own credentials.
+	Privileged bool
+	// Fields are the JSON kinds (string, number, bool, object, array or
+	// null, or several separated by |) expected at paths of the response.
+	// "[]" in a path stands for each element of an array, e.g.
+	// resources[].guid.
+	Fields map[string]string
+}
+
+// SchemaDriftDetector checks canonical CF API and UAA responses, such as
+// their versions and pagination, against the shapes the dashboard expects,
+// to warn early when an upgrade of the platform is about to break the
+// dashboard's aggregates.
+type SchemaDriftDetector struct {
+	Checks []SchemaCheck
+	// Client makes the checks that aren't privileged, and PrivilegedClient
+	// the others.
+	Client           *http.Client
+	PrivilegedClient *http.Client
+	Interval         time.Duration
+	// Notifier is alerted when drift starts and stops, if set.
+	Notifier *WebhookNotifier
+	// Source tells which dashboard the alerts come from, e.g. its URL.
+	Source string
+
+	mu      sync.Mutex
+	drifted map[string]bool
+}
+
+// NewSchemaDriftDetector creates a SchemaDriftDetector of the CF API and UAA
+// responses the dashboard relies on the most.
+func NewSchemaDriftDetector(s *Settings) *SchemaDriftDetector {
+	return &SchemaDriftDetector{
+		Checks: []SchemaCheck{
+			{Name: "cf_api_info", URL: s.ConsoleAPI + "/v2/info", Fields: map[string]string{
+				"api_version":            "string",
+				"authorization_endpoint": "string",
+				"token_endpoint":         "string",
+			}},
+			{Name: "cf_api_root", URL: s.ConsoleAPI + "/", Fields: map[string]string{
+				"links.cloud_controller_v3.href":         "string",
+				"links.cloud_controller_v3.meta.version": "string",
+			}},
+			{Name: "cf_api_v2_pagination", URL: s.ConsoleAPI + "/v2/organizations?results-per-page=1", Privileged: true,
+				Fields: map[string]string{
+					"total_results":             "number",
+					"total_pages":               "number",
+					"next_url":                  "string|null",
+					"resources":                 "array",
+					"resources[].metadata.guid": "string",
+					"resources[].entity.name":   "string",
+				}},
+			{Name: "cf_api_v3_pagination", URL: s.ConsoleAPI + "/v3/organizations?per_page=1", Privileged: true,
+				Fields: map[string]string{
+					"pagination.total_results": "number",
+					"pagination.total_pages":   "number",
+					"pagination.next":          "object|null",
+					"resources":                "array",
+					"resources[].guid":         "string",
+					"resources[].name":         "string",
+				}},
+			{Name: "uaa_info", URL: s.UaaURL + "/info", Fields: map[string]string{
+				"app.version": "string",
+				"links":       "object",
+			}},
+		},
+		Client:           s.HTTPClient,
+		PrivilegedClient: s.HighPrivilegedOauthConfig.Client(s.CreateContext()),
+		Interval:         DefaultSchemaCheckInterval,
+		Source:           s.AppURL,
+		drifted:          make(map[string]bool),
+	}
+}
+
+// Check makes all the checks and returns the drift found, one description
+// per difference. Checks whose upstream can't be reached are logged, but
+// don't count as drift.
+func (d *SchemaDriftDetector) Check() []string {
+	var all []string
+	for _, check := range d.Checks {
+		client := d.Client
+		if check.Privileged {
+			client = d.PrivilegedClient
+		}
+		doc, err := fetchSchemaCheck(client, check.URL)
+		if err != nil {
+			schemaLog.Warnf("unable to check %s: %v", check.Name, err)
+			continue
+		}
+		drift := CompareSchema(doc, check.Fields)
+		for i := range drift {
+			drift[i] = check.Name + ": " + drift[i]
+		}
+		d.record(check.Name, drift)
+		all = append(all, drift...)
+	}
+	return all
+}
+
+// record updates the metric of the check and alerts when its drift starts
+// or stops.
+func (d *SchemaDriftDetector) record(name string, drift []string) {
+	drifted := len(drift) > 0
+	value := 0.0
+	if drifted {
+		value = 1
+	}
+	schemaDrift.WithLabelValues(name).Set(value)
+
+	d.mu.Lock()
+	changed := d.drifted[name] != drifted
+	d.drifted[name] = drifted
+	d.mu.Unlock()
+	if !changed || d.Notifier == nil {
+		return
+	}
+	alert := Alert{Name: AlertSchemaDrift, Value: value, Source: d.Source, Time: time.Now().UTC(), State: AlertResolved}
+	text := fmt.Sprintf("[%s] The %s response has the expected shape again.", alert.State, name)
+	if drifted {
+		alert.State = AlertFiring
+		text = fmt.Sprintf("[%s] The %s response changed shape: %s.", alert.State, name, strings.Join(drift, "; "))
+	}
+	if d.Source != "" {
+		text += " (" + d.Source + ")"
+	}
+	if err := d.Notifier.Notify(text, alert); err != nil {
+		schemaLog.Errorf("unable to alert about the schema drift of %s: %v", name, err)
+	}
+}
+
+// Start checks every Interval until the process exits.
+func (d *SchemaDriftDetector) Start() {
+	go func() {
+		for range time.Tick(d.Interval) {
+			for _, drift := range d.Check() {
+				schemaLog.Warnf("upstream schema drift: %s", drift)
+			}
+		}
+	}()
+}
+
+func fetchSchemaCheck(client *http.Client, url string) (interface{}, error) {
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	var doc interface{}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("the response isn't JSON: %v", err)
+	}
+	return doc, nil
+}
+
+// CompareSchema returns the differences between the decoded JSON document
+// and the expected kinds of its fields, sorted.
+func CompareSchema(doc interface{}, fields map[string]string) []string {
+	var drift []string
+	for path, expected := range fields {
+		for _, value := range schemaValues(doc, strings.Split(path, "."), path) {
+			if value.missing {
+				drift = append(drift, value.path+" is missing")
+				continue
+			}
+			if kind := jsonKind(value.value); !strings.Contains("|"+expected+"|", "|"+kind+"|") {
+				drift = append(drift, fmt.Sprintf("%s is %s, expected %s", value.path, kind, expected))
+			}
+		}
+	}
+	sort.Strings(drift)
+	return drift
+}
+
+// schemaValue is a value found at a path, or where one is missing.
+type schemaValue struct {
+	path    string
+	value   interface{}
+	missing bool
+}
+
+// schemaValues returns the values at the path of the document. Each element
+// of the arrays marked with "[]" is followed, so an empty array has no
+// values to check.
+func schemaValues(doc interface{}, path []string, fullPath string) []schemaValue {
+	if len(path) == 0 {
+		return []schemaValue{{path: fullPath, value: doc}}
+	}
+	key := path[0]
+	each := strings.HasSuffix(key, "[]")
+	key = strings.TrimSuffix(key, "[]")
+	object, ok := doc.(map[string]interface{})
+	if !ok {
+		return []schemaValue{{path: fullPath, missing: true}}
+	}
+	value, ok := object[key]
+	if !ok {
+		return []schemaValue{{path: fullPath, missing: true}}
+	}
+	if !each {
+		return schemaValues(value, path[1:], fullPath)
+	}
+	elements, ok := value.([]interface{})
+	if !ok {
+		return []schemaValue{{path: fullPath, missing: true}}
+	}
+	var values []schemaValue
+	for _, element := range elements {
+		values = append(values, schemaValues(element, path[1:], fullPath)...)
+	}
+	return values
+}
+
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
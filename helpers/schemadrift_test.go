@@ -0,0 +1,69 @@
+package helpers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCompareSchema(t *testing.T) {
+	fields := map[string]string{
+		"total_results":             "number",
+		"next_url":                  "string|null",
+		"resources[].metadata.guid": "string",
+	}
+	for i, test := range []struct {
+		doc   string
+		drift []string
+	}{
+		{`{"total_results": 2, "next_url": null, "resources": [{"metadata": {"guid": "a"}}]}`, nil},
+		{`{"total_results": 2, "next_url": "/v2/x?page=2", "resources": []}`, nil},
+		{`{"total_results": "2", "resources": [{"metadata": {"guid": "a"}}, {"metadata": {"id": "b"}}]}`, []string{
+			"next_url is missing",
+			"resources[].metadata.guid is missing",
+			"total_results is string, expected number",
+		}},
+		{`{"total_results": 2, "next_url": null, "resources": {"metadata": {"guid": "a"}}}`, []string{
+			"resources[].metadata.guid is missing",
+		}},
+	} {
+		var doc interface{}
+		json.Unmarshal([]byte(test.doc), &doc)
+		if drift := CompareSchema(doc, fields); !reflect.DeepEqual(drift, test.drift) {
+			t.Errorf("%d: expected drift %q. Found %q", i, test.drift, drift)
+		}
+	}
+}
+
+func TestSchemaDriftDetectorCheck(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/info":
+			w.Write([]byte(`{"api_version": "2.150.0"}`))
+		case "/info":
+			w.Write([]byte(`{"app": {"version": "76.0.0"}, "links": {}}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+	detector := &SchemaDriftDetector{
+		Checks: []SchemaCheck{
+			{Name: "cf_api_info", URL: upstream.URL + "/v2/info", Fields: map[string]string{"api_version": "number"}},
+			{Name: "uaa_info", URL: upstream.URL + "/info", Fields: map[string]string{"app.version": "string"}},
+			// Unreachable checks aren't drift.
+			{Name: "down", URL: upstream.URL + "/down", Fields: map[string]string{"x": "string"}},
+		},
+		Client:  http.DefaultClient,
+		drifted: make(map[string]bool),
+	}
+	expected := []string{"cf_api_info: api_version is string, expected number"}
+	if drift := detector.Check(); !reflect.DeepEqual(drift, expected) {
+		t.Errorf("expected drift %q. Found %q", expected, drift)
+	}
+	if !detector.drifted["cf_api_info"] || detector.drifted["uaa_info"] {
+		t.Errorf("expected only cf_api_info to have drifted. Found %v", detector.drifted)
+	}
+}
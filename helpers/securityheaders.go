@@ -0,0 +1,124 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHSTSMaxAge is how long browsers only use HTTPS for the dashboard
+	// once they've seen it over HTTPS.
+	DefaultHSTSMaxAge = 365 * 24 * time.Hour
+
+	// googleAnalyticsSource serves the GA script and receives its hits.
+	googleAnalyticsSource = "https://www.google-analytics.com"
+	// newRelicScriptSource serves the New Relic Browser agent.
+	newRelicScriptSource = "https://js-agent.newrelic.com"
+	// newRelicBeaconSource receives the New Relic Browser data.
+	newRelicBeaconSource = "https://bam.nr-data.net"
+)
+
+// SecurityHeaders are the headers every response is sent with to limit what
+// browsers let the dashboard, or content injected into it, do.
+type SecurityHeaders struct {
+	// ScriptSources, ConnectSources and ImgSources are the sources allowed
+	// by the content security policy besides the dashboard itself, e.g. the
+	// analytics hosts.
+	ScriptSources  []string
+	ConnectSources []string
+	ImgSources     []string
+	// ReportURI is where browsers report content security policy violations.
+	ReportURI string
+	// ReportOnly only reports violations instead of blocking them, to try a
+	// policy out.
+	ReportOnly bool
+	// HSTSMaxAge is sent in Strict-Transport-Security. The header isn't sent
+	// when it's zero.
+	HSTSMaxAge time.Duration
+	// FrameOptions is sent in X-Frame-Options, e.g. DENY.
+	FrameOptions string
+	// ReferrerPolicy is sent in Referrer-Policy.
+	ReferrerPolicy string
+}
+
+// NewSecurityHeaders creates the headers with the defaults: the dashboard
+// can't be framed and only sends its origin as referrer to other sites.
+func NewSecurityHeaders() *SecurityHeaders {
+	return &SecurityHeaders{
+		FrameOptions:   "DENY",
+		ReferrerPolicy: "strict-origin-when-cross-origin",
+	}
+}
+
+// AllowGoogleAnalytics allows the GA script and its hits.
+func (h *SecurityHeaders) AllowGoogleAnalytics() {
+	h.ScriptSources = append(h.ScriptSources, googleAnalyticsSource)
+	h.ConnectSources = append(h.ConnectSources, googleAnalyticsSource)
+	h.ImgSources = append(h.ImgSources, googleAnalyticsSource)
+}
+
+// AllowNewRelic allows the New Relic Browser agent and its data.
+func (h *SecurityHeaders) AllowNewRelic() {
+	h.ScriptSources = append(h.ScriptSources, newRelicScriptSource)
+	h.ConnectSources = append(h.ConnectSources, newRelicBeaconSource)
+	h.ImgSources = append(h.ImgSources, newRelicBeaconSource)
+}
+
+// ContentSecurityPolicy returns the policy for a response whose inline
+// scripts carry the nonce. No inline script runs without a nonce. Inline
+// styles are allowed, for the theme colors and the frontend's style
+// attributes.
+func (h *SecurityHeaders) ContentSecurityPolicy(nonce string) string {
+	scriptSources := []string{"script-src 'self'"}
+	if nonce != "" {
+		scriptSources = append(scriptSources, "'nonce-"+nonce+"'")
+	}
+	directives := []string{
+		"default-src 'self'",
+		strings.Join(append(scriptSources, h.ScriptSources...), " "),
+		"style-src 'self' 'unsafe-inline'",
+		strings.Join(append([]string{"img-src 'self' data:"}, h.ImgSources...), " "),
+		strings.Join(append([]string{"connect-src 'self'"}, h.ConnectSources...), " "),
+		"font-src 'self' data:",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+	}
+	if h.ReportURI != "" {
+		directives = append(directives, "report-uri "+h.ReportURI)
+	}
+	return strings.Join(directives, "; ")
+}
+
+// Write sets the headers of a response whose inline scripts carry the nonce.
+func (h *SecurityHeaders) Write(header http.Header, nonce string) {
+	if h.ReportOnly {
+		header.Set("Content-Security-Policy-Report-Only", h.ContentSecurityPolicy(nonce))
+	} else {
+		header.Set("Content-Security-Policy", h.ContentSecurityPolicy(nonce))
+	}
+	if h.HSTSMaxAge > 0 {
+		header.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d", int64(h.HSTSMaxAge/time.Second)))
+	}
+	if h.FrameOptions != "" {
+		header.Set("X-Frame-Options", h.FrameOptions)
+	}
+	if h.ReferrerPolicy != "" {
+		header.Set("Referrer-Policy", h.ReferrerPolicy)
+	}
+	header.Set("X-Content-Type-Options", "nosniff")
+}
+
+// NewCSPNonce returns a random nonce for the inline scripts of a response.
+func NewCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,66 @@
+package helpers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	headers := helpers.NewSecurityHeaders()
+	headers.AllowGoogleAnalytics()
+	header := http.Header{}
+	headers.Write(header, "abc")
+
+	csp := header.Get("Content-Security-Policy")
+	for _, directive := range []string{
+		"default-src 'self'",
+		"script-src 'self' 'nonce-abc' https://www.google-analytics.com",
+		"connect-src 'self' https://www.google-analytics.com",
+		"frame-ancestors 'none'",
+	} {
+		if !strings.Contains(csp, directive) {
+			t.Errorf("Expected %q in the policy. Found %q", directive, csp)
+		}
+	}
+	if strings.Contains(csp, "newrelic") {
+		t.Errorf("Expected New Relic not to be allowed. Found %q", csp)
+	}
+	if header.Get("Strict-Transport-Security") != "" {
+		t.Errorf("Expected no HSTS by default. Found %q", header.Get("Strict-Transport-Security"))
+	}
+	if header.Get("X-Frame-Options") != "DENY" || header.Get("X-Content-Type-Options") != "nosniff" ||
+		header.Get("Referrer-Policy") != "strict-origin-when-cross-origin" {
+		t.Errorf("Unexpected headers %v", header)
+	}
+
+	headers.HSTSMaxAge = time.Hour
+	headers.ReportOnly = true
+	headers.ReportURI = "https://reports.agency.gov/csp"
+	header = http.Header{}
+	headers.Write(header, "")
+	if header.Get("Strict-Transport-Security") != "max-age=3600" {
+		t.Errorf("Unexpected HSTS %q", header.Get("Strict-Transport-Security"))
+	}
+	if header.Get("Content-Security-Policy") != "" {
+		t.Errorf("Expected the policy to only be reported. Found %v", header)
+	}
+	csp = header.Get("Content-Security-Policy-Report-Only")
+	if strings.Contains(csp, "nonce-") || !strings.HasSuffix(csp, "; report-uri https://reports.agency.gov/csp") {
+		t.Errorf("Unexpected policy %q", csp)
+	}
+}
+
+func TestNewCSPNonce(t *testing.T) {
+	first, err := helpers.NewCSPNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := helpers.NewCSPNonce()
+	if len(first) != 24 || first == second {
+		t.Errorf("Expected random nonces. Found %q and %q", first, second)
+	}
+}
@@ -0,0 +1,320 @@
+package helpers
+
+import (
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// The session backends SESSION_BACKEND selects.
+const (
+	CookieSessionBackend   = "cookie"
+	RedisSessionBackend    = "redis"
+	PostgresSessionBackend = "postgres"
+)
+
+// defaultSessionMaxAge is how long sessions last, the same as the cookie
+// store's default.
+const defaultSessionMaxAge = 86400 * 30
+
+// SessionBackend keeps encoded sessions server-side, by session ID.
+// db.SQLSessionStore and db.MemorySessionStore are SessionBackends too.
+type SessionBackend interface {
+	// Session returns the encoded session, or nil if it doesn't exist or
+	// expired.
+	Session(id string) ([]byte, error)
+	// SaveSession saves the encoded session until it expires.
+	SaveSession(id string, data []byte, ttl time.Duration) error
+	// DeleteSession drops the session.
+	DeleteSession(id string) error
+}
+
+// ServerSideStore is a session store that keeps the sessions in a
+// SessionBackend. Only the signed session ID is sent in the cookie, so
+// sessions are not limited by the size of a cookie and tokens never leave
+// the server.
+type ServerSideStore struct {
+	Backend SessionBackend
+	// Codecs sign the session ID in the cookie, and sign and encrypt the
+	// sessions in the backend.
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	// Capacity counts the sessions and caps them. Optional.
+	Capacity *SessionCapacity
+	// MigrateCookies accepts the sessions still kept in cookies from before
+	// the switch to the backend, with the same keys, so their users aren't
+	// logged out. MigrateCookieSession moves them into the backend.
+	MigrateCookies bool
+}
+
+// NewServerSideStore creates a ServerSideStore with the same key pairs as
+// the cookie store.
+func NewServerSideStore(backend SessionBackend, keyPairs ...[]byte) *ServerSideStore {
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			// The backend holds sessions of any size.
+			sc.MaxLength(0)
+		}
+	}
+	return &ServerSideStore{
+		Backend: backend,
+		Codecs:  codecs,
+		Options: &sessions.Options{Path: "/", MaxAge: defaultSessionMaxAge},
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (s *ServerSideStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session the request's cookie points to, or a new session if
+// there is none or it expired.
+func (s *ServerSideStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	start := time.Now()
+	session, err := s.load(r, name)
+	observeSessionOperation(sessionLoad, start, err)
+	return session, err
+}
+
+func (s *ServerSideStore) load(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.Codecs...); err != nil {
+		if values, ok := s.decodeCookieSession(name, cookie.Value); ok {
+			// The session has no ID until it's saved in the backend.
+			session.Values = values
+			session.IsNew = false
+			return session, nil
+		}
+		return session, err
+	}
+	data, err := s.Backend.Session(id)
+	if err != nil || data == nil {
+		if err == nil && s.Capacity != nil {
+			s.Capacity.Expired(id)
+		}
+		return session, err
+	}
+	if s.Capacity != nil {
+		s.Capacity.Used(id)
+	}
+	if err := securecookie.DecodeMulti(name, string(data), &session.Values, s.Codecs...); err != nil {
+		return session, err
+	}
+	session.ID = id
+	session.IsNew = false
+	return session, nil
+}
+
+// Save saves the session in the backend and its ID in the cookie. Sessions
+// with a negative MaxAge are deleted.
+func (s *ServerSideStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	start := time.Now()
+	err := s.save(r, w, session)
+	observeSessionOperation(sessionSaveOperation(session), start, err)
+	return err
+}
+
+func (s *ServerSideStore) save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if err := s.Backend.DeleteSession(session.ID); err != nil {
+				return err
+			}
+			if s.Capacity != nil {
+				s.Capacity.Deleted(session.ID)
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if session.ID == "" {
+		session.ID = newSessionID()
+	}
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	ttl := time.Duration(session.Options.MaxAge) * time.Second
+	if s.Capacity != nil {
+		evicted, err := s.Capacity.Saved(session.ID, ttl)
+		if err != nil {
+			return err
+		}
+		for _, id := range evicted {
+			if err := s.Backend.DeleteSession(id); err != nil {
+				sessionLog.Errorf("unable to delete an evicted session: %v", err)
+			}
+		}
+	}
+	if err := s.Backend.SaveSession(session.ID, []byte(data), ttl); err != nil {
+		if s.Capacity != nil {
+			s.Capacity.Deleted(session.ID)
+		}
+		return err
+	}
+	encodedID, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encodedID, session.Options))
+	return nil
+}
+
+// decodeCookieSession decodes a session kept in a cookie, if cookie sessions
+// are migrated.
+func (s *ServerSideStore) decodeCookieSession(name, value string) (map[interface{}]interface{}, bool) {
+	if !s.MigrateCookies {
+		return nil, false
+	}
+	values := make(map[interface{}]interface{})
+	if err := securecookie.DecodeMulti(name, value, &values, s.Codecs...); err != nil {
+		return nil, false
+	}
+	return values, true
+}
+
+// MigrateCookieSession moves the request's session into the backend if it's
+// still kept in a cookie, and replaces the cookie with one of the session ID,
+// in the response and in the request for the rest of its handling.
+func (s *ServerSideStore) MigrateCookieSession(w http.ResponseWriter, r *http.Request, name string) error {
+	session, _ := s.Get(r, name)
+	if session == nil || session.IsNew || session.ID != "" {
+		return nil
+	}
+	start := time.Now()
+	err := s.save(r, w, session)
+	observeSessionOperation(sessionMigrate, start, err)
+	if err != nil {
+		return err
+	}
+	encodedID, err := securecookie.EncodeMulti(name, session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			cookie.Value = encodedID
+		}
+		r.AddCookie(cookie)
+	}
+	return nil
+}
+
+// RenewSessionID gives a server-side session a new ID when it's next saved,
+// dropping the old one, so an ID planted before the login can't be used
+// after it. Sessions kept in cookies have no ID to renew.
+func RenewSessionID(session *sessions.Session) error {
+	store, ok := session.Store().(*ServerSideStore)
+	if !ok || session.ID == "" {
+		return nil
+	}
+	if err := store.Backend.DeleteSession(session.ID); err != nil {
+		return err
+	}
+	if store.Capacity != nil {
+		store.Capacity.Deleted(session.ID)
+	}
+	session.ID = ""
+	return nil
+}
+
+func newSessionID() string {
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+}
+
+// redisSessionKeyPrefix namespaces the sessions in Redis, so the database
+// can be shared.
+const redisSessionKeyPrefix = "cg-dashboard:session:"
+
+// RedisSessions keeps the sessions in Redis, where they expire on their own.
+type RedisSessions struct {
+	Pool *redis.Pool
+}
+
+// NewRedisSessions connects to Redis at the URL, e.g.
+// redis://:password@host:6379/0.
+func NewRedisSessions(redisURL string) (*RedisSessions, error) {
+	pool, err := newRedisPool(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisSessions{Pool: pool}, nil
+}
+
+// newRedisPool connects to Redis at the URL, checking it answers.
+func newRedisPool(redisURL string) (*redis.Pool, error) {
+	pool := &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 4 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(redisURL,
+				redis.DialConnectTimeout(5*time.Second),
+				redis.DialReadTimeout(5*time.Second),
+				redis.DialWriteTimeout(5*time.Second))
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// Session returns the encoded session, or nil if it doesn't exist or
+// expired.
+func (s *RedisSessions) Session(id string) ([]byte, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+	data, err := redis.Bytes(conn.Do("GET", redisSessionKeyPrefix+id))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	return data, err
+}
+
+// SaveSession saves the encoded session until it expires.
+func (s *RedisSessions) SaveSession(id string, data []byte, ttl time.Duration) error {
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		return errors.New("sessions must last at least a second")
+	}
+	conn := s.Pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", redisSessionKeyPrefix+id, data, "EX", seconds)
+	return err
+}
+
+// DeleteSession drops the session.
+func (s *RedisSessions) DeleteSession(id string) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", redisSessionKeyPrefix+id)
+	return err
+}
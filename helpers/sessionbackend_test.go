@@ -0,0 +1,139 @@
+package helpers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/gorilla/sessions"
+)
+
+func TestServerSideStore(t *testing.T) {
+	backend := &db.MemorySessionStore{}
+	store := helpers.NewServerSideStore(backend, testSessionAuthKey, testSessionEncKey)
+
+	// Sessions of any size only send their ID.
+	large := strings.Repeat("x", 3*helpers.MaxCookieSize)
+	cookies, err := saveSession(t, store, nil, large)
+	if err != nil {
+		t.Fatalf("Expected nil error, found %s", err.Error())
+	}
+	if len(cookies) != 1 || cookies[0].Name != "session" || len(cookies[0].Value) > 512 {
+		t.Fatalf("Expected a small session cookie, found %v", cookies)
+	}
+	if value := loadSession(store, cookies); value != large {
+		t.Errorf("Expected to load the session, found %v", value)
+	}
+
+	// Saving again keeps the same ID, so the old cookie sees the change.
+	saveSession(t, store, cookies, "small")
+	if value := loadSession(store, cookies); value != "small" {
+		t.Errorf("Expected the session to keep its ID, found %v", value)
+	}
+
+	// Tampered IDs are refused.
+	tampered := []*http.Cookie{{Name: "session", Value: cookies[0].Value + "x"}}
+	if value := loadSession(store, tampered); value != nil {
+		t.Errorf("Expected a tampered cookie to be refused, found %v", value)
+	}
+
+	// Deleted sessions are gone from the backend.
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	session, _ := store.New(req, "session")
+	id := session.ID
+	session.Options.MaxAge = -1
+	if err := store.Save(req, nopResponseWriter{}, session); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := backend.Session(id); data != nil {
+		t.Error("Expected the session to be deleted")
+	}
+	if value := loadSession(store, cookies); value != nil {
+		t.Errorf("Expected no session, found %v", value)
+	}
+}
+
+func TestMigrateCookieSession(t *testing.T) {
+	legacy, _ := saveSession(t, sessions.NewCookieStore(testSessionAuthKey, testSessionEncKey), nil, "from the cookie")
+	backend := &db.MemorySessionStore{}
+	store := helpers.NewServerSideStore(backend, testSessionAuthKey, testSessionEncKey)
+
+	// Cookie sessions aren't accepted unless they're migrated.
+	if value := loadSession(store, legacy); value != nil {
+		t.Errorf("Expected the cookie session to be refused, found %v", value)
+	}
+	store.MigrateCookies = true
+	if value := loadSession(store, legacy); value != "from the cookie" {
+		t.Errorf("Expected to load the cookie session, found %v", value)
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(legacy[0])
+	req.AddCookie(&http.Cookie{Name: "other", Value: "kept"})
+	w := httptest.NewRecorder()
+	if err := store.MigrateCookieSession(w, req, "session"); err != nil {
+		t.Fatal(err)
+	}
+	cookies := (&http.Response{Header: w.Header()}).Cookies()
+	if len(cookies) != 1 || len(cookies[0].Value) >= len(legacy[0].Value) {
+		t.Fatalf("Expected a slim session cookie, found %v", cookies)
+	}
+	if value := loadSession(store, cookies); value != "from the cookie" {
+		t.Errorf("Expected the session to be in the backend, found %v", value)
+	}
+	// The rest of the request sees the new cookie.
+	if cookie, _ := req.Cookie("session"); cookie == nil || cookie.Value == legacy[0].Value {
+		t.Errorf("Expected the request's cookie to be replaced, found %v", cookie)
+	}
+	if cookie, _ := req.Cookie("other"); cookie == nil || cookie.Value != "kept" {
+		t.Errorf("Expected the other cookies to be kept, found %v", cookie)
+	}
+
+	// Sessions already in the backend are left alone.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	if err := store.MigrateCookieSession(w, req, "session"); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Header()["Set-Cookie"]) != 0 {
+		t.Errorf("Expected no new cookie, found %v", w.Header()["Set-Cookie"])
+	}
+}
+
+func TestRenewSessionID(t *testing.T) {
+	backend := &db.MemorySessionStore{}
+	store := helpers.NewServerSideStore(backend, testSessionAuthKey, testSessionEncKey)
+	cookies, _ := saveSession(t, store, nil, "before login")
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	session, _ := store.New(req, "session")
+	oldID := session.ID
+	if err := helpers.RenewSessionID(session); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(req, nopResponseWriter{}, session); err != nil {
+		t.Fatal(err)
+	}
+	if session.ID == "" || session.ID == oldID {
+		t.Errorf("Expected a new ID, found %q", session.ID)
+	}
+	if data, _ := backend.Session(oldID); data != nil {
+		t.Error("Expected the old session to be dropped")
+	}
+	if value := loadSession(store, cookies); value != nil {
+		t.Errorf("Expected the old cookie to be useless, found %v", value)
+	}
+}
+
+// nopResponseWriter discards the response.
+type nopResponseWriter struct{}
+
+func (nopResponseWriter) Header() http.Header         { return http.Header{} }
+func (nopResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (nopResponseWriter) WriteHeader(int)             {}
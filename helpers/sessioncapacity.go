@@ -0,0 +1,168 @@
+package helpers
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultSessionBusyMessage is shown to users who can't get a session while
+// the session store is at capacity, unless configured otherwise.
+const DefaultSessionBusyMessage = "The dashboard is busy right now. Try again in a few minutes."
+
+// sessionSweepInterval is how often the sessions that expired on their own
+// are dropped from the tracker.
+const sessionSweepInterval = time.Minute
+
+// ErrSessionStoreBusy is returned when saving a new session while the session
+// store is at capacity and refuses new sessions.
+var ErrSessionStoreBusy = errors.New("the session store is at capacity")
+
+var (
+	activeSessions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dashboard_sessions_active",
+		Help: "Server-side sessions created by this instance that haven't expired or been deleted.",
+	})
+	sessionLifecycle = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_session_lifecycle_total",
+		Help: "Server-side sessions created, expired, evicted and refused at capacity by this instance.",
+	}, []string{"event"})
+)
+
+func init() {
+	prometheus.MustRegister(activeSessions, sessionLifecycle)
+}
+
+// The events of the sessions' lifecycle.
+const (
+	sessionCreated = "created"
+	sessionExpired = "expired"
+	sessionEvicted = "evicted"
+	sessionRefused = "refused"
+)
+
+// SessionCapacity tracks the server-side sessions of an instance, most
+// recently used first, so they can be counted and capped. Without a cap, a
+// flood of logins, e.g. credential stuffing, fills the session store.
+type SessionCapacity struct {
+	// MaxSessions caps the sessions. No cap when zero.
+	MaxSessions int
+	// Refuse refuses new sessions at capacity with ErrSessionStoreBusy,
+	// instead of evicting the least recently used sessions.
+	Refuse bool
+
+	mu        sync.Mutex
+	lru       *list.List
+	sessions  map[string]*list.Element
+	lastSweep time.Time
+}
+
+// trackedSession is a session and when it expires.
+type trackedSession struct {
+	id        string
+	expiresAt time.Time
+}
+
+// NewSessionCapacity tracks sessions, capped at maxSessions unless it's zero.
+func NewSessionCapacity(maxSessions int, refuse bool) *SessionCapacity {
+	return &SessionCapacity{
+		MaxSessions: maxSessions,
+		Refuse:      refuse,
+		lru:         list.New(),
+		sessions:    map[string]*list.Element{},
+	}
+}
+
+// Len returns the number of tracked sessions.
+func (c *SessionCapacity) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Used marks the session as the most recently used.
+func (c *SessionCapacity) Used(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.sessions[id]; ok {
+		c.lru.MoveToFront(e)
+	}
+}
+
+// Saved tracks the session, saved until ttl from now. It returns the
+// sessions evicted to make room for a new one, or ErrSessionStoreBusy when
+// there's no room and new sessions are refused.
+func (c *SessionCapacity) Saved(id string, ttl time.Duration) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if e, ok := c.sessions[id]; ok {
+		e.Value.(*trackedSession).expiresAt = now.Add(ttl)
+		c.lru.MoveToFront(e)
+		return nil, nil
+	}
+	if c.MaxSessions > 0 && c.lru.Len() >= c.MaxSessions {
+		c.sweep(now, true)
+	}
+	var evicted []string
+	for c.MaxSessions > 0 && c.lru.Len() >= c.MaxSessions {
+		if c.Refuse {
+			sessionLifecycle.WithLabelValues(sessionRefused).Inc()
+			return nil, ErrSessionStoreBusy
+		}
+		oldest := c.lru.Remove(c.lru.Back()).(*trackedSession)
+		delete(c.sessions, oldest.id)
+		evicted = append(evicted, oldest.id)
+		sessionLifecycle.WithLabelValues(sessionEvicted).Inc()
+	}
+	c.sessions[id] = c.lru.PushFront(&trackedSession{id: id, expiresAt: now.Add(ttl)})
+	sessionLifecycle.WithLabelValues(sessionCreated).Inc()
+	c.sweep(now, false)
+	activeSessions.Set(float64(c.lru.Len()))
+	return evicted, nil
+}
+
+// Deleted stops tracking the session.
+func (c *SessionCapacity) Deleted(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.sessions[id]; ok {
+		c.lru.Remove(e)
+		delete(c.sessions, id)
+		activeSessions.Set(float64(c.lru.Len()))
+	}
+}
+
+// Expired stops tracking a session the backend no longer has.
+func (c *SessionCapacity) Expired(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.sessions[id]; ok {
+		c.lru.Remove(e)
+		delete(c.sessions, id)
+		sessionLifecycle.WithLabelValues(sessionExpired).Inc()
+		activeSessions.Set(float64(c.lru.Len()))
+	}
+}
+
+// sweep drops the sessions that expired, at most every
+// sessionSweepInterval unless forced.
+func (c *SessionCapacity) sweep(now time.Time, force bool) {
+	if !force && now.Sub(c.lastSweep) < sessionSweepInterval {
+		return
+	}
+	c.lastSweep = now
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		if s := e.Value.(*trackedSession); !now.Before(s.expiresAt) {
+			c.lru.Remove(e)
+			delete(c.sessions, s.id)
+			sessionLifecycle.WithLabelValues(sessionExpired).Inc()
+		}
+		e = next
+	}
+	activeSessions.Set(float64(c.lru.Len()))
+}
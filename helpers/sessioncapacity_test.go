@@ -0,0 +1,67 @@
+package helpers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestSessionCapacityEvicts(t *testing.T) {
+	store := helpers.NewServerSideStore(&db.MemorySessionStore{}, testSessionAuthKey, testSessionEncKey)
+	store.Capacity = helpers.NewSessionCapacity(2, false)
+
+	first, _ := saveSession(t, store, nil, "first")
+	second, _ := saveSession(t, store, nil, "second")
+	// Using the first session makes the second the least recently used.
+	loadSession(store, first)
+	if _, err := saveSession(t, store, nil, "third"); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.Capacity.Len(); n != 2 {
+		t.Errorf("Expected 2 sessions. Found %d", n)
+	}
+	if value := loadSession(store, second); value != nil {
+		t.Errorf("Expected the least recently used session to be evicted. Found %v", value)
+	}
+	if value := loadSession(store, first); value != "first" {
+		t.Errorf("Expected the first session to be kept. Found %v", value)
+	}
+
+	// Saving an existing session doesn't evict anything.
+	if _, err := saveSession(t, store, first, "again"); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.Capacity.Len(); n != 2 {
+		t.Errorf("Expected 2 sessions. Found %d", n)
+	}
+}
+
+func TestSessionCapacityRefuses(t *testing.T) {
+	store := helpers.NewServerSideStore(&db.MemorySessionStore{}, testSessionAuthKey, testSessionEncKey)
+	store.Capacity = helpers.NewSessionCapacity(1, true)
+
+	first, _ := saveSession(t, store, nil, "first")
+	if _, err := saveSession(t, store, nil, "second"); err != helpers.ErrSessionStoreBusy {
+		t.Errorf("Expected the new session to be refused. Found %v", err)
+	}
+	if value := loadSession(store, first); value != "first" {
+		t.Errorf("Expected the first session to be kept. Found %v", value)
+	}
+}
+
+func TestSessionCapacityExpiry(t *testing.T) {
+	capacity := helpers.NewSessionCapacity(1, true)
+	if _, err := capacity.Saved("expired", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// Expired sessions make room.
+	if _, err := capacity.Saved("new", time.Hour); err != nil {
+		t.Errorf("Expected the expired session to make room. Found %v", err)
+	}
+	capacity.Deleted("new")
+	if n := capacity.Len(); n != 0 {
+		t.Errorf("Expected no sessions. Found %d", n)
+	}
+}
@@ -0,0 +1,196 @@
+package helpers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var sessionLog = logging.New("sessions")
+
+const (
+	// MaxCookieSize is the largest cookie (name and value) browsers accept.
+	MaxCookieSize = 4096
+	// defaultSessionSizeWarnThreshold is the cookie size above which a
+	// warning is logged, so that growing sessions are noticed before they
+	// start failing.
+	defaultSessionSizeWarnThreshold = 3584
+	// overflowCookieSuffix is appended to the session name for the cookie
+	// that points to a session saved in the overflow store.
+	overflowCookieSuffix = "-overflow"
+)
+
+// ErrSessionTooLarge is returned when a session doesn't fit in a cookie and
+// there is no overflow store to save it to instead.
+var ErrSessionTooLarge = errors.New("session is too large to be saved in a cookie")
+
+var (
+	sessionCookieBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "dashboard_session_cookie_bytes",
+		Help:    "Size of the serialized session cookies.",
+		Buckets: []float64{512, 1024, 2048, 3072, 3584, 4096, 8192},
+	})
+	sessionOverflows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_session_overflows_total",
+		Help: "Sessions too large for a cookie, by what happened to them.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(sessionCookieBytes, sessionOverflows)
+}
+
+// SizeMonitoredStore is a session store that saves sessions in cookies and
+// measures how large they get. Sessions that don't fit in a cookie are saved
+// in the Overflow store instead, when there is one.
+type SizeMonitoredStore struct {
+	Cookies *sessions.CookieStore
+	// Overflow is an optional server-side store for oversized sessions.
+	Overflow sessions.Store
+	// WarnThreshold is the cookie size in bytes above which a warning is
+	// logged.
+	WarnThreshold int
+}
+
+// NewSizeMonitoredStore wraps the cookie store. The cookie store's own length
+// limit is lifted so that the real size can be measured, and so is the
+// filesystem store's, since its whole point is to hold large sessions.
+func NewSizeMonitoredStore(cookies *sessions.CookieStore, overflow sessions.Store) *SizeMonitoredStore {
+	for _, codec := range cookies.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxLength(0)
+		}
+	}
+	if fsStore, ok := overflow.(*sessions.FilesystemStore); ok {
+		fsStore.MaxLength(0)
+	}
+	return &SizeMonitoredStore{
+		Cookies:       cookies,
+		Overflow:      overflow,
+		WarnThreshold: defaultSessionSizeWarnThreshold,
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (m *SizeMonitoredStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(m, name)
+}
+
+// New returns a session for the given name, loaded from the overflow store if
+// the request points to a session saved there.
+func (m *SizeMonitoredStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	start := time.Now()
+	session, err := m.load(r, name)
+	observeSessionOperation(sessionLoad, start, err)
+	return session, err
+}
+
+func (m *SizeMonitoredStore) load(r *http.Request, name string) (*sessions.Session, error) {
+	var inner *sessions.Session
+	var err error
+	if m.usesOverflow(r, name) {
+		inner, err = m.Overflow.New(r, name+overflowCookieSuffix)
+	} else {
+		inner, err = m.Cookies.New(r, name)
+	}
+	// Sessions must be saved through this store to be measured.
+	session := sessions.NewSession(m, name)
+	session.ID = inner.ID
+	session.Values = inner.Values
+	session.Options = inner.Options
+	session.IsNew = inner.IsNew
+	return session, err
+}
+
+// Save saves the session in a cookie if it fits, or in the overflow store.
+func (m *SizeMonitoredStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	start := time.Now()
+	err := m.save(r, w, session)
+	observeSessionOperation(sessionSaveOperation(session), start, err)
+	return err
+}
+
+func (m *SizeMonitoredStore) save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	// Encode the cookie without writing it to measure it first.
+	rec := httptest.NewRecorder()
+	cookieSession := m.copySession(m.Cookies, session, session.Name())
+	if err := m.Cookies.Save(r, rec, cookieSession); err != nil {
+		return err
+	}
+	size := 0
+	for _, cookie := range (&http.Response{Header: rec.Header()}).Cookies() {
+		if cookie.Name == session.Name() {
+			size = len(cookie.Name) + 1 + len(cookie.Value)
+		}
+	}
+	// Deleted sessions have no size worth tracking.
+	if session.Options.MaxAge > 0 {
+		sessionCookieBytes.Observe(float64(size))
+	}
+
+	if size <= MaxCookieSize {
+		if size > m.WarnThreshold {
+			sessionLog.Warnf("session cookie is %d bytes, close to the %d bytes limit", size, MaxCookieSize)
+		}
+		copyCookies(w, rec)
+		// Remove the session from the overflow store now that it fits.
+		if m.usesOverflow(r, session.Name()) {
+			return m.saveOverflow(r, w, session, -1)
+		}
+		return nil
+	}
+
+	if m.Overflow == nil {
+		sessionOverflows.WithLabelValues("rejected").Inc()
+		sessionLog.Errorf("session cookie is %d bytes, over the %d bytes limit; the session was not saved", size, MaxCookieSize)
+		return ErrSessionTooLarge
+	}
+	sessionOverflows.WithLabelValues("server_side").Inc()
+	sessionLog.Infof("session cookie is %d bytes, over the %d bytes limit; saving it server-side", size, MaxCookieSize)
+	if err := m.saveOverflow(r, w, session, session.Options.MaxAge); err != nil {
+		return err
+	}
+	// Remove the stale cookie so the overflow session is used.
+	expired := *session.Options
+	expired.MaxAge = -1
+	http.SetCookie(w, sessions.NewCookie(session.Name(), "", &expired))
+	return nil
+}
+
+func (m *SizeMonitoredStore) usesOverflow(r *http.Request, name string) bool {
+	if m.Overflow == nil {
+		return false
+	}
+	_, err := r.Cookie(name + overflowCookieSuffix)
+	return err == nil
+}
+
+func (m *SizeMonitoredStore) saveOverflow(r *http.Request, w http.ResponseWriter, session *sessions.Session, maxAge int) error {
+	overflowSession := m.copySession(m.Overflow, session, session.Name()+overflowCookieSuffix)
+	overflowSession.Options.MaxAge = maxAge
+	return m.Overflow.Save(r, w, overflowSession)
+}
+
+// copySession returns a copy of the session that belongs to the store.
+func (m *SizeMonitoredStore) copySession(store sessions.Store, session *sessions.Session, name string) *sessions.Session {
+	c := sessions.NewSession(store, name)
+	c.ID = session.ID
+	c.Values = session.Values
+	opts := *session.Options
+	c.Options = &opts
+	c.IsNew = session.IsNew
+	return c
+}
+
+func copyCookies(w http.ResponseWriter, rec *httptest.ResponseRecorder) {
+	for _, cookie := range rec.Header()["Set-Cookie"] {
+		w.Header().Add("Set-Cookie", cookie)
+	}
+}
@@ -0,0 +1,106 @@
+package helpers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+var (
+	testSessionAuthKey = []byte("00112233445566778899aabbccddeeff")
+	testSessionEncKey  = []byte("00112233445566778899aabbccddeeff")
+)
+
+// saveSession saves the values in a new session and returns the response
+// cookies.
+func saveSession(t *testing.T, store sessions.Store, cookies []*http.Cookie, value string) ([]*http.Cookie, error) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	session, _ := store.New(req, "session")
+	session.Values["data"] = value
+	w := httptest.NewRecorder()
+	err := session.Save(req, w)
+	return (&http.Response{Header: w.Header()}).Cookies(), err
+}
+
+// loadSession returns the value saved by saveSession.
+func loadSession(store sessions.Store, cookies []*http.Cookie) interface{} {
+	req, _ := http.NewRequest("GET", "/", nil)
+	for _, cookie := range cookies {
+		if cookie.MaxAge >= 0 {
+			req.AddCookie(cookie)
+		}
+	}
+	session, _ := store.New(req, "session")
+	return session.Values["data"]
+}
+
+func TestSizeMonitoredStoreSmallSession(t *testing.T) {
+	store := helpers.NewSizeMonitoredStore(sessions.NewCookieStore(testSessionAuthKey, testSessionEncKey), nil)
+	cookies, err := saveSession(t, store, nil, "small")
+	if err != nil {
+		t.Fatalf("Expected nil error, found %s", err.Error())
+	}
+	if len(cookies) != 1 || cookies[0].Name != "session" {
+		t.Fatalf("Expected a session cookie, found %v", cookies)
+	}
+	if value := loadSession(store, cookies); value != "small" {
+		t.Errorf("Expected to load the session, found %v", value)
+	}
+}
+
+func TestSizeMonitoredStoreTooLarge(t *testing.T) {
+	store := helpers.NewSizeMonitoredStore(sessions.NewCookieStore(testSessionAuthKey, testSessionEncKey), nil)
+	cookies, err := saveSession(t, store, nil, strings.Repeat("x", helpers.MaxCookieSize))
+	if err != helpers.ErrSessionTooLarge {
+		t.Errorf("Expected ErrSessionTooLarge, found %v", err)
+	}
+	if len(cookies) != 0 {
+		t.Errorf("Expected no cookie to be set, found %v", cookies)
+	}
+}
+
+func TestSizeMonitoredStoreOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	overflow := sessions.NewFilesystemStore(dir, testSessionAuthKey, testSessionEncKey)
+	store := helpers.NewSizeMonitoredStore(sessions.NewCookieStore(testSessionAuthKey, testSessionEncKey), overflow)
+
+	large := strings.Repeat("x", helpers.MaxCookieSize)
+	cookies, err := saveSession(t, store, nil, large)
+	if err != nil {
+		t.Fatalf("Expected nil error, found %s", err.Error())
+	}
+	if value := loadSession(store, cookies); value != large {
+		t.Errorf("Expected to load the session from the overflow store")
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("Expected the session to be saved server-side, found %d files", len(files))
+	}
+
+	// Once the session fits in a cookie again, the server-side copy is removed.
+	cookies, err = saveSession(t, store, cookies, "small")
+	if err != nil {
+		t.Fatalf("Expected nil error, found %s", err.Error())
+	}
+	if value := loadSession(store, cookies); value != "small" {
+		t.Errorf("Expected to load the session from the cookie, found %v", value)
+	}
+	files, _ = ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("Expected the server-side session to be removed, found %d files", len(files))
+	}
+}
@@ -0,0 +1,119 @@
+package helpers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// DefaultSessionAbsoluteTimeout is how long a session lasts after the
+	// login, however active the user is.
+	DefaultSessionAbsoluteTimeout = 7 * 24 * time.Hour
+
+	// sessionLoginKey and sessionActivityKey hold the unix times of the
+	// login and of the last request in the session.
+	sessionLoginKey    = "logged_in_at"
+	sessionActivityKey = "last_activity_at"
+	// sessionActivityResolution is how stale the last activity can get
+	// before it's saved again, so not every request saves the session.
+	sessionActivityResolution = time.Minute
+)
+
+// noActivityKey marks requests that aren't activity of the user.
+type noActivityKey struct{}
+
+// WithoutSessionActivity marks the request as not being activity of the
+// user, e.g. the frontend polling when the session ends, so it doesn't keep
+// the session alive.
+func WithoutSessionActivity(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), noActivityKey{}, true))
+}
+
+// isSessionActivity returns false for requests marked by
+// WithoutSessionActivity.
+func isSessionActivity(r *http.Request) bool {
+	noActivity, _ := r.Context().Value(noActivityKey{}).(bool)
+	return !noActivity
+}
+
+// SessionTimeouts end sessions after the user has been inactive for Idle, or
+// Absolute after they logged in, whichever comes first. Zero timeouts never
+// end sessions.
+type SessionTimeouts struct {
+	Idle     time.Duration
+	Absolute time.Duration
+}
+
+// SessionExpiry is when a session ends and why.
+type SessionExpiry struct {
+	// ExpiresAt is nil when the session doesn't time out.
+	ExpiresAt *time.Time `json:"expires_at"`
+	// Reason is idle or absolute.
+	Reason           string `json:"reason,omitempty"`
+	RemainingSeconds int64  `json:"remaining_seconds"`
+	// IdleTimeoutSeconds is how long the session lasts without activity, or
+	// zero if it doesn't end for inactivity.
+	IdleTimeoutSeconds int64 `json:"idle_timeout_seconds"`
+}
+
+// StartSession records the login in the session.
+func StartSession(session *sessions.Session, now time.Time) {
+	session.Values[sessionLoginKey] = now.Unix()
+	session.Values[sessionActivityKey] = now.Unix()
+}
+
+// sessionTime returns the unix time of the session value, or now if it's
+// missing, e.g. for sessions created before the timeouts were tracked.
+func sessionTime(session *sessions.Session, key string, now time.Time) time.Time {
+	unix, ok := session.Values[key].(int64)
+	if !ok {
+		return now
+	}
+	return time.Unix(unix, 0)
+}
+
+// Expiry returns when the session ends if the user stays inactive.
+func (t SessionTimeouts) Expiry(session *sessions.Session, now time.Time) SessionExpiry {
+	var expiry SessionExpiry
+	if t.Absolute > 0 {
+		expiresAt := sessionTime(session, sessionLoginKey, now).Add(t.Absolute)
+		expiry.ExpiresAt, expiry.Reason = &expiresAt, "absolute"
+	}
+	if t.Idle > 0 {
+		expiry.IdleTimeoutSeconds = int64(t.Idle / time.Second)
+		idle := sessionTime(session, sessionActivityKey, now).Add(t.Idle)
+		if expiry.ExpiresAt == nil || idle.Before(*expiry.ExpiresAt) {
+			expiry.ExpiresAt, expiry.Reason = &idle, "idle"
+		}
+	}
+	if expiry.ExpiresAt != nil && expiry.ExpiresAt.After(now) {
+		expiry.RemainingSeconds = int64(expiry.ExpiresAt.Sub(now) / time.Second)
+	}
+	return expiry
+}
+
+// Expired returns true if the session has ended.
+func (t SessionTimeouts) Expired(session *sessions.Session, now time.Time) bool {
+	expiresAt := t.Expiry(session, now).ExpiresAt
+	return expiresAt != nil && !now.Before(*expiresAt)
+}
+
+// Touch records activity in the session, and returns true if the session
+// changed and needs saving. Sessions from before the timeouts were tracked
+// start being tracked now.
+func (t SessionTimeouts) Touch(session *sessions.Session, now time.Time) bool {
+	changed := false
+	if _, ok := session.Values[sessionLoginKey].(int64); !ok {
+		session.Values[sessionLoginKey] = now.Unix()
+		changed = true
+	}
+	lastActivity := sessionTime(session, sessionActivityKey, time.Time{})
+	if t.Idle > 0 && now.Sub(lastActivity) >= sessionActivityResolution {
+		session.Values[sessionActivityKey] = now.Unix()
+		changed = true
+	}
+	return changed
+}
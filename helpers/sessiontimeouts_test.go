@@ -0,0 +1,55 @@
+package helpers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestSessionTimeouts(t *testing.T) {
+	timeouts := helpers.SessionTimeouts{Idle: 30 * time.Minute, Absolute: 12 * time.Hour}
+	login := time.Date(2018, 3, 1, 9, 0, 0, 0, time.UTC)
+	session := sessions.NewSession(nil, "session")
+	helpers.StartSession(session, login)
+
+	expiry := timeouts.Expiry(session, login.Add(10*time.Minute))
+	if expiry.Reason != "idle" || !expiry.ExpiresAt.Equal(login.Add(30*time.Minute)) || expiry.RemainingSeconds != 20*60 || expiry.IdleTimeoutSeconds != 30*60 {
+		t.Errorf("Unexpected expiry %+v", expiry)
+	}
+	if timeouts.Expired(session, login.Add(29*time.Minute)) || !timeouts.Expired(session, login.Add(30*time.Minute)) {
+		t.Error("Expected the session to end after 30 minutes without activity")
+	}
+
+	// Activity is only saved again once it's stale.
+	if timeouts.Touch(session, login.Add(30*time.Second)) {
+		t.Error("Expected recent activity not to be saved again")
+	}
+	// Stay active all day.
+	now := login
+	for ; now.Before(login.Add(12 * time.Hour)); now = now.Add(20 * time.Minute) {
+		if timeouts.Expired(session, now) {
+			t.Fatalf("Expected an active session to last. It ended at %s", now)
+		}
+		timeouts.Touch(session, now)
+	}
+	if expiry := timeouts.Expiry(session, now); expiry.Reason != "absolute" || !timeouts.Expired(session, now) {
+		t.Errorf("Expected the session to end 12 hours after the login. Found %+v", expiry)
+	}
+
+	// Sessions from before the timeouts were tracked start being tracked.
+	session = sessions.NewSession(nil, "session")
+	if timeouts.Expired(session, now) || !timeouts.Touch(session, now) {
+		t.Error("Expected an untracked session to start being tracked")
+	}
+	if expiry := timeouts.Expiry(session, now); !expiry.ExpiresAt.Equal(now.Add(30 * time.Minute)) {
+		t.Errorf("Unexpected expiry %+v", expiry)
+	}
+
+	// Without timeouts, sessions never end.
+	if expiry := (helpers.SessionTimeouts{}).Expiry(session, now.Add(1000*time.Hour)); expiry.ExpiresAt != nil {
+		t.Errorf("Unexpected expiry %+v", expiry)
+	}
+}
@@ -1,12 +1,15 @@
 package helpers
 
 import (
-	"crypto/tls"
+	"database/sql"
 	"encoding/gob"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cloudfoundry-community/go-cfenv"
 	"github.com/gorilla/sessions"
@@ -14,11 +17,23 @@ import (
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers/logging"
+	"github.com/18F/cg-dashboard/jobs"
 )
 
 const (
-	// 7 days at most.
-	expirationConstant = 60 * 60 * 24 * 7
+	// FirehoseScope is the UAA scope that platform operators need to read
+	// platform metrics.
+	FirehoseScope = "doppler.firehose"
+
+	// DefaultMaxProxyResponseBytes is the largest proxied response unless
+	// configured otherwise.
+	DefaultMaxProxyResponseBytes = 64 << 20
+
+	// platformCacheBytes bounds the memory used by the platform cache.
+	platformCacheBytes = 16 << 20
 )
 
 // Settings is the object to hold global values and objects for the service.
@@ -31,14 +46,25 @@ type Settings struct {
 	LoginURL string
 	// Sessions is the session store for all connected users.
 	Sessions sessions.Store
+	// SessionTimeouts end the sessions of inactive users, and all sessions
+	// some time after the login.
+	SessionTimeouts SessionTimeouts
 	// Generate secure random state
 	StateGenerator func() (string, error)
 	// UAA API
 	UaaURL string
 	// Log API
 	LogURL string
+	// Log Cache API, used for platform metrics. Optional.
+	LogCacheURL string
 	// TemplatesPath is the path to the templates directory.
 	TemplatesPath string
+	// EmailTemplatesPath is the directory of the deployment's own email
+	// templates, which override the default ones. Empty when there are none.
+	EmailTemplatesPath string
+	// Assets maps the frontend assets to their content-hashed file names.
+	// Nil when the assets are not hashed.
+	Assets AssetManifest
 	// High Privileged OauthConfig
 	HighPrivilegedOauthConfig *clientcredentials.Config
 	// A flag to indicate whether profiling should be included (debug purposes).
@@ -49,6 +75,10 @@ type Settings struct {
 	SecureCookies bool
 	// Inidicates if targeting a local CF environment.
 	LocalCF bool
+	// Environment is the name of the configuration profile in use, if any.
+	Environment string
+	// VerboseLogging logs every request.
+	VerboseLogging bool
 	// URL where this app is hosted
 	AppURL string
 	// SMTP host for UAA invites
@@ -63,23 +93,190 @@ type Settings struct {
 	SMTPFrom string
 	// SMTPCert is x509 TLS cert
 	SMTPCert string
+	// EmailBackend is the backend emails are sent with: smtp, ses or
+	// sendgrid. SMTPFrom is the sender of all of them.
+	EmailBackend string
+	// SES configures the SES backend. Nil with other backends.
+	SES *SESSettings
+	// SendGrid configures the SendGrid backend. Nil with other backends.
+	SendGrid *SendGridSettings
+	// MailQueue configures the queue emails are sent from in the background.
+	// Nil when they're sent while the request waits.
+	MailQueue *MailQueueSettings
 	// Shared secret with CF API proxy
 	TICSecret string
+	// MetricsToken is the bearer token /metrics requires. /metrics isn't
+	// served without one.
+	MetricsToken string
 	// CSRFKey used for gorilla CSRF validation
 	CSRFKey []byte
+	// OpaqueAccessTokens keeps the opaque access token from UAA instead of
+	// exchanging it for a JWT.
+	OpaqueAccessTokens bool
+	// TokenIntrospector validates opaque access tokens. Only set when
+	// OpaqueAccessTokens is enabled.
+	TokenIntrospector *TokenIntrospector
+	// TokenRevoker revokes the user's refresh token at UAA on logout.
+	TokenRevoker *TokenRevoker
+	// StreamGuard holds the limits for streaming connections.
+	StreamGuard *StreamGuard
+	// Jobs runs bulk operations in the background.
+	Jobs *jobs.Runner
+	// ChangeSets signs the planned changes of bulk operations.
+	ChangeSets *ChangeSetSigner
+	// Downloads signs the short-lived URLs of file downloads.
+	Downloads *DownloadSigner
+	// Logins counts the steps of the login flow users reach.
+	Logins *LoginFunnel
+	// Telemetry reports anonymous feature usage. Nil when turned off.
+	Telemetry *Telemetry
+	// Alerts notifies about the dashboard's own error rate, upstream latency
+	// and login failures. Nil when turned off.
+	Alerts *AlertEvaluator
+	// SchemaDrift checks that canonical CF API and UAA responses still have
+	// the shapes the dashboard expects.
+	SchemaDrift *SchemaDriftDetector
+	// Purger drops the dashboard's own data that's past its retention.
+	Purger *Purger
+	// Webhooks delivers the orgs' events to the webhooks of their managers.
+	Webhooks *Webhooks
+	// CrashWatcher looks for apps crashing repeatedly and notifies their
+	// orgs.
+	CrashWatcher *CrashWatcher
+	// Health checks the dashboard's dependencies for /healthz.
+	Health *HealthChecker
+	// PlatformHealth summarizes the platform's health for the header widget.
+	PlatformHealth *PlatformHealth
+	// OrgMailer emails org managers about their orgs from the background
+	// checks.
+	OrgMailer *OrgMailer
+	// QuotaAlerts alerts org managers about orgs running out of quota. Nil
+	// when quota alerting is off.
+	QuotaAlerts *QuotaAlerts
+	// AccessReviews runs the campaigns of org managers reviewing their orgs'
+	// members.
+	AccessReviews *AccessReviews
+	// RoleGrants revokes the org managers' temporary role grants when they
+	// expire.
+	RoleGrants *RoleGrants
+	// AuthAnomalies looks for anomalies in the logins and notifies them.
+	AuthAnomalies *AuthAnalyzer
+	// DB is the database for the dashboard's own data. Nil when not configured.
+	DB *sql.DB
+	// DBCipher encrypts the sensitive columns of DB. Nil when not configured.
+	DBCipher *db.ColumnCipher
+	// Content is the operator-managed content, such as quick links.
+	Content db.ContentStore
+	// Audit keeps the audit events, so users can review their own activity.
+	Audit db.AuditStore
+	// Incidents are the crash loops of the orgs' apps, shown in the orgs'
+	// activity.
+	Incidents db.IncidentStore
+	// RoleRequests are the users' requests for org and space roles.
+	RoleRequests db.RoleRequestStore
+	// Preferences are the users' own settings, such as their locale.
+	Preferences db.PreferenceStore
+	// PendingChanges are the admins' changes waiting for a second admin's
+	// approval.
+	PendingChanges db.PendingChangeStore
+	// Secrets are the credentials, such as new service keys, kept for users
+	// to retrieve once.
+	Secrets db.SecretStore
+	// AccessReviewStore keeps the org managers' reviews of their orgs'
+	// members.
+	AccessReviewStore db.AccessReviewStore
+	// Changelog is the release notes shown to users after deploys, and the
+	// latest version each user has seen.
+	Changelog db.ChangelogStore
+	// RoleGrantStore keeps the org managers' temporary role grants.
+	RoleGrantStore db.RoleGrantStore
+	// LoginLocations are where the users logged in from, which new logins
+	// are compared with.
+	LoginLocations db.LoginLocationStore
+	// PendingInvites keeps when users were invited until their first login,
+	// to measure how long invites take to be accepted.
+	PendingInvites db.PendingInviteStore
+	// AdminAudit records the privileged actions taken on behalf of users.
+	AdminAudit *AdminAudit
+	// ApprovalPolicy selects the changes that need a second admin's
+	// approval. Nil when none do.
+	ApprovalPolicy *ApprovalPolicy
+	// MaintenanceMessage is shown instead of the dashboard while it's in
+	// maintenance. Empty when it's not.
+	MaintenanceMessage string
+	// Theme is the deployment's default branding. The theme in Content
+	// overrides it field by field.
+	Theme db.Theme
+	// ProxyQuota limits the CF API requests per user and org. Nil when
+	// unlimited.
+	ProxyQuota *ProxyQuota
+	// MaxProxyResponseBytes is the largest proxied response. Zero means no
+	// limit.
+	MaxProxyResponseBytes int64
+	// PlatformCache keeps the CF API responses that are the same for every
+	// user, such as the stacks.
+	PlatformCache *Cache
+	// APICache caches the responses of proxied CF API GETs for each user.
+	// Nil when they aren't cached.
+	APICache *ResponseCache
+	// WarmCaches fills the platform cache on startup.
+	WarmCaches bool
+	// SyntheticUsers accepts requests from synthetic users for load testing.
+	SyntheticUsers bool
+	// Tracer records the requests of the users admins are debugging.
+	Tracer *Tracer
+	// Startup is how the dashboard started, for admins. Nil when the
+	// settings weren't created by the server.
+	Startup *StartupReport
+	// Preflight is set before InitSettings by the preflight subcommand, so the
+	// database is connected to without migrating or checking its schema,
+	// which the preflight checks itself.
+	Preflight bool
+	// Workers bound the concurrency of endpoints that fan out to many
+	// backend requests.
+	Workers *WorkerPool
+	// Invites are who can be invited, and how fast bulk invites are sent.
+	Invites *InvitePolicy
+	// SecurityHeaders are sent with every response.
+	SecurityHeaders *SecurityHeaders
+	// SessionBusyMessage is shown to users turned away while the session
+	// store is at capacity.
+	SessionBusyMessage string
+	// RetryPolicy retries the proxied CF API requests that failed
+	// transiently. Nil when retries are turned off.
+	RetryPolicy *RetryPolicy
+	// HTTPClient reaches the CF API and UAA. It trusts the CA bundle, if
+	// any, and fails over between the UAA endpoints.
+	HTTPClient *http.Client
+	// UAAFailover switches between the primary and secondary UAA and login
+	// endpoints. Nil when there are no secondary endpoints.
+	UAAFailover *UAAFailover
+	// UAABreaker and CFAPIBreaker fail the requests to UAA and the CF API
+	// fast while they're down. Nil when circuit breakers are turned off.
+	UAABreaker   *CircuitBreaker
+	CFAPIBreaker *CircuitBreaker
+	// ClientSecrets are the current and next secrets of the OAuth client,
+	// which requests made as the client fall back between.
+	ClientSecrets *ClientSecrets
+	// TLS serves HTTPS directly. Nil when a router terminates TLS.
+	TLS *TLSListener
+	// RequestTimeout is how long a request can take, and RouteTimeouts the
+	// timeouts of route groups that differ from it, by path prefix.
+	RequestTimeout time.Duration
+	RouteTimeouts  map[string]time.Duration
+	// Server limits how long clients and the dashboard have to send
+	// requests and responses, and the size of the requests' headers.
+	Server *ServerLimits
+	// Connections caps the client connections of all the listeners.
+	Connections *ConnLimiter
 }
 
-// CreateContext returns a new context to be used for http connections.
+// CreateContext returns a new context to be used for http connections. The
+// oauth2 package reaches the CF API and UAA with the settings' HTTPClient.
 func (s *Settings) CreateContext() context.Context {
 	ctx := context.TODO()
-	// If targeting local cf env, we won't have
-	// valid SSL certs so we need to disable verifying them.
-	if s.LocalCF {
-		httpClient := http.DefaultClient
-		httpClient.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+	if s.HTTPClient != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, s.HTTPClient)
 	}
 	return ctx
 }
@@ -105,11 +302,13 @@ func (s *Settings) InitSettings(envVars *env.VarSet, app *cfenv.App) (retErr err
 	}()
 
 	s.TemplatesPath = envVars.String(TemplatesPathEnvVar, "./templates")
+	s.EmailTemplatesPath = envVars.String(EmailTemplatesPathEnvVar, "")
 	s.AppURL = envVars.MustString(HostnameEnvVar)
 	s.ConsoleAPI = envVars.MustString(APIURLEnvVar)
 	s.LoginURL = envVars.MustString(LoginURLEnvVar)
 	s.UaaURL = envVars.MustString(UAAURLEnvVar)
 	s.LogURL = envVars.MustString(LogURLEnvVar)
+	s.LogCacheURL = envVars.String(LogCacheURLEnvVar, "")
 	s.PProfEnabled = envVars.MustBool(PProfEnabledEnvVar)
 	s.BuildInfo = envVars.String(BuildInfoEnvVar, "developer-build")
 	s.LocalCF = envVars.MustBool(LocalCFEnvVar)
@@ -119,6 +318,27 @@ func (s *Settings) InitSettings(envVars *env.VarSet, app *cfenv.App) (retErr err
 	if s.LocalCF == false && s.SecureCookies == false {
 		return errors.New("cannot run with insecure cookies when targeting a production CF environment")
 	}
+	s.VerboseLogging = envVars.MustBool(VerboseLoggingEnvVar)
+	if err := logging.Levels.Parse(envVars.String(LogLevelEnvVar, "")); err != nil {
+		return fmt.Errorf("could not parse env var %q: %v", LogLevelEnvVar, err)
+	}
+	if err := logging.SetFormat(envVars.String(LogFormatEnvVar, logging.TextFormat)); err != nil {
+		return fmt.Errorf("could not parse env var %q: %v", LogFormatEnvVar, err)
+	}
+	s.Environment = strings.ToLower(envVars.String(EnvironmentEnvVar, ""))
+	// Safe guard: debugging aids must not be turned on in production, even
+	// explicitly.
+	if s.Environment == ProfileProduction && (s.LocalCF || s.PProfEnabled) {
+		return fmt.Errorf("cannot enable %s or %s in the %s environment",
+			LocalCFEnvVar, PProfEnabledEnvVar, ProfileProduction)
+	}
+
+	s.SyntheticUsers = envVars.MustBool(SyntheticUsersEnvVar)
+	// Safe guard: synthetic users skip the login, so they are only for load
+	// testing against a mock backend.
+	if s.SyntheticUsers && !s.LocalCF {
+		return fmt.Errorf("%s can only be enabled with %s", SyntheticUsersEnvVar, LocalCFEnvVar)
+	}
 
 	// Setup OAuth2 Client Service.
 	s.OAuthConfig = &oauth2.Config{
@@ -131,12 +351,56 @@ func (s *Settings) InitSettings(envVars *env.VarSet, app *cfenv.App) (retErr err
 			TokenURL: envVars.MustString(UAAURLEnvVar) + "/oauth/token",
 		},
 	}
+	// Platform operators need the firehose scope to read platform metrics.
+	if s.LogCacheURL != "" {
+		s.OAuthConfig.Scopes = append(s.OAuthConfig.Scopes, FirehoseScope)
+	}
+
+	// If targeting local cf env, we won't have valid SSL certs so we need to
+	// disable verifying them.
+	transport, err := newUpstreamTransport(envVars.String(CABundlePathEnvVar, ""), s.LocalCF)
+	if err != nil {
+		return fmt.Errorf("could not load env var %q: %v", CABundlePathEnvVar, err)
+	}
+	s.HTTPClient = &http.Client{Transport: transport}
+	if s.UAAFailover, err = parseUAAFailover(envVars, s); err != nil {
+		return err
+	}
+	if s.RetryPolicy, err = parseRetryPolicy(envVars); err != nil {
+		return err
+	}
+	if s.UAAFailover != nil {
+		// The endpoints are checked without failing over.
+		s.UAAFailover.Client = &http.Client{Transport: transport}
+		s.HTTPClient.Transport = s.UAAFailover.Transport(transport)
+	}
+	if err := parseCircuitBreakers(envVars, s); err != nil {
+		return err
+	}
+	// Wraps the breakers, so the requests retried with the other secret are
+	// counted too.
+	s.ClientSecrets = NewClientSecrets(s.OAuthConfig.ClientID, s.OAuthConfig.ClientSecret,
+		envVars.String(ClientSecretNextEnvVar, ""))
+	s.HTTPClient.Transport = s.ClientSecrets.Transport(s.HTTPClient.Transport)
+
+	s.OpaqueAccessTokens = envVars.MustBool(OpaqueAccessTokensEnvVar)
+	if s.OpaqueAccessTokens {
+		s.TokenIntrospector = NewTokenIntrospector(s.UaaURL,
+			s.OAuthConfig.ClientID, s.OAuthConfig.ClientSecret,
+			oauth2.NewClient(s.CreateContext(), nil))
+	}
+	s.TokenRevoker = NewTokenRevoker(s.UaaURL, envVars.String(TokenRevocationURLEnvVar, ""),
+		s.OAuthConfig.ClientID, s.OAuthConfig.ClientSecret,
+		oauth2.NewClient(s.CreateContext(), nil))
 
 	s.StateGenerator = func() (string, error) {
 		return GenerateRandomString(32)
 	}
 
-	var err error
+	s.Assets, err = LoadAssetManifest(envVars.String(AssetManifestPathEnvVar, "./static/assets/manifest.json"))
+	if err != nil {
+		return err
+	}
 
 	// Initialize CSRF key
 	s.CSRFKey, err = hex.DecodeString(envVars.MustString(CSRFKeyEnvVar))
@@ -144,22 +408,28 @@ func (s *Settings) InitSettings(envVars *env.VarSet, app *cfenv.App) (retErr err
 		return fmt.Errorf("could not decode hex env var %q: %v", CSRFKeyEnvVar, err)
 	}
 
-	// Initialize Sessions.
+	// Initialize the session keys. The store is set up with the database.
 	sessionAuthenticationKey, err := hex.DecodeString(envVars.MustString(SessionAuthenticationEnvVar))
 	if err != nil {
 		return fmt.Errorf("could not decode hex env var %q: %v", SessionAuthenticationEnvVar, err)
 	}
 
-	// Initialize cookiestore
 	sessionEncryptionKey, err := hex.DecodeString(envVars.MustString(SessionEncryptionEnvVar))
 	if err != nil {
 		return err
 	}
-	store := sessions.NewCookieStore(sessionAuthenticationKey, sessionEncryptionKey)
-	store.Options.HttpOnly = true
-	store.Options.Secure = s.SecureCookies
-
-	s.Sessions = store
+	s.ChangeSets = NewChangeSetSigner(sessionAuthenticationKey)
+	downloadURLTTL := DefaultDownloadURLTTL
+	if ttl := envVars.String(DownloadURLTTLEnvVar, ""); ttl != "" {
+		downloadURLTTL, err = time.ParseDuration(ttl)
+		if err == nil && downloadURLTTL <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", DownloadURLTTLEnvVar, err)
+		}
+	}
+	s.Downloads = NewDownloadSigner(sessionAuthenticationKey, sessionEncryptionKey, downloadURLTTL)
 
 	// Want to save a struct into the session. Have to register it.
 	gob.Register(oauth2.Token{})
@@ -172,11 +442,851 @@ func (s *Settings) InitSettings(envVars *env.VarSet, app *cfenv.App) (retErr err
 	}
 
 	s.SMTPFrom = envVars.MustString(SMTPFromEnvVar)
-	s.SMTPHost = envVars.MustString(SMTPHostEnvVar)
+	if err := parseEmailBackend(envVars, s); err != nil {
+		return err
+	}
 	s.SMTPPass = envVars.String(SMTPPassEnvVar, "")
 	s.SMTPPort = envVars.String(SMTPPortEnvVar, "")
 	s.SMTPUser = envVars.String(SMTPUserEnvVar, "")
 	s.SMTPCert = envVars.String(SMTPCertEnvVar, "")
+	if s.MailQueue, err = parseMailQueue(envVars); err != nil {
+		return err
+	}
 	s.TICSecret = envVars.String(TICSecretEnvVar, "")
+	s.MetricsToken = envVars.String(MetricsTokenEnvVar, "")
+	s.MaintenanceMessage = envVars.String(MaintenanceMessageEnvVar, "")
+
+	if databaseURL := envVars.String(DatabaseURLEnvVar, ""); databaseURL != "" {
+		options, err := parsePoolOptions(envVars)
+		if err != nil {
+			return err
+		}
+		s.DBCipher, err = db.ParseColumnCipher(envVars.String(DBEncryptionKeyEnvVar, ""),
+			envVars.String(DBEncryptionPreviousKeysEnvVar, ""))
+		if err != nil {
+			return fmt.Errorf("invalid database encryption keys: %v", err)
+		}
+		if s.Preflight {
+			s.DB, err = db.Connect(databaseURL, options)
+		} else if envVars.MustBool(DBSkipMigrationsEnvVar) {
+			s.DB, err = db.Connect(databaseURL, options)
+			if err == nil {
+				if err = db.CheckSchema(s.DB); err != nil {
+					s.DB.Close()
+				}
+			}
+		} else {
+			s.DB, err = db.Open(databaseURL, options)
+		}
+		if err != nil {
+			return err
+		}
+		WatchDBPool(s.DB)
+		s.Content = &db.SQLContentStore{DB: s.DB}
+		s.Preferences = &db.SQLPreferenceStore{DB: s.DB}
+		s.Audit = &db.SQLAuditStore{DB: s.DB}
+		s.RoleRequests = &db.SQLRoleRequestStore{DB: s.DB}
+		s.PendingChanges = &db.SQLPendingChangeStore{DB: s.DB, Cipher: s.DBCipher}
+		s.Incidents = &db.SQLIncidentStore{DB: s.DB}
+		s.Secrets = &db.SQLSecretStore{DB: s.DB, Cipher: s.DBCipher}
+		s.AccessReviewStore = &db.SQLAccessReviewStore{DB: s.DB}
+		s.Changelog = &db.SQLChangelogStore{DB: s.DB}
+		s.RoleGrantStore = &db.SQLRoleGrantStore{DB: s.DB}
+		s.LoginLocations = &db.SQLLoginLocationStore{DB: s.DB}
+		s.PendingInvites = &db.SQLPendingInviteStore{DB: s.DB}
+		s.AdminAudit = &AdminAudit{Store: &db.SQLAdminAuditStore{DB: s.DB}}
+	} else {
+		s.Content = &db.MemoryContentStore{}
+		s.Preferences = &db.MemoryPreferenceStore{}
+		s.Audit = &db.MemoryAuditStore{}
+		s.RoleRequests = &db.MemoryRoleRequestStore{}
+		s.PendingChanges = &db.MemoryPendingChangeStore{}
+		s.Incidents = &db.MemoryIncidentStore{}
+		s.Secrets = &db.MemorySecretStore{}
+		s.AccessReviewStore = &db.MemoryAccessReviewStore{}
+		s.Changelog = &db.MemoryChangelogStore{}
+		s.RoleGrantStore = &db.MemoryRoleGrantStore{}
+		s.LoginLocations = &db.MemoryLoginLocationStore{}
+		s.PendingInvites = &db.MemoryPendingInviteStore{}
+		s.AdminAudit = &AdminAudit{Store: &db.MemoryAdminAuditStore{}}
+	}
+	if s.Changelog, err = parseChangelog(envVars, s.Changelog); err != nil {
+		return err
+	}
+
+	if s.SessionTimeouts, err = parseSessionTimeouts(envVars); err != nil {
+		return err
+	}
+	if s.Sessions, err = parseSessionStore(envVars, s, sessionAuthenticationKey, sessionEncryptionKey); err != nil {
+		return err
+	}
+
+	if s.SecurityHeaders, err = parseSecurityHeaders(envVars, s); err != nil {
+		return err
+	}
+	if s.TLS, err = parseTLSListener(envVars, s.AppURL); err != nil {
+		return err
+	}
+	if s.Connections, err = parseConnLimiter(envVars); err != nil {
+		return err
+	}
+	if err := parseRequestTimeouts(envVars, s); err != nil {
+		return err
+	}
+	if s.Server, err = parseServerLimits(envVars, s); err != nil {
+		return err
+	}
+
+	s.Theme = db.Theme{
+		ProductName:  envVars.String(ThemeProductNameEnvVar, ""),
+		LogoURL:      envVars.String(ThemeLogoURLEnvVar, ""),
+		PrimaryColor: envVars.String(ThemePrimaryColorEnvVar, ""),
+		AccentColor:  envVars.String(ThemeAccentColorEnvVar, ""),
+		Footer:       envVars.String(ThemeFooterEnvVar, ""),
+	}
+	if err := s.Theme.Validate(); err != nil {
+		return fmt.Errorf("invalid theme settings: %v", err)
+	}
+
+	s.Jobs = jobs.NewRunner(jobs.DefaultConcurrency, jobs.DefaultDelay)
+	if s.DB != nil {
+		s.Webhooks = NewWebhooks(&db.SQLWebhookStore{DB: s.DB, Cipher: s.DBCipher}, s.Jobs)
+	} else {
+		s.Webhooks = NewWebhooks(&db.MemoryWebhookStore{}, s.Jobs)
+	}
+	s.Health = NewHealthChecker(s, oauth2.NewClient(s.CreateContext(), nil))
+	if s.UAABreaker != nil {
+		s.Health.Breakers = []*CircuitBreaker{s.UAABreaker, s.CFAPIBreaker}
+	}
+	s.Health.ClientSecrets = s.ClientSecrets
+	s.PlatformHealth = NewPlatformHealth(s, oauth2.NewClient(s.CreateContext(), nil),
+		s.HighPrivilegedOauthConfig.Client(s.CreateContext()))
+	s.OrgMailer = NewOrgMailer(s.ConsoleAPI, s.UaaURL, s.HighPrivilegedOauthConfig.Client(s.CreateContext()))
+	s.OrgMailer.AppURL = s.AppURL
+	if s.CrashWatcher, err = parseCrashWatcher(envVars, s); err != nil {
+		return err
+	}
+	s.Logins = NewLoginFunnel()
+	s.Tracer = NewTracer(DefaultTraceCapacity)
+	if s.Purger, err = parsePurger(envVars, s); err != nil {
+		return err
+	}
+
+	if telemetryURL := envVars.String(TelemetryURLEnvVar, ""); telemetryURL != "" && !envVars.MustBool(TelemetryOptOutEnvVar) {
+		s.Telemetry = NewTelemetry(telemetryURL, s.BuildInfo)
+		if interval := envVars.String(TelemetryIntervalEnvVar, ""); interval != "" {
+			s.Telemetry.Interval, err = time.ParseDuration(interval)
+			if err == nil && s.Telemetry.Interval <= 0 {
+				err = errors.New("must be positive")
+			}
+			if err != nil {
+				return fmt.Errorf("could not parse env var %q: %v", TelemetryIntervalEnvVar, err)
+			}
+		}
+	}
+
+	if webhookURL := envVars.String(AlertWebhookURLEnvVar, ""); webhookURL != "" {
+		if s.Alerts, err = parseAlerts(envVars, NewWebhookNotifier(webhookURL), s.AppURL); err != nil {
+			return err
+		}
+	}
+
+	s.SchemaDrift = NewSchemaDriftDetector(s)
+	if s.Alerts != nil {
+		s.SchemaDrift.Notifier = s.Alerts.Notifier
+	}
+	if interval := envVars.String(SchemaDriftCheckIntervalEnvVar, ""); interval != "" {
+		s.SchemaDrift.Interval, err = time.ParseDuration(interval)
+		if err == nil && s.SchemaDrift.Interval <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", SchemaDriftCheckIntervalEnvVar, err)
+		}
+	}
+
+	if thresholds := envVars.String(QuotaAlertThresholdsEnvVar, ""); thresholds != "" {
+		if s.QuotaAlerts, err = parseQuotaAlerts(envVars, thresholds, s); err != nil {
+			return err
+		}
+	}
+	if s.AccessReviews, err = parseAccessReviews(envVars, s); err != nil {
+		return err
+	}
+	s.RoleGrants = NewRoleGrants(s.RoleGrantStore, s.ConsoleAPI, s.HighPrivilegedOauthConfig.Client(s.CreateContext()))
+	s.RoleGrants.Record = s.RecordAuditEvent
+	s.RoleGrants.AdminAudit = s.AdminAudit
+	if s.AuthAnomalies, err = parseAuthAnomalies(envVars, s); err != nil {
+		return err
+	}
+
+	var perUser, perOrg int
+	if quota := envVars.String(ProxyQuotaPerUserEnvVar, ""); quota != "" {
+		if perUser, err = strconv.Atoi(quota); err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", ProxyQuotaPerUserEnvVar, err)
+		}
+	}
+	if quota := envVars.String(ProxyQuotaPerOrgEnvVar, ""); quota != "" {
+		if perOrg, err = strconv.Atoi(quota); err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", ProxyQuotaPerOrgEnvVar, err)
+		}
+	}
+	if perUser > 0 || perOrg > 0 {
+		s.ProxyQuota = NewProxyQuota(perUser, perOrg)
+	}
+
+	var quotaThreshold int
+	if threshold := envVars.String(ApprovalQuotaThresholdEnvVar, ""); threshold != "" {
+		if quotaThreshold, err = strconv.Atoi(threshold); err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", ApprovalQuotaThresholdEnvVar, err)
+		}
+	}
+	s.ApprovalPolicy, err = NewApprovalPolicy(envVars.String(ApprovalRequiredForEnvVar, ""), quotaThreshold)
+	if err != nil {
+		return fmt.Errorf("could not parse env var %q: %v", ApprovalRequiredForEnvVar, err)
+	}
+
+	s.MaxProxyResponseBytes = DefaultMaxProxyResponseBytes
+	if maxBytes := envVars.String(MaxProxyResponseBytesEnvVar, ""); maxBytes != "" {
+		if s.MaxProxyResponseBytes, err = strconv.ParseInt(maxBytes, 10, 64); err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", MaxProxyResponseBytesEnvVar, err)
+		}
+	}
+
+	poolSize, poolPerUser := DefaultWorkerPoolSize, DefaultWorkerPoolPerUser
+	if size := envVars.String(WorkerPoolSizeEnvVar, ""); size != "" {
+		if poolSize, err = strconv.Atoi(size); err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", WorkerPoolSizeEnvVar, err)
+		}
+	}
+	if perUser := envVars.String(WorkerPoolPerUserEnvVar, ""); perUser != "" {
+		if poolPerUser, err = strconv.Atoi(perUser); err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", WorkerPoolPerUserEnvVar, err)
+		}
+	}
+	s.Workers = NewWorkerPool(poolSize, poolPerUser)
+	if s.Invites, err = parseInvitePolicy(envVars); err != nil {
+		return err
+	}
+
+	s.PlatformCache = NewCache("platform", platformCacheBytes)
+	if s.APICache, err = parseAPICache(envVars); err != nil {
+		return err
+	}
+	s.WarmCaches = envVars.MustBool(WarmCachesEnvVar)
+
+	// Initialize the limits for streaming connections.
+	s.StreamGuard = NewStreamGuard(s.AppURL)
+	if origins := envVars.String(StreamAllowedOriginsEnvVar, ""); origins != "" {
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				s.StreamGuard.AllowedOrigins = append(s.StreamGuard.AllowedOrigins, origin)
+			}
+		}
+	}
+	if maxPerUser := envVars.String(StreamMaxPerUserEnvVar, ""); maxPerUser != "" {
+		s.StreamGuard.MaxPerUser, err = strconv.Atoi(maxPerUser)
+		if err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", StreamMaxPerUserEnvVar, err)
+		}
+	}
+	if idleTimeout := envVars.String(StreamIdleTimeoutEnvVar, ""); idleTimeout != "" {
+		s.StreamGuard.IdleTimeout, err = time.ParseDuration(idleTimeout)
+		if err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", StreamIdleTimeoutEnvVar, err)
+		}
+	}
 	return nil
 }
+
+// parseAlerts creates the alert evaluator with the rule thresholds from the
+// env vars.
+// parsePurger creates the purger of the settings' stores and jobs with the
+// configured retentions.
+// parseSessionStore creates the store SESSION_BACKEND selects. Postgres
+// sessions need the database to be set up first.
+func parseSessionStore(envVars *env.VarSet, s *Settings, authenticationKey, encryptionKey []byte) (sessions.Store, error) {
+	var backend SessionBackend
+	switch name := envVars.String(SessionBackendEnvVar, CookieSessionBackend); name {
+	case CookieSessionBackend:
+		for _, name := range []string{SessionMaxActiveEnvVar, SessionMigrateCookiesEnvVar} {
+			if envVars.String(name, "") != "" {
+				return nil, fmt.Errorf("env var %q needs server-side sessions, set %q", name, SessionBackendEnvVar)
+			}
+		}
+		store := sessions.NewCookieStore(authenticationKey, encryptionKey)
+		store.Options.HttpOnly = true
+		store.Options.Secure = s.SecureCookies
+		store.MaxAge(int(s.SessionTimeouts.Absolute / time.Second))
+
+		// Sessions too large for a cookie are optionally saved server-side.
+		var overflow sessions.Store
+		if dir := envVars.String(SessionOverflowDirEnvVar, ""); dir != "" {
+			fsStore := sessions.NewFilesystemStore(dir, authenticationKey, encryptionKey)
+			fsStore.Options.HttpOnly = true
+			fsStore.Options.Secure = s.SecureCookies
+			fsStore.MaxAge(int(s.SessionTimeouts.Absolute / time.Second))
+			overflow = fsStore
+		}
+		return NewSizeMonitoredStore(store, overflow), nil
+	case RedisSessionBackend:
+		redisURL := envVars.String(SessionRedisURLEnvVar, "")
+		if redisURL == "" {
+			return nil, fmt.Errorf("env var %q is required with %s=%s", SessionRedisURLEnvVar, SessionBackendEnvVar, name)
+		}
+		redisSessions, err := NewRedisSessions(redisURL)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to the session Redis server: %v", err)
+		}
+		backend = redisSessions
+	case PostgresSessionBackend:
+		if s.DB == nil {
+			return nil, fmt.Errorf("env var %q is required with %s=%s", DatabaseURLEnvVar, SessionBackendEnvVar, name)
+		}
+		backend = &db.SQLSessionStore{DB: s.DB}
+	default:
+		return nil, fmt.Errorf("could not parse env var %q: unknown backend %q, expected cookie, redis or postgres", SessionBackendEnvVar, name)
+	}
+	store := NewServerSideStore(backend, authenticationKey, encryptionKey)
+	store.Options.HttpOnly = true
+	store.Options.Secure = s.SecureCookies
+	store.Options.MaxAge = int(s.SessionTimeouts.Absolute / time.Second)
+	store.MigrateCookies = envVars.MustBool(SessionMigrateCookiesEnvVar)
+	var err error
+	if store.Capacity, err = parseSessionCapacity(envVars); err != nil {
+		return nil, err
+	}
+	s.SessionBusyMessage = envVars.String(SessionBusyMessageEnvVar, DefaultSessionBusyMessage)
+	return store, nil
+}
+
+// parseSessionCapacity reads the cap on the server-side sessions. The
+// sessions are counted even when they aren't capped.
+func parseSessionCapacity(envVars *env.VarSet) (*SessionCapacity, error) {
+	maxSessions := 0
+	if value := envVars.String(SessionMaxActiveEnvVar, ""); value != "" {
+		var err error
+		if maxSessions, err = strconv.Atoi(value); err == nil && maxSessions < 0 {
+			err = errors.New("must not be negative")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", SessionMaxActiveEnvVar, err)
+		}
+	}
+	var refuse bool
+	switch policy := envVars.String(SessionCapacityPolicyEnvVar, "evict"); policy {
+	case "evict":
+	case "refuse":
+		refuse = true
+	default:
+		return nil, fmt.Errorf("could not parse env var %q: unknown policy %q, expected evict or refuse", SessionCapacityPolicyEnvVar, policy)
+	}
+	return NewSessionCapacity(maxSessions, refuse), nil
+}
+
+// parseAPICache reads where and how long the responses of proxied CF API
+// GETs are cached. It returns nil when they aren't.
+func parseAPICache(envVars *env.VarSet) (*ResponseCache, error) {
+	cache := &ResponseCache{TTLs: map[string]time.Duration{}}
+	switch name := envVars.String(APICacheEnvVar, ""); name {
+	case "":
+		return nil, nil
+	case MemoryAPICacheBackend:
+		cache.Backend = NewMemoryResponseCache(DefaultAPICacheBytes)
+	case RedisAPICacheBackend:
+		redisURL := envVars.String(APICacheRedisURLEnvVar, "")
+		if redisURL == "" {
+			return nil, fmt.Errorf("env var %q is required with %s=%s", APICacheRedisURLEnvVar, APICacheEnvVar, name)
+		}
+		backend, err := NewRedisResponseCache(redisURL)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to the API cache Redis server: %v", err)
+		}
+		cache.Backend = backend
+	default:
+		return nil, fmt.Errorf("could not parse env var %q: unknown backend %q, expected memory or redis", APICacheEnvVar, name)
+	}
+	for resource, ttl := range DefaultAPICacheTTLs {
+		cache.TTLs[resource] = ttl
+	}
+	for _, pair := range strings.Split(envVars.String(APICacheTTLsEnvVar, ""), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("could not parse env var %q: expected resource=ttl, found %q", APICacheTTLsEnvVar, pair)
+		}
+		ttl, err := time.ParseDuration(parts[1])
+		if err == nil && ttl < 0 {
+			err = errors.New("must not be negative")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", APICacheTTLsEnvVar, err)
+		}
+		cache.TTLs[strings.TrimSpace(parts[0])] = ttl
+	}
+	return cache, nil
+}
+
+// parseRetryPolicy reads the retry policy of proxied CF API requests. It
+// returns nil when retries are turned off.
+func parseRetryPolicy(envVars *env.VarSet) (*RetryPolicy, error) {
+	policy := NewRetryPolicy()
+	var err error
+	if attempts := envVars.String(ProxyRetryMaxAttemptsEnvVar, ""); attempts != "" {
+		policy.MaxAttempts, err = strconv.Atoi(attempts)
+		if err == nil && policy.MaxAttempts < 1 {
+			err = errors.New("must be at least 1")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", ProxyRetryMaxAttemptsEnvVar, err)
+		}
+	}
+	if policy.MaxAttempts == 1 {
+		return nil, nil
+	}
+	for name, d := range map[string]*time.Duration{
+		ProxyRetryBaseDelayEnvVar: &policy.BaseDelay,
+		ProxyRetryMaxDelayEnvVar:  &policy.MaxDelay,
+	} {
+		if value := envVars.String(name, ""); value != "" {
+			if *d, err = time.ParseDuration(value); err == nil && *d <= 0 {
+				err = errors.New("must be positive")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not parse env var %q: %v", name, err)
+			}
+		}
+	}
+	policy.IdempotentOnly = !envVars.MustBool(ProxyRetryNonIdempotentEnvVar)
+	if ratio := envVars.String(ProxyRetryBudgetRatioEnvVar, ""); ratio != "" {
+		policy.Budget.Ratio, err = strconv.ParseFloat(ratio, 64)
+		if err == nil && (policy.Budget.Ratio <= 0 || policy.Budget.Ratio > 1) {
+			err = errors.New("must be more than 0 and at most 1")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", ProxyRetryBudgetRatioEnvVar, err)
+		}
+	}
+	return policy, nil
+}
+
+// parseCircuitBreakers reads the circuit breakers of UAA and the CF API, and
+// sends the requests of the HTTPClient through them. A threshold of 0 turns
+// them off.
+func parseCircuitBreakers(envVars *env.VarSet, s *Settings) error {
+	threshold, cooldown := DefaultBreakerThreshold, DefaultBreakerCooldown
+	var err error
+	if value := envVars.String(CircuitBreakerThresholdEnvVar, ""); value != "" {
+		threshold, err = strconv.Atoi(value)
+		if err == nil && threshold < 0 {
+			err = errors.New("must not be negative")
+		}
+		if err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", CircuitBreakerThresholdEnvVar, err)
+		}
+	}
+	if threshold == 0 {
+		return nil
+	}
+	if value := envVars.String(CircuitBreakerCooldownEnvVar, ""); value != "" {
+		cooldown, err = time.ParseDuration(value)
+		if err == nil && cooldown <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", CircuitBreakerCooldownEnvVar, err)
+		}
+	}
+	s.UAABreaker = NewCircuitBreaker("uaa", threshold, cooldown)
+	s.CFAPIBreaker = NewCircuitBreaker("cf_api", threshold, cooldown)
+	upstreams := map[string]*CircuitBreaker{s.UaaURL: s.UAABreaker, s.ConsoleAPI: s.CFAPIBreaker}
+	if s.UAAFailover != nil && s.UAAFailover.UAA != nil {
+		upstreams[s.UAAFailover.UAA.Secondary] = s.UAABreaker
+	}
+	s.HTTPClient.Transport = newBreakerTransport(s.HTTPClient.Transport, upstreams)
+	return nil
+}
+
+// parseUAAFailover reads the secondary UAA and login endpoints. It returns
+// nil when there are none.
+func parseUAAFailover(envVars *env.VarSet, s *Settings) (*UAAFailover, error) {
+	uaaSecondary := envVars.String(UAASecondaryURLEnvVar, "")
+	loginSecondary := envVars.String(LoginSecondaryURLEnvVar, "")
+	if uaaSecondary == "" && loginSecondary == "" {
+		return nil, nil
+	}
+	failover := &UAAFailover{Interval: DefaultFailoverCheckInterval}
+	if uaaSecondary != "" {
+		failover.UAA = NewEndpointFailover("uaa", s.UaaURL, uaaSecondary, "/healthz")
+	}
+	if loginSecondary != "" {
+		failover.Login = NewEndpointFailover("login", s.LoginURL, loginSecondary, "/healthz")
+	}
+	if interval := envVars.String(UAAFailoverCheckIntervalEnvVar, ""); interval != "" {
+		var err error
+		if failover.Interval, err = time.ParseDuration(interval); err == nil && failover.Interval <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", UAAFailoverCheckIntervalEnvVar, err)
+		}
+	}
+	return failover, nil
+}
+
+// parseSessionTimeouts reads the session timeouts. Sessions don't end for
+// inactivity unless SESSION_IDLE_TIMEOUT is set.
+func parseSessionTimeouts(envVars *env.VarSet) (SessionTimeouts, error) {
+	timeouts := SessionTimeouts{Absolute: DefaultSessionAbsoluteTimeout}
+	for name, d := range map[string]*time.Duration{
+		SessionIdleTimeoutEnvVar:     &timeouts.Idle,
+		SessionAbsoluteTimeoutEnvVar: &timeouts.Absolute,
+	} {
+		if value := envVars.String(name, ""); value != "" {
+			var err error
+			if *d, err = time.ParseDuration(value); err == nil && *d < sessionActivityResolution {
+				err = fmt.Errorf("must be at least %s", sessionActivityResolution)
+			}
+			if err != nil {
+				return timeouts, fmt.Errorf("could not parse env var %q: %v", name, err)
+			}
+		}
+	}
+	if timeouts.Idle > timeouts.Absolute {
+		return timeouts, fmt.Errorf("env var %q must not be longer than %q", SessionIdleTimeoutEnvVar, SessionAbsoluteTimeoutEnvVar)
+	}
+	return timeouts, nil
+}
+
+// parseSecurityHeaders reads the security headers. The content security
+// policy allows the analytics that are configured, and HSTS is sent when the
+// dashboard is served over HTTPS.
+func parseSecurityHeaders(envVars *env.VarSet, s *Settings) (*SecurityHeaders, error) {
+	headers := NewSecurityHeaders()
+	if envVars.String(GATrackingIDEnvVar, "") != "" {
+		headers.AllowGoogleAnalytics()
+	}
+	if envVars.String(NewRelicIDEnvVar, "") != "" && envVars.String(NewRelicBrowserLicenseKeyEnvVar, "") != "" {
+		headers.AllowNewRelic()
+	}
+	for _, source := range strings.Split(envVars.String(CSPExtraSourcesEnvVar, ""), ",") {
+		if source = strings.TrimSpace(source); source == "" {
+			continue
+		}
+		if strings.ContainsAny(source, ";'\"") {
+			return nil, fmt.Errorf("could not parse env var %q: invalid source %q", CSPExtraSourcesEnvVar, source)
+		}
+		headers.ScriptSources = append(headers.ScriptSources, source)
+		headers.ConnectSources = append(headers.ConnectSources, source)
+		headers.ImgSources = append(headers.ImgSources, source)
+	}
+	headers.ReportURI = envVars.String(CSPReportURIEnvVar, "")
+	headers.ReportOnly = envVars.MustBool(CSPReportOnlyEnvVar)
+	if s.SecureCookies {
+		headers.HSTSMaxAge = DefaultHSTSMaxAge
+		if maxAge := envVars.String(HSTSMaxAgeEnvVar, ""); maxAge != "" {
+			var err error
+			if headers.HSTSMaxAge, err = time.ParseDuration(maxAge); err == nil && headers.HSTSMaxAge < 0 {
+				err = errors.New("must not be negative")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not parse env var %q: %v", HSTSMaxAgeEnvVar, err)
+			}
+		}
+	}
+	return headers, nil
+}
+
+// ServerSideSessions returns true if the sessions are kept server-side, so
+// their size doesn't matter.
+func (s *Settings) ServerSideSessions() bool {
+	_, ok := s.Sessions.(*ServerSideStore)
+	return ok
+}
+
+func parsePurger(envVars *env.VarSet, s *Settings) (*Purger, error) {
+	durations := map[string]time.Duration{}
+	for _, name := range []string{PurgeIntervalEnvVar, RetentionAuditEventsEnvVar, RetentionDecisionsEnvVar, RetentionJobsEnvVar} {
+		value := envVars.String(name, "")
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err == nil && d <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", name, err)
+		}
+		durations[name] = d
+	}
+	if d, ok := durations[RetentionJobsEnvVar]; ok {
+		s.Jobs.Retention = d
+	}
+	purger := NewPurger(s.Audit, s.RoleRequests, s.PendingChanges, s.Jobs)
+	purger.Targets = append(purger.Targets, PurgeTarget{
+		Kind: "webhook_deliveries", Retention: webhookDeliveryRetention, Purge: s.Webhooks.Store.PurgeDeliveries,
+	})
+	purger.Targets = append(purger.Targets, PurgeTarget{
+		// Resolved incidents are kept as long as the activity they're shown in.
+		Kind: "incidents", Retention: db.AuditRetention, Purge: s.Incidents.PurgeIncidents,
+	})
+	purger.Targets = append(purger.Targets, PurgeTarget{
+		Kind: "login_locations", Retention: db.LoginLocationRetention, Purge: s.LoginLocations.PurgeLoginLocations,
+	})
+	purger.Targets = append(purger.Targets, PurgeTarget{
+		Kind: "pending_invites", Retention: db.PendingInviteRetention, Purge: s.PendingInvites.PurgeInvites,
+	})
+	purger.Targets = append(purger.Targets, PurgeTarget{
+		Kind: "admin_audit_log", Retention: db.AuditRetention, Purge: s.AdminAudit.Store.PurgeAdminActions,
+	})
+	// Secrets that were never retrieved are dropped as soon as they expire.
+	purger.Targets = append(purger.Targets, PurgeTarget{Kind: "secrets", Purge: s.Secrets.PurgeSecrets})
+	if store, ok := s.Sessions.(*ServerSideStore); ok {
+		if sqlSessions, ok := store.Backend.(*db.SQLSessionStore); ok {
+			// Sessions expire on their own, so they're dropped as soon as
+			// they expire.
+			purger.Targets = append(purger.Targets, PurgeTarget{Kind: "sessions", Purge: sqlSessions.PurgeSessions})
+		}
+	}
+	if d, ok := durations[PurgeIntervalEnvVar]; ok {
+		purger.Interval = d
+	}
+	if d, ok := durations[RetentionAuditEventsEnvVar]; ok {
+		purger.SetRetention("audit_events", d)
+		purger.SetRetention("admin_audit_log", d)
+		purger.SetRetention("incidents", d)
+	}
+	if d, ok := durations[RetentionDecisionsEnvVar]; ok {
+		purger.SetRetention("role_requests", d)
+		purger.SetRetention("pending_changes", d)
+	}
+	return purger, nil
+}
+
+// parsePoolOptions reads the tuning of the database connection pool.
+func parsePoolOptions(envVars *env.VarSet) (db.PoolOptions, error) {
+	options := db.DefaultPoolOptions()
+	var err error
+	for name, v := range map[string]*int{
+		DBMaxOpenConnsEnvVar: &options.MaxOpenConns,
+		DBMaxIdleConnsEnvVar: &options.MaxIdleConns,
+	} {
+		if value := envVars.String(name, ""); value != "" {
+			if *v, err = strconv.Atoi(value); err == nil && *v < 0 {
+				err = errors.New("must not be negative")
+			}
+			if err != nil {
+				return db.PoolOptions{}, fmt.Errorf("could not parse env var %q: %v", name, err)
+			}
+		}
+	}
+	for name, d := range map[string]*time.Duration{
+		DBConnMaxLifetimeEnvVar: &options.ConnMaxLifetime,
+		DBConnectTimeoutEnvVar:  &options.ConnectTimeout,
+	} {
+		if value := envVars.String(name, ""); value != "" {
+			if *d, err = time.ParseDuration(value); err == nil && *d < 0 {
+				err = errors.New("must not be negative")
+			}
+			if err != nil {
+				return db.PoolOptions{}, fmt.Errorf("could not parse env var %q: %v", name, err)
+			}
+		}
+	}
+	return options, nil
+}
+
+func parseQuotaAlerts(envVars *env.VarSet, list string, s *Settings) (*QuotaAlerts, error) {
+	var thresholds []float64
+	for _, field := range strings.Split(list, ",") {
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err == nil && (threshold <= 0 || threshold > 100) {
+			err = errors.New("must be percentages between 0 and 100")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", QuotaAlertThresholdsEnvVar, err)
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	alerts := NewQuotaAlerts(s.Webhooks, s.OrgMailer, s.ConsoleAPI, s.HighPrivilegedOauthConfig.Client(s.CreateContext()), thresholds)
+	for name, d := range map[string]*time.Duration{
+		QuotaAlertIntervalEnvVar: &alerts.Interval,
+		QuotaAlertCooldownEnvVar: &alerts.Cooldown,
+	} {
+		if value := envVars.String(name, ""); value != "" {
+			var err error
+			if *d, err = time.ParseDuration(value); err == nil && *d <= 0 {
+				err = errors.New("must be positive")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not parse env var %q: %v", name, err)
+			}
+		}
+	}
+	return alerts, nil
+}
+
+// parseRequestTimeouts reads the request timeout and the timeouts of route
+// groups, which are added to or override the defaults.
+func parseRequestTimeouts(envVars *env.VarSet, s *Settings) error {
+	s.RequestTimeout = TimeoutConstant
+	if value := envVars.String(RequestTimeoutEnvVar, ""); value != "" {
+		var err error
+		if s.RequestTimeout, err = time.ParseDuration(value); err == nil && s.RequestTimeout <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", RequestTimeoutEnvVar, err)
+		}
+	}
+	s.RouteTimeouts = map[string]time.Duration{}
+	for prefix, timeout := range DefaultRouteTimeouts {
+		s.RouteTimeouts[prefix] = timeout
+	}
+	for _, pair := range strings.Split(envVars.String(RouteTimeoutsEnvVar, ""), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") {
+			return fmt.Errorf("could not parse env var %q: expected /path/prefix=timeout, found %q", RouteTimeoutsEnvVar, pair)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err == nil && timeout <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			return fmt.Errorf("could not parse env var %q: %v", RouteTimeoutsEnvVar, err)
+		}
+		s.RouteTimeouts[strings.TrimSpace(parts[0])] = timeout
+	}
+	return nil
+}
+
+func parseAccessReviews(envVars *env.VarSet, s *Settings) (*AccessReviews, error) {
+	reviews := NewAccessReviews(s.AccessReviewStore, s.OrgMailer, s.ConsoleAPI, s.HighPrivilegedOauthConfig.Client(s.CreateContext()))
+	for name, d := range map[string]*time.Duration{
+		AccessReviewIntervalEnvVar: &reviews.Interval,
+		AccessReviewDueInEnvVar:    &reviews.DueIn,
+	} {
+		if value := envVars.String(name, ""); value != "" {
+			var err error
+			if *d, err = time.ParseDuration(value); err == nil && *d <= 0 {
+				err = errors.New("must be positive")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not parse env var %q: %v", name, err)
+			}
+		}
+	}
+	return reviews, nil
+}
+
+func parseAuthAnomalies(envVars *env.VarSet, s *Settings) (*AuthAnalyzer, error) {
+	var geo *GeoDB
+	if path := envVars.String(GeoIPDBPathEnvVar, ""); path != "" {
+		var err error
+		if geo, err = LoadGeoDB(path); err != nil {
+			return nil, fmt.Errorf("could not load the geo database %q: %v", path, err)
+		}
+	}
+	analyzer := NewAuthAnalyzer(s.LoginLocations, geo)
+	analyzer.Record = s.RecordAuditEvent
+	analyzer.Source = s.AppURL
+	if webhookURL := envVars.String(SecurityWebhookURLEnvVar, ""); webhookURL != "" {
+		analyzer.Notifier = NewWebhookNotifier(webhookURL)
+	} else if s.Alerts != nil {
+		analyzer.Notifier = s.Alerts.Notifier
+	}
+	if speed := envVars.String(AuthMaxTravelSpeedEnvVar, ""); speed != "" {
+		var err error
+		if analyzer.MaxSpeed, err = strconv.ParseFloat(speed, 64); err == nil && analyzer.MaxSpeed <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", AuthMaxTravelSpeedEnvVar, err)
+		}
+	}
+	if burst := envVars.String(AuthFailureBurstEnvVar, ""); burst != "" {
+		var err error
+		if analyzer.FailureBurst, err = strconv.Atoi(burst); err == nil && analyzer.FailureBurst < 0 {
+			err = errors.New("must not be negative")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", AuthFailureBurstEnvVar, err)
+		}
+	}
+	if window := envVars.String(AuthFailureWindowEnvVar, ""); window != "" {
+		var err error
+		if analyzer.FailureWindow, err = time.ParseDuration(window); err == nil && analyzer.FailureWindow <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", AuthFailureWindowEnvVar, err)
+		}
+	}
+	return analyzer, nil
+}
+
+func parseCrashWatcher(envVars *env.VarSet, s *Settings) (*CrashWatcher, error) {
+	watcher := NewCrashWatcher(s.Webhooks, s.Incidents, s.OrgMailer, s.ConsoleAPI, s.HighPrivilegedOauthConfig.Client(s.CreateContext()))
+	if threshold := envVars.String(CrashLoopThresholdEnvVar, ""); threshold != "" {
+		var err error
+		if watcher.Threshold, err = strconv.Atoi(threshold); err == nil && watcher.Threshold < 2 {
+			err = errors.New("must be at least 2")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", CrashLoopThresholdEnvVar, err)
+		}
+	}
+	for name, d := range map[string]*time.Duration{
+		CrashLoopWindowEnvVar:     &watcher.Window,
+		CrashLoopEscalationEnvVar: &watcher.Escalation,
+	} {
+		if value := envVars.String(name, ""); value != "" {
+			var err error
+			if *d, err = time.ParseDuration(value); err == nil && *d <= 0 {
+				err = errors.New("must be positive")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not parse env var %q: %v", name, err)
+			}
+		}
+	}
+	return watcher, nil
+}
+
+func parseAlerts(envVars *env.VarSet, notifier *WebhookNotifier, source string) (*AlertEvaluator, error) {
+	alerts := NewAlertEvaluator(notifier, source)
+	var err error
+	if interval := envVars.String(AlertIntervalEnvVar, ""); interval != "" {
+		if alerts.Interval, err = time.ParseDuration(interval); err == nil && alerts.Interval <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", AlertIntervalEnvVar, err)
+		}
+	}
+	if rate := envVars.String(AlertErrorRateEnvVar, ""); rate != "" {
+		if alerts.ErrorRate, err = strconv.ParseFloat(rate, 64); err == nil && (alerts.ErrorRate <= 0 || alerts.ErrorRate >= 1) {
+			err = errors.New("must be between 0 and 1")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", AlertErrorRateEnvVar, err)
+		}
+	}
+	if latency := envVars.String(AlertUpstreamLatencyEnvVar, ""); latency != "" {
+		if alerts.UpstreamLatency, err = time.ParseDuration(latency); err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", AlertUpstreamLatencyEnvVar, err)
+		}
+	}
+	if failures := envVars.String(AlertLoginFailuresEnvVar, ""); failures != "" {
+		if alerts.LoginFailures, err = strconv.Atoi(failures); err != nil {
+			return nil, fmt.Errorf("could not parse env var %q: %v", AlertLoginFailuresEnvVar, err)
+		}
+	}
+	return alerts, nil
+}
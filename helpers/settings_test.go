@@ -1,8 +1,17 @@
 package helpers_test
 
 import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/cloudfoundry-community/go-cfenv"
 	"github.com/govau/cf-common/env"
 
 	"github.com/18F/cg-dashboard/helpers"
@@ -292,3 +301,328 @@ func TestInitSettings(t *testing.T) {
 		})
 	}
 }
+
+func TestInitSettingsSyntheticUsers(t *testing.T) {
+	app, _ := cfenv.Current()
+	envVars := make(map[string]string)
+	for _, tt := range initSettingsTests {
+		if tt.testName != "Basic Valid Local CF Settings" {
+			continue
+		}
+		for k, v := range tt.envVars {
+			envVars[k] = v
+		}
+	}
+	envVars[helpers.SyntheticUsersEnvVar] = "true"
+	s := helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil || !s.SyntheticUsers {
+		t.Errorf("Expected synthetic users to be enabled with a local CF. Found error %v", err)
+	}
+
+	envVars[helpers.LocalCFEnvVar] = "0"
+	envVars[helpers.SecureCookiesEnvVar] = "1"
+	s = helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err == nil {
+		t.Error("Expected synthetic users to be refused without a local CF")
+	}
+}
+
+func TestInitSettingsSessionBackend(t *testing.T) {
+	app, _ := cfenv.Current()
+	envVars := make(map[string]string)
+	for _, tt := range initSettingsTests {
+		if tt.testName != "Basic Valid Local CF Settings" {
+			continue
+		}
+		for k, v := range tt.envVars {
+			envVars[k] = v
+		}
+	}
+	envVars[helpers.SessionBackendEnvVar] = "cookie"
+	s := helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil || s.ServerSideSessions() {
+		t.Errorf("Expected sessions in cookies. Found error %v", err)
+	}
+
+	for _, backend := range []string{"postgres", "redis", "memcached"} {
+		envVars[helpers.SessionBackendEnvVar] = backend
+		s = helpers.Settings{}
+		if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err == nil {
+			t.Errorf("Expected the %s backend to be refused without its settings", backend)
+		}
+	}
+}
+
+func TestInitSettingsSessionTimeouts(t *testing.T) {
+	app, _ := cfenv.Current()
+	envVars := make(map[string]string)
+	for _, tt := range initSettingsTests {
+		if tt.testName != "Basic Valid Local CF Settings" {
+			continue
+		}
+		for k, v := range tt.envVars {
+			envVars[k] = v
+		}
+	}
+	s := helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	if s.SessionTimeouts.Idle != 0 || s.SessionTimeouts.Absolute != helpers.DefaultSessionAbsoluteTimeout {
+		t.Errorf("Unexpected default timeouts %+v", s.SessionTimeouts)
+	}
+
+	envVars[helpers.SessionIdleTimeoutEnvVar] = "30m"
+	envVars[helpers.SessionAbsoluteTimeoutEnvVar] = "12h"
+	s = helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	if s.SessionTimeouts.Idle != 30*time.Minute || s.SessionTimeouts.Absolute != 12*time.Hour {
+		t.Errorf("Unexpected timeouts %+v", s.SessionTimeouts)
+	}
+
+	for _, timeouts := range [][2]string{{"10s", "12h"}, {"13h", "12h"}, {"30m", "forever"}} {
+		envVars[helpers.SessionIdleTimeoutEnvVar] = timeouts[0]
+		envVars[helpers.SessionAbsoluteTimeoutEnvVar] = timeouts[1]
+		s = helpers.Settings{}
+		if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err == nil {
+			t.Errorf("Expected the timeouts %v to be refused", timeouts)
+		}
+	}
+}
+
+func TestInitSettingsSecurityHeaders(t *testing.T) {
+	app, _ := cfenv.Current()
+	envVars := make(map[string]string)
+	for _, tt := range initSettingsTests {
+		if tt.testName != "Basic Valid Local CF Settings" {
+			continue
+		}
+		for k, v := range tt.envVars {
+			envVars[k] = v
+		}
+	}
+	envVars[helpers.SecureCookiesEnvVar] = "true"
+	envVars[helpers.NewRelicIDEnvVar] = "12345"
+	envVars[helpers.NewRelicBrowserLicenseKeyEnvVar] = "abcdef"
+	envVars[helpers.CSPExtraSourcesEnvVar] = "https://cdn.agency.gov, https://fonts.agency.gov"
+	s := helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	if s.SecurityHeaders.HSTSMaxAge != helpers.DefaultHSTSMaxAge {
+		t.Errorf("Unexpected HSTS max age %s", s.SecurityHeaders.HSTSMaxAge)
+	}
+	if csp := s.SecurityHeaders.ContentSecurityPolicy("n"); !strings.Contains(csp,
+		"script-src 'self' 'nonce-n' https://js-agent.newrelic.com https://cdn.agency.gov https://fonts.agency.gov;") {
+		t.Errorf("Unexpected policy %q", csp)
+	}
+
+	envVars[helpers.SecureCookiesEnvVar] = "false"
+	envVars[helpers.HSTSMaxAgeEnvVar] = "1h"
+	s = helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	if s.SecurityHeaders.HSTSMaxAge != 0 {
+		t.Errorf("Expected no HSTS without secure cookies. Found %s", s.SecurityHeaders.HSTSMaxAge)
+	}
+
+	envVars[helpers.CSPExtraSourcesEnvVar] = "https://cdn.agency.gov; script-src *"
+	s = helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err == nil {
+		t.Error("Expected the extra sources to be refused")
+	}
+}
+
+func TestInitSettingsCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+
+	app, _ := cfenv.Current()
+	envVars := make(map[string]string)
+	for _, tt := range initSettingsTests {
+		if tt.testName != "Basic Valid Production CF Settings" {
+			continue
+		}
+		for k, v := range tt.envVars {
+			envVars[k] = v
+		}
+	}
+	s := helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.HTTPClient.Get(server.URL); err == nil {
+		t.Error("Expected the unknown CA to be refused")
+	}
+
+	envVars[helpers.CABundlePathEnvVar] = bundle
+	s = helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.HTTPClient.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected the CA bundle to be trusted. Found %v", err)
+	}
+	res.Body.Close()
+	if http.DefaultClient.Transport != nil {
+		t.Error("Expected the default client to be left alone")
+	}
+
+	envVars[helpers.CABundlePathEnvVar] = filepath.Join(dir, "missing.pem")
+	s = helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err == nil {
+		t.Error("Expected a missing CA bundle to be refused")
+	}
+}
+
+func TestInitSettingsServerLimits(t *testing.T) {
+	app, _ := cfenv.Current()
+	envVars := make(map[string]string)
+	for _, tt := range initSettingsTests {
+		if tt.testName != "Basic Valid Local CF Settings" {
+			continue
+		}
+		for k, v := range tt.envVars {
+			envVars[k] = v
+		}
+	}
+	envVars[helpers.RouteTimeoutsEnvVar] = "/admin/=5m"
+	s := helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	if s.Server.ReadHeaderTimeout != helpers.DefaultReadHeaderTimeout || s.Server.WriteTimeout != 310*time.Second ||
+		s.Server.MaxHeaderBytes != http.DefaultMaxHeaderBytes {
+		t.Errorf("Unexpected default limits %+v", s.Server)
+	}
+
+	envVars[helpers.ServerReadHeaderTimeoutEnvVar] = "5s"
+	envVars[helpers.ServerWriteTimeoutEnvVar] = "2m"
+	envVars[helpers.ServerMaxHeaderBytesEnvVar] = "65536"
+	s = helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	server := s.Server.Server(http.NotFoundHandler())
+	if server.ReadHeaderTimeout != 5*time.Second || server.WriteTimeout != 2*time.Minute || server.MaxHeaderBytes != 65536 {
+		t.Errorf("Unexpected server %+v", server)
+	}
+
+	for name, value := range map[string]string{
+		helpers.ServerIdleTimeoutEnvVar:    "-1s",
+		helpers.ServerReadTimeoutEnvVar:    "forever",
+		helpers.ServerMaxHeaderBytesEnvVar: "0",
+	} {
+		s = helpers.Settings{}
+		envVars[name] = value
+		if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err == nil {
+			t.Errorf("Expected %s=%s to be refused", name, value)
+		}
+		delete(envVars, name)
+	}
+}
+
+func TestInitSettingsMailQueue(t *testing.T) {
+	app, _ := cfenv.Current()
+	envVars := make(map[string]string)
+	for _, tt := range initSettingsTests {
+		if tt.testName != "Basic Valid Local CF Settings" {
+			continue
+		}
+		for k, v := range tt.envVars {
+			envVars[k] = v
+		}
+	}
+	s := helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	if s.MailQueue == nil || s.MailQueue.Workers != helpers.DefaultMailQueueWorkers || s.MailQueue.MaxAttempts != helpers.DefaultMailMaxAttempts {
+		t.Errorf("Unexpected default mail queue %+v", s.MailQueue)
+	}
+
+	envVars[helpers.MailRetryBaseDelayEnvVar] = "-1s"
+	if err := (&helpers.Settings{}).InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err == nil {
+		t.Error("Expected a negative retry delay to be refused")
+	}
+
+	envVars[helpers.MailQueueWorkersEnvVar] = "0"
+	s = helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil || s.MailQueue != nil {
+		t.Errorf("Expected no mail queue without workers. Found %+v, %v", s.MailQueue, err)
+	}
+}
+
+func TestInitSettingsEmailBackend(t *testing.T) {
+	app, _ := cfenv.Current()
+	envVars := make(map[string]string)
+	for _, tt := range initSettingsTests {
+		if tt.testName != "Basic Valid Local CF Settings" {
+			continue
+		}
+		for k, v := range tt.envVars {
+			envVars[k] = v
+		}
+	}
+	delete(envVars, helpers.SMTPHostEnvVar)
+	envVars[helpers.EmailBackendEnvVar] = helpers.EmailBackendSendGrid
+	envVars[helpers.SendGridAPIKeyEnvVar] = "key"
+	s := helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	if s.SendGrid == nil || s.SendGrid.APIKey != "key" || s.SendGrid.URL != helpers.DefaultSendGridURL {
+		t.Errorf("Unexpected SendGrid settings %+v", s.SendGrid)
+	}
+
+	envVars[helpers.EmailBackendEnvVar] = "pigeon"
+	if err := (&helpers.Settings{}).InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err == nil {
+		t.Error("Expected an unknown email backend to be refused")
+	}
+}
+
+func TestInitSettingsAuthAnomalies(t *testing.T) {
+	app, _ := cfenv.Current()
+	envVars := make(map[string]string)
+	for _, tt := range initSettingsTests {
+		if tt.testName != "Basic Valid Local CF Settings" {
+			continue
+		}
+		for k, v := range tt.envVars {
+			envVars[k] = v
+		}
+	}
+	envVars[helpers.AuthFailureBurstEnvVar] = "3"
+	s := helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	if s.AuthAnomalies == nil || s.AuthAnomalies.FailureBurst != 3 || s.AuthAnomalies.Geo != nil {
+		t.Errorf("Unexpected auth anomalies %+v", s.AuthAnomalies)
+	}
+
+	for name, value := range map[string]string{
+		helpers.AuthFailureWindowEnvVar: "-1m",
+		helpers.GeoIPDBPathEnvVar:       "testdata/missing-geoip.csv",
+	} {
+		vars := make(map[string]string)
+		for k, v := range envVars {
+			vars[k] = v
+		}
+		vars[name] = value
+		if err := (&helpers.Settings{}).InitSettings(env.NewVarSet(env.WithMapLookup(vars)), app); err == nil {
+			t.Errorf("Expected %s=%s to be refused", name, value)
+		}
+	}
+}
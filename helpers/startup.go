@@ -0,0 +1,212 @@
+package helpers
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+)
+
+// StartupReport records how the dashboard started: the port, the endpoints
+// it talks to, the features that are turned on and how long each step of the
+// startup took. Each step is logged as it happens, as key=value pairs that
+// log drains can parse, and the whole report is served to admins.
+type StartupReport struct {
+	mu     sync.Mutex
+	out    io.Writer
+	report StartupReportSnapshot
+}
+
+// StartupReportSnapshot is a copy of a StartupReport.
+type StartupReportSnapshot struct {
+	StartedAt time.Time `json:"started_at"`
+	// ReadyAt is when the dashboard started serving requests. Nil until then.
+	ReadyAt        *time.Time        `json:"ready_at,omitempty"`
+	Port           string            `json:"port"`
+	Environment    string            `json:"environment,omitempty"`
+	BuildInfo      string            `json:"build_info"`
+	Endpoints      map[string]string `json:"endpoints"`
+	Features       map[string]bool   `json:"features"`
+	SessionBackend string            `json:"session_backend"`
+	Steps          []StartupStep     `json:"steps"`
+	Warnings       []string          `json:"warnings"`
+}
+
+// StartupStep is a step of the startup and how long it took.
+type StartupStep struct {
+	Name       string  `json:"name"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// NewStartupReport starts a report, logging to out.
+func NewStartupReport(out io.Writer, port string) *StartupReport {
+	r := &StartupReport{out: out, report: StartupReportSnapshot{
+		StartedAt: time.Now(),
+		Port:      port,
+		Endpoints: map[string]string{},
+		Features:  map[string]bool{},
+		Steps:     []StartupStep{},
+		Warnings:  []string{},
+	}}
+	r.log("event=start port=%s", port)
+	return r
+}
+
+// Step runs and times a step of the startup. A nil StartupReport only runs
+// it.
+func (r *StartupReport) Step(name string, step func() error) error {
+	if r == nil {
+		return step()
+	}
+	start := time.Now()
+	err := step()
+	s := StartupStep{Name: name, DurationMS: float64(time.Since(start)) / float64(time.Millisecond)}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	r.mu.Lock()
+	r.report.Steps = append(r.report.Steps, s)
+	r.mu.Unlock()
+	if err != nil {
+		r.log("event=step name=%s duration_ms=%.1f error=%q", name, s.DurationMS, s.Error)
+	} else {
+		r.log("event=step name=%s duration_ms=%.1f", name, s.DurationMS)
+	}
+	return err
+}
+
+// Warn records a problem that doesn't stop the dashboard from starting.
+func (r *StartupReport) Warn(warning string) {
+	r.mu.Lock()
+	r.report.Warnings = append(r.report.Warnings, warning)
+	r.mu.Unlock()
+	r.log("event=warning message=%q", warning)
+}
+
+// Info logs a fact about the startup that's not worth keeping in the report.
+func (r *StartupReport) Info(message string) {
+	r.log("event=info message=%q", message)
+}
+
+// Describe records the endpoints, features and session backend of the
+// settings.
+func (r *StartupReport) Describe(s *Settings) {
+	r.mu.Lock()
+	r.report.Environment = s.Environment
+	r.report.BuildInfo = s.BuildInfo
+	r.report.Endpoints = map[string]string{
+		"app":   s.AppURL,
+		"api":   s.ConsoleAPI,
+		"login": s.LoginURL,
+		"uaa":   s.UaaURL,
+		"log":   s.LogURL,
+	}
+	if s.LogCacheURL != "" {
+		r.report.Endpoints["log_cache"] = s.LogCacheURL
+	}
+	r.report.Features = map[string]bool{
+		"database":               s.DB != nil,
+		"database_encryption":    s.DBCipher != nil,
+		"opaque_access_tokens":   s.OpaqueAccessTokens,
+		"telemetry":              s.Telemetry != nil,
+		"alerts":                 s.Alerts != nil,
+		"quota_alerts":           s.QuotaAlerts != nil,
+		"approvals":              s.ApprovalPolicy != nil,
+		"webhooks":               s.Webhooks != nil,
+		"proxy_quotas":           s.ProxyQuota != nil,
+		"warm_caches":            s.WarmCaches,
+		"synthetic_users":        s.SyntheticUsers,
+		"pprof":                  s.PProfEnabled,
+		"maintenance":            s.MaintenanceMessage != "",
+		"verbose_logging":        s.VerboseLogging,
+		"local_cf":               s.LocalCF,
+		"secure_cookies":         s.SecureCookies,
+		"tls":                    s.TLS != nil,
+		"acme":                   s.TLS != nil && s.TLS.ACME(),
+		"hashed_assets":          s.Assets != nil,
+		"client_secret_rotation": s.ClientSecrets != nil && s.ClientSecrets.Next != "",
+	}
+	r.report.SessionBackend = sessionBackend(s)
+	snapshot := r.snapshot()
+	r.mu.Unlock()
+
+	for _, name := range sortedKeys(snapshot.Endpoints) {
+		r.log("event=endpoint name=%s url=%s", name, snapshot.Endpoints[name])
+	}
+	var enabled []string
+	for name, on := range snapshot.Features {
+		if on {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	r.log("event=features enabled=%s", strings.Join(enabled, ","))
+	r.log("event=sessions backend=%s", snapshot.SessionBackend)
+}
+
+// Ready records that the dashboard is about to serve requests.
+func (r *StartupReport) Ready() {
+	r.mu.Lock()
+	now := time.Now()
+	r.report.ReadyAt = &now
+	elapsed := now.Sub(r.report.StartedAt)
+	r.mu.Unlock()
+	r.log("event=ready duration_ms=%.1f", float64(elapsed)/float64(time.Millisecond))
+}
+
+// Snapshot returns a copy of the report.
+func (r *StartupReport) Snapshot() StartupReportSnapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshot()
+}
+
+func (r *StartupReport) snapshot() StartupReportSnapshot {
+	s := r.report
+	s.Endpoints = map[string]string{}
+	for k, v := range r.report.Endpoints {
+		s.Endpoints[k] = v
+	}
+	s.Features = map[string]bool{}
+	for k, v := range r.report.Features {
+		s.Features[k] = v
+	}
+	s.Steps = append([]StartupStep{}, r.report.Steps...)
+	s.Warnings = append([]string{}, r.report.Warnings...)
+	return s
+}
+
+func (r *StartupReport) log(format string, args ...interface{}) {
+	fmt.Fprintf(r.out, "startup "+format+"\n", args...)
+}
+
+// sessionBackend describes where the sessions are saved.
+func sessionBackend(s *Settings) string {
+	if store, ok := s.Sessions.(*ServerSideStore); ok {
+		switch store.Backend.(type) {
+		case *RedisSessions:
+			return RedisSessionBackend
+		case *db.SQLSessionStore:
+			return PostgresSessionBackend
+		}
+		return "server"
+	}
+	if store, ok := s.Sessions.(*SizeMonitoredStore); ok && store.Overflow != nil {
+		return "cookie+filesystem"
+	}
+	return "cookie"
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,69 @@
+package helpers_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestStartupReport(t *testing.T) {
+	var out bytes.Buffer
+	report := helpers.NewStartupReport(&out, "8080")
+	if err := report.Step("settings", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := report.Step("templates", func() error { return errors.New("no templates") }); err == nil {
+		t.Error("expected the step's error")
+	}
+	report.Warn("UAA client configuration drift: scope")
+	report.Describe(&helpers.Settings{
+		ConsoleAPI: "https://api.example.com",
+		UaaURL:     "https://uaa.example.com",
+		WarmCaches: true,
+	})
+	report.Ready()
+
+	snapshot := report.Snapshot()
+	if snapshot.Port != "8080" || snapshot.ReadyAt == nil {
+		t.Errorf("unexpected report %+v", snapshot)
+	}
+	if len(snapshot.Steps) != 2 || snapshot.Steps[0].Name != "settings" || snapshot.Steps[1].Error != "no templates" {
+		t.Errorf("unexpected steps %+v", snapshot.Steps)
+	}
+	if snapshot.Endpoints["api"] != "https://api.example.com" || !snapshot.Features["warm_caches"] || snapshot.Features["database"] {
+		t.Errorf("unexpected endpoints %v or features %v", snapshot.Endpoints, snapshot.Features)
+	}
+	if snapshot.SessionBackend != "cookie" || len(snapshot.Warnings) != 1 {
+		t.Errorf("unexpected report %+v", snapshot)
+	}
+
+	for _, line := range []string{
+		"startup event=start port=8080",
+		"startup event=step name=templates",
+		`error="no templates"`,
+		`startup event=warning message="UAA client configuration drift: scope"`,
+		"startup event=endpoint name=api url=https://api.example.com",
+		"startup event=features enabled=warm_caches",
+		"startup event=sessions backend=cookie",
+		"startup event=ready",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected %q in the log:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestNilStartupReportStep(t *testing.T) {
+	var report *helpers.StartupReport
+	ran := false
+	report.Step("settings", func() error {
+		ran = true
+		return nil
+	})
+	if !ran {
+		t.Error("expected the step to run")
+	}
+}
@@ -0,0 +1,93 @@
+package helpers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStreamMaxPerUser         = 5
+	defaultStreamIdleTimeout        = 5 * time.Minute
+	defaultStreamTokenCheckInterval = time.Minute
+)
+
+// StreamGuard holds the rules for long lived streaming connections (e.g. log
+// streaming over websockets) so that they can't be used to hold
+// unauthenticated or zombie connections open.
+type StreamGuard struct {
+	// AllowedOrigins are the origins, besides the app URL, that may open streams.
+	AllowedOrigins []string
+	// MaxPerUser is the maximum number of concurrent streams per user.
+	MaxPerUser int
+	// IdleTimeout closes streams that have not sent or received anything.
+	IdleTimeout time.Duration
+	// TokenCheckInterval is how often the user's session is checked while a
+	// stream is open.
+	TokenCheckInterval time.Duration
+
+	mu   sync.Mutex
+	open map[string]int
+}
+
+// NewStreamGuard creates a StreamGuard that allows the app URL as origin.
+func NewStreamGuard(appURL string) *StreamGuard {
+	return &StreamGuard{
+		AllowedOrigins:     []string{appURL},
+		MaxPerUser:         defaultStreamMaxPerUser,
+		IdleTimeout:        defaultStreamIdleTimeout,
+		TokenCheckInterval: defaultStreamTokenCheckInterval,
+		open:               make(map[string]int),
+	}
+}
+
+// CheckOrigin returns true if the Origin header of the request is one of
+// the allowed origins. Browsers always send it for websockets, so a missing
+// Origin is rejected.
+func (g *StreamGuard) CheckOrigin(req *http.Request) bool {
+	origin, err := url.Parse(req.Header.Get("Origin"))
+	if err != nil || origin.Host == "" {
+		return false
+	}
+	for _, allowed := range g.AllowedOrigins {
+		u, err := url.Parse(allowed)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(u.Scheme, origin.Scheme) && strings.EqualFold(u.Host, origin.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Acquire reserves a stream for the user. It returns false if the user
+// already has the maximum number of streams open. Otherwise the returned
+// release func must be called when the stream closes.
+func (g *StreamGuard) Acquire(user string) (release func(), ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.open[user] >= g.MaxPerUser {
+		return nil, false
+	}
+	g.open[user]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if g.open[user]--; g.open[user] <= 0 {
+				delete(g.open, user)
+			}
+		})
+	}, true
+}
+
+// Open returns the number of streams the user has open.
+func (g *StreamGuard) Open(user string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.open[user]
+}
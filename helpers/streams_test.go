@@ -0,0 +1,63 @@
+package helpers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+var checkOriginTests = []struct {
+	testName string
+	origin   string
+	want     bool
+}{
+	{"App Origin", "https://hostname", true},
+	{"Extra Allowed Origin", "https://other.hostname", true},
+	{"Unknown Origin", "https://evil.example.com", false},
+	{"Scheme Mismatch", "http://hostname", false},
+	{"Missing Origin", "", false},
+}
+
+func TestStreamGuardCheckOrigin(t *testing.T) {
+	guard := helpers.NewStreamGuard("https://hostname")
+	guard.AllowedOrigins = append(guard.AllowedOrigins, "https://other.hostname")
+	for _, test := range checkOriginTests {
+		req, _ := http.NewRequest("GET", "/log/stream", nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		if got := guard.CheckOrigin(req); got != test.want {
+			t.Errorf("Test %s: expected %t, found %t", test.testName, test.want, got)
+		}
+	}
+}
+
+func TestStreamGuardAcquire(t *testing.T) {
+	guard := helpers.NewStreamGuard("https://hostname")
+	guard.MaxPerUser = 2
+
+	release1, ok := guard.Acquire("user")
+	if !ok {
+		t.Fatal("Expected first stream to be allowed")
+	}
+	if _, ok := guard.Acquire("user"); !ok {
+		t.Fatal("Expected second stream to be allowed")
+	}
+	if _, ok := guard.Acquire("user"); ok {
+		t.Error("Expected third stream to be rejected")
+	}
+	if _, ok := guard.Acquire("other-user"); !ok {
+		t.Error("Expected other users to be unaffected")
+	}
+
+	// Releasing twice must only free one slot.
+	release1()
+	release1()
+	if open := guard.Open("user"); open != 1 {
+		t.Errorf("Expected 1 open stream, found %d", open)
+	}
+	if _, ok := guard.Acquire("user"); !ok {
+		t.Error("Expected stream to be allowed after release")
+	}
+}
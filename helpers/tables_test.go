@@ -0,0 +1,65 @@
+package helpers
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCSVTableWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewCSVTableWriter(&out)
+	w.WriteRow([]string{"name", "state"})
+	w.WriteRow([]string{"web, api", "STARTED"})
+	w.WriteRow([]string{"=HYPERLINK(\"http://evil\")", "-1"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "name,state\n\"web, api\",STARTED\n\"'=HYPERLINK(\"\"http://evil\"\")\",'-1\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q. Found %q", expected, out.String())
+	}
+}
+
+func TestXLSXTableWriter(t *testing.T) {
+	var out bytes.Buffer
+	w, err := NewXLSXTableWriter(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteRow([]string{"name", "state"})
+	w.WriteRow([]string{"<web & api>", " STARTED"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(r)
+		parts[f.Name] = string(b)
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels"} {
+		if parts[name] == "" {
+			t.Errorf("Expected part %s", name)
+		}
+	}
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, expected := range []string{
+		`<row><c t="inlineStr"><is><t xml:space="preserve">name</t></is></c>`,
+		`<t xml:space="preserve">&lt;web &amp; api&gt;</t>`,
+		`<t xml:space="preserve"> STARTED</t></is></c></row></sheetData></worksheet>`,
+	} {
+		if !strings.Contains(sheet, expected) {
+			t.Errorf("Expected the sheet to contain %s. Found %s", expected, sheet)
+		}
+	}
+}
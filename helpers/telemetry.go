@@ -0,0 +1,118 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+var telemetryLog = logging.New("telemetry")
+
+// defaultTelemetryInterval is how often usage counts are reported.
+const defaultTelemetryInterval = 24 * time.Hour
+
+// Telemetry counts how often each dashboard feature is used and periodically
+// reports the counts to an operator-configured endpoint. Only feature names
+// and counts are reported, never anything that identifies users, orgs or
+// apps.
+type Telemetry struct {
+	// URL is where the reports are POSTed.
+	URL      string
+	Interval time.Duration
+	// BuildInfo is included so reports can be compared across releases.
+	BuildInfo string
+	Client    *http.Client
+
+	mu     sync.Mutex
+	since  time.Time
+	counts map[string]int64
+}
+
+// TelemetryReport is the body of a telemetry report.
+type TelemetryReport struct {
+	BuildInfo   string           `json:"build_info"`
+	PeriodStart time.Time        `json:"period_start"`
+	PeriodEnd   time.Time        `json:"period_end"`
+	Features    map[string]int64 `json:"features"`
+}
+
+// NewTelemetry creates a Telemetry reporting to the URL.
+func NewTelemetry(url, buildInfo string) *Telemetry {
+	return &Telemetry{
+		URL:       url,
+		Interval:  defaultTelemetryInterval,
+		BuildInfo: buildInfo,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+		since:     time.Now(),
+		counts:    make(map[string]int64),
+	}
+}
+
+// Record counts a use of the feature.
+func (t *Telemetry) Record(feature string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counts[feature]++
+}
+
+// Flush reports the counts since the last report. On failure the counts are
+// kept and reported with the next report.
+func (t *Telemetry) Flush() error {
+	t.mu.Lock()
+	report := TelemetryReport{
+		BuildInfo:   t.BuildInfo,
+		PeriodStart: t.since,
+		PeriodEnd:   time.Now(),
+		Features:    t.counts,
+	}
+	t.counts = make(map[string]int64)
+	t.since = report.PeriodEnd
+	t.mu.Unlock()
+
+	if len(report.Features) == 0 {
+		return nil
+	}
+	err := t.send(report)
+	if err != nil {
+		// Put the counts back for the next report.
+		t.mu.Lock()
+		for feature, count := range report.Features {
+			t.counts[feature] += count
+		}
+		t.since = report.PeriodStart
+		t.mu.Unlock()
+	}
+	return err
+}
+
+func (t *Telemetry) send(report TelemetryReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	res, err := t.Client.Post(t.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d from telemetry endpoint", res.StatusCode)
+	}
+	return nil
+}
+
+// Start reports the counts every Interval until the process exits.
+func (t *Telemetry) Start() {
+	go func() {
+		for range time.Tick(t.Interval) {
+			if err := t.Flush(); err != nil {
+				telemetryLog.Warnf("unable to send telemetry: %v", err)
+			}
+		}
+	}()
+}
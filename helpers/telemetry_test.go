@@ -0,0 +1,57 @@
+package helpers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestTelemetryFlush(t *testing.T) {
+	var reports []helpers.TelemetryReport
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var report helpers.TelemetryReport
+		json.NewDecoder(r.Body).Decode(&report)
+		reports = append(reports, report)
+	}))
+	defer server.Close()
+
+	telemetry := helpers.NewTelemetry(server.URL, "build-1")
+	// Nothing to report.
+	if err := telemetry.Flush(); err != nil {
+		t.Errorf("Expected nil error, found %s", err.Error())
+	}
+
+	telemetry.Record("GET /v2/apps")
+	telemetry.Record("GET /v2/apps")
+	if err := telemetry.Flush(); err == nil {
+		t.Error("Expected an error from the unavailable endpoint")
+	}
+
+	// The counts are kept until they are reported.
+	fail = false
+	telemetry.Record("GET /ping")
+	if err := telemetry.Flush(); err != nil {
+		t.Fatalf("Expected nil error, found %s", err.Error())
+	}
+	if len(reports) != 1 {
+		t.Fatalf("Expected 1 report, found %d", len(reports))
+	}
+	report := reports[0]
+	if report.BuildInfo != "build-1" || len(report.Features) != 2 ||
+		report.Features["GET /v2/apps"] != 2 || report.Features["GET /ping"] != 1 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	// Reported counts are reset.
+	if err := telemetry.Flush(); err != nil || len(reports) != 1 {
+		t.Errorf("Expected nothing more to report, found %d reports (%v)", len(reports), err)
+	}
+}
@@ -4,23 +4,58 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"os"
 	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/18F/cg-dashboard/db"
 )
 
 const (
 	// InviteEmailTemplate is the template key for the invite email.
 	InviteEmailTemplate = "INVITE_EMAIL_TEMPLATE"
+	// InviteEmailTextTemplate is the template key for the plain text part of
+	// the invite email.
+	InviteEmailTextTemplate = "INVITE_EMAIL_TEXT_TEMPLATE"
+	// BroadcastEmailTemplate is the template key for operator broadcast emails.
+	BroadcastEmailTemplate = "BROADCAST_EMAIL_TEMPLATE"
 	// IndexTemplate is the template key for the index.html.
 	IndexTemplate = "INDEX_HTML_TEMPLATE"
+	// PageTemplate is the template key for the server-rendered pages.
+	PageTemplate = "PAGE_HTML_TEMPLATE"
 )
 
+// DefaultEmailProductName is the product name in the emails when the theme
+// has none.
+const DefaultEmailProductName = "cloud.gov"
+
+// emailTemplateFiles are the file names of the email templates. Templates
+// ending in .txt are plain text, so they're not HTML escaped.
+var emailTemplateFiles = map[string]string{
+	InviteEmailTemplate:     "invite.html",
+	InviteEmailTextTemplate: "invite.txt",
+	BroadcastEmailTemplate:  "broadcast.html",
+}
+
 // findTemplates will try to construct to final path of where to find templates
-// given the basePath of where to look.
-func findTemplates(basePath string) map[string][]string {
-	return map[string][]string{
-		IndexTemplate:       {filepath.Join(basePath, "web", "index.html")},
-		InviteEmailTemplate: {filepath.Join(basePath, "mail", "invite.html")},
+// given the basePath of where to look. The email templates in emailPath, if
+// set, override the ones in the basePath, file by file.
+func findTemplates(basePath, emailPath string) map[string][]string {
+	paths := map[string][]string{
+		IndexTemplate: {filepath.Join(basePath, "web", "index.html")},
+		PageTemplate:  {filepath.Join(basePath, "web", "page.html")},
+	}
+	for templateName, file := range emailTemplateFiles {
+		path := filepath.Join(basePath, "mail", file)
+		if emailPath != "" {
+			if _, err := os.Stat(filepath.Join(emailPath, file)); err == nil {
+				path = filepath.Join(emailPath, file)
+			}
+		}
+		paths[templateName] = []string{path}
 	}
+	return paths
 }
 
 // Templates serve as a mapping to various templates.
@@ -30,19 +65,46 @@ func findTemplates(basePath string) map[string][]string {
 // Similar to https://hackernoon.com/golang-template-2-template-composition-and-how-to-organize-template-files-4cb40bcdf8f6
 type Templates struct {
 	templates map[string]*template.Template
+	// text are the plain text templates.
+	text map[string]*texttemplate.Template
+	// Assets is used by the asset template function to find the hashed file
+	// names of the frontend assets.
+	Assets AssetManifest
 }
 
 // InitTemplates will try to parse the templates.
-func InitTemplates(basePath string) (*Templates, error) {
-	templates := make(map[string]*template.Template)
-	for templateName, templatePath := range findTemplates(basePath) {
-		tpl, err := template.ParseFiles(templatePath...)
+// Templates can use {{asset "bundle.js"}} to refer to a frontend asset by the
+// name it was given in the asset manifest. The email templates in emailPath,
+// when it's set, replace the default ones of the same name.
+func InitTemplates(basePath, emailPath string) (*Templates, error) {
+	if emailPath != "" {
+		if info, err := os.Stat(emailPath); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("the email templates path %q is not a directory", emailPath)
+		}
+	}
+	t := &Templates{
+		templates: make(map[string]*template.Template),
+		text:      make(map[string]*texttemplate.Template),
+	}
+	funcs := template.FuncMap{
+		"asset": func(name string) string { return t.Assets.Path(name) },
+	}
+	for templateName, templatePath := range findTemplates(basePath, emailPath) {
+		if strings.HasSuffix(templatePath[0], ".txt") {
+			tpl, err := texttemplate.ParseFiles(templatePath...)
+			if err != nil {
+				return nil, err
+			}
+			t.text[templateName] = tpl
+			continue
+		}
+		tpl, err := template.New(filepath.Base(templatePath[0])).Funcs(funcs).ParseFiles(templatePath...)
 		if err != nil {
 			return nil, err
 		}
-		templates[templateName] = tpl
+		t.templates[templateName] = tpl
 	}
-	return &Templates{templates}, nil
+	return t, nil
 }
 
 func (t *Templates) getTemplate(templateKey string) (*template.Template, error) {
@@ -52,31 +114,119 @@ func (t *Templates) getTemplate(templateKey string) (*template.Template, error)
 	return nil, fmt.Errorf("unable to find template with key %s", templateKey)
 }
 
-// inviteEmail provides struct for the templates/mail/invite.tmpl
+// EmailBranding is the deployment's branding of the emails, from its theme.
+type EmailBranding struct {
+	ProductName  string
+	LogoURL      string
+	PrimaryColor string
+	Footer       string
+}
+
+// NewEmailBranding returns the branding of the emails with the theme. Logos
+// served by the dashboard itself are linked with its URL, since emails are
+// read elsewhere.
+func NewEmailBranding(theme db.Theme, appURL string) EmailBranding {
+	b := EmailBranding{
+		ProductName:  theme.ProductName,
+		LogoURL:      theme.LogoURL,
+		PrimaryColor: theme.PrimaryColor,
+		Footer:       theme.Footer,
+	}
+	if b.ProductName == "" {
+		b.ProductName = DefaultEmailProductName
+	}
+	if strings.HasPrefix(b.LogoURL, "/") && !strings.HasPrefix(b.LogoURL, "//") {
+		b.LogoURL = strings.TrimSuffix(appURL, "/") + b.LogoURL
+	}
+	return b
+}
+
+// inviteEmail provides struct for the templates/mail/invite.html and
+// invite.txt
 type inviteEmail struct {
 	URL string
+	EmailBranding
 }
 
-// GetInviteEmail gets the filled in invite email template.
-func (t *Templates) GetInviteEmail(rw io.Writer, url string) error {
+// GetInviteEmail gets the filled in HTML and plain text invite email
+// templates.
+func (t *Templates) GetInviteEmail(html, text io.Writer, url string, branding EmailBranding) error {
 	tpl, err := t.getTemplate(InviteEmailTemplate)
 	if err != nil {
 		return err
 	}
-	return tpl.Execute(rw, inviteEmail{url})
+	textTpl, ok := t.text[InviteEmailTextTemplate]
+	if !ok {
+		return fmt.Errorf("unable to find template with key %s", InviteEmailTextTemplate)
+	}
+	data := inviteEmail{URL: url, EmailBranding: branding}
+	if err := tpl.Execute(html, data); err != nil {
+		return err
+	}
+	return textTpl.Execute(text, data)
+}
+
+// broadcastEmail provides struct for the templates/mail/broadcast.html
+type broadcastEmail struct {
+	Subject    string
+	Paragraphs []string
+}
+
+// GetBroadcastEmail gets the filled in broadcast email template. The plain
+// text message is split into paragraphs on blank lines.
+func (t *Templates) GetBroadcastEmail(rw io.Writer, subject, message string) error {
+	tpl, err := t.getTemplate(BroadcastEmailTemplate)
+	if err != nil {
+		return err
+	}
+	var paragraphs []string
+	for _, p := range strings.Split(strings.Replace(message, "\r\n", "\n", -1), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return tpl.Execute(rw, broadcastEmail{Subject: subject, Paragraphs: paragraphs})
 }
 
-// GetIndex gets the filled in index.html
-func (t *Templates) GetIndex(rw io.Writer, csrfToken, gaTrackingID, newRelicID,
-	newRelicBrowserLicenseKey string) error {
+// GetIndex gets the filled in index.html, branded with the theme. Its inline
+// scripts carry the content security policy nonce.
+func (t *Templates) GetIndex(rw io.Writer, csrfToken, cspNonce, gaTrackingID, newRelicID,
+	newRelicBrowserLicenseKey string, theme db.Theme) error {
 	tpl, err := t.getTemplate(IndexTemplate)
 	if err != nil {
 		return err
 	}
 	return tpl.Execute(rw, map[string]interface{}{
 		"csrfToken":                     csrfToken,
+		"cspNonce":                      cspNonce,
 		"GA_TRACKING_ID":                gaTrackingID,
 		"NEW_RELIC_ID":                  newRelicID,
 		"NEW_RELIC_BROWSER_LICENSE_KEY": newRelicBrowserLicenseKey,
+		"theme":                         theme,
 	})
 }
+
+// PageLink is the action a server-rendered page offers.
+type PageLink struct {
+	Text string
+	URL  string
+}
+
+// Page is a server-rendered page for when the frontend can't be used, such as
+// logging in, errors and maintenance. Pages need no JavaScript and meet
+// Section 508.
+type Page struct {
+	Title      string
+	Paragraphs []string
+	Link       *PageLink
+	Theme      db.Theme
+}
+
+// GetPage gets the filled in server-rendered page.
+func (t *Templates) GetPage(rw io.Writer, page Page) error {
+	tpl, err := t.getTemplate(PageTemplate)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(rw, page)
+}
@@ -3,35 +3,40 @@ package helpers_test
 import (
 	"bytes"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/18F/cg-dashboard/db"
 	"github.com/18F/cg-dashboard/helpers"
 )
 
 func TestInitTemplates(t *testing.T) {
 	// Valid case: correct base path to templates.
-	_, err := helpers.InitTemplates(filepath.Join("testdata", "templates"))
+	_, err := helpers.InitTemplates(filepath.Join("testdata", "templates"), "")
 	if err != nil {
 		t.Errorf("Expected to find the templates. %s", err.Error())
 	}
 	// Invalid case: incorrect base path to templates.
-	_, err = helpers.InitTemplates(filepath.Join("testdata", "non-existent-path"))
+	_, err = helpers.InitTemplates(filepath.Join("testdata", "non-existent-path"), "")
 	if err == nil {
 		t.Error("Expected not to find the templates")
 	}
 }
 
 func TestGetInviteEmail(t *testing.T) {
-	templates, err := helpers.InitTemplates(filepath.Join("testdata", "templates"))
+	templates, err := helpers.InitTemplates(filepath.Join("testdata", "templates"), "")
 	if err != nil {
 		t.Errorf("Expected to find the templates. %s", err.Error())
 	}
-	body := new(bytes.Buffer)
-	err = templates.GetInviteEmail(body, "http://test-url.com")
+	body, text := new(bytes.Buffer), new(bytes.Buffer)
+	err = templates.GetInviteEmail(body, text, "http://test-url.com", helpers.NewEmailBranding(db.Theme{}, ""))
 	if err != nil {
 		t.Errorf("Expected no error getting the invite email. %s", err.Error())
 	}
+	if text.String() != "Join cloud.gov: http://test-url.com\n" {
+		t.Errorf("Unexpected plain text invite e-mail %q", text.String())
+	}
 	inviteTpl, err := ioutil.ReadFile(filepath.Join("testdata", "templates", "mail", "invite.html"))
 	if err != nil {
 		t.Errorf("Expected no error reading the invite email. %s", err.Error())
@@ -45,14 +50,46 @@ func TestGetInviteEmail(t *testing.T) {
 	}
 }
 
+func TestInitTemplatesEmailOverride(t *testing.T) {
+	dir, err := ioutil.TempDir("", "email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "invite.txt"), []byte("Welcome to {{.ProductName}} <{{.URL}}>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	templates, err := helpers.InitTemplates(filepath.Join("testdata", "templates"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	branding := helpers.NewEmailBranding(db.Theme{ProductName: "Agency Cloud", LogoURL: "/skins/logo.png"}, "https://dashboard.example.gov/")
+	if branding.LogoURL != "https://dashboard.example.gov/skins/logo.png" {
+		t.Errorf("Expected the logo to be linked with the app URL. Found %q", branding.LogoURL)
+	}
+	body, text := new(bytes.Buffer), new(bytes.Buffer)
+	if err := templates.GetInviteEmail(body, text, "http://test-url.com", branding); err != nil {
+		t.Fatal(err)
+	}
+	// The plain text isn't HTML escaped, and the HTML template not overridden
+	// is the default.
+	if text.String() != "Welcome to Agency Cloud <http://test-url.com>" || body.Len() == 0 {
+		t.Errorf("Unexpected invite e-mail %q", text.String())
+	}
+
+	if _, err := helpers.InitTemplates(filepath.Join("testdata", "templates"), filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected a missing email templates path to be refused")
+	}
+}
+
 func TestGetIndex(t *testing.T) {
-	templates, err := helpers.InitTemplates(filepath.Join("testdata", "templates"))
+	templates, err := helpers.InitTemplates(filepath.Join("testdata", "templates"), "")
 	if err != nil {
 		t.Errorf("Expected to find the templates. %s", err.Error())
 	}
 	body := new(bytes.Buffer)
-	err = templates.GetIndex(body, "testCSRFToken", "test-gaTrackingID",
-		"test-newRelicID", "test-newRelicBrowserLicenseKey")
+	err = templates.GetIndex(body, "testCSRFToken", "", "test-gaTrackingID",
+		"test-newRelicID", "test-newRelicBrowserLicenseKey", db.Theme{})
 	if err != nil {
 		t.Errorf("Expected no error getting the index html. %s", err.Error())
 	}
@@ -68,3 +105,54 @@ func TestGetIndex(t *testing.T) {
 		t.Logf("writing expected file to %s", filepath.Join("testdata", "templates", "web", "index.html.returned"))
 	}
 }
+
+func TestGetBroadcastEmail(t *testing.T) {
+	templates, err := helpers.InitTemplates(filepath.Join("testdata", "templates"), "")
+	if err != nil {
+		t.Errorf("Expected to find the templates. %s", err.Error())
+	}
+	body := new(bytes.Buffer)
+	err = templates.GetBroadcastEmail(body, "Scheduled maintenance",
+		"The platform will be upgraded on Saturday.\r\n\r\nApps will <b>not</b> be restarted.")
+	if err != nil {
+		t.Errorf("Expected no error getting the broadcast email. %s", err.Error())
+	}
+	broadcastTpl, err := ioutil.ReadFile(filepath.Join("testdata", "templates", "mail", "broadcast.html.expected"))
+	if err != nil {
+		t.Errorf("Expected no error reading the broadcast email. %s", err.Error())
+		return
+	}
+	if string(broadcastTpl) != string(body.Bytes()) {
+		t.Error("Expected broadcast e-mail template does not match generated broadcast e-mail template.")
+		// Helpful for generating the new broadcast data.
+		ioutil.WriteFile(filepath.Join("testdata", "templates", "mail", "broadcast.html.returned"), body.Bytes(), 0444)
+		t.Logf("writing expected file to %s", filepath.Join("testdata", "templates", "mail", "broadcast.html.returned"))
+	}
+}
+
+func TestGetPage(t *testing.T) {
+	templates, err := helpers.InitTemplates(filepath.Join("testdata", "templates"), "")
+	if err != nil {
+		t.Errorf("Expected to find the templates. %s", err.Error())
+	}
+	body := new(bytes.Buffer)
+	err = templates.GetPage(body, helpers.Page{
+		Title:      "Your login could not be completed",
+		Paragraphs: []string{"The login was cancelled or denied.", "Try <again>."},
+		Link:       &helpers.PageLink{Text: "Log in again", URL: "/handshake"},
+		Theme:      db.Theme{ProductName: "Agency Cloud", PrimaryColor: "#112e51", Footer: "Operated by the Agency"},
+	})
+	if err != nil {
+		t.Errorf("Expected no error getting the page. %s", err.Error())
+	}
+	pageTpl, err := ioutil.ReadFile(filepath.Join("testdata", "templates", "web", "page.html.expected"))
+	if err != nil {
+		t.Errorf("Expected no error reading the page. %s", err.Error())
+	}
+	if string(pageTpl) != string(body.Bytes()) {
+		t.Error("Expected page template does not match generated page template.")
+		// Helpful for generating the new page data.
+		ioutil.WriteFile(filepath.Join("testdata", "templates", "web", "page.html.returned"), body.Bytes(), 0444)
+		t.Logf("writing expected file to %s", filepath.Join("testdata", "templates", "web", "page.html.returned"))
+	}
+}
@@ -22,4 +22,18 @@ func (_m *Mailer) SendEmail(emailAddress string, subject string, body []byte) er
 	return r0
 }
 
+// SendMultipartEmail provides a mock function with given fields: emailAddress, subject, html, text
+func (_m *Mailer) SendMultipartEmail(emailAddress string, subject string, html []byte, text []byte) error {
+	ret := _m.Called(emailAddress, subject, html, text)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, []byte, []byte) error); ok {
+		r0 = rf(emailAddress, subject, html, text)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 var _ mailer.Mailer = (*Mailer)(nil)
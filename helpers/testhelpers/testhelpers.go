@@ -2,6 +2,8 @@ package testhelpers
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -93,6 +95,22 @@ var ValidTokenData = map[string]interface{}{
 	"token": oauth2.Token{Expiry: time.Time{}, AccessToken: "sampletoken"},
 }
 
+// NewJWTAccessToken creates an unsigned JWT access token with the given
+// claims. Useful for testing code that looks at the token's scopes.
+func NewJWTAccessToken(claims map[string]interface{}) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload, _ := json.Marshal(claims)
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".signature"
+}
+
+// NewTokenData is a dataset which represents a valid JWT token with the given
+// claims.
+func NewTokenData(claims map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"token": oauth2.Token{Expiry: time.Time{}, AccessToken: NewJWTAccessToken(claims)},
+	}
+}
+
 // EchoResponseHandler is a normal handler for responses received from the proxy requests.
 func EchoResponseHandler(rw http.ResponseWriter, response *http.Response) {
 	for header := range response.Header {
@@ -124,10 +142,11 @@ func CreateRouterWithMockSession(sessionData map[string]interface{}, envVars map
 	// Override the session store.
 	settings.Sessions = store
 
-	templates, err := helpers.InitTemplates(settings.TemplatesPath)
+	templates, err := helpers.InitTemplates(settings.TemplatesPath, settings.EmailTemplatesPath)
 	if err != nil {
 		log.Fatalf("failed to init templates: %v", err)
 	}
+	templates.Assets = settings.Assets
 
 	// Create the router.
 	mockMailer := new(mocks.Mailer)
@@ -135,6 +154,8 @@ func CreateRouterWithMockSession(sessionData map[string]interface{}, envVars map
 	// argument.
 	mockMailer.On("SendEmail", mock.AnythingOfType("string"),
 		mock.AnythingOfType("string"), mock.AnythingOfType("[]uint8")).Return(nil)
+	mockMailer.On("SendMultipartEmail", mock.AnythingOfType("string"), mock.AnythingOfType("string"),
+		mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]uint8")).Return(nil)
 	router := controllers.InitRouter(&settings, templates, mockMailer)
 
 	return router, &store
@@ -0,0 +1,237 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRouteTimeouts are the timeouts of the route groups that differ from
+// the request timeout, by path prefix, unless configured otherwise. Static
+// assets are served from disk, so they fail fast rather than tie up
+// connections, bulk invites are paced, so they take longer, and requests for
+// changes wait up to 30 seconds for one.
+var DefaultRouteTimeouts = map[string]time.Duration{
+	"/assets/":         10 * time.Second,
+	"/api/assets":      10 * time.Second,
+	"/api/changes":     45 * time.Second,
+	"/uaa/invite/bulk": 2 * time.Minute,
+}
+
+// annotationsKey is the context key of the RequestAnnotations.
+type annotationsKey struct{}
+
+// RequestAnnotations are what a request's handler tells the TimeoutHandler
+// about the request, so a timeout can say where the time went.
+type RequestAnnotations struct {
+	mu        sync.Mutex
+	requestID string
+	nextCall  int
+	inFlight  map[int]upstreamCall
+	slowest   upstreamCall
+}
+
+// upstreamCall is a request to an upstream made while handling the request.
+type upstreamCall struct {
+	upstream string
+	start    time.Time
+	duration time.Duration
+}
+
+// WithAnnotations returns the request with new RequestAnnotations in its
+// context, and the annotations.
+func WithAnnotations(req *http.Request) (*http.Request, *RequestAnnotations) {
+	a := &RequestAnnotations{inFlight: make(map[int]upstreamCall)}
+	return req.WithContext(context.WithValue(req.Context(), annotationsKey{}, a)), a
+}
+
+// Annotations returns the RequestAnnotations of the request, or nil. The
+// methods of nil annotations do nothing.
+func Annotations(req *http.Request) *RequestAnnotations {
+	a, _ := req.Context().Value(annotationsKey{}).(*RequestAnnotations)
+	return a
+}
+
+// SetRequestID records the dashboard's ID of the request.
+func (a *RequestAnnotations) SetRequestID(id string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requestID = id
+}
+
+// RequestID returns the dashboard's ID of the request, if it's known yet.
+func (a *RequestAnnotations) RequestID() string {
+	if a == nil {
+		return ""
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.requestID
+}
+
+// StartUpstream records the start of a request to the upstream, e.g. cf_api.
+// The returned func records its end.
+func (a *RequestAnnotations) StartUpstream(upstream string) func() {
+	if a == nil {
+		return func() {}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	id := a.nextCall
+	a.nextCall++
+	a.inFlight[id] = upstreamCall{upstream: upstream, start: time.Now()}
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		call := a.inFlight[id]
+		delete(a.inFlight, id)
+		if call.duration = time.Since(call.start); call.duration > a.slowest.duration {
+			a.slowest = call
+		}
+	}
+}
+
+// SlowUpstream returns the upstream that has been waited on the longest:
+// the one whose request has been in flight the longest, or else the slowest
+// one to answer. It returns an empty string when no upstream was called.
+func (a *RequestAnnotations) SlowUpstream() (string, time.Duration) {
+	if a == nil {
+		return "", 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	slowest := a.slowest
+	for _, call := range a.inFlight {
+		if d := time.Since(call.start); d > slowest.duration {
+			slowest = upstreamCall{upstream: call.upstream, duration: d}
+		}
+	}
+	return slowest.upstream, slowest.duration
+}
+
+// TimeoutHandler is http.TimeoutHandler answering the requests that time out
+// with a JSON 503, which has the request's ID and the upstream it was waiting
+// on, instead of a blank page.
+type TimeoutHandler struct {
+	Handler http.Handler
+	// Timeout applies to the requests outside of the Routes, which are
+	// timeouts by path prefix. The longest matching prefix wins.
+	Timeout time.Duration
+	Routes  map[string]time.Duration
+}
+
+// TimeoutFor returns the timeout of requests to the path.
+func (h *TimeoutHandler) TimeoutFor(path string) time.Duration {
+	timeout, longest := h.Timeout, -1
+	for prefix, d := range h.Routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			timeout, longest = d, len(prefix)
+		}
+	}
+	return timeout
+}
+
+// timeoutResponse is the body of a request that timed out.
+type timeoutResponse struct {
+	Status      string `json:"status"`
+	Description string `json:"error_description"`
+	RequestID   string `json:"request_id,omitempty"`
+	// Upstream is the upstream the request was waiting on the longest, e.g.
+	// cf_api, and UpstreamMS for how long.
+	Upstream   string `json:"upstream,omitempty"`
+	UpstreamMS int64  `json:"upstream_ms,omitempty"`
+}
+
+func (h *TimeoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.TimeoutFor(r.URL.Path))
+	defer cancel()
+	r, annotations := WithAnnotations(r.WithContext(ctx))
+	tw := &timeoutWriter{header: make(http.Header)}
+	done := make(chan struct{})
+	panicked := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicked <- p
+			}
+		}()
+		h.Handler.ServeHTTP(tw, r)
+		close(done)
+	}()
+	select {
+	case p := <-panicked:
+		panic(p)
+	case <-done:
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		for k, v := range tw.header {
+			w.Header()[k] = v
+		}
+		if tw.code == 0 {
+			tw.code = http.StatusOK
+		}
+		w.WriteHeader(tw.code)
+		w.Write(tw.buf.Bytes())
+	case <-ctx.Done():
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		tw.timedOut = true
+		body := timeoutResponse{
+			Status:      "timeout",
+			Description: "The request took too long. Try again later.",
+			RequestID:   annotations.RequestID(),
+		}
+		var waited time.Duration
+		if body.Upstream, waited = annotations.SlowUpstream(); body.Upstream != "" {
+			body.Description = "The request took too long waiting on the " + body.Upstream + " upstream. Try again later."
+			body.UpstreamMS = int64(waited / time.Millisecond)
+		}
+		if body.RequestID != "" {
+			w.Header().Set("X-Request-Id", body.RequestID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+// timeoutWriter buffers the response of the handler, which is dropped if
+// the request times out.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
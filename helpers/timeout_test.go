@@ -0,0 +1,67 @@
+package helpers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutHandler(t *testing.T) {
+	handler := &TimeoutHandler{Timeout: 50 * time.Millisecond, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		annotations := Annotations(r)
+		annotations.SetRequestID("request-id")
+		if r.URL.Path == "/fast" {
+			w.Header().Set("X-Test", "fast")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("done"))
+			return
+		}
+		end := annotations.StartUpstream("cf_api")
+		defer end()
+		<-r.Context().Done()
+	})}
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/fast", nil))
+	if rw.Code != http.StatusCreated || rw.Header().Get("X-Test") != "fast" || rw.Body.String() != "done" {
+		t.Errorf("expected the handler's response. Found %d %q", rw.Code, rw.Body.String())
+	}
+
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/slow", nil))
+	var body timeoutResponse
+	json.NewDecoder(rw.Body).Decode(&body)
+	if rw.Code != http.StatusServiceUnavailable || rw.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected a JSON 503. Found %d %q", rw.Code, rw.Header().Get("Content-Type"))
+	}
+	if body.Status != "timeout" || body.RequestID != "request-id" || body.Upstream != "cf_api" || body.UpstreamMS < 40 {
+		t.Errorf("expected the request ID and the slow upstream. Found %+v", body)
+	}
+}
+
+func TestTimeoutHandlerRoutes(t *testing.T) {
+	handler := &TimeoutHandler{Timeout: 20 * time.Second, Routes: map[string]time.Duration{
+		"/admin/":        time.Minute,
+		"/admin/reports": 2 * time.Minute,
+		"/assets/":       5 * time.Second,
+	}}
+	for path, expected := range map[string]time.Duration{
+		"/v2/apps":             20 * time.Second,
+		"/assets/app.js":       5 * time.Second,
+		"/admin/logins":        time.Minute,
+		"/admin/reports/usage": 2 * time.Minute,
+	} {
+		if timeout := handler.TimeoutFor(path); timeout != expected {
+			t.Errorf("expected the timeout of %s to be %v. Found %v", path, expected, timeout)
+		}
+	}
+
+	// Requests for changes wait up to 30 seconds, longer than the request
+	// timeout.
+	handler = &TimeoutHandler{Timeout: TimeoutConstant, Routes: DefaultRouteTimeouts}
+	if timeout := handler.TimeoutFor("/api/changes"); timeout <= 30*time.Second {
+		t.Errorf("expected requests for changes to outlive their wait. Found a timeout of %v", timeout)
+	}
+}
@@ -0,0 +1,135 @@
+package helpers
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/govau/cf-common/env"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DefaultACMECacheDir is where the certificates obtained with ACME are kept
+// unless configured otherwise.
+const DefaultACMECacheDir = "./acme-cache"
+
+// TLSListener serves the dashboard over HTTPS itself, for deployments where
+// no router terminates TLS in front of it. It either serves the certificate
+// at CertPath and KeyPath, or obtains certificates for ACMEHosts from an ACME
+// CA such as Let's Encrypt.
+type TLSListener struct {
+	CertPath string
+	KeyPath  string
+
+	// ACMEHosts are the host names certificates are obtained for. ACME is
+	// off when empty.
+	ACMEHosts []string
+	// ACMEEmail is the contact the CA notifies of problems with the
+	// certificates.
+	ACMEEmail string
+	// ACMECacheDir keeps the certificates and account key across restarts,
+	// so they aren't requested again.
+	ACMECacheDir string
+	// ACMEDirectoryURL is the CA's directory, e.g. the Let's Encrypt
+	// staging one. Defaults to Let's Encrypt.
+	ACMEDirectoryURL string
+
+	// HTTPPort, when set, serves plain HTTP on that port, answering the ACME
+	// HTTP challenges and redirecting everything else to HTTPS.
+	HTTPPort string
+}
+
+// ACME returns true if the certificates are obtained with ACME.
+func (l *TLSListener) ACME() bool {
+	return len(l.ACMEHosts) > 0
+}
+
+// Config returns the TLS configuration of the listener and the handler of its
+// plain HTTP port. The certificate at CertPath and KeyPath is loaded once, so
+// a replaced certificate is served after a restart.
+func (l *TLSListener) Config() (*tls.Config, http.Handler, error) {
+	if !l.ACME() {
+		cert, err := tls.LoadX509KeyPair(l.CertPath, l.KeyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		config := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		return config, http.HandlerFunc(redirectToHTTPS), nil
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(l.ACMEHosts...),
+		Cache:      autocert.DirCache(l.ACMECacheDir),
+		Email:      l.ACMEEmail,
+	}
+	if l.ACMEDirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: l.ACMEDirectoryURL}
+	}
+	config := manager.TLSConfig()
+	config.MinVersion = tls.VersionTLS12
+	return config, manager.HTTPHandler(http.HandlerFunc(redirectToHTTPS)), nil
+}
+
+// redirectToHTTPS redirects plain HTTP requests to the same URL over HTTPS.
+func redirectToHTTPS(rw http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	u := *req.URL
+	u.Scheme = "https"
+	u.Host = host
+	http.Redirect(rw, req, u.String(), http.StatusMovedPermanently)
+}
+
+// parseTLSListener reads how the dashboard serves HTTPS itself. It returns
+// nil when it only serves plain HTTP.
+func parseTLSListener(envVars *env.VarSet, appURL string) (*TLSListener, error) {
+	l := &TLSListener{
+		CertPath:         envVars.String(TLSCertPathEnvVar, ""),
+		KeyPath:          envVars.String(TLSKeyPathEnvVar, ""),
+		ACMEEmail:        envVars.String(TLSACMEEmailEnvVar, ""),
+		ACMECacheDir:     envVars.String(TLSACMECacheDirEnvVar, DefaultACMECacheDir),
+		ACMEDirectoryURL: envVars.String(TLSACMEDirectoryURLEnvVar, ""),
+		HTTPPort:         envVars.String(TLSHTTPPortEnvVar, ""),
+	}
+	if envVars.MustBool(TLSACMEEnvVar) {
+		if l.CertPath != "" || l.KeyPath != "" {
+			return nil, fmt.Errorf("cannot set env vars %q or %q with %q", TLSCertPathEnvVar, TLSKeyPathEnvVar, TLSACMEEnvVar)
+		}
+		for _, host := range strings.Split(envVars.String(TLSACMEHostsEnvVar, ""), ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				l.ACMEHosts = append(l.ACMEHosts, host)
+			}
+		}
+		if len(l.ACMEHosts) == 0 {
+			u, err := url.Parse(appURL)
+			if err != nil || u.Hostname() == "" {
+				return nil, fmt.Errorf("env var %q must be set when %q has no host", TLSACMEHostsEnvVar, HostnameEnvVar)
+			}
+			l.ACMEHosts = []string{u.Hostname()}
+		}
+		return l, nil
+	}
+	if l.CertPath == "" && l.KeyPath == "" {
+		if l.HTTPPort != "" {
+			return nil, fmt.Errorf("env var %q is only used when serving HTTPS", TLSHTTPPortEnvVar)
+		}
+		return nil, nil
+	}
+	if l.CertPath == "" || l.KeyPath == "" {
+		return nil, fmt.Errorf("env vars %q and %q must be set together", TLSCertPathEnvVar, TLSKeyPathEnvVar)
+	}
+	// Fail at startup rather than at the first connection.
+	if _, err := tls.LoadX509KeyPair(l.CertPath, l.KeyPath); err != nil {
+		return nil, fmt.Errorf("unable to load the TLS certificate: %v", err)
+	}
+	return l, nil
+}
@@ -0,0 +1,129 @@
+package helpers_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfenv"
+	"github.com/govau/cf-common/env"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// writeTestCertificate writes a self-signed certificate and its key to dir.
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dashboard.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certPath, keyPath
+}
+
+func TestInitSettingsTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeTestCertificate(t, dir)
+
+	app, _ := cfenv.Current()
+	envVars := make(map[string]string)
+	for _, tt := range initSettingsTests {
+		if tt.testName != "Basic Valid Local CF Settings" {
+			continue
+		}
+		for k, v := range tt.envVars {
+			envVars[k] = v
+		}
+	}
+	s := helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	if s.TLS != nil {
+		t.Errorf("Expected plain HTTP by default. Found %+v", s.TLS)
+	}
+
+	envVars[helpers.TLSCertPathEnvVar] = certPath
+	envVars[helpers.TLSKeyPathEnvVar] = keyPath
+	s = helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	if s.TLS == nil || s.TLS.ACME() {
+		t.Fatalf("Expected the certificate to be served. Found %+v", s.TLS)
+	}
+	config, httpHandler, err := s.TLS.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Certificates) != 1 {
+		t.Errorf("Expected the certificate. Found %d", len(config.Certificates))
+	}
+	response := httptest.NewRecorder()
+	httpHandler.ServeHTTP(response, httptest.NewRequest("GET", "http://dashboard.example.com:8080/orgs?x=1", nil))
+	if response.Code != http.StatusMovedPermanently || response.Header().Get("Location") != "https://dashboard.example.com/orgs?x=1" {
+		t.Errorf("Expected a redirect to HTTPS. Found %d %q", response.Code, response.Header().Get("Location"))
+	}
+
+	// ACME defaults to the host of the app.
+	delete(envVars, helpers.TLSCertPathEnvVar)
+	delete(envVars, helpers.TLSKeyPathEnvVar)
+	envVars[helpers.HostnameEnvVar] = "https://dashboard.example.com"
+	envVars[helpers.TLSACMEEnvVar] = "true"
+	s = helpers.Settings{}
+	if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err != nil {
+		t.Fatal(err)
+	}
+	if !s.TLS.ACME() || len(s.TLS.ACMEHosts) != 1 || s.TLS.ACMEHosts[0] != "dashboard.example.com" {
+		t.Errorf("Unexpected ACME hosts %v", s.TLS.ACMEHosts)
+	}
+
+	for _, invalid := range []map[string]string{
+		{helpers.TLSACMEEnvVar: "true", helpers.TLSCertPathEnvVar: certPath, helpers.TLSKeyPathEnvVar: keyPath},
+		{helpers.TLSACMEEnvVar: "false", helpers.TLSCertPathEnvVar: certPath},
+		{helpers.TLSACMEEnvVar: "false", helpers.TLSCertPathEnvVar: keyPath, helpers.TLSKeyPathEnvVar: keyPath},
+		{helpers.TLSACMEEnvVar: "false", helpers.TLSHTTPPortEnvVar: "80"},
+	} {
+		delete(envVars, helpers.TLSCertPathEnvVar)
+		delete(envVars, helpers.TLSKeyPathEnvVar)
+		for k, v := range invalid {
+			envVars[k] = v
+		}
+		s = helpers.Settings{}
+		if err := s.InitSettings(env.NewVarSet(env.WithMapLookup(envVars)), app); err == nil {
+			t.Errorf("Expected %v to be refused", invalid)
+		}
+		delete(envVars, helpers.TLSHTTPPortEnvVar)
+	}
+}
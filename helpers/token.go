@@ -0,0 +1,146 @@
+package helpers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// SyntheticUserHeader names the synthetic user a load testing request is
+// made as, when synthetic users are enabled.
+const SyntheticUserHeader = "X-Synthetic-User"
+
+// TokenClaims are the claims of a UAA JWT access token that the dashboard
+// uses.
+type TokenClaims struct {
+	UserID   string   `json:"user_id"`
+	UserName string   `json:"user_name"`
+	Email    string   `json:"email"`
+	Scope    []string `json:"scope"`
+}
+
+// ParseTokenClaims decodes the claims of a JWT access token. The signature
+// is not verified, so this must only be used with tokens we got from UAA
+// ourselves (e.g. from the session).
+func ParseTokenClaims(accessToken string) (*TokenClaims, error) {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("access token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, err
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// HasScope returns true if the claims contain the given scope.
+func (c *TokenClaims) HasScope(scope string) bool {
+	for _, s := range c.Scope {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// SyntheticToken makes an unsigned access token for the synthetic user. Its
+// claims are parsed like a UAA token's, but only a mock backend accepts it.
+func SyntheticToken(name string) oauth2.Token {
+	return UnsignedToken(TokenClaims{
+		UserID:   "synthetic-" + name,
+		UserName: name,
+		Email:    name + "@synthetic.invalid",
+		Scope:    []string{"cloud_controller.read", "cloud_controller.write", "scim.read", "openid"},
+	}, time.Hour)
+}
+
+// UnsignedToken makes an unsigned access token with the claims that expires
+// after the TTL. Only a mock backend accepts it.
+func UnsignedToken(claims TokenClaims, ttl time.Duration) oauth2.Token {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	payload, _ := json.Marshal(claims)
+	return oauth2.Token{
+		AccessToken: header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".",
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(ttl),
+	}
+}
+
+// ExchangeCode exchanges the authorization code for a token like
+// config.Exchange, with the extra parameters, e.g. the PKCE code verifier,
+// in the body of the token request. The oauth2 package can't send any, and
+// adding them to the token URL would log them with it.
+func ExchangeCode(ctx context.Context, config *oauth2.Config, code string, params url.Values) (*oauth2.Token, error) {
+	form := url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {code},
+		"client_id":  {config.ClientID},
+	}
+	if config.RedirectURL != "" {
+		form.Set("redirect_uri", config.RedirectURL)
+	}
+	for name, values := range params {
+		form[name] = values
+	}
+	req, err := http.NewRequest("POST", config.Endpoint.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(config.ClientID, config.ClientSecret)
+
+	client := http.DefaultClient
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		client = c
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oauth2: cannot fetch token: %s\nResponse: %s", res.Status, body)
+	}
+	var tj struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tj); err != nil {
+		return nil, fmt.Errorf("oauth2: cannot parse token: %v", err)
+	}
+	if tj.AccessToken == "" {
+		return nil, errors.New("oauth2: server response missing access_token")
+	}
+	token := &oauth2.Token{
+		AccessToken:  tj.AccessToken,
+		TokenType:    tj.TokenType,
+		RefreshToken: tj.RefreshToken,
+	}
+	if tj.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(tj.ExpiresIn) * time.Second)
+	}
+	var extra map[string]interface{}
+	json.Unmarshal(body, &extra)
+	return token.WithExtra(extra), nil
+}
@@ -0,0 +1,94 @@
+package helpers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/testhelpers"
+)
+
+func TestParseTokenClaims(t *testing.T) {
+	token := testhelpers.NewJWTAccessToken(map[string]interface{}{
+		"user_id": "user-guid",
+		"email":   "user@example.com",
+		"scope":   []string{"openid", "doppler.firehose"},
+	})
+	claims, err := helpers.ParseTokenClaims(token)
+	if err != nil {
+		t.Fatalf("Expected nil error, found %s", err.Error())
+	}
+	if claims.UserID != "user-guid" || claims.Email != "user@example.com" {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+	if !claims.HasScope("doppler.firehose") {
+		t.Error("Expected doppler.firehose scope")
+	}
+	if claims.HasScope("cloud_controller.admin") {
+		t.Error("Unexpected cloud_controller.admin scope")
+	}
+
+	if _, err := helpers.ParseTokenClaims("opaque-token"); err == nil {
+		t.Error("Expected non nil error for opaque token")
+	}
+}
+
+func TestSyntheticToken(t *testing.T) {
+	token := helpers.SyntheticToken("user-1")
+	claims, err := helpers.ParseTokenClaims(token.AccessToken)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if claims.UserID != "synthetic-user-1" || claims.UserName != "user-1" || !claims.HasScope("cloud_controller.read") {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+	if !token.Valid() {
+		t.Error("Expected the token to be valid")
+	}
+}
+
+func TestExchangeCode(t *testing.T) {
+	var form url.Values
+	var query, user string
+	uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		query = r.URL.RawQuery
+		user, _, _ = r.BasicAuth()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "access", "refresh_token": "refresh", "token_type": "bearer", "expires_in": 3600, "jti": "id"}`))
+	}))
+	defer uaa.Close()
+
+	config := &oauth2.Config{
+		ClientID:     "dashboard",
+		ClientSecret: "secret",
+		RedirectURL:  "https://dashboard.example.com/oauth2callback",
+		Endpoint:     oauth2.Endpoint{TokenURL: uaa.URL + "/oauth/token?tenant=a"},
+	}
+	token, err := helpers.ExchangeCode(context.Background(), config, "code",
+		url.Values{"code_verifier": {"verifier"}, "token_format": {"opaque"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access" || token.RefreshToken != "refresh" || token.Expiry.IsZero() || token.Extra("jti") != "id" {
+		t.Errorf("Unexpected token %+v", token)
+	}
+	// The parameters are in the body, and the token URL is kept as it is.
+	for name, expected := range map[string]string{
+		"grant_type": "authorization_code", "code": "code", "client_id": "dashboard",
+		"redirect_uri": config.RedirectURL, "code_verifier": "verifier", "token_format": "opaque",
+	} {
+		if form.Get(name) != expected {
+			t.Errorf("Expected %s %q in the token request. Found %q", name, expected, form.Get(name))
+		}
+	}
+	if query != "tenant=a" || user != "dashboard" {
+		t.Errorf("Unexpected token request to ?%s as %q", query, user)
+	}
+}
@@ -0,0 +1,238 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTraceCapacity is how many traced requests are kept, for all the
+	// traced users together.
+	DefaultTraceCapacity = 1000
+	// DefaultTraceDuration is how long a user is traced unless asked
+	// otherwise.
+	DefaultTraceDuration = 15 * time.Minute
+	// MaxTraceDuration is the longest a user can be traced at once, so a
+	// forgotten trace doesn't keep recording.
+	MaxTraceDuration = 2 * time.Hour
+	// MaxTracedBodyBytes is how much of a request or response body is kept.
+	MaxTracedBodyBytes = 4096
+	// redacted replaces the sensitive values in the traces.
+	redacted = "[redacted]"
+)
+
+// sensitiveHeaders are never kept in the traces.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Csrf-Token":        true,
+	"X-Tic-Secret":        true,
+}
+
+// sensitiveNamePattern matches the names of the query parameters and JSON
+// fields whose values are never kept in the traces.
+var sensitiveNamePattern = regexp.MustCompile(`(?i)password|secret|token|credential|private_key|passcode|^code$|^state$`)
+
+// TraceEntry is a sanitized request of a traced user and its response.
+type TraceEntry struct {
+	Time            time.Time         `json:"time"`
+	UserID          string            `json:"user_id"`
+	RequestID       string            `json:"request_id"`
+	Method          string            `json:"method"`
+	URL             string            `json:"url"`
+	RequestHeaders  map[string]string `json:"request_headers"`
+	RequestBody     string            `json:"request_body,omitempty"`
+	Status          int               `json:"status"`
+	ResponseHeaders map[string]string `json:"response_headers"`
+	ResponseBody    string            `json:"response_body,omitempty"`
+	DurationMS      float64           `json:"duration_ms"`
+}
+
+// TraceTarget is a user being traced.
+type TraceTarget struct {
+	UserID  string    `json:"user_id"`
+	Until   time.Time `json:"until"`
+	Entries int       `json:"entries"`
+}
+
+// Tracer records the requests and responses of some users for a while, to
+// debug one user's problem without turning on debug logs for everyone. The
+// entries are sanitized and kept in a ring buffer, in memory only.
+type Tracer struct {
+	mu      sync.Mutex
+	targets map[string]time.Time
+	entries []TraceEntry
+	next    int
+	full    bool
+}
+
+// NewTracer creates a Tracer keeping the last capacity entries.
+func NewTracer(capacity int) *Tracer {
+	return &Tracer{targets: map[string]time.Time{}, entries: make([]TraceEntry, capacity)}
+}
+
+// Enable traces the user until the given time.
+func (t *Tracer) Enable(userID string, until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.targets[userID] = until
+}
+
+// Disable stops tracing the user. The user's entries are kept until they're
+// overwritten.
+func (t *Tracer) Disable(userID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.targets[userID]
+	delete(t.targets, userID)
+	return ok
+}
+
+// Any returns true if some user is traced, so requests can skip looking up
+// their user when no one is.
+func (t *Tracer) Any(now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for userID, until := range t.targets {
+		if now.Before(until) {
+			return true
+		}
+		delete(t.targets, userID)
+	}
+	return false
+}
+
+// Traced returns true if the user is traced.
+func (t *Tracer) Traced(userID string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until, ok := t.targets[userID]
+	return ok && now.Before(until)
+}
+
+// Record keeps the entry, overwriting the oldest one when the buffer is full.
+func (t *Tracer) Record(entry TraceEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.entries) == 0 {
+		return
+	}
+	t.entries[t.next] = entry
+	t.next = (t.next + 1) % len(t.entries)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// Entries returns the kept entries of the user, oldest first.
+func (t *Tracer) Entries(userID string) []TraceEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries := []TraceEntry{}
+	t.each(func(e TraceEntry) {
+		if e.UserID == userID {
+			entries = append(entries, e)
+		}
+	})
+	return entries
+}
+
+// Targets returns the users being traced.
+func (t *Tracer) Targets(now time.Time) []TraceTarget {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := map[string]int{}
+	t.each(func(e TraceEntry) {
+		counts[e.UserID]++
+	})
+	targets := []TraceTarget{}
+	for userID, until := range t.targets {
+		if now.Before(until) {
+			targets = append(targets, TraceTarget{UserID: userID, Until: until, Entries: counts[userID]})
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].UserID < targets[j].UserID })
+	return targets
+}
+
+// each calls fn with the entries, oldest first.
+func (t *Tracer) each(fn func(TraceEntry)) {
+	start, n := 0, t.next
+	if t.full {
+		start, n = t.next, len(t.entries)
+	}
+	for i := 0; i < n; i++ {
+		fn(t.entries[(start+i)%len(t.entries)])
+	}
+}
+
+// SanitizeTraceURL redacts the sensitive query parameters of the URL.
+func SanitizeTraceURL(u *url.URL) string {
+	query := u.Query()
+	for name := range query {
+		if sensitiveNamePattern.MatchString(name) {
+			query[name] = []string{redacted}
+		}
+	}
+	sanitized := *u
+	sanitized.RawQuery = query.Encode()
+	return sanitized.RequestURI()
+}
+
+// SanitizeTraceHeaders flattens the headers, redacting the sensitive ones.
+func SanitizeTraceHeaders(header http.Header) map[string]string {
+	sanitized := map[string]string{}
+	for name, values := range header {
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			sanitized[name] = redacted
+			continue
+		}
+		sanitized[name] = strings.Join(values, ", ")
+	}
+	return sanitized
+}
+
+// SanitizeTraceBody returns the body to keep in a trace. JSON bodies are kept
+// with the sensitive fields redacted, up to a limit. Other bodies are only
+// described, since they can't be sanitized.
+func SanitizeTraceBody(contentType string, body []byte, truncated bool) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if truncated {
+		return fmt.Sprintf("[over %d bytes of %s]", MaxTracedBodyBytes, contentType)
+	}
+	var v interface{}
+	if !strings.Contains(contentType, "json") || json.Unmarshal(body, &v) != nil {
+		return fmt.Sprintf("[%d bytes of %s]", len(body), contentType)
+	}
+	sanitized, _ := json.Marshal(redactJSON(v))
+	return string(sanitized)
+}
+
+func redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if sensitiveNamePattern.MatchString(k) {
+				v[k] = redacted
+			} else {
+				v[k] = redactJSON(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactJSON(item)
+		}
+	}
+	return v
+}
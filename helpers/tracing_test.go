@@ -0,0 +1,67 @@
+package helpers_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestTracer(t *testing.T) {
+	now := time.Now()
+	tracer := helpers.NewTracer(3)
+	if tracer.Any(now) {
+		t.Error("expected no one to be traced")
+	}
+	tracer.Enable("user-1", now.Add(time.Minute))
+	tracer.Enable("user-2", now.Add(-time.Minute))
+	if !tracer.Any(now) || !tracer.Traced("user-1", now) || tracer.Traced("user-2", now) {
+		t.Error("expected only user-1 to be traced")
+	}
+
+	for _, path := range []string{"/1", "/2", "/3", "/4"} {
+		tracer.Record(helpers.TraceEntry{UserID: "user-1", URL: path})
+	}
+	tracer.Record(helpers.TraceEntry{UserID: "user-3", URL: "/other"})
+	entries := tracer.Entries("user-1")
+	if len(entries) != 2 || entries[0].URL != "/3" || entries[1].URL != "/4" {
+		t.Errorf("expected the last entries of user-1, oldest first, got %+v", entries)
+	}
+	if targets := tracer.Targets(now); len(targets) != 1 || targets[0].UserID != "user-1" || targets[0].Entries != 2 {
+		t.Errorf("unexpected targets %+v", targets)
+	}
+
+	if !tracer.Disable("user-1") || tracer.Disable("user-1") || tracer.Any(now) {
+		t.Error("expected user-1 to be traced once")
+	}
+}
+
+func TestSanitizeTrace(t *testing.T) {
+	u, _ := url.Parse("/oauth2callback?code=secret-code&state=abc&page=2")
+	if sanitized := helpers.SanitizeTraceURL(u); strings.Contains(sanitized, "secret-code") ||
+		strings.Contains(sanitized, "abc") || !strings.Contains(sanitized, "page=2") {
+		t.Errorf("unexpected URL %s", sanitized)
+	}
+
+	headers := helpers.SanitizeTraceHeaders(http.Header{
+		"Cookie": {"session=abc"}, "Accept": {"application/json"},
+	})
+	if headers["Cookie"] != "[redacted]" || headers["Accept"] != "application/json" {
+		t.Errorf("unexpected headers %v", headers)
+	}
+
+	body := helpers.SanitizeTraceBody("application/json",
+		[]byte(`{"name": "db", "credentials": {"uri": "postgres://u:p@h"}, "items": [{"client_secret": "s"}]}`), false)
+	if strings.Contains(body, "postgres://") || strings.Contains(body, `"s"`) || !strings.Contains(body, `"name":"db"`) {
+		t.Errorf("unexpected body %s", body)
+	}
+	if body := helpers.SanitizeTraceBody("text/plain", []byte("password=hunter2"), false); body != "[16 bytes of text/plain]" {
+		t.Errorf("unexpected body %s", body)
+	}
+	if body := helpers.SanitizeTraceBody("application/json", []byte(`{"a":`), true); !strings.HasPrefix(body, "[over ") {
+		t.Errorf("unexpected body %s", body)
+	}
+}
@@ -0,0 +1,140 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"golang.org/x/oauth2"
+)
+
+// UAAClientRegistration is the subset of a UAA client registration that the
+// dashboard depends on.
+// https://docs.cloudfoundry.org/api/uaa/#retrieve28
+type UAAClientRegistration struct {
+	ClientID             string   `json:"client_id"`
+	Scope                []string `json:"scope"`
+	RedirectURI          []string `json:"redirect_uri"`
+	AuthorizedGrantTypes []string `json:"authorized_grant_types"`
+	// Validities are in seconds. Zero means the UAA default.
+	AccessTokenValidity  int `json:"access_token_validity"`
+	RefreshTokenValidity int `json:"refresh_token_validity"`
+}
+
+// FetchUAAClientRegistration gets the registration of the dashboard's own
+// client from UAA. The client needs the clients.read authority.
+func (s *Settings) FetchUAAClientRegistration() (*UAAClientRegistration, error) {
+	config := *s.HighPrivilegedOauthConfig
+	config.Scopes = []string{"clients.read"}
+	client := config.Client(s.CreateContext())
+	client.Timeout = 10 * time.Second
+	res, err := client.Get(fmt.Sprintf("%s/oauth/clients/%s",
+		s.UaaURL, url.PathEscape(s.OAuthConfig.ClientID)))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d fetching the UAA client registration", res.StatusCode)
+	}
+	var registration UAAClientRegistration
+	if err := json.NewDecoder(res.Body).Decode(&registration); err != nil {
+		return nil, err
+	}
+	return &registration, nil
+}
+
+// UAAClientDrift compares the OAuth configuration and session lifetime with
+// the client registered in UAA and describes every mismatch. An empty result
+// means the configuration matches.
+func UAAClientDrift(config *oauth2.Config, sessionMaxAge int, registration *UAAClientRegistration) []string {
+	var drift []string
+
+	redirectRegistered := false
+	for _, pattern := range registration.RedirectURI {
+		if redirectURIMatches(pattern, config.RedirectURL) {
+			redirectRegistered = true
+			break
+		}
+	}
+	if !redirectRegistered {
+		drift = append(drift, fmt.Sprintf("redirect URI %s is not registered (registered: %s)",
+			config.RedirectURL, strings.Join(registration.RedirectURI, ", ")))
+	}
+
+	registeredScopes := make(map[string]bool)
+	for _, scope := range registration.Scope {
+		registeredScopes[scope] = true
+	}
+	for _, scope := range config.Scopes {
+		if !registeredScopes[scope] {
+			drift = append(drift, fmt.Sprintf("scope %s is requested but not registered", scope))
+		}
+	}
+
+	grants := make(map[string]bool)
+	for _, grant := range registration.AuthorizedGrantTypes {
+		grants[grant] = true
+	}
+	for _, grant := range []string{"authorization_code", "refresh_token", "client_credentials"} {
+		if !grants[grant] {
+			drift = append(drift, fmt.Sprintf("grant type %s is not authorized", grant))
+		}
+	}
+
+	if registration.RefreshTokenValidity > 0 {
+		if registration.AccessTokenValidity > 0 && registration.RefreshTokenValidity <= registration.AccessTokenValidity {
+			drift = append(drift, fmt.Sprintf("refresh token validity (%ds) is not longer than access token validity (%ds)",
+				registration.RefreshTokenValidity, registration.AccessTokenValidity))
+		}
+		if sessionMaxAge > registration.RefreshTokenValidity {
+			drift = append(drift, fmt.Sprintf("sessions last %ds but refresh tokens expire after %ds; users will be logged out early",
+				sessionMaxAge, registration.RefreshTokenValidity))
+		}
+	}
+	return drift
+}
+
+// redirectURIMatches implements the subset of UAA's Ant-style redirect URI
+// patterns used in practice: exact matches, "*" within a path segment and a
+// trailing "**".
+func redirectURIMatches(pattern, uri string) bool {
+	if pattern == uri {
+		return true
+	}
+	if strings.HasSuffix(pattern, "**") {
+		return strings.HasPrefix(uri, strings.TrimSuffix(pattern, "**"))
+	}
+	if strings.Contains(pattern, "*") {
+		matched, err := path.Match(pattern, uri)
+		return err == nil && matched
+	}
+	return false
+}
+
+// sessionMaxAge returns the lifetime in seconds of the session cookies, or
+// zero if unknown.
+func (s *Settings) sessionMaxAge() int {
+	switch store := s.Sessions.(type) {
+	case *sessions.CookieStore:
+		return store.Options.MaxAge
+	case *SizeMonitoredStore:
+		return store.Cookies.Options.MaxAge
+	}
+	return 0
+}
+
+// CheckUAAClientDrift fetches the dashboard's UAA client registration and
+// returns the mismatches with the configuration.
+func (s *Settings) CheckUAAClientDrift() ([]string, error) {
+	registration, err := s.FetchUAAClientRegistration()
+	if err != nil {
+		return nil, err
+	}
+	return UAAClientDrift(s.OAuthConfig, s.sessionMaxAge(), registration), nil
+}
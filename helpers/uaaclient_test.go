@@ -0,0 +1,128 @@
+package helpers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestUAAClientDrift(t *testing.T) {
+	config := &oauth2.Config{
+		RedirectURL: "https://dashboard.example.com/oauth2callback",
+		Scopes:      []string{"openid", "cloud_controller.read"},
+	}
+	matching := helpers.UAAClientRegistration{
+		Scope:                []string{"openid", "cloud_controller.read", "cloud_controller.write"},
+		RedirectURI:          []string{"https://dashboard.example.com/**"},
+		AuthorizedGrantTypes: []string{"authorization_code", "refresh_token", "client_credentials"},
+		AccessTokenValidity:  600,
+		RefreshTokenValidity: 86400,
+	}
+	tests := []struct {
+		testName      string
+		sessionMaxAge int
+		modify        func(r *helpers.UAAClientRegistration)
+		expected      []string
+	}{
+		{
+			testName:      "Matching Registration",
+			sessionMaxAge: 3600,
+			modify:        func(r *helpers.UAAClientRegistration) {},
+		},
+		{
+			testName:      "Exact Redirect URI",
+			sessionMaxAge: 3600,
+			modify: func(r *helpers.UAAClientRegistration) {
+				r.RedirectURI = []string{"https://dashboard.example.com/oauth2callback"}
+			},
+		},
+		{
+			testName:      "Wrong Redirect URI",
+			sessionMaxAge: 3600,
+			modify: func(r *helpers.UAAClientRegistration) {
+				r.RedirectURI = []string{"https://old.example.com/*"}
+			},
+			expected: []string{"redirect URI https://dashboard.example.com/oauth2callback is not registered"},
+		},
+		{
+			testName:      "Missing Scope And Grant",
+			sessionMaxAge: 3600,
+			modify: func(r *helpers.UAAClientRegistration) {
+				r.Scope = []string{"openid"}
+				r.AuthorizedGrantTypes = []string{"authorization_code", "client_credentials"}
+			},
+			expected: []string{
+				"scope cloud_controller.read is requested but not registered",
+				"grant type refresh_token is not authorized",
+			},
+		},
+		{
+			testName:      "Token Validity",
+			sessionMaxAge: 86400 * 30,
+			modify: func(r *helpers.UAAClientRegistration) {
+				r.RefreshTokenValidity = 600
+			},
+			expected: []string{
+				"refresh token validity (600s) is not longer than access token validity (600s)",
+				"sessions last 2592000s but refresh tokens expire after 600s",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			registration := matching
+			tt.modify(&registration)
+			drift := helpers.UAAClientDrift(config, tt.sessionMaxAge, &registration)
+			if len(drift) != len(tt.expected) {
+				t.Fatalf("Expected %d mismatches, found %v", len(tt.expected), drift)
+			}
+			for i := range drift {
+				if !strings.HasPrefix(drift[i], tt.expected[i]) {
+					t.Errorf("Expected %q, found %q", tt.expected[i], drift[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFetchUAAClientRegistration(t *testing.T) {
+	uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/token":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token": "admin-token", "token_type": "bearer", "expires_in": 3600}`))
+		case "/oauth/clients/dashboard":
+			if r.Header.Get("Authorization") != "Bearer admin-token" {
+				t.Errorf("Expected the client credentials token")
+			}
+			w.Write([]byte(`{"client_id": "dashboard", "scope": ["openid"], "redirect_uri": ["https://dashboard.example.com/**"], "refresh_token_validity": 86400}`))
+		default:
+			t.Errorf("Unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer uaa.Close()
+	s := helpers.Settings{
+		UaaURL:      uaa.URL,
+		OAuthConfig: &oauth2.Config{ClientID: "dashboard"},
+		HighPrivilegedOauthConfig: &clientcredentials.Config{
+			ClientID:     "dashboard",
+			ClientSecret: "secret",
+			TokenURL:     uaa.URL + "/oauth/token",
+		},
+	}
+	registration, err := s.FetchUAAClientRegistration()
+	if err != nil {
+		t.Fatalf("Expected nil error, found %s", err.Error())
+	}
+	if registration.ClientID != "dashboard" || registration.RefreshTokenValidity != 86400 ||
+		len(registration.RedirectURI) != 1 {
+		t.Errorf("Unexpected registration %+v", registration)
+	}
+}
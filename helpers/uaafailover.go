@@ -0,0 +1,267 @@
+package helpers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+const (
+	// DefaultFailoverCheckInterval is how often the UAA and login endpoints
+	// are checked, to switch between the primary and secondary ones.
+	DefaultFailoverCheckInterval = 30 * time.Second
+
+	// failoverThreshold is how many connection failures in a row switch to
+	// the other endpoint.
+	failoverThreshold = 3
+)
+
+var failoverLog = logging.New("failover")
+
+var (
+	failoverSwitches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_upstream_failovers_total",
+		Help: "Switches between the primary and secondary endpoints of an upstream, by upstream and the endpoint switched to.",
+	}, []string{"upstream", "endpoint"})
+	failoverSecondary = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dashboard_upstream_on_secondary",
+		Help: "1 while an upstream is reached at its secondary endpoint.",
+	}, []string{"upstream"})
+)
+
+func init() {
+	prometheus.MustRegister(failoverSwitches, failoverSecondary)
+}
+
+// EndpointFailover switches an upstream, e.g. UAA, between its primary and
+// secondary base URLs. It switches to the secondary after failoverThreshold
+// connection failures in a row, or when a check finds the primary down, and
+// back once a check finds the primary healthy again.
+type EndpointFailover struct {
+	// Name of the upstream in the metrics and logs, e.g. uaa.
+	Name      string
+	Primary   string
+	Secondary string
+	// HealthPath is checked on both endpoints, e.g. /healthz.
+	HealthPath string
+
+	mu          sync.Mutex
+	onSecondary bool
+	failures    int
+}
+
+// NewEndpointFailover creates the failover of the upstream, starting on the
+// primary.
+func NewEndpointFailover(name, primary, secondary, healthPath string) *EndpointFailover {
+	failoverSecondary.WithLabelValues(name).Set(0)
+	return &EndpointFailover{
+		Name:       name,
+		Primary:    strings.TrimSuffix(primary, "/"),
+		Secondary:  strings.TrimSuffix(secondary, "/"),
+		HealthPath: healthPath,
+	}
+}
+
+// Current returns the base URL the upstream is reached at.
+func (f *EndpointFailover) Current() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.current()
+}
+
+// path returns the rest of the URL after the base URL of either endpoint,
+// e.g. /oauth/token for a token URL configured with the primary.
+func (f *EndpointFailover) path(u string) (string, bool) {
+	for _, base := range []string{f.Primary, f.Secondary} {
+		if u == base || strings.HasPrefix(u, base+"/") || strings.HasPrefix(u, base+"?") {
+			return strings.TrimPrefix(u, base), true
+		}
+	}
+	return "", false
+}
+
+// Failed records a connection failure to the endpoint at base, switching
+// to the other one after failoverThreshold failures in a row.
+func (f *EndpointFailover) Failed(base string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if base != f.current() {
+		return
+	}
+	if f.failures++; f.failures >= failoverThreshold {
+		f.switchTo(!f.onSecondary)
+	}
+}
+
+// Succeeded records that the endpoint at base was reached.
+func (f *EndpointFailover) Succeeded(base string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if base == f.current() {
+		f.failures = 0
+	}
+}
+
+// Check checks the health of both endpoints, preferring the primary.
+func (f *EndpointFailover) Check(ctx context.Context, client *http.Client) {
+	primary := httpHealthCheck(client, f.Primary+f.HealthPath)(ctx)
+	var secondary error
+	if primary != nil {
+		secondary = httpHealthCheck(client, f.Secondary+f.HealthPath)(ctx)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case primary == nil && f.onSecondary:
+		f.switchTo(false)
+	case primary != nil && secondary == nil && !f.onSecondary:
+		failoverLog.Warnf("the primary %s endpoint is down: %v", f.Name, primary)
+		f.switchTo(true)
+	}
+}
+
+func (f *EndpointFailover) current() string {
+	if f.onSecondary {
+		return f.Secondary
+	}
+	return f.Primary
+}
+
+// switchTo switches to the secondary or back to the primary. f.mu must be
+// held.
+func (f *EndpointFailover) switchTo(secondary bool) {
+	f.onSecondary = secondary
+	f.failures = 0
+	endpoint := "primary"
+	if secondary {
+		endpoint = "secondary"
+		failoverSecondary.WithLabelValues(f.Name).Set(1)
+	} else {
+		failoverSecondary.WithLabelValues(f.Name).Set(0)
+	}
+	failoverSwitches.WithLabelValues(f.Name, endpoint).Inc()
+	failoverLog.Warnf("switched %s to the %s endpoint %s", f.Name, endpoint, f.current())
+}
+
+// UAAFailover switches UAA and the login server between their primary and
+// secondary endpoints, e.g. while UAA is being migrated between hostnames.
+type UAAFailover struct {
+	UAA   *EndpointFailover
+	Login *EndpointFailover
+	// Interval is how often the endpoints are checked.
+	Interval time.Duration
+	// Client checks the endpoints. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// failovers returns the configured failovers.
+func (u *UAAFailover) failovers() []*EndpointFailover {
+	var failovers []*EndpointFailover
+	for _, f := range []*EndpointFailover{u.UAA, u.Login} {
+		if f != nil {
+			failovers = append(failovers, f)
+		}
+	}
+	return failovers
+}
+
+// LoginURL returns the URL of the login server, e.g. its authorize URL, on
+// its current endpoint, for the pages users are sent to. A nil UAAFailover
+// returns the URL as is.
+func (u *UAAFailover) LoginURL(loginURL string) string {
+	if u == nil || u.Login == nil {
+		return loginURL
+	}
+	if path, ok := u.Login.path(loginURL); ok {
+		return u.Login.Current() + path
+	}
+	return loginURL
+}
+
+// Transport sends requests to the primary UAA and login endpoints to the
+// current ones instead, and retries them once on the other endpoint when
+// they can't connect.
+func (u *UAAFailover) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &failoverTransport{base: base, failovers: u.failovers()}
+}
+
+// Start checks the endpoints every Interval, in the background.
+func (u *UAAFailover) Start() {
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	go func() {
+		for tick := time.Tick(u.Interval); ; <-tick {
+			for _, f := range u.failovers() {
+				ctx, cancel := context.WithTimeout(context.Background(), DefaultHealthCheckTimeout)
+				f.Check(ctx, client)
+				cancel()
+			}
+		}
+	}()
+}
+
+// failoverTransport is the RoundTripper of UAAFailover.Transport.
+type failoverTransport struct {
+	base      http.RoundTripper
+	failovers []*EndpointFailover
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, f := range t.failovers {
+		path, ok := f.path(req.URL.String())
+		if !ok {
+			continue
+		}
+		current := f.Current()
+		res, err := t.send(req, current+path)
+		if err == nil {
+			f.Succeeded(current)
+			return res, nil
+		}
+		f.Failed(current)
+		// Requests whose body was sent can't be retried.
+		if req.Body != nil && req.GetBody == nil {
+			return nil, err
+		}
+		other := f.Secondary
+		if current == f.Secondary {
+			other = f.Primary
+		}
+		if res, retryErr := t.send(req, other+path); retryErr == nil {
+			f.Succeeded(other)
+			return res, nil
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// send sends a copy of the request to the URL.
+func (t *failoverTransport) send(req *http.Request, target string) (*http.Response, error) {
+	r, err := http.NewRequest(req.Method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	r = r.WithContext(req.Context())
+	r.Header = req.Header
+	if req.GetBody != nil {
+		if r.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+		r.ContentLength = req.ContentLength
+	} else {
+		r.Body = req.Body
+	}
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,71 @@
+package helpers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+func TestUAAFailover(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("primary"))
+	}))
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secondary " + r.URL.Path))
+	}))
+	defer secondary.Close()
+	primaryURL := primary.URL
+	// The primary can't be connected to.
+	primary.Close()
+
+	failover := &helpers.UAAFailover{
+		UAA:   helpers.NewEndpointFailover("uaa", primaryURL, secondary.URL, "/healthz"),
+		Login: helpers.NewEndpointFailover("login", "https://login.example.com", "https://login2.example.com", "/healthz"),
+	}
+	client := &http.Client{Transport: failover.Transport(nil)}
+
+	for i := 0; i < 3; i++ {
+		res, err := client.Post(primaryURL+"/oauth/token", "application/x-www-form-urlencoded",
+			strings.NewReader("grant_type=refresh_token"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, 64)
+		n, _ := res.Body.Read(body)
+		res.Body.Close()
+		if string(body[:n]) != "secondary /oauth/token" {
+			t.Errorf("Expected the request to be retried on the secondary. Found %q", body[:n])
+		}
+	}
+	if current := failover.UAA.Current(); current != secondary.URL {
+		t.Errorf("Expected to switch to the secondary after repeated failures. Found %s", current)
+	}
+
+	// Only the login server's URLs are rewritten, and only once it switched.
+	if got := failover.LoginURL("https://login.example.com/oauth/authorize"); got != "https://login.example.com/oauth/authorize" {
+		t.Errorf("Expected the primary login server. Found %s", got)
+	}
+	var none *helpers.UAAFailover
+	if got := none.LoginURL("https://login.example.com"); got != "https://login.example.com" {
+		t.Errorf("Expected the URL as is without failover. Found %s", got)
+	}
+
+	// The primary coming back is noticed by the checks.
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	back := helpers.NewEndpointFailover("uaa", healthy.URL, secondary.URL, "/healthz")
+	for i := 0; i < 3; i++ {
+		back.Failed(healthy.URL)
+	}
+	if back.Current() != secondary.URL {
+		t.Fatalf("Expected to be on the secondary. Found %s", back.Current())
+	}
+	back.Check(context.Background(), http.DefaultClient)
+	if back.Current() != healthy.URL {
+		t.Errorf("Expected to switch back to the primary. Found %s", back.Current())
+	}
+}
@@ -0,0 +1,280 @@
+package helpers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers/logging"
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+var webhookLog = logging.New("webhooks")
+
+// The events webhooks can subscribe to.
+const (
+	// WebhookOrgUserAdded is sent when a user is added to the org through
+	// the dashboard.
+	WebhookOrgUserAdded = "org.user_added"
+	// WebhookAppCrashedRepeatedly is sent when an app of the org crashes
+	// several times in a short while.
+	WebhookAppCrashedRepeatedly = "app.crashed_repeatedly"
+	// WebhookQuotaThreshold is sent when the org's usage crosses a threshold
+	// of its quota.
+	WebhookQuotaThreshold = "org.quota_threshold"
+)
+
+// WebhookEvents are the known events.
+var WebhookEvents = map[string]bool{
+	WebhookOrgUserAdded:         true,
+	WebhookAppCrashedRepeatedly: true,
+	WebhookQuotaThreshold:       true,
+}
+
+// The headers of the deliveries.
+const (
+	// WebhookSignatureHeader is "sha256=" and the hex HMAC-SHA256 of the
+	// timestamp, a dot and the body, keyed with the subscription's secret.
+	WebhookSignatureHeader = "X-Dashboard-Signature"
+	// WebhookTimestampHeader is the Unix time the delivery was signed at, so
+	// receivers can refuse replayed deliveries.
+	WebhookTimestampHeader = "X-Dashboard-Timestamp"
+	WebhookEventHeader     = "X-Dashboard-Event"
+	WebhookDeliveryHeader  = "X-Dashboard-Delivery"
+)
+
+const (
+	// DefaultWebhookAttempts is how many times a delivery is attempted.
+	DefaultWebhookAttempts = 5
+	// DefaultWebhookBackoff is the wait before the first retry. It doubles
+	// with each retry.
+	DefaultWebhookBackoff = 30 * time.Second
+	// webhookDeliveryRetention is how long the delivery log is kept.
+	webhookDeliveryRetention = 30 * 24 * time.Hour
+)
+
+// WebhookEvent is the body posted to the webhooks.
+type WebhookEvent struct {
+	ID      string      `json:"id"`
+	Type    string      `json:"type"`
+	OrgGUID string      `json:"org_guid"`
+	Time    time.Time   `json:"time"`
+	Data    interface{} `json:"data"`
+}
+
+// Webhooks delivers the events of orgs to the webhooks subscribed to them.
+// Each event is delivered by a background job, retrying with a backoff, and
+// every delivery is logged in the store.
+type Webhooks struct {
+	Store  db.WebhookStore
+	Jobs   *jobs.Runner
+	Client *http.Client
+	// MaxAttempts is how many times a delivery is attempted.
+	MaxAttempts int
+	// Backoff is the wait before the first retry.
+	Backoff time.Duration
+}
+
+// NewWebhooks creates Webhooks posting with a client that refuses to
+// connect to private addresses, since anyone managing an org picks the URLs.
+func NewWebhooks(store db.WebhookStore, runner *jobs.Runner) *Webhooks {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: refusePrivateAddresses}
+	return &Webhooks{
+		Store: store,
+		Jobs:  runner,
+		Client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+			// Redirects could lead to private addresses through a public one.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		MaxAttempts: DefaultWebhookAttempts,
+		Backoff:     DefaultWebhookBackoff,
+	}
+}
+
+// privateNetworks are the networks webhooks can't be delivered to.
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// refusePrivateAddresses refuses connections to the loopback, link-local
+// and private addresses the webhook URLs resolve to.
+func refusePrivateAddresses(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsGlobalUnicast() {
+		return fmt.Errorf("webhooks can't be delivered to %s", host)
+	}
+	for _, private := range privateNetworks {
+		if private.Contains(ip) {
+			return fmt.Errorf("webhooks can't be delivered to the private address %s", host)
+		}
+	}
+	return nil
+}
+
+// SignWebhook returns the signature of a delivery.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Publish queues the delivery of the event to the org's webhooks subscribed
+// to its type.
+func (w *Webhooks) Publish(eventType, orgGUID string, data interface{}) error {
+	subscriptions, err := w.Store.Webhooks(orgGUID)
+	if err != nil {
+		return err
+	}
+	id, err := GenerateRandomString(16)
+	if err != nil {
+		return err
+	}
+	event := WebhookEvent{ID: id, Type: eventType, OrgGUID: orgGUID, Time: time.Now().UTC(), Data: data}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var tasks []jobs.Task
+	for _, subscription := range subscriptions {
+		if !subscription.Subscribes(eventType) {
+			continue
+		}
+		subscription := subscription
+		tasks = append(tasks, jobs.Task{Name: subscription.ID, Run: func() error {
+			return w.deliver(subscription, event, body)
+		}})
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+	// A webhook waiting to be retried doesn't hold up the others.
+	_, err = w.Jobs.SubmitWithOptions("webhook_delivery", "", tasks, jobs.Options{Concurrency: len(tasks)})
+	return err
+}
+
+// deliver posts the event to the subscription until it's accepted or the
+// attempts run out, logging each attempt.
+func (w *Webhooks) deliver(subscription db.WebhookSubscription, event WebhookEvent, body []byte) error {
+	id, err := GenerateRandomString(16)
+	if err != nil {
+		return err
+	}
+	delivery := db.WebhookDelivery{
+		ID:             id,
+		SubscriptionID: subscription.ID,
+		EventID:        event.ID,
+		Event:          event.Type,
+		Status:         db.WebhookDeliveryPending,
+		CreatedAt:      time.Now().UTC(),
+	}
+	backoff := w.Backoff
+	for delivery.Attempts < w.MaxAttempts {
+		if delivery.Attempts > 0 {
+			w.record(delivery)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		delivery.Attempts++
+		var retry bool
+		delivery.ResponseStatus, retry, err = w.post(subscription, event, body, delivery.ID)
+		if err == nil || !retry {
+			break
+		}
+		delivery.Error = err.Error()
+	}
+	now := time.Now().UTC()
+	delivery.FinishedAt = &now
+	if err != nil {
+		delivery.Status, delivery.Error = db.WebhookDeliveryFailed, err.Error()
+		webhookLog.Warnf("could not deliver %s event %s to webhook %s after %d attempts: %v",
+			event.Type, event.ID, subscription.ID, delivery.Attempts, err)
+	} else {
+		delivery.Status, delivery.Error = db.WebhookDeliverySucceeded, ""
+	}
+	w.record(delivery)
+	return err
+}
+
+// post posts the event once. It returns the response status and whether a
+// failed delivery is worth retrying.
+func (w *Webhooks) post(subscription db.WebhookSubscription, event WebhookEvent, body []byte, deliveryID string) (int, bool, error) {
+	req, err := http.NewRequest("POST", subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event.Type)
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(subscription.Secret, timestamp, body))
+	res, err := w.Client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	res.Body.Close()
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return res.StatusCode, false, nil
+	case res.StatusCode >= 400 && res.StatusCode < 500 &&
+		res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusTooManyRequests:
+		// The webhook refuses the delivery; it won't change its mind.
+		return res.StatusCode, false, fmt.Errorf("the webhook refused the delivery with status %d", res.StatusCode)
+	default:
+		return res.StatusCode, true, fmt.Errorf("unexpected status %d from the webhook", res.StatusCode)
+	}
+}
+
+func (w *Webhooks) record(delivery db.WebhookDelivery) {
+	if err := w.Store.RecordDelivery(delivery); err != nil {
+		webhookLog.Errorf("could not log the delivery %s of webhook %s: %v", delivery.ID, delivery.SubscriptionID, err)
+	}
+}
+
+// Subscribed returns true if any webhook, of any org, subscribes to the
+// event type, so events that nobody listens to aren't looked for.
+func (w *Webhooks) Subscribed(eventType string) (bool, error) {
+	subscriptions, err := w.Store.Webhooks("")
+	if err != nil {
+		return false, err
+	}
+	for _, subscription := range subscriptions {
+		if subscription.Subscribes(eventType) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// NewWebhookSecret returns a random secret to sign the deliveries of a new
+// subscription with.
+func NewWebhookSecret() (string, error) {
+	return GenerateRandomString(32)
+}
@@ -0,0 +1,119 @@
+package helpers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/jobs"
+)
+
+// waitForDeliveries waits for the webhook's deliveries to finish.
+func waitForDeliveries(t *testing.T, store db.WebhookStore, id string, n int) []db.WebhookDelivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, _ := store.Deliveries(id, 10)
+		finished := 0
+		for _, d := range deliveries {
+			if d.Status != db.WebhookDeliveryPending {
+				finished++
+			}
+		}
+		if finished >= n || time.Now().After(deadline) {
+			return deliveries
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhooksPublish(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		statuses = []int{http.StatusInternalServerError, http.StatusOK}
+		received []*http.Request
+		bodies   [][]byte
+	)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r)
+		bodies = append(bodies, body)
+		status := http.StatusBadRequest
+		if r.URL.Path == "/ok" {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	store := &db.MemoryWebhookStore{}
+	webhooks := helpers.NewWebhooks(store, jobs.NewRunner(1, 0))
+	webhooks.Client = server.Client()
+	webhooks.Backoff = time.Millisecond
+	ok, _ := store.CreateWebhook(db.WebhookSubscription{OrgGUID: "org-1", URL: server.URL + "/ok", Events: []string{helpers.WebhookOrgUserAdded}, Secret: "secret"})
+	refusing, _ := store.CreateWebhook(db.WebhookSubscription{OrgGUID: "org-1", URL: server.URL + "/refuse", Events: []string{helpers.WebhookOrgUserAdded}, Secret: "other"})
+	// Neither of these get the event.
+	store.CreateWebhook(db.WebhookSubscription{OrgGUID: "org-1", URL: server.URL + "/crashes", Events: []string{helpers.WebhookAppCrashedRepeatedly}})
+	store.CreateWebhook(db.WebhookSubscription{OrgGUID: "org-2", URL: server.URL + "/other-org", Events: []string{helpers.WebhookOrgUserAdded}})
+
+	if err := webhooks.Publish(helpers.WebhookOrgUserAdded, "org-1", map[string]string{"user_guid": "user-guid"}); err != nil {
+		t.Fatal(err)
+	}
+	deliveries := waitForDeliveries(t, store, ok.ID, 1)
+	if len(deliveries) != 1 || deliveries[0].Status != db.WebhookDeliverySucceeded || deliveries[0].Attempts != 2 {
+		t.Errorf("Expected the delivery to succeed on the retry. Found %+v", deliveries)
+	}
+	deliveries = waitForDeliveries(t, store, refusing.ID, 1)
+	if len(deliveries) != 1 || deliveries[0].Status != db.WebhookDeliveryFailed || deliveries[0].Attempts != 1 ||
+		deliveries[0].ResponseStatus != http.StatusBadRequest {
+		t.Errorf("Expected the refused delivery not to be retried. Found %+v", deliveries)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 3 {
+		t.Fatalf("Expected 3 posts. Found %d", len(received))
+	}
+	for i, r := range received {
+		secret := "secret"
+		if r.URL.Path == "/refuse" {
+			secret = "other"
+		}
+		timestamp := r.Header.Get(helpers.WebhookTimestampHeader)
+		if got, want := r.Header.Get(helpers.WebhookSignatureHeader), helpers.SignWebhook(secret, timestamp, bodies[i]); got != want {
+			t.Errorf("Expected signature %s. Found %s", want, got)
+		}
+		if r.Header.Get(helpers.WebhookEventHeader) != helpers.WebhookOrgUserAdded || r.Header.Get(helpers.WebhookDeliveryHeader) == "" {
+			t.Errorf("Unexpected headers %v", r.Header)
+		}
+		var event helpers.WebhookEvent
+		if err := json.Unmarshal(bodies[i], &event); err != nil || event.OrgGUID != "org-1" || event.Type != helpers.WebhookOrgUserAdded {
+			t.Errorf("Unexpected event %s", bodies[i])
+		}
+	}
+}
+
+func TestWebhooksRefusePrivateAddresses(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the loopback webhook not to be posted")
+	}))
+	defer server.Close()
+	store := &db.MemoryWebhookStore{}
+	webhooks := helpers.NewWebhooks(store, jobs.NewRunner(1, 0))
+	webhooks.MaxAttempts = 1
+	webhook, _ := store.CreateWebhook(db.WebhookSubscription{OrgGUID: "org-1", URL: server.URL, Events: []string{helpers.WebhookOrgUserAdded}})
+	if err := webhooks.Publish(helpers.WebhookOrgUserAdded, "org-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	deliveries := waitForDeliveries(t, store, webhook.ID, 1)
+	if len(deliveries) != 1 || deliveries[0].Status != db.WebhookDeliveryFailed {
+		t.Errorf("Expected the delivery to fail. Found %+v", deliveries)
+	}
+}
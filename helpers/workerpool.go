@@ -0,0 +1,110 @@
+package helpers
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultWorkerPoolSize is the most requests to backends made at the
+	// same time by fan-out endpoints, unless configured otherwise.
+	DefaultWorkerPoolSize = 32
+	// DefaultWorkerPoolPerUser is the most of them for a single user.
+	DefaultWorkerPoolPerUser = 8
+)
+
+var workerPoolBusy = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "dashboard_worker_pool_busy",
+	Help: "Workers of the shared pool currently running fan-out tasks.",
+})
+
+func init() {
+	prometheus.MustRegister(workerPoolBusy)
+}
+
+// WorkerPool bounds the concurrency of the endpoints that fan out to many
+// backend requests, such as walking pages or looking up each app. Workers are
+// shared by all requests, and each user can only use a few at a time, so one
+// heavy request can't starve the others.
+//
+// Tasks that don't get a worker run in the goroutine of the request instead,
+// one after the other. So requests always make progress, even when the pool
+// is used up or a task fans out again.
+type WorkerPool struct {
+	// PerUser is the most workers a user can use at the same time. Zero
+	// means no limit besides the size of the pool.
+	PerUser int
+
+	slots chan struct{}
+	mu    sync.Mutex
+	users map[string]int
+}
+
+// NewWorkerPool creates a WorkerPool with size workers.
+func NewWorkerPool(size, perUser int) *WorkerPool {
+	return &WorkerPool{
+		PerUser: perUser,
+		slots:   make(chan struct{}, size),
+		users:   make(map[string]int),
+	}
+}
+
+// acquire takes a worker for the user, if one is free.
+func (p *WorkerPool) acquire(user string) bool {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return false
+	}
+	if user != "" && p.PerUser > 0 {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.users[user] >= p.PerUser {
+			<-p.slots
+			return false
+		}
+		p.users[user]++
+	}
+	workerPoolBusy.Inc()
+	return true
+}
+
+func (p *WorkerPool) release(user string) {
+	workerPoolBusy.Dec()
+	if user != "" && p.PerUser > 0 {
+		p.mu.Lock()
+		if p.users[user]--; p.users[user] <= 0 {
+			delete(p.users, user)
+		}
+		p.mu.Unlock()
+	}
+	<-p.slots
+}
+
+// Run runs the tasks for the user, concurrently when workers are free, and
+// returns the error of the first failed task (in the order of the tasks).
+// A nil pool runs the tasks one after the other.
+func (p *WorkerPool) Run(user string, tasks []func() error) error {
+	errs := make([]error, len(tasks))
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		if p == nil || !p.acquire(user) {
+			errs[i] = task()
+			continue
+		}
+		wg.Add(1)
+		go func(i int, task func() error) {
+			defer wg.Done()
+			defer p.release(user)
+			errs[i] = task()
+		}(i, task)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
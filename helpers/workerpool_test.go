@@ -0,0 +1,109 @@
+package helpers_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// runTracked runs n tasks for the user and returns the most that ran at the
+// same time.
+func runTracked(pool *helpers.WorkerPool, user string, n int, running *int, mu *sync.Mutex) int {
+	most := 0
+	tasks := make([]func() error, n)
+	for i := range tasks {
+		tasks[i] = func() error {
+			mu.Lock()
+			*running++
+			if *running > most {
+				most = *running
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			*running--
+			mu.Unlock()
+			return nil
+		}
+	}
+	pool.Run(user, tasks)
+	return most
+}
+
+func TestWorkerPoolPerUser(t *testing.T) {
+	pool := helpers.NewWorkerPool(10, 2)
+	var mu sync.Mutex
+	running := 0
+	// The request's own goroutine runs tasks too, so one more than the
+	// workers can run at the same time.
+	if most := runTracked(pool, "user-1", 10, &running, &mu); most > 3 {
+		t.Errorf("Expected at most 3 tasks at the same time. Found %d", most)
+	}
+}
+
+func TestWorkerPoolSize(t *testing.T) {
+	pool := helpers.NewWorkerPool(2, 0)
+	var mu sync.Mutex
+	running := 0
+	var wg sync.WaitGroup
+	mosts := make([]int, 3)
+	for i := range mosts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mosts[i] = runTracked(pool, "", 5, &running, &mu)
+		}(i)
+	}
+	wg.Wait()
+	// Each of the 3 requests runs tasks itself, plus the 2 shared workers.
+	for _, most := range mosts {
+		if most > 5 {
+			t.Errorf("Expected at most 5 tasks at the same time. Found %d", most)
+		}
+	}
+}
+
+func TestWorkerPoolNested(t *testing.T) {
+	pool := helpers.NewWorkerPool(1, 1)
+	inner := func() error {
+		return pool.Run("user-1", []func() error{
+			func() error { return nil },
+			func() error { return nil },
+		})
+	}
+	done := make(chan error)
+	go func() {
+		done <- pool.Run("user-1", []func() error{inner, inner, inner})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Nested fan-out didn't finish")
+	}
+}
+
+func TestWorkerPoolErrors(t *testing.T) {
+	errFirst := errors.New("first")
+	for _, pool := range []*helpers.WorkerPool{nil, helpers.NewWorkerPool(4, 0)} {
+		ran := make([]bool, 3)
+		err := pool.Run("", []func() error{
+			func() error { ran[0] = true; return nil },
+			func() error { ran[1] = true; return errFirst },
+			func() error { ran[2] = true; return errors.New("second") },
+		})
+		if err != errFirst {
+			t.Errorf("Expected the first error. Found %v", err)
+		}
+		for i, ok := range ran {
+			if !ok {
+				t.Errorf("Expected task %d to run", i)
+			}
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Job and task statuses.
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+const (
+	// DefaultConcurrency is the default number of tasks run at the same time.
+	DefaultConcurrency = 2
+	// DefaultDelay is the default delay between starting two tasks.
+	DefaultDelay = 2 * time.Second
+	// DefaultRetention is how long finished jobs are kept around to be looked
+	// at, unless configured otherwise.
+	DefaultRetention = 24 * time.Hour
+)
+
+// Task is a single unit of work in a job, e.g. restaging one app.
+type Task struct {
+	// Name identifies the task in the results, e.g. the app GUID.
+	Name string
+	Run  func() error
+}
+
+// Result is the outcome of a single task.
+type Result struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Job is a group of tasks submitted together.
+type Job struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Owner      string     `json:"-"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// ResumesAt is set while the job waits for its maintenance window.
+	ResumesAt *time.Time `json:"resumes_at,omitempty"`
+	Results   []Result   `json:"results"`
+}
+
+// Options change how the tasks of a single job are run.
+type Options struct {
+	// Concurrency and Delay override the throttling of the runner when set.
+	Concurrency int
+	Delay       time.Duration
+	// Window, if set, only lets tasks start during the maintenance window.
+	Window *Window
+}
+
+// Runner runs jobs in the background. Tasks are throttled: at most
+// Concurrency tasks of a job run at once and task starts are spaced by Delay
+// so bulk operations don't overload the platform.
+type Runner struct {
+	Concurrency int
+	Delay       time.Duration
+	// Retention is how long finished jobs are kept.
+	Retention time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewRunner creates a Runner with the given throttling.
+func NewRunner(concurrency int, delay time.Duration) *Runner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Runner{
+		Concurrency: concurrency,
+		Delay:       delay,
+		Retention:   DefaultRetention,
+		jobs:        make(map[string]*Job),
+	}
+}
+
+// Submit queues the tasks as a new job and returns a snapshot of it.
+func (r *Runner) Submit(kind, owner string, tasks []Task) (Job, error) {
+	return r.SubmitWithOptions(kind, owner, tasks, Options{})
+}
+
+// SubmitWithOptions queues the tasks as a new job run with the options and
+// returns a snapshot of it.
+func (r *Runner) SubmitWithOptions(kind, owner string, tasks []Task, opts Options) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+	job := &Job{
+		ID:        id,
+		Kind:      kind,
+		Owner:     owner,
+		Status:    StatusPending,
+		CreatedAt: time.Now(),
+		Results:   make([]Result, len(tasks)),
+	}
+	for i, task := range tasks {
+		job.Results[i] = Result{Name: task.Name, Status: StatusPending}
+	}
+
+	r.mu.Lock()
+	r.purge(time.Now().Add(-r.Retention))
+	r.jobs[id] = job
+	snapshot := job.copy()
+	r.mu.Unlock()
+
+	if opts.Concurrency < 1 {
+		opts.Concurrency = r.Concurrency
+	}
+	if opts.Delay == 0 {
+		opts.Delay = r.Delay
+	}
+	go r.run(job, tasks, opts)
+	return snapshot, nil
+}
+
+// Get returns a snapshot of the job with the given ID.
+func (r *Runner) Get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return job.copy(), true
+}
+
+func (r *Runner) run(job *Job, tasks []Task, opts Options) {
+	r.setJobStatus(job, StatusRunning)
+
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		if i > 0 && opts.Delay > 0 {
+			time.Sleep(opts.Delay)
+		}
+		sem <- struct{}{}
+		if opts.Window != nil {
+			r.waitForWindow(job, *opts.Window)
+		}
+		wg.Add(1)
+		go func(i int, task Task) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			r.setResult(job, i, Result{Name: task.Name, Status: StatusRunning})
+			if err := task.Run(); err != nil {
+				r.setResult(job, i, Result{Name: task.Name, Status: StatusFailed, Error: err.Error()})
+				return
+			}
+			r.setResult(job, i, Result{Name: task.Name, Status: StatusSucceeded})
+		}(i, task)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Status = StatusSucceeded
+	for _, result := range job.Results {
+		if result.Status == StatusFailed {
+			job.Status = StatusFailed
+		}
+	}
+}
+
+// waitForWindow waits until the maintenance window is open.
+func (r *Runner) waitForWindow(job *Job, w Window) {
+	now := time.Now()
+	next := w.Next(now)
+	if !next.After(now) {
+		return
+	}
+	r.mu.Lock()
+	job.ResumesAt = &next
+	r.mu.Unlock()
+	time.Sleep(next.Sub(now))
+	r.mu.Lock()
+	job.ResumesAt = nil
+	r.mu.Unlock()
+}
+
+func (r *Runner) setJobStatus(job *Job, status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job.Status = status
+}
+
+func (r *Runner) setResult(job *Job, i int, result Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job.Results[i] = result
+}
+
+// Purge removes the jobs that finished before the time and returns how many
+// it removed.
+func (r *Runner) Purge(before time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.purge(before)
+}
+
+// purge removes the jobs that finished before the time. Must be called with
+// the lock held.
+func (r *Runner) purge(before time.Time) int {
+	purged := 0
+	for id, job := range r.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(before) {
+			delete(r.jobs, id)
+			purged++
+		}
+	}
+	return purged
+}
+
+func (j *Job) copy() Job {
+	c := *j
+	c.Results = append([]Result(nil), j.Results...)
+	if j.FinishedAt != nil {
+		finishedAt := *j.FinishedAt
+		c.FinishedAt = &finishedAt
+	}
+	if j.ResumesAt != nil {
+		resumesAt := *j.ResumesAt
+		c.ResumesAt = &resumesAt
+	}
+	return c
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
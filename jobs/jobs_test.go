@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForJob polls the runner until the job is finished.
+func waitForJob(t *testing.T, r *Runner, id string) Job {
+	for i := 0; i < 200; i++ {
+		job, ok := r.Get(id)
+		if !ok {
+			t.Fatalf("Expected to find job %s", id)
+		}
+		if job.FinishedAt != nil {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Job %s did not finish", id)
+	return Job{}
+}
+
+func TestRunnerResults(t *testing.T) {
+	r := NewRunner(2, 0)
+	job, err := r.Submit("test", "owner", []Task{
+		{Name: "ok", Run: func() error { return nil }},
+		{Name: "broken", Run: func() error { return errors.New("boom") }},
+	})
+	if err != nil {
+		t.Fatalf("Expected nil error, found %s", err.Error())
+	}
+	if job.Status != StatusPending || len(job.Results) != 2 {
+		t.Errorf("Unexpected submitted job %+v", job)
+	}
+
+	job = waitForJob(t, r, job.ID)
+	if job.Status != StatusFailed {
+		t.Errorf("Expected job status %s, found %s", StatusFailed, job.Status)
+	}
+	if job.Results[0].Status != StatusSucceeded {
+		t.Errorf("Expected task ok to succeed, found %+v", job.Results[0])
+	}
+	if job.Results[1].Status != StatusFailed || job.Results[1].Error != "boom" {
+		t.Errorf("Expected task broken to fail, found %+v", job.Results[1])
+	}
+	if job.Owner != "owner" || job.Kind != "test" {
+		t.Errorf("Unexpected job metadata %+v", job)
+	}
+}
+
+func TestRunnerConcurrency(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		runner   *Runner
+		opts     Options
+		expected int
+	}{
+		{name: "runner", runner: NewRunner(2, 0), expected: 2},
+		{name: "options", runner: NewRunner(2, 0), opts: Options{Concurrency: 3}, expected: 3},
+	} {
+		maxRunning := runConcurrently(t, test.runner, test.opts)
+		if maxRunning > test.expected {
+			t.Errorf("%s: expected at most %d concurrent tasks, found %d", test.name, test.expected, maxRunning)
+		}
+	}
+}
+
+// runConcurrently runs a job of slow tasks and returns the most tasks that
+// ran at the same time.
+func runConcurrently(t *testing.T, r *Runner, opts Options) int {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	task := func() error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}
+	tasks := make([]Task, 6)
+	for i := range tasks {
+		tasks[i] = Task{Name: "task", Run: task}
+	}
+	job, _ := r.SubmitWithOptions("test", "owner", tasks, opts)
+	job = waitForJob(t, r, job.ID)
+	if job.Status != StatusSucceeded {
+		t.Errorf("Expected job status %s, found %s", StatusSucceeded, job.Status)
+	}
+	return maxRunning
+}
+
+func TestRunnerUnknownJob(t *testing.T) {
+	r := NewRunner(1, 0)
+	if _, ok := r.Get("unknown"); ok {
+		t.Error("Expected not to find unknown job")
+	}
+}
+
+func TestRunnerPurge(t *testing.T) {
+	r := NewRunner(1, 0)
+	job, _ := r.Submit("test", "owner", []Task{{Name: "ok", Run: func() error { return nil }}})
+	job = waitForJob(t, r, job.ID)
+
+	if purged := r.Purge(job.FinishedAt.Add(-time.Second)); purged != 0 {
+		t.Errorf("Expected the job to be kept. Found %d purged", purged)
+	}
+	if purged := r.Purge(job.FinishedAt.Add(time.Second)); purged != 1 {
+		t.Errorf("Expected the job to be purged. Found %d purged", purged)
+	}
+	if _, ok := r.Get(job.ID); ok {
+		t.Error("Expected the purged job to be gone")
+	}
+}
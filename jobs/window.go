@@ -0,0 +1,66 @@
+package jobs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a daily maintenance window in UTC, e.g. 02:00-05:00. A window
+// can span midnight, e.g. 22:00-04:00.
+type Window struct {
+	// Start and End are offsets from midnight.
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseWindow parses a window written as HH:MM-HH:MM.
+func ParseWindow(s string) (*Window, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid maintenance window %q, expected HH:MM-HH:MM", s)
+	}
+	var offsets [2]time.Duration
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q, expected HH:MM-HH:MM", s)
+		}
+		offsets[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if offsets[0] == offsets[1] {
+		return nil, fmt.Errorf("invalid maintenance window %q, it's empty", s)
+	}
+	return &Window{Start: offsets[0], End: offsets[1]}, nil
+}
+
+// Next returns t if t is within the window, otherwise when the window next
+// opens.
+func (w Window) Next(t time.Time) time.Time {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	offset := t.Sub(midnight)
+	if w.contains(offset) {
+		return t
+	}
+	start := midnight.Add(w.Start)
+	if offset > w.Start {
+		start = start.AddDate(0, 0, 1)
+	}
+	return start
+}
+
+func (w Window) contains(offset time.Duration) bool {
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	// The window spans midnight.
+	return offset >= w.Start || offset < w.End
+}
+
+func (w Window) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(w.Start) + "-" + format(w.End)
+}
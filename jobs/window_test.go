@@ -0,0 +1,54 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	for _, test := range []struct {
+		window string
+		valid  bool
+	}{
+		{"02:00-05:00", true},
+		{"22:30-04:00", true},
+		{"02:00-5pm", false},
+		{"02:00", false},
+		{"05:00-05:00", false},
+		{"25:00-05:00", false},
+	} {
+		w, err := ParseWindow(test.window)
+		if test.valid && (err != nil || w.String() != test.window) {
+			t.Errorf("%s: unexpected window %v, error %v", test.window, w, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected an error", test.window)
+		}
+	}
+}
+
+func TestWindowNext(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2018, 6, 1, hour, min, 0, 0, time.UTC)
+	}
+	day, _ := ParseWindow("02:00-05:00")
+	night, _ := ParseWindow("22:00-04:00")
+	for _, test := range []struct {
+		name     string
+		window   *Window
+		now      time.Time
+		expected time.Time
+	}{
+		{"before", day, at(1, 0), at(2, 0)},
+		{"within", day, at(3, 0), at(3, 0)},
+		{"after", day, at(6, 0), at(2, 0).AddDate(0, 0, 1)},
+		{"end", day, at(5, 0), at(2, 0).AddDate(0, 0, 1)},
+		{"overnight before midnight", night, at(23, 0), at(23, 0)},
+		{"overnight after midnight", night, at(1, 0), at(1, 0)},
+		{"overnight outside", night, at(12, 0), at(22, 0)},
+	} {
+		if next := test.window.Next(test.now); !next.Equal(test.expected) {
+			t.Errorf("%s: expected %s, found %s", test.name, test.expected, next)
+		}
+	}
+}
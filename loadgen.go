@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// defaultLoadgenPaths are the main aggregates and the most used proxied
+// requests of the frontend.
+var defaultLoadgenPaths = []string{
+	"/v2/authstatus",
+	"/v2/organizations",
+	"/v2/spaces",
+	"/v2/apps",
+	"/uaa/userinfo",
+	"/api/changes",
+}
+
+// loadgenResult is the outcome of a single request.
+type loadgenResult struct {
+	path    string
+	latency time.Duration
+	failed  bool
+}
+
+// runLoadgen implements the loadgen subcommand. It sends requests as
+// synthetic users to a dashboard started with SYNTHETIC_USERS against a mock
+// backend, and reports the latency percentiles of each path.
+func runLoadgen(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	target := flags.String("target", "http://localhost:"+defaultPort, "URL of the dashboard")
+	concurrency := flags.Int("concurrency", 10, "requests sent at the same time")
+	duration := flags.Duration("duration", 30*time.Second, "how long to send requests for")
+	users := flags.Int("users", 50, "number of synthetic users the requests are spread over")
+	paths := flags.String("paths", strings.Join(defaultLoadgenPaths, ","), "comma separated paths to request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *concurrency < 1 || *users < 1 {
+		return fmt.Errorf("concurrency and users must be at least 1")
+	}
+	var targets []string
+	for _, path := range strings.Split(*paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			targets = append(targets, path)
+		}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no paths to request")
+	}
+
+	client := &http.Client{Timeout: helpers.TimeoutConstant + 5*time.Second}
+	results := make(chan loadgenResult, *concurrency)
+	deadline := time.Now().Add(*duration)
+	var wg sync.WaitGroup
+	for worker := 0; worker < *concurrency; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(int64(worker)))
+			for i := worker; time.Now().Before(deadline); i++ {
+				path := targets[i%len(targets)]
+				user := "user-" + strconv.Itoa(random.Intn(*users))
+				results <- loadgenRequest(client, *target+path, user, path)
+			}
+		}(worker)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	latencies := make(map[string][]time.Duration)
+	failures := make(map[string]int)
+	for result := range results {
+		latencies[result.path] = append(latencies[result.path], result.latency)
+		if result.failed {
+			failures[result.path]++
+		}
+	}
+	writeLoadgenReport(out, targets, latencies, failures, *duration)
+	return nil
+}
+
+// loadgenRequest sends a GET request as the synthetic user.
+func loadgenRequest(client *http.Client, url, user, path string) loadgenResult {
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set(helpers.SyntheticUserHeader, user)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	start := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		return loadgenResult{path: path, latency: time.Since(start), failed: true}
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	return loadgenResult{
+		path:    path,
+		latency: time.Since(start),
+		failed:  res.StatusCode >= 400,
+	}
+}
+
+// percentile returns the p-th percentile of the sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p/100*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func writeLoadgenReport(out io.Writer, paths []string, latencies map[string][]time.Duration, failures map[string]int, duration time.Duration) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "path\trequests\tfailed\treq/s\tp50\tp90\tp99\tmax\t")
+	for _, path := range paths {
+		sorted := latencies[path]
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%v\t%v\t%v\t%v\t\n",
+			path, len(sorted), failures[path], float64(len(sorted))/duration.Seconds(),
+			percentile(sorted, 50).Round(time.Millisecond),
+			percentile(sorted, 90).Round(time.Millisecond),
+			percentile(sorted, 99).Round(time.Millisecond),
+			percentile(sorted, 100).Round(time.Millisecond))
+	}
+	w.Flush()
+}
+
+// loadgenMain runs the loadgen subcommand and exits.
+func loadgenMain(args []string) {
+	if err := runLoadgen(args, os.Stdout); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,197 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// apiTimeout is how long the HTTPS backends have to send an email.
+const apiTimeout = 30 * time.Second
+
+// APIError is an error response from the API of an email backend.
+type APIError struct {
+	Backend string
+	Status  int
+	Body    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s responded with status %d: %s", e.Backend, e.Status, e.Body)
+}
+
+// Permanent returns true if the API refused the email for good, e.g. for an
+// invalid address, so retrying won't help. Throttling and server errors are
+// worth retrying.
+func (e *APIError) Permanent() bool {
+	return e.Status >= 400 && e.Status < 500 && e.Status != http.StatusTooManyRequests
+}
+
+// permanentError returns true if the backend refused the email for good, so
+// retrying won't help: an SMTP 5xx reply, e.g. 550 for an unknown mailbox, or
+// an API's client error.
+func permanentError(err error) bool {
+	switch e := err.(type) {
+	case *textproto.Error:
+		return e.Code >= 500
+	case *APIError:
+		return e.Permanent()
+	}
+	return false
+}
+
+// apiClient returns the client the HTTPS backends send emails with. It
+// trusts the same CAs as the settings' HTTPClient.
+func apiClient(settings helpers.Settings) *http.Client {
+	transport := http.DefaultTransport
+	if settings.HTTPClient != nil && settings.HTTPClient.Transport != nil {
+		transport = settings.HTTPClient.Transport
+	}
+	return &http.Client{Transport: transport, Timeout: apiTimeout}
+}
+
+// postJSON posts the JSON body with the client after sign sets its headers,
+// and returns an APIError unless the backend accepted it.
+func postJSON(client *http.Client, backend, url string, body interface{}, sign func(req *http.Request, payload []byte)) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	sign(req, payload)
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		io.Copy(ioutil.Discard, res.Body)
+		return nil
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	return &APIError{Backend: backend, Status: res.StatusCode, Body: string(bytes.TrimSpace(message))}
+}
+
+// sendGridMailer sends emails with the SendGrid v3 API.
+type sendGridMailer struct {
+	client *http.Client
+	url    string
+	apiKey string
+	from   *mail.Address
+}
+
+// InitSendGridMailer creates a Mailer sending emails with the SendGrid API.
+func InitSendGridMailer(settings helpers.Settings) (Mailer, error) {
+	from, err := mail.ParseAddress(settings.SMTPFrom)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the sender %q: %v", settings.SMTPFrom, err)
+	}
+	return &sendGridMailer{
+		client: apiClient(settings),
+		url:    settings.SendGrid.URL,
+		apiKey: settings.SendGrid.APIKey,
+		from:   from,
+	}, nil
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridPersonalization struct {
+	To []sendGridAddress `json:"to"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridMessage struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+}
+
+func (s *sendGridMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	return s.SendMultipartEmail(emailAddress, subject, body, nil)
+}
+
+func (s *sendGridMailer) SendMultipartEmail(emailAddress, subject string, html, text []byte) error {
+	message := sendGridMessage{
+		Personalizations: []sendGridPersonalization{{To: []sendGridAddress{{Email: emailAddress}}}},
+		From:             sendGridAddress{Email: s.from.Address, Name: s.from.Name},
+		Subject:          subject,
+	}
+	// SendGrid requires the plain text part first.
+	if len(text) > 0 {
+		message.Content = append(message.Content, sendGridContent{Type: "text/plain", Value: string(text)})
+	}
+	message.Content = append(message.Content, sendGridContent{Type: "text/html", Value: string(html)})
+	return postJSON(s.client, helpers.EmailBackendSendGrid, s.url, message, func(req *http.Request, payload []byte) {
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	})
+}
+
+// sesMailer sends emails with the AWS SES v2 API.
+type sesMailer struct {
+	client   *http.Client
+	settings helpers.SESSettings
+	from     string
+	now      func() time.Time
+}
+
+// InitSESMailer creates a Mailer sending emails with the SES API.
+func InitSESMailer(settings helpers.Settings) (Mailer, error) {
+	if _, err := mail.ParseAddress(settings.SMTPFrom); err != nil {
+		return nil, fmt.Errorf("could not parse the sender %q: %v", settings.SMTPFrom, err)
+	}
+	return &sesMailer{
+		client:   apiClient(settings),
+		settings: *settings.SES,
+		from:     settings.SMTPFrom,
+		now:      time.Now,
+	}, nil
+}
+
+func (s *sesMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	return s.SendMultipartEmail(emailAddress, subject, body, nil)
+}
+
+func (s *sesMailer) SendMultipartEmail(emailAddress, subject string, html, text []byte) error {
+	type content struct {
+		Data    string `json:"Data"`
+		Charset string `json:"Charset"`
+	}
+	body := map[string]content{"Html": {Data: string(html), Charset: "UTF-8"}}
+	if len(text) > 0 {
+		body["Text"] = content{Data: string(text), Charset: "UTF-8"}
+	}
+	message := map[string]interface{}{
+		"FromEmailAddress": s.from,
+		"Destination":      map[string][]string{"ToAddresses": {emailAddress}},
+		"Content": map[string]interface{}{
+			"Simple": map[string]interface{}{
+				"Subject": content{Data: subject, Charset: "UTF-8"},
+				"Body":    body,
+			},
+		},
+	}
+	return postJSON(s.client, helpers.EmailBackendSES, s.settings.URL(), message, func(req *http.Request, payload []byte) {
+		signV4(req, payload, s.settings, s.now().UTC())
+	})
+}
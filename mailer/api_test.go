@@ -0,0 +1,149 @@
+package mailer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// recordingServer records the last request it got and responds with status.
+func recordingServer(status int, req **http.Request, body *[]byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		*req = r
+		*body, _ = ioutil.ReadAll(r.Body)
+		rw.WriteHeader(status)
+		rw.Write([]byte(`{"errors":[{"message":"invalid"}]}`))
+	}))
+}
+
+func TestSendGridMailer(t *testing.T) {
+	var req *http.Request
+	var body []byte
+	server := recordingServer(http.StatusAccepted, &req, &body)
+	defer server.Close()
+	m, err := InitSendGridMailer(helpers.Settings{
+		SMTPFrom: "Dashboard <dashboard@example.gov>",
+		SendGrid: &helpers.SendGridSettings{APIKey: "key", URL: server.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SendEmail("a@example.gov", "Welcome", []byte("<p>hi</p>")); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer key" {
+		t.Errorf("Authorization = %q, want the API key", got)
+	}
+	var message sendGridMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.From.Email != "dashboard@example.gov" || message.From.Name != "Dashboard" {
+		t.Errorf("From = %+v", message.From)
+	}
+	if len(message.Personalizations) != 1 || message.Personalizations[0].To[0].Email != "a@example.gov" {
+		t.Errorf("Personalizations = %+v", message.Personalizations)
+	}
+	if message.Subject != "Welcome" || message.Content[0].Value != "<p>hi</p>" {
+		t.Errorf("message = %+v", message)
+	}
+}
+
+func TestSESMailer(t *testing.T) {
+	var req *http.Request
+	var body []byte
+	server := recordingServer(http.StatusOK, &req, &body)
+	defer server.Close()
+	m, err := InitSESMailer(helpers.Settings{
+		SMTPFrom: "dashboard@example.gov",
+		SES: &helpers.SESSettings{
+			Region:          "us-gov-west-1",
+			AccessKeyID:     "AKID",
+			SecretAccessKey: "secret",
+			SessionToken:    "token",
+			Endpoint:        server.URL,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.(*sesMailer).now = func() time.Time { return time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC) }
+	if err := m.SendEmail("a@example.gov", "Welcome", []byte("<p>hi</p>")); err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.Path != "/v2/email/outbound-emails" {
+		t.Errorf("path = %q", req.URL.Path)
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/20261017/us-gov-west-1/ses/aws4_request, SignedHeaders=") {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if req.Header.Get("X-Amz-Date") != "20261017T120000Z" || req.Header.Get("X-Amz-Security-Token") != "token" {
+		t.Errorf("headers = %v", req.Header)
+	}
+	if !strings.Contains(string(body), `"ToAddresses":["a@example.gov"]`) {
+		t.Errorf("body = %s", body)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	testCases := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusServiceUnavailable, false},
+	}
+	for _, tc := range testCases {
+		var req *http.Request
+		var body []byte
+		server := recordingServer(tc.status, &req, &body)
+		m, err := InitSendGridMailer(helpers.Settings{
+			SMTPFrom: "dashboard@example.gov",
+			SendGrid: &helpers.SendGridSettings{APIKey: "key", URL: server.URL},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.SendEmail("a@example.gov", "Welcome", nil)
+		server.Close()
+		apiErr, ok := err.(*APIError)
+		if !ok {
+			t.Fatalf("status %d: got %v, want an APIError", tc.status, err)
+		}
+		if apiErr.Status != tc.status || permanentError(err) != tc.permanent {
+			t.Errorf("status %d: got %+v, want permanent %v", tc.status, apiErr, tc.permanent)
+		}
+	}
+}
+
+func TestSendGridMultipartEmail(t *testing.T) {
+	var req *http.Request
+	var body []byte
+	server := recordingServer(http.StatusAccepted, &req, &body)
+	defer server.Close()
+	m, err := InitSendGridMailer(helpers.Settings{
+		SMTPFrom: "dashboard@example.gov",
+		SendGrid: &helpers.SendGridSettings{APIKey: "key", URL: server.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SendMultipartEmail("a@example.gov", "Welcome", []byte("<p>hi</p>"), []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	var message sendGridMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		t.Fatal(err)
+	}
+	if len(message.Content) != 2 || message.Content[0].Type != "text/plain" || message.Content[1].Value != "<p>hi</p>" {
+		t.Errorf("Expected the plain text part before the HTML. Found %+v", message.Content)
+	}
+}
@@ -12,6 +12,30 @@ import (
 // Mailer is a interface that any mailer should implement.
 type Mailer interface {
 	SendEmail(emailAddress string, subject string, body []byte) error
+	// SendMultipartEmail sends an email with both an HTML and a plain text
+	// part, so it isn't taken for spam for lacking the alternative.
+	SendMultipartEmail(emailAddress string, subject string, html, text []byte) error
+}
+
+// InitMailer creates the Mailer of the settings' email backend, with its
+// sends exported as metrics.
+func InitMailer(settings helpers.Settings) (Mailer, error) {
+	var m Mailer
+	var err error
+	backend := settings.EmailBackend
+	switch backend {
+	case helpers.EmailBackendSES:
+		m, err = InitSESMailer(settings)
+	case helpers.EmailBackendSendGrid:
+		m, err = InitSendGridMailer(settings)
+	default:
+		backend = helpers.EmailBackendSMTP
+		m, err = InitSMTPMailer(settings)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedMailer{Mailer: m, backend: backend}, nil
 }
 
 // InitSMTPMailer creates a new SMTP Mailer
@@ -48,10 +72,15 @@ type smtpMailer struct {
 }
 
 func (s *smtpMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	return s.SendMultipartEmail(emailAddress, subject, body, nil)
+}
+
+func (s *smtpMailer) SendMultipartEmail(emailAddress, subject string, html, text []byte) error {
 	e := email.NewEmail()
 	e.From = s.smtpFrom
 	e.To = []string{" <" + emailAddress + ">"}
-	e.HTML = body
+	e.HTML = html
+	e.Text = text
 	e.Subject = subject
 
 	addr := s.smtpHost + ":" + s.smtpPass
@@ -2,6 +2,7 @@ package mailer
 
 import (
 	"bytes"
+	"errors"
 	"strings"
 	"testing"
 
@@ -64,3 +65,23 @@ func TestInitSMTPMailer(t *testing.T) {
 		t.Errorf("Expected nil error, found %s", err.Error())
 	}
 }
+
+func TestInitMailerInstrumented(t *testing.T) {
+	m, err := InitMailer(helpers.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instrumented, ok := m.(*instrumentedMailer)
+	if !ok || instrumented.backend != helpers.EmailBackendSMTP {
+		t.Errorf("Expected the SMTP mailer to be instrumented. Found %#v", m)
+	}
+
+	flaky := &flakyMailer{failures: 1, err: errors.New("connection reset"), attempts: map[string]int{}, sent: make(chan string, 1)}
+	m = &instrumentedMailer{Mailer: flaky, backend: "test"}
+	if err := m.SendEmail("a@example.gov", "Welcome", nil); err != flaky.err {
+		t.Errorf("Expected the backend's error. Found %v", err)
+	}
+	if err := m.SendEmail("a@example.gov", "Welcome", nil); err != nil {
+		t.Errorf("Expected the email to be sent. Found %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	mailSends = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_mail_sends_total",
+		Help: "Emails handed to the email backend, by backend and result.",
+	}, []string{"backend", "result"})
+	mailSendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dashboard_mail_send_duration_seconds",
+		Help:    "Duration of handing emails to the email backend.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"backend"})
+)
+
+func init() {
+	prometheus.MustRegister(mailSends, mailSendDuration)
+}
+
+// instrumentedMailer counts the emails sent by its Mailer and how long the
+// backend took, so a slow or failing backend shows before the queue backs up.
+type instrumentedMailer struct {
+	Mailer
+	backend string
+}
+
+func (m *instrumentedMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	return m.SendMultipartEmail(emailAddress, subject, body, nil)
+}
+
+func (m *instrumentedMailer) SendMultipartEmail(emailAddress, subject string, html, text []byte) error {
+	start := time.Now()
+	err := m.Mailer.SendMultipartEmail(emailAddress, subject, html, text)
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	mailSends.WithLabelValues(m.backend, result).Inc()
+	mailSendDuration.WithLabelValues(m.backend).Observe(time.Since(start).Seconds())
+	return err
+}
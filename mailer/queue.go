@@ -0,0 +1,142 @@
+package mailer
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/18F/cg-dashboard/helpers"
+	"github.com/18F/cg-dashboard/helpers/logging"
+)
+
+// ErrQueueFull is returned when too many emails are waiting to be sent.
+var ErrQueueFull = errors.New("too many emails are waiting to be sent")
+
+// The results of the attempts to send queued emails.
+const (
+	deliverySent    = "sent"
+	deliveryRetried = "retried"
+	deliveryFailed  = "failed"
+	deliveryRefused = "refused"
+)
+
+var (
+	mailQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dashboard_mail_queue_depth",
+		Help: "Emails waiting to be sent, including those waiting to be retried.",
+	})
+	mailDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dashboard_mail_deliveries_total",
+		Help: "Attempts to send queued emails, by whether they were sent, will be retried, failed for good or were refused by a full queue.",
+	}, []string{"result"})
+	mailDeliveryAttempts = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "dashboard_mail_delivery_attempts",
+		Help:    "Attempts it took to send queued emails, or to give up on them.",
+		Buckets: prometheus.LinearBuckets(1, 1, 10),
+	})
+)
+
+var mailLog = logging.New("mailer")
+
+func init() {
+	prometheus.MustRegister(mailQueueDepth, mailDeliveries, mailDeliveryAttempts)
+}
+
+// queuedEmail is an email waiting to be sent.
+type queuedEmail struct {
+	address  string
+	subject  string
+	html     []byte
+	text     []byte
+	attempts int
+}
+
+// Queue sends the emails of a Mailer in the background. The emails that fail
+// are retried with an exponential backoff, unless the backend refused them
+// for good. Those that still fail are logged as failed.
+type Queue struct {
+	mailer   Mailer
+	settings helpers.MailQueueSettings
+	emails   chan *queuedEmail
+}
+
+// NewQueue creates a Queue sending the emails with the mailer, and starts its
+// workers.
+func NewQueue(mailer Mailer, settings helpers.MailQueueSettings) *Queue {
+	q := &Queue{
+		mailer:   mailer,
+		settings: settings,
+		emails:   make(chan *queuedEmail, settings.Size),
+	}
+	for i := 0; i < settings.Workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// SendEmail queues the email. It only returns an error if the queue is full.
+func (q *Queue) SendEmail(emailAddress, subject string, body []byte) error {
+	return q.SendMultipartEmail(emailAddress, subject, body, nil)
+}
+
+// SendMultipartEmail queues the email with its HTML and plain text parts. It
+// only returns an error if the queue is full.
+func (q *Queue) SendMultipartEmail(emailAddress, subject string, html, text []byte) error {
+	select {
+	case q.emails <- &queuedEmail{address: emailAddress, subject: subject, html: html, text: text}:
+		mailQueueDepth.Inc()
+		return nil
+	default:
+		mailDeliveries.WithLabelValues(deliveryRefused).Inc()
+		return ErrQueueFull
+	}
+}
+
+// Depth returns how many emails are waiting to be sent, not counting those
+// waiting to be retried.
+func (q *Queue) Depth() int {
+	return len(q.emails)
+}
+
+func (q *Queue) work() {
+	for email := range q.emails {
+		q.send(email)
+	}
+}
+
+func (q *Queue) send(email *queuedEmail) {
+	email.attempts++
+	err := q.mailer.SendMultipartEmail(email.address, email.subject, email.html, email.text)
+	switch {
+	case err == nil:
+		mailDeliveries.WithLabelValues(deliverySent).Inc()
+		mailDeliveryAttempts.Observe(float64(email.attempts))
+		mailQueueDepth.Dec()
+	case email.attempts >= q.settings.MaxAttempts || permanentError(err):
+		mailDeliveries.WithLabelValues(deliveryFailed).Inc()
+		mailDeliveryAttempts.Observe(float64(email.attempts))
+		mailQueueDepth.Dec()
+		mailLog.Errorf("gave up sending %q to %s after %d attempts: %v",
+			email.subject, email.address, email.attempts, err)
+	default:
+		mailDeliveries.WithLabelValues(deliveryRetried).Inc()
+		delay := q.backoff(email.attempts)
+		mailLog.Warnf("could not send %q to %s, retrying in %s: %v", email.subject, email.address, delay, err)
+		// The retry waits outside the queue, so it doesn't hold up a worker,
+		// and goes back in even if the queue filled up meanwhile.
+		time.AfterFunc(delay, func() { q.emails <- email })
+	}
+}
+
+// backoff returns how long to wait before retrying after the attempt: a
+// random delay between half and all of the base delay doubled for each
+// attempt, capped at the max delay.
+func (q *Queue) backoff(attempt int) time.Duration {
+	d := q.settings.BaseDelay << uint(attempt-1)
+	if d > q.settings.MaxDelay || d <= 0 {
+		d = q.settings.MaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,78 @@
+package mailer
+
+import (
+	"errors"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// flakyMailer fails the first attempts at each address with its error.
+type flakyMailer struct {
+	mu       sync.Mutex
+	failures int
+	err      error
+	attempts map[string]int
+	sent     chan string
+}
+
+func (m *flakyMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	return m.SendMultipartEmail(emailAddress, subject, body, nil)
+}
+
+func (m *flakyMailer) SendMultipartEmail(emailAddress, subject string, html, text []byte) error {
+	m.mu.Lock()
+	m.attempts[emailAddress]++
+	attempts := m.attempts[emailAddress]
+	m.mu.Unlock()
+	if attempts <= m.failures {
+		return m.err
+	}
+	m.sent <- emailAddress
+	return nil
+}
+
+func TestQueueRetries(t *testing.T) {
+	m := &flakyMailer{failures: 2, err: errors.New("connection reset"), attempts: map[string]int{}, sent: make(chan string, 1)}
+	q := NewQueue(m, helpers.MailQueueSettings{Workers: 1, Size: 1, MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	if err := q.SendEmail("a@example.gov", "subject", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case address := <-m.sent:
+		if address != "a@example.gov" || m.attempts[address] != 3 {
+			t.Errorf("Expected a@example.gov to be sent on the third attempt. Found %s after %d", address, m.attempts[address])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the email to be retried until sent")
+	}
+}
+
+func TestQueueGivesUp(t *testing.T) {
+	m := &flakyMailer{failures: 10, err: &textproto.Error{Code: 550, Msg: "no such user"}, attempts: map[string]int{}, sent: make(chan string, 1)}
+	q := NewQueue(m, helpers.MailQueueSettings{Workers: 1, Size: 1, MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	q.SendEmail("a@example.gov", "subject", nil)
+	time.Sleep(50 * time.Millisecond)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.attempts["a@example.gov"] != 1 {
+		t.Errorf("Expected a permanent SMTP error not to be retried. Found %d attempts", m.attempts["a@example.gov"])
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	// Without workers nothing leaves the queue.
+	q := NewQueue(&flakyMailer{}, helpers.MailQueueSettings{Size: 1, MaxAttempts: 1})
+	if err := q.SendEmail("a@example.gov", "subject", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.SendEmail("b@example.gov", "subject", nil); err != ErrQueueFull {
+		t.Errorf("Expected %v. Found %v", ErrQueueFull, err)
+	}
+	if q.Depth() != 1 {
+		t.Errorf("Expected 1 email queued. Found %d", q.Depth())
+	}
+}
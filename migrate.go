@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/18F/cg-dashboard/db"
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// runMigrate implements the migrate subcommand. It shows or changes the
+// schema version of the database at DATABASE_URL:
+//
+//	migrate status           lists the migrations and when they were applied
+//	migrate plan [-to N]     lists the migrations up or down would run
+//	migrate up [-to N]       applies the migrations up to N, the latest by default
+//	migrate down [-to N]     reverts the migrations after N, the last one by default
+//
+// With -dry-run, up and down print the SQL they would run instead.
+func runMigrate(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: cg-dashboard migrate up|down|status|plan [-to N] [-dry-run]")
+	}
+	command := args[0]
+	flags := flag.NewFlagSet("migrate "+command, flag.ContinueOnError)
+	to := flags.Int("to", -1, "schema version to migrate to")
+	dryRun := flags.Bool("dry-run", false, "print the SQL instead of running it")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	url := os.Getenv(helpers.DatabaseURLEnvVar)
+	if url == "" {
+		return errors.New(helpers.DatabaseURLEnvVar + " is not set")
+	}
+	conn, err := db.Connect(url, db.DefaultPoolOptions())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch command {
+	case "status":
+		statuses, err := db.Statuses(conn)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tAPPLIED\tDESCRIPTION")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, applied, s.Description)
+		}
+		return w.Flush()
+	case "plan", "up":
+		target := *to
+		if target < 0 {
+			target = db.LatestVersion()
+		}
+		if command == "plan" {
+			steps, err := db.Plan(conn, target)
+			if err != nil {
+				return err
+			}
+			if len(steps) == 0 {
+				fmt.Fprintln(out, "the schema is up to date")
+			}
+			for _, step := range steps {
+				fmt.Fprintln(out, step)
+			}
+			return nil
+		}
+		return db.MigrateTo(conn, target, *dryRun, out)
+	case "down":
+		target := *to
+		if target < 0 {
+			current, err := db.CurrentVersion(conn)
+			if err != nil {
+				return err
+			}
+			if current == 0 {
+				return errors.New("no migration to revert")
+			}
+			target = current - 1
+		}
+		return db.MigrateTo(conn, target, *dryRun, out)
+	}
+	return fmt.Errorf("unknown migrate command %q", command)
+}
+
+// migrateMain runs the migrate subcommand and exits.
+func migrateMain(args []string) {
+	if err := runMigrate(args, os.Stdout); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
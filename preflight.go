@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cloudfoundry-community/go-cfenv"
+
+	"github.com/18F/cg-dashboard/helpers"
+)
+
+// runPreflight implements the preflight subcommand. It resolves the settings
+// like the server does, then checks their dependencies are live and prints a
+// readiness report. It fails if the settings can't be resolved or a check
+// fails, so it can run as a CF task before traffic is sent to a deployment:
+//
+//	preflight [-timeout 10s]
+func runPreflight(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("preflight", flag.ContinueOnError)
+	timeout := flags.Duration("timeout", helpers.DefaultPreflightTimeout, "how long each check can take")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	app, _ := cfenv.Current()
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tRESULT\tDETAIL")
+	envVars, err := withProfileDefaults(makeEnvVarSetOpts(app))
+	settings := helpers.Settings{Preflight: true}
+	if err == nil {
+		err = settings.InitSettings(envVars, app)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "settings\t%s\t%v\n", helpers.PreflightFail, err)
+		w.Flush()
+		return errors.New("not ready: the settings are invalid")
+	}
+	if settings.DB != nil {
+		defer settings.DB.Close()
+	}
+	fmt.Fprintf(w, "settings\t%s\tresolved for %s\n", helpers.PreflightOK, settings.AppURL)
+
+	results := helpers.RunPreflightChecks(&settings, *timeout)
+	failed := 0
+	for _, r := range results {
+		if r.Result == helpers.PreflightFail {
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, r.Result, r.Detail)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if !helpers.PreflightReady(results) {
+		return fmt.Errorf("not ready: %d checks failed", failed)
+	}
+	fmt.Fprintln(out, "ready")
+	return nil
+}
+
+// preflightMain runs the preflight subcommand and exits.
+func preflightMain(args []string) {
+	if err := runPreflight(args, os.Stdout); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
 	"os"
 	"strings"
@@ -27,46 +26,60 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "loadgen" {
+		loadgenMain(os.Args[2:])
+	}
+	if len(os.Args) > 1 && (os.Args[1] == "export" || os.Args[1] == "import") {
+		archiveMain(os.Args[1], os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrateMain(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "preflight" {
+		preflightMain(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "dev-login" {
+		devLoginMain(os.Args[2:])
+	}
+
 	// Start the server up.
 	var port string
 	if port = os.Getenv("PORT"); len(port) == 0 {
 		port = defaultPort
 	}
-	fmt.Println("using port: " + port)
+	report := helpers.NewStartupReport(os.Stdout, port)
 
 	// Try to load the user-provided-service
 	// for backup of certain environment variables.
 	cfEnv, err := cfenv.Current()
 	if err != nil || cfEnv == nil {
-		fmt.Println("Warning: No Cloud Foundry Environment found")
+		report.Warn("no Cloud Foundry environment found")
 	}
 
-	startApp(port, cfEnv)
+	startApp(port, cfEnv, report)
 }
 
-func startMonitoring(license string) {
+func startMonitoring(license string) error {
 	agent := gorelic.NewAgent()
 	agent.Verbose = true
 	agent.CollectHTTPStat = true
 	agent.NewrelicLicense = license
 	agent.NewrelicName = "Cloudgov Deck"
-	if err := agent.Run(); err != nil {
-		fmt.Println(err.Error())
-	}
+	return agent.Run()
 }
 
-func startApp(port string, app *cfenv.App) {
+func startApp(port string, app *cfenv.App, report *helpers.StartupReport) {
+	opts := makeEnvVarSetOpts(app)
 	var envVars *env.VarSet
-
-	if upsNames := os.Getenv(envUPSNames); upsNames != "" && app != nil {
-		envVars = makeUPSEnvVarSet(app, upsNames)
-	} else {
-		envVars = makeDefaultEnvVarSet(app)
+	if err := report.Step("env", func() (err error) {
+		envVars, err = withProfileDefaults(opts)
+		return err
+	}); err != nil {
+		os.Exit(1)
 	}
 
-	router, settings, err := controllers.InitApp(envVars, app)
+	router, settings, err := controllers.InitAppReporting(envVars, app, report)
 	if err != nil {
-		fmt.Println(err.Error())
 		// Terminate the program with a non-zero value number.
 		// Need this for testing purposes.
 		os.Exit(1)
@@ -74,41 +87,211 @@ func startApp(port string, app *cfenv.App) {
 	if settings.PProfEnabled {
 		pprof.InitPProfRouter(router)
 	}
+	report.Describe(settings)
+
+	report.Step("uaa_client_check", func() error {
+		checkUAAClient(settings, report)
+		return nil
+	})
+	report.Step("schema_drift_check", func() error {
+		for _, drift := range settings.SchemaDrift.Check() {
+			report.Warn("upstream schema drift: " + drift)
+		}
+		return nil
+	})
+	// Only the first instance keeps checking, so drift is alerted once.
+	if isFirstInstance() {
+		report.Info("checking the upstream schemas every " + settings.SchemaDrift.Interval.String())
+		settings.SchemaDrift.Start()
+	}
+
+	if settings.Telemetry != nil {
+		report.Info("reporting anonymous usage telemetry to " + settings.Telemetry.URL)
+		settings.Telemetry.Start()
+	}
+
+	if settings.Alerts != nil {
+		report.Info("evaluating alerts every " + settings.Alerts.Interval.String())
+		settings.Alerts.Start()
+	}
+
+	if settings.UAAFailover != nil {
+		report.Info("checking the primary and secondary UAA endpoints every " + settings.UAAFailover.Interval.String())
+		settings.UAAFailover.Start()
+	}
+
+	report.Info("purging data past its retention every " + settings.Purger.Interval.String())
+	settings.Purger.Start()
+	settings.CrashWatcher.Start()
+
+	// Only the first instance starts campaigns, so orgs are reviewed once.
+	if settings.AccessReviews.Interval > 0 && isFirstInstance() {
+		report.Info("starting access review campaigns every " + settings.AccessReviews.Interval.String())
+		settings.AccessReviews.Start()
+	}
+
+	settings.RoleGrants.Start()
+
+	if settings.QuotaAlerts != nil {
+		report.Info("checking org quotas every " + settings.QuotaAlerts.Interval.String())
+		settings.QuotaAlerts.Start()
+	}
+
+	if nrLicense := envVars.String(helpers.NewRelicLicenseEnvVar, ""); nrLicense != "" {
+		if err := report.Step("monitoring", func() error {
+			return startMonitoring(nrLicense)
+		}); err != nil {
+			report.Warn("unable to start New Relic monitoring: " + err.Error())
+		}
+	}
 
-	nrLicense := envVars.String(helpers.NewRelicLicenseEnvVar, "")
-	if nrLicense != "" {
-		fmt.Println("starting monitoring...")
-		startMonitoring(nrLicense)
+	// Only the first instance warms the caches, so a deploy doesn't hit the
+	// CF API with the same requests from every instance.
+	if settings.WarmCaches && isFirstInstance() {
+		go controllers.WarmPlatformCache(settings)
 	}
 
-	fmt.Println("starting app now...")
+	if settings.SyntheticUsers {
+		report.Warn("synthetic users are enabled, requests can skip the login")
+	}
+	if settings.MetricsToken == "" && settings.Environment == helpers.ProfileProduction {
+		report.Warn("/metrics is disabled, set METRICS_TOKEN to scrape it")
+	}
 
-	// TODO add better timeout message. By default it will just say "Timeout"
+	report.Ready()
+
+	if err := serve(port, makeServerHandler(router, settings), settings, report); err != nil {
+		report.Warn("server stopped: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+// serve serves the handler on the port, over HTTPS when the dashboard
+// terminates TLS itself. The plain HTTP port of the TLS listener, if any,
+// answers ACME challenges and redirects to HTTPS. All the ports listen on
+// both IPv6 and IPv4, share the caps of the client connections and have the
+// same timeouts.
+func serve(port string, handler http.Handler, settings *helpers.Settings, report *helpers.StartupReport) error {
+	ln, network, err := settings.Connections.Listen(port)
+	if err != nil {
+		return err
+	}
+	if network == "tcp4" {
+		report.Warn("IPv6 is unavailable, only listening on IPv4")
+	}
+	server := settings.Server.Server(handler)
+	listener := settings.TLS
+	if listener == nil {
+		return server.Serve(ln)
+	}
+	config, httpHandler, err := listener.Config()
+	if err != nil {
+		return err
+	}
+	if listener.HTTPPort != "" {
+		go func() {
+			httpLn, _, err := settings.Connections.Listen(listener.HTTPPort)
+			if err == nil {
+				err = settings.Server.Server(httpHandler).Serve(httpLn)
+			}
+			report.Warn("unable to serve plain HTTP on port " + listener.HTTPPort + ": " + err.Error())
+		}()
+	}
+	report.Info("serving HTTPS on port " + port)
+	server.TLSConfig = config
+	return server.ServeTLS(ln, "", "")
+}
+
+// isFirstInstance returns true on the first instance of the app, or when not
+// running on Cloud Foundry.
+func isFirstInstance() bool {
+	index := os.Getenv("CF_INSTANCE_INDEX")
+	return index == "" || index == "0"
+}
+
+// checkUAAClient warns about differences between the configuration and the
+// client registered in UAA, which otherwise only show up as confusing login
+// failures. It never stops the app from starting.
+func checkUAAClient(settings *helpers.Settings, report *helpers.StartupReport) {
+	drift, err := settings.CheckUAAClientDrift()
+	if err != nil {
+		report.Warn("unable to check the UAA client registration: " + err.Error())
+		return
+	}
+	for _, d := range drift {
+		report.Warn("UAA client configuration drift: " + d)
+	}
+}
+
+// makeServerHandler wraps the router with the timeout, session and CSRF
+// handlers. Session-less paths such as /ping and static assets bypass the
+// CSRF protection so they never set cookies, and streams bypass the timeout.
+func makeServerHandler(router http.Handler, settings *helpers.Settings) http.Handler {
+	timeout := &helpers.TimeoutHandler{
+		Handler: context.ClearHandler(router),
+		Timeout: settings.RequestTimeout,
+		Routes:  settings.RouteTimeouts,
+	}
 	protect := csrf.Protect(settings.CSRFKey, csrf.Secure(settings.SecureCookies))
-	http.ListenAndServe(":"+port, protect(
-		http.TimeoutHandler(context.ClearHandler(router), helpers.TimeoutConstant, ""),
-	))
+	protected := protect(timeout)
+	// Streams outlive the timeout, and the timeout handler can't hand their
+	// connections over to websockets.
+	streams := protect(context.ClearHandler(router))
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if controllers.IsSessionlessPath(req.URL.Path) {
+			timeout.ServeHTTP(rw, req)
+			return
+		}
+		if controllers.IsStreamPath(req.URL.Path) {
+			streams.ServeHTTP(rw, req)
+			return
+		}
+		protected.ServeHTTP(rw, req)
+	})
+}
+
+// withProfileDefaults makes an env var set from the lookups, falling back to
+// the defaults of the profile named in the ENVIRONMENT env var (if any).
+func withProfileDefaults(opts []env.VarSetOpt) (*env.VarSet, error) {
+	envVars := env.NewVarSet(opts...)
+	name := envVars.String(helpers.EnvironmentEnvVar, "")
+	if name == "" {
+		return envVars, nil
+	}
+	defaults, err := helpers.ProfileDefaults(name)
+	if err != nil {
+		return nil, err
+	}
+	return env.NewVarSet(append(opts, env.WithMapLookup(defaults))...), nil
+}
+
+// makeEnvVarSetOpts makes the env var lookups from the UPSs named in UPS_NAMES,
+// or from the default UPS.
+func makeEnvVarSetOpts(app *cfenv.App) []env.VarSetOpt {
+	if upsNames := os.Getenv(envUPSNames); upsNames != "" && app != nil {
+		return makeUPSEnvVarSetOpts(app, upsNames)
+	}
+	return makeDefaultEnvVarSetOpts(app)
 }
 
-// makeDefaultEnvVarSet makes an env var set using the hard-coded UPS named
-// defaultUPSName followed by the OS.
-func makeDefaultEnvVarSet(app *cfenv.App) *env.VarSet {
+// makeDefaultEnvVarSetOpts makes the env var lookups using the hard-coded UPS
+// named defaultUPSName followed by the OS.
+func makeDefaultEnvVarSetOpts(app *cfenv.App) []env.VarSetOpt {
 	opts := []env.VarSetOpt{}
 	if app != nil {
 		opts = append(opts, env.WithUPSLookup(app, defaultUPSName))
 	}
-	opts = append(opts, env.WithOSLookup())
-	return env.NewVarSet(opts...)
+	return append(opts, env.WithOSLookup())
 }
 
-// makeUPSEnvVarSet makes an env var set from UPS names in the delimited
-// environment variable upsNames.
-func makeUPSEnvVarSet(app *cfenv.App, upsNames string) *env.VarSet {
+// makeUPSEnvVarSetOpts makes the env var lookups from UPS names in the
+// delimited environment variable upsNames.
+func makeUPSEnvVarSetOpts(app *cfenv.App, upsNames string) []env.VarSetOpt {
 	opts := []env.VarSetOpt{env.WithOSLookup()}
 	for _, name := range strings.Split(upsNames, upsNamesEnvDelimiter) {
 		if name = strings.TrimSpace(name); name != "" {
 			opts = append(opts, env.WithUPSLookup(app, name))
 		}
 	}
-	return env.NewVarSet(opts...)
+	return opts
 }
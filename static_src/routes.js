@@ -144,8 +144,10 @@ export function checkAuth(...args) {
         style: "inline"
       });
 
-      // Redirect the user to the cloud.gov login page
-      return Promise.reject(windowUtil.redirect("/handshake"));
+      // Redirect the user to the cloud.gov login page, and back to this page
+      // after the login
+      const next = encodeURIComponent(windowUtil.currentPath());
+      return Promise.reject(windowUtil.redirect(`/handshake?next=${next}`));
     })
     .then(() => {
       userActions.fetchCurrentUser({ orgGuid, spaceGuid });
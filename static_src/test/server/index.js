@@ -1,3 +1,4 @@
+import fs from "fs";
 import hapi from "hapi";
 import inert from "inert";
 import smocks from "smocks";
@@ -59,6 +60,23 @@ export function start(...args) {
     }
   });
 
+  // render the index template the way the server does for a deployment
+  // without a theme: the test build doesn't hash the assets either
+  server.route({
+    method: "get",
+    path: "/",
+    handler(request, reply) {
+      const index = fs.readFileSync("templates/web/index.html", "utf8");
+      reply(
+        index
+          .replace(/\{\{asset "([^"]+)"\}\}/g, "$1")
+          .replace(/\{\{with \.theme\}\}[\s\S]*?\{\{end\}\}\{\{end\}\}/g, "")
+          .replace(/\{\{with \.theme\.\w+\}\}\{\{\.\}\}\{\{else\}\}(.*?)\{\{end\}\}/g, "$1")
+          .replace(/\{\{\.theme\}\}/g, "{}")
+      );
+    }
+  });
+
   server.route({
     method: "get",
     path: "/{p*}",
@@ -137,6 +137,7 @@ describe("routes", function() {
         next = sandbox.spy(done);
         sandbox.stub(routerActions, "navigate");
         sandbox.stub(windowUtil, "redirect");
+        sandbox.stub(windowUtil, "currentPath").returns("/#/org/org-guid");
         loginActions.fetchStatus.returns(
           Promise.resolve({ status: "unauthorized" })
         );
@@ -148,8 +149,10 @@ describe("routes", function() {
         expect(next).toHaveBeenCalledWith(false);
       });
 
-      it("redirects to /handshake", function() {
-        expect(windowUtil.redirect).toHaveBeenCalledWith("/handshake");
+      it("redirects to /handshake with the page", function() {
+        expect(windowUtil.redirect).toHaveBeenCalledWith(
+          "/handshake?next=%2F%23%2Forg%2Forg-guid"
+        );
       });
 
       it("renders a loader", function() {
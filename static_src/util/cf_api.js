@@ -23,6 +23,10 @@ function CfApiV2Error(response) {
   this.description = description;
   this.response = response;
   this.title = title;
+  // The dashboard's stable code of the error, e.g. quota_exceeded, and the
+  // CF API's own error for more detail.
+  this.dashboardCode = response.dashboard_code;
+  this.raw = response.raw;
 
   this.message = description;
 }
@@ -51,6 +55,16 @@ function handleError(err, errHandler = errorActions.errorFetch) {
   }
 }
 
+// The dashboard's and the CF API's IDs of the request, which support needs to
+// trace a failed action.
+function requestIds(response) {
+  const headers = response.headers || {};
+  return {
+    requestId: headers["x-request-id"],
+    cfRequestId: headers["x-cf-request-id"]
+  };
+}
+
 function parseError(resultOrError) {
   if (resultOrError instanceof Error) {
     // Leave it alone
@@ -65,17 +79,19 @@ function parseError(resultOrError) {
         // V2 api
         const error = new CfApiV2Error(response.data);
         error.response = response;
-        return error;
+        return Object.assign(error, requestIds(response));
       }
     }
 
     // If data is not an object, we're not sure what to do with it.
+    const ids = requestIds(response);
     const error = new Error(
-      `The API returned an unkown error with status ${response.status}.`
+      `The API returned an unkown error with status ${response.status}.` +
+        (ids.requestId ? ` Request ID: ${ids.requestId}.` : "")
     );
     error.response = response;
     error.data = response.data;
-    return error;
+    return Object.assign(error, ids);
   }
 
   const error = new Error("The API returned an unkown error.");
@@ -3,6 +3,12 @@
 const util = {
   redirect(url) {
     window.location = url;
+  },
+
+  // The path of the current page, including the route in the hash.
+  currentPath() {
+    const { pathname, search, hash } = window.location;
+    return `${pathname}${search}${hash}`;
   }
 };
 
@@ -26,6 +26,31 @@ const getSkinEnv = () => {
   return require(mod); // eslint-disable-line import/no-dynamic-require, global-require
 };
 
+// AssetManifestPlugin writes manifest.json, mapping the asset names the index
+// template refers to (bundle.js, style.css) to the hashed file names of the
+// build. The server reads it to serve the hashed assets with long caching.
+class AssetManifestPlugin {
+  apply(compiler) {
+    compiler.plugin("emit", (compilation, callback) => {
+      const manifest = {};
+      compilation.chunks.forEach(chunk => {
+        chunk.files.forEach(file => {
+          const name = file.replace(/\.[0-9a-f]{8,}(\.\w+)$/, "$1");
+          if (!/\.map$/.test(file)) {
+            manifest[name] = file;
+          }
+        });
+      });
+      const json = JSON.stringify(manifest, null, 2);
+      compilation.assets["manifest.json"] = {
+        source: () => json,
+        size: () => json.length
+      };
+      callback();
+    });
+  }
+}
+
 const srcDir = "./static_src";
 const compiledDir = "./static/assets";
 
@@ -36,8 +61,8 @@ const config = {
 
   output: {
     path: path.resolve(compiledDir),
-    filename: "bundle.js",
-    sourceMapFilename: "bundle.js.map"
+    filename: PRODUCTION ? "bundle.[chunkhash].js" : "bundle.js",
+    sourceMapFilename: "[file].map"
   },
 
   devtool: PRODUCTION ? "cheap-source-map" : "eval-source-map",
@@ -88,7 +113,7 @@ const config = {
 
   plugins: [
     new ExtractTextPlugin({
-      filename: "style.css",
+      filename: PRODUCTION ? "style.[contenthash].css" : "style.css",
       disable: false,
       allChunks: true
     }),
@@ -102,6 +127,10 @@ const config = {
   ]
 };
 
+if (PRODUCTION) {
+  config.plugins.push(new AssetManifestPlugin());
+}
+
 if (TEST) {
   config.externals = {
     cheerio: "window",